@@ -1,19 +1,26 @@
 import { Command, Option } from 'commander';
 import Database from 'better-sqlite3';
-import { createLogger } from '../utils/logger';
-import { resolveRepoName, getQueueDbPath } from '../utils/queue_helper';
+import { createLogger, Logger } from '../utils/logger';
+import { resolveRepoName, getQueueDbPaths } from '../utils/queue_helper';
 import fs from 'fs';
 
 async function clearQueue(options?: { repoName?: string }) {
   const repoName = resolveRepoName(options?.repoName);
   const logger = createLogger({ name: repoName, branch: 'unknown' });
-  const dbPath = getQueueDbPath(repoName);
+  const dbPaths = getQueueDbPaths(repoName);
 
-  if (!fs.existsSync(dbPath)) {
+  if (!fs.existsSync(dbPaths[0])) {
     logger.info('Queue database does not exist. Nothing to clear.');
     return;
   }
 
+  // Every shard of a sharded queue is cleared.
+  for (const dbPath of dbPaths) {
+    clearQueueDb(logger, dbPath);
+  }
+}
+
+function clearQueueDb(logger: Logger, dbPath: string) {
   logger.info(`Opening queue database at: ${dbPath}`);
   const db = new Database(dbPath);
 
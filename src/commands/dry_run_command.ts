@@ -0,0 +1,274 @@
+import path from 'path';
+import { Worker } from 'worker_threads';
+import { execFileSync } from 'child_process';
+import { buildChunkDocument, buildLocationDocument, CodeChunk, getChunkDocumentId } from '../utils/elasticsearch';
+import {
+  FileSample,
+  filterReadableFiles,
+  getRepositoryIgnoreFiles,
+  sampleFiles,
+  SkippedFile,
+  walkRepositoryFiles,
+} from '../utils/file_walker';
+import { createLogger, setLogPhase } from '../utils/logger';
+import { ChunkOverflowAction, LanguageParser } from '../utils/parser';
+import { ExtensionMap, logParserSample } from '../utils/extension_map';
+import { ChunkGranularityMap } from '../utils/chunk_granularity';
+import { SymbolKindFilter } from '../utils/symbol_kinds';
+import { ProducerPool } from '../utils/producer_pool';
+import type { ChunkOverflowFile, ParseFailure } from '../utils/progress_reporter';
+import { indexingConfig } from '../config';
+import { MESSAGE_STATUS_FAILURE, MESSAGE_STATUS_SUCCESS } from '../utils/constants';
+import { estimateTokenCount, TokenizerName } from '../utils/tokenizer';
+import { collectSymbols, SymbolExportWriter } from '../utils/symbol_export';
+
+/** Chunks listed in {@link DryRunReport.largestChunks}. */
+export const DRY_RUN_LARGEST_CHUNKS = 10;
+
+/** Kind counted for chunks that are not a parsed definition, such as Markdown sections or text. */
+const NO_KIND = 'none';
+
+export interface DryRunOptions {
+  repoName?: string;
+  branch?: string;
+  /** Worker threads parsing files concurrently. */
+  enqueueConcurrency?: number;
+  /** Parsing workers replaced after a crash or hang before the run fails, see `ProducerPoolOptions.maxRestarts`. */
+  maxWorkerRestarts?: number;
+  languages?: string;
+  extensionMap?: ExtensionMap;
+  ignorePath?: string;
+  excludePatterns?: string[];
+  includePatterns?: string[];
+  useIgnoreFiles?: boolean;
+  chunkOverlapLines?: number;
+  embedDocComments?: boolean;
+  embedContext?: boolean;
+  maxChunkTokens?: number;
+  /** Lines of each chunk stored in its `preview` field (0 disables). */
+  previewLines?: number;
+  /** Tokenizer of the run, see `--tokenizer`. */
+  tokenizer?: TokenizerName;
+  chunkGranularity?: ChunkGranularityMap;
+  /** Symbol kinds to chunk, see `parseSymbolKinds`. */
+  symbolKinds?: SymbolKindFilter;
+  /** Files larger than this many bytes are skipped without being read (default: SCS_IDXR_MAX_FILE_SIZE_BYTES). */
+  maxFileSize?: number;
+  /** Share one chunk document between files with identical chunk content (default: true). */
+  dedup?: boolean;
+  /** Estimate the chunk documents with their content stored compressed (default: false). */
+  storeCompressed?: boolean;
+  /** Estimate the chunk documents without their content, see `--no-store-content` (default: true). */
+  storeContent?: boolean;
+  /** Parse only a sample of the files, as the index run would, see `--limit` and `--sample-rate`. */
+  sample?: FileSample;
+  /** Chunk a file that fails to parse as one whole-file chunk, as the index run would. */
+  wholeFileFallback?: boolean;
+  /** Skip or collapse a file producing more chunks than this, as the index run would. */
+  maxChunksPerFile?: number;
+  chunkOverflow?: ChunkOverflowAction;
+  /** Writes the symbols of every parsed file as they arrive, see `--export-symbols`. */
+  symbolWriter?: SymbolExportWriter;
+}
+
+/** What a full index of one repository would write, as reported by `index --dry-run`. */
+export interface DryRunReport {
+  repo: string;
+  branch: string;
+  /** Files that were parsed successfully. */
+  files: number;
+  /** Chunks produced, one location document each. */
+  chunks: number;
+  /** Distinct chunk contents, one chunk document and one embedding each. */
+  uniqueChunks: number;
+  /** Embedding model tokens of the `semantic_text` of every distinct chunk, counted by the run's tokenizer. */
+  estimatedEmbeddingTokens: number;
+  /** Estimated JSON size in bytes of the chunk and location documents, before vectors and inference. */
+  estimatedDocumentBytes: number;
+  /** Chunks produced per language. */
+  chunksByLanguage: Record<string, number>;
+  /** Chunks produced per kind of definition (e.g. `function_declaration`), `none` for other chunks. */
+  chunksByKind: Record<string, number>;
+  /** The chunks with the most estimated tokens, largest first. */
+  largestChunks: DryRunChunk[];
+  failedFiles: ParseFailure[];
+  /** Files producing more chunks than `--max-chunks-per-file`, which would be skipped or indexed whole. */
+  chunkOverflowFiles: ChunkOverflowFile[];
+  /** Files and directories (ending with `/`) excluded by ignore rules. */
+  ignoredPaths: string[];
+  /** Files larger than the maximum file size or binary, which would not be read. */
+  skippedFiles: SkippedFile[];
+}
+
+/** A chunk listed in {@link DryRunReport.largestChunks}. */
+export interface DryRunChunk {
+  file: string;
+  startLine: number;
+  endLine: number;
+  language: string;
+  kind?: string;
+  /** UTF-8 size of the chunk content. */
+  bytes: number;
+  estimatedTokens: number;
+}
+
+function readGitValue(directory: string, args: string[]): string | null {
+  try {
+    return execFileSync('git', args, { cwd: directory, stdio: ['ignore', 'pipe', 'ignore'] })
+      .toString()
+      .trim();
+  } catch {
+    return null;
+  }
+}
+
+/**
+ * Walks, parses and chunks a repository like a full index, without touching the queue, the
+ * embedding provider or Elasticsearch, and reports what the index would receive.
+ */
+export async function dryRun(directory: string, options: DryRunOptions = {}): Promise<DryRunReport> {
+  const repoName = options.repoName ?? path.basename(path.resolve(directory));
+  const gitBranch = options.branch ?? readGitValue(directory, ['rev-parse', '--abbrev-ref', 'HEAD']) ?? 'unknown';
+  const logger = createLogger({ name: repoName, branch: gitBranch });
+  setLogPhase('enqueue');
+
+  const languageParser = new LanguageParser(options.languages, { extensionMap: options.extensionMap });
+  const gitRoot = readGitValue(directory, ['rev-parse', '--show-toplevel']) ?? path.resolve(directory);
+  const walkResult = walkRepositoryFiles({
+    rootDir: gitRoot,
+    searchDir: directory,
+    includeFile: (file) => languageParser.getLanguageConfigForFile(file) !== undefined,
+    ignoreFiles: getRepositoryIgnoreFiles(gitRoot, {
+      ignorePath: options.ignorePath,
+      useIgnoreFiles: options.useIgnoreFiles,
+    }),
+    excludePatterns: options.excludePatterns,
+    includePatterns: options.includePatterns,
+    useIgnoreFiles: options.useIgnoreFiles,
+    collectIgnoredPaths: true,
+  });
+  const maxFileSize = options.maxFileSize ?? indexingConfig.maxFileSizeBytes;
+  const readable = filterReadableFiles(gitRoot, walkResult.files, maxFileSize);
+  const files = options.sample ? sampleFiles(readable.files, options.sample) : readable.files;
+  if (options.sample) {
+    logger.info(`Sampled ${files.length} of ${readable.files.length} files.`);
+  }
+  logger.info(`Dry run: parsing ${files.length} files without writing to the queue or Elasticsearch.`);
+  logParserSample(files, (file) => languageParser.getLanguageConfigForFile(file), logger);
+
+  const report: DryRunReport = {
+    repo: repoName,
+    branch: gitBranch,
+    files: 0,
+    chunks: 0,
+    uniqueChunks: 0,
+    estimatedEmbeddingTokens: 0,
+    estimatedDocumentBytes: 0,
+    chunksByLanguage: {},
+    chunksByKind: {},
+    largestChunks: [],
+    failedFiles: [],
+    chunkOverflowFiles: [],
+    ignoredPaths: walkResult.ignoredPaths ?? [],
+    skippedFiles: readable.skipped,
+  };
+  const now = new Date().toISOString();
+  const chunkIds = new Set<string>();
+  const documentBytes = (doc: Record<string, unknown>) => Buffer.byteLength(JSON.stringify(doc));
+  const record = (chunk: CodeChunk) => {
+    report.chunks++;
+    report.chunksByLanguage[chunk.language] = (report.chunksByLanguage[chunk.language] ?? 0) + 1;
+    const kind = chunk.kind ?? NO_KIND;
+    report.chunksByKind[kind] = (report.chunksByKind[kind] ?? 0) + 1;
+    recordLargest(report.largestChunks, chunk);
+    const chunkId = getChunkDocumentId(chunk, { dedup: options.dedup });
+    report.estimatedDocumentBytes += documentBytes(buildLocationDocument(chunk, chunkId, now));
+    if (!chunkIds.has(chunkId)) {
+      chunkIds.add(chunkId);
+      report.estimatedEmbeddingTokens += chunk.token_count ?? estimateTokenCount(chunk.semantic_text);
+      report.estimatedDocumentBytes += documentBytes(
+        buildChunkDocument(chunk, now, undefined, {
+          storeCompressed: options.storeCompressed,
+          storeContent: options.storeContent,
+        })
+      );
+    }
+  };
+
+  const commitHash = readGitValue(directory, ['rev-parse', 'HEAD']);
+  const producerWorkerPath = path.join(__dirname, '..', '..', 'dist', 'utils', 'producer_worker.js');
+  const producerPool = new ProducerPool({
+    size: options.enqueueConcurrency ?? 1,
+    highWaterMark: indexingConfig.enqueueHighWaterMark,
+    maxRestarts: options.maxWorkerRestarts,
+    heartbeatTimeoutMs: indexingConfig.workerHeartbeatTimeoutMs,
+    createWorker: () =>
+      new Worker(producerWorkerPath, {
+        workerData: {
+          repoName,
+          gitBranch,
+          languages: options.languages,
+          chunkOverlapLines: options.chunkOverlapLines,
+          embedDocComments: options.embedDocComments,
+          embedContext: options.embedContext,
+          maxChunkTokens: options.maxChunkTokens,
+          previewLines: options.previewLines,
+          tokenizer: options.tokenizer,
+          extensionMap: options.extensionMap,
+          chunkGranularity: options.chunkGranularity,
+          symbolKinds: options.symbolKinds,
+          wholeFileFallback: options.wholeFileFallback,
+          maxChunksPerFile: options.maxChunksPerFile,
+          chunkOverflow: options.chunkOverflow,
+          repoRoot: gitRoot,
+          commitSha: commitHash ?? undefined,
+        },
+      }),
+  });
+
+  await producerPool.run(
+    files,
+    (file) => ({ filePath: path.resolve(gitRoot, file), gitBranch, relativePath: file }),
+    async (file, message) => {
+      if (message.status === MESSAGE_STATUS_SUCCESS) {
+        report.files++;
+        (message.data ?? []).forEach(record);
+        if (message.parseError !== undefined) {
+          report.failedFiles.push({ file, error: message.parseError, wholeFile: true });
+        }
+        if (message.chunkOverflow) {
+          report.chunkOverflowFiles.push({ file, ...message.chunkOverflow });
+        }
+        await options.symbolWriter?.write(collectSymbols(message.data ?? [], repoName, { dedup: options.dedup }));
+      } else if (message.status === MESSAGE_STATUS_FAILURE) {
+        report.failedFiles.push({ file, error: message.error ?? 'unknown error' });
+      }
+    },
+    (file, error) => {
+      report.failedFiles.push({ file, error: error instanceof Error ? error.message : String(error) });
+    }
+  );
+
+  report.uniqueChunks = chunkIds.size;
+  return report;
+}
+
+/** Keeps `largest` sorted and at most {@link DRY_RUN_LARGEST_CHUNKS} long while adding `chunk`. */
+function recordLargest(largest: DryRunChunk[], chunk: CodeChunk): void {
+  const estimatedTokens = estimateTokenCount(chunk.content);
+  if (largest.length >= DRY_RUN_LARGEST_CHUNKS && estimatedTokens <= largest[largest.length - 1].estimatedTokens) {
+    return;
+  }
+  const entry: DryRunChunk = {
+    file: chunk.filePath,
+    startLine: chunk.startLine,
+    endLine: chunk.endLine,
+    language: chunk.language,
+    ...(chunk.kind ? { kind: chunk.kind } : {}),
+    bytes: Buffer.byteLength(chunk.content),
+    estimatedTokens,
+  };
+  const at = largest.findIndex((other) => other.estimatedTokens < estimatedTokens);
+  largest.splice(at === -1 ? largest.length : at, 0, entry);
+  largest.length = Math.min(largest.length, DRY_RUN_LARGEST_CHUNKS);
+}
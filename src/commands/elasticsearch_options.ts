@@ -0,0 +1,72 @@
+import fs from 'fs';
+import { Command, Option } from 'commander';
+import { elasticsearchConfig } from '../config';
+import { setClient } from '../utils/elasticsearch';
+import { addLogSecret } from '../utils/logger';
+
+/** Elasticsearch client flags, shared by every command. Each overrides its environment variable. */
+export interface ElasticsearchOptions {
+  esNode?: string;
+  esApiKey?: string;
+  esCloudId?: string;
+  esCaCert?: string;
+  esInsecure?: boolean;
+}
+
+/** Adds the Elasticsearch client flags to `command`, see {@link applyElasticsearchOptions}. */
+export function addElasticsearchOptions(command: Command): Command {
+  return command
+    .addOption(new Option('--es-node <url>', 'Elasticsearch URL (overrides ELASTICSEARCH_ENDPOINT)'))
+    .addOption(new Option('--es-api-key <key>', 'Elasticsearch API key (overrides ELASTICSEARCH_API_KEY)'))
+    .addOption(new Option('--es-cloud-id <id>', 'Elastic Cloud ID (overrides ELASTICSEARCH_CLOUD_ID)'))
+    .addOption(
+      new Option(
+        '--es-ca-cert <path>',
+        'PEM file with the CA certificate of a cluster with a private CA (overrides ELASTICSEARCH_CA_CERT)'
+      )
+    )
+    .addOption(new Option('--es-insecure', 'Do not verify the TLS certificate of Elasticsearch (development only)'));
+}
+
+/**
+ * Validates the Elasticsearch client flags and applies them to `elasticsearchConfig`, so the client
+ * is built with them. `--es-node` and `--es-cloud-id` each replace the other's environment variable.
+ * The API key is redacted from every log line.
+ */
+export function applyElasticsearchOptions(options: ElasticsearchOptions): void {
+  if (options.esNode !== undefined && options.esCloudId !== undefined) {
+    throw new Error('--es-node and --es-cloud-id cannot be used together.');
+  }
+  if (options.esNode !== undefined) {
+    let protocol: string | undefined;
+    try {
+      protocol = new URL(options.esNode).protocol;
+    } catch {
+      protocol = undefined;
+    }
+    if (protocol !== 'http:' && protocol !== 'https:') {
+      throw new Error(`Invalid --es-node value: ${options.esNode}. Expected an http or https URL.`);
+    }
+    elasticsearchConfig.endpoint = options.esNode;
+    elasticsearchConfig.cloudId = undefined;
+  }
+  if (options.esCloudId !== undefined) {
+    elasticsearchConfig.cloudId = options.esCloudId;
+    elasticsearchConfig.endpoint = undefined;
+  }
+  if (options.esApiKey !== undefined) {
+    addLogSecret(options.esApiKey);
+    elasticsearchConfig.apiKey = options.esApiKey;
+  }
+  if (options.esCaCert !== undefined) {
+    if (!fs.existsSync(options.esCaCert)) {
+      throw new Error(`Invalid --es-ca-cert value: ${options.esCaCert}. The file does not exist.`);
+    }
+    elasticsearchConfig.caCert = options.esCaCert;
+  }
+  if (options.esInsecure) {
+    elasticsearchConfig.insecure = true;
+  }
+  // A client created before the flags were parsed would use the environment only.
+  setClient(undefined);
+}
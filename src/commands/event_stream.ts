@@ -0,0 +1,415 @@
+import { execFileSync } from 'child_process';
+import http from 'http';
+import { AddressInfo } from 'net';
+import path from 'path';
+import readline from 'readline';
+import { FileChangesResult, IncrementalIndexOptions, indexFileChanges } from './incremental_index_command';
+import { classifyChangedPaths, isIgnoreFile } from './watch_files';
+import { createSettingsIndex, updateLastIndexedCommit } from '../utils/elasticsearch';
+import { createPathFilter, getRepositoryIgnoreFiles, walkRepositoryFiles } from '../utils/file_walker';
+import { pullRepo } from '../utils/git_helper';
+import { isShutdownRequested } from '../utils/graceful_shutdown';
+import { createLogger } from '../utils/logger';
+import { loadManifest, writeManifest } from '../utils/manifest';
+import { createMetrics } from '../utils/metrics';
+import { LanguageParser } from '../utils/parser';
+import { openQueue } from '../utils/queue_shards';
+
+/** Host the event listener binds to when `--events` only gives a port. */
+export const DEFAULT_EVENTS_HOST = '127.0.0.1';
+
+/** Largest request body the event listener reads. */
+const MAX_EVENT_BODY_BYTES = 16 * 1024 * 1024;
+
+/** Changed paths of one push, relative to the repository root, as read from the event stream. */
+export interface ChangeEvent {
+  /** Commit the push moved the branch to. */
+  commit?: string;
+  added: string[];
+  modified: string[];
+  deleted: string[];
+}
+
+/** Where `--events` reads change events from. */
+export type EventSource = { type: 'stdin' } | { type: 'http'; host: string; port: number };
+
+/** Parses `--events`: `-` for stdin, or a port or `host:port` to listen for HTTP POSTs on. */
+export function parseEventSource(value: string): EventSource {
+  if (value === '-') {
+    return { type: 'stdin' };
+  }
+  const match = /^(?:(.+):)?(\d+)$/.exec(value.trim());
+  const port = match ? Number(match[2]) : NaN;
+  if (!match || port > 65535) {
+    throw new Error(`Invalid --events value: ${value}. Must be - for stdin, or a port or host:port to listen on.`);
+  }
+  return { type: 'http', host: match[1] ?? DEFAULT_EVENTS_HOST, port };
+}
+
+function normalizeEventPath(value: string): string {
+  const normalized = path.posix.normalize(value.replace(/\\/g, '/')).replace(/\/$/, '');
+  if (path.posix.isAbsolute(normalized) || normalized === '..' || normalized.startsWith('../')) {
+    throw new Error(`Invalid change event: ${value} is not a path inside the repository.`);
+  }
+  return normalized;
+}
+
+/**
+ * Parses one line of the event stream, e.g.
+ * `{"commit":"3f1c…","added":["src/new.ts"],"modified":["src/app.ts"],"deleted":["src/old.ts"]}`.
+ * Every field is optional.
+ */
+export function parseChangeEvent(line: string): ChangeEvent {
+  let value: unknown;
+  try {
+    value = JSON.parse(line);
+  } catch {
+    throw new Error('Invalid change event: not valid JSON.');
+  }
+  if (typeof value !== 'object' || value === null || Array.isArray(value)) {
+    throw new Error('Invalid change event: must be a JSON object.');
+  }
+  const record = value as Record<string, unknown>;
+  if (record.commit !== undefined && (typeof record.commit !== 'string' || record.commit.length === 0)) {
+    throw new Error('Invalid change event: commit must be a non-empty string.');
+  }
+  const paths = (field: 'added' | 'modified' | 'deleted'): string[] => {
+    const list = record[field] ?? [];
+    if (!Array.isArray(list) || list.some((item) => typeof item !== 'string' || item.length === 0)) {
+      throw new Error(`Invalid change event: ${field} must be an array of paths.`);
+    }
+    return list.map(normalizeEventPath);
+  };
+  return {
+    ...(record.commit !== undefined ? { commit: record.commit as string } : {}),
+    added: paths('added'),
+    modified: paths('modified'),
+    deleted: paths('deleted'),
+  };
+}
+
+/** Change events in arrival order, read by one consumer. */
+export class ChangeEventQueue {
+  private readonly events: ChangeEvent[] = [];
+  private waiter: (() => void) | undefined;
+  private closed = false;
+
+  get length(): number {
+    return this.events.length;
+  }
+
+  push(event: ChangeEvent): void {
+    if (!this.closed) {
+      this.events.push(event);
+      this.wake();
+    }
+  }
+
+  /** Stops accepting events; the ones already queued are still returned by {@link next}. */
+  close(): void {
+    this.closed = true;
+    this.wake();
+  }
+
+  /** Resolves with the next event, or with undefined once the queue is closed and empty. */
+  async next(): Promise<ChangeEvent | undefined> {
+    while (this.events.length === 0 && !this.closed) {
+      await new Promise<void>((resolve) => {
+        this.waiter = resolve;
+      });
+    }
+    return this.events.shift();
+  }
+
+  private wake(): void {
+    const waiter = this.waiter;
+    this.waiter = undefined;
+    waiter?.();
+  }
+}
+
+type Logger = ReturnType<typeof createLogger>;
+
+/**
+ * Queues the events of a newline-delimited JSON stream, skipping blank lines and logging invalid
+ * ones. The queue is closed when the stream ends.
+ */
+export function readEventStream(input: NodeJS.ReadableStream, events: ChangeEventQueue, logger: Logger): void {
+  const lines = readline.createInterface({ input, crlfDelay: Infinity });
+  let lineNumber = 0;
+  lines.on('line', (line) => {
+    lineNumber++;
+    if (line.trim().length === 0) {
+      return;
+    }
+    try {
+      events.push(parseChangeEvent(line));
+    } catch (error) {
+      logger.warn(`Skipping line ${lineNumber} of the event stream: ${(error as Error).message}`);
+    }
+  });
+  lines.on('close', () => events.close());
+}
+
+/**
+ * Serves `POST` requests whose body holds one or more newline-delimited change events. A request is
+ * answered with 202 once its events are queued, or with 400 and none of them queued when one is
+ * invalid.
+ */
+export async function listenForEvents(
+  source: { host: string; port: number },
+  events: ChangeEventQueue,
+  logger: Logger
+): Promise<http.Server> {
+  const server = http.createServer((request, response) => {
+    const respond = (status: number, body: Record<string, unknown>) => {
+      response.writeHead(status, { 'Content-Type': 'application/json' });
+      response.end(JSON.stringify(body));
+    };
+    if (request.method !== 'POST') {
+      response.setHeader('Allow', 'POST');
+      respond(405, { error: 'Events must be sent with POST.' });
+      return;
+    }
+    const body: Buffer[] = [];
+    let size = 0;
+    request.on('data', (chunk: Buffer) => {
+      size += chunk.length;
+      if (size > MAX_EVENT_BODY_BYTES) {
+        if (!response.headersSent) {
+          respond(413, { error: `Request body exceeds ${MAX_EVENT_BODY_BYTES} bytes.` });
+        }
+        request.destroy();
+        return;
+      }
+      body.push(chunk);
+    });
+    request.on('end', () => {
+      let received: ChangeEvent[];
+      try {
+        received = Buffer.concat(body)
+          .toString('utf8')
+          .split('\n')
+          .filter((line) => line.trim().length > 0)
+          .map(parseChangeEvent);
+      } catch (error) {
+        respond(400, { error: (error as Error).message });
+        return;
+      }
+      received.forEach((event) => events.push(event));
+      logger.info(`Received ${received.length} change events`, { queued: events.length });
+      respond(202, { accepted: received.length, queued: events.length });
+    });
+  });
+  await new Promise<void>((resolve, reject) => {
+    server.once('error', reject);
+    server.listen(source.port, source.host, () => resolve());
+  });
+  return server;
+}
+
+function readGitValue(directory: string, args: string[]): string | null {
+  try {
+    return execFileSync('git', args, { cwd: directory, stdio: ['ignore', 'pipe', 'ignore'] })
+      .toString()
+      .trim();
+  } catch {
+    return null;
+  }
+}
+
+export interface EventStreamOptions extends IncrementalIndexOptions {
+  source: EventSource;
+  /** Pull the checkout before applying an event for a commit it is not at. */
+  pull?: boolean;
+  githubToken?: string;
+  /** Stream read for the `stdin` source (default: `process.stdin`). */
+  input?: NodeJS.ReadableStream;
+  /** Called once the event listener is bound, with its port. */
+  onListening?: (port: number) => void;
+}
+
+/** Events applied by {@link consumeChangeEvents}. */
+export interface EventStreamSummary {
+  applied: number;
+  failed: number;
+  /** Events received but not applied because of a shutdown. */
+  dropped: number;
+}
+
+/**
+ * Applies change events to a repository in the order they arrive, through the same enqueue path
+ * as an incremental run: deleted files lose their documents, added and modified files are parsed
+ * and enqueued, and the stale locations of modified files are pruned. `indexQueue` then indexes
+ * what the event enqueued, and the next event waits until it returns, so a later push never races
+ * an earlier one. Files are read from the checkout; with `pull`, it is pulled first when it is not
+ * at the event's commit. Once an event is indexed, the commit of the checkout becomes the last
+ * indexed commit, unless the checkout is at another commit than the event's.
+ *
+ * Returns when the stdin stream ends, or on shutdown.
+ */
+export async function consumeChangeEvents(
+  directory: string,
+  options: EventStreamOptions,
+  indexQueue: () => Promise<void>
+): Promise<EventStreamSummary> {
+  const repoName = options.repoName ?? path.basename(path.resolve(directory));
+  const gitBranch = options.branch ?? readGitValue(directory, ['rev-parse', '--abbrev-ref', 'HEAD']) ?? 'unknown';
+  const logger = createLogger({ name: repoName, branch: gitBranch });
+  const metrics = createMetrics({ name: repoName, branch: gitBranch });
+
+  const gitRoot = readGitValue(directory, ['rev-parse', '--show-toplevel']) ?? path.resolve(directory);
+  const languageParser = new LanguageParser(options.languages, { extensionMap: options.extensionMap });
+  const isSupported = (file: string) => languageParser.getLanguageConfigForFile(file) !== undefined;
+  const ignoreFiles = getRepositoryIgnoreFiles(gitRoot, {
+    ignorePath: options.ignorePath,
+    useIgnoreFiles: options.useIgnoreFiles,
+  });
+  const loadPathFilter = () =>
+    createPathFilter({
+      rootDir: gitRoot,
+      ignoreFiles,
+      excludePatterns: options.excludePatterns,
+      includePatterns: options.includePatterns,
+      useIgnoreFiles: options.useIgnoreFiles,
+    });
+  let isExcluded = loadPathFilter();
+
+  const queue = await openQueue(options.queueDir, { repoName, branch: gitBranch, shards: options.queueShards });
+  const events = new ChangeEventQueue();
+  let server: http.Server | undefined;
+  if (options.source.type === 'http') {
+    server = await listenForEvents(options.source, events, logger);
+    const { port } = server.address() as AddressInfo;
+    options.onListening?.(port);
+    logger.info(`Listening for change events on http://${options.source.host}:${port}`);
+  } else {
+    readEventStream(options.input ?? process.stdin, events, logger);
+    logger.info('Reading change events from stdin');
+  }
+  // The queue is closed on shutdown, so a consumer waiting for the next event returns.
+  const shutdownPoll = setInterval(() => {
+    if (isShutdownRequested()) {
+      events.close();
+    }
+  }, 250);
+  shutdownPoll.unref();
+
+  const applyEvent = async (
+    event: ChangeEvent,
+    number: number
+  ): Promise<{ result: FileChangesResult; head: string | null }> => {
+    let head = readGitValue(directory, ['rev-parse', 'HEAD']);
+    if (event.commit && head && !head.startsWith(event.commit) && options.pull) {
+      await pullRepo(directory, options.branch, options.githubToken);
+      head = readGitValue(directory, ['rev-parse', 'HEAD']);
+    }
+    if (event.commit && head && !head.startsWith(event.commit)) {
+      logger.warn(
+        `The checkout is at ${head}, not at commit ${event.commit} of event ${number}; its files are read as they are.`
+      );
+    }
+
+    const changedPaths = [...event.added, ...event.modified, ...event.deleted];
+    if (changedPaths.some(isIgnoreFile)) {
+      logger.info('Ignore rules changed, reloading them.');
+      isExcluded = loadPathFilter();
+    }
+    // The files on disk decide, as for a file watcher: a path listed as deleted that still exists is
+    // re-indexed, and one listed as added that is gone is deleted.
+    const changes = classifyChangedPaths(
+      changedPaths.filter((changedPath) => !isIgnoreFile(changedPath)),
+      {
+        gitRoot,
+        indexedFiles: new Set(queue.getFileHashes().keys()),
+        isSupported,
+        isExcluded: (relativePath, isDirectory) => isExcluded(relativePath, isDirectory),
+        walkDirectory: (relativeDir) =>
+          walkRepositoryFiles({
+            rootDir: gitRoot,
+            searchDir: path.join(gitRoot, relativeDir),
+            includeFile: isSupported,
+            ignoreFiles,
+            excludePatterns: options.excludePatterns,
+            includePatterns: options.includePatterns,
+            useIgnoreFiles: options.useIgnoreFiles,
+          }).files,
+      }
+    );
+    const manifest = options.manifestPath ? loadManifest(options.manifestPath, repoName, gitBranch) : undefined;
+    const result = await indexFileChanges(
+      changes,
+      { gitRoot, gitBranch, repoName, commitHash: head ?? undefined, queue, languageParser, logger, metrics, manifest },
+      options
+    );
+    if (manifest && options.manifestPath && !result.interrupted) {
+      writeManifest(options.manifestPath, manifest);
+    }
+    return { result, head };
+  };
+
+  // The next run diffs from this commit, instead of re-indexing the files of every applied event.
+  const saveIndexedCommit = async (event: ChangeEvent, head: string | null): Promise<void> => {
+    if (!head || (event.commit && !head.startsWith(event.commit))) {
+      return;
+    }
+    try {
+      await createSettingsIndex(options.elasticsearchIndex);
+      await updateLastIndexedCommit(gitBranch, head, options.elasticsearchIndex, repoName);
+    } catch (error) {
+      logger.warn(`Failed to update last indexed commit: ${error instanceof Error ? error.message : error}`);
+    }
+  };
+
+  const summary: EventStreamSummary = { applied: 0, failed: 0, dropped: 0 };
+  try {
+    for (let event = await events.next(); event; event = await events.next()) {
+      if (isShutdownRequested()) {
+        summary.dropped = events.length + 1;
+        break;
+      }
+      const number = summary.applied + summary.failed + 1;
+      const startedAt = Date.now();
+      try {
+        const { result, head } = await applyEvent(event, number);
+        const enqueuedAt = Date.now();
+        await indexQueue();
+        const finishedAt = Date.now();
+        summary.applied++;
+        if (!result.interrupted) {
+          await saveIndexedCommit(event, head);
+        }
+        logger.info(
+          `Applied event ${number}${event.commit ? ` (${event.commit})` : ''}: ${result.filesToIndex.length} ` +
+            `files indexed and ${result.filesToDelete.length} deleted in ${finishedAt - startedAt} ms`,
+          {
+            event: number,
+            commit: event.commit,
+            filesIndexed: result.filesToIndex.length,
+            filesDeleted: result.filesToDelete.length,
+            enqueueMs: enqueuedAt - startedAt,
+            indexMs: finishedAt - enqueuedAt,
+            totalMs: finishedAt - startedAt,
+            queued: events.length,
+          }
+        );
+      } catch (error) {
+        // The files of a failed event are picked up by the next incremental run, by their content hash.
+        summary.failed++;
+        logger.error(`Failed to apply event ${number}${event.commit ? ` (${event.commit})` : ''}`, {
+          error: error instanceof Error ? error.message : String(error),
+        });
+      }
+    }
+  } finally {
+    clearInterval(shutdownPoll);
+    await new Promise<void>((resolve) => (server ? server.close(() => resolve()) : resolve()));
+    queue.close();
+  }
+
+  if (summary.dropped > 0) {
+    logger.warn(`Shutdown requested, ${summary.dropped} received change events were not applied.`);
+  }
+  logger.info(`Stopped reading change events: ${summary.applied} applied, ${summary.failed} failed.`);
+  return summary;
+}
@@ -0,0 +1,30 @@
+import { Command, Option } from 'commander';
+import { indexRepos } from './index_command';
+import { parseEventSource } from './event_stream';
+import { addIndexOptions } from './index_options';
+import { logger } from '../utils/logger';
+import { shutdown } from '../utils/otel_provider';
+
+export const eventsCommand = new Command('index:events')
+  .description('Index a local repository, then apply the changed-file events of pushes as they arrive')
+  .argument('<repo>', 'Repository path (format: path[:index])')
+  .addOption(
+    new Option(
+      '--events <source>',
+      'Where events are read from: - for newline-delimited JSON on stdin, or a port or host:port to listen on'
+    ).default('-')
+  )
+  .addOption(new Option('--index <name>', 'Elasticsearch index to write to (overrides :index)'))
+  .addOption(new Option('--pull', 'Git pull before the initial run, and before an event for a commit not checked out'));
+
+addIndexOptions(eventsCommand).action(async (repo, options) => {
+  try {
+    const events = parseEventSource(options.events);
+    // The initial run is the one `index` makes: incremental when the repository was indexed before.
+    await indexRepos([repo], { ...options, events });
+  } catch (error) {
+    logger.error('Fatal error in index:events command', { error });
+    await shutdown();
+    throw error;
+  }
+});
@@ -0,0 +1,42 @@
+import { Command, Option } from 'commander';
+import { createLogger } from '../utils/logger';
+import { resolveRepoName, getQueueDbPath, getQueueDbPaths, openExistingQueue } from '../utils/queue_helper';
+import { exportQueue, getExportFormat } from '../utils/queue_export';
+
+export const exportQueueCommand = new Command('queue:export')
+  .description('Write the state of a queue, without any source content, to a file for offline inspection.')
+  .argument('<file>', 'Output file: JSON when it ends in .json, NDJSON otherwise')
+  .addOption(new Option('--repo-name <repoName>', 'Repository name (auto-detects if only one repo exists)'))
+  .addOption(new Option('--redact-paths', 'Replace the directories of file paths with hashes of them'))
+  .action(async (file: string, options) => {
+    const repoName = resolveRepoName(options.repoName);
+    const logger = createLogger({ name: repoName, branch: 'unknown' });
+    const dbPath = getQueueDbPath(repoName);
+
+    logger.info(`Connecting to queue database at: ${dbPath}`);
+
+    try {
+      const shards = getQueueDbPaths(repoName).length;
+      if (shards > 1) {
+        throw new Error(`The queue has ${shards} shards; queue:export only supports a queue with one.`);
+      }
+      const queue = await openExistingQueue(repoName);
+      let counts;
+      try {
+        counts = exportQueue(queue, file, { repoName, redactPaths: options.redactPaths ?? false });
+      } finally {
+        queue.close();
+      }
+
+      logger.info(
+        `Exported ${counts.queue} queued and ${counts.dead_letter} dead-lettered documents, ` +
+          `${counts.indexed_files} indexed files and ${counts.queue_metadata} metadata entries ` +
+          `to ${file} (${getExportFormat(file)}).`,
+        { counts }
+      );
+    } catch (error) {
+      logger.error(`Failed to export the database at ${dbPath} to ${file}.`, { error });
+      logger.error('Please ensure the --repo-name is correct and the database file exists.');
+      process.exit(1);
+    }
+  });
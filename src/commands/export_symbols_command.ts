@@ -0,0 +1,22 @@
+import { Command, Option } from 'commander';
+import { indexRepos } from './index_command';
+import { addIndexOptions } from './index_options';
+import { logger } from '../utils/logger';
+import { shutdown } from '../utils/otel_provider';
+
+export const exportSymbolsCommand = new Command('export-symbols')
+  .description('Parse repositories and write their symbols to a JSON Lines file, without indexing anything')
+  .argument('<repos...>', 'Repository names, paths, or URLs')
+  .addOption(new Option('--out <file>', 'JSON Lines file to write the symbols to (required)').makeOptionMandatory());
+
+addIndexOptions(exportSymbolsCommand).action(async (repos, options) => {
+  try {
+    // A dry run of `index`, so symbols are parsed and chunked as they would be indexed.
+    const { out, ...indexOptions } = options;
+    await indexRepos(repos, { ...indexOptions, dryRun: true, exportSymbols: out });
+  } catch (error) {
+    logger.error('Fatal error in export-symbols command', { error });
+    await shutdown();
+    throw error;
+  }
+});
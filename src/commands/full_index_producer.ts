@@ -1,22 +1,56 @@
-import { glob } from 'glob';
-import {
-  createIndex,
-  createLocationsIndex,
-  createSettingsIndex,
-  deleteIndex,
-  deleteLocationsIndex,
-} from '../utils/elasticsearch';
-import { LanguageParser } from '../utils/parser';
+import { createIndex, createLocationsIndex } from '../utils/elasticsearch';
+import { ChunkOverflowAction, LanguageParser } from '../utils/parser';
+import { ExtensionMap, logParserSample } from '../utils/extension_map';
+import { ChunkGranularityMap } from '../utils/chunk_granularity';
+import { SymbolKindFilter } from '../utils/symbol_kinds';
+import { LargestChunks, TokenizerName } from '../utils/tokenizer';
 import path from 'path';
+import fs from 'fs';
 import { Worker } from 'worker_threads';
-import PQueue from 'p-queue';
 import { execFileSync } from 'child_process';
-import fs from 'fs';
-import ignore from 'ignore';
-import { createLogger } from '../utils/logger';
-import { IQueueWithEnqueueMetadata } from '../utils/queue';
-import { SqliteQueue } from '../utils/sqlite_queue';
-import { createMetrics, createAttributes } from '../utils/metrics';
+import {
+  createPathFilter,
+  FileSample,
+  filterReadableFiles,
+  getRepositoryIgnoreFiles,
+  logSkippedFiles,
+  resolveListedFiles,
+  sampleFiles,
+  SkippedFile,
+  walkRepositoryFiles,
+  WalkResult,
+} from '../utils/file_walker';
+import {
+  createManifest,
+  hashContent,
+  loadManifest,
+  Manifest,
+  readContentHash,
+  recordManifestEntry,
+  writeManifest,
+} from '../utils/manifest';
+import { createLogger, setLogPhase } from '../utils/logger';
+import { decodeText } from '../utils/file_encoding';
+import {
+  chunkContentBytes,
+  formatParseFailures,
+  groupParseFailures,
+  ParseFailure,
+  ProgressReporter,
+} from '../utils/progress_reporter';
+import { EnqueuedFile, IQueueWithEnqueueMetadata } from '../utils/queue';
+import { openQueue } from '../utils/queue_shards';
+import { ProducerMessage, ProducerPool, ProducerRequest } from '../utils/producer_pool';
+import {
+  findArchiveRoot,
+  getArchiveBaseName,
+  normalizeArchivePath,
+  readGitArchiveEntries,
+  readTarEntries,
+  ReadTarOptions,
+} from '../utils/tar_reader';
+import { indexingConfig } from '../config';
+import { createMetrics, createAttributes, Metrics } from '../utils/metrics';
 import {
   MESSAGE_STATUS_SUCCESS,
   MESSAGE_STATUS_FAILURE,
@@ -27,234 +61,711 @@ import {
 
 export interface IndexOptions {
   queueDir: string;
+  /** Database files the queue is split into by file path, see `--queue-shards` (default: 1). */
+  queueShards?: number;
   elasticsearchIndex: string;
   repoName?: string;
+  /** Stamped on every chunk and location as `repo_url`. */
+  repoUrl?: string;
+  /** Stamped on every location as `repo_ref`. */
+  repoRef?: string;
   branch?: string;
-  parseConcurrency?: number;
+  /** Worker threads parsing files concurrently during enqueue. */
+  enqueueConcurrency?: number;
+  /** Parsing workers replaced after a crash or hang before the run fails, see `ProducerPoolOptions.maxRestarts`. */
+  maxWorkerRestarts?: number;
   languages?: string;
+  /** Routes file suffixes to languages or skips them, see `loadExtensionMap`. */
+  extensionMap?: ExtensionMap;
+  /** Extra gitignore-style file applied at the repository root. */
+  ignorePath?: string;
+  /** Glob patterns (gitignore syntax) that always exclude matching paths. */
+  excludePatterns?: string[];
+  /** Glob patterns (gitignore syntax) of the only files indexed, see `WalkOptions.includePatterns`. */
+  includePatterns?: string[];
+  /** Set to false to disable `.gitignore`, `.codesearchignore` and `.indexerignore` handling. */
+  useIgnoreFiles?: boolean;
+  /** When set, a manifest of enqueued files is written to this path. */
+  manifestPath?: string;
+  /** Lines of trailing sibling context stored in each tree-sitter chunk's `overlap` field. */
+  chunkOverlapLines?: number;
+  /** Prepend leading doc comments to each chunk's `semantic_text`. */
+  embedDocComments?: boolean;
+  /** Prepend each chunk's package, receiver type and file imports to its `semantic_text`. */
+  embedContext?: boolean;
+  /** Split tree-sitter chunks whose token count exceeds this into parts (0 disables). */
+  maxChunkTokens?: number;
+  /** Lines of each chunk stored in its `preview` field (0 disables). */
+  previewLines?: number;
+  /** Counts the tokens of each chunk for `maxChunkTokens` and `token_count`, see `--tokenizer`. */
+  tokenizer?: TokenizerName;
+  /** Chunk granularity per language, see `parseChunkGranularity`. */
+  chunkGranularity?: ChunkGranularityMap;
+  /** Symbol kinds to chunk, see `parseSymbolKinds`. */
+  symbolKinds?: SymbolKindFilter;
+  /** Files larger than this many bytes are skipped without being read (default: SCS_IDXR_MAX_FILE_SIZE_BYTES). */
+  maxFileSize?: number;
+  /** Receives per-file enqueue progress. */
+  progress?: ProgressReporter;
+  /**
+   * Continue an interrupted enqueue: files already recorded in the queue with an unchanged
+   * modification time are skipped instead of being parsed and enqueued again.
+   */
+  resume?: boolean;
+  /** Parse and enqueue every file, even when its content hash matches the last indexed one. */
+  force?: boolean;
+  /** `code_vector` dimensions of the embedding provider; an existing index with other dimensions is rejected. */
+  vectorDims?: number;
+  /**
+   * Files to index instead of walking the directory, relative to it or absolute, e.g. read with
+   * `readFileList`. Per-directory ignore files are not applied to them.
+   */
+  files?: string[];
+  /** Index only a sample of the files left by the ignore rules, see `--limit` and `--sample-rate`. */
+  sample?: FileSample;
+  /** Index a file that fails to parse as one whole-file chunk instead of leaving it out. */
+  wholeFileFallback?: boolean;
+  /** Skip or collapse a file producing more chunks than this, see `--max-chunks-per-file`. No limit when omitted. */
+  maxChunksPerFile?: number;
+  /** What happens to a file over `maxChunksPerFile`. Defaults to `skip`. */
+  chunkOverflow?: ChunkOverflowAction;
+  /** Stamp each chunk with the date and author of the last commit changing its lines, see `--with-blame`. */
+  withBlame?: boolean;
+  /** Fail the enqueue once every file was parsed when any of them failed, see {@link checkParseFailures}. */
+  failOnParseError?: boolean;
+  /** Git ref whose `git archive` {@link indexArchive} reads, in which case its path is the repository. */
+  archiveRef?: string;
+}
+
+/**
+ * Throws when `failOnParseError` is set and files failed to parse, before the enqueue is marked as
+ * completed, so the next run enqueues the repository again instead of indexing a partial queue.
+ */
+export function checkParseFailures(failures: readonly ParseFailure[], options: { failOnParseError?: boolean }): void {
+  if (options.failOnParseError && failures.length > 0) {
+    throw new Error(
+      `${failures.length} files failed to parse with --fail-on-parse-error: ${formatParseFailures(failures)}`
+    );
+  }
+}
+
+/** Reasons logged by {@link logParseFailureReasons}, with the files of each listed up to this many. */
+const MAX_LOGGED_PARSE_FAILURE_REASONS = 10;
+const MAX_LOGGED_FILES_PER_REASON = 3;
+
+/** Logs the parse failures of an enqueue summary grouped by their error, with a few files of each. */
+export function logParseFailureReasons(
+  failures: readonly ParseFailure[],
+  logger: ReturnType<typeof createLogger>
+): void {
+  const groups = groupParseFailures(failures);
+  for (const { error, files } of groups.slice(0, MAX_LOGGED_PARSE_FAILURE_REASONS)) {
+    const listed = files.slice(0, MAX_LOGGED_FILES_PER_REASON).join(', ');
+    const more = files.length - MAX_LOGGED_FILES_PER_REASON;
+    logger.warn(`  ${files.length} files: ${error} (${listed}${more > 0 ? ` and ${more} more` : ''})`);
+  }
+  if (groups.length > MAX_LOGGED_PARSE_FAILURE_REASONS) {
+    logger.warn(`  ... and ${groups.length - MAX_LOGGED_PARSE_FAILURE_REASONS} other errors`);
+  }
 }
 
 async function getQueue(options: IndexOptions, repoName?: string, branch?: string): Promise<IQueueWithEnqueueMetadata> {
-  const queueDbPath = path.join(options.queueDir, 'queue.db');
-  const queue = new SqliteQueue({
-    dbPath: queueDbPath,
-    repoName,
-    branch,
+  return openQueue(options.queueDir, { repoName, branch, shards: options.queueShards });
+}
+
+/**
+ * Runs a git command in `directory` and returns its trimmed output, or null when the directory is
+ * not a git repository (or git is unavailable).
+ */
+function readGitValue(directory: string, args: string[]): string | null {
+  try {
+    // Use execFileSync to prevent shell injection from special characters in directory paths
+    return execFileSync('git', args, { cwd: directory, stdio: ['ignore', 'pipe', 'ignore'] })
+      .toString()
+      .trim();
+  } catch {
+    return null;
+  }
+}
+
+/** Returns the file's modification time in whole milliseconds, or undefined when it cannot be read. */
+function readMtimeMs(filePath: string): number | undefined {
+  try {
+    return Math.floor(fs.statSync(filePath).mtimeMs);
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * Picks the listed files that are indexed: existing files inside the repository with a language,
+ * minus the ones excluded by the root-level ignore files, exclude patterns and built-in exclusions.
+ */
+function selectListedFiles(
+  gitRoot: string,
+  directory: string,
+  listedFiles: string[],
+  includeFile: (file: string) => boolean,
+  ignoreFiles: string[],
+  options: IndexOptions,
+  logger: ReturnType<typeof createLogger>
+): WalkResult {
+  const listed = resolveListedFiles(gitRoot, path.resolve(directory), listedFiles);
+  if (listed.missing.length > 0) {
+    logger.warn(`Skipped ${listed.missing.length} listed paths that are not files.`, { paths: listed.missing });
+  }
+  if (listed.outside.length > 0) {
+    logger.warn(`Skipped ${listed.outside.length} listed paths outside ${gitRoot}.`, { paths: listed.outside });
+  }
+  const isExcluded = createPathFilter({
+    rootDir: gitRoot,
+    ignoreFiles,
+    excludePatterns: options.excludePatterns,
+    includePatterns: options.includePatterns,
+    useIgnoreFiles: false,
+  });
+  const files = listed.files.filter(includeFile);
+  const included = files.filter((file) => !isExcluded(file));
+  logger.info(`Read ${listedFiles.length} paths from the file list, ${included.length} of them are indexed.`);
+  return { files: included, ignoredFileCount: files.length - included.length, ignoredDirectoryCount: 0 };
+}
+
+/** Clears the queue and the indexed content hashes, which describe the index a clean run replaces. */
+async function clearQueue(options: IndexOptions, repoName: string, gitBranch: string): Promise<void> {
+  const workQueue: IQueueWithEnqueueMetadata = await getQueue(options, repoName, gitBranch);
+  await workQueue.clear();
+  await workQueue.clearFileHashes();
+}
+
+/** Records the parser metrics a producer worker reported for a file. */
+function recordParserMetrics(
+  metrics: Metrics,
+  message: ProducerMessage,
+  logger: ReturnType<typeof createLogger>
+): void {
+  if (!message.metrics || !metrics.parser) {
+    return;
+  }
+  if (message.status === MESSAGE_STATUS_FAILURE) {
+    if (message.metrics.filesFailed > 0) {
+      metrics.parser.filesFailed.add(
+        message.metrics.filesFailed,
+        createAttributes(metrics, {
+          language: message.metrics.language || LANGUAGE_UNKNOWN,
+          status: METRIC_STATUS_FAILURE,
+        })
+      );
+    }
+    return;
+  }
+
+  const attrs = createAttributes(metrics, {
+    language: message.metrics.language,
+    parser_type: message.metrics.parserType,
+  });
+
+  if (message.metrics.filesProcessed > 0) {
+    metrics.parser.filesProcessed.add(message.metrics.filesProcessed, {
+      ...attrs,
+      status: METRIC_STATUS_SUCCESS,
+    });
+  }
+
+  if (message.metrics.chunksCreated > 0) {
+    metrics.parser.chunksCreated.add(message.metrics.chunksCreated, attrs);
+  }
+
+  if (message.metrics.chunksSkipped > 0) {
+    metrics.parser.chunksSkipped?.add(message.metrics.chunksSkipped, {
+      ...attrs,
+      size: 'oversized',
+    });
+  }
+
+  if (message.metrics.chunksSplit > 0) {
+    metrics.parser.chunksSplit?.add(message.metrics.chunksSplit, attrs);
+  }
+
+  message.metrics.chunkSizes.forEach((size: number) => {
+    metrics.parser?.chunkSize.record(size, attrs);
+  });
+
+  // Debug: Log histogram recording
+  if (message.metrics.chunkSizes.length > 0) {
+    logger.debug(`Recorded ${message.metrics.chunkSizes.length} chunk size measurements`, {
+      min: Math.min(...message.metrics.chunkSizes),
+      max: Math.max(...message.metrics.chunkSizes),
+      avg: message.metrics.chunkSizes.reduce((a: number, b: number) => a + b, 0) / message.metrics.chunkSizes.length,
+    });
+  }
+}
+
+interface EnqueueRun {
+  options: IndexOptions;
+  repoName: string;
+  gitBranch: string;
+  /** Directory the files are relative to: the repository root, or the archive for archive entries. */
+  rootDir: string;
+  commitHash: string | null;
+  workQueue: IQueueWithEnqueueMetadata;
+  manifest?: Manifest;
+  logger: ReturnType<typeof createLogger>;
+  metrics: Metrics;
+  /** Builds the message posted to a producer worker for a file. */
+  toRequest: (file: string) => ProducerRequest;
+  /** Queue metadata of a file, recorded with its chunks. */
+  getSourceFile: (file: string) => EnqueuedFile;
+  /** SHA-256 recorded in the manifest for a file; when undefined it is read from disk. */
+  getManifestHash?: (file: string) => string | undefined;
+}
+
+/**
+ * Parses the files in the producer worker pool and enqueues their chunks, then logs the summary and
+ * marks the enqueue as completed. An enqueue interrupted by shutdown stays marked as started.
+ */
+async function enqueueFiles(files: string[] | AsyncIterable<string>, run: EnqueueRun): Promise<void> {
+  const { options, repoName, gitBranch, rootDir, commitHash, workQueue, manifest, logger, metrics } = run;
+  let successCount = 0;
+  let failureCount = 0;
+  let chunksSplitCount = 0;
+  const largestChunks = new LargestChunks();
+  const parseFailures: ParseFailure[] = [];
+  const startedAt = Date.now();
+
+  const producerWorkerPath = path.join(__dirname, '..', '..', 'dist', 'utils', 'producer_worker.js');
+  const producerPool = new ProducerPool({
+    size: options.enqueueConcurrency ?? 1,
+    highWaterMark: indexingConfig.enqueueHighWaterMark,
+    maxRestarts: options.maxWorkerRestarts,
+    heartbeatTimeoutMs: indexingConfig.workerHeartbeatTimeoutMs,
+    createWorker: () =>
+      new Worker(producerWorkerPath, {
+        workerData: {
+          repoName,
+          gitBranch,
+          languages: options.languages,
+          chunkOverlapLines: options.chunkOverlapLines,
+          embedDocComments: options.embedDocComments,
+          embedContext: options.embedContext,
+          maxChunkTokens: options.maxChunkTokens,
+          previewLines: options.previewLines,
+          tokenizer: options.tokenizer,
+          extensionMap: options.extensionMap,
+          chunkGranularity: options.chunkGranularity,
+          symbolKinds: options.symbolKinds,
+          wholeFileFallback: options.wholeFileFallback,
+          maxChunksPerFile: options.maxChunksPerFile,
+          chunkOverflow: options.chunkOverflow,
+          withBlame: options.withBlame,
+          repoRoot: rootDir,
+          commitSha: commitHash ?? undefined,
+          repoUrl: options.repoUrl,
+          repoRef: options.repoRef,
+        },
+      }),
   });
-  await queue.initialize();
-  return queue;
+
+  await producerPool.run(
+    files,
+    run.toRequest,
+    async (file, message) => {
+      const language = message.metrics?.language || undefined;
+      if (message.status === MESSAGE_STATUS_SUCCESS) {
+        successCount++;
+        chunksSplitCount += message.metrics?.chunksSplit ?? 0;
+
+        // Record parser metrics from worker
+        recordParserMetrics(metrics, message, logger);
+
+        const chunks = message.data ?? [];
+        chunks.forEach((chunk) => largestChunks.add(chunk));
+        const enqueueResult = await workQueue.enqueue(chunks, { sourceFile: run.getSourceFile(file) });
+        if (manifest) {
+          const absolutePath = path.resolve(rootDir, file);
+          recordManifestEntry(manifest, file, absolutePath, chunks.length, enqueueResult, run.getManifestHash?.(file));
+        }
+        options.progress?.recordFileEnqueued(chunks.length, chunkContentBytes(chunks));
+        if (message.chunkOverflow) {
+          options.progress?.recordChunkOverflow({ file, ...message.chunkOverflow });
+        }
+        if (message.parseError !== undefined) {
+          const failure = { file, error: message.parseError, wholeFile: true, language };
+          parseFailures.push(failure);
+          workQueue.recordParseFailure(failure);
+          options.progress?.recordParseFailure(failure);
+          logger.warn('Failed to parse file, indexed it as a whole file', { file, error: message.parseError });
+        } else {
+          workQueue.clearParseFailures([file]);
+        }
+      } else if (message.status === MESSAGE_STATUS_FAILURE) {
+        failureCount++;
+        const failure = { file, error: message.error ?? 'unknown error', language };
+        parseFailures.push(failure);
+        workQueue.recordParseFailure(failure);
+        options.progress?.recordFileFailed(failure);
+
+        // Record failure metric
+        recordParserMetrics(metrics, message, logger);
+
+        logger.warn('Failed to parse file', {
+          file: message.filePath,
+          error: message.error,
+        });
+      }
+    },
+    (file, error) => {
+      failureCount++;
+      const failure = { file, error: error instanceof Error ? error.message : String(error) };
+      parseFailures.push(failure);
+      workQueue.recordParseFailure(failure);
+      options.progress?.recordFileFailed(failure);
+      logger.error('Worker thread error', failure);
+    }
+  );
+
+  if (producerPool.isDraining) {
+    // The enqueue stays marked as started, so the next run clears or resumes it.
+    logger.warn(`Enqueue interrupted by shutdown after ${successCount} files; rerun with --resume to continue.`);
+    return;
+  }
+
+  logger.info('--- Indexing Summary ---');
+  logger.info(`Successfully processed: ${successCount} files`);
+  logger.info(`Failed to parse:      ${failureCount} files`);
+  logParseFailureReasons(parseFailures, logger);
+  // Compares runs with different --enqueue-concurrency values.
+  const filesPerSecond = Math.round(((successCount + failureCount) * 1000) / Math.max(1, Date.now() - startedAt));
+  logger.info(`Parse throughput:     ${filesPerSecond} files/s with ${options.enqueueConcurrency ?? 1} parse workers`);
+  if (chunksSplitCount > 0) {
+    logger.info(`Chunks split:         ${chunksSplitCount} (over the size or --max-chunk-tokens budget)`);
+  }
+  if (largestChunks.size > 0) {
+    logger.warn(`Largest chunks by ${options.tokenizer} tokens: ${largestChunks.format(options.maxChunkTokens)}`);
+  }
+  logger.info(`HEAD commit hash:     ${commitHash ?? '(not a git repository)'}`);
+  logger.info('---');
+  checkParseFailures(parseFailures, options);
+  logger.info('File parsing and enqueueing complete.');
+
+  if (commitHash) {
+    await workQueue.setEnqueueCommitHash(commitHash);
+  }
+
+  if (manifest && options.manifestPath) {
+    manifest.commitHash = commitHash ?? undefined;
+    writeManifest(options.manifestPath, manifest);
+    logger.info(`Wrote manifest for ${Object.keys(manifest.files).length} files to ${options.manifestPath}`);
+  }
+
+  // Mark enqueue as completed
+  await workQueue.markEnqueueCompleted();
+
+  // Runs over a file list or an archive do not advance the last indexed commit, see `indexArchive`.
+  if (commitHash && !options.files && !options.archiveRef) {
+    logger.info('Note: Commit hash will be updated after worker completes successfully.');
+  }
 }
 
 export async function index(directory: string, clean: boolean, options: IndexOptions) {
   const repoName = options?.repoName ?? path.basename(path.resolve(directory));
-  // Use execFileSync to prevent shell injection from special characters in directory paths
-  const gitBranch =
-    options?.branch ??
-    execFileSync('git', ['rev-parse', '--abbrev-ref', 'HEAD'], {
-      cwd: directory,
-    })
-      .toString()
-      .trim();
+  const gitBranch = options?.branch ?? readGitValue(directory, ['rev-parse', '--abbrev-ref', 'HEAD']) ?? 'unknown';
 
   const logger = createLogger({ name: repoName, branch: gitBranch });
   const metrics = createMetrics({ name: repoName, branch: gitBranch });
+  setLogPhase('enqueue');
 
-  const languageParser = new LanguageParser(options.languages);
+  const languageParser = new LanguageParser(options.languages, { extensionMap: options.extensionMap });
   const supportedFileExtensions = Array.from(languageParser.fileSuffixMap.keys());
   logger.info('Starting full indexing process', {
     directory,
     clean,
     supportedFileExtensions,
+    extensionMap: options.extensionMap,
   });
   if (clean) {
-    logger.info('Clean flag is set, deleting existing index and clearing queue.');
-    await deleteIndex(options.elasticsearchIndex);
-    await deleteLocationsIndex(options.elasticsearchIndex);
-
-    // Clear the queue when doing a clean reindex
-    const workQueue: IQueueWithEnqueueMetadata = await getQueue(options, repoName, gitBranch);
-    await workQueue.clear();
+    // The index itself is rebuilt into a fresh generation by the caller, see `createRebuildIndex`.
+    logger.info('Clean flag is set, clearing queue.');
+    await clearQueue(options, repoName, gitBranch);
   }
 
-  await createIndex(options.elasticsearchIndex);
-  await createSettingsIndex(options.elasticsearchIndex);
+  await createIndex(options.elasticsearchIndex, { vectorDims: options.vectorDims });
   await createLocationsIndex(options.elasticsearchIndex);
 
-  // Use execFileSync to prevent shell injection from special characters in directory paths
-  const gitRoot = execFileSync('git', ['rev-parse', '--show-toplevel'], {
-    cwd: directory,
-  })
-    .toString()
-    .trim();
-  const ig = ignore();
-  const gitignorePath = path.join(gitRoot, '.gitignore');
-  if (fs.existsSync(gitignorePath)) {
-    ig.add(fs.readFileSync(gitignorePath, 'utf8'));
+  // Directories that are not git repositories are walked from the directory itself.
+  const gitRoot = readGitValue(directory, ['rev-parse', '--show-toplevel']) ?? path.resolve(directory);
+  const ignoreFiles = getRepositoryIgnoreFiles(gitRoot, {
+    ignorePath: options.ignorePath,
+    useIgnoreFiles: options.useIgnoreFiles,
+  });
+  if (ignoreFiles.length > 0) {
+    logger.info(`Loaded ignore files with custom exclusions`, { ignoreFiles });
   }
 
-  // Load .indexerignore if it exists
-  const indexerignorePath = path.join(gitRoot, '.indexerignore');
-  if (fs.existsSync(indexerignorePath)) {
-    ig.add(fs.readFileSync(indexerignorePath, 'utf8'));
-    logger.info(`Loaded .indexerignore with custom exclusions`);
+  const includeFile = (file: string) => languageParser.getLanguageConfigForFile(file) !== undefined;
+  const walkResult = options.files
+    ? selectListedFiles(gitRoot, directory, options.files, includeFile, ignoreFiles, options, logger)
+    : walkRepositoryFiles({
+        rootDir: gitRoot,
+        searchDir: directory,
+        includeFile,
+        ignoreFiles,
+        excludePatterns: options.excludePatterns,
+        includePatterns: options.includePatterns,
+        useIgnoreFiles: options.useIgnoreFiles,
+      });
+  logger.info(
+    `Skipped ${walkResult.ignoredFileCount} files and ${walkResult.ignoredDirectoryCount} directories matched by ignore rules.`
+  );
+
+  // Checked before anything reads the files, including the content hashes below.
+  const maxFileSize = options.maxFileSize ?? indexingConfig.maxFileSizeBytes;
+  const readable = filterReadableFiles(gitRoot, walkResult.files, maxFileSize);
+  logSkippedFiles(readable.skipped, maxFileSize, logger);
+  options.progress?.recordFilesSkipped(readable.skipped);
+  let files = readable.files;
+  if (options.sample) {
+    files = sampleFiles(files, options.sample);
+    logger.info(`Sampled ${files.length} of ${readable.files.length} files.`);
   }
+  logParserSample(files, (file) => languageParser.getLanguageConfigForFile(file), logger);
 
-  ig.add(['**/*_lexer.ts', '**/*_parser.ts']);
+  // Read before parsing, so chunks are tagged with the commit their content was read at.
+  const commitHash = readGitValue(directory, ['rev-parse', 'HEAD']);
 
-  const relativeSearchDir = path.relative(gitRoot, directory);
-
-  const extensionPattern =
-    supportedFileExtensions.length === 1 ? supportedFileExtensions.join(',') : `{${supportedFileExtensions.join(',')}}`;
-  const globPattern = path.join(relativeSearchDir, `**/*${extensionPattern}`);
-
-  const allFiles = await glob(globPattern, {
-    cwd: gitRoot,
-  });
+  const workQueue: IQueueWithEnqueueMetadata = await getQueue(options, repoName, gitBranch);
+  if (options.resume) {
+    // Files whose content changed since the interrupted run have a new mtime and are enqueued again.
+    const enqueuedFiles = workQueue.getEnqueuedFiles();
+    const remainingFiles = files.filter((file) => enqueuedFiles.get(file) !== readMtimeMs(path.resolve(gitRoot, file)));
+    logger.info(
+      `Resuming enqueue: ${files.length - remainingFiles.length} files are already in the queue and will be skipped.`
+    );
+    files = remainingFiles;
+  }
 
-  // Normalize to relative paths - glob may return absolute paths despite cwd
-  // Use fs.realpathSync to resolve symlinks (e.g., /tmp -> /private/tmp on macOS)
-  const realGitRoot = fs.realpathSync(gitRoot);
-  const relativeFiles = allFiles.map((f) => {
-    if (path.isAbsolute(f)) {
-      const realPath = fs.realpathSync(f);
-      return path.relative(realGitRoot, realPath);
+  // Files whose content matches the hash recorded when they were last indexed are not parsed again.
+  const contentHashes = new Map<string, string>();
+  for (const file of files) {
+    const sha256 = readContentHash(path.resolve(gitRoot, file));
+    if (sha256) {
+      contentHashes.set(file, sha256);
     }
-    return f;
-  });
+  }
+  if (!options.force) {
+    const indexedHashes = workQueue.getFileHashes();
+    const changedFiles = files.filter((file) => {
+      const sha256 = contentHashes.get(file);
+      return sha256 === undefined || indexedHashes.get(file) !== sha256;
+    });
+    const unchangedCount = files.length - changedFiles.length;
+    if (unchangedCount > 0) {
+      logger.info(`Skipping ${unchangedCount} files whose content is unchanged since they were last indexed.`);
+    }
+    files = changedFiles;
+  }
+  logger.info(`Found ${files.length} files to process.`);
+  options.progress?.startEnqueue(files.length);
+
+  // Ensure enqueue completion metadata reflects this run.
+  await workQueue.markEnqueueStarted();
 
-  const files = ig.filter(relativeFiles);
+  // A full index regenerates the manifest from scratch; a resumed one keeps the entries of skipped files.
+  const manifest = options.manifestPath
+    ? options.resume
+      ? loadManifest(options.manifestPath, repoName, gitBranch)
+      : createManifest(repoName, gitBranch)
+    : undefined;
 
-  logger.info(`Found ${files.length} files to process.`);
+  const mtimes = new Map<string, number | undefined>();
 
-  let successCount = 0;
-  let failureCount = 0;
+  await enqueueFiles(files, {
+    options,
+    repoName,
+    gitBranch,
+    rootDir: gitRoot,
+    commitHash,
+    workQueue,
+    manifest,
+    logger,
+    metrics,
+    toRequest: (file) => {
+      const absolutePath = path.resolve(gitRoot, file);
+      // Read before parsing, so a file modified while it is parsed is enqueued again on resume.
+      mtimes.set(file, readMtimeMs(absolutePath));
+      return { filePath: absolutePath, gitBranch, relativePath: file };
+    },
+    getSourceFile: (file) => {
+      const sourceFile = {
+        filePath: file,
+        mtimeMs: mtimes.get(file),
+        sha256: contentHashes.get(file),
+        indexName: options.elasticsearchIndex,
+      };
+      mtimes.delete(file);
+      return sourceFile;
+    },
+  });
+}
 
-  const parseConcurrency =
-    typeof options.parseConcurrency === 'number' && Number.isFinite(options.parseConcurrency)
-      ? Math.floor(options.parseConcurrency)
-      : 1;
-  const producerQueue = new PQueue({ concurrency: Math.max(1, parseConcurrency) });
+/**
+ * Indexes the files of a tar archive (`.tar`, `.tar.gz` or `.tgz`) without extracting it, or with
+ * `archiveRef`, the files of that ref in the repository at `archivePath` as `git archive` writes
+ * them, without a checkout. Entries are streamed through the same parse and enqueue pipeline as the
+ * files of a directory, and each entry name, normalized to a relative path, is the `filePath` of its
+ * chunks. When every entry of a tarball is under one directory, as in GitHub and
+ * `git archive --prefix` tarballs, that directory is stripped so paths are relative to the
+ * repository root. Symlinks, hard links and other non-regular entries are skipped, as are entries
+ * whose name points outside the archive.
+ *
+ * Per-directory ignore files are not read from the archive; `ignorePath` and the exclude patterns
+ * apply. The repository's last indexed commit is not changed, so chunks of a ref are only tagged
+ * with its commit.
+ */
+export async function indexArchive(archivePath: string, clean: boolean, options: IndexOptions) {
+  const ref = options.archiveRef;
+  const rootDir = path.resolve(archivePath);
+  const repoName = options.repoName ?? (ref ? path.basename(rootDir) : getArchiveBaseName(archivePath));
+  const gitBranch = options.branch ?? ref ?? 'unknown';
 
-  const workQueue: IQueueWithEnqueueMetadata = await getQueue(options, repoName, gitBranch);
-  // Ensure enqueue completion metadata reflects this run.
-  await workQueue.markEnqueueStarted();
+  const logger = createLogger({ name: repoName, branch: gitBranch });
+  const metrics = createMetrics({ name: repoName, branch: gitBranch });
+  setLogPhase('enqueue');
 
-  const producerWorkerPath = path.join(__dirname, '..', '..', 'dist', 'utils', 'producer_worker.js');
+  const commitHash = ref ? readGitValue(rootDir, ['rev-parse', '--verify', `${ref}^{commit}`]) : null;
+  if (ref && !commitHash) {
+    throw new Error(`Cannot resolve --archive-ref ${ref} to a commit in ${rootDir}.`);
+  }
 
-  files.forEach((file) => {
-    producerQueue.add(
-      () =>
-        new Promise<void>((resolve, reject) => {
-          const worker = new Worker(producerWorkerPath, {
-            workerData: { repoName, gitBranch, languages: options.languages },
-          });
-          const absolutePath = path.resolve(gitRoot, file);
-          worker.on('message', async (message) => {
-            if (message.status === MESSAGE_STATUS_SUCCESS) {
-              successCount++;
-
-              // Record parser metrics from worker
-              if (message.metrics && metrics.parser) {
-                const attrs = createAttributes(metrics, {
-                  language: message.metrics.language,
-                  parser_type: message.metrics.parserType,
-                });
-
-                if (message.metrics.filesProcessed > 0) {
-                  metrics.parser.filesProcessed.add(message.metrics.filesProcessed, {
-                    ...attrs,
-                    status: METRIC_STATUS_SUCCESS,
-                  });
-                }
-
-                if (message.metrics.chunksCreated > 0) {
-                  metrics.parser.chunksCreated.add(message.metrics.chunksCreated, attrs);
-                }
-
-                if (message.metrics.chunksSkipped > 0) {
-                  metrics.parser.chunksSkipped?.add(message.metrics.chunksSkipped, {
-                    ...attrs,
-                    size: 'oversized',
-                  });
-                }
-
-                message.metrics.chunkSizes.forEach((size: number) => {
-                  metrics.parser?.chunkSize.record(size, attrs);
-                });
-
-                // Debug: Log histogram recording
-                if (message.metrics.chunkSizes.length > 0) {
-                  logger.debug(`Recorded ${message.metrics.chunkSizes.length} chunk size measurements`, {
-                    min: Math.min(...message.metrics.chunkSizes),
-                    max: Math.max(...message.metrics.chunkSizes),
-                    avg:
-                      message.metrics.chunkSizes.reduce((a: number, b: number) => a + b, 0) /
-                      message.metrics.chunkSizes.length,
-                  });
-                }
-              }
-
-              if (message.data.length > 0) {
-                await workQueue.enqueue(message.data);
-              }
-            } else if (message.status === MESSAGE_STATUS_FAILURE) {
-              failureCount++;
-
-              // Record failure metric
-              if (message.metrics && metrics.parser && message.metrics.filesFailed > 0) {
-                metrics.parser.filesFailed.add(
-                  message.metrics.filesFailed,
-                  createAttributes(metrics, {
-                    language: message.metrics.language || LANGUAGE_UNKNOWN,
-                    status: METRIC_STATUS_FAILURE,
-                  })
-                );
-              }
-
-              logger.warn('Failed to parse file', {
-                file: message.filePath,
-                error: message.error,
-              });
-            }
-            worker.terminate();
-            resolve();
-          });
-          worker.on('error', (err) => {
-            failureCount++;
-            logger.error('Worker thread error', { file, error: err.message });
-            worker.terminate();
-            reject(err);
-          });
-          const relativePath = file;
-          worker.postMessage({
-            filePath: absolutePath,
-            gitBranch,
-            relativePath,
-          });
-        })
-    );
+  const languageParser = new LanguageParser(options.languages, { extensionMap: options.extensionMap });
+  logger.info('Starting archive indexing process', {
+    archive: rootDir,
+    ref,
+    clean,
+    extensionMap: options.extensionMap,
   });
+  if (clean) {
+    logger.info('Clean flag is set, clearing queue.');
+    await clearQueue(options, repoName, gitBranch);
+  }
 
-  await producerQueue.onIdle();
+  await createIndex(options.elasticsearchIndex, { vectorDims: options.vectorDims });
+  await createLocationsIndex(options.elasticsearchIndex);
 
-  // Use execFileSync to prevent shell injection from special characters in directory paths
-  const commitHash = execFileSync('git', ['rev-parse', 'HEAD'], {
-    cwd: directory,
-  })
-    .toString()
-    .trim();
+  // `git archive` writes paths relative to the repository root, since it is run without --prefix.
+  const archiveRoot = ref ? undefined : await findArchiveRoot(rootDir);
+  if (archiveRoot !== undefined) {
+    logger.info(`Stripping the top-level directory ${archiveRoot}/ from archive entries.`);
+  }
+  const toRepoPath = (name: string): string | undefined => {
+    const file = normalizeArchivePath(name);
+    return archiveRoot !== undefined && file?.startsWith(`${archiveRoot}/`) ? file.slice(archiveRoot.length + 1) : file;
+  };
+
+  const isExcluded = createPathFilter({
+    rootDir,
+    ignoreFiles: options.ignorePath ? [path.resolve(options.ignorePath)] : [],
+    excludePatterns: options.excludePatterns,
+    includePatterns: options.includePatterns,
+    useIgnoreFiles: false,
+  });
+  const isIndexed = (file: string | undefined): file is string =>
+    file !== undefined && !isExcluded(file) && languageParser.getLanguageConfigForFile(file) !== undefined;
+  const maxFileSize = options.maxFileSize ?? indexingConfig.maxFileSizeBytes;
 
-  logger.info('--- Indexing Summary ---');
-  logger.info(`Successfully processed: ${successCount} files`);
-  logger.info(`Failed to parse:      ${failureCount} files`);
-  logger.info(`HEAD commit hash:     ${commitHash}`);
-  logger.info('---');
-  logger.info('File parsing and enqueueing complete.');
+  const workQueue: IQueueWithEnqueueMetadata = await getQueue(options, repoName, gitBranch);
+  const indexedHashes = options.force ? new Map<string, string>() : workQueue.getFileHashes();
+  const contents = new Map<string, string>();
+  const contentHashes = new Map<string, string>();
+  const skipped: SkippedFile[] = [];
+  let nonRegularCount = 0;
+  let unsafeCount = 0;
+  let unchangedCount = 0;
+
+  // Entries are read as producer workers become free, so only the files being parsed are held in memory.
+  async function* readArchiveFiles(): AsyncGenerator<string> {
+    const readOptions: ReadTarOptions = {
+      readContent: (entry) => entry.size <= maxFileSize && isIndexed(toRepoPath(entry.name)),
+    };
+    const entries = ref ? readGitArchiveEntries(rootDir, ref, readOptions) : readTarEntries(rootDir, readOptions);
+    for await (const entry of entries) {
+      if (entry.type !== 'file') {
+        if (entry.type !== 'directory') {
+          nonRegularCount++;
+          logger.debug(`Skipped ${entry.type} entry ${entry.name}`);
+        }
+        continue;
+      }
+      const file = toRepoPath(entry.name);
+      if (file === undefined) {
+        unsafeCount++;
+        logger.warn(`Skipped archive entry ${entry.name}, which points outside the archive.`);
+        continue;
+      }
+      if (!isIndexed(file)) {
+        continue;
+      }
+      if (entry.size > maxFileSize) {
+        skipped.push({ file, size: entry.size, reason: 'too_large' });
+        continue;
+      }
+      const content = entry.content ?? Buffer.alloc(0);
+      const { text } = decodeText(content);
+      if (text === undefined) {
+        skipped.push({ file, size: entry.size, reason: 'binary' });
+        continue;
+      }
+      const sha256 = hashContent(content);
+      if (indexedHashes.get(file) === sha256) {
+        unchangedCount++;
+        continue;
+      }
+      contents.set(file, text);
+      contentHashes.set(file, sha256);
+      options.progress?.recordFilesFound(1);
+      yield file;
+    }
+  }
 
-  await workQueue.setEnqueueCommitHash(commitHash);
+  await workQueue.markEnqueueStarted();
+  options.progress?.startEnqueue(0);
+  const manifest = options.manifestPath ? createManifest(repoName, gitBranch) : undefined;
 
-  // Mark enqueue as completed
-  await workQueue.markEnqueueCompleted();
+  await enqueueFiles(readArchiveFiles(), {
+    options,
+    repoName,
+    gitBranch,
+    rootDir,
+    commitHash,
+    workQueue,
+    manifest,
+    logger,
+    metrics,
+    toRequest: (file) => {
+      const content = contents.get(file) ?? '';
+      contents.delete(file);
+      return {
+        filePath: path.join(rootDir, file),
+        gitBranch,
+        relativePath: file,
+        inMemoryFile: { content, rootDir },
+      };
+    },
+    getSourceFile: (file) => ({
+      filePath: file,
+      sha256: contentHashes.get(file),
+      indexName: options.elasticsearchIndex,
+    }),
+    getManifestHash: (file) => contentHashes.get(file),
+  });
 
-  logger.info('Note: Commit hash will be updated after worker completes successfully.');
+  logSkippedFiles(skipped, maxFileSize, logger);
+  options.progress?.recordFilesSkipped(skipped);
+  if (unchangedCount > 0) {
+    logger.info(`Skipped ${unchangedCount} files whose content is unchanged since they were last indexed.`);
+  }
+  if (nonRegularCount > 0 || unsafeCount > 0) {
+    logger.info(
+      `Skipped ${nonRegularCount} symlinks and other non-regular entries and ${unsafeCount} entries outside the archive.`
+    );
+  }
 }
@@ -0,0 +1,33 @@
+import { Command, Option } from 'commander';
+import { createLogger } from '../utils/logger';
+import { getQueueDbPath } from '../utils/queue_helper';
+import { importQueue, readQueueExport } from '../utils/queue_export';
+
+export const importQueueCommand = new Command('queue:import')
+  .description('Load a file written by queue:export into a new queue database.')
+  .argument('<file>', 'File written by queue:export')
+  .addOption(new Option('--repo-name <repoName>', 'Repository whose queue is created (default: the exported one)'))
+  .action(async (file: string, options) => {
+    const logger = createLogger();
+
+    try {
+      const exported = readQueueExport(file);
+      const { header } = exported;
+      const repoName: string = options.repoName ?? header.repoName;
+      const dbPath = getQueueDbPath(repoName);
+      const counts = await importQueue(exported, dbPath);
+
+      logger.info(
+        `Imported ${counts.queue} queued and ${counts.dead_letter} dead-lettered documents of ${header.repoName} ` +
+          `(exported at ${header.exportedAt}) into ${dbPath}.`,
+        { counts }
+      );
+      if (header.redactedPaths) {
+        logger.warn('The export has redacted paths, so its documents do not match files of the repository.');
+      }
+      logger.info(`Inspect it with the queue:* commands and --repo-name ${repoName}.`);
+    } catch (error) {
+      logger.error(`Failed to import ${file}.`, { error: error instanceof Error ? error.message : String(error) });
+      process.exit(1);
+    }
+  });
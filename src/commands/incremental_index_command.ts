@@ -1,12 +1,29 @@
-import { createLocationsIndex, deleteDocumentsByFilePaths, getLastIndexedCommit } from '../utils/elasticsearch';
-import { languageConfigurations, parseLanguageNames } from '../languages';
+import {
+  createLocationsIndex,
+  deleteDocumentsByFilePaths,
+  getIndexedFilePaths,
+  getLastIndexedCommit,
+} from '../utils/elasticsearch';
+import { ChunkOverflowAction, LanguageParser } from '../utils/parser';
+import { ExtensionMap, logParserSample } from '../utils/extension_map';
+import { ChunkGranularityMap } from '../utils/chunk_granularity';
+import { SymbolKindFilter } from '../utils/symbol_kinds';
+import { LineRange, parseDiffHunks, selectChangedChunks } from '../utils/smart_incremental';
+import { LargestChunks, TokenizerName } from '../utils/tokenizer';
+import { checkParseFailures, index as fullIndex, logParseFailureReasons } from './full_index_producer';
+import { FileSample, filterReadableFiles, logSkippedFiles, sampleFiles } from '../utils/file_walker';
 import path from 'path';
+import fs from 'fs';
 import { Worker } from 'worker_threads';
-import PQueue from 'p-queue';
-import { createLogger } from '../utils/logger';
+import { createLogger, setLogPhase } from '../utils/logger';
+import { chunkContentBytes, ParseFailure, ProgressReporter } from '../utils/progress_reporter';
 import { IQueueWithEnqueueMetadata } from '../utils/queue';
-import { SqliteQueue } from '../utils/sqlite_queue';
-import simpleGit from 'simple-git';
+import { diffIndexedFiles } from '../utils/indexed_files';
+import { openQueue } from '../utils/queue_shards';
+import { ProducerPool } from '../utils/producer_pool';
+import { indexingConfig } from '../config';
+import { loadManifest, Manifest, readContentHash, recordManifestEntry, writeManifest } from '../utils/manifest';
+import simpleGit, { SimpleGit } from 'simple-git';
 import { createMetrics, createAttributes } from '../utils/metrics';
 import {
   MESSAGE_STATUS_SUCCESS,
@@ -14,16 +31,84 @@ import {
   METRIC_STATUS_SUCCESS,
   METRIC_STATUS_FAILURE,
   LANGUAGE_UNKNOWN,
+  QUEUE_PRIORITY_HIGH,
 } from '../utils/constants';
 
 export interface IncrementalIndexOptions {
   queueDir: string;
+  /** Database files the queue is split into by file path, see `--queue-shards` (default: 1). */
+  queueShards?: number;
   elasticsearchIndex: string;
   deleteDocumentsPageSize?: number;
-  parseConcurrency?: number;
+  /** Worker threads parsing files concurrently during enqueue. */
+  enqueueConcurrency?: number;
+  /** Parsing workers replaced after a crash or hang before the run fails, see `ProducerPoolOptions.maxRestarts`. */
+  maxWorkerRestarts?: number;
   languages?: string;
+  /** Routes file suffixes to languages or skips them, see `loadExtensionMap`. */
+  extensionMap?: ExtensionMap;
   repoName?: string;
+  /** Stamped on every chunk and location as `repo_url`. */
+  repoUrl?: string;
+  /** Stamped on every location as `repo_ref`. */
+  repoRef?: string;
   branch?: string;
+  ignorePath?: string;
+  excludePatterns?: string[];
+  includePatterns?: string[];
+  useIgnoreFiles?: boolean;
+  manifestPath?: string;
+  chunkOverlapLines?: number;
+  embedDocComments?: boolean;
+  embedContext?: boolean;
+  maxChunkTokens?: number;
+  /** Lines of each chunk stored in its `preview` field (0 disables). */
+  previewLines?: number;
+  /** Counts the tokens of each chunk for `maxChunkTokens` and `token_count`, see `--tokenizer`. */
+  tokenizer?: TokenizerName;
+  /** Chunk granularity per language, see `parseChunkGranularity`. */
+  chunkGranularity?: ChunkGranularityMap;
+  /** Symbol kinds to chunk, see `parseSymbolKinds`. */
+  symbolKinds?: SymbolKindFilter;
+  /** Files larger than this many bytes are skipped without being read (default: SCS_IDXR_MAX_FILE_SIZE_BYTES). */
+  maxFileSize?: number;
+  /**
+   * Git ref to diff against instead of the last indexed commit. When the directory is not a git
+   * repository or the ref cannot be resolved, a full index runs instead.
+   */
+  since?: string;
+  /**
+   * With `since`, the git ref to diff up to (default: HEAD). Files are read from the working tree,
+   * so it must resolve to the checked-out commit.
+   */
+  until?: string;
+  /** Receives per-file enqueue progress. */
+  progress?: ProgressReporter;
+  /** Parse and enqueue every changed file, even when its content hash matches the last indexed one. */
+  force?: boolean;
+  /** Delete the documents of indexed files that no longer exist in the working tree. */
+  prune?: boolean;
+  /** With `prune`, log the files whose documents would be deleted instead of deleting them. */
+  pruneDryRun?: boolean;
+  /**
+   * Only enqueue the chunks of modified files that intersect the diff's hunks, or whose location
+   * moved, and leave the documents of the others in place.
+   */
+  smartIncremental?: boolean;
+  /** Whether identical chunks share a chunk document (default: true), as passed to the worker. */
+  dedup?: boolean;
+  /** Re-index only a sample of the added and modified files; deleted files are always removed. */
+  sample?: FileSample;
+  /** Index a file that fails to parse as one whole-file chunk instead of leaving it out. */
+  wholeFileFallback?: boolean;
+  /** Skip or collapse a file producing more chunks than this, see `--max-chunks-per-file`. No limit when omitted. */
+  maxChunksPerFile?: number;
+  /** What happens to a file over `maxChunksPerFile`. Defaults to `skip`. */
+  chunkOverflow?: ChunkOverflowAction;
+  /** Stamp each chunk with the date and author of the last commit changing its lines, see `--with-blame`. */
+  withBlame?: boolean;
+  /** Fail the enqueue once every file was parsed when any of them failed, see `checkParseFailures`. */
+  failOnParseError?: boolean;
 }
 
 async function getQueue(
@@ -31,99 +116,231 @@ async function getQueue(
   repoName?: string,
   branch?: string
 ): Promise<IQueueWithEnqueueMetadata> {
-  const queuePath = path.join(options.queueDir, 'queue.db');
-  const queue = new SqliteQueue({
-    dbPath: queuePath,
-    repoName,
-    branch,
-  });
-  await queue.initialize();
-  return queue;
+  return openQueue(options.queueDir, { repoName, branch, shards: options.queueShards });
 }
 
-export async function incrementalIndex(directory: string, options: IncrementalIndexOptions) {
-  const repoName = options?.repoName ?? path.basename(path.resolve(directory));
-
-  const git = simpleGit(directory);
-  const gitBranch = options?.branch ?? (await git.revparse(['--abbrev-ref', 'HEAD']));
+/**
+ * Resolves `ref` to a commit hash, or returns null (after logging why) when `directory` is not a
+ * git repository or the ref does not name a commit.
+ */
+async function resolveSinceRef(
+  git: SimpleGit,
+  ref: string,
+  logger: ReturnType<typeof createLogger>
+): Promise<string | null> {
+  let isRepo = false;
+  try {
+    isRepo = await git.checkIsRepo();
+  } catch {
+    isRepo = false;
+  }
+  if (!isRepo) {
+    logger.warn(`Cannot diff against --since ${ref}: not a git repository. Falling back to a full index.`);
+    return null;
+  }
+  try {
+    return (await git.revparse(['--verify', '--quiet', `${ref}^{commit}`])).trim();
+  } catch {
+    logger.warn(`Cannot diff against --since ${ref}: the ref does not resolve to a commit. Falling back to a full index.`);
+    return null;
+  }
+}
 
-  const logger = createLogger({ name: repoName, branch: gitBranch });
-  const metrics = createMetrics({ name: repoName, branch: gitBranch });
+/**
+ * Resolves the `--until` ref to a commit hash. Throws when it does not name a commit or is not the
+ * checked-out commit, since the changed files are parsed from the working tree.
+ */
+async function resolveUntilRef(git: SimpleGit, ref: string): Promise<string> {
+  let commitHash: string;
+  try {
+    commitHash = (await git.revparse(['--verify', '--quiet', `${ref}^{commit}`])).trim();
+  } catch {
+    throw new Error(`Invalid --until value: ${ref} does not resolve to a commit.`);
+  }
+  const headCommitHash = (await git.revparse(['HEAD'])).trim();
+  if (commitHash !== headCommitHash) {
+    throw new Error(
+      `--until ${ref} (${commitHash}) is not the checked-out commit (${headCommitHash}). ` +
+        'Check it out first: changed files are read from the working tree.'
+    );
+  }
+  return commitHash;
+}
 
-  const supportedExtensions = new Set<string>();
-  const enabledLanguageNames = parseLanguageNames(options.languages);
-  enabledLanguageNames.forEach((name) => {
-    const config = languageConfigurations[name];
-    config.fileSuffixes.forEach((suffix) => supportedExtensions.add(suffix));
-  });
+/**
+ * Removes indexed documents for files that no longer exist in the working tree.
+ *
+ * The git diff only covers changes since the last indexed commit, so documents can be left behind
+ * (e.g. after a history rewrite or an interrupted run). This compares the file paths in the
+ * locations index with the files on disk and deletes the difference. Paths already handled by the
+ * diff are skipped. A file is only pruned when it is gone from disk: files that are still present
+ * but now excluded by ignore rules or a disabled language keep their documents. If the repository
+ * root cannot be read, nothing is deleted: every indexed file would otherwise look missing.
+ *
+ * @returns The file paths whose documents were removed.
+ */
+async function removeMissingFiles(
+  gitRoot: string,
+  gitBranch: string,
+  repoName: string,
+  handledFiles: Set<string>,
+  options: IncrementalIndexOptions,
+  logger: ReturnType<typeof createLogger>
+): Promise<string[]> {
+  if (!isDirectory(gitRoot)) {
+    logger.warn('Skipping removal of missing files because the repository root is not readable', { gitRoot });
+    return [];
+  }
 
-  logger.info('Starting incremental indexing process', {
-    directory,
-    ...options,
-  });
+  const indexedFilePaths = await getIndexedFilePaths(options.elasticsearchIndex, { branch: gitBranch, repoName });
+  const missingFiles = Array.from(indexedFilePaths ?? []).filter(
+    (filePath) => !handledFiles.has(filePath) && !fs.existsSync(path.resolve(gitRoot, filePath))
+  );
 
-  const lastCommitHash = await getLastIndexedCommit(gitBranch, options.elasticsearchIndex);
+  if (missingFiles.length === 0) {
+    return [];
+  }
 
-  if (!lastCommitHash) {
-    logger.warn('No previous commit hash found. Please run a full index first.', { gitBranch });
-    return;
+  if (options.pruneDryRun) {
+    logger.info('Dry run: would remove indexed documents for files that no longer exist.', {
+      count: missingFiles.length,
+      files: missingFiles,
+    });
+    return [];
   }
 
-  // Ensure the locations store exists for this index. This allows upgrading existing deployments
-  // without requiring a full clean reindex just to create the new index.
-  await createLocationsIndex(options.elasticsearchIndex);
+  logger.info('Removing indexed documents for files that no longer exist...', { count: missingFiles.length });
+  await deleteDocumentsByFilePaths(missingFiles, options.elasticsearchIndex, {
+    deleteDocumentsPageSize: options.deleteDocumentsPageSize,
+    repoName,
+  });
+  logger.info('Removed indexed documents for files that no longer exist.', { count: missingFiles.length });
+  return missingFiles;
+}
 
-  logger.info(`Last indexed commit hash: ${lastCommitHash}`, { gitBranch });
+function isDirectory(directory: string): boolean {
+  try {
+    return fs.statSync(directory).isDirectory();
+  } catch {
+    return false;
+  }
+}
 
-  const gitRoot = await git.revparse(['--show-toplevel']);
-  const changedFilesRaw = await git.diff(['--name-status', lastCommitHash, 'HEAD']);
+/** Files to update in the index, relative to the repository root. */
+export interface FileChanges {
+  /** Added or modified files to parse and enqueue. */
+  filesToIndex: string[];
+  /** Files whose indexed documents are deleted. */
+  filesToDelete: string[];
+  /**
+   * Modified files, also listed in `filesToIndex`. They are re-indexed in place and their locations
+   * that were not written again are pruned after the new documents are indexed, so the file stays
+   * searchable in the meantime.
+   */
+  filesToPrune: string[];
+  /**
+   * Lines the diff changed in files of `filesToPrune`. Only the chunks of those files that
+   * intersect them or are not indexed at their position are enqueued, see `selectChangedChunks`.
+   */
+  changedLines?: Map<string, LineRange[]>;
+}
 
-  const changedFiles = changedFilesRaw.split('\n').filter((line) => line);
+export interface FileChangesContext {
+  gitRoot: string;
+  gitBranch: string;
+  repoName: string;
+  /** Commit the new chunks are tagged with and the enqueue is recorded for, unset outside a git repository. */
+  commitHash?: string;
+  queue: IQueueWithEnqueueMetadata;
+  languageParser: LanguageParser;
+  logger: ReturnType<typeof createLogger>;
+  metrics: ReturnType<typeof createMetrics>;
+  /** Entries of deleted and enqueued files are updated in place; the caller writes it. */
+  manifest?: Manifest;
+  /** Receives per-file enqueue progress. */
+  progress?: ProgressReporter;
+}
 
-  const filesToDelete: string[] = [];
-  const filesToIndex: string[] = [];
+export interface FileChangesResult {
+  /** The files that were enqueued, after skipping unreadable and unchanged ones. */
+  filesToIndex: string[];
+  /** The files whose documents were deleted. */
+  filesToDelete: string[];
+  /** True when a shutdown stopped the enqueue; the enqueue is then left marked as started. */
+  interrupted: boolean;
+}
 
-  for (const line of changedFiles) {
-    const parts = line.split('\t');
-    const status = parts[0];
+/**
+ * Deletes the documents of removed files and parses and enqueues added or modified ones. Shared by
+ * incremental runs, which find the changes with a git diff, and the watch command, which gets them
+ * from the file system.
+ */
+export async function indexFileChanges(
+  changes: FileChanges,
+  context: FileChangesContext,
+  options: IncrementalIndexOptions
+): Promise<FileChangesResult> {
+  const { gitRoot, gitBranch, repoName, commitHash, queue, languageParser, logger, metrics, manifest, progress } =
+    context;
+  let filesToIndex = [...changes.filesToIndex];
+  let filesToDelete = [...changes.filesToDelete];
+  let filesToPrune = [...changes.filesToPrune];
+
+  // Checked before the content hashes below read the files. A modified file that is now too large
+  // or binary loses its documents, like a file whose language is no longer enabled.
+  const maxFileSize = options.maxFileSize ?? indexingConfig.maxFileSizeBytes;
+  const readable = filterReadableFiles(gitRoot, filesToIndex, maxFileSize);
+  if (readable.skipped.length > 0) {
+    const skippedFiles = new Set(readable.skipped.map((skipped) => skipped.file));
+    filesToDelete.push(...filesToPrune.filter((file) => skippedFiles.has(file)));
+    filesToPrune = filesToPrune.filter((file) => !skippedFiles.has(file));
+    filesToIndex = readable.files;
+    logSkippedFiles(readable.skipped, maxFileSize, logger);
+    progress?.recordFilesSkipped(readable.skipped);
+  }
 
-    if (status.startsWith('R')) {
-      // Handle Rename (RXXX)
-      const oldFile = parts[1];
-      const newFile = parts[2];
-      filesToDelete.push(oldFile);
-      if (supportedExtensions.has(path.extname(newFile))) {
-        filesToIndex.push(newFile);
-      }
-    } else if (status.startsWith('C')) {
-      // Handle Copy (CXXX)
-      const newFile = parts[2];
-      if (supportedExtensions.has(path.extname(newFile))) {
-        filesToIndex.push(newFile);
-      }
-    } else if (status === 'D') {
-      const file = parts[1];
-      filesToDelete.push(file);
-    } else if (status === 'A') {
-      const file = parts[1];
-      if (supportedExtensions.has(path.extname(file))) {
-        filesToIndex.push(file);
-      }
-    } else if (status === 'M') {
-      const file = parts[1];
-      // Always remove stale indexed locations for changed files, even if this file type is no
-      // longer enabled. Otherwise changing the enabled language set can leave stale docs.
-      filesToDelete.push(file);
-      if (supportedExtensions.has(path.extname(file))) {
-        filesToIndex.push(file);
-      }
+  // A checkout or rebase can report files whose content did not change. Files matching the hash
+  // recorded when they were last indexed keep their documents and are not parsed again.
+  const contentHashes = new Map<string, string>();
+  for (const file of filesToIndex) {
+    const sha256 = readContentHash(path.resolve(gitRoot, file));
+    if (sha256) {
+      contentHashes.set(file, sha256);
+    }
+  }
+  if (!options.force) {
+    const indexedHashes = queue.getFileHashes();
+    const unchangedFiles = new Set(
+      filesToIndex.filter((file) => contentHashes.has(file) && indexedHashes.get(file) === contentHashes.get(file))
+    );
+    if (unchangedFiles.size > 0) {
+      logger.info(`Skipping ${unchangedFiles.size} files whose content is unchanged since they were last indexed.`);
+      filesToIndex = filesToIndex.filter((file) => !unchangedFiles.has(file));
+      filesToDelete = filesToDelete.filter((file) => !unchangedFiles.has(file));
+      filesToPrune = filesToPrune.filter((file) => !unchangedFiles.has(file));
     }
   }
 
-  logger.info(`Found ${changedFiles.length} changed files`, {
+  logger.info(`Found ${filesToIndex.length + filesToDelete.length} changed files`, {
     toIndex: filesToIndex.length,
     toDelete: filesToDelete.length,
   });
+  logParserSample(filesToIndex, (file) => languageParser.getLanguageConfigForFile(file), logger);
+
+  // Recorded before any new document is enqueued, so every location this run writes is newer. Files
+  // re-indexed by their changed lines are marked once they are parsed, with the locations they keep.
+  const indexedBefore = Date.now();
+  const changedLines = new Map<string, LineRange[]>();
+  for (const file of filesToPrune) {
+    const lines = changes.changedLines?.get(file);
+    if (lines) {
+      changedLines.set(file, lines);
+    }
+  }
+  const filesToPruneWhole = filesToPrune.filter((file) => !changedLines.has(file));
+  if (filesToPruneWhole.length > 0) {
+    await queue.markLocationsStale(filesToPruneWhole, indexedBefore);
+  }
 
   let workQueue: IQueueWithEnqueueMetadata | undefined;
 
@@ -131,18 +348,33 @@ export async function incrementalIndex(directory: string, options: IncrementalIn
     logger.info('Removing stale indexed locations for changed/deleted files...', { count: filesToDelete.length });
     await deleteDocumentsByFilePaths(filesToDelete, options.elasticsearchIndex, {
       deleteDocumentsPageSize: options.deleteDocumentsPageSize,
+      repoName,
     });
     logger.info('Removed stale indexed locations for changed/deleted files.', { count: filesToDelete.length });
   }
 
+  // Files whose documents were removed are no longer indexed under their last hash.
+  await queue.deleteFileHashes(filesToDelete);
+  queue.clearParseFailures(filesToDelete);
+  if (manifest) {
+    for (const file of filesToDelete) {
+      delete manifest.files[file];
+    }
+  }
+
   if (filesToIndex.length === 0) {
     logger.info('No new or modified files to process.');
   } else {
     logger.info('Processing and enqueueing added/modified files...');
+    progress?.startEnqueue(filesToIndex.length);
 
     let successCount = 0;
     let failureCount = 0;
-    const enqueueQueue = await getQueue(options, repoName, gitBranch);
+    let chunksSplitCount = 0;
+    let chunksKeptCount = 0;
+    const largestChunks = new LargestChunks();
+    const parseFailures: ParseFailure[] = [];
+    const enqueueQueue = queue;
     workQueue = enqueueQueue;
     // Ensure enqueue completion metadata reflects this run. If the process dies mid-enqueue,
     // the index command can detect it and safely re-enqueue from scratch.
@@ -150,229 +382,429 @@ export async function incrementalIndex(directory: string, options: IncrementalIn
 
     const producerWorkerPath = path.join(__dirname, '..', '..', 'dist', 'utils', 'producer_worker.js');
 
-    const configuredPoolSize =
-      typeof options.parseConcurrency === 'number' && Number.isFinite(options.parseConcurrency)
-        ? Math.floor(options.parseConcurrency)
-        : 1;
-    const poolSize = Math.max(1, Math.min(configuredPoolSize, filesToIndex.length));
-
-    const producerQueue = new PQueue({ concurrency: poolSize });
-
-    const workers = Array.from(
-      { length: poolSize },
-      () =>
+    const producerPool = new ProducerPool({
+      size: options.enqueueConcurrency ?? 1,
+      highWaterMark: indexingConfig.enqueueHighWaterMark,
+      maxRestarts: options.maxWorkerRestarts,
+      heartbeatTimeoutMs: indexingConfig.workerHeartbeatTimeoutMs,
+      createWorker: () =>
         new Worker(producerWorkerPath, {
-          workerData: { repoName, gitBranch, languages: options.languages },
-        })
-    );
-
-    const idleWorkers: Worker[] = workers.slice();
-    const waiters: Array<(worker: Worker) => void> = [];
-
-    const acquireWorker = async (): Promise<Worker> => {
-      const worker = idleWorkers.pop();
-      if (worker) return worker;
-      return await new Promise<Worker>((resolve) => waiters.push(resolve));
-    };
-
-    const releaseWorker = (worker: Worker): void => {
-      const waiter = waiters.shift();
-      if (waiter) {
-        waiter(worker);
-      } else {
-        idleWorkers.push(worker);
-      }
-    };
-
-    const addOneTimeListener = (
-      worker: Worker,
-      event: 'message' | 'error',
-      handler: (...args: unknown[]) => void
-    ): (() => void) => {
-      const w = worker as unknown as {
-        once?: (event: string, handler: (...args: unknown[]) => void) => void;
-        on: (event: string, handler: (...args: unknown[]) => void) => void;
-        off?: (event: string, handler: (...args: unknown[]) => void) => void;
-        removeListener?: (event: string, handler: (...args: unknown[]) => void) => void;
-      };
-
-      if (typeof w.once === 'function') {
-        w.once(event, handler);
-        // Critical: remove the listener when the opposite event wins the race.
-        // (On Node.js, removeListener(event, originalHandler) also removes the internal once wrapper.)
-        return () => {
-          if (typeof w.off === 'function') {
-            w.off(event, handler);
-          } else if (typeof w.removeListener === 'function') {
-            w.removeListener(event, handler);
-          }
-        };
-      }
-
-      w.on(event, handler);
-      return () => {
-        if (typeof w.off === 'function') {
-          w.off(event, handler);
-        } else if (typeof w.removeListener === 'function') {
-          w.removeListener(event, handler);
-        }
-      };
-    };
+          workerData: {
+            repoName,
+            gitBranch,
+            languages: options.languages,
+            chunkOverlapLines: options.chunkOverlapLines,
+            embedDocComments: options.embedDocComments,
+            embedContext: options.embedContext,
+            maxChunkTokens: options.maxChunkTokens,
+            previewLines: options.previewLines,
+            tokenizer: options.tokenizer,
+            extensionMap: options.extensionMap,
+            chunkGranularity: options.chunkGranularity,
+            symbolKinds: options.symbolKinds,
+            wholeFileFallback: options.wholeFileFallback,
+            maxChunksPerFile: options.maxChunksPerFile,
+            chunkOverflow: options.chunkOverflow,
+            withBlame: options.withBlame,
+            repoRoot: gitRoot,
+            commitSha: commitHash,
+            repoUrl: options.repoUrl,
+            repoRef: options.repoRef,
+          },
+        }),
+    });
 
-    const runParseJob = async (file: string): Promise<void> => {
+    const writeParsedFile = async (file: string, message: unknown): Promise<void> => {
       const relativePath = file;
       const absolutePath = path.resolve(gitRoot, file);
 
-      const worker = await acquireWorker();
-      try {
-        const message = await new Promise<unknown>((resolve, reject) => {
-          const cleanups: Array<() => void> = [];
-          const cleanup = () => cleanups.forEach((fn) => fn());
-
-          cleanups.push(
-            addOneTimeListener(worker, 'message', (msg: unknown) => {
-              cleanup();
-              resolve(msg);
-            })
-          );
-
-          cleanups.push(
-            addOneTimeListener(worker, 'error', (err: unknown) => {
-              cleanup();
-              reject(err);
-            })
-          );
+      const payload = message as {
+        status?: unknown;
+        data?: unknown;
+        metrics?: unknown;
+        error?: unknown;
+        parseError?: unknown;
+        chunkOverflow?: unknown;
+        filePath?: unknown;
+      };
 
-          worker.postMessage({
-            filePath: absolutePath,
-            gitBranch,
-            relativePath,
+      const status = payload.status;
+      const metricsPayload = payload.metrics as
+        | {
+            filesProcessed?: unknown;
+            filesFailed?: unknown;
+            chunksCreated?: unknown;
+            chunksSkipped?: unknown;
+            chunksSplit?: unknown;
+            chunkSizes?: unknown;
+            language?: unknown;
+            parserType?: unknown;
+          }
+        | undefined;
+      // Names the parser of a recorded parse failure.
+      const parserLanguage =
+        typeof metricsPayload?.language === 'string' && metricsPayload.language ? metricsPayload.language : undefined;
+
+      if (status === MESSAGE_STATUS_SUCCESS) {
+        successCount++;
+        const chunksSplit = typeof metricsPayload?.chunksSplit === 'number' ? metricsPayload.chunksSplit : 0;
+        chunksSplitCount += chunksSplit;
+
+        // Record parser metrics from worker
+        if (metricsPayload && metrics.parser) {
+          const attrs = createAttributes(metrics, {
+            language: typeof metricsPayload.language === 'string' ? metricsPayload.language : LANGUAGE_UNKNOWN,
+            parser_type: typeof metricsPayload.parserType === 'string' ? metricsPayload.parserType : '',
           });
-        });
-
-        const payload = message as {
-          status?: unknown;
-          data?: unknown;
-          metrics?: unknown;
-          error?: unknown;
-          filePath?: unknown;
-        };
-
-        const status = payload.status;
-        const metricsPayload = payload.metrics as
-          | {
-              filesProcessed?: unknown;
-              filesFailed?: unknown;
-              chunksCreated?: unknown;
-              chunksSkipped?: unknown;
-              chunkSizes?: unknown;
-              language?: unknown;
-              parserType?: unknown;
-            }
-          | undefined;
 
-        if (status === MESSAGE_STATUS_SUCCESS) {
-          successCount++;
+          const filesProcessed =
+            typeof metricsPayload.filesProcessed === 'number' ? metricsPayload.filesProcessed : 0;
+          const chunksCreated = typeof metricsPayload.chunksCreated === 'number' ? metricsPayload.chunksCreated : 0;
+          const chunksSkipped = typeof metricsPayload.chunksSkipped === 'number' ? metricsPayload.chunksSkipped : 0;
+          const chunkSizes = Array.isArray(metricsPayload.chunkSizes) ? metricsPayload.chunkSizes : [];
 
-          // Record parser metrics from worker
-          if (metricsPayload && metrics.parser) {
-            const attrs = createAttributes(metrics, {
-              language: typeof metricsPayload.language === 'string' ? metricsPayload.language : LANGUAGE_UNKNOWN,
-              parser_type: typeof metricsPayload.parserType === 'string' ? metricsPayload.parserType : '',
+          if (filesProcessed > 0) {
+            metrics.parser.filesProcessed.add(filesProcessed, {
+              ...attrs,
+              status: METRIC_STATUS_SUCCESS,
             });
+          }
 
-            const filesProcessed =
-              typeof metricsPayload.filesProcessed === 'number' ? metricsPayload.filesProcessed : 0;
-            const chunksCreated = typeof metricsPayload.chunksCreated === 'number' ? metricsPayload.chunksCreated : 0;
-            const chunksSkipped = typeof metricsPayload.chunksSkipped === 'number' ? metricsPayload.chunksSkipped : 0;
-            const chunkSizes = Array.isArray(metricsPayload.chunkSizes) ? metricsPayload.chunkSizes : [];
-
-            if (filesProcessed > 0) {
-              metrics.parser.filesProcessed.add(filesProcessed, {
-                ...attrs,
-                status: METRIC_STATUS_SUCCESS,
-              });
-            }
-
-            if (chunksCreated > 0) {
-              metrics.parser.chunksCreated.add(chunksCreated, attrs);
-            }
-
-            if (chunksSkipped > 0) {
-              metrics.parser.chunksSkipped?.add(chunksSkipped, {
-                ...attrs,
-                size: 'oversized',
-              });
-            }
+          if (chunksCreated > 0) {
+            metrics.parser.chunksCreated.add(chunksCreated, attrs);
+          }
 
-            chunkSizes.forEach((size: unknown) => {
-              if (typeof size === 'number') {
-                metrics.parser?.chunkSize.record(size, attrs);
-              }
+          if (chunksSkipped > 0) {
+            metrics.parser.chunksSkipped?.add(chunksSkipped, {
+              ...attrs,
+              size: 'oversized',
             });
           }
 
-          if (Array.isArray(payload.data) && payload.data.length > 0) {
-            await enqueueQueue.enqueue(payload.data);
+          if (chunksSplit > 0) {
+            metrics.parser.chunksSplit?.add(chunksSplit, attrs);
           }
-          return;
-        }
 
-        if (status === MESSAGE_STATUS_FAILURE) {
-          failureCount++;
-
-          // Record failure metric
-          const filesFailed = typeof metricsPayload?.filesFailed === 'number' ? metricsPayload.filesFailed : 0;
-          const language = typeof metricsPayload?.language === 'string' ? metricsPayload.language : LANGUAGE_UNKNOWN;
-          if (metricsPayload && metrics.parser && filesFailed > 0) {
-            metrics.parser.filesFailed.add(
-              filesFailed,
-              createAttributes(metrics, {
-                language,
-                status: METRIC_STATUS_FAILURE,
-              })
-            );
-          }
+          chunkSizes.forEach((size: unknown) => {
+            if (typeof size === 'number') {
+              metrics.parser?.chunkSize.record(size, attrs);
+            }
+          });
+        }
 
-          logger.warn('Failed to parse file', {
-            file: typeof payload.filePath === 'string' ? payload.filePath : absolutePath,
-            error: typeof payload.error === 'string' ? payload.error : 'Unknown error',
+        const chunks = Array.isArray(payload.data) ? payload.data : [];
+        let chunksToEnqueue = chunks;
+        const fileChangedLines = changedLines.get(relativePath);
+        if (fileChangedLines) {
+          const selected = await selectChangedChunks(chunks, fileChangedLines, options.elasticsearchIndex, {
+            dedup: options.dedup,
           });
-          return;
+          await enqueueQueue.markLocationsStale([relativePath], indexedBefore, selected.keptLocationIds);
+          chunksToEnqueue = selected.chunks;
+          chunksKeptCount += selected.keptLocationIds.length;
+        }
+        chunksToEnqueue.forEach((chunk) => largestChunks.add(chunk));
+        // Files touched since the last indexed commit jump ahead of any backlog in the queue.
+        const enqueueResult = await enqueueQueue.enqueue(chunksToEnqueue, {
+          priority: QUEUE_PRIORITY_HIGH,
+          sourceFile: {
+            filePath: relativePath,
+            sha256: contentHashes.get(relativePath),
+            chunkCount: chunks.length,
+            indexName: options.elasticsearchIndex,
+          },
+        });
+        if (manifest) {
+          recordManifestEntry(manifest, relativePath, absolutePath, chunks.length, enqueueResult);
         }
+        progress?.recordFileEnqueued(chunksToEnqueue.length, chunkContentBytes(chunksToEnqueue));
+        if (payload.chunkOverflow && typeof payload.chunkOverflow === 'object') {
+          const { chunks: chunkCount, action } = payload.chunkOverflow as { chunks?: unknown; action?: unknown };
+          if (typeof chunkCount === 'number' && (action === 'skip' || action === 'whole-file')) {
+            progress?.recordChunkOverflow({ file: relativePath, chunks: chunkCount, action });
+          }
+        }
+        if (typeof payload.parseError === 'string') {
+          const failure = { file: relativePath, error: payload.parseError, wholeFile: true, language: parserLanguage };
+          parseFailures.push(failure);
+          enqueueQueue.recordParseFailure(failure);
+          progress?.recordParseFailure(failure);
+          logger.warn('Failed to parse file, indexed it as a whole file', failure);
+        } else {
+          enqueueQueue.clearParseFailures([relativePath]);
+        }
+        return;
+      }
 
+      if (status === MESSAGE_STATUS_FAILURE) {
         failureCount++;
-        logger.warn('Unexpected worker response while parsing file', { file: relativePath, status });
-      } catch (err) {
-        failureCount++;
-        const message = err instanceof Error ? err.message : String(err);
-        logger.error('Worker thread error', { file, error: message });
-      } finally {
-        releaseWorker(worker);
+        const error = typeof payload.error === 'string' ? payload.error : 'Unknown error';
+        const failure = { file: relativePath, error, language: parserLanguage };
+        parseFailures.push(failure);
+        enqueueQueue.recordParseFailure(failure);
+        progress?.recordFileFailed(failure);
+
+        // Record failure metric
+        const filesFailed = typeof metricsPayload?.filesFailed === 'number' ? metricsPayload.filesFailed : 0;
+        if (metricsPayload && metrics.parser && filesFailed > 0) {
+          metrics.parser.filesFailed.add(
+            filesFailed,
+            createAttributes(metrics, {
+              language: parserLanguage ?? LANGUAGE_UNKNOWN,
+              status: METRIC_STATUS_FAILURE,
+            })
+          );
+        }
+
+        logger.warn('Failed to parse file', {
+          file: typeof payload.filePath === 'string' ? payload.filePath : absolutePath,
+          error: failure.error,
+        });
+        return;
       }
+
+      failureCount++;
+      const failure = { file: relativePath, error: `unexpected worker response: ${String(status)}` };
+      parseFailures.push(failure);
+      enqueueQueue.recordParseFailure(failure);
+      progress?.recordFileFailed(failure);
+      logger.warn('Unexpected worker response while parsing file', { file: relativePath, status });
     };
 
-    filesToIndex.forEach((file) => {
-      producerQueue.add(() => runParseJob(file));
-    });
+    await producerPool.run(
+      filesToIndex,
+      (file) => ({ filePath: path.resolve(gitRoot, file), gitBranch, relativePath: file }),
+      writeParsedFile,
+      (file, err) => {
+        failureCount++;
+        const failure = { file, error: err instanceof Error ? err.message : String(err) };
+        parseFailures.push(failure);
+        enqueueQueue.recordParseFailure(failure);
+        progress?.recordFileFailed(failure);
+        logger.error('Worker thread error', failure);
+      }
+    );
 
-    await producerQueue.onIdle();
-    await Promise.all(workers.map(async (w) => await w.terminate()));
+    if (producerPool.isDraining) {
+      // The enqueue stays marked as started and the commit hash is not advanced, so the next run
+      // enqueues the same changes again.
+      logger.warn(`Enqueue interrupted by shutdown after ${successCount} files.`);
+      return { filesToIndex, filesToDelete, interrupted: true };
+    }
 
     logger.info('--- Incremental Indexing Summary (Additions/Modifications) ---');
     logger.info(`Successfully processed: ${successCount} files`);
     logger.info(`Failed to parse:      ${failureCount} files`);
+    logParseFailureReasons(parseFailures, logger);
+    if (chunksSplitCount > 0) {
+      logger.info(`Chunks split:         ${chunksSplitCount} (over the size or --max-chunk-tokens budget)`);
+    }
+    if (changedLines.size > 0) {
+      logger.info(`Chunks left in place: ${chunksKeptCount} (outside the changed lines of ${changedLines.size} files)`);
+    }
+    if (largestChunks.size > 0) {
+      logger.warn(`Largest chunks by ${options.tokenizer} tokens: ${largestChunks.format(options.maxChunkTokens)}`);
+    }
+    checkParseFailures(parseFailures, options);
   }
 
-  const newCommitHash = await git.revparse(['HEAD']);
-
   // If we enqueued any work during this run, persist enqueue metadata for the resume path.
   // (If there were no files to index, nothing is enqueued, so we do not touch enqueue metadata.)
   if (workQueue) {
-    await workQueue.setEnqueueCommitHash(newCommitHash);
+    if (commitHash) {
+      await workQueue.setEnqueueCommitHash(commitHash);
+    }
     await workQueue.markEnqueueCompleted();
   }
 
+  return { filesToIndex, filesToDelete, interrupted: false };
+}
+
+export async function incrementalIndex(directory: string, options: IncrementalIndexOptions) {
+  const repoName = options?.repoName ?? path.basename(path.resolve(directory));
+
+  const git = simpleGit(directory);
+  const gitBranch = options?.branch ?? (await git.revparse(['--abbrev-ref', 'HEAD']));
+
+  const logger = createLogger({ name: repoName, branch: gitBranch });
+  const metrics = createMetrics({ name: repoName, branch: gitBranch });
+  setLogPhase('enqueue');
+
+  const languageParser = new LanguageParser(options.languages, { extensionMap: options.extensionMap });
+  const isSupported = (file: string) => languageParser.getLanguageConfigForFile(file) !== undefined;
+
+  const { progress, ...loggedOptions } = options;
+  logger.info('Starting incremental indexing process', {
+    directory,
+    ...loggedOptions,
+  });
+
+  let baseCommitHash: string | null;
+  let untilCommitHash: string | undefined;
+  if (options.since) {
+    baseCommitHash = await resolveSinceRef(git, options.since, logger);
+    if (!baseCommitHash) {
+      await fullIndex(directory, false, options);
+      return;
+    }
+    if (options.until) {
+      untilCommitHash = await resolveUntilRef(git, options.until);
+      logger.info(
+        `Diffing --since ${options.since} (${baseCommitHash}) to --until ${options.until} (${untilCommitHash})`,
+        { gitBranch }
+      );
+    } else {
+      logger.info(`Diffing against --since ${options.since} (${baseCommitHash})`, { gitBranch });
+    }
+  } else {
+    baseCommitHash = await getLastIndexedCommit(gitBranch, options.elasticsearchIndex, repoName);
+
+    if (!baseCommitHash) {
+      logger.warn('No previous commit hash found. Please run a full index first.', { gitBranch });
+      return;
+    }
+    logger.info(`Last indexed commit hash: ${baseCommitHash}`, { gitBranch });
+  }
+
+  // Ensure the locations store exists for this index. This allows upgrading existing deployments
+  // without requiring a full clean reindex just to create the new index.
+  await createLocationsIndex(options.elasticsearchIndex);
+
+  const gitRoot = await git.revparse(['--show-toplevel']);
+  // Read before parsing, so chunks are tagged with the commit their content was read at.
+  const newCommitHash = await git.revparse(['HEAD']);
+  const changedFilesRaw = await git.diff(['--name-status', `${baseCommitHash}..${untilCommitHash ?? 'HEAD'}`]);
+
+  const changedFiles = changedFilesRaw.split('\n').filter((line) => line);
+
+  const filesToDelete: string[] = [];
+  let filesToIndex: string[] = [];
+  // Modified files are re-indexed in place. Their locations that were not written again are pruned
+  // after the new documents are indexed, so the file stays searchable in the meantime.
+  let filesToPrune: string[] = [];
+
+  for (const line of changedFiles) {
+    const parts = line.split('\t');
+    const status = parts[0];
+
+    if (status.startsWith('R')) {
+      // Handle Rename (RXXX)
+      const oldFile = parts[1];
+      const newFile = parts[2];
+      filesToDelete.push(oldFile);
+      if (isSupported(newFile)) {
+        filesToIndex.push(newFile);
+      }
+    } else if (status.startsWith('C')) {
+      // Handle Copy (CXXX)
+      const newFile = parts[2];
+      if (isSupported(newFile)) {
+        filesToIndex.push(newFile);
+      }
+    } else if (status === 'D') {
+      const file = parts[1];
+      filesToDelete.push(file);
+    } else if (status === 'A') {
+      const file = parts[1];
+      if (isSupported(file)) {
+        filesToIndex.push(file);
+      }
+    } else if (status === 'M') {
+      const file = parts[1];
+      if (isSupported(file)) {
+        filesToIndex.push(file);
+        filesToPrune.push(file);
+      } else {
+        // Remove the indexed locations of changed files whose type is no longer enabled or is now
+        // skipped by the extension map. Otherwise changing the language set can leave stale docs.
+        filesToDelete.push(file);
+      }
+    }
+  }
+
+  // Files that drifted from their indexed state outside of the diff, e.g. edited or deleted while an
+  // earlier run was interrupted, are re-indexed or removed as well.
+  const queue = await getQueue(options, repoName, gitBranch);
+  if (isDirectory(gitRoot)) {
+    const listed = new Set([...filesToIndex, ...filesToDelete]);
+    const drift = diffIndexedFiles(gitRoot, queue.getIndexedFiles(options.elasticsearchIndex));
+    const changedSinceIndexed = drift.changed.filter((file) => !listed.has(file) && isSupported(file));
+    const deletedSinceIndexed = drift.deleted.filter((file) => !listed.has(file));
+    filesToIndex.push(...changedSinceIndexed);
+    filesToPrune.push(...changedSinceIndexed);
+    filesToDelete.push(...deletedSinceIndexed);
+    if (changedSinceIndexed.length > 0 || deletedSinceIndexed.length > 0) {
+      logger.info(
+        `Found ${changedSinceIndexed.length} changed and ${deletedSinceIndexed.length} deleted files ` +
+          'since they were indexed, outside of the diff.'
+      );
+    }
+  }
+
+  if (options.sample) {
+    const sampled = new Set(sampleFiles(filesToIndex, options.sample));
+    logger.info(`Sampled ${sampled.size} of ${filesToIndex.length} added or modified files.`);
+    filesToIndex = filesToIndex.filter((file) => sampled.has(file));
+    filesToPrune = filesToPrune.filter((file) => sampled.has(file));
+  }
+
+  // With --smart-incremental, modified files are re-indexed by the lines the diff changed.
+  let changedLines: Map<string, LineRange[]> | undefined;
+  if (options.smartIncremental && filesToPrune.length > 0) {
+    const hunksRaw = await git.diff([
+      '-U0',
+      '--no-color',
+      '--no-ext-diff',
+      '--no-prefix',
+      '--diff-filter=M',
+      `${baseCommitHash}..${untilCommitHash ?? 'HEAD'}`,
+    ]);
+    changedLines = parseDiffHunks(hunksRaw);
+    logger.info(`Re-indexing the changed lines of ${changedLines.size} of ${filesToPrune.length} modified files`);
+  }
+
+  // Incremental runs merge into the existing manifest so unchanged files keep their entries.
+  const manifest = options.manifestPath ? loadManifest(options.manifestPath, repoName, gitBranch) : undefined;
+  const result = await indexFileChanges(
+    { filesToIndex, filesToDelete, filesToPrune, changedLines },
+    {
+      gitRoot,
+      gitBranch,
+      repoName,
+      commitHash: newCommitHash,
+      queue,
+      languageParser,
+      logger,
+      metrics,
+      manifest,
+      progress,
+    },
+    options
+  );
+  if (result.interrupted) {
+    return;
+  }
+
+  const handledFiles = new Set([...result.filesToDelete, ...result.filesToIndex]);
+  const missingFiles = options.prune
+    ? await removeMissingFiles(gitRoot, gitBranch, repoName, handledFiles, options, logger)
+    : [];
+  if (missingFiles.length > 0) {
+    await queue.deleteFileHashes(missingFiles);
+    queue.clearParseFailures(missingFiles);
+    if (manifest) {
+      for (const file of missingFiles) {
+        delete manifest.files[file];
+      }
+    }
+  }
+
+  if (manifest && options.manifestPath) {
+    manifest.commitHash = newCommitHash;
+    writeManifest(options.manifestPath, manifest);
+    logger.info(`Updated manifest at ${options.manifestPath}`, { files: Object.keys(manifest.files).length });
+  }
+
   logger.info('---');
   logger.info(`New HEAD commit hash: ${newCommitHash}`);
   logger.info('---');
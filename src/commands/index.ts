@@ -1,13 +1,24 @@
 // Main command
 export * from './index_command';
+export * from './index_status_command';
+export * from './index_stats_command';
+export * from './verify_command';
+export * from './watch_command';
+export * from './serve_command';
+export * from './events_command';
 
 // Utility commands
 export * from './setup_command';
 export * from './references_command';
 export * from './monitor_queue_command';
 export * from './clear_queue_command';
-export * from './retry_failed_command';
+export * from './maintain_queue_command';
+export * from './requeue_dead_letter_command';
 export * from './list_failed_command';
+export * from './inspect_failures_command';
+export * from './export_queue_command';
+export * from './export_symbols_command';
+export * from './import_queue_command';
 export * from './dump_tree_command';
 export * from './scaffold_language_command';
 
@@ -15,6 +26,10 @@ export * from './scaffold_language_command';
 export * from './search_command';
 
 // Internal utilities (not exposed as CLI commands)
+export * from './elasticsearch_options';
 export * from './full_index_producer';
 export * from './incremental_index_command';
+export * from './dry_run_command';
+export * from './event_stream';
+export * from './watch_files';
 export * from './worker_command';
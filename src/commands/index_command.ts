@@ -1,22 +1,75 @@
 import { Command, Option } from 'commander';
-import { index as indexRepo } from './full_index_producer';
+import { index as indexRepo, indexArchive } from './full_index_producer';
 import { incrementalIndex } from './incremental_index_command';
 import { worker } from './worker_command';
-import { appConfig } from '../config';
-import { logger } from '../utils/logger';
+import { DEFAULT_WATCH_DEBOUNCE_MS, watchFiles } from './watch_files';
+import { addIndexOptions, DEFAULT_PARSE_CONCURRENCY } from './index_options';
+import { consumeChangeEvents, EventSource } from './event_stream';
+import { dryRun, DryRunReport } from './dry_run_command';
+import { appConfig, elasticsearchConfig, embeddingConfig, indexingConfig } from '../config';
+import { LOG_FORMATS, LogFormat, logger, parseLogLevels, setLogPhase } from '../utils/logger';
 import { shutdown } from '../utils/otel_provider';
+import { startMetricsServer } from '../utils/prometheus_exporter';
+import { isShutdownRequested } from '../utils/graceful_shutdown';
 import { cloneOrPullRepo, pullRepo } from '../utils/git_helper';
 import { parseLanguageNames } from '../languages';
+import { loadExtensionMap, SKIP_PARSER } from '../utils/extension_map';
+import { ChunkGranularityMap, parseChunkGranularity } from '../utils/chunk_granularity';
+import { CHUNK_OVERFLOW_ACTIONS, ChunkOverflowAction } from '../utils/parser';
+import { DEFAULT_PREVIEW_LINES } from '../utils/chunk_preview';
+import { parseSymbolKinds, SymbolKindFilter } from '../utils/symbol_kinds';
+import { getEmbeddingTextOptions } from '../utils/embedding_text';
+import { setPauseFile } from '../utils/pause_control';
+import { readFileList, type SkippedFile } from '../utils/file_walker';
+import { getArchiveBaseName, isArchivePath } from '../utils/tar_reader';
+import { resolveManifestPath } from '../utils/manifest';
+import { DEFAULT_BULK_MIN_SIZE } from '../utils/adaptive_batch_size';
+import { DEFAULT_MAX_WORKER_RESTARTS } from '../utils/producer_pool';
+import { listQueueShardPaths, MAX_QUEUE_SHARDS, openQueue, resolveQueueShardCount } from '../utils/queue_shards';
+import {
+  ChunkOverflowFile,
+  formatChunkOverflows,
+  formatParseFailures,
+  ParseFailure,
+  PROGRESS_FORMATS,
+  ProgressEvent,
+  ProgressFormat,
+  ProgressReporter,
+} from '../utils/progress_reporter';
+import { formatDuration } from '../utils/eta';
+import {
+  createEmbeddingProvider,
+  DEFAULT_EMBEDDING_BATCH_SIZE,
+  DEFAULT_EMBEDDING_CONCURRENCY,
+  EmbeddingProvider,
+  validateEmbeddingProvider,
+} from '../utils/embedding_provider';
+import {
+  CachedEmbeddingProvider,
+  EMBEDDING_CACHE_FILE_NAME,
+  EmbeddingCache,
+  EmbeddingCacheStats,
+  getDefaultEmbeddingCachePath,
+  getEmbeddingModelKey,
+} from '../utils/embedding_cache';
+import { RateLimiter } from '../utils/rate_limiter';
+import { getDefaultTokenizerName, parseTokenizerName } from '../utils/tokenizer';
+import { SymbolExportWriter } from '../utils/symbol_export';
+import {
+  assertVectorQuantizationDims,
+  createIndex,
+  DEFAULT_ES_CONNECT_RETRIES,
+  DEFAULT_ES_CONNECT_TIMEOUT_MS,
+  loadMappingFile,
+  VECTOR_QUANTIZATIONS,
+  VECTOR_SIMILARITIES,
+  VectorQuantization,
+  waitForElasticsearch,
+} from '../utils/elasticsearch';
 import path from 'path';
 import fs from 'fs';
 import { execFileSync } from 'child_process';
 import Database from 'better-sqlite3';
-import os from 'os';
-
-const DEFAULT_PARSE_CONCURRENCY = Math.max(
-  1,
-  Math.floor((typeof os.availableParallelism === 'function' ? os.availableParallelism() : os.cpus().length) / 2)
-);
 
 interface RepoConfig {
   repoPath: string;
@@ -24,6 +77,10 @@ interface RepoConfig {
   repoUrl: string;
   indexName: string;
   branch?: string;
+  /** Stamped on every document as `repo_url` (default: a remote `repoUrl` without its credentials). */
+  url?: string;
+  /** Stamped on every location as `repo_ref`, e.g. the tag or release that is indexed. */
+  ref?: string;
 }
 
 /**
@@ -32,6 +89,7 @@ interface RepoConfig {
  * - URLs: https://github.com/elastic/kibana.git[:index]
  * - Repo names: kibana[:index]
  * - Full paths: /path/to/repo[:index]
+ * - Archives: /path/to/repo.tar.gz[:index], named after the file without its suffix
  */
 export function parseRepoArg(arg: string, globalBranch?: string): RepoConfig {
   // Split on the last ':' to handle URLs with '://' and SSH URLs with ':'
@@ -111,8 +169,11 @@ export function parseRepoArg(arg: string, globalBranch?: string): RepoConfig {
   let repoPath: string;
   let repoName: string;
 
-  // Check if it's a URL
-  if (repoSpec.includes('://') || repoSpec.includes('github.com') || repoSpec.includes('.git')) {
+  if (!repoSpec.includes('://') && isArchivePath(repoSpec)) {
+    // Archives are read in place, see `indexArchive`.
+    repoPath = path.resolve(repoSpec);
+    repoName = getArchiveBaseName(repoPath);
+  } else if (repoSpec.includes('://') || repoSpec.includes('github.com') || repoSpec.includes('.git')) {
     // Extract repo name from URL
     const urlMatch = repoSpec.match(/\/([^\/]+?)(\.git)?$/);
     repoName = urlMatch ? urlMatch[1] : path.basename(repoSpec, '.git');
@@ -136,6 +197,95 @@ export function parseRepoArg(arg: string, globalBranch?: string): RepoConfig {
   };
 }
 
+function isRemoteRepoSpec(spec: string): boolean {
+  return spec.includes('://') || spec.startsWith('git@');
+}
+
+/** Returns the `repo_url` of a repository given as a remote spec, without the token of an https URL. */
+export function getPublicRepoUrl(spec: string): string | undefined {
+  return isRemoteRepoSpec(spec) ? spec.replace(/^([a-z][a-z0-9+.-]*:\/\/)[^@/]+@/i, '$1') : undefined;
+}
+
+/**
+ * Builds the RepoConfig for a repository identified by a location and an explicit name. The
+ * name keys the queue directory and tags every chunk, so it must be unique within a run.
+ */
+function createNamedRepoConfig(location: string, repoName: string, branch?: string, indexName?: string): RepoConfig {
+  return {
+    repoPath: isRemoteRepoSpec(location) ? path.join(process.cwd(), '.repos', repoName) : path.resolve(location),
+    repoName,
+    repoUrl: location,
+    indexName: indexName || repoName,
+    branch,
+  };
+}
+
+/**
+ * Parse a `--repo <path>=<name>` value into a RepoConfig. The path may also be a git URL,
+ * which is cloned into `.repos/<name>`.
+ */
+export function parseRepoFlag(value: string, globalBranch?: string): RepoConfig {
+  const separator = value.lastIndexOf('=');
+  const location = separator > 0 ? value.substring(0, separator).trim() : '';
+  const repoName = separator > 0 ? value.substring(separator + 1).trim() : '';
+  if (!location || !repoName) {
+    throw new Error(`Invalid --repo value: ${value}. Expected <path>=<name>.`);
+  }
+  return createNamedRepoConfig(location, repoName, globalBranch);
+}
+
+/**
+ * Load repositories from a JSON repos file:
+ * `{ "index": "shared", "repos": [{ "path": "../svc-a", "name": "svc-a", "branch": "main" }] }`
+ *
+ * `index` is the default index for entries without their own `index`. Relative paths are
+ * resolved against the directory of the file. Entries may also set the `url` and `ref` stamped on
+ * their documents, see `--repo-url` and `--repo-ref`.
+ */
+export function loadReposFile(filePath: string, globalBranch?: string): RepoConfig[] {
+  let parsed: unknown;
+  try {
+    parsed = JSON.parse(fs.readFileSync(filePath, 'utf8'));
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new Error(`Could not read repos file ${filePath}: ${message}`);
+  }
+
+  const file = parsed as { index?: unknown; repos?: unknown };
+  if (!file || typeof file !== 'object' || !Array.isArray(file.repos)) {
+    throw new Error(`Invalid repos file ${filePath}: expected an object with a "repos" array.`);
+  }
+  const defaultIndex = typeof file.index === 'string' ? file.index : undefined;
+  const baseDir = path.dirname(path.resolve(filePath));
+
+  return file.repos.map((entry: unknown, position: number) => {
+    const repo = (entry ?? {}) as {
+      path?: unknown;
+      name?: unknown;
+      index?: unknown;
+      branch?: unknown;
+      url?: unknown;
+      ref?: unknown;
+    };
+    if (typeof repo.path !== 'string' || repo.path.length === 0) {
+      throw new Error(`Invalid repos file ${filePath}: repos[${position}] is missing "path".`);
+    }
+    const location = isRemoteRepoSpec(repo.path) ? repo.path : path.resolve(baseDir, repo.path);
+    const repoName =
+      typeof repo.name === 'string' && repo.name.length > 0 ? repo.name : path.basename(repo.path, '.git');
+    return {
+      ...createNamedRepoConfig(
+        location,
+        repoName,
+        typeof repo.branch === 'string' ? repo.branch : globalBranch,
+        typeof repo.index === 'string' ? repo.index : defaultIndex
+      ),
+      ...(typeof repo.url === 'string' && repo.url.length > 0 ? { url: repo.url } : {}),
+      ...(typeof repo.ref === 'string' && repo.ref.length > 0 ? { ref: repo.ref } : {}),
+    };
+  });
+}
+
 /**
  * Clone a repository if it doesn't exist
  * Note: This is a thin wrapper around the shared git_helper utility
@@ -154,19 +304,20 @@ export async function ensureRepoCloned(repoUrl: string, repoPath: string, token?
  */
 export function hasQueueItems(repoName: string): boolean {
   const queueDir = path.join(appConfig.queueBaseDir, repoName);
-  const queueDbPath = path.join(queueDir, 'queue.db');
-
-  if (!fs.existsSync(queueDbPath)) {
-    return false;
-  }
 
   try {
-    const db = new Database(queueDbPath, { readonly: true });
-    const result = db
-      .prepare("SELECT COUNT(*) as count FROM queue WHERE status IN ('pending', 'processing')")
-      .get() as { count: number };
-    db.close();
-    return result.count > 0;
+    // A sharded queue has items when any of its shards does.
+    return listQueueShardPaths(queueDir).some((queueDbPath) => {
+      const db = new Database(queueDbPath, { readonly: true });
+      try {
+        const result = db
+          .prepare("SELECT COUNT(*) as count FROM queue WHERE status IN ('pending', 'processing')")
+          .get() as { count: number };
+        return result.count > 0;
+      } finally {
+        db.close();
+      }
+    });
   } catch (error) {
     logger.warn(`Could not check queue status: ${error}`);
     return false;
@@ -179,29 +330,183 @@ export function hasQueueItems(repoName: string): boolean {
 async function indexRepos(
   repoArgs: string[],
   options: {
+    repo?: string[];
+    reposFile?: string;
+    repoName?: string;
+    repoUrl?: string;
+    repoRef?: string;
+    index?: string;
     clean?: boolean;
+    deleteOldIndices?: boolean;
+    keepOldIndices?: string;
+    alias?: string;
     pull?: boolean;
     watch?: boolean;
+    /** Set by the watch command: re-index files as they change after the initial run. */
+    watchFiles?: boolean;
+    /** Set by the serve command: leave OpenTelemetry and the metrics server running for its next run. */
+    serve?: boolean;
+    /** Set by the index:events command: apply change events from this source after the initial run. */
+    events?: EventSource;
+    debounce?: string;
+    workers?: string;
     concurrency?: string;
     branch?: string;
     githubToken?: string;
     batchSize?: string;
+    bulkMinSize?: string;
+    bulkMaxSize?: string;
     deleteDocumentsPageSize?: string;
+    enqueueConcurrency?: string;
+    parseWorkers?: string;
     parseConcurrency?: string;
+    maxWorkerRestarts?: string;
     languages?: string;
+    ignorePath?: string;
+    exclude?: string[];
+    include?: string[];
+    ignoreFiles?: boolean;
+    manifest?: string | boolean;
+    maxAttempts?: string;
+    queueShards?: string;
+    since?: string;
+    until?: string;
+    chunkOverlapLines?: string;
+    maxChunkTokens?: string;
+    previewLines?: string;
+    tokenizer?: string;
+    maxFileSize?: string;
+    embedDocs?: boolean;
+    embedContext?: boolean;
+    stripComments?: boolean;
+    stripLicense?: boolean;
+    progress?: string;
+    progressInterval?: string;
+    logFormat?: string;
+    logLevel?: string;
+    resume?: boolean;
+    dryRun?: boolean;
+    json?: boolean;
+    exportSymbols?: string;
+    prune?: boolean;
+    pruneDryRun?: boolean;
+    smartIncremental?: boolean;
+    limit?: string;
+    sampleRate?: string;
+    sampleSeed?: string;
+    wholeFileFallback?: boolean;
+    withBlame?: boolean;
+    failOnParseError?: boolean;
+    maxChunksPerFile?: string;
+    chunkOverflow?: string;
+    dedup?: boolean;
+    storeCompressed?: boolean;
+    storeContent?: boolean;
+    extensionMap?: string;
+    chunkGranularity?: string;
+    includeKinds?: string;
+    excludeKinds?: string;
+    force?: boolean;
+    embeddingProvider?: string;
+    embedProvider?: string;
+    embeddingUrl?: string;
+    embeddingModel?: string;
+    embeddingDims?: string;
+    similarity?: string;
+    vectorQuantization?: string;
+    mappingFile?: string;
+    embeddingBatchSize?: string;
+    embedBatchSize?: string;
+    embeddingConcurrency?: string;
+    /** Cache file path, or false with `--no-embedding-cache`. */
+    embeddingCache?: string | false;
+    /** False with `--no-embed-cache`, an alias of `--no-embedding-cache`. */
+    embedCache?: boolean;
+    embedCacheDir?: string;
+    embedRpm?: string;
+    embedTpm?: string;
+    embedConcurrency?: string;
+    indexConcurrency?: string;
+    metricsPort?: string;
+    pauseFile?: string;
+    esConnectRetries?: string;
+    esConnectTimeout?: string;
+    filesFrom?: string;
+    archiveRef?: string;
   }
 ) {
+  const startedAt = Date.now();
+  // Applied before the first log line, so a run never mixes formats.
+  if (options.logFormat !== undefined) {
+    if (!LOG_FORMATS.includes(options.logFormat as LogFormat)) {
+      throw new Error(`Invalid --log-format value: ${options.logFormat}. Expected one of: ${LOG_FORMATS.join(', ')}.`);
+    }
+    appConfig.logFormat = options.logFormat === 'json' ? 'json' : 'text';
+  }
+  // Set in the environment, so the parsing worker threads started later apply the same levels.
+  if (options.logLevel !== undefined) {
+    try {
+      parseLogLevels(options.logLevel);
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      throw new Error(`Invalid --log-level value: ${options.logLevel}. ${message}`);
+    }
+    appConfig.logLevel = options.logLevel;
+  }
   logger.info('Starting index command...');
 
-  if (!repoArgs || repoArgs.length === 0) {
+  let repoConfigs: RepoConfig[] = [
+    ...(repoArgs ?? []).map((arg) => parseRepoArg(arg, options.branch)),
+    ...(options.repo ?? []).map((value) => parseRepoFlag(value, options.branch)),
+    ...(options.reposFile ? loadReposFile(options.reposFile, options.branch) : []),
+  ];
+  const repoIdentity: Array<[string, string | undefined]> = [
+    ['--repo-name', options.repoName],
+    ['--repo-url', options.repoUrl],
+    ['--repo-ref', options.repoRef],
+  ];
+  for (const [flag, value] of repoIdentity) {
+    if (value !== undefined && value.trim() === '') {
+      throw new Error(`Invalid ${flag} value: it must not be empty.`);
+    }
+    if (value !== undefined && repoConfigs.length > 1) {
+      throw new Error(`${flag} requires a single repository; set "name", "url" and "ref" per repos file entry.`);
+    }
+  }
+  repoConfigs = repoConfigs.map((config) => ({
+    ...config,
+    // The index follows a renamed repository unless it was given, as with --repo <path>=<name>.
+    ...(options.repoName !== undefined
+      ? {
+          repoName: options.repoName,
+          indexName: config.indexName === config.repoName ? options.repoName : config.indexName,
+        }
+      : {}),
+    ...(options.repoUrl !== undefined ? { url: options.repoUrl } : {}),
+    ...(options.repoRef !== undefined ? { ref: options.repoRef } : {}),
+    ...(options.index ? { indexName: options.index } : {}),
+  }));
+
+  if (repoConfigs.length === 0) {
     logger.error('No repository configurations provided. Exiting.');
-    logger.error('Provide repositories as arguments.');
+    logger.error('Provide repositories as arguments, with --repo <path>=<name>, or with --repos-file.');
     process.exit(1);
   }
 
-  if (options.watch && repoArgs.length > 1) {
+  // The queue directory and the repo_name tag of every chunk are keyed by the repository name.
+  const seenRepoNames = new Set<string>();
+  for (const config of repoConfigs) {
+    if (seenRepoNames.has(config.repoName)) {
+      throw new Error(
+        `Duplicate repository name "${config.repoName}". Give each repository a unique name with --repo <path>=<name>.`
+      );
+    }
+    seenRepoNames.add(config.repoName);
+  }
+
+  if (options.watch && repoConfigs.length > 1) {
     logger.warn(
-      `Watch mode enabled with ${repoArgs.length} repositories. Only the first repository (${repoArgs[0]}) will be watched.`
+      `Watch mode enabled with ${repoConfigs.length} repositories. Only the first repository (${repoConfigs[0].repoUrl}) will be watched.`
     );
   }
 
@@ -226,11 +531,288 @@ async function indexRepos(
     return parsed;
   }
 
-  const concurrency = parsePositiveInt('concurrency', options.concurrency, 2);
+  /**
+   * Parses a string option into a non-negative integer, throwing an error if invalid.
+   *
+   * @param optionName The name of the option for error messaging.
+   * @param value The value to parse.
+   * @param fallback The default value if the input is undefined.
+   * @returns The parsed integer.
+   */
+  function parseNonNegativeInt(optionName: string, value: string | undefined, fallback: number): number {
+    if (value === undefined) {
+      return fallback;
+    }
+
+    const parsed = Number(value);
+    if (!Number.isInteger(parsed) || parsed < 0) {
+      throw new Error(`Invalid --${optionName} value: ${value}. Must be a non-negative integer.`);
+    }
+
+    return parsed;
+  }
+
+  // --workers supersedes --concurrency, which is kept for existing scripts.
+  const concurrency =
+    options.workers !== undefined
+      ? parsePositiveInt('workers', options.workers, 2)
+      : parsePositiveInt('concurrency', options.concurrency, 2);
   const batchSize = parsePositiveInt('batch-size', options.batchSize, 100);
+  const bulkMaxSize = parsePositiveInt('bulk-max-size', options.bulkMaxSize, batchSize);
+  const bulkMinSize = parsePositiveInt(
+    'bulk-min-size',
+    options.bulkMinSize,
+    Math.min(DEFAULT_BULK_MIN_SIZE, bulkMaxSize)
+  );
   const deleteDocumentsPageSize = parsePositiveInt('delete-documents-page-size', options.deleteDocumentsPageSize, 500);
-  const parseConcurrency = parsePositiveInt('parse-concurrency', options.parseConcurrency, DEFAULT_PARSE_CONCURRENCY);
+  // --enqueue-concurrency supersedes its --parse-workers alias and --parse-concurrency, which is kept
+  // for existing scripts.
+  const enqueueConcurrency =
+    options.enqueueConcurrency !== undefined
+      ? parsePositiveInt('enqueue-concurrency', options.enqueueConcurrency, DEFAULT_PARSE_CONCURRENCY)
+      : options.parseWorkers !== undefined
+        ? parsePositiveInt('parse-workers', options.parseWorkers, DEFAULT_PARSE_CONCURRENCY)
+        : parsePositiveInt('parse-concurrency', options.parseConcurrency, DEFAULT_PARSE_CONCURRENCY);
+  const maxWorkerRestarts = parseNonNegativeInt(
+    'max-worker-restarts',
+    options.maxWorkerRestarts,
+    DEFAULT_MAX_WORKER_RESTARTS
+  );
+  const maxAttempts = parsePositiveInt('max-attempts', options.maxAttempts, indexingConfig.queueMaxAttempts);
+  const requestedQueueShards =
+    options.queueShards !== undefined ? parsePositiveInt('queue-shards', options.queueShards, 1) : undefined;
+  if (requestedQueueShards !== undefined && requestedQueueShards > MAX_QUEUE_SHARDS) {
+    throw new Error(`Invalid --queue-shards value: ${options.queueShards}. Must be at most ${MAX_QUEUE_SHARDS}.`);
+  }
+  const chunkOverlapLines = parseNonNegativeInt('chunk-overlap-lines', options.chunkOverlapLines, 0);
+  const maxChunkTokens = parseNonNegativeInt('max-chunk-tokens', options.maxChunkTokens, indexingConfig.maxChunkTokens);
+  const previewLines = parseNonNegativeInt('preview-lines', options.previewLines, DEFAULT_PREVIEW_LINES);
+  // --embedding-provider supersedes its --embed-provider alias.
+  const embeddingProviderName = options.embeddingProvider ?? options.embedProvider ?? 'elasticsearch';
+  // Chunks are counted the way the embedding provider's models tokenize unless --tokenizer picks one.
+  const tokenizer =
+    options.tokenizer !== undefined
+      ? parseTokenizerName(options.tokenizer)
+      : getDefaultTokenizerName(embeddingProviderName);
+  const maxFileSize = parsePositiveInt('max-file-size', options.maxFileSize, indexingConfig.maxFileSizeBytes);
+  // Sampled runs index a reproducible subset of the files left by the ignore rules, see `sampleFiles`.
+  const limit = options.limit !== undefined ? parsePositiveInt('limit', options.limit, 0) : undefined;
+  const sampleRate = options.sampleRate !== undefined ? Number(options.sampleRate) : undefined;
+  if (sampleRate !== undefined && !(sampleRate > 0 && sampleRate <= 1)) {
+    throw new Error(
+      `Invalid --sample-rate value: ${options.sampleRate}. Must be a number greater than 0 and at most 1.`
+    );
+  }
+  if (options.sampleSeed !== undefined && sampleRate === undefined) {
+    throw new Error('--sample-seed requires --sample-rate.');
+  }
+  const sampleSeed = parseNonNegativeInt('sample-seed', options.sampleSeed, 0);
+  const sample = limit !== undefined || sampleRate !== undefined ? { limit, sampleRate, seed: sampleSeed } : undefined;
+  // Generated files can produce tens of thousands of chunks; no limit unless --max-chunks-per-file sets one.
+  const maxChunksPerFile =
+    options.maxChunksPerFile !== undefined
+      ? parsePositiveInt('max-chunks-per-file', options.maxChunksPerFile, 0)
+      : undefined;
+  const chunkOverflow = (options.chunkOverflow ?? 'skip') as ChunkOverflowAction;
+  if (!CHUNK_OVERFLOW_ACTIONS.includes(chunkOverflow)) {
+    throw new Error(
+      `Invalid --chunk-overflow value: ${options.chunkOverflow}. Expected one of: ${CHUNK_OVERFLOW_ACTIONS.join(', ')}.`
+    );
+  }
+  if (options.chunkOverflow !== undefined && maxChunksPerFile === undefined) {
+    throw new Error('--chunk-overflow requires --max-chunks-per-file.');
+  }
   const githubToken = options.githubToken ?? appConfig.githubToken;
+  const debounceMs = parseNonNegativeInt('debounce', options.debounce, DEFAULT_WATCH_DEBOUNCE_MS);
+  const esConnectRetries = parseNonNegativeInt(
+    'es-connect-retries',
+    options.esConnectRetries,
+    DEFAULT_ES_CONNECT_RETRIES
+  );
+  const esConnectTimeoutMs = parsePositiveInt(
+    'es-connect-timeout',
+    options.esConnectTimeout,
+    DEFAULT_ES_CONNECT_TIMEOUT_MS
+  );
+
+  // Applied to the config, so the ingest pipeline's dimensions and every index created by the run use them.
+  const embeddingDims =
+    options.embeddingDims !== undefined ? parsePositiveInt('embedding-dims', options.embeddingDims, 0) : undefined;
+  if (embeddingDims !== undefined) {
+    elasticsearchConfig.denseVectorDims = embeddingDims;
+  }
+  if (options.similarity !== undefined) {
+    if (!VECTOR_SIMILARITIES.includes(options.similarity as (typeof VECTOR_SIMILARITIES)[number])) {
+      throw new Error(
+        `Invalid --similarity value: ${options.similarity}. Expected one of: ${VECTOR_SIMILARITIES.join(', ')}.`
+      );
+    }
+    elasticsearchConfig.denseVectorSimilarity = options.similarity;
+  }
+  if (options.vectorQuantization !== undefined) {
+    if (!VECTOR_QUANTIZATIONS.includes(options.vectorQuantization as VectorQuantization)) {
+      throw new Error(
+        `Invalid --vector-quantization value: ${options.vectorQuantization}. ` +
+          `Expected one of: ${VECTOR_QUANTIZATIONS.join(', ')}.`
+      );
+    }
+    elasticsearchConfig.denseVectorQuantization = options.vectorQuantization;
+  }
+  if (options.mappingFile !== undefined) {
+    if (options.similarity !== undefined) {
+      throw new Error('--similarity cannot be combined with --mapping-file, which maps code_vector itself.');
+    }
+    if (options.vectorQuantization !== undefined) {
+      throw new Error('--vector-quantization cannot be combined with --mapping-file, which maps code_vector itself.');
+    }
+    loadMappingFile(options.mappingFile);
+    elasticsearchConfig.mappingFile = options.mappingFile;
+  }
+
+  if (bulkMinSize > bulkMaxSize) {
+    throw new Error(`--bulk-min-size (${bulkMinSize}) cannot be greater than --bulk-max-size (${bulkMaxSize}).`);
+  }
+
+  if (options.since !== undefined && options.since.trim().length === 0) {
+    throw new Error('Invalid --since value: empty string. Provide a git ref (commit, branch or tag).');
+  }
+  if (options.since && options.clean) {
+    throw new Error('--since cannot be combined with --clean.');
+  }
+  if (options.until !== undefined && options.until.trim().length === 0) {
+    throw new Error('Invalid --until value: empty string. Provide a git ref (commit, branch or tag).');
+  }
+  if (options.until && !options.since) {
+    throw new Error('--until requires --since.');
+  }
+  if (options.resume && options.clean) {
+    throw new Error('--resume cannot be combined with --clean.');
+  }
+  if (options.deleteOldIndices && !options.clean) {
+    throw new Error('--delete-old-indices requires --clean.');
+  }
+  if (options.keepOldIndices !== undefined && !options.clean) {
+    throw new Error('--keep-old-indices requires --clean.');
+  }
+  if (options.keepOldIndices !== undefined && options.deleteOldIndices) {
+    throw new Error('--keep-old-indices cannot be combined with --delete-old-indices.');
+  }
+  if (options.json && !options.dryRun) {
+    throw new Error('--json requires --dry-run.');
+  }
+  if (options.exportSymbols !== undefined && !options.dryRun) {
+    throw new Error('--export-symbols requires --dry-run.');
+  }
+  if (options.dryRun && options.watch) {
+    throw new Error('--dry-run cannot be combined with --watch.');
+  }
+  if (options.prune && options.clean) {
+    throw new Error('--prune cannot be combined with --clean.');
+  }
+  if (options.pruneDryRun && !options.prune) {
+    throw new Error('--prune-dry-run requires --prune.');
+  }
+  if (options.smartIncremental && options.clean) {
+    throw new Error('--smart-incremental cannot be combined with --clean.');
+  }
+  if (options.metricsPort !== undefined && options.dryRun) {
+    throw new Error('--metrics-port cannot be combined with --dry-run.');
+  }
+  if (options.pauseFile !== undefined && options.dryRun) {
+    throw new Error('--pause-file cannot be combined with --dry-run.');
+  }
+  // File lists and archives are indexed as given: there is no commit range to diff and no tree to watch.
+  const watch = options.watch || options.watchFiles;
+  const listedRunConflicts: Array<[string, unknown]> = [
+    ['--since', options.since],
+    ['--prune', options.prune],
+    ['--watch', watch],
+    ['--dry-run', options.dryRun],
+  ];
+  if (options.filesFrom !== undefined) {
+    if (repoConfigs.length > 1) {
+      throw new Error('--files-from requires a single repository.');
+    }
+    for (const [flag, value] of listedRunConflicts) {
+      if (value) {
+        throw new Error(`${flag} cannot be combined with --files-from.`);
+      }
+    }
+  }
+  const archiveConflicts: Array<[string, unknown]> = [
+    ...listedRunConflicts,
+    ['--resume', options.resume],
+    ['--files-from', options.filesFrom !== undefined],
+    ['--limit', limit !== undefined],
+    ['--sample-rate', sampleRate !== undefined],
+  ];
+  if (repoConfigs.some((config) => isArchivePath(config.repoPath))) {
+    const tarballConflicts: Array<[string, unknown]> = [
+      ...archiveConflicts,
+      ['--pull', options.pull],
+      ['--archive-ref', options.archiveRef],
+    ];
+    for (const [flag, value] of tarballConflicts) {
+      if (value) {
+        throw new Error(`${flag} cannot be combined with an archive repository.`);
+      }
+    }
+  }
+  if (options.archiveRef !== undefined) {
+    for (const [flag, value] of archiveConflicts) {
+      if (value) {
+        throw new Error(`${flag} cannot be combined with --archive-ref.`);
+      }
+    }
+  }
+  if (options.storeContent === false && options.storeCompressed) {
+    throw new Error('--no-store-content cannot be combined with --store-compressed.');
+  }
+  // The ingest pipeline embeds the stored `content`, which is only an excerpt of a compressed chunk
+  // and is not stored at all with --no-store-content.
+  const usesIngestPipeline = embeddingProviderName === 'elasticsearch';
+  const contentFlag = options.storeCompressed
+    ? '--store-compressed'
+    : options.storeContent === false
+      ? '--no-store-content'
+      : undefined;
+  if (contentFlag && usesIngestPipeline && indexingConfig.enableDenseVectors) {
+    throw new Error(
+      `${contentFlag} requires --embedding-provider http, openai or cohere ` +
+        'when SCS_IDXR_ENABLE_DENSE_VECTORS is set.'
+    );
+  }
+  const metricsPort = options.metricsPort === undefined ? undefined : Number(options.metricsPort);
+  if (metricsPort !== undefined && (!Number.isInteger(metricsPort) || metricsPort < 1 || metricsPort > 65535)) {
+    throw new Error(`Invalid --metrics-port value: ${options.metricsPort}. Must be an integer between 1 and 65535.`);
+  }
+  // --delete-old-indices is the same as keeping no previous generation.
+  const keepOldIndices = options.deleteOldIndices
+    ? 0
+    : options.keepOldIndices !== undefined
+      ? parseNonNegativeInt('keep-old-indices', options.keepOldIndices, 0)
+      : undefined;
+
+  const readAlias = options.alias;
+  if (readAlias !== undefined) {
+    if (readAlias.trim().length === 0) {
+      throw new Error('Invalid --alias value: empty string. Provide an alias name.');
+    }
+    const conflicting = repoConfigs.find((config) => config.indexName === readAlias);
+    if (conflicting) {
+      throw new Error(`--alias "${readAlias}" must differ from the index name of ${conflicting.repoName}.`);
+    }
+  }
+
+  const progressFormat = (options.progress ?? 'text') as ProgressFormat;
+  if (!PROGRESS_FORMATS.includes(progressFormat)) {
+    throw new Error(`Invalid --progress value: ${options.progress}. Expected one of: ${PROGRESS_FORMATS.join(', ')}.`);
+  }
+  // Without --progress-interval the reporter picks the interval for its output (bar or lines).
+  const progressIntervalMs =
+    options.progressInterval === undefined
+      ? undefined
+      : parsePositiveInt('progress-interval', options.progressInterval, 0) * 1000;
 
   let languages = options.languages ?? appConfig.languages;
   if (languages !== undefined && languages.trim().length === 0) {
@@ -246,19 +828,233 @@ async function indexRepos(
     }
     languages = languageNames.join(',');
   }
-  const isSingleRepo = repoArgs.length === 1;
+
+  const extensionMapPath = options.extensionMap ?? appConfig.extensionMap;
+  const extensionMap = extensionMapPath ? loadExtensionMap(extensionMapPath) : undefined;
+  if (extensionMap && extensionMapPath) {
+    logger.info(`Loaded extension map ${extensionMapPath}`, {
+      rules: Object.keys(extensionMap.extensions).length,
+      unknown: extensionMap.unknown ?? SKIP_PARSER,
+    });
+    const enabledLanguages = new Set<string>(parseLanguageNames(languages));
+    for (const [suffix, parser] of Object.entries(extensionMap.extensions)) {
+      if (parser !== SKIP_PARSER && !enabledLanguages.has(parser)) {
+        logger.warn(`Extension map routes "${suffix}" to "${parser}", which is not enabled. Those files are skipped.`);
+      }
+    }
+  }
+
+  const chunkGranularityValue = options.chunkGranularity ?? appConfig.chunkGranularity;
+  let chunkGranularity: ChunkGranularityMap | undefined;
+  if (chunkGranularityValue !== undefined) {
+    chunkGranularity = parseChunkGranularity(chunkGranularityValue);
+    const enabledLanguages = new Set<string>(parseLanguageNames(languages));
+    for (const [language, mode] of Object.entries(chunkGranularity)) {
+      if (!enabledLanguages.has(language)) {
+        logger.warn(`Chunk granularity sets "${language}" to "${mode}", but "${language}" is not enabled.`);
+      }
+    }
+  }
+
+  if (options.includeKinds !== undefined && options.excludeKinds !== undefined) {
+    throw new Error('--include-kinds cannot be combined with --exclude-kinds.');
+  }
+  let symbolKinds: SymbolKindFilter | undefined;
+  if (options.includeKinds !== undefined) {
+    symbolKinds = { include: parseSymbolKinds('--include-kinds', options.includeKinds) };
+  } else if (options.excludeKinds !== undefined) {
+    symbolKinds = { exclude: parseSymbolKinds('--exclude-kinds', options.excludeKinds) };
+  }
+
+  // Read once, before anything else can consume standard input.
+  let listedFiles: string[] | undefined;
+  if (options.filesFrom !== undefined) {
+    try {
+      listedFiles = readFileList(options.filesFrom);
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      throw new Error(`Could not read --files-from ${options.filesFrom}: ${message}`);
+    }
+    if (listedFiles.length === 0) {
+      logger.warn(`--files-from ${options.filesFrom} lists no files.`);
+    }
+  }
+
+  // Waited for before the embedding provider is probed, which can be a model deployed in the cluster.
+  if (!options.dryRun) {
+    const version = await waitForElasticsearch({ retries: esConnectRetries, timeoutMs: esConnectTimeoutMs });
+    logger.info(`Connected to Elasticsearch ${version}.`);
+  }
+
+  // Only the embedded text is normalized; the flags turn on the transforms of their variables.
+  if (options.stripComments) {
+    embeddingConfig.stripComments = true;
+  }
+  if (options.stripLicense) {
+    embeddingConfig.stripLicense = true;
+  }
+  // Read once up front, so a bad SCS_IDXR_EMBED_HEADER_TEMPLATE fails before any batch is embedded.
+  getEmbeddingTextOptions();
+
+  // Vectors are computed by the ingest pipeline unless an external provider is selected, which is
+  // validated here so a bad URL or model fails before any repository is processed.
+  // Shared by every embedding request of the run, so the provider's quotas hold across workers.
+  const embeddingRateLimiter =
+    options.embedRpm !== undefined || options.embedTpm !== undefined
+      ? new RateLimiter({
+          requestsPerMinute:
+            options.embedRpm !== undefined ? parsePositiveInt('embed-rpm', options.embedRpm, 0) : undefined,
+          tokensPerMinute:
+            options.embedTpm !== undefined ? parsePositiveInt('embed-tpm', options.embedTpm, 0) : undefined,
+        })
+      : undefined;
+  let embeddingProvider: EmbeddingProvider | undefined;
+  if (embeddingProviderName !== 'elasticsearch') {
+    embeddingProvider = createEmbeddingProvider({
+      provider: embeddingProviderName,
+      url: options.embeddingUrl,
+      model: options.embeddingModel,
+      // --embedding-batch-size supersedes its --embed-batch-size alias.
+      batchSize:
+        options.embeddingBatchSize !== undefined
+          ? parsePositiveInt('embedding-batch-size', options.embeddingBatchSize, DEFAULT_EMBEDDING_BATCH_SIZE)
+          : parsePositiveInt('embed-batch-size', options.embedBatchSize, DEFAULT_EMBEDDING_BATCH_SIZE),
+      concurrency: parsePositiveInt(
+        'embedding-concurrency',
+        options.embeddingConcurrency,
+        DEFAULT_EMBEDDING_CONCURRENCY
+      ),
+      rateLimiter: embeddingRateLimiter,
+      dimensions: embeddingDims,
+    });
+    // A dry run never embeds, so it does not need the endpoint to be reachable.
+    if (!options.dryRun) {
+      await validateEmbeddingProvider(embeddingProvider);
+    }
+    logger.info(
+      `Using the ${embeddingProviderName} embedding provider (${embeddingProvider.dimensions} dimensions).`
+    );
+  } else if (options.embeddingUrl !== undefined || options.embeddingModel !== undefined) {
+    throw new Error('--embedding-url and --embedding-model require --embedding-provider http, openai or cohere.');
+  } else if (options.embedRpm !== undefined || options.embedTpm !== undefined) {
+    throw new Error('--embed-rpm and --embed-tpm require --embedding-provider http, openai or cohere.');
+  } else if (typeof options.embeddingCache === 'string') {
+    throw new Error('--embedding-cache requires --embedding-provider http, openai or cohere.');
+  } else if (options.embedCacheDir !== undefined) {
+    throw new Error('--embed-cache-dir requires --embedding-provider http, openai or cohere.');
+  }
+  // Checked before any index is created, since Elasticsearch would only reject the mapping then.
+  if (options.vectorQuantization !== undefined) {
+    assertVectorQuantizationDims(
+      options.vectorQuantization as VectorQuantization,
+      embeddingProvider?.dimensions ?? elasticsearchConfig.denseVectorDims,
+      embeddingProvider ? 'the embedding provider produces' : 'SCS_IDXR_DENSE_VECTOR_DIMS is set to'
+    );
+  }
+  // Chunks embedded by earlier runs, including failed ones, are served from the cache. Wrapped after
+  // validation, so the probe still reaches the endpoint.
+  let embeddingCache: EmbeddingCache | undefined;
+  if (options.embedCache === false && typeof options.embeddingCache === 'string') {
+    throw new Error('--no-embed-cache cannot be combined with --embedding-cache.');
+  }
+  if (options.embedCacheDir !== undefined && (options.embeddingCache !== undefined || options.embedCache === false)) {
+    const flag =
+      options.embeddingCache === false
+        ? '--no-embedding-cache'
+        : options.embedCache === false
+          ? '--no-embed-cache'
+          : '--embedding-cache';
+    throw new Error(`--embed-cache-dir cannot be combined with ${flag}.`);
+  }
+  if (embeddingProvider && options.embeddingCache !== false && options.embedCache !== false && !options.dryRun) {
+    const cachePath =
+      options.embeddingCache ??
+      (options.embedCacheDir
+        ? path.join(options.embedCacheDir, EMBEDDING_CACHE_FILE_NAME)
+        : getDefaultEmbeddingCachePath());
+    embeddingCache = new EmbeddingCache(path.resolve(cachePath));
+    const modelKey = getEmbeddingModelKey(embeddingProviderName, embeddingProvider, options.embeddingUrl);
+    embeddingProvider = new CachedEmbeddingProvider(embeddingProvider, embeddingCache, modelKey);
+    logger.info(`Caching embeddings in ${embeddingCache.dbPath}.`);
+  }
+  // The ingest pipeline embeds inside the bulk request, so there is no embedding stage to separate.
+  if (!embeddingProvider && (options.embedConcurrency !== undefined || options.indexConcurrency !== undefined)) {
+    throw new Error('--embed-concurrency and --index-concurrency require --embedding-provider http, openai or cohere.');
+  }
+  const embedConcurrency =
+    options.embedConcurrency !== undefined
+      ? parsePositiveInt('embed-concurrency', options.embedConcurrency, concurrency)
+      : undefined;
+  const indexConcurrency =
+    options.indexConcurrency !== undefined
+      ? parsePositiveInt('index-concurrency', options.indexConcurrency, concurrency)
+      : undefined;
+  async function swapRebuiltIndex(indexName: string, generationName: string): Promise<void> {
+    const { swapIndexAlias } = await import('../utils/elasticsearch');
+    const oldIndices = await swapIndexAlias(indexName, generationName, { keepOldIndices, readAlias });
+    logger.info(`Alias "${indexName}" now points to "${generationName}".`);
+    if (oldIndices.length > 0 && keepOldIndices === undefined) {
+      logger.info(
+        `Kept the previous indices ${oldIndices.join(', ')}. Use --keep-old-indices or --delete-old-indices to ` +
+          'delete them.'
+      );
+    }
+  }
+
+  // Indexes are created, or checked against this run's embeddings, before any repository is enqueued.
+  // A --clean run builds new generations with the current mappings instead.
+  if (!options.dryRun && !options.clean) {
+    for (const indexName of new Set(repoConfigs.map((config) => config.indexName))) {
+      await createIndex(indexName, {
+        vectorDims: embeddingProvider?.dimensions,
+        storeCompressed: options.storeCompressed,
+      });
+    }
+  }
+
+  if (options.pauseFile !== undefined) {
+    const pauseFile = path.resolve(options.pauseFile);
+    setPauseFile(pauseFile);
+    logger.info(`Indexing pauses while ${pauseFile} exists.`);
+  }
+
+  // Started before any instrument is created, so every metric of the run is recorded for it.
+  if (metricsPort !== undefined) {
+    await startMetricsServer(metricsPort);
+    logger.info(`Serving Prometheus metrics on http://localhost:${metricsPort}/metrics`);
+  }
+
+  const isSingleRepo = repoConfigs.length === 1;
   const failedRepos: string[] = [];
+  // A --clean run rebuilds each index into one new generation, shared by the repositories indexed into
+  // it. The value is undefined for indexes that did not exist yet and are built in place.
+  const rebuildIndexes = new Map<string, string | undefined>();
+  const repoSummaries: ProgressEvent[] = [];
+  const skippedFiles: Array<SkippedFile & { repo: string }> = [];
+  const parseFailures: Array<ParseFailure & { repo: string }> = [];
+  const chunkOverflowFiles: Array<ChunkOverflowFile & { repo: string }> = [];
+  const dryRunReports: DryRunReport[] = [];
+  const symbolWriter = options.exportSymbols !== undefined ? new SymbolExportWriter(options.exportSymbols) : undefined;
 
-  for (let i = 0; i < repoArgs.length; i++) {
-    const repoArg = repoArgs[i];
-    const config = parseRepoArg(repoArg, options.branch);
+  for (let i = 0; i < repoConfigs.length; i++) {
+    const config = repoConfigs[i];
     const isFirstRepo = i === 0;
     const shouldWatch = options.watch && isFirstRepo;
+    // With --archive-ref, the repository is read from `git archive` of the ref, like an archive.
+    const isArchive = isArchivePath(config.repoPath) || options.archiveRef !== undefined;
+    // Only the listed files or archive entries are indexed, so the last indexed commit is left as it is.
+    const isListedRun = isArchive || listedFiles !== undefined;
+
+    // The signal handler exits once in-flight work is drained; do not start on another repository.
+    if (isShutdownRequested()) {
+      logger.warn(`Shutdown requested, skipping ${repoConfigs.length - i} remaining repositories.`);
+      return;
+    }
 
     logger.info(`--- Processing repository: ${config.repoName} ---`);
 
     // Step 1: Clone if it's a URL and doesn't exist
-    if (repoArg.includes('://') || repoArg.startsWith('git@')) {
+    if (isRemoteRepoSpec(config.repoUrl)) {
       try {
         await ensureRepoCloned(config.repoUrl, config.repoPath, githubToken);
       } catch (error) {
@@ -300,7 +1096,9 @@ async function indexRepos(
 
     // Step 4: Determine git branch
     let gitBranch = config.branch;
-    if (!gitBranch) {
+    if (!gitBranch && isArchive) {
+      gitBranch = options.archiveRef ?? 'unknown';
+    } else if (!gitBranch) {
       try {
         gitBranch = execFileSync('git', ['rev-parse', '--abbrev-ref', 'HEAD'], {
           cwd: config.repoPath,
@@ -313,33 +1111,138 @@ async function indexRepos(
       }
     }
 
+    // A dry run stops after chunking: no queue, no embeddings and no Elasticsearch requests.
+    if (options.dryRun) {
+      try {
+        dryRunReports.push(
+          await dryRun(config.repoPath, {
+            repoName: config.repoName,
+            branch: gitBranch,
+            enqueueConcurrency,
+            maxWorkerRestarts,
+            languages,
+            extensionMap,
+            ignorePath: options.ignorePath,
+            excludePatterns: options.exclude,
+            includePatterns: options.include,
+            useIgnoreFiles: options.ignoreFiles,
+            chunkOverlapLines,
+            embedDocComments: options.embedDocs ?? false,
+            embedContext: options.embedContext ?? false,
+            maxChunkTokens,
+            previewLines,
+            tokenizer,
+            chunkGranularity,
+            symbolKinds,
+            maxFileSize,
+            dedup: options.dedup ?? true,
+            storeCompressed: options.storeCompressed ?? false,
+            storeContent: options.storeContent ?? true,
+            sample,
+            wholeFileFallback: options.wholeFileFallback ?? false,
+            maxChunksPerFile,
+            chunkOverflow,
+            symbolWriter,
+          })
+        );
+      } catch (error) {
+        logger.error(`Dry run failed for ${config.repoName}.`, {
+          error: error instanceof Error ? error.message : String(error),
+        });
+        if (isSingleRepo) {
+          throw error;
+        }
+        failedRepos.push(config.repoName);
+      }
+      continue;
+    }
+
     const queueDir = path.join(appConfig.queueBaseDir, config.repoName);
+    const queueShards = resolveQueueShardCount(queueDir, requestedQueueShards, options.clean ?? false);
+    const progress = new ProgressReporter({
+      format: progressFormat,
+      repoName: config.repoName,
+      branch: gitBranch,
+      intervalMs: progressIntervalMs,
+      getEmbeddingUtilization: embeddingRateLimiter && (() => embeddingRateLimiter.utilization()),
+    });
 
     const producerOptions = {
       queueDir,
+      queueShards,
       elasticsearchIndex: config.indexName,
       repoName: config.repoName,
+      repoUrl: config.url ?? getPublicRepoUrl(config.repoUrl),
+      repoRef: config.ref,
       branch: gitBranch,
-      parseConcurrency,
+      enqueueConcurrency,
+      maxWorkerRestarts,
       languages,
+      extensionMap,
+      ignorePath: options.ignorePath,
+      excludePatterns: options.exclude,
+      includePatterns: options.include,
+      useIgnoreFiles: options.ignoreFiles,
+      manifestPath: resolveManifestPath(options.manifest, queueDir),
+      chunkOverlapLines,
+      maxChunkTokens,
+      previewLines,
+      tokenizer,
+      chunkGranularity,
+      symbolKinds,
+      maxFileSize,
+      embedDocComments: options.embedDocs ?? false,
+      embedContext: options.embedContext ?? false,
+      force: options.force ?? false,
+      vectorDims: embeddingProvider?.dimensions,
+      files: listedFiles,
+      sample,
+      wholeFileFallback: options.wholeFileFallback ?? false,
+      maxChunksPerFile,
+      chunkOverflow,
+      withBlame: options.withBlame ?? false,
+      failOnParseError: options.failOnParseError ?? false,
+      progress,
     };
+    const produce = (clean: boolean, produceOptions: typeof producerOptions) =>
+      isArchive
+        ? indexArchive(config.repoPath, clean, { ...produceOptions, archiveRef: options.archiveRef })
+        : indexRepo(config.repoPath, clean, produceOptions);
     const incrementalOptions = {
       ...producerOptions,
       deleteDocumentsPageSize,
+      since: options.since,
+      until: options.until,
+      prune: options.prune ?? false,
+      pruneDryRun: options.pruneDryRun ?? false,
+      smartIncremental: options.smartIncremental ?? false,
+      dedup: options.dedup ?? true,
     };
     const workerOptions = {
       queueDir,
+      queueShards,
       elasticsearchIndex: config.indexName,
       repoName: config.repoName,
       branch: gitBranch,
       batchSize,
+      bulkMinSize,
+      bulkMaxSize,
+      maxAttempts,
+      progress,
+      embeddingProvider,
+      embedConcurrency,
+      indexConcurrency,
+      dedup: options.dedup ?? true,
+      storeCompressed: options.storeCompressed ?? false,
+      storeContent: options.storeContent ?? true,
     };
 
     try {
-      const { getLastIndexedCommit, updateLastIndexedCommit, createSettingsIndex } = await import(
+      const { getLastIndexedCommit, updateLastIndexedCommit, createSettingsIndex, createRebuildIndex } = await import(
         '../utils/elasticsearch'
       );
-      const lastCommitHashAtStart = await getLastIndexedCommit(gitBranch, config.indexName);
+      let rebuildIndex: string | undefined;
+      const lastCommitHashAtStart = await getLastIndexedCommit(gitBranch, config.indexName, config.repoName);
       let isResumingQueue = false;
       let enqueueCommitHashFromQueue: string | null = null;
 
@@ -347,34 +1250,57 @@ async function indexRepos(
       if (options.clean) {
         // Full clean reindex
         logger.info(`Running clean reindex for ${config.repoName}...`);
-        await indexRepo(config.repoPath, true, producerOptions);
+        if (!rebuildIndexes.has(config.indexName)) {
+          rebuildIndexes.set(
+            config.indexName,
+            await createRebuildIndex(config.indexName, { vectorDims: embeddingProvider?.dimensions })
+          );
+        }
+        rebuildIndex = rebuildIndexes.get(config.indexName);
+        await produce(true, {
+          ...producerOptions,
+          elasticsearchIndex: rebuildIndex ?? config.indexName,
+        });
       } else if (hasQueueItems(config.repoName)) {
         // Queue has items - check if enqueue was completed
-        const queueDbPath = path.join(appConfig.queueBaseDir, config.repoName, 'queue.db');
-        const { SqliteQueue } = await import('../utils/sqlite_queue');
-        const queue = new SqliteQueue({
-          dbPath: queueDbPath,
-          repoName: config.repoName,
-          branch: gitBranch,
-        });
-        await queue.initialize();
+        // Each shard of a sharded queue resumes on its own; its counts are summed.
+        const queue = await openQueue(queueDir, { repoName: config.repoName, branch: gitBranch, shards: queueShards });
         enqueueCommitHashFromQueue = queue.getEnqueueCommitHash();
+        const resumeStatus = `${queue.getRemainingCount()} pending, ${queue.getCompletedCount()} done`;
 
-        if (!queue.isEnqueueCompleted()) {
+        if (!queue.isEnqueueCompleted() && options.resume) {
+          // Interrupted during enqueue - continue the walk, skipping files already in the queue
+          logger.info(`Queue has pending items but enqueue was not completed for ${config.repoName}.`);
+          logger.info(`Resumed ${config.repoName} with ${resumeStatus}.`);
+          logger.info(`Resuming interrupted enqueue...`);
+          await produce(false, { ...producerOptions, resume: true });
+        } else if (!queue.isEnqueueCompleted()) {
           // Queue has items but enqueue was not completed - interrupted during enqueue
-          // Clear and re-enqueue (enqueue is fast, no need for deduplication complexity)
+          // Without --resume, clear and re-enqueue (enqueue is fast compared to indexing)
           logger.info(`Queue has pending items but enqueue was not completed for ${config.repoName}.`);
-          logger.info(`Clearing partial queue and re-enqueueing from scratch...`);
+          logger.info(`Clearing partial queue and re-enqueueing from scratch (use --resume to continue instead)...`);
           await queue.clear();
-          await indexRepo(config.repoPath, false, producerOptions);
+          await produce(false, producerOptions);
         } else {
           // Normal resume - enqueue completed, just process the queue
           logger.info(`Queue has pending items for ${config.repoName}. Resuming...`);
+          logger.info(`Resumed ${config.repoName} with ${resumeStatus}.`);
           isResumingQueue = true;
         }
       } else {
+        if (options.resume) {
+          logger.info(`Nothing to resume for ${config.repoName}: the queue is empty. Starting fresh...`);
+        }
         // Queue is empty - try incremental, fall back to full index if no previous commit
-        if (lastCommitHashAtStart) {
+        if (isListedRun) {
+          // Unchanged files are skipped by their content hash instead of a diff
+          logger.info(`Running full index of the ${isArchive ? 'archive' : 'listed files'} for ${config.repoName}...`);
+          await produce(false, producerOptions);
+        } else if (options.since) {
+          // Explicit base ref - incremental falls back to a full index if the ref cannot be used
+          logger.info(`Running incremental index for ${config.repoName} since ${options.since}...`);
+          await incrementalIndex(config.repoPath, incrementalOptions);
+        } else if (lastCommitHashAtStart) {
           // Previous index exists - do incremental
           logger.info(`Running incremental index for ${config.repoName}...`);
           await incrementalIndex(config.repoPath, incrementalOptions);
@@ -385,16 +1311,47 @@ async function indexRepos(
         }
       }
 
+      if (isShutdownRequested()) {
+        logger.warn(`Shutdown requested, left the queue for ${config.repoName} to the next run.`);
+        return;
+      }
+
+      // An index updated in place is searchable through the read alias right away; a rebuilt
+      // generation joins it when the aliases are swapped.
+      if (readAlias && !rebuildIndex) {
+        const { addReadAlias } = await import('../utils/elasticsearch');
+        await addReadAlias(config.indexName, readAlias);
+      }
+
       // Step 6: Run worker
+      if (rebuildIndex && shouldWatch) {
+        // The watched worker never returns, so the rebuild is completed before watching the alias.
+        await worker(concurrency, false, { ...workerOptions, elasticsearchIndex: rebuildIndex });
+        await swapRebuiltIndex(config.indexName, rebuildIndex);
+        rebuildIndexes.set(config.indexName, undefined);
+      }
       if (shouldWatch) {
         logger.info(`Running worker for ${config.repoName} with concurrency ${concurrency} (watch mode enabled)...`);
         logger.info(`Watching queue for ${config.repoName}. Worker will continue running...`);
       } else {
         logger.info(`Running worker for ${config.repoName} with concurrency ${concurrency}...`);
       }
-      await worker(concurrency, shouldWatch, workerOptions);
+      await worker(
+        concurrency,
+        shouldWatch,
+        rebuildIndex && !shouldWatch ? { ...workerOptions, elasticsearchIndex: rebuildIndex } : workerOptions
+      );
 
-      if (!shouldWatch) {
+      // A drained worker leaves documents in the queue, so the last indexed commit must not advance.
+      if (isShutdownRequested()) {
+        logger.warn(`Shutdown requested, not updating the last indexed commit for ${config.repoName}.`);
+        return;
+      }
+
+      if (isListedRun || sample) {
+        const indexed = isListedRun ? 'listed files were' : 'a sample of the files was';
+        logger.info(`Left the last indexed commit of ${config.repoName} unchanged, since only ${indexed} indexed.`);
+      } else if (!shouldWatch) {
         // Step 7: If we resumed an existing queue, ensure we catch up to current HEAD before
         // advancing the settings commit hash. Otherwise incremental diffing can be skipped on
         // subsequent runs (settings would incorrectly claim we've indexed to HEAD).
@@ -427,7 +1384,7 @@ async function indexRepos(
             // If settings commit was missing but we have a queue baseline, persist it so incrementalIndex can run.
             if (!lastCommitHashAtStart && enqueueCommitHashFromQueue) {
               await createSettingsIndex(config.indexName);
-              await updateLastIndexedCommit(gitBranch, enqueueCommitHashFromQueue, config.indexName);
+              await updateLastIndexedCommit(gitBranch, enqueueCommitHashFromQueue, config.indexName, config.repoName);
             }
 
             logger.info(
@@ -441,13 +1398,44 @@ async function indexRepos(
         // Step 8: Update last indexed commit after all indexing work completes successfully.
         try {
           await createSettingsIndex(config.indexName);
-          await updateLastIndexedCommit(gitBranch, currentHead, config.indexName);
+          await updateLastIndexedCommit(gitBranch, currentHead, config.indexName, config.repoName);
           logger.info(`Updated last indexed commit to ${currentHead} for branch ${gitBranch}`);
         } catch (error) {
           logger.warn(`Failed to update last indexed commit: ${error instanceof Error ? error.message : error}`);
         }
       }
 
+      if (options.watchFiles) {
+        // Step 9: Re-index files as they are saved, until shutdown drains the worker.
+        const fileWatcher = await watchFiles(config.repoPath, {
+          ...incrementalOptions,
+          // A file saved mid-edit must not stop the watcher; it is parsed again on its next save.
+          failOnParseError: false,
+          debounceMs,
+        });
+        logger.info(`Watching ${config.repoName} for file changes. Worker will continue running...`);
+        try {
+          await worker(concurrency, true, workerOptions);
+        } finally {
+          await fileWatcher.close();
+        }
+      }
+
+      if (options.events) {
+        // Step 10: Apply change events in order, each indexed and its commit recorded before the next one.
+        await consumeChangeEvents(
+          config.repoPath,
+          {
+            ...incrementalOptions,
+            failOnParseError: false,
+            source: options.events,
+            pull: options.pull,
+            githubToken,
+          },
+          () => worker(concurrency, false, workerOptions)
+        );
+      }
+
       logger.info(`--- Finished processing for: ${config.repoName} ---`);
     } catch (error: unknown) {
       const errorMessage = error instanceof Error ? error.message : 'An unknown error occurred';
@@ -460,13 +1448,54 @@ async function indexRepos(
         throw error;
       }
       failedRepos.push(config.repoName);
+    } finally {
+      progress.stop();
+      repoSummaries.push(progress.snapshot());
+      skippedFiles.push(...progress.skippedFiles.map((skipped) => ({ repo: config.repoName, ...skipped })));
+      parseFailures.push(...progress.parseFailures.map((failure) => ({ repo: config.repoName, ...failure })));
+      chunkOverflowFiles.push(...progress.chunkOverflowFiles.map((file) => ({ repo: config.repoName, ...file })));
+    }
+  }
+
+  // Searches keep hitting the previous generation until every repository was indexed into the new one.
+  for (const [indexName, generationName] of rebuildIndexes) {
+    if (generationName) {
+      await swapRebuiltIndex(indexName, generationName);
     }
   }
 
   logger.info('All repositories processed.');
+  if (repoSummaries.length > 1) {
+    for (const summary of repoSummaries) {
+      logger.info(
+        `Summary for ${summary.repo}: ${summary.filesEnqueued} files enqueued, ${summary.filesFailed} failed, ` +
+          `${summary.chunksIndexed} chunks indexed`
+      );
+    }
+  }
+  setLogPhase('done');
+  if (symbolWriter) {
+    await symbolWriter.close();
+    logger.info(`Exported ${symbolWriter.symbols} symbols to ${symbolWriter.filePath}`);
+  }
+  if (options.dryRun) {
+    reportDryRun(dryRunReports, options.json ?? false);
+  } else {
+    logRunSummary(
+      repoSummaries,
+      skippedFiles,
+      parseFailures,
+      chunkOverflowFiles,
+      Date.now() - startedAt,
+      embeddingCache?.stats()
+    );
+  }
+  embeddingCache?.close();
 
   // Flush OpenTelemetry logs before exiting
-  await shutdown();
+  if (!options.serve) {
+    await shutdown();
+  }
 
   // Set exit code if any repo setup failures occurred (multi-repo mode only)
   if (failedRepos.length > 0) {
@@ -475,48 +1504,228 @@ async function indexRepos(
   }
 }
 
+/** Paths listed in a skipped-files or ignored-paths warning before the rest are only counted. */
+const MAX_LISTED_PATHS = 20;
+
+/**
+ * Logs the totals of a run, with its wall-clock time split by phase, the files skipped for their
+ * size or binary content, the files that failed to parse or went over `--max-chunks-per-file` and the
+ * hit rate of the embedding cache.
+ */
+function logRunSummary(
+  summaries: ProgressEvent[],
+  skippedFiles: Array<SkippedFile & { repo: string }>,
+  parseFailures: Array<ParseFailure & { repo: string }>,
+  chunkOverflowFiles: Array<ChunkOverflowFile & { repo: string }>,
+  totalMs: number,
+  embeddingCache?: EmbeddingCacheStats
+): void {
+  const sum = (pick: (summary: ProgressEvent) => number) =>
+    summaries.reduce((total, summary) => total + pick(summary), 0);
+  const files = sum((summary) => summary.filesEnqueued);
+  const filesFailed = sum((summary) => summary.filesFailed);
+  if (skippedFiles.length > 0 && appConfig.logFormat !== 'json') {
+    const listed = skippedFiles
+      .slice(0, MAX_LISTED_PATHS)
+      .map((skipped) => `${skipped.file} (${skipped.size} bytes, ${skipped.reason})`)
+      .join(', ');
+    const more = skippedFiles.length - MAX_LISTED_PATHS;
+    logger.warn(`Skipped ${skippedFiles.length} files: ${listed}` + (more > 0 ? ` and ${more} more` : ''));
+  }
+  if (parseFailures.length > 0 && appConfig.logFormat !== 'json') {
+    logger.warn(`Failed to parse ${parseFailures.length} files: ${formatParseFailures(parseFailures)}`);
+  }
+  if (chunkOverflowFiles.length > 0 && appConfig.logFormat !== 'json') {
+    logger.warn(
+      `${chunkOverflowFiles.length} files went over --max-chunks-per-file: ${formatChunkOverflows(chunkOverflowFiles)}`
+    );
+  }
+  const chunksIndexed = sum((summary) => summary.chunksIndexed);
+  const chunksDeduplicated = sum((summary) => summary.chunksDeduplicated);
+  const versionConflicts = sum((summary) => summary.chunksVersionConflicts);
+  const bytes = sum((summary) => summary.bytesProduced);
+  const wallClockMs = {
+    total: totalMs,
+    enqueue: sum((summary) => summary.phaseMs.enqueue),
+    embed: sum((summary) => summary.phaseMs.embed),
+    bulk: sum((summary) => summary.phaseMs.bulk),
+  };
+  const phases = (['enqueue', 'embed', 'bulk'] as const)
+    .map((phase) => `${phase} ${formatDuration(wallClockMs[phase] / 1000)}`)
+    .join(', ');
+  const embeddingLookups = embeddingCache ? embeddingCache.hits + embeddingCache.misses : 0;
+  const embeddingCacheHitRate =
+    embeddingCache && embeddingLookups > 0 ? Math.round((embeddingCache.hits / embeddingLookups) * 100) : 0;
+  const cached = embeddingCache
+    ? `, embedding cache hit ${embeddingCache.hits} of ${embeddingLookups} chunks (${embeddingCacheHitRate}%)`
+    : '';
+  // Locations another run wrote from a later read of the file, kept instead of this run's.
+  const conflicts = versionConflicts > 0 ? `, ${versionConflicts} version conflicts` : '';
+  logger.info(
+    `Run summary: ${files} files enqueued (${filesFailed} failed, ${skippedFiles.length} skipped), ` +
+      `${chunksIndexed} chunks indexed (${chunksDeduplicated} deduplicated${conflicts}), ${bytes} bytes in ` +
+      `${formatDuration(totalMs / 1000)} (${phases})${cached}`,
+    {
+      type: 'summary',
+      files,
+      filesFailed,
+      filesSkipped: skippedFiles.length,
+      skippedFiles,
+      parseFailures,
+      chunkOverflowFiles,
+      chunksProduced: sum((summary) => summary.chunksProduced),
+      chunksIndexed,
+      chunksDeduplicated,
+      versionConflicts,
+      bytes,
+      wallClockMs,
+      ...(embeddingCache ? { embeddingCache: { ...embeddingCache, hitRate: embeddingCacheHitRate } } : {}),
+    }
+  );
+}
+
+/** Prints what a dry run found, as log lines or as a single JSON line on stdout. */
+function reportDryRun(reports: DryRunReport[], json: boolean): void {
+  if (json) {
+    process.stdout.write(`${JSON.stringify({ type: 'dry_run', repositories: reports })}\n`);
+    return;
+  }
+  for (const report of reports) {
+    for (const failed of report.failedFiles) {
+      const fallback = failed.wholeFile ? ' and index it as a whole file' : '';
+      logger.warn(`Dry run: ${report.repo} would fail to parse ${failed.file}${fallback}.`, { error: failed.error });
+    }
+    if (report.chunkOverflowFiles.length > 0) {
+      logger.warn(
+        `Dry run: ${report.repo} has ${report.chunkOverflowFiles.length} files over --max-chunks-per-file: ` +
+          formatChunkOverflows(report.chunkOverflowFiles)
+      );
+    }
+    if (report.ignoredPaths.length > 0) {
+      const listed = report.ignoredPaths.slice(0, MAX_LISTED_PATHS).join(', ');
+      const more = report.ignoredPaths.length - MAX_LISTED_PATHS;
+      logger.warn(
+        `Dry run: ${report.repo} would skip ${report.ignoredPaths.length} paths matched by ignore rules: ${listed}` +
+          (more > 0 ? ` and ${more} more` : '')
+      );
+    }
+    for (const skipped of report.skippedFiles) {
+      logger.warn(`Dry run: ${report.repo} would skip ${skipped.file} (${skipped.size} bytes, ${skipped.reason}).`);
+    }
+    logger.info(
+      `Dry run for ${report.repo} (${report.branch}): ${report.files} files, ${report.chunks} chunks ` +
+        `(${report.uniqueChunks} unique), ~${report.estimatedEmbeddingTokens} embedding tokens, ` +
+        `~${report.estimatedDocumentBytes} bytes of documents, ${report.failedFiles.length} files failed to parse`
+    );
+    if (report.chunks > 0) {
+      logger.info(`Dry run: ${report.repo} chunks by language: ${formatCounts(report.chunksByLanguage)}`);
+      logger.info(`Dry run: ${report.repo} chunks by kind: ${formatCounts(report.chunksByKind)}`);
+      const largest = report.largestChunks
+        .map((chunk) => {
+          const type = chunk.kind ? `${chunk.language} ${chunk.kind}` : chunk.language;
+          const lines = `${chunk.file}:${chunk.startLine}-${chunk.endLine}`;
+          return `${lines} (${type}, ~${chunk.estimatedTokens} tokens, ${chunk.bytes} bytes)`;
+        })
+        .join(', ');
+      logger.info(`Dry run: ${report.repo} largest chunks: ${largest}`);
+    }
+  }
+}
+
+/** Formats counts as `a 10, b 3`, largest first. */
+function formatCounts(counts: Record<string, number>): string {
+  return Object.entries(counts)
+    .sort(([nameA, countA], [nameB, countB]) => countB - countA || nameA.localeCompare(nameB))
+    .map(([name, count]) => `${name} ${count}`)
+    .join(', ');
+}
+
 export const indexCommand = new Command('index')
   .description('Index one or more repositories')
   .argument('[repos...]', 'Repository names, paths, or URLs (format: repo[:index]).')
-  .addOption(new Option('--clean', 'Delete index and reindex all files (full rebuild)'))
+  .addOption(
+    new Option('--repo <path=name>', 'Repository path or URL with an explicit name (repeatable)').argParser(
+      (value: string, previous?: string[]) => [...(previous ?? []), value]
+    )
+  )
+  .addOption(new Option('--repos-file <file>', 'JSON file listing repositories to index (see README)'))
+  .addOption(
+    new Option('--index <name>', 'Index every repository into this shared Elasticsearch index (overrides :index)')
+  )
+  .addOption(
+    new Option('--clean', 'Rebuild the index into a new generation and swap its alias when done (full rebuild)')
+  )
+  .addOption(
+    new Option('--delete-old-indices', 'With --clean, delete the index generations the alias pointed to before')
+  )
+  .addOption(
+    new Option('--keep-old-indices <number>', 'With --clean, keep this many previous generations and delete older ones')
+  )
   .addOption(new Option('--pull', 'Git pull before indexing'))
+  .addOption(new Option('--watch', 'Keep worker running after processing queue'))
   .addOption(
     new Option(
-      '--github-token <token>',
-      'GitHub token for cloning/pulling private repositories (overrides GITHUB_TOKEN)'
+      '--files-from <file>',
+      'Index the files listed in <file>, one path per line, instead of walking the repository ("-" reads stdin)'
     )
   )
-  .addOption(new Option('--watch', 'Keep worker running after processing queue'))
   .addOption(
-    new Option('--concurrency <number>', 'Number of concurrent Elasticsearch indexing worker threads').default('2')
+    new Option('--archive-ref <git-ref>', 'Index <git-ref> from "git archive" instead of the checked-out working tree')
   )
-  .addOption(new Option('--batch-size <number>', 'Number of chunks per Elasticsearch bulk request').default('100'))
   .addOption(
     new Option(
-      '--delete-documents-page-size <number>',
-      'PIT pagination size for incremental deletion scans (locations index)'
-    ).default('500')
+      '--since <git-ref>',
+      'Only index files changed between <git-ref> and HEAD (falls back to a full index if the ref is unusable)'
+    )
   )
   .addOption(
-    new Option('--parse-concurrency <number>', 'Number of concurrent file-parsing worker threads').default(
-      `${DEFAULT_PARSE_CONCURRENCY}`
+    new Option(
+      '--until <git-ref>',
+      'With --since, the ref to diff up to; must be the checked-out commit (default: HEAD)'
     )
   )
   .addOption(
     new Option(
-      '--languages <names>',
-      'Comma-separated list of languages to index (default: SCS_IDXR_LANGUAGES if set, otherwise all languages)'
+      '--manifest [path]',
+      'Write a manifest of enqueued files with content hashes (default path: <queue dir>/manifest.json)'
     )
   )
-  .addOption(new Option('--branch <branch>', 'Branch name for logging/metadata (default: auto-detect)'))
-  .action(async (repos, options) => {
-    try {
-      await indexRepos(repos, options);
-    } catch (error) {
-      logger.error('Fatal error in index command', { error });
-      await shutdown();
-      throw error;
-    }
-  });
+  .addOption(
+    new Option('--limit <number>', 'Index at most this many files, the first ones by sorted path (for smoke tests)')
+  )
+  .addOption(
+    new Option('--sample-rate <fraction>', 'Index a reproducible random fraction of the files, from 0 to 1 (e.g. 0.1)')
+  )
+  .addOption(new Option('--sample-seed <number>', 'With --sample-rate, the seed of the sample (default: 0)'))
+  .addOption(
+    new Option('--fail-on-parse-error', 'Fail the run after the enqueue when any file failed to parse (for strict CI)')
+  )
+  .addOption(new Option('--force', 'Re-index files even when their content hash matches the last indexed version'))
+  .addOption(
+    new Option(
+      '--resume',
+      'Continue an interrupted enqueue, skipping files already in the queue, instead of re-enqueueing from scratch'
+    )
+  )
+  .addOption(
+    new Option('--dry-run', 'Walk, parse and chunk the repositories and report the totals without indexing anything')
+  )
+  .addOption(new Option('--json', 'With --dry-run, print the report as one JSON line on stdout'))
+  .addOption(
+    new Option(
+      '--export-symbols <file>',
+      'With --dry-run, write every parsed symbol with its location and references to this JSON Lines file'
+    )
+  );
+
+addIndexOptions(indexCommand).action(async (repos, options) => {
+  try {
+    await indexRepos(repos, options);
+  } catch (error) {
+    logger.error('Fatal error in index command', { error });
+    await shutdown();
+    throw error;
+  }
+});
 
 export { indexRepos };
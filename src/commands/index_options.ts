@@ -0,0 +1,341 @@
+import os from 'os';
+import { Command, Option } from 'commander';
+import { DEFAULT_PREVIEW_LINES } from '../utils/chunk_preview';
+import { DEFAULT_EMBEDDING_BATCH_SIZE, DEFAULT_EMBEDDING_CONCURRENCY } from '../utils/embedding_provider';
+import { DEFAULT_ES_CONNECT_RETRIES, DEFAULT_ES_CONNECT_TIMEOUT_MS } from '../utils/elasticsearch';
+import { DEFAULT_MAX_WORKER_RESTARTS } from '../utils/producer_pool';
+
+/** Default of `--parse-concurrency`: half the available cores, at least one. */
+export const DEFAULT_PARSE_CONCURRENCY = Math.max(
+  1,
+  Math.floor((typeof os.availableParallelism === 'function' ? os.availableParallelism() : os.cpus().length) / 2)
+);
+
+/**
+ * Adds the flags of an indexing run to `command`: how files are parsed, chunked, embedded and stored,
+ * and how the queue and the workers run. Every command that calls `indexRepos` (`index`, `watch`,
+ * `index:serve`, `index:events` and `export-symbols`) takes them, so its runs write documents of the same
+ * shape as `index`.
+ */
+export function addIndexOptions(command: Command): Command {
+  return command
+    .addOption(new Option('--repo-name <name>', 'Name of the repository, stamped on its documents as repo_name'))
+    .addOption(
+      new Option('--repo-url <url>', 'URL stamped on the documents as repo_url (default: the URL cloned from)')
+    )
+    .addOption(new Option('--repo-ref <ref>', 'Ref stamped on the locations as repo_ref, e.g. the tag being indexed'))
+    .addOption(new Option('--alias <name>', 'Read alias that follows the current generation of every indexed index'))
+    .addOption(
+      new Option(
+        '--github-token <token>',
+        'GitHub token for cloning/pulling private repositories (overrides GITHUB_TOKEN)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--workers <number>',
+        'Size of the indexing worker pool: bulk batches indexed in parallel and held in memory (default: 2)'
+      )
+    )
+    .addOption(
+      new Option('--concurrency <number>', 'Alias of --workers, used when --workers is not given').default('2')
+    )
+    .addOption(new Option('--batch-size <number>', 'Number of chunks per Elasticsearch bulk request').default('100'))
+    .addOption(
+      new Option(
+        '--bulk-max-size <number>',
+        'Largest bulk request size to grow back to after Elasticsearch 429s (default: --batch-size)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--bulk-min-size <number>',
+        'Smallest bulk request size to shrink to on Elasticsearch 429s (default: 10)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--delete-documents-page-size <number>',
+        'PIT pagination size for incremental deletion scans (locations index)'
+      ).default('500')
+    )
+    .addOption(
+      new Option(
+        '--max-attempts <number>',
+        'Indexing attempts per document before it is dead-lettered (default: SCS_IDXR_QUEUE_MAX_ATTEMPTS or 3)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--queue-shards <number>',
+        'SQLite files the queue is split into by file path, each drained by its own worker (default: 1)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--chunk-overlap-lines <number>',
+        'Lines of trailing context from the next sibling stored with each code chunk (default: 0)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--max-chunk-tokens <number>',
+        'Split code chunks above this many tokens into parts (default: SCS_IDXR_MAX_CHUNK_TOKENS or 0, off)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--preview-lines <number>',
+        `Lines of each chunk stored in its preview field for result lists (default: ${DEFAULT_PREVIEW_LINES})`
+      )
+    )
+    .addOption(
+      new Option('--with-blame', 'Store the date and author of the last commit changing each chunk (runs git blame)')
+    )
+    .addOption(
+      new Option(
+        '--tokenizer <name>',
+        'Count chunk tokens with bpe, whitespace or chars (default: bpe for openai, whitespace otherwise)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--max-file-size <bytes>',
+        'Skip files larger than this many bytes without reading them (default: SCS_IDXR_MAX_FILE_SIZE_BYTES or 2097152)'
+      )
+    )
+    .addOption(new Option('--embed-docs', 'Embed leading doc comments together with the code of each chunk'))
+    .addOption(
+      new Option('--embed-context', 'Embed the package, receiver type and imports of the file with each chunk')
+    )
+    .addOption(new Option('--strip-comments', 'Strip comments from the embedded text (SCS_IDXR_EMBED_STRIP_COMMENTS)'))
+    .addOption(
+      new Option('--strip-license', 'Strip license headers from the embedded text (SCS_IDXR_EMBED_STRIP_LICENSE)')
+    )
+    .addOption(
+      new Option(
+        '--chunk-granularity <language:mode,...>',
+        'Chunk whole files (file), per symbol (symbol) or per symbol with embedded doc comments (symbol+doc), ' +
+          'e.g. typescript:file,go:symbol (overrides SCS_IDXR_CHUNK_GRANULARITY, default: symbol)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--include-kinds <kinds>',
+        'Only chunk these symbol kinds: function, method, type, struct, interface, enum or variable (comma-separated)'
+      )
+    )
+    .addOption(
+      new Option('--exclude-kinds <kinds>', 'Do not chunk these symbol kinds (comma-separated, see --include-kinds)')
+    )
+    .addOption(
+      new Option(
+        '--embedding-provider <name>',
+        'Where code vectors are computed: elasticsearch (default, ingest pipeline), http, openai or cohere'
+      )
+    )
+    .addOption(new Option('--embed-provider <name>', 'Alias of --embedding-provider, used when it is not given'))
+    .addOption(
+      new Option('--embedding-url <url>', 'Embedding endpoint (required for http, overrides the openai/cohere API URL)')
+    )
+    .addOption(new Option('--embedding-model <name>', 'Model name sent to the embedding endpoint'))
+    .addOption(
+      new Option(
+        '--embedding-dims <number>',
+        'Dimensions of the embedding model, for the code_vector mapping (default: SCS_IDXR_DENSE_VECTOR_DIMS or 768)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--similarity <name>',
+        'Similarity of the code_vector mapping: cosine, dot_product or l2_norm (default: cosine)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--vector-quantization <type>',
+        "Quantization of the code_vector index: none, int8_hnsw, int4_hnsw or bbq_hnsw (default: Elasticsearch's)"
+      )
+    )
+    .addOption(
+      new Option('--mapping-file <path>', 'JSON file with the mappings (and settings) of new code chunk indices')
+    )
+    .addOption(
+      new Option(
+        '--embedding-batch-size <number>',
+        `Texts per HTTP embedding request (default: ${DEFAULT_EMBEDDING_BATCH_SIZE})`
+      )
+    )
+    .addOption(new Option('--embed-batch-size <number>', 'Alias of --embedding-batch-size, used when it is not given'))
+    .addOption(
+      new Option(
+        '--embedding-concurrency <number>',
+        `Concurrent HTTP embedding requests (default: ${DEFAULT_EMBEDDING_CONCURRENCY})`
+      )
+    )
+    .addOption(
+      new Option(
+        '--embedding-cache <path>',
+        'SQLite file caching vectors by chunk content and model, reused across runs (default: .queues/embedding_cache.db)'
+      )
+    )
+    .addOption(
+      new Option('--no-embedding-cache', 'Embed every new chunk document, without reading or writing the cache')
+    )
+    .addOption(new Option('--no-embed-cache', 'Alias of --no-embedding-cache'))
+    .addOption(
+      new Option(
+        '--embed-cache-dir <dir>',
+        'Directory of the embedding cache file, instead of the queue base directory'
+      )
+    )
+    .addOption(
+      new Option('--embed-rpm <number>', 'Embedding requests per minute allowed by the provider (default: unlimited)')
+    )
+    .addOption(
+      new Option(
+        '--embed-tpm <number>',
+        'Embedding tokens per minute allowed by the provider, estimated from chunk size (default: unlimited)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--embed-concurrency <number>',
+        'Batches embedded at once, in a stage separate from bulk requests (default: --workers)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--index-concurrency <number>',
+        'Batches bulk indexed at once, in a stage separate from embedding (default: --workers)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--enqueue-concurrency <number>',
+        `Worker threads parsing files concurrently during enqueue (default: ${DEFAULT_PARSE_CONCURRENCY})`
+      )
+    )
+    .addOption(new Option('--parse-workers <number>', 'Alias of --enqueue-concurrency, used when it is not given'))
+    .addOption(
+      new Option('--parse-concurrency <number>', 'Alias of --enqueue-concurrency, used when it is not given').default(
+        `${DEFAULT_PARSE_CONCURRENCY}`
+      )
+    )
+    .addOption(
+      new Option(
+        '--max-worker-restarts <number>',
+        `Parsing worker threads replaced after a crash or hang before failing (default: ${DEFAULT_MAX_WORKER_RESTARTS})`
+      )
+    )
+    .addOption(
+      new Option(
+        '--languages <names>',
+        'Comma-separated list of languages to index (default: SCS_IDXR_LANGUAGES if set, otherwise all languages)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--extension-map <file>',
+        'JSON file routing file suffixes to languages or "skip" (overrides SCS_IDXR_EXTENSION_MAP, see README)'
+      )
+    )
+    .addOption(new Option('--branch <branch>', 'Branch name for logging/metadata (default: auto-detect)'))
+    .addOption(
+      new Option('--ignore-path <file>', 'Additional ignore file (gitignore syntax) applied at the repository root')
+    )
+    .addOption(
+      new Option('--include <glob>', 'Only index files matching a glob (gitignore syntax, repeatable)').argParser(
+        (value: string, previous?: string[]) => [...(previous ?? []), value]
+      )
+    )
+    .addOption(
+      new Option('--exclude <glob>', 'Exclude files matching a glob (gitignore syntax, repeatable)').argParser(
+        (value: string, previous?: string[]) => [...(previous ?? []), value]
+      )
+    )
+    .addOption(
+      new Option('--no-ignore-files', 'Do not apply .gitignore, .codesearchignore or .indexerignore rules')
+    )
+    .addOption(
+      new Option('--prune', 'On incremental runs, delete the documents of indexed files that no longer exist on disk')
+    )
+    .addOption(new Option('--prune-dry-run', 'With --prune, log the files that would be pruned without deleting them'))
+    .addOption(
+      new Option(
+        '--smart-incremental',
+        'On incremental runs, only re-index the symbols of modified files that intersect the changed lines'
+      )
+    )
+    .addOption(
+      new Option(
+        '--whole-file-fallback',
+        'Index a file that fails to parse as one whole-file chunk instead of skipping it'
+      )
+    )
+    .addOption(
+      new Option(
+        '--max-chunks-per-file <number>',
+        'Skip or collapse a file that produces more chunks than this, such as a generated one (default: no limit)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--chunk-overflow <action>',
+        'What --max-chunks-per-file does to such a file: skip, or whole-file to index it as one chunk (default: skip)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--store-compressed',
+        'Store chunk content gzip-compressed in content_gz, keeping a short searchable excerpt in content'
+      )
+    )
+    .addOption(
+      new Option(
+        '--no-store-content',
+        'Store chunks without their code, only metadata, line ranges and embeddings; search reads it with --repo-root'
+      )
+    )
+    .addOption(
+      new Option('--no-dedup', 'Store identical chunks of different files as separate documents, each embedded')
+    )
+    .addOption(
+      new Option(
+        '--progress <format>',
+        'Periodic progress output: text (a bar on a terminal, log lines otherwise) or json (JSON lines on stdout)'
+      ).default('text')
+    )
+    .addOption(
+      new Option(
+        '--progress-interval <seconds>',
+        'Seconds between progress updates (default: 5 for the terminal bar, 30 for log and JSON lines)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--log-format <format>',
+        'Console log format: text (or pretty) or json (one JSON object per line, with a final run summary)'
+      )
+    )
+    .addOption(
+      new Option(
+        '--log-level <levels>',
+        'Lowest level logged: debug, info (default), warn or error, then module=level overrides, e.g. info,queue=warn'
+      )
+    )
+    .addOption(new Option('--metrics-port <port>', 'Serve Prometheus metrics on http://localhost:<port>/metrics'))
+    .addOption(new Option('--pause-file <path>', 'Pause indexing while this file exists, resuming once it is removed'))
+    .addOption(
+      new Option(
+        '--es-connect-retries <number>',
+        `Retries with exponential backoff while Elasticsearch is not reachable (default: ${DEFAULT_ES_CONNECT_RETRIES})`
+      )
+    )
+    .addOption(
+      new Option(
+        '--es-connect-timeout <ms>',
+        `Milliseconds each Elasticsearch connection attempt may take (default: ${DEFAULT_ES_CONNECT_TIMEOUT_MS})`
+      )
+    );
+}
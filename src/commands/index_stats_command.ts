@@ -0,0 +1,92 @@
+import { Command, Option } from 'commander';
+import { getIndexStats, IndexStats } from '../utils/elasticsearch';
+
+/** Aligns the columns of `rows`, the first of which is the header. Columns of numbers are right-aligned. */
+export function formatTable(rows: string[][]): string {
+  const columns = rows[0].map((_, column) => ({
+    width: Math.max(...rows.map((row) => row[column].length)),
+    numeric: rows.length > 1 && rows.slice(1).every((row) => /^\d+(\.\d+)?%?$/.test(row[column])),
+  }));
+  return rows
+    .map((row) =>
+      row
+        .map((value, column) =>
+          columns[column].numeric ? value.padStart(columns[column].width) : value.padEnd(columns[column].width)
+        )
+        .join('  ')
+        .trimEnd()
+    )
+    .join('\n');
+}
+
+function share(count: number, total: number): string {
+  return total > 0 ? `${((count / total) * 100).toFixed(1)}%` : '0.0%';
+}
+
+/** Formats the stats as the tables `index:stats` prints. */
+export function formatIndexStats(stats: IndexStats): string {
+  const sections = [
+    `Index ${stats.index}: ${stats.chunks} chunks at ${stats.locations} locations in ${stats.files} files`,
+    formatTable([
+      ['Language', 'Chunks', 'Share'],
+      ...stats.byLanguage.map(({ key, count }) => [key, String(count), share(count, stats.chunks)]),
+    ]),
+    formatTable([
+      ['Symbol kind', 'Chunks', 'Share'],
+      ...stats.bySymbolKind.map(({ key, count }) => [key, String(count), share(count, stats.chunks)]),
+    ]),
+    formatTable([
+      ['Tokens', 'Chunks', 'Share'],
+      ...stats.chunkSizes.map(({ from, to, count }) => [
+        to === null ? `${from}+` : `${from}-${to - 1}`,
+        String(count),
+        share(count, stats.chunks),
+      ]),
+    ]),
+    formatTable([
+      ['Largest chunks', 'Tokens', 'Locations', 'Symbol'],
+      ...stats.largestChunks.map((chunk) => [
+        chunk.location
+          ? `${chunk.location.filePath}:${chunk.location.startLine}-${chunk.location.endLine}`
+          : chunk.chunkId,
+        String(chunk.tokens),
+        String(chunk.locations),
+        chunk.symbolName ?? '',
+      ]),
+    ]),
+    formatTable([
+      ['Files with the most chunks', 'Chunks'],
+      ...stats.filesWithMostChunks.map(({ filePath, chunks }) => [filePath, String(chunks)]),
+    ]),
+  ];
+  if (stats.estimatedSizes > 0) {
+    sections.push(
+      `Sizes of ${stats.estimatedSizes} chunks are estimated from their length; index with --tokenizer to count them.`
+    );
+  }
+  return sections.join('\n\n');
+}
+
+function parseTop(value: string): number {
+  const top = Number(value);
+  if (!Number.isInteger(top) || top < 1 || top > 1000) {
+    throw new Error(`Invalid --top value: ${value}. Must be an integer from 1 to 1000.`);
+  }
+  return top;
+}
+
+export const indexStatsCommand = new Command('index:stats')
+  .description('Show what an index holds: chunks by language and symbol kind, chunk sizes and the largest files.')
+  .addOption(new Option('--index <index>', 'Elasticsearch index or alias to inspect (required)').makeOptionMandatory())
+  .addOption(new Option('--repo <name>', 'Only count chunks from this repository (for shared indexes)'))
+  .addOption(new Option('--top <number>', 'Largest chunks and files with the most chunks to list').default('10'))
+  .addOption(new Option('--json', 'Print the stats as JSON'))
+  .action(async (options) => {
+    try {
+      const stats = await getIndexStats(options.index, { repoName: options.repo, top: parseTop(options.top) });
+      console.log(options.json ? JSON.stringify(stats, null, 2) : formatIndexStats(stats));
+    } catch (error) {
+      console.error('Reading index stats failed:', error);
+      process.exit(1);
+    }
+  });
@@ -0,0 +1,126 @@
+import { Command, Option } from 'commander';
+import { createLogger } from '../utils/logger';
+import { getQueueDbPath, openExistingShardedQueue } from '../utils/queue_helper';
+import { SqliteQueue } from '../utils/sqlite_queue';
+import { ShardedQueue } from '../utils/queue_shards';
+import { diffIndexedFiles } from '../utils/indexed_files';
+import type { RecordedParseFailure } from '../utils/queue';
+import { parseRepoArg } from './index_command';
+
+export interface IndexStatus {
+  repoName: string;
+  indexName: string;
+  repoPath: string;
+  indexedFiles: number;
+  indexedChunks: number;
+  /** Most recent time a file's documents were all indexed, null when no file was. */
+  lastIndexedAt: string | null;
+  /** Files indexed but deleted from the repository since. */
+  deletedFiles: string[];
+  /** Files whose content changed since they were indexed and that are not re-indexed yet. */
+  changedFiles: string[];
+  /** Files with documents still in the queue. */
+  queuedFiles: number;
+  queuedDocuments: number;
+  deadLetteredDocuments: number;
+  /** SQLite files the queue is split into, whose counts are summed above. */
+  queueShards: number;
+  /** Files whose last parse failed, including those indexed as a whole file instead. */
+  parseFailedFiles: number;
+  /** The files whose last parse failed, listed with `--parse-failures`. */
+  parseFailures?: RecordedParseFailure[];
+}
+
+/**
+ * Compares the files the queue recorded as indexed into `indexName` with the checkout at `repoPath`.
+ */
+export function readIndexStatus(
+  queue: SqliteQueue | ShardedQueue,
+  repo: { repoName: string; repoPath: string; indexName: string },
+  options: { parseFailures?: boolean } = {}
+): IndexStatus {
+  const indexedFiles = queue.getIndexedFiles(repo.indexName);
+  const parseFailures = queue.getParseFailures();
+  const drift = diffIndexedFiles(repo.repoPath, indexedFiles);
+  let lastIndexedAt: string | null = null;
+  for (const file of indexedFiles) {
+    if (lastIndexedAt === null || file.indexedAt > lastIndexedAt) {
+      lastIndexedAt = file.indexedAt;
+    }
+  }
+  return {
+    ...repo,
+    indexedFiles: indexedFiles.length,
+    indexedChunks: indexedFiles.reduce((total, file) => total + file.chunkCount, 0),
+    lastIndexedAt,
+    deletedFiles: drift.deleted,
+    changedFiles: drift.changed,
+    queuedFiles: queue.getPendingFileCount(),
+    queuedDocuments: queue.getRemainingCount(),
+    deadLetteredDocuments: queue.getDeadLetterEntries().length,
+    queueShards: queue instanceof ShardedQueue ? queue.shards.length : 1,
+    parseFailedFiles: parseFailures.length,
+    ...(options.parseFailures ? { parseFailures } : {}),
+  };
+}
+
+function logFiles(title: string, files: string[]): void {
+  console.log(`${title}: ${files.length}`);
+  for (const file of files) {
+    console.log(`  ${file}`);
+  }
+}
+
+export const indexStatusCommand = new Command('index:status')
+  .description('Show what is indexed for a repository and what changed on disk since it was indexed.')
+  .argument('<repo>', 'Repository to check, as given to "index": name, path or URL, with an optional :index')
+  .addOption(new Option('--parse-failures', 'List the files whose last parse failed, with the parser and the error'))
+  .addOption(new Option('--json', 'Print the status as JSON'))
+  .action(async (repoArg: string, options) => {
+    const { repoName, repoPath, indexName } = parseRepoArg(repoArg);
+    const logger = createLogger({ name: repoName, branch: 'unknown' });
+    const dbPath = getQueueDbPath(repoName);
+
+    try {
+      const queue = await openExistingShardedQueue(repoName);
+      let status: IndexStatus;
+      try {
+        status = readIndexStatus(queue, { repoName, repoPath, indexName }, { parseFailures: options.parseFailures });
+      } finally {
+        queue.close();
+      }
+
+      if (options.json) {
+        console.log(JSON.stringify(status, null, 2));
+        return;
+      }
+
+      console.log(`Index status of '${repoName}' in ${indexName} (${repoPath}):\n`);
+      console.log(
+        `Indexed files: ${status.indexedFiles} (${status.indexedChunks} chunks), ` +
+          `last indexed at ${status.lastIndexedAt ?? 'never'}`
+      );
+      logFiles('Deleted since indexed', status.deletedFiles);
+      logFiles('Changed since indexed', status.changedFiles);
+      console.log(`Waiting in the queue: ${status.queuedFiles} files (${status.queuedDocuments} documents)`);
+      console.log(`Dead-lettered documents: ${status.deadLetteredDocuments}`);
+      if (status.queueShards > 1) {
+        console.log(`Queue shards: ${status.queueShards}`);
+      }
+      console.log(`Failed to parse: ${status.parseFailedFiles} files`);
+      for (const failure of status.parseFailures ?? []) {
+        const fallback = failure.wholeFile ? ' (indexed as a whole file)' : '';
+        console.log(`  ${failure.filePath} [${failure.language ?? 'unknown'}]: ${failure.error}${fallback}`);
+      }
+      if (status.parseFailedFiles > 0 && !status.parseFailures) {
+        console.log(`Run "index:status ${repoArg} --parse-failures" to list them.`);
+      }
+      if (status.deletedFiles.length > 0 || status.changedFiles.length > 0) {
+        console.log(`\nRun "index ${repoArg}" to bring them up to date.`);
+      }
+    } catch (error) {
+      logger.error(`Failed to connect to or read the database at ${dbPath}.`, { error });
+      logger.error('Please ensure the repository has been indexed and the database file exists.');
+      process.exit(1);
+    }
+  });
@@ -0,0 +1,100 @@
+import { Command, Option } from 'commander';
+import ignore from 'ignore';
+import { createLogger } from '../utils/logger';
+import { resolveRepoName, getQueueDbPath, openExistingQueueShards } from '../utils/queue_helper';
+import { SqliteQueue } from '../utils/sqlite_queue';
+import { CodeChunk } from '../utils/elasticsearch';
+
+export interface FailedDocumentInfo {
+  id: number;
+  filePath: string | null;
+  attempts: number;
+  lastError: string | null;
+  lastErrorAt: string | null;
+  deadLetteredAt: string;
+  /** Shard of a sharded queue the document is in, since ids are only unique within a shard. */
+  shard?: number;
+}
+
+/**
+ * Reads dead-lettered documents, optionally keeping only paths that match `pathGlob` (gitignore syntax).
+ * Documents of a shard of a sharded queue are tagged with `shard`.
+ */
+export function readFailedDocuments(queue: SqliteQueue, pathGlob?: string, shard?: number): FailedDocumentInfo[] {
+  const matcher = pathGlob ? ignore().add(pathGlob) : undefined;
+  const failures: FailedDocumentInfo[] = [];
+  for (const entry of queue.getDeadLetterEntries()) {
+    let filePath: string | null = null;
+    try {
+      filePath = (JSON.parse(entry.document) as CodeChunk).filePath ?? null;
+    } catch {
+      // Keep the entry so that unreadable documents are still visible.
+    }
+    if (matcher && (!filePath || !matcher.ignores(filePath))) {
+      continue;
+    }
+    failures.push({
+      id: entry.id,
+      filePath,
+      attempts: entry.attempts,
+      lastError: entry.lastError,
+      lastErrorAt: entry.lastErrorAt,
+      deadLetteredAt: entry.deadLetteredAt,
+      ...(shard !== undefined ? { shard } : {}),
+    });
+  }
+  return failures;
+}
+
+export const inspectFailuresCommand = new Command('queue:inspect-failures')
+  .description('Show why dead-lettered documents failed, and optionally requeue them.')
+  .addOption(new Option('--repo-name <repoName>', 'Repository name (auto-detects if only one repo exists)'))
+  .addOption(new Option('--path <glob>', 'Only include documents whose file path matches (gitignore syntax)'))
+  .addOption(new Option('--json', 'Print failures as JSON'))
+  .addOption(new Option('--requeue', 'Move the matching dead-lettered documents back to "pending"'))
+  .action(async (options) => {
+    const repoName = resolveRepoName(options.repoName);
+    const logger = createLogger({ name: repoName, branch: 'unknown' });
+    const dbPath = getQueueDbPath(repoName);
+
+    try {
+      const queues = await openExistingQueueShards(repoName);
+      const failures: FailedDocumentInfo[] = [];
+      let requeued = 0;
+      // Dead-lettered documents are requeued into the shard they failed in.
+      for (const [shard, queue] of queues.entries()) {
+        const shardFailures = readFailedDocuments(queue, options.path, queues.length > 1 ? shard : undefined);
+        requeued += options.requeue ? queue.requeueDeadLetter(shardFailures.map((f) => f.id)) : 0;
+        failures.push(...shardFailures);
+        queue.close();
+      }
+
+      if (options.json) {
+        console.log(JSON.stringify({ repoName, failures, ...(options.requeue ? { requeued } : {}) }, null, 2));
+        return;
+      }
+
+      if (failures.length === 0) {
+        console.log(`No dead-lettered documents found in queue '${repoName}'.`);
+        return;
+      }
+
+      console.log(`Found ${failures.length} dead-lettered documents in queue '${repoName}':\n`);
+      for (const failure of failures) {
+        const shard = failure.shard !== undefined ? `Shard: ${failure.shard} | ` : '';
+        console.log(
+          `${shard}ID: ${failure.id} | Attempts: ${failure.attempts} | Dead-lettered at: ${failure.deadLetteredAt} | ` +
+            `Path: ${failure.filePath ?? '(unknown)'}`
+        );
+        console.log(`  Error: ${failure.lastError ?? '(not recorded)'}`);
+      }
+
+      if (options.requeue) {
+        console.log(`\nRequeued ${requeued} documents. They will be picked up by the worker on its next run.`);
+      }
+    } catch (error) {
+      logger.error(`Failed to connect to or read the database at ${dbPath}.`, { error });
+      logger.error('Please ensure the --repo-name is correct and the database file exists.');
+      process.exit(1);
+    }
+  });
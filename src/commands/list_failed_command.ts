@@ -1,7 +1,6 @@
 import { Command, Option } from 'commander';
-import Database from 'better-sqlite3';
 import { createLogger } from '../utils/logger';
-import { resolveRepoName, getQueueDbPath } from '../utils/queue_helper';
+import { resolveRepoName, getQueueDbPath, openExistingQueueShards } from '../utils/queue_helper';
 import { CodeChunk } from '../utils/elasticsearch';
 
 // Helper function to format bytes into a human-readable string
@@ -15,7 +14,7 @@ function formatBytes(bytes: number, decimals = 2): string {
 }
 
 export const listFailedCommand = new Command('queue:list-failed')
-  .description('Lists all documents in a queue with a "failed" status.')
+  .description('Lists all dead-lettered documents in a queue.')
   .addOption(new Option('--repo-name <repoName>', 'Repository name (auto-detects if only one repo exists)'))
   .action(async (options) => {
     const repoName = resolveRepoName(options.repoName);
@@ -23,36 +22,33 @@ export const listFailedCommand = new Command('queue:list-failed')
     const dbPath = getQueueDbPath(repoName);
 
     try {
-      const db = new Database(dbPath, { readonly: true });
-
-      const selectStmt = db.prepare(`
-        SELECT id, document
-        FROM queue
-        WHERE status = 'failed'
-        ORDER BY id
-      `);
-
-      const failedDocs = selectStmt.all() as { id: number; document: string }[];
+      const queues = await openExistingQueueShards(repoName);
+      const failedDocs = queues.flatMap((queue, shard) =>
+        queue.getDeadLetterEntries().map((entry) => ({ ...entry, shard: queues.length > 1 ? shard : undefined }))
+      );
+      queues.forEach((queue) => queue.close());
 
       if (failedDocs.length === 0) {
-        console.log(`No failed documents found in queue '${repoName}'.`);
+        console.log(`No dead-lettered documents found in queue '${repoName}'.`);
         return;
       }
 
-      console.log(`Found ${failedDocs.length} failed documents in queue '${repoName}':\n`);
+      console.log(`Found ${failedDocs.length} dead-lettered documents in queue '${repoName}':\n`);
 
       for (const doc of failedDocs) {
+        const shard = doc.shard !== undefined ? `Shard: ${doc.shard} | ` : '';
         try {
           const parsedDoc: CodeChunk = JSON.parse(doc.document);
           const contentSize = Buffer.byteLength(parsedDoc.content, 'utf8');
           const displayPath = parsedDoc.filePath ?? '(unknown)';
-          console.log(`ID: ${doc.id} | Size: ${formatBytes(contentSize)} | Path: ${displayPath}`);
+          console.log(
+            `${shard}ID: ${doc.id} | Size: ${formatBytes(contentSize)} | Attempts: ${doc.attempts} | ` +
+              `Path: ${displayPath}`
+          );
         } catch {
-          console.log(`ID: ${doc.id} | Error: Failed to parse document JSON.`);
+          console.log(`${shard}ID: ${doc.id} | Error: Failed to parse document JSON.`);
         }
       }
-
-      db.close();
     } catch (error) {
       logger.error(`Failed to connect to or read the database at ${dbPath}.`, { error });
       logger.error('Please ensure the --repo-name is correct and the database file exists.');
@@ -0,0 +1,42 @@
+import { Command, Option } from 'commander';
+import { createLogger } from '../utils/logger';
+import { resolveRepoName, getQueueDbPath, openExistingQueueShards } from '../utils/queue_helper';
+
+export const maintainQueueCommand = new Command('queue:maintain')
+  .description('Compact a queue database: VACUUM, reset row ids of an empty queue and truncate the WAL.')
+  .addOption(new Option('--repo-name <repoName>', 'Repository name (auto-detects if only one repo exists)'))
+  .action(async (options) => {
+    const repoName = resolveRepoName(options.repoName);
+    const logger = createLogger({ name: repoName, branch: 'unknown' });
+    const dbPath = getQueueDbPath(repoName);
+
+    logger.info(`Connecting to queue database at: ${dbPath}`);
+
+    try {
+      const queues = await openExistingQueueShards(repoName);
+      const results = queues.map((queue) => {
+        const result = queue.compact();
+        queue.close();
+        return result;
+      });
+
+      if (results.some((result) => !result.vacuumed)) {
+        logger.warn('The queue database is in use. Stop the running indexer and run queue:maintain again.');
+        process.exitCode = 1;
+        return;
+      }
+      // The shards of a sharded queue are compacted one by one and reported together.
+      const bytesBefore = results.reduce((total, result) => total + result.bytesBefore, 0);
+      const bytesAfter = results.reduce((total, result) => total + result.bytesAfter, 0);
+      const freedBytes = Math.max(0, bytesBefore - bytesAfter);
+      const shards = results.length > 1 ? ` (${results.length} shards)` : '';
+      logger.info(`Queue database${shards} compacted: ${bytesBefore} -> ${bytesAfter} bytes (${freedBytes} freed).`);
+      if (results.every((result) => result.sequenceReset)) {
+        logger.info('The queue is empty, so document ids restart at 1.');
+      }
+    } catch (error) {
+      logger.error(`Failed to compact the database at ${dbPath}.`, { error });
+      logger.error('Please ensure the --repo-name is correct and the database file exists.');
+      process.exit(1);
+    }
+  });
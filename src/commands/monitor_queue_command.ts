@@ -1,13 +1,30 @@
 import { Command, Option } from 'commander';
 import Database from 'better-sqlite3';
-import { createLogger } from '../utils/logger';
-import { resolveRepoName, getQueueDbPath } from '../utils/queue_helper';
+import { createLogger, Logger } from '../utils/logger';
+import { resolveRepoName, getQueueDbPaths, listQueueRepoNames } from '../utils/queue_helper';
 import moment from 'moment';
 
 async function monitorQueue(options?: { repoName?: string }) {
-  const repoName = resolveRepoName(options?.repoName);
+  // Repositories indexed into a shared index each have their own queue, so show all of them.
+  const queueRepoNames = options?.repoName ? [] : listQueueRepoNames();
+  if (queueRepoNames.length > 1) {
+    for (const repoName of queueRepoNames) {
+      logQueueStatistics(repoName);
+    }
+    return;
+  }
+  logQueueStatistics(resolveRepoName(options?.repoName));
+}
+
+function logQueueStatistics(repoName: string) {
   const logger = createLogger({ name: repoName, branch: 'unknown' });
-  const dbPath = getQueueDbPath(repoName);
+  // Each shard of a sharded queue is shown on its own.
+  for (const dbPath of getQueueDbPaths(repoName)) {
+    logQueueDbStatistics(logger, dbPath);
+  }
+}
+
+function logQueueDbStatistics(logger: Logger, dbPath: string) {
   logger.info(`Database: ${dbPath}`);
   const db = new Database(dbPath, { readonly: true });
 
@@ -26,9 +43,15 @@ async function monitorQueue(options?: { repoName?: string }) {
     const processing = processingStmt.get() as { count: number };
     logger.info(`Processing documents: ${processing.count}`);
 
-    const failedStmt = db.prepare("SELECT COUNT(*) as count FROM queue WHERE status = 'failed'");
+    // Queues created before the dead-letter table existed are upgraded by the next worker run.
+    const hasDeadLetter = db
+      .prepare("SELECT COUNT(*) as count FROM sqlite_master WHERE type = 'table' AND name = 'dead_letter'")
+      .get() as { count: number };
+    const failedStmt = hasDeadLetter.count
+      ? db.prepare('SELECT COUNT(*) as count FROM dead_letter')
+      : db.prepare("SELECT COUNT(*) as count FROM queue WHERE status = 'failed'");
     const failed = failedStmt.get() as { count: number };
-    logger.info(`Failed documents: ${failed.count}`);
+    logger.info(`Dead-lettered documents: ${failed.count}`);
 
     if (total.count > 0) {
       const oldestStmt = db.prepare('SELECT MIN(created_at) as date FROM queue');
@@ -0,0 +1,40 @@
+import { Command, Option } from 'commander';
+import { createLogger } from '../utils/logger';
+import { resolveRepoName, getQueueDbPath, openExistingQueueShards } from '../utils/queue_helper';
+
+export const requeueDeadLetterCommand = new Command('queue:requeue-dead-letter')
+  .alias('queue:retry-failed')
+  .description('Move all dead-lettered documents in a queue back to "pending" to be retried.')
+  .addOption(new Option('--repo-name <repoName>', 'Repository name (auto-detects if only one repo exists)'))
+  .action(async (options) => {
+    const repoName = resolveRepoName(options.repoName);
+    const logger = createLogger({ name: repoName, branch: 'unknown' });
+    const dbPath = getQueueDbPath(repoName);
+
+    logger.info(`Connecting to queue database at: ${dbPath}`);
+
+    try {
+      const queues = await openExistingQueueShards(repoName);
+
+      const deadLetterCount = queues.reduce((total, queue) => total + queue.getDeadLetterEntries().length, 0);
+      if (deadLetterCount === 0) {
+        logger.info('No dead-lettered documents found. Nothing to do.');
+        queues.forEach((queue) => queue.close());
+        return;
+      }
+
+      logger.info(`Found ${deadLetterCount} dead-lettered documents. Moving them back to 'pending'...`);
+      // Each shard of a sharded queue requeues its own documents.
+      let requeued = 0;
+      for (const queue of queues) {
+        requeued += queue.requeueDeadLetter();
+        queue.close();
+      }
+
+      logger.info(`Successfully requeued ${requeued} documents. They will be picked up by the worker on its next run.`);
+    } catch (error) {
+      logger.error(`Failed to connect to or update the database at ${dbPath}.`, { error });
+      logger.error('Please ensure the --repo-name is correct and the database file exists.');
+      process.exit(1);
+    }
+  });
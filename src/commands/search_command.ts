@@ -1,61 +1,328 @@
+import fs from 'fs';
 import { Command, Option } from 'commander';
-import { getLocationsForChunkIds, indexHasSemanticTextField, searchCodeChunks } from '../utils/elasticsearch';
+import { elasticsearchConfig } from '../config';
+import {
+  getChunkIdsForPathPrefix,
+  getLocationsForChunkIds,
+  getVectorQuantization,
+  indexHasSemanticTextField,
+  KnnQueryOptions,
+  searchCodeChunks,
+  searchCodeChunksHybrid,
+  searchCodeChunksKnn,
+  SearchResult,
+} from '../utils/elasticsearch';
+import { createEmbeddingProvider } from '../utils/embedding_provider';
+import { DEFAULT_RRF_RANK_CONSTANT, SEARCH_FUSIONS, SearchFusion } from '../utils/search_fusion';
+import { readChunkSource, SourceSnippet } from '../utils/source_snippet';
+import { parseSymbolKinds } from '../utils/symbol_kinds';
 
 /**
- * Search command - performs semantic search on indexed code
+ * Returns the most specific symbol name for a search result, if any.
  */
-export async function search(query: string, options: { index: string; limit?: string }) {
-  console.log(`Searching for: "${query}"`);
+function getSymbolName(result: SearchResult): string | undefined {
+  // Later parts of a split symbol do not contain its declaration, so prefer the recorded name.
+  return result.symbol_name ?? result.symbols?.[0]?.name ?? (result.containerPath || undefined);
+}
+
+function formatLocation(location: { filePath: string; startLine: number; endLine: number }): string {
+  return `${location.filePath}:${location.startLine}-${location.endLine}`;
+}
+
+/** Where the code of a result indexed without content was read, for `--json`. */
+function describeSnippet(snippet: SourceSnippet | undefined): { contentLocation?: string; stale?: boolean } {
+  return snippet ? { contentLocation: formatLocation(snippet), stale: snippet.stale } : {};
+}
+
+/** Parses a fusion weight, which may be zero to turn off one side of a hybrid search. */
+function parseWeight(name: string, value: string | undefined): number {
+  if (value === undefined) {
+    return 1;
+  }
+  const weight = Number(value);
+  if (value.trim().length === 0 || !Number.isFinite(weight) || weight < 0) {
+    throw new Error(`Invalid --${name} value: ${value}. Must be a non-negative number.`);
+  }
+  return weight;
+}
+
+/**
+ * Embeds the query for a kNN search, with the external provider that populated `code_vector` or
+ * with the model of the ingest pipeline.
+ */
+async function getKnnQuery(
+  query: string,
+  limit: number,
+  options: { embeddingProvider?: string; embeddingUrl?: string; embeddingModel?: string }
+): Promise<KnnQueryOptions> {
+  if (options.embeddingProvider !== undefined && options.embeddingProvider !== 'elasticsearch') {
+    // Vectors indexed by an external provider can only be queried with vectors from the same provider.
+    const provider = createEmbeddingProvider({
+      provider: options.embeddingProvider,
+      url: options.embeddingUrl,
+      model: options.embeddingModel,
+      purpose: 'query',
+    });
+    const [queryVector] = await provider.embed([query]);
+    return { k: limit, queryVector };
+  }
+  const modelId = elasticsearchConfig.denseVectorModelId;
+  if (!modelId) {
+    throw new Error(
+      'kNN search requires SCS_IDXR_DENSE_VECTOR_MODEL_ID to be set to the text embedding model used to ' +
+        'populate "code_vector" (see SCS_IDXR_ENABLE_DENSE_VECTORS).'
+    );
+  }
+  return { k: limit, modelId };
+}
+
+/**
+ * Search command - performs semantic, kNN or hybrid (kNN and BM25) search on indexed code
+ */
+export async function search(
+  query: string,
+  options: {
+    index: string;
+    limit?: string;
+    k?: string;
+    knn?: boolean;
+    hybrid?: boolean;
+    fusion?: string;
+    lexicalWeight?: string;
+    vectorWeight?: string;
+    rankConstant?: string;
+    json?: boolean;
+    locations?: string;
+    repo?: string;
+    references?: string;
+    language?: string;
+    kind?: string;
+    package?: string;
+    pathPrefix?: string;
+    embeddingProvider?: string;
+    embeddingUrl?: string;
+    embeddingModel?: string;
+    repoRoot?: string;
+  }
+) {
+  if (!options.json) {
+    console.log(`Searching for: "${query}"`);
+  }
 
   const indexName = options.index;
 
-  const parsedLimit = options.limit ? Number(options.limit) : 10;
+  const rawLimit = options.k ?? options.limit;
+  const limitOptionName = options.k !== undefined ? 'k' : 'limit';
+  const parsedLimit = rawLimit ? Number(rawLimit) : 10;
   if (!Number.isInteger(parsedLimit) || parsedLimit <= 0) {
-    throw new Error(`Invalid --limit value: ${options.limit}. Must be a positive integer.`);
+    throw new Error(`Invalid --${limitOptionName} value: ${rawLimit}. Must be a positive integer.`);
   }
   const limit = parsedLimit;
 
-  const semanticTextEnabled = await indexHasSemanticTextField(indexName);
-  if (!semanticTextEnabled) {
-    throw new Error(
-      `Index "${indexName}" does not have a "semantic_text" mapping, so semantic search cannot run. ` +
-        'This usually happens when the index was created with semantic text disabled. ' +
-        'Recreate the index with semantic text enabled and reindex your code, or use a non-semantic search command.'
-    );
+  const perChunkLimit = options.locations !== undefined ? Number(options.locations) : 5;
+  if (!Number.isInteger(perChunkLimit) || perChunkLimit <= 0 || perChunkLimit > 50) {
+    throw new Error(`Invalid --locations value: ${options.locations}. Must be an integer between 1 and 50.`);
   }
 
-  const results = await searchCodeChunks(query, indexName, limit);
+  if (options.hybrid && options.knn) {
+    throw new Error('--hybrid cannot be combined with --knn.');
+  }
+  for (const [flag, value] of [
+    ['fusion', options.fusion],
+    ['lexical-weight', options.lexicalWeight],
+    ['vector-weight', options.vectorWeight],
+    ['rank-constant', options.rankConstant],
+  ]) {
+    if (value !== undefined && !options.hybrid) {
+      throw new Error(`--${flag} requires --hybrid.`);
+    }
+  }
+  const fusion = (options.fusion ?? 'rrf') as SearchFusion;
+  if (!SEARCH_FUSIONS.includes(fusion)) {
+    throw new Error(`Invalid --fusion value: ${options.fusion}. Expected one of: ${SEARCH_FUSIONS.join(', ')}.`);
+  }
+  const lexicalWeight = parseWeight('lexical-weight', options.lexicalWeight);
+  const vectorWeight = parseWeight('vector-weight', options.vectorWeight);
+  if (lexicalWeight === 0 && vectorWeight === 0) {
+    throw new Error('--lexical-weight and --vector-weight cannot both be 0.');
+  }
+  const rankConstant = options.rankConstant !== undefined ? Number(options.rankConstant) : DEFAULT_RRF_RANK_CONSTANT;
+  if (!Number.isInteger(rankConstant) || rankConstant <= 0) {
+    throw new Error(`Invalid --rank-constant value: ${options.rankConstant}. Must be a positive integer.`);
+  }
+
+  const symbolKinds = options.kind !== undefined ? parseSymbolKinds('--kind', options.kind) : undefined;
+  if (symbolKinds && symbolKinds.length > 1) {
+    throw new Error(`Invalid --kind value: ${options.kind}. Expected a single kind.`);
+  }
+  const symbolKind = symbolKinds?.[0];
+
+  if (options.repoRoot !== undefined && !fs.statSync(options.repoRoot, { throwIfNoEntry: false })?.isDirectory()) {
+    throw new Error(`Invalid --repo-root value: ${options.repoRoot}. Must be a directory.`);
+  }
+
+  if (options.pathPrefix !== undefined && options.pathPrefix.length === 0) {
+    throw new Error('Invalid --path-prefix value: empty string. Provide a path such as src/utils/.');
+  }
+  // Chunk documents do not store file paths, so the search is narrowed to the chunks located there.
+  const chunkIds = options.pathPrefix
+    ? await getChunkIdsForPathPrefix(indexName, options.pathPrefix, { repoName: options.repo })
+    : undefined;
+  const filters = {
+    repoName: options.repo,
+    references: options.references,
+    language: options.language,
+    symbolKind,
+    packageName: options.package,
+    chunkIds,
+  };
+  // kNN scores of quantized vectors approximate the similarity, so they are reported as such.
+  const vectorQuantization = options.hybrid || options.knn ? await getVectorQuantization(indexName) : undefined;
+  let results: SearchResult[];
+  if (options.hybrid) {
+    const knnQuery = await getKnnQuery(query, limit, options);
+    results = await searchCodeChunksHybrid(query, indexName, {
+      ...knnQuery,
+      ...filters,
+      fusion,
+      lexicalWeight,
+      vectorWeight,
+      rankConstant,
+    });
+  } else if (options.knn) {
+    const knnQuery = await getKnnQuery(query, limit, options);
+    results = await searchCodeChunksKnn(query, indexName, { ...knnQuery, ...filters });
+  } else {
+    const semanticTextEnabled = await indexHasSemanticTextField(indexName);
+    if (!semanticTextEnabled) {
+      throw new Error(
+        `Index "${indexName}" does not have a "semantic_text" mapping, so semantic search cannot run. ` +
+          'This usually happens when the index was created with semantic text disabled. ' +
+          'Recreate the index with semantic text enabled and reindex your code, or use a non-semantic search command.'
+      );
+    }
+    results = await searchCodeChunks(query, indexName, limit, filters);
+  }
+
+  const visible = results.slice(0, limit);
+  const locationsByChunkId =
+    visible.length > 0
+      ? await getLocationsForChunkIds(
+          visible.map((r) => r.id),
+          { index: indexName, perChunkLimit, filePathPrefix: options.pathPrefix }
+        )
+      : {};
+  // Chunks indexed with --no-store-content have no `content`: their code is read from the checkout.
+  const snippets = new Map<string, SourceSnippet>();
+  if (options.repoRoot) {
+    for (const result of visible) {
+      const snippet =
+        result.content === undefined
+          ? readChunkSource(options.repoRoot, locationsByChunkId[result.id]?.locations ?? [])
+          : undefined;
+      if (snippet) {
+        snippets.set(result.id, snippet);
+      }
+    }
+  }
+
+  if (options.json) {
+    const output = {
+      query,
+      index: indexName,
+      mode: options.hybrid ? 'hybrid' : options.knn ? 'knn' : 'semantic',
+      ...(options.hybrid ? { fusion, lexicalWeight, vectorWeight } : {}),
+      ...(vectorQuantization ? { vectorQuantization, approximateScores: true } : {}),
+      repo: options.repo,
+      references: options.references,
+      language: options.language,
+      kind: symbolKind,
+      package: options.package,
+      pathPrefix: options.pathPrefix,
+      results: visible.map((result) => ({
+        id: result.id,
+        score: result.score,
+        repo: result.repo_name,
+        language: result.language,
+        kind: result.kind,
+        symbolKind: result.symbol_kind,
+        symbol: getSymbolName(result),
+        scopePath: result.scope_path,
+        containerPath: result.containerPath || undefined,
+        ...(result.symbol_id && result.totalChunks !== undefined
+          ? { symbolId: result.symbol_id, part: (result.chunkIndex ?? 0) + 1, of: result.totalChunks }
+          : {}),
+        // Identical content in several files is one chunk document; its locations list every file.
+        locations: locationsByChunkId[result.id]?.locations ?? [],
+        locationCount: locationsByChunkId[result.id]?.total ?? 0,
+        content: result.content ?? snippets.get(result.id)?.content,
+        ...describeSnippet(snippets.get(result.id)),
+      })),
+    };
+    console.log(JSON.stringify(output, null, 2));
+    return;
+  }
 
   console.log(`\nSearch results (showing top ${Math.min(limit, results.length)} of ${results.length}):`);
+  if (vectorQuantization) {
+    console.log(`Scores are approximate: "${indexName}" indexes code_vector with ${vectorQuantization} quantization.`);
+  }
 
   if (results.length === 0) {
     console.log('No results found.');
     return;
   }
 
-  const visible = results.slice(0, limit);
-  const locationsByChunkId = await getLocationsForChunkIds(
-    visible.map((r) => r.id),
-    { index: indexName, perChunkLimit: 5 }
-  );
-
   visible.forEach((result, index) => {
     console.log('\n' + '='.repeat(80));
     console.log(`Result #${index + 1} (Score: ${result.score.toFixed(2)})`);
     console.log('='.repeat(80));
-    const locations = locationsByChunkId[result.id] ?? [];
+    const locations = locationsByChunkId[result.id]?.locations ?? [];
+    const total = locationsByChunkId[result.id]?.total ?? locations.length;
     if (locations.length > 0) {
-      console.log(`Locations (sample): ${locations.length}`);
+      console.log(`Locations: ${total}`);
       locations.forEach((p) => {
         console.log(`- ${p.filePath}:${p.startLine}-${p.endLine}`);
       });
+      if (total > locations.length) {
+        console.log(`- ... and ${total - locations.length} more (see --locations)`);
+      }
+    }
+    if (result.repo_name) {
+      console.log(`Repository: ${result.repo_name}`);
+    }
+    const symbol = getSymbolName(result);
+    if (symbol) {
+      console.log(`Symbol: ${symbol}`);
     }
-    if (result.kind) {
+    if (result.scope_path && result.scope_path !== symbol) {
+      console.log(`Scope: ${result.scope_path}`);
+    }
+    if (result.symbol_id && result.totalChunks !== undefined) {
+      console.log(`Part: ${(result.chunkIndex ?? 0) + 1} of ${result.totalChunks} (symbol id ${result.symbol_id})`);
+    }
+    if (result.symbol_kind) {
+      console.log(`Kind: ${result.symbol_kind} (${result.kind})`);
+    } else if (result.kind) {
       console.log(`Kind: ${result.kind}`);
     }
-    console.log('\nContent:');
-    console.log('-'.repeat(80));
-    console.log(result.content);
+    const snippet = snippets.get(result.id);
+    if (result.content !== undefined) {
+      console.log('\nContent:');
+      console.log('-'.repeat(80));
+      console.log(result.content);
+    } else if (snippet?.content !== undefined) {
+      console.log(`\nContent (read from ${formatLocation(snippet)}):`);
+      if (snippet.stale) {
+        console.log(`Stale: ${snippet.filePath} changed since it was indexed, so these lines may not be the chunk.`);
+      }
+      console.log('-'.repeat(80));
+      console.log(snippet.content);
+    } else if (snippet) {
+      console.log(`\nContent: not stored, and ${snippet.filePath} cannot be read from --repo-root.`);
+    } else {
+      console.log('\nContent: not stored in the index. Pass --repo-root to read it from a checkout.');
+    }
   });
 
   console.log('\n' + '='.repeat(80));
@@ -65,8 +332,49 @@ export async function search(query: string, options: { index: string; limit?: st
 export const searchCommand = new Command('search')
   .description('Search indexed code using semantic search')
   .argument('<query>', 'Search query (natural language)')
-  .addOption(new Option('--index <index>', 'Elasticsearch index to search (required)').makeOptionMandatory())
+  .addOption(new Option('--index <index>', 'Elasticsearch index or alias to search (required)').makeOptionMandatory())
   .addOption(new Option('--limit <number>', 'Maximum number of results to display').default('10'))
+  .addOption(new Option('--k <number>', 'Number of results to return (overrides --limit)'))
+  .addOption(
+    new Option('--knn', 'Run a kNN query on dense code vectors (requires SCS_IDXR_DENSE_VECTOR_MODEL_ID)')
+  )
+  .addOption(
+    new Option('--hybrid', 'Combine a kNN query on code vectors with a BM25 match on the code and symbol names')
+  )
+  .addOption(new Option('--fusion <method>', 'How --hybrid merges the two result lists: rrf (default) or linear'))
+  .addOption(new Option('--lexical-weight <number>', 'Weight of the BM25 results in --hybrid (default: 1)'))
+  .addOption(new Option('--vector-weight <number>', 'Weight of the kNN results in --hybrid (default: 1)'))
+  .addOption(
+    new Option('--rank-constant <number>', `Rank constant of --fusion rrf (default: ${DEFAULT_RRF_RANK_CONSTANT})`)
+  )
+  .addOption(new Option('--repo <name>', 'Only return chunks from this repository (for shared indexes)'))
+  .addOption(
+    new Option(
+      '--references <symbol>',
+      'Only return chunks that call or instantiate this symbol (e.g. greet, or Greeter.Greet for a receiver type)'
+    )
+  )
+  .addOption(new Option('--language <name>', 'Only return chunks of this language (e.g. typescript)'))
+  .addOption(
+    new Option('--kind <kind>', 'Only return chunks defining a symbol of this kind (e.g. method, struct, interface)')
+  )
+  .addOption(new Option('--package <name>', 'Only return chunks of files declaring this package (Go, Java, Scala)'))
+  .addOption(
+    new Option('--path-prefix <path>', 'Only return chunks located in files under this path (e.g. src/utils/)')
+  )
+  .addOption(
+    new Option(
+      '--embedding-provider <name>',
+      'Embeds the --knn or --hybrid query: elasticsearch (SCS_IDXR_DENSE_VECTOR_MODEL_ID), http, openai or cohere'
+    ).default('elasticsearch')
+  )
+  .addOption(new Option('--embedding-url <url>', 'Embedding endpoint (required for http)'))
+  .addOption(new Option('--embedding-model <name>', 'Model name sent to the embedding endpoint'))
+  .addOption(new Option('--locations <number>', 'File locations to list per result, up to 50').default('5'))
+  .addOption(
+    new Option('--repo-root <path>', 'Checkout to read the code of chunks indexed with --no-store-content from')
+  )
+  .addOption(new Option('--json', 'Print results as JSON'))
   .action(async (query, options) => {
     try {
       await search(query, options);
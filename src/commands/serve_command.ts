@@ -0,0 +1,178 @@
+import fs from 'fs';
+import path from 'path';
+import { Command, Option } from 'commander';
+import { indexRepos } from './index_command';
+import { addIndexOptions } from './index_options';
+import { isShutdownRequested } from '../utils/graceful_shutdown';
+import { logger } from '../utils/logger';
+import { shutdown } from '../utils/otel_provider';
+
+/** Default of `--interval`: time between the starts of two incremental runs. */
+export const DEFAULT_SERVE_INTERVAL = '5m';
+
+const DURATION_UNITS_MS: Record<string, number> = { ms: 1, s: 1000, m: 60 * 1000, h: 60 * 60 * 1000 };
+
+/** Parses an `--interval` such as `30s`, `5m` or `1h` into milliseconds; a bare number is in seconds. */
+export function parseInterval(value: string): number {
+  const match = /^(\d+(?:\.\d+)?)(ms|s|m|h)?$/.exec(value.trim());
+  const intervalMs = match ? Math.round(Number(match[1]) * DURATION_UNITS_MS[match[2] ?? 's']) : NaN;
+  if (!(intervalMs > 0)) {
+    throw new Error(`Invalid --interval value: ${value}. Must be a positive duration such as 30s, 5m or 1h.`);
+  }
+  return intervalMs;
+}
+
+/** State of the serve loop, written to the `--status-file` whenever it changes. */
+export interface ServeStatus {
+  state: 'running' | 'idle' | 'stopped';
+  /** Runs started since the command started. */
+  cycles: number;
+  /** Scheduled runs skipped because the previous run was still going. */
+  skippedCycles: number;
+  lastRunStartedAt: string | null;
+  lastRunFinishedAt: string | null;
+  lastRunStatus: 'succeeded' | 'failed' | null;
+  lastRunError: string | null;
+  nextRunAt: string | null;
+}
+
+export interface ServeLoopOptions {
+  intervalMs: number;
+  statusFile?: string;
+  /** Test seams; default to `Date.now` and a timer. */
+  now?: () => number;
+  sleep?: (ms: number) => Promise<void>;
+}
+
+function sleepFor(ms: number): Promise<void> {
+  return new Promise((resolve) => setTimeout(resolve, ms));
+}
+
+function writeStatusFile(statusFile: string, status: ServeStatus): void {
+  // Written to a temporary file and renamed over the old one, so a reader never sees a partial file.
+  const tempFile = `${statusFile}.tmp`;
+  fs.writeFileSync(tempFile, `${JSON.stringify(status, null, 2)}\n`);
+  fs.renameSync(tempFile, statusFile);
+}
+
+/**
+ * Calls `runCycle` every `intervalMs`, counted from the start of the previous run, until a shutdown is
+ * requested. Runs never overlap: the ticks that pass while a run is still going are skipped and
+ * logged, and the next run starts on the following tick. A run that throws, or that sets a failing
+ * `process.exitCode` as a multi-repository run does, is recorded as failed and the loop goes on.
+ *
+ * @returns The status once the loop stopped.
+ */
+export async function runServeLoop(
+  runCycle: (cycle: number) => Promise<void>,
+  options: ServeLoopOptions
+): Promise<ServeStatus> {
+  const { intervalMs, statusFile } = options;
+  const now = options.now ?? Date.now;
+  const sleep = options.sleep ?? sleepFor;
+  const status: ServeStatus = {
+    state: 'idle',
+    cycles: 0,
+    skippedCycles: 0,
+    lastRunStartedAt: null,
+    lastRunFinishedAt: null,
+    lastRunStatus: null,
+    lastRunError: null,
+    nextRunAt: null,
+  };
+  const update = (changes: Partial<ServeStatus>) => {
+    Object.assign(status, changes);
+    if (statusFile) {
+      writeStatusFile(statusFile, status);
+    }
+  };
+
+  let nextRunAt = now();
+  while (!isShutdownRequested()) {
+    const startedAt = now();
+    update({ state: 'running', cycles: status.cycles + 1, lastRunStartedAt: new Date(startedAt).toISOString() });
+    logger.info(`Starting indexing run ${status.cycles}...`);
+    let error: string | null = null;
+    try {
+      await runCycle(status.cycles);
+      if (process.exitCode) {
+        error = 'One or more repositories failed to index.';
+      }
+    } catch (runError) {
+      error = runError instanceof Error ? runError.message : String(runError);
+    }
+    // A failed repository must not fail the exit of a later, successful run.
+    process.exitCode = undefined;
+    const finishedAt = now();
+    update({
+      lastRunFinishedAt: new Date(finishedAt).toISOString(),
+      lastRunStatus: error ? 'failed' : 'succeeded',
+      lastRunError: error,
+    });
+    const seconds = ((finishedAt - startedAt) / 1000).toFixed(1);
+    if (error) {
+      logger.error(`Indexing run ${status.cycles} failed after ${seconds}s`, { error });
+    } else {
+      logger.info(`Indexing run ${status.cycles} succeeded in ${seconds}s.`);
+    }
+    if (isShutdownRequested()) {
+      break;
+    }
+
+    nextRunAt += intervalMs;
+    if (finishedAt >= nextRunAt) {
+      const skipped = Math.floor((finishedAt - nextRunAt) / intervalMs) + 1;
+      nextRunAt += skipped * intervalMs;
+      logger.warn(`Indexing run ${status.cycles} outlasted the interval, skipped ${skipped} scheduled runs.`);
+      status.skippedCycles += skipped;
+    }
+    update({ state: 'idle', nextRunAt: new Date(nextRunAt).toISOString() });
+    logger.info(`Next indexing run at ${status.nextRunAt}.`);
+    // A shutdown while sleeping exits from the signal handler, since nothing is in flight.
+    await sleep(nextRunAt - finishedAt);
+  }
+
+  update({ state: 'stopped', nextRunAt: null });
+  return status;
+}
+
+export const serveCommand = new Command('index:serve')
+  .description('Index repositories, then re-run incremental indexing on an interval until stopped')
+  .argument('[repos...]', 'Repository names, paths, or URLs (format: repo[:index]).')
+  .addOption(
+    new Option('--repo <path=name>', 'Repository path or URL with an explicit name (repeatable)').argParser(
+      (value: string, previous?: string[]) => [...(previous ?? []), value]
+    )
+  )
+  .addOption(new Option('--repos-file <file>', 'JSON file listing repositories to index (see README)'))
+  .addOption(
+    new Option(
+      '--interval <duration>',
+      'Time between the starts of two runs, e.g. 30s, 5m or 1h (a bare number is in seconds)'
+    ).default(DEFAULT_SERVE_INTERVAL)
+  )
+  .addOption(new Option('--status-file <path>', 'JSON file updated with the state, time and outcome of the last run'))
+  .addOption(
+    new Option('--index <name>', 'Index every repository into this shared Elasticsearch index (overrides :index)')
+  )
+  .addOption(new Option('--pull', 'Git pull before each run'));
+
+addIndexOptions(serveCommand).action(async (repos, options) => {
+  const { interval, statusFile, metricsPort, ...indexOptions } = options;
+  try {
+    const intervalMs = parseInterval(interval);
+    const status = await runServeLoop(
+      // Each run is the one `index` makes: incremental once a repository was indexed, a full index
+      // otherwise. The metrics server and OpenTelemetry are kept running from one run to the next.
+      (cycle) =>
+        indexRepos(repos, { ...indexOptions, metricsPort: cycle === 1 ? metricsPort : undefined, serve: true }),
+      { intervalMs, statusFile: statusFile ? path.resolve(statusFile) : undefined }
+    );
+    logger.info(`Stopped after ${status.cycles} indexing runs.`);
+    await shutdown();
+  } catch (error) {
+    logger.error('Fatal error in index:serve command', { error });
+    await shutdown();
+    throw error;
+  }
+});
@@ -0,0 +1,220 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { createHash } from 'crypto';
+import { Command, Option } from 'commander';
+import { createLogger } from '../utils/logger';
+import { getQueueDbPath, getQueueDir, openExistingShardedQueue } from '../utils/queue_helper';
+import { ShardedQueue } from '../utils/queue_shards';
+import { deleteDocumentsByFilePaths, getIndexedFileSummaries, IndexedFileSummary } from '../utils/elasticsearch';
+import { hashContent, Manifest, ManifestEntry, MANIFEST_FILE_NAME, readManifest } from '../utils/manifest';
+import { indexRepos, parseRepoArg } from './index_command';
+
+/** A file with fewer locations in the index than the chunks the manifest recorded for it. */
+export interface MissingDocuments {
+  filePath: string;
+  expected: number;
+  found: number;
+}
+
+/** How the files of a manifest compare with the locations indexed for them, see {@link compareManifestWithIndex}. */
+export interface VerifyResult {
+  checkedFiles: number;
+  missing: MissingDocuments[];
+  /** Files with locations in the index that the manifest does not list. */
+  orphaned: string[];
+  /** Files whose locations were indexed from content other than the one the manifest recorded. */
+  hashMismatches: string[];
+  /** Files whose chunks are still in the queue, which are not compared yet. */
+  queued: string[];
+  /** Files whose content on disk changed since the manifest was written, whose hashes are not compared. */
+  changed: string[];
+}
+
+export interface VerifyReport extends VerifyResult {
+  repoName: string;
+  indexName: string;
+  branch: string;
+  manifestPath: string;
+}
+
+/** Hashes content the way `git hash-object` does, which is the `git_file_hash` of its locations. */
+function hashGitBlob(content: Buffer): string {
+  return createHash('sha1').update(`blob ${content.length}\0`).update(content).digest('hex');
+}
+
+/**
+ * Compares the files recorded in a manifest with the locations indexed for them.
+ *
+ * A file is missing documents when it has fewer locations than the chunks it was enqueued with. The
+ * `git_file_hash` of its locations is checked against the file under `rootDir` when that file still has
+ * the content recorded in the manifest.
+ *
+ * @param options.isQueued Tells whether the chunks of a file, by their queue row ids, still wait in the queue.
+ */
+export function compareManifestWithIndex(
+  manifest: Manifest,
+  indexedFiles: Map<string, IndexedFileSummary>,
+  options: { rootDir: string; isQueued?: (entry: ManifestEntry) => boolean }
+): VerifyResult {
+  const result: VerifyResult = {
+    checkedFiles: 0,
+    missing: [],
+    orphaned: [],
+    hashMismatches: [],
+    queued: [],
+    changed: [],
+  };
+  for (const filePath of Object.keys(manifest.files).sort()) {
+    const entry = manifest.files[filePath];
+    if (options.isQueued?.(entry)) {
+      result.queued.push(filePath);
+      continue;
+    }
+    result.checkedFiles++;
+    const summary = indexedFiles.get(filePath);
+    const found = summary?.documentCount ?? 0;
+    if (found < entry.chunkCount) {
+      result.missing.push({ filePath, expected: entry.chunkCount, found });
+    }
+    if (!summary || found === 0) {
+      continue;
+    }
+    let content: Buffer;
+    try {
+      content = fs.readFileSync(path.resolve(options.rootDir, filePath));
+    } catch {
+      // Files that cannot be read, e.g. entries of an archive, are only counted.
+      continue;
+    }
+    if (hashContent(content) !== entry.sha256) {
+      result.changed.push(filePath);
+    } else if (summary.gitFileHashes.some((hash) => hash !== hashGitBlob(content))) {
+      result.hashMismatches.push(filePath);
+    }
+  }
+  result.orphaned = Array.from(indexedFiles.keys())
+    .filter((filePath) => manifest.files[filePath] === undefined)
+    .sort();
+  return result;
+}
+
+/** True when the comparison found documents to add or remove. */
+export function hasDiscrepancies(result: VerifyResult): boolean {
+  return result.missing.length > 0 || result.orphaned.length > 0 || result.hashMismatches.length > 0;
+}
+
+function logFiles(title: string, files: string[]): void {
+  console.log(`${title}: ${files.length}`);
+  for (const file of files) {
+    console.log(`  ${file}`);
+  }
+}
+
+/**
+ * Deletes the documents of orphaned files and indexes the files missing documents or indexed from
+ * other content again, as a listed run of the settings in the environment. Closes `queue`.
+ */
+async function fixDiscrepancies(repoArg: string, report: VerifyReport, queue: ShardedQueue | undefined): Promise<void> {
+  const logger = createLogger({ name: report.repoName, branch: report.branch });
+  const files = Array.from(new Set([...report.missing.map((file) => file.filePath), ...report.hashMismatches]));
+  try {
+    if (report.orphaned.length > 0) {
+      logger.info(`Deleting the documents of ${report.orphaned.length} orphaned files...`);
+      await deleteDocumentsByFilePaths(report.orphaned, report.indexName, { repoName: report.repoName });
+    }
+    // Recorded hashes would skip the files as unchanged.
+    await queue?.deleteFileHashes(files);
+  } finally {
+    // The run below opens the queue itself.
+    queue?.close();
+  }
+  if (files.length === 0) {
+    return;
+  }
+  const listDir = fs.mkdtempSync(path.join(os.tmpdir(), 'verify-fix-'));
+  const listPath = path.join(listDir, 'files.txt');
+  try {
+    fs.writeFileSync(listPath, `${files.join('\n')}\n`);
+    logger.info(`Indexing ${files.length} files again...`);
+    await indexRepos([repoArg], { filesFrom: listPath, branch: report.branch || undefined });
+  } finally {
+    fs.rmSync(listDir, { recursive: true, force: true });
+  }
+}
+
+export const verifyCommand = new Command('verify')
+  .description('Check that the index holds the documents the manifest of the last run recorded')
+  .argument('<repo>', 'Repository to check, as given to "index": name, path or URL, with an optional :index')
+  .addOption(
+    new Option('--manifest <path>', `Manifest to check against (default: ${MANIFEST_FILE_NAME} in the queue directory)`)
+  )
+  .addOption(new Option('--fix', 'Index files missing documents again and delete the documents of orphaned files'))
+  .addOption(new Option('--json', 'Print the report as JSON'))
+  .action(async (repoArg: string, options) => {
+    const { repoName, repoPath, indexName } = parseRepoArg(repoArg);
+    const manifestPath = path.resolve(options.manifest ?? path.join(getQueueDir(repoName), MANIFEST_FILE_NAME));
+    const logger = createLogger({ name: repoName, branch: 'unknown' });
+
+    let queue: ShardedQueue | undefined;
+    try {
+      const manifest = readManifest(manifestPath);
+      // Without a queue database, every file is expected in the index.
+      queue = fs.existsSync(getQueueDbPath(repoName)) ? await openExistingShardedQueue(repoName) : undefined;
+      const workQueue = queue;
+      const indexedFiles = await getIndexedFileSummaries(indexName, {
+        branch: manifest.branch || undefined,
+        repoName,
+      });
+      const report: VerifyReport = {
+        repoName,
+        indexName,
+        branch: manifest.branch,
+        manifestPath,
+        ...compareManifestWithIndex(manifest, indexedFiles, {
+          rootDir: repoPath,
+          isQueued: (entry) =>
+            workQueue !== undefined &&
+            entry.firstDocumentId !== undefined &&
+            entry.lastDocumentId !== undefined &&
+            workQueue.hasDocumentsInRange(entry.firstDocumentId, entry.lastDocumentId),
+        }),
+      };
+
+      if (options.json) {
+        console.log(JSON.stringify(report, null, 2));
+      } else {
+        console.log(`Verifying ${indexName} against ${manifestPath} (${report.checkedFiles} files):\n`);
+        console.log(`Files missing documents: ${report.missing.length}`);
+        for (const file of report.missing) {
+          console.log(`  ${file.filePath} (${file.found} of ${file.expected})`);
+        }
+        logFiles('Orphaned files', report.orphaned);
+        logFiles('Hash mismatches', report.hashMismatches);
+        console.log(`Still in the queue: ${report.queued.length} files`);
+        console.log(`Changed on disk since indexed: ${report.changed.length} files`);
+      }
+
+      if (!hasDiscrepancies(report)) {
+        if (!options.json) {
+          console.log('\nThe index matches the manifest.');
+        }
+        return;
+      }
+      if (!options.fix) {
+        if (!options.json) {
+          console.log(`\nRun "verify ${repoArg} --fix" to repair the index.`);
+        }
+        process.exitCode = 1;
+        return;
+      }
+      const fixQueue = queue;
+      queue = undefined;
+      await fixDiscrepancies(repoArg, report, fixQueue);
+    } catch (error) {
+      logger.error(`Failed to verify ${indexName}.`, { error: error instanceof Error ? error.message : String(error) });
+      process.exit(1);
+    } finally {
+      queue?.close();
+    }
+  });
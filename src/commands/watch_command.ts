@@ -0,0 +1,28 @@
+import { Command, Option } from 'commander';
+import { indexRepos } from './index_command';
+import { addIndexOptions } from './index_options';
+import { DEFAULT_WATCH_DEBOUNCE_MS } from './watch_files';
+import { logger } from '../utils/logger';
+import { shutdown } from '../utils/otel_provider';
+
+export const watchCommand = new Command('watch')
+  .description('Index a local repository, then re-index its files as they are saved')
+  .argument('<repo>', 'Repository path (format: path[:index])')
+  .addOption(new Option('--index <name>', 'Elasticsearch index to write to (overrides :index)'))
+  .addOption(
+    new Option(
+      '--debounce <ms>',
+      `Milliseconds without further changes before saved files are re-indexed (default: ${DEFAULT_WATCH_DEBOUNCE_MS})`
+    )
+  );
+
+addIndexOptions(watchCommand).action(async (repo, options) => {
+  try {
+    // The initial run is the one `index` makes: incremental when the repository was indexed before.
+    await indexRepos([repo], { ...options, watchFiles: true });
+  } catch (error) {
+    logger.error('Fatal error in watch command', { error });
+    await shutdown();
+    throw error;
+  }
+});
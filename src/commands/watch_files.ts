@@ -0,0 +1,241 @@
+import { execFileSync } from 'child_process';
+import fs from 'fs';
+import path from 'path';
+import { FileChanges, IncrementalIndexOptions, indexFileChanges } from './incremental_index_command';
+import {
+  createPathFilter,
+  DIRECTORY_IGNORE_FILES,
+  getRepositoryIgnoreFiles,
+  walkRepositoryFiles,
+} from '../utils/file_walker';
+import { FileWatcher } from '../utils/file_watcher';
+import { isShutdownRequested } from '../utils/graceful_shutdown';
+import { createLogger } from '../utils/logger';
+import { loadManifest, writeManifest } from '../utils/manifest';
+import { createMetrics } from '../utils/metrics';
+import { LanguageParser } from '../utils/parser';
+import { openQueue } from '../utils/queue_shards';
+
+/** Milliseconds without a new file system event before changed files are re-indexed. */
+export const DEFAULT_WATCH_DEBOUNCE_MS = 300;
+
+/** Root-level ignore file read by `getRepositoryIgnoreFiles`. */
+const INDEXER_IGNORE_FILE = '.indexerignore';
+
+export interface WatchFilesOptions extends IncrementalIndexOptions {
+  /** Milliseconds without a new event before changed files are re-indexed (default: 300). */
+  debounceMs?: number;
+  /** Whether to use a single recursive watch, see `FileWatcherOptions.recursive`. */
+  recursive?: boolean;
+}
+
+function readGitValue(directory: string, args: string[]): string | null {
+  try {
+    return execFileSync('git', args, { cwd: directory, stdio: ['ignore', 'pipe', 'ignore'] })
+      .toString()
+      .trim();
+  } catch {
+    return null;
+  }
+}
+
+function statPath(absolutePath: string): fs.Stats | undefined {
+  try {
+    return fs.statSync(absolutePath);
+  } catch {
+    return undefined;
+  }
+}
+
+/** Whether a changed path is an ignore file, whose change reloads the ignore rules. */
+export function isIgnoreFile(relativePath: string): boolean {
+  const name = path.posix.basename(relativePath);
+  return DIRECTORY_IGNORE_FILES.includes(name) || relativePath === INDEXER_IGNORE_FILE;
+}
+
+/**
+ * Splits the paths reported by a file watcher, relative to the repository root, into the files to
+ * re-index and the ones whose documents are deleted. A directory that appeared is walked for its
+ * files, and one that disappeared deletes the indexed files below it (`indexedFiles`), since a
+ * watcher reports a removed directory but not the files it held.
+ */
+export function classifyChangedPaths(
+  changedPaths: string[],
+  context: {
+    gitRoot: string;
+    indexedFiles: Set<string>;
+    isSupported: (relativePath: string) => boolean;
+    isExcluded: (relativePath: string, isDirectory?: boolean) => boolean;
+    walkDirectory: (relativeDir: string) => string[];
+  }
+): FileChanges {
+  const filesToIndex = new Set<string>();
+  const filesToDelete = new Set<string>();
+  const filesToPrune = new Set<string>();
+  const addFile = (file: string) => {
+    filesToIndex.add(file);
+    // Only files indexed before have locations to prune.
+    if (context.indexedFiles.has(file)) {
+      filesToPrune.add(file);
+    }
+  };
+
+  for (const relativePath of changedPaths) {
+    const stats = statPath(path.resolve(context.gitRoot, relativePath));
+    if (stats?.isDirectory()) {
+      if (!context.isExcluded(relativePath, true)) {
+        context.walkDirectory(relativePath).forEach(addFile);
+      }
+    } else if (stats?.isFile()) {
+      if (context.isExcluded(relativePath)) {
+        // As in an incremental run, a file that the ignore rules now exclude keeps its documents.
+        continue;
+      }
+      if (context.isSupported(relativePath)) {
+        addFile(relativePath);
+      } else if (context.indexedFiles.has(relativePath)) {
+        // Its language is no longer enabled or the extension map now skips it.
+        filesToDelete.add(relativePath);
+      }
+    } else {
+      // Removed or renamed away. A file that was enqueued but not indexed yet is not listed in
+      // `indexedFiles`, so supported paths are deleted either way.
+      if (context.isSupported(relativePath) || context.indexedFiles.has(relativePath)) {
+        filesToDelete.add(relativePath);
+      }
+      const prefix = `${relativePath}/`;
+      for (const file of context.indexedFiles) {
+        if (file.startsWith(prefix)) {
+          filesToDelete.add(file);
+        }
+      }
+    }
+  }
+
+  return {
+    filesToIndex: Array.from(filesToIndex),
+    filesToDelete: Array.from(filesToDelete).filter((file) => !filesToIndex.has(file)),
+    filesToPrune: Array.from(filesToPrune),
+  };
+}
+
+/**
+ * Watches a repository and re-indexes files as they are saved, through the same enqueue path as an
+ * incremental run, but for the changed files only: the tree is not walked or diffed again. Changes
+ * are batched by a debounce, so a burst of saves or a branch switch is enqueued once. The returned
+ * watcher must be closed to release its handles.
+ *
+ * The enqueued chunks are indexed by a worker running in watch mode, which the caller starts.
+ */
+export async function watchFiles(directory: string, options: WatchFilesOptions): Promise<FileWatcher> {
+  const repoName = options.repoName ?? path.basename(path.resolve(directory));
+  const gitBranch = options.branch ?? readGitValue(directory, ['rev-parse', '--abbrev-ref', 'HEAD']) ?? 'unknown';
+  const logger = createLogger({ name: repoName, branch: gitBranch });
+  const metrics = createMetrics({ name: repoName, branch: gitBranch });
+
+  // Directories that are not git repositories are watched from the directory itself.
+  // Resolved like the git root, so paths below a symlinked directory are made relative to it correctly.
+  const watchedDir = fs.realpathSync(directory);
+  const gitRoot = readGitValue(directory, ['rev-parse', '--show-toplevel']) ?? watchedDir;
+  const languageParser = new LanguageParser(options.languages, { extensionMap: options.extensionMap });
+  const isSupported = (file: string) => languageParser.getLanguageConfigForFile(file) !== undefined;
+
+  const ignoreFiles = getRepositoryIgnoreFiles(gitRoot, {
+    ignorePath: options.ignorePath,
+    useIgnoreFiles: options.useIgnoreFiles,
+  });
+  const loadPathFilter = () =>
+    createPathFilter({
+      rootDir: gitRoot,
+      ignoreFiles,
+      excludePatterns: options.excludePatterns,
+      includePatterns: options.includePatterns,
+      useIgnoreFiles: options.useIgnoreFiles,
+    });
+  let isExcluded = loadPathFilter();
+
+  const queue = await openQueue(options.queueDir, { repoName, branch: gitBranch, shards: options.queueShards });
+
+  // The watcher reports paths relative to the watched directory, the index uses the repository root.
+  const toRepoPath = (watchedPath: string) =>
+    path.relative(gitRoot, path.join(watchedDir, watchedPath)).split(path.sep).join('/');
+
+  const reindex = async (watchedPaths: string[]) => {
+    if (isShutdownRequested()) {
+      return;
+    }
+    const changedPaths = watchedPaths.map(toRepoPath);
+    if (changedPaths.some(isIgnoreFile)) {
+      // Applies to the files saved from now on; files the rules now include or exclude keep their state.
+      logger.info('Ignore rules changed, reloading them.');
+      isExcluded = loadPathFilter();
+    }
+
+    const changes = classifyChangedPaths(
+      changedPaths.filter((changedPath) => !isIgnoreFile(changedPath)),
+      {
+        gitRoot,
+        indexedFiles: new Set(queue.getFileHashes().keys()),
+        isSupported,
+        isExcluded: (relativePath, isDirectory) => isExcluded(relativePath, isDirectory),
+        walkDirectory: (relativeDir) =>
+          walkRepositoryFiles({
+            rootDir: gitRoot,
+            searchDir: path.join(gitRoot, relativeDir),
+            includeFile: isSupported,
+            ignoreFiles,
+            excludePatterns: options.excludePatterns,
+            includePatterns: options.includePatterns,
+            useIgnoreFiles: options.useIgnoreFiles,
+          }).files,
+      }
+    );
+    if (changes.filesToIndex.length === 0 && changes.filesToDelete.length === 0) {
+      return;
+    }
+
+    logger.info('Files changed, updating the index...', {
+      toIndex: changes.filesToIndex.length,
+      toDelete: changes.filesToDelete.length,
+    });
+    const manifest = options.manifestPath ? loadManifest(options.manifestPath, repoName, gitBranch) : undefined;
+    const result = await indexFileChanges(
+      changes,
+      {
+        gitRoot,
+        gitBranch,
+        repoName,
+        // Read before parsing, so chunks are tagged with the commit their content was read at.
+        commitHash: readGitValue(directory, ['rev-parse', 'HEAD']) ?? undefined,
+        queue,
+        languageParser,
+        logger,
+        metrics,
+        manifest,
+      },
+      options
+    );
+    if (manifest && options.manifestPath && !result.interrupted) {
+      writeManifest(options.manifestPath, manifest);
+    }
+  };
+
+  const watcher = new FileWatcher({
+    rootDir: watchedDir,
+    debounceMs: options.debounceMs ?? DEFAULT_WATCH_DEBOUNCE_MS,
+    onChange: reindex,
+    // Ignore files are dotfiles, which are otherwise never indexed, but they change what is.
+    isExcluded: (watchedPath, isDirectory) => {
+      const relativePath = toRepoPath(watchedPath);
+      return !isIgnoreFile(relativePath) && isExcluded(relativePath, isDirectory);
+    },
+    recursive: options.recursive,
+    logger: logger.child({ module: 'watch' }),
+  });
+  watcher.start();
+  logger.info(`Watching ${watchedDir} for changes`, {
+    recursive: watcher.isRecursive,
+    watchedDirectories: watcher.watchedDirectoryCount,
+  });
+  return watcher;
+}
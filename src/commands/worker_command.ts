@@ -1,47 +1,119 @@
 import { IndexerWorker } from '../utils/indexer_worker';
-import { createLogger } from '../utils/logger';
+import { createLogger, setLogPhase } from '../utils/logger';
 import { SqliteQueue } from '../utils/sqlite_queue';
-import { createIndex, createLocationsIndex } from '../utils/elasticsearch';
-import path from 'path';
+import { openQueueShards } from '../utils/queue_shards';
+import { ProgressReporter } from '../utils/progress_reporter';
+import { EmbeddingProvider } from '../utils/embedding_provider';
+import { createIndex, createLocationsIndex, deleteUnreferencedChunks } from '../utils/elasticsearch';
+import { indexingConfig } from '../config';
 
 export interface WorkerOptions {
   queueDir: string;
+  /** Shards of the queue, each drained by its own indexer worker (default: 1). */
+  queueShards?: number;
   elasticsearchIndex: string;
   batchSize?: number;
+  bulkMinSize?: number;
+  bulkMaxSize?: number;
   repoName?: string;
   branch?: string;
+  maxAttempts?: number;
+  progress?: ProgressReporter;
+  embeddingProvider?: EmbeddingProvider;
+  /** Batches embedded and bulk indexed at once when the two run as separate stages, see `IndexerWorkerOptions`. */
+  embedConcurrency?: number;
+  indexConcurrency?: number;
+  /** Share one chunk document between files with identical chunk content (default: true). */
+  dedup?: boolean;
+  /** Store chunk content gzip-compressed, with a searchable excerpt (default: false). */
+  storeCompressed?: boolean;
+  /** Store chunk content in the chunk documents (default: true). */
+  storeContent?: boolean;
 }
 
 export async function worker(concurrency: number = 1, watch: boolean = false, options: WorkerOptions) {
   const repoInfo =
     options?.repoName && options?.branch ? { name: options.repoName, branch: options.branch } : undefined;
   const logger = createLogger(repoInfo);
+  setLogPhase('bulk');
   const batchSize = options.batchSize ?? 100;
 
-  logger.info('Starting indexer worker process', { concurrency, batchSize, ...options });
+  const { progress, embeddingProvider, ...loggedOptions } = options;
+  logger.info('Starting indexer worker process', { concurrency, batchSize, ...loggedOptions });
 
   // The worker can be run standalone (without going through the index command). Ensure the new
   // locations index exists so `indexCodeChunks` doesn't fail and leave rows stuck in processing.
-  await createIndex(options.elasticsearchIndex);
+  await createIndex(options.elasticsearchIndex, {
+    vectorDims: embeddingProvider?.dimensions,
+    storeCompressed: options.storeCompressed,
+  });
   await createLocationsIndex(options.elasticsearchIndex);
 
-  const queuePath = path.join(options.queueDir, 'queue.db');
-  const queue = new SqliteQueue({
-    dbPath: queuePath,
+  const queues = await openQueueShards(options.queueDir, {
     repoName: options?.repoName,
     branch: options?.branch,
+    maxAttempts: options?.maxAttempts,
+    shards: options.queueShards,
   });
-  await queue.initialize();
-
-  const indexerWorker = new IndexerWorker({
-    queue,
-    batchSize,
-    concurrency,
-    watch,
-    logger,
-    elasticsearchIndex: options.elasticsearchIndex,
-    repoInfo,
-  });
+  // No document has been claimed by this worker yet, so the files can be compacted safely.
+  for (const queue of queues) {
+    queue.compact({ minFreeBytes: indexingConfig.queueVacuumMinFreeMb * 1024 * 1024 });
+  }
+
+  // Each shard is drained by its own worker, with an even share of the concurrency.
+  const shareOf = (shard: number) =>
+    Math.max(1, Math.floor(concurrency / queues.length) + (shard < concurrency % queues.length ? 1 : 0));
+  const indexerWorkers = queues.map(
+    (queue, shard) =>
+      new IndexerWorker({
+        queue,
+        batchSize,
+        bulkMinSize: options.bulkMinSize,
+        bulkMaxSize: options.bulkMaxSize,
+        concurrency: shareOf(shard),
+        watch,
+        logger,
+        elasticsearchIndex: options.elasticsearchIndex,
+        repoInfo,
+        progress,
+        embeddingProvider,
+        embedConcurrency: options.embedConcurrency,
+        indexConcurrency: options.indexConcurrency,
+        dedup: options.dedup,
+        storeCompressed: options.storeCompressed,
+        storeContent: options.storeContent,
+        ...(queues.length > 1 ? { shard } : {}),
+      })
+  );
+
+  const sum = (count: (queue: SqliteQueue) => number) => queues.reduce((total, queue) => total + count(queue), 0);
+  progress?.startIndexing(() => ({
+    remaining: sum((queue) => queue.getRemainingCount()),
+    completed: sum((queue) => queue.getCompletedCount()),
+    enqueueCompleted: queues.every((queue) => queue.isEnqueueCompleted()),
+  }));
+  await Promise.all(indexerWorkers.map((indexerWorker) => indexerWorker.start()));
+
+  // Only checked now: a chunk one shard's worker replaced may be referenced by a location another one wrote.
+  const replacedChunkIds = new Set(indexerWorkers.flatMap((indexerWorker) => indexerWorker.getReplacedChunkIds()));
+  try {
+    await deleteUnreferencedChunks(Array.from(replacedChunkIds), options.elasticsearchIndex);
+  } catch (error) {
+    logger.warn('Could not delete the chunk documents of overwritten symbol locations', {
+      error: error instanceof Error ? error.message : String(error),
+    });
+  }
 
-  await indexerWorker.start();
+  const deadLettered = sum((queue) => queue.getDeadLetteredCount());
+  if (deadLettered > 0) {
+    const shardCounts = queues
+      .map((queue, shard) => ({ shard, count: queue.getDeadLetteredCount() }))
+      .filter(({ count }) => count > 0)
+      .map(({ shard, count }) => `shard ${shard}: ${count}`);
+    const perShard = queues.length > 1 ? ` (${shardCounts.join(', ')})` : '';
+    logger.warn(
+      `${deadLettered} items dead-lettered${perShard}. ` +
+        'Inspect them with queue:inspect-failures and requeue them with queue:requeue-dead-letter.'
+    );
+  }
 }
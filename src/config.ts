@@ -72,13 +72,28 @@ function parseEnvBoolean(envVarName: string, fallback: boolean): boolean {
 const envFile = process.env.NODE_ENV === 'test' ? '.env.test' : '.env';
 dotenv.config({ path: path.join(projectRoot, envFile), override: false, quiet: true });
 
+/** Sets an environment variable, or deletes it for `undefined`. */
+function setEnv(envVarName: string, value: string | undefined): void {
+  if (value === undefined) {
+    delete process.env[envVarName];
+  } else {
+    process.env[envVarName] = value;
+  }
+}
+
 export const elasticsearchConfig = {
   get endpoint() {
     return process.env.ELASTICSEARCH_ENDPOINT;
   },
+  set endpoint(v: string | undefined) {
+    setEnv('ELASTICSEARCH_ENDPOINT', v);
+  },
   get cloudId() {
     return process.env.ELASTICSEARCH_CLOUD_ID || undefined;
   },
+  set cloudId(v: string | undefined) {
+    setEnv('ELASTICSEARCH_CLOUD_ID', v);
+  },
   get username() {
     return process.env.ELASTICSEARCH_USERNAME;
   },
@@ -88,6 +103,23 @@ export const elasticsearchConfig = {
   get apiKey() {
     return process.env.ELASTICSEARCH_API_KEY || undefined;
   },
+  set apiKey(v: string | undefined) {
+    setEnv('ELASTICSEARCH_API_KEY', v);
+  },
+  /** Path of a PEM file with the CA certificate(s) the cluster's TLS certificate is verified against. */
+  get caCert() {
+    return process.env.ELASTICSEARCH_CA_CERT || undefined;
+  },
+  set caCert(v: string | undefined) {
+    setEnv('ELASTICSEARCH_CA_CERT', v);
+  },
+  /** Skips TLS certificate verification, for development clusters with self-signed certificates. */
+  get insecure() {
+    return parseEnvBoolean('ELASTICSEARCH_INSECURE', false);
+  },
+  set insecure(v: boolean) {
+    process.env.ELASTICSEARCH_INSECURE = v ? 'true' : 'false';
+  },
   get inferenceId() {
     return process.env.SCS_IDXR_ELASTICSEARCH_INFERENCE_ID || undefined;
   },
@@ -97,6 +129,84 @@ export const elasticsearchConfig = {
   get disableSemanticText() {
     return parseEnvBoolean('SCS_IDXR_DISABLE_SEMANTIC_TEXT', false);
   },
+  get denseVectorModelId() {
+    return process.env.SCS_IDXR_DENSE_VECTOR_MODEL_ID || undefined;
+  },
+  get denseVectorDims() {
+    return parseEnvPositiveInt('SCS_IDXR_DENSE_VECTOR_DIMS', 768);
+  },
+  set denseVectorDims(v: number) {
+    process.env.SCS_IDXR_DENSE_VECTOR_DIMS = v.toString();
+  },
+  /** Similarity of the `code_vector` mapping: `cosine`, `dot_product` or `l2_norm`. */
+  get denseVectorSimilarity() {
+    return process.env.SCS_IDXR_DENSE_VECTOR_SIMILARITY || 'cosine';
+  },
+  set denseVectorSimilarity(v: string) {
+    process.env.SCS_IDXR_DENSE_VECTOR_SIMILARITY = v;
+  },
+  /** Quantization of the `code_vector` index, see `--vector-quantization`. Elasticsearch's default when unset. */
+  get denseVectorQuantization() {
+    return process.env.SCS_IDXR_DENSE_VECTOR_QUANTIZATION || undefined;
+  },
+  set denseVectorQuantization(v: string | undefined) {
+    setEnv('SCS_IDXR_DENSE_VECTOR_QUANTIZATION', v);
+  },
+  /** JSON file whose mappings (and optional settings) replace the generated code chunk index body. */
+  get mappingFile() {
+    return process.env.SCS_IDXR_MAPPING_FILE || undefined;
+  },
+  set mappingFile(v: string | undefined) {
+    setEnv('SCS_IDXR_MAPPING_FILE', v);
+  },
+};
+
+export const embeddingConfig = {
+  get apiKey() {
+    return process.env.SCS_IDXR_EMBEDDING_API_KEY || undefined;
+  },
+  get openaiApiKey() {
+    return process.env.OPENAI_API_KEY || undefined;
+  },
+  get cohereApiKey() {
+    return process.env.COHERE_API_KEY || undefined;
+  },
+
+  // Normalization of the text that is embedded, see `normalizeEmbeddingText`. Stored content is never changed.
+  get stripComments() {
+    return parseEnvBoolean('SCS_IDXR_EMBED_STRIP_COMMENTS', false);
+  },
+  set stripComments(v: boolean) {
+    process.env.SCS_IDXR_EMBED_STRIP_COMMENTS = v ? 'true' : 'false';
+  },
+
+  get stripLicense() {
+    return parseEnvBoolean('SCS_IDXR_EMBED_STRIP_LICENSE', false);
+  },
+  set stripLicense(v: boolean) {
+    process.env.SCS_IDXR_EMBED_STRIP_LICENSE = v ? 'true' : 'false';
+  },
+
+  get collapseWhitespace() {
+    return parseEnvBoolean('SCS_IDXR_EMBED_COLLAPSE_WHITESPACE', false);
+  },
+  set collapseWhitespace(v: boolean) {
+    process.env.SCS_IDXR_EMBED_COLLAPSE_WHITESPACE = v ? 'true' : 'false';
+  },
+
+  get lowercase() {
+    return parseEnvBoolean('SCS_IDXR_EMBED_LOWERCASE', false);
+  },
+  set lowercase(v: boolean) {
+    process.env.SCS_IDXR_EMBED_LOWERCASE = v ? 'true' : 'false';
+  },
+
+  get headerTemplate() {
+    return process.env.SCS_IDXR_EMBED_HEADER_TEMPLATE || undefined;
+  },
+  set headerTemplate(v: string | undefined) {
+    setEnv('SCS_IDXR_EMBED_HEADER_TEMPLATE', v);
+  },
 };
 
 export const otelConfig = {
@@ -147,6 +257,13 @@ export const indexingConfig = {
     process.env.SCS_IDXR_MAX_CHUNK_SIZE_BYTES = v.toString();
   },
 
+  get maxFileSizeBytes() {
+    return parseEnvPositiveInt('SCS_IDXR_MAX_FILE_SIZE_BYTES', 2 * 1024 * 1024);
+  },
+  set maxFileSizeBytes(v: number) {
+    process.env.SCS_IDXR_MAX_FILE_SIZE_BYTES = v.toString();
+  },
+
   get enableDenseVectors() {
     return parseEnvBoolean('SCS_IDXR_ENABLE_DENSE_VECTORS', false);
   },
@@ -168,6 +285,34 @@ export const indexingConfig = {
     process.env.SCS_IDXR_CHUNK_OVERLAP_LINES = v.toString();
   },
 
+  get maxCodeChunkChars() {
+    return parseEnvNonNegativeInt('SCS_IDXR_MAX_CODE_CHUNK_CHARS', 0);
+  },
+  set maxCodeChunkChars(v: number) {
+    process.env.SCS_IDXR_MAX_CODE_CHUNK_CHARS = v.toString();
+  },
+
+  get maxChunkTokens() {
+    return parseEnvNonNegativeInt('SCS_IDXR_MAX_CHUNK_TOKENS', 0);
+  },
+  set maxChunkTokens(v: number) {
+    process.env.SCS_IDXR_MAX_CHUNK_TOKENS = v.toString();
+  },
+
+  get chunksPerFileWarning() {
+    return parseEnvNonNegativeInt('SCS_IDXR_CHUNKS_PER_FILE_WARNING', 1000);
+  },
+  set chunksPerFileWarning(v: number) {
+    process.env.SCS_IDXR_CHUNKS_PER_FILE_WARNING = v.toString();
+  },
+
+  get codeChunkOverlapChars() {
+    return parseEnvNonNegativeInt('SCS_IDXR_CODE_CHUNK_OVERLAP_CHARS', 256);
+  },
+  set codeChunkOverlapChars(v: number) {
+    process.env.SCS_IDXR_CODE_CHUNK_OVERLAP_CHARS = v.toString();
+  },
+
   get markdownChunkDelimiter() {
     return process.env.SCS_IDXR_MARKDOWN_CHUNK_DELIMITER || '\\n\\s*\\n';
   },
@@ -175,6 +320,90 @@ export const indexingConfig = {
     process.env.SCS_IDXR_MARKDOWN_CHUNK_DELIMITER = v;
   },
 
+  get queueMaxAttempts() {
+    return parseEnvPositiveInt('SCS_IDXR_QUEUE_MAX_ATTEMPTS', 3);
+  },
+  set queueMaxAttempts(v: number) {
+    process.env.SCS_IDXR_QUEUE_MAX_ATTEMPTS = v.toString();
+  },
+
+  get queueRetryBaseDelayMs() {
+    return parseEnvNonNegativeInt('SCS_IDXR_QUEUE_RETRY_BASE_DELAY_MS', 1000);
+  },
+  set queueRetryBaseDelayMs(v: number) {
+    process.env.SCS_IDXR_QUEUE_RETRY_BASE_DELAY_MS = v.toString();
+  },
+
+  get queueRetryMaxDelayMs() {
+    return parseEnvNonNegativeInt('SCS_IDXR_QUEUE_RETRY_MAX_DELAY_MS', 5 * 60 * 1000);
+  },
+  set queueRetryMaxDelayMs(v: number) {
+    process.env.SCS_IDXR_QUEUE_RETRY_MAX_DELAY_MS = v.toString();
+  },
+
+  get queueVisibilityTimeoutMs() {
+    return parseEnvPositiveInt('SCS_IDXR_QUEUE_VISIBILITY_TIMEOUT_MS', 5 * 60 * 1000);
+  },
+  set queueVisibilityTimeoutMs(v: number) {
+    process.env.SCS_IDXR_QUEUE_VISIBILITY_TIMEOUT_MS = v.toString();
+  },
+
+  get queueVacuumMinFreeMb() {
+    return parseEnvNonNegativeInt('SCS_IDXR_QUEUE_VACUUM_MIN_FREE_MB', 64);
+  },
+  set queueVacuumMinFreeMb(v: number) {
+    process.env.SCS_IDXR_QUEUE_VACUUM_MIN_FREE_MB = v.toString();
+  },
+
+  get queueWalTruncateMb() {
+    return parseEnvPositiveInt('SCS_IDXR_QUEUE_WAL_TRUNCATE_MB', 64);
+  },
+  set queueWalTruncateMb(v: number) {
+    process.env.SCS_IDXR_QUEUE_WAL_TRUNCATE_MB = v.toString();
+  },
+
+  get enqueueHighWaterMark() {
+    return parseEnvPositiveInt('SCS_IDXR_ENQUEUE_HIGH_WATER_MARK', 10000);
+  },
+  set enqueueHighWaterMark(v: number) {
+    process.env.SCS_IDXR_ENQUEUE_HIGH_WATER_MARK = v.toString();
+  },
+
+  get shutdownTimeoutMs() {
+    return parseEnvPositiveInt('SCS_IDXR_SHUTDOWN_TIMEOUT_MS', 30 * 1000);
+  },
+  set shutdownTimeoutMs(v: number) {
+    process.env.SCS_IDXR_SHUTDOWN_TIMEOUT_MS = v.toString();
+  },
+
+  get workerHeartbeatTimeoutMs() {
+    return parseEnvPositiveInt('SCS_IDXR_WORKER_HEARTBEAT_TIMEOUT_MS', 5 * 60 * 1000);
+  },
+  set workerHeartbeatTimeoutMs(v: number) {
+    process.env.SCS_IDXR_WORKER_HEARTBEAT_TIMEOUT_MS = v.toString();
+  },
+
+  get bulkItemMaxRetries() {
+    return parseEnvNonNegativeInt('SCS_IDXR_BULK_ITEM_MAX_RETRIES', 3);
+  },
+  set bulkItemMaxRetries(v: number) {
+    process.env.SCS_IDXR_BULK_ITEM_MAX_RETRIES = v.toString();
+  },
+
+  get bulkItemRetryBaseDelayMs() {
+    return parseEnvNonNegativeInt('SCS_IDXR_BULK_ITEM_RETRY_BASE_DELAY_MS', 500);
+  },
+  set bulkItemRetryBaseDelayMs(v: number) {
+    process.env.SCS_IDXR_BULK_ITEM_RETRY_BASE_DELAY_MS = v.toString();
+  },
+
+  get bulkTargetLatencyMs() {
+    return parseEnvNonNegativeInt('SCS_IDXR_BULK_TARGET_LATENCY_MS', 30 * 1000);
+  },
+  set bulkTargetLatencyMs(v: number) {
+    process.env.SCS_IDXR_BULK_TARGET_LATENCY_MS = v.toString();
+  },
+
   get testThrowOnFilePath() {
     return process.env.SCS_IDXR_TEST_INDEXING_THROW_ON_FILEPATH;
   },
@@ -229,4 +458,35 @@ export const appConfig = {
   set forceLogging(v: boolean) {
     process.env.SCS_IDXR_FORCE_LOGGING = v ? 'true' : 'false';
   },
+
+  get chunkGranularity() {
+    return process.env.SCS_IDXR_CHUNK_GRANULARITY;
+  },
+  set chunkGranularity(v: string | undefined) {
+    if (v === undefined) delete process.env.SCS_IDXR_CHUNK_GRANULARITY;
+    else process.env.SCS_IDXR_CHUNK_GRANULARITY = v;
+  },
+
+  get extensionMap() {
+    return process.env.SCS_IDXR_EXTENSION_MAP;
+  },
+  set extensionMap(v: string | undefined) {
+    if (v === undefined) delete process.env.SCS_IDXR_EXTENSION_MAP;
+    else process.env.SCS_IDXR_EXTENSION_MAP = v;
+  },
+
+  get logFormat() {
+    return process.env.SCS_IDXR_LOG_FORMAT === 'json' ? 'json' : 'text';
+  },
+  set logFormat(v: 'text' | 'json') {
+    process.env.SCS_IDXR_LOG_FORMAT = v;
+  },
+
+  get logLevel() {
+    return process.env.SCS_IDXR_LOG_LEVEL;
+  },
+  set logLevel(v: string | undefined) {
+    if (v === undefined) delete process.env.SCS_IDXR_LOG_LEVEL;
+    else process.env.SCS_IDXR_LOG_LEVEL = v;
+  },
 };
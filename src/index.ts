@@ -1,16 +1,31 @@
 import './config'; // Must be the first import
 import { Command } from 'commander';
 import { indexCommand } from './commands/index_command';
+import { indexStatusCommand } from './commands/index_status_command';
+import { indexStatsCommand } from './commands/index_stats_command';
 import { setupCommand } from './commands/setup_command';
 import { clearQueueCommand } from './commands/clear_queue_command';
 import { dumpTreeCommand } from './commands/dump_tree_command';
+import { eventsCommand } from './commands/events_command';
+import { exportQueueCommand } from './commands/export_queue_command';
+import { exportSymbolsCommand } from './commands/export_symbols_command';
+import { importQueueCommand } from './commands/import_queue_command';
+import { inspectFailuresCommand } from './commands/inspect_failures_command';
 import { listFailedCommand } from './commands/list_failed_command';
+import { maintainQueueCommand } from './commands/maintain_queue_command';
 import { monitorQueueCommand } from './commands/monitor_queue_command';
 import { referencesCommand } from './commands/references_command';
-import { retryFailedCommand } from './commands/retry_failed_command';
+import { requeueDeadLetterCommand } from './commands/requeue_dead_letter_command';
 import { scaffoldLanguageCommand } from './commands/scaffold_language_command';
 import { searchCommand } from './commands/search_command';
+import { serveCommand } from './commands/serve_command';
+import { verifyCommand } from './commands/verify_command';
+import { watchCommand } from './commands/watch_command';
+import { addElasticsearchOptions, applyElasticsearchOptions } from './commands/elasticsearch_options';
 import { shutdown } from './utils/otel_provider';
+import { drainForShutdown } from './utils/graceful_shutdown';
+import { pauseIndexing, resumeIndexing } from './utils/pause_control';
+import { indexingConfig } from './config';
 import { validateAllLanguageConfigurations } from './languages';
 
 async function main() {
@@ -21,48 +36,102 @@ async function main() {
 
   program.name('code-indexer').version('1.0.0').description('A CLI for indexing codebases into Elasticsearch');
 
+  // Client flags apply to every command, before or after its name.
+  addElasticsearchOptions(program);
+  program.hook('preAction', () => applyElasticsearchOptions(program.opts()));
+
   // Main command
   program.addCommand(indexCommand);
+  program.addCommand(watchCommand);
+  program.addCommand(serveCommand);
+  program.addCommand(eventsCommand);
 
   // Utility commands
   program.addCommand(setupCommand);
   program.addCommand(clearQueueCommand);
   program.addCommand(dumpTreeCommand);
+  program.addCommand(indexStatsCommand);
+  program.addCommand(indexStatusCommand);
+  program.addCommand(exportQueueCommand);
+  program.addCommand(exportSymbolsCommand);
+  program.addCommand(importQueueCommand);
+  program.addCommand(inspectFailuresCommand);
   program.addCommand(listFailedCommand);
+  program.addCommand(maintainQueueCommand);
   program.addCommand(monitorQueueCommand);
   program.addCommand(referencesCommand);
-  program.addCommand(retryFailedCommand);
+  program.addCommand(requeueDeadLetterCommand);
   program.addCommand(scaffoldLanguageCommand);
   program.addCommand(searchCommand);
+  program.addCommand(verifyCommand);
 
   await program.parseAsync(process.argv);
 }
 
 // Graceful shutdown handlers
+let isShuttingDown = false;
+
+// Conventional exit codes for a process stopped by a signal (128 + signal number).
+const SIGNAL_EXIT_CODES: Record<string, number> = { SIGINT: 130, SIGTERM: 143 };
+
 /**
  * Handles graceful shutdown of the application.
  *
- * Flushes any pending OpenTelemetry logs to the collector before exiting.
+ * Stops dequeuing and parsing new files, waits up to `SCS_IDXR_SHUTDOWN_TIMEOUT_MS` for in-flight
+ * bulk requests, embedding calls and parsed files to be committed and for the producer workers to
+ * acknowledge, flushes the queue and any pending OpenTelemetry logs, then exits with a non-zero code
+ * so callers can tell the run was interrupted. A second signal exits immediately.
  * Called on SIGTERM and SIGINT signals to ensure clean application termination.
  *
  * @param signal - The signal name that triggered the shutdown (e.g., 'SIGTERM', 'SIGINT').
  */
 async function handleShutdown(signal: string) {
-  console.log(`\nReceived ${signal}, shutting down gracefully...`);
+  const exitCode = SIGNAL_EXIT_CODES[signal] ?? 1;
+  if (isShuttingDown) {
+    console.log(`\nReceived ${signal} again, exiting without waiting for in-flight batches.`);
+    process.exit(exitCode);
+  }
+  isShuttingDown = true;
+  const timeoutMs = indexingConfig.shutdownTimeoutMs;
+  console.log(`\nReceived ${signal}, finishing in-flight work (up to ${timeoutMs}ms, press Ctrl+C again to force)...`);
   try {
+    const drained = await drainForShutdown(timeoutMs);
+    if (drained) {
+      console.log('Shutdown complete: in-flight batches were committed. Run the command again to continue.');
+    } else {
+      console.log(
+        `Timed out after ${timeoutMs}ms waiting for in-flight work. Unfinished batches are retried on the next run.`
+      );
+    }
     await shutdown();
-    process.exit(0);
   } catch (error) {
     console.error('Error during shutdown:', error);
-    process.exit(1);
   }
+  process.exit(exitCode);
 }
 
 process.on('SIGTERM', () => handleShutdown('SIGTERM'));
 process.on('SIGINT', () => handleShutdown('SIGINT'));
 
+// SIGUSR1 pauses dequeuing and SIGUSR2 resumes it, e.g. to keep the cluster free during peak hours.
+// Windows has neither signal; use --pause-file there.
+if (process.platform !== 'win32') {
+  process.on('SIGUSR1', () => {
+    pauseIndexing();
+    console.log('\nReceived SIGUSR1, pausing indexing after the in-flight batches (send SIGUSR2 to resume)...');
+  });
+  process.on('SIGUSR2', () => {
+    resumeIndexing();
+    console.log('\nReceived SIGUSR2, resuming indexing.');
+  });
+}
+
 main()
   .then(async () => {
+    // A command that stopped because of a signal returns early; the signal handler sets the exit code.
+    if (isShuttingDown) {
+      return;
+    }
     await shutdown();
     process.exit(0);
   })
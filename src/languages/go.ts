@@ -58,6 +58,11 @@ export const goConfig: LanguageConfiguration = {
     '(function_declaration name: (identifier) @function.name)',
     '(method_declaration name: (field_identifier) @method.name)',
     '(type_spec name: (type_identifier) @type.name)',
+    '(field_declaration name: (field_identifier) @field.name)',
+    '(method_elem name: (field_identifier) @method.name)',
+    // Embedded structs and interfaces, e.g. `*Base` in a struct or `io.Reader` in an interface.
+    '(field_declaration !name type: [(type_identifier) (qualified_type)] @type.embedded)',
+    '(type_elem . [(type_identifier) (qualified_type)] @type.embedded .)',
     '(var_spec name: (identifier) @variable.name)',
     '(call_expression function: (identifier) @function.call)',
     '(call_expression function: (selector_expression field: (field_identifier)) @method.call)',
@@ -1,9 +1,12 @@
 // src/languages/index.ts
 import { typescript } from './typescript';
+import { tsx } from './tsx';
 import { javascript } from './javascript';
+import { jsx } from './jsx';
 import { markdown } from './markdown';
 import { yamlConfig } from './yaml';
 import { javaConfig } from './java';
+import { kotlinConfig } from './kotlin';
 import { goConfig } from './go';
 import { pythonConfig } from './python';
 import { jsonConfig } from './json';
@@ -17,6 +20,7 @@ import { bashConfig } from './bash';
 import { scalaConfig } from './scala';
 import { hclConfig } from './hcl';
 import { plpgsqlConfig } from './plpgsql';
+import { rustConfig } from './rust';
 import { LanguageConfiguration } from '../utils/parser';
 import {
   validateLanguageConfiguration,
@@ -26,10 +30,13 @@ import {
 
 export const languageConfigurations = {
   typescript,
+  tsx,
   javascript,
+  jsx,
   markdown,
   yaml: yamlConfig,
   java: javaConfig,
+  kotlin: kotlinConfig,
   go: goConfig,
   python: pythonConfig,
   json: jsonConfig,
@@ -43,6 +50,7 @@ export const languageConfigurations = {
   scala: scalaConfig,
   hcl: hclConfig,
   plpgsql: plpgsqlConfig,
+  rust: rustConfig,
 } as const;
 
 /**
@@ -9,8 +9,13 @@ export const javaConfig: LanguageConfiguration = {
     '(import_declaration) @import',
     '(if_statement) @if',
     '(return_statement) @return',
+    // Annotations such as `@GetMapping` are part of the declaration's `modifiers`, so they stay in its chunk.
     '(method_declaration) @method',
+    '(constructor_declaration) @method',
     '(class_declaration) @class',
+    '(interface_declaration) @interface',
+    '(enum_declaration) @enum',
+    '(annotation_type_declaration) @interface',
     '(line_comment) @comment',
     '(block_comment) @comment',
     '(marker_annotation) @annotation',
@@ -40,7 +45,11 @@ export const javaConfig: LanguageConfiguration = {
   importQueries: ['(import_declaration (scoped_identifier (identifier) @import.symbol) @import.path)'],
   symbolQueries: [
     '(class_declaration name: (identifier) @class.name)',
+    '(interface_declaration name: (identifier) @interface.name)',
+    '(enum_declaration name: (identifier) @enum.name)',
+    '(annotation_type_declaration name: (identifier) @interface.name)',
     '(method_declaration name: (identifier) @method.name)',
+    '(constructor_declaration name: (identifier) @method.name)',
     '(variable_declarator (identifier) @variable.name)',
     '(method_invocation name: (identifier) @method.call)',
     '(object_creation_expression type: (type_identifier) @class.instantiation)',
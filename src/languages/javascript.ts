@@ -4,7 +4,7 @@ import { LanguageConfiguration } from '../utils/parser';
 
 export const javascript: LanguageConfiguration = {
   name: 'javascript',
-  fileSuffixes: ['.js', '.jsx'],
+  fileSuffixes: ['.js'],
   parser: js,
   queries: [
     '(import_statement) @import',
@@ -66,6 +66,10 @@ export const javascript: LanguageConfiguration = {
     '(import_statement (import_clause (namespace_import (identifier) @import.symbol)) source: (string) @import.path)',
     '(import_statement (import_clause (identifier) @import.symbol) source: (string) @import.path)',
     '(import_statement source: (string) @import.path)',
+    // CommonJS `require('x')` calls, with the names bound by `const x = ...` or `const { a } = ...`.
+    '(variable_declarator name: (identifier) @import.symbol value: (call_expression function: (identifier) @require (#eq? @require "require") arguments: (arguments (string) @import.path)))',
+    '(variable_declarator name: (object_pattern (shorthand_property_identifier_pattern) @import.symbol) value: (call_expression function: (identifier) @require (#eq? @require "require") arguments: (arguments (string) @import.path)))',
+    '(call_expression function: (identifier) @require (#eq? @require "require") arguments: (arguments (string) @import.path))',
   ],
   symbolQueries: [
     '(function_declaration name: (identifier) @function.name)',
@@ -0,0 +1,44 @@
+// src/languages/jsx.ts
+import js from 'tree-sitter-javascript';
+import { LanguageConfiguration } from '../utils/parser';
+import { javascript } from './javascript';
+
+/**
+ * Names module-scope consts holding a component wrapped in a call, such as `memo(() => ...)` or
+ * `forwardRef(function Input(props, ref) { ... })`, as functions.
+ */
+export const WRAPPED_COMPONENT_SYMBOL_QUERIES = [
+  '(program (lexical_declaration (variable_declarator name: (identifier) @function.name value: (call_expression arguments: (arguments [(arrow_function) (function_expression)])))))',
+  '(program (export_statement (lexical_declaration (variable_declarator name: (identifier) @function.name value: (call_expression arguments: (arguments [(arrow_function) (function_expression)]))))))',
+];
+
+/**
+ * JSX files are chunked by their module-scope statements only, so a component, hook or class
+ * component is one chunk with its JSX body intact instead of one chunk per nested statement and
+ * call. Class methods are still chunked on their own, under their class.
+ */
+export const jsx: LanguageConfiguration = {
+  ...javascript,
+  name: 'jsx',
+  fileSuffixes: ['.jsx'],
+  parser: js,
+  queries: [
+    '(program (import_statement) @import)',
+    '(program (comment) @comment)',
+    '(program (function_declaration) @function)',
+    '(program (generator_function_declaration) @function)',
+    '(program (class_declaration) @class)',
+    '(program (lexical_declaration) @variable)',
+    '(program (variable_declaration) @variable)',
+    '(program (export_statement) @export)',
+    '(program (expression_statement) @expression)',
+    '(method_definition) @method',
+  ],
+  symbolQueries: [
+    // Arrow functions and function expressions assigned at module scope are functions, not variables.
+    '(program (lexical_declaration (variable_declarator name: (identifier) @function.name value: [(arrow_function) (function_expression)])))',
+    '(program (export_statement (lexical_declaration (variable_declarator name: (identifier) @function.name value: [(arrow_function) (function_expression)]))))',
+    ...WRAPPED_COMPONENT_SYMBOL_QUERIES,
+    ...(javascript.symbolQueries ?? []),
+  ],
+};
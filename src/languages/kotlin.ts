@@ -0,0 +1,45 @@
+import { LanguageConfiguration } from '../utils/parser';
+import kotlin from 'tree-sitter-kotlin';
+
+export const kotlinConfig: LanguageConfiguration = {
+  name: 'kotlin',
+  fileSuffixes: ['.kt', '.kts'],
+  parser: kotlin,
+  queries: [
+    '(import_header) @import',
+    '(if_expression) @if',
+    '(jump_expression) @return',
+    // Top-level and extension functions, methods and local functions; annotations are part of `modifiers`.
+    '(function_declaration) @function',
+    // Classes, interfaces, enum classes and data classes.
+    '(class_declaration) @class',
+    '(object_declaration) @object',
+    '(companion_object) @object',
+    // Only top-level and member properties: locals stay in the chunk of their function.
+    '(source_file (property_declaration) @variable)',
+    '(class_body (property_declaration) @variable)',
+    '(line_comment) @comment',
+    '(multiline_comment) @comment',
+  ],
+  importQueries: ['(import_header (identifier) @import.path)'],
+  symbolQueries: [
+    '(class_declaration (type_identifier) @class.name)',
+    '(object_declaration (type_identifier) @object.name)',
+    '(function_declaration (simple_identifier) @function.name)',
+    '(property_declaration (variable_declaration (simple_identifier) @variable.name))',
+    '(call_expression (simple_identifier) @function.call)',
+    '(call_expression (navigation_expression (navigation_suffix (simple_identifier) @method.call)))',
+  ],
+  // Top-level declarations are public unless they are declared `private` or `internal`.
+  exportQueries: [
+    `(source_file
+      (class_declaration (type_identifier) @export.name) @export.declaration
+      (#not-match? @export.declaration "^(@\\\\S+\\\\s+)*(private|internal)\\\\s"))`,
+    `(source_file
+      (object_declaration (type_identifier) @export.name) @export.declaration
+      (#not-match? @export.declaration "^(@\\\\S+\\\\s+)*(private|internal)\\\\s"))`,
+    `(source_file
+      (function_declaration (simple_identifier) @export.name) @export.declaration
+      (#not-match? @export.declaration "^(@\\\\S+\\\\s+)*(private|internal)\\\\s"))`,
+  ],
+};
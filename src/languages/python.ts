@@ -41,6 +41,9 @@ export const pythonConfig: LanguageConfiguration = {
     '(import_statement name: (dotted_name) @import.path)',
     '(import_from_statement module_name: (dotted_name) @import.path (dotted_name (identifier) @import.symbol))',
     '(import_from_statement module_name: (dotted_name) @import.path (wildcard_import) @import.symbol)',
+    // Relative imports (`from . import x`, `from ..pkg import y`), resolved to a path by the parser.
+    '(import_from_statement module_name: (relative_import) @import.path (dotted_name (identifier) @import.symbol))',
+    '(import_from_statement module_name: (relative_import) @import.path (wildcard_import) @import.symbol)',
   ],
   symbolQueries: [
     '(class_definition name: (identifier) @class.name)',
@@ -52,6 +55,8 @@ export const pythonConfig: LanguageConfiguration = {
   exportQueries: [
     '(module (function_definition name: (identifier) @export.name))',
     '(module (class_definition name: (identifier) @export.name))',
+    '(module (decorated_definition definition: (function_definition name: (identifier) @export.name)))',
+    '(module (decorated_definition definition: (class_definition name: (identifier) @export.name)))',
     '(module (expression_statement (assignment left: (identifier) @export.name (#match? @export.name "^[A-Z_][A-Z0-9_]*$"))))',
   ],
 };
@@ -0,0 +1,86 @@
+import { LanguageConfiguration } from '../utils/parser';
+import rust from 'tree-sitter-rust';
+
+export const rustConfig: LanguageConfiguration = {
+  name: 'rust',
+  fileSuffixes: ['.rs'],
+  parser: rust,
+  queries: [
+    '(use_declaration) @import',
+    '(function_item) @function',
+    '(impl_item) @impl',
+    '(struct_item) @struct',
+    '(enum_item) @enum',
+    '(trait_item) @trait',
+    '(type_item) @type',
+    '(const_item) @const',
+    '(static_item) @static',
+    '(mod_item) @module',
+    '(macro_definition) @macro',
+    '(line_comment) @comment',
+    '(block_comment) @comment',
+    // Item-level doc comments (`///` and `/** */`) are attached to the item that follows them,
+    // mirroring how Go doc comments are handled.
+    `
+    (
+      [(line_comment) (block_comment)]+ @doc
+      .
+      (function_item) @function
+    ) @function_with_doc
+    `,
+    `
+    (
+      [(line_comment) (block_comment)]+ @doc
+      .
+      (impl_item) @impl
+    ) @impl_with_doc
+    `,
+    `
+    (
+      [(line_comment) (block_comment)]+ @doc
+      .
+      (struct_item) @struct
+    ) @struct_with_doc
+    `,
+    `
+    (
+      [(line_comment) (block_comment)]+ @doc
+      .
+      (enum_item) @enum
+    ) @enum_with_doc
+    `,
+    `
+    (
+      [(line_comment) (block_comment)]+ @doc
+      .
+      (trait_item) @trait
+    ) @trait_with_doc
+    `,
+  ],
+  importQueries: ['(use_declaration argument: (_) @import.path)'],
+  symbolQueries: [
+    '(function_item name: (identifier) @function.name)',
+    '(function_signature_item name: (identifier) @method.name)',
+    '(struct_item name: (type_identifier) @struct.name)',
+    '(enum_item name: (type_identifier) @enum.name)',
+    '(trait_item name: (type_identifier) @trait.name)',
+    '(type_item name: (type_identifier) @type.name)',
+    '(const_item name: (identifier) @variable.name)',
+    '(static_item name: (identifier) @variable.name)',
+    '(macro_definition name: (identifier) @macro.name)',
+    '(call_expression function: (identifier) @function.call)',
+    '(call_expression function: (field_expression field: (field_identifier)) @method.call)',
+    '(call_expression function: (scoped_identifier name: (identifier)) @function.call)',
+    '(macro_invocation macro: (identifier) @macro.call)',
+    '(struct_expression name: (type_identifier) @struct.instantiation)',
+  ],
+  exportQueries: [
+    '(function_item (visibility_modifier) name: (identifier) @export.name)',
+    '(struct_item (visibility_modifier) name: (type_identifier) @export.name)',
+    '(enum_item (visibility_modifier) name: (type_identifier) @export.name)',
+    '(trait_item (visibility_modifier) name: (type_identifier) @export.name)',
+    '(type_item (visibility_modifier) name: (type_identifier) @export.name)',
+    '(const_item (visibility_modifier) name: (identifier) @export.name)',
+    '(static_item (visibility_modifier) name: (identifier) @export.name)',
+  ],
+};
@@ -0,0 +1,34 @@
+// src/languages/tsx.ts
+import ts from 'tree-sitter-typescript';
+import { LanguageConfiguration } from '../utils/parser';
+import { typescript } from './typescript';
+import { WRAPPED_COMPONENT_SYMBOL_QUERIES } from './jsx';
+
+/**
+ * TSX shares the TypeScript symbol, import and export queries with the JSX-aware grammar, since the
+ * plain TypeScript grammar cannot parse JSX elements. Like JSX, it is chunked by module-scope
+ * statements, so each component, hook or class component is one chunk; class methods are still
+ * chunked on their own.
+ */
+export const tsx: LanguageConfiguration = {
+  ...typescript,
+  name: 'tsx',
+  fileSuffixes: ['.tsx'],
+  parser: ts.tsx,
+  queries: [
+    '(program (import_statement) @import)',
+    '(program (comment) @comment)',
+    '(program (function_declaration) @function)',
+    '(program (generator_function_declaration) @function)',
+    '(program (class_declaration) @class)',
+    '(program (abstract_class_declaration) @class)',
+    '(program (lexical_declaration) @variable)',
+    '(program (variable_declaration) @variable)',
+    '(program (interface_declaration) @interface)',
+    '(program (type_alias_declaration) @type)',
+    '(program (export_statement) @export)',
+    '(program (expression_statement) @expression)',
+    '(method_definition) @method',
+  ],
+  symbolQueries: [...WRAPPED_COMPONENT_SYMBOL_QUERIES, ...(typescript.symbolQueries ?? [])],
+};
@@ -4,12 +4,14 @@ import { LanguageConfiguration } from '../utils/parser';
 
 export const typescript: LanguageConfiguration = {
   name: 'typescript',
-  fileSuffixes: ['.ts', '.tsx'],
+  fileSuffixes: ['.ts'],
   parser: ts.typescript,
   queries: [
     '(import_statement) @import',
     '(lexical_declaration) @variable',
     '(class_declaration) @class',
+    '(abstract_class_declaration) @class',
+    '(method_definition) @method',
     '(interface_declaration) @interface',
     '(export_statement) @export',
     '(comment) @comment',
@@ -39,6 +41,13 @@ export const typescript: LanguageConfiguration = {
     ) @class_with_doc
     `,
     `
+    (
+      (comment)+ @doc
+      .
+      (abstract_class_declaration) @class
+    ) @class_with_doc
+    `,
+    `
     (
       (comment)+ @doc
       .
@@ -73,6 +82,13 @@ export const typescript: LanguageConfiguration = {
       (interface_declaration) @interface
     ) @interface_with_doc
     `,
+    `
+    (
+      (comment)+ @doc
+      .
+      (export_statement) @export
+    ) @export_with_doc
+    `,
   ],
   importQueries: [
     '(import_statement (import_clause (named_imports (import_specifier name: (identifier) @import.symbol))) source: (string) @import.path)',
@@ -80,11 +96,20 @@ export const typescript: LanguageConfiguration = {
     '(import_statement (import_clause (identifier) @import.symbol) source: (string) @import.path)',
     '(import_statement source: (string) @import.path)',
     '(import_statement "type" (import_clause (named_imports (import_specifier name: (identifier) @import.symbol))) source: (string) @import.path)',
+    '(import_statement (import_require_clause (identifier) @import.symbol source: (string) @import.path))',
+    // CommonJS `require('x')` calls, with the names bound by `const x = ...` or `const { a } = ...`.
+    '(variable_declarator name: (identifier) @import.symbol value: (call_expression function: (identifier) @require (#eq? @require "require") arguments: (arguments (string) @import.path)))',
+    '(variable_declarator name: (object_pattern (shorthand_property_identifier_pattern) @import.symbol) value: (call_expression function: (identifier) @require (#eq? @require "require") arguments: (arguments (string) @import.path)))',
+    '(call_expression function: (identifier) @require (#eq? @require "require") arguments: (arguments (string) @import.path))',
   ],
   symbolQueries: [
     '(function_declaration name: (identifier) @function.name)',
     '(generator_function_declaration name: (identifier) @function.name)',
+    // Arrow functions and function expressions assigned at module scope are functions, not variables.
+    '(program (lexical_declaration (variable_declarator name: (identifier) @function.name value: [(arrow_function) (function_expression)])))',
+    '(program (export_statement (lexical_declaration (variable_declarator name: (identifier) @function.name value: [(arrow_function) (function_expression)]))))',
     '(class_declaration name: (type_identifier) @class.name)',
+    '(abstract_class_declaration name: (type_identifier) @class.name)',
     '(method_definition name: (property_identifier) @method.name)',
     '(variable_declarator name: (identifier) @variable.name)',
     '(type_alias_declaration name: (type_identifier) @type.name)',
@@ -97,6 +122,7 @@ export const typescript: LanguageConfiguration = {
     '(export_statement (lexical_declaration (variable_declarator name: (identifier) @export.name)))',
     '(export_statement (function_declaration name: (identifier) @export.name))',
     '(export_statement (class_declaration name: (type_identifier) @export.name))',
+    '(export_statement (abstract_class_declaration name: (type_identifier) @export.name))',
     '(export_statement (interface_declaration name: (type_identifier) @export.name))',
     '(export_statement (type_alias_declaration name: (type_identifier) @export.name))',
     '(export_statement "default" @export.default)',
@@ -0,0 +1,140 @@
+import { computeBackoffDelayMs } from './sqlite_queue';
+
+/** Default lower bound for the adaptive bulk batch size. */
+export const DEFAULT_BULK_MIN_SIZE = 10;
+
+/** Consecutive successful batches required before the batch size grows again. */
+const DEFAULT_GROWTH_INTERVAL = 5;
+
+export interface AdaptiveBatchSizeOptions {
+  /** Smallest batch size the controller shrinks to. */
+  minSize: number;
+  /** Largest batch size the controller grows back to. */
+  maxSize: number;
+  /** Starting batch size (defaults to `maxSize`). Clamped to `[minSize, maxSize]`. */
+  initialSize?: number;
+  /** Consecutive successful batches required before growing (default: 5). */
+  growthInterval?: number;
+  /** Backoff after the first rejection; doubles for each consecutive rejection (default: 1000). */
+  baseBackoffMs?: number;
+  /** Upper bound for the rejection backoff (default: 60000). */
+  maxBackoffMs?: number;
+  /**
+   * Bulk request duration above which a successful batch shrinks the size by a quarter, and at or
+   * below which it counts toward growing. `0` or unset only reacts to rejections.
+   */
+  targetLatencyMs?: number;
+}
+
+/** Batches recorded since the last call to {@link AdaptiveBatchSize.takeStats}. */
+export interface AdaptiveBatchSizeStats {
+  /** Effective batch size at the time of the call. */
+  size: number;
+  batches: number;
+  /** Batches with at least one document rejected with a 429. */
+  rejected: number;
+  /** Mean duration of the successful batches, or null if none had a duration. */
+  averageLatencyMs: number | null;
+}
+
+/**
+ * Tracks the effective Elasticsearch bulk batch size.
+ *
+ * The size is halved whenever Elasticsearch rejects a bulk request with a 429, and shrinks by a
+ * quarter when a bulk request succeeds but takes longer than `targetLatencyMs`. It grows back by a
+ * quarter toward `maxSize` after every `growthInterval` consecutive fast successful batches.
+ */
+export class AdaptiveBatchSize {
+  private readonly minSize: number;
+  private readonly maxSize: number;
+  private readonly growthInterval: number;
+  private readonly baseBackoffMs: number;
+  private readonly maxBackoffMs: number;
+  private readonly targetLatencyMs: number;
+  private readonly initialSize: number;
+  private currentSize: number;
+  private consecutiveSuccesses = 0;
+  private consecutiveRejections = 0;
+  private stats = { batches: 0, rejected: 0, timed: 0, latencyMs: 0 };
+
+  constructor(options: AdaptiveBatchSizeOptions) {
+    if (options.minSize > options.maxSize) {
+      throw new Error(`Bulk min size (${options.minSize}) cannot be greater than max size (${options.maxSize}).`);
+    }
+    this.minSize = options.minSize;
+    this.maxSize = options.maxSize;
+    this.growthInterval = options.growthInterval ?? DEFAULT_GROWTH_INTERVAL;
+    this.baseBackoffMs = options.baseBackoffMs ?? 1000;
+    this.maxBackoffMs = options.maxBackoffMs ?? 60000;
+    this.targetLatencyMs = options.targetLatencyMs ?? 0;
+    this.initialSize = Math.min(this.maxSize, Math.max(this.minSize, options.initialSize ?? this.maxSize));
+    this.currentSize = this.initialSize;
+  }
+
+  /** The batch size to use for the next bulk request. */
+  get size(): number {
+    return this.currentSize;
+  }
+
+  /** Returns to the initial size and forgets the batches recorded so far, e.g. before a new run. */
+  reset(): void {
+    this.currentSize = this.initialSize;
+    this.consecutiveSuccesses = 0;
+    this.consecutiveRejections = 0;
+    this.stats = { batches: 0, rejected: 0, timed: 0, latencyMs: 0 };
+  }
+
+  /**
+   * Records a batch that Elasticsearch did not reject.
+   *
+   * @param latencyMs - How long the bulk request took, compared against `targetLatencyMs`.
+   * @returns true if the batch size changed.
+   */
+  recordSuccess(latencyMs?: number): boolean {
+    this.consecutiveRejections = 0;
+    this.stats.batches++;
+    if (latencyMs !== undefined) {
+      this.stats.timed++;
+      this.stats.latencyMs += latencyMs;
+    }
+    if (this.targetLatencyMs > 0 && latencyMs !== undefined && latencyMs > this.targetLatencyMs) {
+      this.consecutiveSuccesses = 0;
+      const previous = this.currentSize;
+      this.currentSize = Math.max(this.minSize, this.currentSize - Math.max(1, Math.floor(this.currentSize / 4)));
+      return this.currentSize !== previous;
+    }
+    this.consecutiveSuccesses++;
+    if (this.consecutiveSuccesses < this.growthInterval || this.currentSize >= this.maxSize) {
+      return false;
+    }
+    this.consecutiveSuccesses = 0;
+    this.currentSize = Math.min(this.maxSize, this.currentSize + Math.max(1, Math.floor(this.currentSize / 4)));
+    return true;
+  }
+
+  /**
+   * Records a batch that Elasticsearch rejected (429) and halves the batch size.
+   *
+   * @returns How long to wait before sending the next bulk request, in milliseconds.
+   */
+  recordRejection(): number {
+    this.consecutiveSuccesses = 0;
+    this.consecutiveRejections++;
+    this.stats.batches++;
+    this.stats.rejected++;
+    this.currentSize = Math.max(this.minSize, Math.floor(this.currentSize / 2));
+    return computeBackoffDelayMs(this.consecutiveRejections, this.baseBackoffMs, this.maxBackoffMs);
+  }
+
+  /** Returns the batches recorded since the previous call and starts a new count. */
+  takeStats(): AdaptiveBatchSizeStats {
+    const { batches, rejected, timed, latencyMs } = this.stats;
+    this.stats = { batches: 0, rejected: 0, timed: 0, latencyMs: 0 };
+    return {
+      size: this.currentSize,
+      batches,
+      rejected,
+      averageLatencyMs: timed > 0 ? Math.round(latencyMs / timed) : null,
+    };
+  }
+}
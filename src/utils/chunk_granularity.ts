@@ -0,0 +1,56 @@
+import { languageConfigurations } from '../languages';
+
+/**
+ * How the files of a language are cut into chunks: `file` keeps each file whole, `symbol` (the
+ * default) makes a chunk per definition and `symbol+doc` also embeds each definition's doc comment.
+ */
+export const CHUNK_GRANULARITIES = ['file', 'symbol', 'symbol+doc'] as const;
+export type ChunkGranularity = (typeof CHUNK_GRANULARITIES)[number];
+
+export const DEFAULT_CHUNK_GRANULARITY: ChunkGranularity = 'symbol';
+
+/** Kind of the single chunk made of a whole source file in `file` mode. */
+export const CHUNK_KIND_FILE = 'file';
+
+/**
+ * Language name to {@link ChunkGranularity}, for the languages that do not use the default.
+ *
+ * Kept as plain JSON so it can be handed to parsing worker threads as is.
+ */
+export type ChunkGranularityMap = Record<string, ChunkGranularity>;
+
+/**
+ * Parses and validates a granularity list such as `typescript:file,go:symbol+doc`, so an unknown
+ * language or mode fails at startup instead of being ignored by the parser.
+ */
+export function parseChunkGranularity(value: string): ChunkGranularityMap {
+  const granularity: ChunkGranularityMap = {};
+  for (const entry of value.split(',').map((part) => part.trim())) {
+    if (entry.length === 0) {
+      continue;
+    }
+    const separator = entry.indexOf(':');
+    const language = separator === -1 ? '' : entry.slice(0, separator).trim();
+    const mode = separator === -1 ? '' : entry.slice(separator + 1).trim();
+    if (language.length === 0 || mode.length === 0) {
+      throw new Error(`Invalid chunk granularity "${entry}": expected language:mode, such as "typescript:file".`);
+    }
+    if (!(language in languageConfigurations)) {
+      throw new Error(
+        `Invalid chunk granularity "${entry}": unknown language "${language}". ` +
+          `Expected one of: ${Object.keys(languageConfigurations).join(', ')}.`
+      );
+    }
+    if (!CHUNK_GRANULARITIES.includes(mode as ChunkGranularity)) {
+      throw new Error(
+        `Invalid chunk granularity "${entry}": unknown mode "${mode}". ` +
+          `Expected one of: ${CHUNK_GRANULARITIES.join(', ')}.`
+      );
+    }
+    if (granularity[language] !== undefined) {
+      throw new Error(`Invalid chunk granularity "${value}": "${language}" is listed more than once.`);
+    }
+    granularity[language] = mode as ChunkGranularity;
+  }
+  return granularity;
+}
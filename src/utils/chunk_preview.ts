@@ -0,0 +1,46 @@
+/** Default of `--preview-lines`: the lines of each chunk stored in its `preview` field. */
+export const DEFAULT_PREVIEW_LINES = 5;
+
+/** Longer preview lines are cut to this many characters, ending in `…`. */
+export const PREVIEW_MAX_LINE_CHARS = 160;
+
+const ELLIPSIS = '…';
+
+/** The declaration line of a function or method, at `index` among the lines of the chunk. */
+export interface PreviewSignature {
+  line: string;
+  /** Negative when the chunk is a later part of a split symbol, which starts below the signature. */
+  index: number;
+}
+
+function truncateLine(line: string): string {
+  // Code points, so a character outside the BMP is never cut in half.
+  const chars = Array.from(line);
+  if (chars.length <= PREVIEW_MAX_LINE_CHARS) {
+    return line;
+  }
+  return `${chars.slice(0, PREVIEW_MAX_LINE_CHARS - 1).join('')}${ELLIPSIS}`;
+}
+
+/**
+ * Builds the `preview` of a chunk: its first `previewLines` lines, each cut to
+ * {@link PREVIEW_MAX_LINE_CHARS} characters. A `signature` outside of those lines is added after them,
+ * or before them for a later part of a split symbol, separated by a `…` line. Returns `undefined`
+ * when `previewLines` is 0.
+ */
+export function buildChunkPreview(
+  content: string,
+  previewLines: number,
+  signature?: PreviewSignature
+): string | undefined {
+  if (previewLines <= 0) {
+    return undefined;
+  }
+  const lines = content.split('\n').slice(0, previewLines).map(truncateLine);
+  if (signature && signature.index < 0) {
+    lines.unshift(truncateLine(signature.line.trim()), ELLIPSIS);
+  } else if (signature && signature.index >= previewLines) {
+    lines.push(ELLIPSIS, truncateLine(signature.line.trim()));
+  }
+  return lines.join('\n');
+}
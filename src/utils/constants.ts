@@ -39,6 +39,12 @@ export const QUEUE_STATUS_PENDING = 'pending';
 export const QUEUE_STATUS_PROCESSING = 'processing';
 export const QUEUE_STATUS_FAILED = 'failed';
 
+/**
+ * Queue priority levels. Higher values are dequeued first; FIFO order applies within a level.
+ */
+export const QUEUE_PRIORITY_DEFAULT = 0;
+export const QUEUE_PRIORITY_HIGH = 10;
+
 /**
  * Code chunk types for document classification.
  */
@@ -69,12 +75,21 @@ export const PARSER_TYPE_YAML = 'yaml';
 export const PARSER_TYPE_JSON = 'json';
 export const PARSER_TYPE_TEXT = 'text';
 export const PARSER_TYPE_HANDLEBARS = 'handlebars';
+export const PARSER_TYPE_WHOLE_FILE = 'whole-file';
 
 /**
  * Worker message status values.
  */
 export const MESSAGE_STATUS_SUCCESS = 'success';
 export const MESSAGE_STATUS_FAILURE = 'failure';
+export const MESSAGE_STATUS_SHUTDOWN = 'shutdown';
+/** A log line forwarded by a worker thread, written by the main thread in its log format. */
+export const MESSAGE_STATUS_LOG = 'log';
+
+/**
+ * Message the main thread posts to ask a producer worker to wind down after its current file.
+ */
+export const MESSAGE_TYPE_SHUTDOWN = 'shutdown';
 
 /**
  * Metric status values.
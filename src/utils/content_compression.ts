@@ -0,0 +1,41 @@
+import zlib from 'zlib';
+
+/** Characters of a compressed chunk's content kept, uncompressed and searchable, in `content`. */
+export const CONTENT_EXCERPT_CHARS = 256;
+
+/** The fields a chunk's content is stored in, see {@link compressContent}. */
+export interface StoredContent {
+  /** The whole content, or its first {@link CONTENT_EXCERPT_CHARS} characters when `content_gz` is set. */
+  content: string;
+  /** The whole content, gzip-compressed and base64-encoded. */
+  content_gz?: string;
+}
+
+/**
+ * Splits a chunk's content into a short searchable excerpt and the gzip-compressed (base64) whole.
+ * Content that would not get smaller, such as a short chunk, is stored as is.
+ */
+export function compressContent(content: string): StoredContent {
+  if (content.length <= CONTENT_EXCERPT_CHARS) {
+    return { content };
+  }
+  const excerpt = content.slice(0, CONTENT_EXCERPT_CHARS);
+  const compressed = zlib.gzipSync(content).toString('base64');
+  if (Buffer.byteLength(excerpt, 'utf8') + compressed.length >= Buffer.byteLength(content, 'utf8')) {
+    return { content };
+  }
+  return { content: excerpt, content_gz: compressed };
+}
+
+/** Returns the whole content of stored chunk fields, decompressing `content_gz` when it is set. */
+export function inflateContent(stored: StoredContent): string {
+  if (!stored.content_gz) {
+    return stored.content;
+  }
+  return zlib.gunzipSync(Buffer.from(stored.content_gz, 'base64')).toString('utf8');
+}
+
+/** Bytes of the content fields of a chunk document, as sent in its JSON source. */
+export function storedContentBytes(stored: StoredContent): number {
+  return Buffer.byteLength(stored.content, 'utf8') + (stored.content_gz?.length ?? 0);
+}
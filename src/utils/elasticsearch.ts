@@ -6,12 +6,28 @@ import {
   BulkOperationType,
   BulkResponseItem,
   FieldValue,
+  IndicesIndexSettings,
+  IndicesUpdateAliasesAction,
+  MappingTypeMapping,
+  KnnSearch,
   SearchHit,
+  SearchResponse,
+  SearchTotalHits,
 } from '@elastic/elasticsearch/lib/api/types';
 import { createHash } from 'crypto';
+import fs from 'fs';
 import { elasticsearchConfig, indexingConfig, appConfig } from '../config';
 export { elasticsearchConfig };
-import { logger } from './logger';
+import { addLogSecret, createLogger } from './logger';
+import { computeBackoffDelayMs } from './sqlite_queue';
+import type { EmbeddingProvider } from './embedding_provider';
+import { fuseSearchResults, SearchFusionOptions } from './search_fusion';
+import { compressContent, inflateContent, storedContentBytes } from './content_compression';
+import type { SymbolKind } from './symbol_kinds';
+import { CHARS_PER_TOKEN } from './tokenizer';
+import { getEmbeddingTextOptions, normalizeEmbeddingText } from './embedding_text';
+
+const logger = createLogger(undefined, { module: 'elasticsearch' });
 
 /**
  * The Elasticsearch client instance.
@@ -31,65 +47,87 @@ export function setClient(client: Client | undefined): void {
 }
 
 /**
- * Gets the Elasticsearch client instance, initializing it if necessary.
- * This lazy initialization allows commands that don't need Elasticsearch
- * to run without requiring Elasticsearch configuration.
+ * Builds the client options from `elasticsearchConfig`: the Cloud ID or endpoint, an API key or
+ * username and password, and the TLS settings. Credentials are registered with the logger, so they
+ * are redacted from every log line.
  */
-export function getClient(): Client {
-  if (_client) {
-    return _client;
+export function getClientOptions(): ClientOptions {
+  const tls: NonNullable<ClientOptions['tls']> = {};
+  const caCert = elasticsearchConfig.caCert;
+  if (caCert) {
+    try {
+      tls.ca = fs.readFileSync(caCert);
+    } catch (error) {
+      const message = error instanceof Error ? error.message : String(error);
+      throw new Error(`Could not read the Elasticsearch CA certificate ${caCert}: ${message}`);
+    }
+  }
+  if (elasticsearchConfig.insecure) {
+    tls.rejectUnauthorized = false;
   }
 
   const baseOptions: Partial<ClientOptions> = {
     requestTimeout: elasticsearchConfig.requestTimeout,
+    ...(Object.keys(tls).length > 0 ? { tls } : {}),
   };
 
-  if (elasticsearchConfig.cloudId) {
-    const clientOptions: ClientOptions = {
-      ...baseOptions,
-      cloud: {
-        id: elasticsearchConfig.cloudId,
-      },
+  let auth: ClientOptions['auth'];
+  if (elasticsearchConfig.apiKey) {
+    addLogSecret(elasticsearchConfig.apiKey);
+    auth = { apiKey: elasticsearchConfig.apiKey };
+  } else if (elasticsearchConfig.username && elasticsearchConfig.password) {
+    addLogSecret(elasticsearchConfig.password);
+    auth = {
+      username: elasticsearchConfig.username,
+      password: elasticsearchConfig.password,
     };
+  }
 
-    if (elasticsearchConfig.apiKey) {
-      clientOptions.auth = { apiKey: elasticsearchConfig.apiKey };
-    } else if (elasticsearchConfig.username && elasticsearchConfig.password) {
-      clientOptions.auth = {
-        username: elasticsearchConfig.username,
-        password: elasticsearchConfig.password,
-      };
-    } else {
+  if (elasticsearchConfig.cloudId) {
+    if (!auth) {
       throw new Error(
-        'Elasticsearch Cloud authentication not configured. Please set ELASTICSEARCH_API_KEY or ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD.'
+        'Elasticsearch Cloud authentication not configured. Please set --es-api-key, ELASTICSEARCH_API_KEY or ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD.'
       );
     }
-
-    _client = new Client(clientOptions);
+    return { ...baseOptions, cloud: { id: elasticsearchConfig.cloudId }, auth };
   } else if (elasticsearchConfig.endpoint) {
-    const clientOptions: ClientOptions = {
-      ...baseOptions,
-      node: elasticsearchConfig.endpoint,
-    };
-
-    if (elasticsearchConfig.apiKey) {
-      clientOptions.auth = { apiKey: elasticsearchConfig.apiKey };
-    } else if (elasticsearchConfig.username && elasticsearchConfig.password) {
-      clientOptions.auth = {
-        username: elasticsearchConfig.username,
-        password: elasticsearchConfig.password,
-      };
-    }
-    _client = new Client(clientOptions);
-  } else {
-    throw new Error(
-      'Elasticsearch connection not configured. Please set ELASTICSEARCH_CLOUD_ID or ELASTICSEARCH_ENDPOINT.'
-    );
+    return { ...baseOptions, node: elasticsearchConfig.endpoint, ...(auth ? { auth } : {}) };
   }
+  throw new Error(
+    'Elasticsearch connection not configured. Please set --es-node, --es-cloud-id, ELASTICSEARCH_CLOUD_ID or ELASTICSEARCH_ENDPOINT.'
+  );
+}
 
+/**
+ * Gets the Elasticsearch client instance, initializing it if necessary.
+ * This lazy initialization allows commands that don't need Elasticsearch
+ * to run without requiring Elasticsearch configuration.
+ */
+export function getClient(): Client {
+  if (_client) {
+    return _client;
+  }
+  const clientOptions = getClientOptions();
+  if (elasticsearchConfig.insecure) {
+    logger.warn('TLS certificate verification of Elasticsearch is disabled. Only use --es-insecure for development.');
+  }
+  _client = new Client(clientOptions);
   return _client;
 }
 
+/** Connection attempts after the first failed one before a run gives up on Elasticsearch. */
+export const DEFAULT_ES_CONNECT_RETRIES = 5;
+/** Milliseconds each connection attempt may take. */
+export const DEFAULT_ES_CONNECT_TIMEOUT_MS = 10000;
+const ES_CONNECT_BASE_DELAY_MS = 1000;
+const ES_CONNECT_MAX_DELAY_MS = 30000;
+/** Statuses a cluster that is starting or overloaded answers with, retried while waiting for it. */
+const RETRYABLE_CONNECT_STATUSES = new Set([429, 502, 503, 504]);
+/** Oldest major version of Elasticsearch the indexer supports. */
+export const MIN_ELASTICSEARCH_MAJOR_VERSION = 8;
+/** Matches the errors of a TLS certificate that cannot be verified, which retrying does not fix. */
+const CERTIFICATE_ERROR_PATTERN = /certificate|self[- ]signed/i;
+
 const codeSimilarityPipeline = 'code-similarity-pipeline';
 
 /**
@@ -182,76 +220,572 @@ function summarizeElasticsearchError(error: unknown): ElasticsearchErrorSummary
 }
 
 /**
- * Creates the Elasticsearch index for storing code chunks.
+ * Analyzer for code bodies: identifiers are split on punctuation, case changes and digits, and the
+ * original token is kept, so `getUserById` matches `getUserById`, `user` and `id`.
+ */
+const CODE_ANALYSIS_SETTINGS = {
+  analysis: {
+    filter: {
+      code_word_delimiter: {
+        type: 'word_delimiter_graph',
+        preserve_original: true,
+        split_on_case_change: true,
+        split_on_numerics: true,
+      },
+    },
+    analyzer: {
+      code_analyzer: {
+        type: 'custom',
+        tokenizer: 'whitespace',
+        filter: ['code_word_delimiter', 'flatten_graph', 'lowercase'],
+      },
+    },
+  },
+};
+
+/** Similarities a `code_vector` can be mapped with, see `--similarity`. */
+export const VECTOR_SIMILARITIES = ['cosine', 'dot_product', 'l2_norm'] as const;
+
+/**
+ * Quantizations a `code_vector` can be indexed with, see `--vector-quantization`. `none` keeps the
+ * float vectors in the HNSW graph; the others search quantized copies of them, so kNN scores are
+ * approximate. Every one of them indexes `float` vectors, the element type of the mapping.
+ */
+export const VECTOR_QUANTIZATIONS = ['none', 'int8_hnsw', 'int4_hnsw', 'bbq_hnsw'] as const;
+export type VectorQuantization = (typeof VECTOR_QUANTIZATIONS)[number];
+
+/** Most dimensions Elasticsearch indexes a `dense_vector` with. */
+const MAX_INDEXED_VECTOR_DIMS = 4096;
+/** Fewest dimensions `bbq_hnsw` quantizes. */
+const MIN_BBQ_VECTOR_DIMS = 64;
+
+/** The `index_options.type` of a quantization. */
+function getVectorIndexType(quantization: VectorQuantization): 'hnsw' | Exclude<VectorQuantization, 'none'> {
+  return quantization === 'none' ? 'hnsw' : quantization;
+}
+
+/**
+ * Throws when Elasticsearch cannot index vectors of `dims` dimensions with `quantization`: every index
+ * takes at most 4096 dimensions, `int4_hnsw` needs an even number of them and `bbq_hnsw` at least 64.
  *
- * This function checks if the index already exists. If it doesn't, it creates
- * the index with the correct mappings for the code chunk documents.
+ * @param dimsSource Where the dimensions come from, e.g. `the embedding provider produces`.
  */
-export async function createIndex(index: string): Promise<void> {
-  const indexName = index;
-  const client = getClient();
+export function assertVectorQuantizationDims(quantization: VectorQuantization, dims: number, dimsSource: string) {
+  const requirement =
+    dims > MAX_INDEXED_VECTOR_DIMS
+      ? `at most ${MAX_INDEXED_VECTOR_DIMS} dimensions`
+      : quantization === 'int4_hnsw' && dims % 2 !== 0
+        ? 'an even number of dimensions'
+        : quantization === 'bbq_hnsw' && dims < MIN_BBQ_VECTOR_DIMS
+          ? `at least ${MIN_BBQ_VECTOR_DIMS} dimensions`
+          : undefined;
+  if (requirement !== undefined) {
+    throw new Error(`--vector-quantization ${quantization} requires ${requirement}, but ${dimsSource} ${dims}.`);
+  }
+}
+
+/** The `index_options` of `code_vector` for `--vector-quantization`, none to keep Elasticsearch's default. */
+function getVectorIndexOptions(
+  dims: number,
+  dimsSource: string
+): { index_options?: { type: ReturnType<typeof getVectorIndexType> } } {
+  const quantization = elasticsearchConfig.denseVectorQuantization as VectorQuantization | undefined;
+  if (quantization === undefined) {
+    return {};
+  }
+  assertVectorQuantizationDims(quantization, dims, dimsSource);
+  return { index_options: { type: getVectorIndexType(quantization) } };
+}
+
+interface IndexBody {
+  settings: IndicesIndexSettings;
+  mappings: MappingTypeMapping;
+}
+
+/**
+ * Reads a `--mapping-file`: either an index body with `mappings` and optional `settings`, or the
+ * `mappings` object alone. Without settings, the code analyzer of the generated mapping is defined,
+ * so the file can keep using `code_analyzer`.
+ */
+export function loadMappingFile(filePath: string): IndexBody {
+  if (!fs.existsSync(filePath)) {
+    throw new Error(`Invalid --mapping-file value: ${filePath}. The file does not exist.`);
+  }
+  let parsed: unknown;
+  try {
+    parsed = JSON.parse(fs.readFileSync(filePath, 'utf8'));
+  } catch (error) {
+    const reason = error instanceof Error ? error.message : String(error);
+    throw new Error(`Invalid --mapping-file value: ${filePath}. The file is not valid JSON: ${reason}`);
+  }
+  const body = (parsed ?? {}) as { mappings?: MappingTypeMapping; settings?: IndicesIndexSettings };
+  const mappings = body.mappings ?? (parsed as MappingTypeMapping);
+  if (typeof mappings?.properties !== 'object' || mappings.properties === null) {
+    throw new Error(`Invalid --mapping-file value: ${filePath}. Expected an object with mappings.properties.`);
+  }
+  return { settings: body.settings ?? CODE_ANALYSIS_SETTINGS, mappings };
+}
 
+/**
+ * Returns the settings and mappings of a code chunk index: the `--mapping-file` when one is set, the
+ * generated ones otherwise.
+ */
+function getCodeChunkIndexBody(vectorDims: number | undefined): IndexBody {
+  if (elasticsearchConfig.mappingFile) {
+    // Checks that the file maps the provider's dimensions.
+    getExpectedVectorMapping(vectorDims);
+    return loadMappingFile(elasticsearchConfig.mappingFile);
+  }
   const semanticTextEnabled = !elasticsearchConfig.disableSemanticText;
   const semanticTextInferenceId = semanticTextEnabled ? getElserInferenceIdOrThrow() : undefined;
 
-  const indexExists = await client.indices.exists({ index: indexName });
-  if (!indexExists) {
-    logger.info(`Creating index "${indexName}"...`);
-    await client.indices.create({
-      index: indexName,
-      mappings: {
-        properties: {
-          type: { type: 'keyword' },
-          language: { type: 'keyword' },
-          kind: { type: 'keyword' },
-          imports: {
-            type: 'nested',
-            properties: {
-              path: { type: 'keyword' },
-              type: { type: 'keyword' },
-              symbols: { type: 'keyword' },
-            },
+  return {
+    settings: CODE_ANALYSIS_SETTINGS,
+    mappings: {
+      properties: {
+        type: { type: 'keyword' },
+        language: { type: 'keyword' },
+        kind: { type: 'keyword' },
+        imports: {
+          type: 'nested',
+          properties: {
+            path: { type: 'keyword' },
+            type: { type: 'keyword' },
+            symbols: { type: 'keyword' },
           },
-          symbols: {
-            type: 'nested',
-            properties: {
-              name: { type: 'keyword' },
-              kind: { type: 'keyword' },
-              line: { type: 'integer' },
-            },
+        },
+        symbols: {
+          type: 'nested',
+          properties: {
+            name: { type: 'keyword' },
+            kind: { type: 'keyword' },
+            line: { type: 'integer' },
           },
-          exports: {
-            type: 'nested',
-            properties: {
-              name: { type: 'keyword' },
-              type: { type: 'keyword' },
-              target: { type: 'keyword' },
-            },
+        },
+        references: {
+          type: 'nested',
+          properties: {
+            name: { type: 'keyword' },
+            kind: { type: 'keyword' },
+            receiver: { type: 'keyword' },
+            receiver_type: { type: 'keyword' },
           },
-          containerPath: { type: 'text' },
-          chunk_hash: { type: 'keyword' },
-          content: { type: 'text' },
-          ...(semanticTextEnabled
-            ? {
-                semantic_text: {
-                  type: 'semantic_text',
-                  inference_id: semanticTextInferenceId,
-                },
-              }
-            : {}),
-          code_vector: {
-            type: 'dense_vector',
-            dims: 768, // Based on microsoft/codebert-base
-            index: true,
-            similarity: 'cosine',
+        },
+        exports: {
+          type: 'nested',
+          properties: {
+            name: { type: 'keyword' },
+            type: { type: 'keyword' },
+            target: { type: 'keyword' },
           },
-          created_at: { type: 'date' },
-          updated_at: { type: 'date' },
         },
+        containerPath: { type: 'text' },
+        // The `text` subfields let the lexical part of a hybrid search match the words of a name.
+        symbol_fqn: { type: 'keyword', fields: { text: { type: 'text', analyzer: 'code_analyzer' } } },
+        symbol_kind: { type: 'keyword' },
+        parent_symbol: { type: 'keyword' },
+        scope_path: { type: 'keyword', fields: { text: { type: 'text', analyzer: 'code_analyzer' } } },
+        package_name: { type: 'keyword' },
+        receiver_type: { type: 'keyword' },
+        repo_name: { type: 'keyword' },
+        repo_root: { type: 'keyword' },
+        repo_url: { type: 'keyword' },
+        chunk_hash: { type: 'keyword' },
+        content: { type: 'text', analyzer: 'code_analyzer' },
+        content_gz: { type: 'binary' },
+        preview: { type: 'text', index: false },
+        doc_comment: { type: 'text' },
+        overlap: { type: 'text', analyzer: 'code_analyzer' },
+        symbol_id: { type: 'keyword' },
+        symbol_name: { type: 'keyword', fields: { text: { type: 'text', analyzer: 'code_analyzer' } } },
+        chunkIndex: { type: 'integer' },
+        totalChunks: { type: 'integer' },
+        token_count: { type: 'integer' },
+        last_modified: { type: 'date' },
+        last_author: { type: 'keyword' },
+        ...(semanticTextEnabled
+          ? {
+              semantic_text: {
+                type: 'semantic_text',
+                inference_id: semanticTextInferenceId,
+              },
+            }
+          : {}),
+        code_vector: {
+          type: 'dense_vector',
+          dims: vectorDims ?? elasticsearchConfig.denseVectorDims, // 768 for microsoft/codebert-base
+          index: true,
+          similarity: elasticsearchConfig.denseVectorSimilarity as (typeof VECTOR_SIMILARITIES)[number],
+          ...getVectorIndexOptions(
+            vectorDims ?? elasticsearchConfig.denseVectorDims,
+            vectorDims !== undefined ? 'the embedding provider produces' : 'SCS_IDXR_DENSE_VECTOR_DIMS is set to'
+          ),
+        },
+        created_at: { type: 'date' },
+        updated_at: { type: 'date' },
       },
+    },
+  };
+}
+
+/**
+ * Returns the concrete index name of an alias generation, e.g. `code-search-000002`.
+ */
+export function getIndexGenerationName(index: string, generation: number): string {
+  return `${index}-${String(generation).padStart(6, '0')}`;
+}
+
+const INDEX_GENERATION_PATTERN = /^-(\d{6})$/;
+
+/**
+ * Returns the concrete indices an alias points to, or an empty array when `alias` is not an alias.
+ */
+async function getAliasTargets(alias: string): Promise<string[]> {
+  const client = getClient();
+  if (!(await client.indices.existsAlias({ name: alias }))) {
+    return [];
+  }
+  const response = await client.indices.getAlias({ name: alias });
+  return Object.keys(response);
+}
+
+/**
+ * Returns the concrete indices behind `index`: the generations of an alias, or the index itself.
+ */
+async function resolveConcreteIndices(index: string): Promise<string[]> {
+  const aliasTargets = await getAliasTargets(index);
+  return aliasTargets.length > 0 ? aliasTargets : [index];
+}
+
+/**
+ * Creates the Elasticsearch index for storing code chunks.
+ *
+ * A new index is created as the first generation (`<index>-000001`) behind an alias named `index`, so
+ * a `--clean` rebuild can later swap the alias to a fresh generation. An existing alias or index with
+ * that name is reused.
+ *
+ * An existing index is checked with {@link assertCompatibleMapping}, so a run does not write vectors
+ * that its queries could never match.
+ *
+ * @param options.vectorDims Dimensions of the active embedding provider. The `code_vector` mapping
+ *   is created with them. Defaults to `SCS_IDXR_DENSE_VECTOR_DIMS`.
+ * @param options.storeCompressed Chunk content is stored compressed, so an existing index created
+ *   before `content_gz` was mapped gets the mapping instead of a dynamically mapped text field.
+ */
+export async function createIndex(
+  index: string,
+  options: { vectorDims?: number; storeCompressed?: boolean } = {}
+): Promise<void> {
+  const indexName = index;
+  const client = getClient();
+
+  const body = getCodeChunkIndexBody(options.vectorDims);
+
+  const indexExists = await client.indices.exists({ index: indexName });
+  if (!indexExists) {
+    const generationName = getIndexGenerationName(indexName, 1);
+    logger.info(`Creating index "${generationName}" behind alias "${indexName}"...`);
+    await client.indices.create({
+      index: generationName,
+      ...body,
+      aliases: { [indexName]: {} },
     });
   } else {
     logger.info(`Index "${indexName}" already exists.`);
+    await assertCompatibleMapping(indexName, options.vectorDims);
+    // Indices created before these fields were mapped would map them dynamically as text.
+    await client.indices.putMapping({
+      index: indexName,
+      properties: {
+        symbol_kind: { type: 'keyword' },
+        parent_symbol: { type: 'keyword' },
+        scope_path: { type: 'keyword', fields: { text: { type: 'text', analyzer: 'code_analyzer' } } },
+        preview: { type: 'text', index: false },
+        last_modified: { type: 'date' },
+        last_author: { type: 'keyword' },
+        ...(options.storeCompressed ? { content_gz: { type: 'binary' } } : {}),
+      },
+    });
+  }
+}
+
+/**
+ * Creates the next generation of an aliased index for a `--clean` rebuild, with its locations index.
+ *
+ * Neither index is aliased yet; searches keep using the current generation until
+ * {@link swapIndexAlias} points the aliases at the new one.
+ *
+ * @returns The concrete name of the new generation, or undefined when `index` does not exist yet and
+ *   can be built in place.
+ */
+export async function createRebuildIndex(
+  index: string,
+  options: { vectorDims?: number } = {}
+): Promise<string | undefined> {
+  const client = getClient();
+  if (!(await client.indices.exists({ index }))) {
+    return undefined;
+  }
+
+  const generations = (await getAliasTargets(index))
+    .map((name) => INDEX_GENERATION_PATTERN.exec(name.slice(index.length)))
+    .map((match) => (match ? parseInt(match[1], 10) : 0));
+  let generation = Math.max(0, ...generations) + 1;
+  while (await client.indices.exists({ index: getIndexGenerationName(index, generation) })) {
+    generation++;
+  }
+
+  const generationName = getIndexGenerationName(index, generation);
+  logger.info(`Creating index "${generationName}" to rebuild "${index}"...`);
+  await client.indices.create({ index: generationName, ...getCodeChunkIndexBody(options.vectorDims) });
+  await createLocationsIndex(generationName);
+  return generationName;
+}
+
+/**
+ * Atomically points the `index` and `<index>_locations` aliases at a rebuilt generation.
+ *
+ * A concrete index that still uses the alias name (created before indexes were aliased) is removed
+ * in the same request, since an alias cannot share its name with an index.
+ *
+ * @param options.keepOldIndices Number of previous generations to keep after the swap, newest first.
+ *   Older generations, including ones no alias pointed to anymore, are deleted. Keeps all by default.
+ * @param options.readAlias An additional alias, such as one shared by several indexes, that is moved
+ *   from the previous generation to the new one in the same request.
+ * @returns The concrete indices the aliases no longer point to.
+ */
+export async function swapIndexAlias(
+  index: string,
+  generationName: string,
+  options: { keepOldIndices?: number; readAlias?: string } = {}
+): Promise<string[]> {
+  const client = getClient();
+  const actions: IndicesUpdateAliasesAction[] = [];
+  const oldIndices: string[] = [];
+
+  const swaps: Array<[alias: string, target: string, readAlias: string | undefined]> = [
+    [index, generationName, options.readAlias],
+    [
+      getLocationsIndexName(index),
+      getLocationsIndexName(generationName),
+      options.readAlias ? getLocationsIndexName(options.readAlias) : undefined,
+    ],
+  ];
+  for (const [alias, target, readAlias] of swaps) {
+    const aliasTargets = await getAliasTargets(alias);
+    actions.push({ add: { index: target, alias } });
+    if (aliasTargets.length > 0) {
+      for (const oldIndex of aliasTargets.filter((name) => name !== target)) {
+        actions.push({ remove: { index: oldIndex, alias } });
+        oldIndices.push(oldIndex);
+      }
+    } else if (await client.indices.exists({ index: alias })) {
+      logger.info(`Replacing index "${alias}" with an alias to "${target}".`);
+      actions.push({ remove_index: { index: alias } });
+    }
+    if (readAlias) {
+      // The read alias may span other indexes; only this index's previous generations leave it.
+      const readAliasTargets = await getAliasTargets(readAlias);
+      actions.push({ add: { index: target, alias: readAlias } });
+      for (const oldIndex of readAliasTargets.filter((name) => name !== target && aliasTargets.includes(name))) {
+        actions.push({ remove: { index: oldIndex, alias: readAlias } });
+      }
+    }
+  }
+
+  logger.info(`Swapping alias "${index}" to "${generationName}"...`);
+  await client.indices.updateAliases({ actions });
+
+  if (options.keepOldIndices !== undefined) {
+    await deleteOldGenerations(index, generationName, options.keepOldIndices);
+  }
+  return oldIndices;
+}
+
+/**
+ * Deletes the generations of `index` older than the `keep` newest ones before `currentGeneration`,
+ * together with their locations indices.
+ */
+async function deleteOldGenerations(index: string, currentGeneration: string, keep: number): Promise<void> {
+  const client = getClient();
+  const response = await client.indices.get({ index: `${index}-*`, ignore_unavailable: true });
+  // Generation numbers are zero-padded, so the names sort in generation order.
+  const generations = Object.keys(response)
+    .filter((name) => name !== currentGeneration && INDEX_GENERATION_PATTERN.test(name.slice(index.length)))
+    .sort()
+    .reverse();
+  const expired = generations.slice(keep);
+  if (expired.length === 0) {
+    return;
+  }
+  const expiredIndices = expired.flatMap((name) => [name, getLocationsIndexName(name)]);
+  logger.info(`Deleting old indices: ${expiredIndices.join(', ')}`);
+  await client.indices.delete({ index: expiredIndices, ignore_unavailable: true });
+}
+
+/**
+ * Adds `readAlias` to the concrete indices behind `index` and `<index>_locations`, for indexes that
+ * were updated in place. Indices that do not exist yet, or already carry the alias, are skipped.
+ */
+export async function addReadAlias(index: string, readAlias: string): Promise<void> {
+  const client = getClient();
+  const actions: IndicesUpdateAliasesAction[] = [];
+  for (const [source, alias] of [
+    [index, readAlias],
+    [getLocationsIndexName(index), getLocationsIndexName(readAlias)],
+  ]) {
+    if (!(await client.indices.exists({ index: source }))) {
+      continue;
+    }
+    const readAliasTargets = await getAliasTargets(alias);
+    for (const concreteIndex of await resolveConcreteIndices(source)) {
+      if (!readAliasTargets.includes(concreteIndex)) {
+        actions.push({ add: { index: concreteIndex, alias } });
+      }
+    }
+  }
+  if (actions.length > 0) {
+    logger.info(`Adding alias "${readAlias}" to "${index}"...`);
+    await client.indices.updateAliases({ actions });
+  }
+}
+
+type FieldMapping = {
+  type?: unknown;
+  dims?: unknown;
+  similarity?: unknown;
+  index_options?: { type?: unknown };
+  inference_id?: unknown;
+};
+
+/** Dense vectors are mapped with cosine similarity unless the mapping says otherwise. */
+const DEFAULT_VECTOR_SIMILARITY = 'cosine';
+
+/**
+ * Returns the `code_vector` mapping this run expects: the one of the `--mapping-file`, which must then
+ * have the provider's dimensions, or the configured one.
+ */
+function getExpectedVectorMapping(vectorDims: number | undefined): {
+  dims?: number;
+  similarity: string;
+  dimsSource: string;
+  similaritySource: string;
+  fromMappingFile: boolean;
+} {
+  const mappingFile = elasticsearchConfig.mappingFile;
+  const fileVector = mappingFile
+    ? (loadMappingFile(mappingFile).mappings.properties?.code_vector as FieldMapping | undefined)
+    : undefined;
+  if (mappingFile && fileVector) {
+    const dims = typeof fileVector.dims === 'number' ? fileVector.dims : undefined;
+    if (vectorDims !== undefined && dims !== vectorDims) {
+      throw new Error(
+        `--mapping-file ${mappingFile} maps code_vector with ${dims} dimensions, but the embedding provider ` +
+          `produces ${vectorDims}. Set code_vector.dims to ${vectorDims} in the file.`
+      );
+    }
+    return {
+      dims,
+      similarity: typeof fileVector.similarity === 'string' ? fileVector.similarity : DEFAULT_VECTOR_SIMILARITY,
+      dimsSource: `--mapping-file ${mappingFile} sets`,
+      similaritySource: `--mapping-file ${mappingFile} sets`,
+      fromMappingFile: true,
+    };
+  }
+  return {
+    dims: vectorDims ?? (indexingConfig.enableDenseVectors ? elasticsearchConfig.denseVectorDims : undefined),
+    similarity: elasticsearchConfig.denseVectorSimilarity,
+    dimsSource: vectorDims !== undefined ? 'the embedding provider produces' : 'SCS_IDXR_DENSE_VECTOR_DIMS is set to',
+    similaritySource: '--similarity is',
+    fromMappingFile: false,
+  };
+}
+
+/**
+ * Throws when an existing code chunk index is mapped for other embeddings than the ones this run
+ * writes, since its documents would be indexed but never match a query:
+ * - `code_vector` must be a `dense_vector` with `vectorDims` dimensions, or `SCS_IDXR_DENSE_VECTOR_DIMS`
+ *   when dense vectors are computed by the ingest pipeline, the configured similarity and, when
+ *   `--vector-quantization` is set, its index type. It is not checked when neither is in use. A
+ *   `--mapping-file` replaces them with its own `code_vector`.
+ * - `semantic_text` must be a `semantic_text` field using `SCS_IDXR_ELASTICSEARCH_INFERENCE_ID`,
+ *   unless semantic text is disabled.
+ *
+ * Every generation behind an alias is checked.
+ */
+async function assertCompatibleMapping(index: string, vectorDims: number | undefined): Promise<void> {
+  const expected = getExpectedVectorMapping(vectorDims);
+  const { dims: expectedDims, dimsSource } = expected;
+  const inferenceId = elasticsearchConfig.disableSemanticText ? undefined : getElserInferenceIdOrThrow();
+  if (expectedDims === undefined && inferenceId === undefined) {
+    return;
+  }
+
+  const response = (await getClient().indices.getMapping({ index })) as unknown as Record<
+    string,
+    { mappings?: { properties?: Record<string, FieldMapping | undefined> } }
+  >;
+  for (const entry of Object.values(response)) {
+    const properties = entry?.mappings?.properties ?? {};
+    const codeVector = properties.code_vector;
+    if (expectedDims !== undefined && codeVector?.type !== 'dense_vector') {
+      throw new Error(
+        `Index "${index}" does not map code_vector as a dense_vector, but ${dimsSource} ${expectedDims} ` +
+          'dimensions. Reindex with --clean to recreate the index.'
+      );
+    }
+    if (expectedDims !== undefined && codeVector?.dims !== expectedDims) {
+      throw new Error(
+        `Index "${index}" maps code_vector with ${codeVector?.dims} dimensions, but ${dimsSource} ${expectedDims}. ` +
+          'Reindex with --clean to recreate the index for this provider.'
+      );
+    }
+    const similarity = typeof codeVector?.similarity === 'string' ? codeVector.similarity : DEFAULT_VECTOR_SIMILARITY;
+    if (expectedDims !== undefined && similarity !== expected.similarity) {
+      const keep = expected.fromMappingFile
+        ? `set code_vector.similarity to ${similarity}`
+        : `pass --similarity ${similarity}`;
+      throw new Error(
+        `Index "${index}" maps code_vector with ${similarity} similarity, but ${expected.similaritySource} ` +
+          `${expected.similarity}. Reindex with --clean to recreate the index, or ${keep} to keep it.`
+      );
+    }
+    const quantization = elasticsearchConfig.denseVectorQuantization as VectorQuantization | undefined;
+    const indexType = codeVector?.index_options?.type;
+    if (
+      expectedDims !== undefined &&
+      !expected.fromMappingFile &&
+      quantization !== undefined &&
+      indexType !== getVectorIndexType(quantization)
+    ) {
+      const current = VECTOR_QUANTIZATIONS.find((name) => getVectorIndexType(name) === indexType);
+      const keep = current ? `pass --vector-quantization ${current}` : 'omit --vector-quantization';
+      throw new Error(
+        `Index "${index}" indexes code_vector with ${typeof indexType === 'string' ? indexType : 'the default'} ` +
+          `index options, but --vector-quantization is ${quantization}. Reindex with --clean to recreate the ` +
+          `index, or ${keep} to keep it.`
+      );
+    }
+
+    const semanticText = properties.semantic_text;
+    if (inferenceId !== undefined && semanticText?.type !== 'semantic_text') {
+      throw new Error(
+        `Index "${index}" has no semantic_text field, because it was created with semantic text disabled. ` +
+          'Reindex with --clean, or set SCS_IDXR_DISABLE_SEMANTIC_TEXT=true.'
+      );
+    }
+    // An index created without an explicit endpoint uses the default one, which is not compared.
+    if (
+      inferenceId !== undefined &&
+      typeof semanticText?.inference_id === 'string' &&
+      semanticText.inference_id !== inferenceId
+    ) {
+      throw new Error(
+        `Index "${index}" embeds semantic_text with the inference endpoint "${semanticText.inference_id}", but ` +
+          `SCS_IDXR_ELASTICSEARCH_INFERENCE_ID is "${inferenceId}". Reindex with --clean to recreate the index ` +
+          'for this endpoint.'
+      );
+    }
   }
 }
 
@@ -274,9 +808,45 @@ export interface ChunkLocation {
   directoryDepth?: number;
   git_file_hash?: string;
   git_branch?: string;
+  repo_name?: string;
+  repo_root?: string;
+  repo_url?: string;
+  /** Ref the repository was indexed at, see `--repo-ref`. */
+  repo_ref?: string;
+  /** HEAD commit of the repository when this location was indexed. */
+  commit_sha?: string;
+  /** Paths imported anywhere in the file. */
+  file_imports?: string[];
+  /** Commit date of the most recent change to the lines of this location, see `--with-blame`. */
+  last_modified?: string | null;
+  last_author?: string | null;
   updated_at: string;
 }
 
+const LOCATIONS_INDEX_MAPPINGS: MappingTypeMapping = {
+  properties: {
+    chunk_id: { type: 'keyword' },
+    // Root field used for KQL filtering / discovery on the locations store.
+    filePath: { type: 'wildcard' },
+    startLine: { type: 'integer' },
+    endLine: { type: 'integer' },
+    directoryPath: { type: 'keyword', eager_global_ordinals: true },
+    directoryName: { type: 'keyword' },
+    directoryDepth: { type: 'integer' },
+    git_file_hash: { type: 'keyword' },
+    git_branch: { type: 'keyword' },
+    repo_name: { type: 'keyword' },
+    repo_root: { type: 'keyword' },
+    repo_url: { type: 'keyword' },
+    repo_ref: { type: 'keyword' },
+    commit_sha: { type: 'keyword' },
+    file_imports: { type: 'keyword' },
+    last_modified: { type: 'date' },
+    last_author: { type: 'keyword' },
+    updated_at: { type: 'date' },
+  },
+};
+
 /**
  * Creates the Elasticsearch index for storing code chunk locations.
  *
@@ -287,25 +857,20 @@ export async function createLocationsIndex(index: string): Promise<void> {
   const locationsIndexName = getLocationsIndexName(index);
   const indexExists = await getClient().indices.exists({ index: locationsIndexName });
   if (!indexExists) {
-    logger.info(`Creating index "${locationsIndexName}"...`);
-    await getClient().indices.create({
-      index: locationsIndexName,
-      mappings: {
-        properties: {
-          chunk_id: { type: 'keyword' },
-          // Root field used for KQL filtering / discovery on the locations store.
-          filePath: { type: 'wildcard' },
-          startLine: { type: 'integer' },
-          endLine: { type: 'integer' },
-          directoryPath: { type: 'keyword', eager_global_ordinals: true },
-          directoryName: { type: 'keyword' },
-          directoryDepth: { type: 'integer' },
-          git_file_hash: { type: 'keyword' },
-          git_branch: { type: 'keyword' },
-          updated_at: { type: 'date' },
-        },
-      },
-    });
+    // An aliased chunk index keeps its locations next to the generation it points to.
+    const [generationName] = await getAliasTargets(index);
+    if (generationName) {
+      const generationLocationsName = getLocationsIndexName(generationName);
+      logger.info(`Creating index "${generationLocationsName}" behind alias "${locationsIndexName}"...`);
+      await getClient().indices.create({
+        index: generationLocationsName,
+        mappings: LOCATIONS_INDEX_MAPPINGS,
+        aliases: { [locationsIndexName]: {} },
+      });
+    } else {
+      logger.info(`Creating index "${locationsIndexName}"...`);
+      await getClient().indices.create({ index: locationsIndexName, mappings: LOCATIONS_INDEX_MAPPINGS });
+    }
   } else {
     logger.info(`Index "${locationsIndexName}" already exists.`);
   }
@@ -326,6 +891,7 @@ export async function createSettingsIndex(index: string): Promise<void> {
       index: settingsIndexName,
       mappings: {
         properties: {
+          repo_name: { type: 'keyword' },
           branch: { type: 'keyword' },
           commit_hash: { type: 'keyword' },
           updated_at: { type: 'date' },
@@ -337,19 +903,31 @@ export async function createSettingsIndex(index: string): Promise<void> {
   }
 }
 
+/**
+ * Returns the settings document id that stores the last indexed commit of a repository branch.
+ *
+ * An index named after its repository keeps the branch-only id used before indexes could be shared, so
+ * existing deployments do not lose their incremental baseline. Repositories indexed into a shared index
+ * get their own document per branch.
+ */
+function getSettingsDocumentId(index: string, branch: string, repoName?: string): string {
+  return repoName && repoName !== index ? `${repoName}:${branch}` : branch;
+}
+
 /**
  * Retrieves the last indexed commit hash for a given branch.
  *
  * @param branch The branch name.
  * @param index The base name of the Elasticsearch index.
+ * @param repoName The repository name, used to keep repositories sharing an index apart.
  * @returns A promise that resolves to the commit hash or null if not found.
  */
-export async function getLastIndexedCommit(branch: string, index: string): Promise<string | null> {
+export async function getLastIndexedCommit(branch: string, index: string, repoName?: string): Promise<string | null> {
   const settingsIndexName = `${index}_settings`;
   try {
     const response = await getClient().get<{ commit_hash: string }>({
       index: settingsIndexName,
-      id: branch,
+      id: getSettingsDocumentId(index, branch, repoName),
     });
     return response._source?.commit_hash ?? null;
   } catch (error: unknown) {
@@ -366,14 +944,21 @@ export async function getLastIndexedCommit(branch: string, index: string): Promi
  * @param branch The branch name.
  * @param commitHash The new commit hash.
  * @param index The base name of the Elasticsearch index.
+ * @param repoName The repository name, used to keep repositories sharing an index apart.
  * @returns A promise that resolves when the update is complete.
  */
-export async function updateLastIndexedCommit(branch: string, commitHash: string, index: string): Promise<void> {
+export async function updateLastIndexedCommit(
+  branch: string,
+  commitHash: string,
+  index: string,
+  repoName?: string
+): Promise<void> {
   const settingsIndexName = `${index}_settings`;
   await getClient().index({
     index: settingsIndexName,
-    id: branch,
+    id: getSettingsDocumentId(index, branch, repoName),
     document: {
+      ...(repoName ? { repo_name: repoName } : {}),
       branch,
       commit_hash: commitHash,
       updated_at: new Date().toISOString(),
@@ -388,6 +973,20 @@ export interface SymbolInfo {
   line: number;
 }
 
+/** A name-based reference from a chunk to another symbol. Names are not resolved to a definition. */
+export interface ReferenceInfo {
+  name: string;
+  /**
+   * `function` for free-function calls, `method` for calls on a receiver (`g.Greet()`), `macro` for
+   * macro invocations and `type` for instantiations such as `new Foo()`.
+   */
+  kind: 'function' | 'method' | 'macro' | 'type';
+  /** Receiver of a method call when it is a plain identifier, e.g. `g` in `g.Greet()`. */
+  receiver?: string;
+  /** Type of the receiver when it is declared statically (Go only), e.g. `Greeter` after `g := Greeter{}`. */
+  receiver_type?: string;
+}
+
 export interface ExportInfo {
   name: string;
   type: 'named' | 'default' | 'namespace';
@@ -400,8 +999,32 @@ export interface CodeChunk {
   kind?: string;
   imports?: { path: string; type: 'module' | 'file'; symbols?: string[] }[];
   symbols?: SymbolInfo[];
+  /** Symbols the chunk calls or instantiates, deduplicated per chunk. */
+  references?: ReferenceInfo[];
   exports?: ExportInfo[];
   containerPath?: string;
+  /** Fully-qualified name of the symbol the chunk defines, e.g. `main.Greeter.Greet`. */
+  symbol_fqn?: string;
+  /** Language-independent kind of the symbol the chunk defines, e.g. `method` or `interface`. */
+  symbol_kind?: SymbolKind;
+  /** Innermost symbol enclosing the one the chunk defines, e.g. `Greeter` for the method `Greeter.Greet`. */
+  parent_symbol?: string;
+  /** Dotted path of the symbol the chunk defines within its file, without the package, e.g. `Greeter.Greet`. */
+  scope_path?: string;
+  /** Package declared by the chunk's file (Go, Java and Scala), e.g. `main`. */
+  package_name?: string;
+  /** Receiver type of a Go method, e.g. `Greeter` for `func (g *Greeter) Greet()`. */
+  receiver_type?: string;
+  /**
+   * Paths imported anywhere in the chunk's file, stored on the location since files sharing a chunk
+   * can import different packages.
+   */
+  file_imports?: string[];
+  /**
+   * File context (package, receiver type, imports) prepended to `semantic_text` when context embedding
+   * is enabled. Only meaningful on input chunks: it is part of the document id, not stored.
+   */
+  embedded_context?: string;
   /**
    * File path for this chunk occurrence.
    *
@@ -415,10 +1038,62 @@ export interface CodeChunk {
   directoryDepth?: number;
   git_file_hash?: string;
   git_branch?: string;
+  /** Name of the repository the chunk was parsed from. */
+  repo_name?: string;
+  /** Absolute path of the repository root on the indexing host. */
+  repo_root?: string;
+  /** URL of the repository, see `--repo-url`. */
+  repo_url?: string;
+  /** Ref the repository was indexed at, see `--repo-ref` (stored on the location only). */
+  repo_ref?: string;
+  /** HEAD commit of the repository when the chunk was parsed (stored on the location only). */
+  commit_sha?: string;
+  /**
+   * Milliseconds since the epoch when the file was read. Its location document is written with this
+   * external version, so a write of an older read, e.g. from a stuck indexer, cannot replace a newer one.
+   */
+  source_version?: number;
   chunk_hash: string;
   startLine?: number;
   endLine?: number;
+  /** The chunk's code. Chunk documents stored without `storeContent` have none, see `readChunkSource`. */
   content: string;
+  /**
+   * The whole content, gzip-compressed and base64-encoded, on chunk documents stored with
+   * `storeCompressed`. Their `content` is then only an excerpt. Search results have it inflated.
+   */
+  content_gz?: string;
+  /**
+   * The first `--preview-lines` lines of the chunk for result lists, with the signature of a function
+   * or method that starts below them. Stored, not searched.
+   */
+  preview?: string;
+  /** Comment block (or Python docstring) directly preceding the symbol, kept out of `content`. */
+  doc_comment?: string;
+  /** Trailing context from the following sibling chunk (only set when chunk overlap is enabled). */
+  overlap?: string;
+  /** Shared by every part of a split symbol: a content hash of the whole symbol. */
+  symbol_id?: string;
+  /** Name of the symbol a part of a split symbol belongs to, when it has one. */
+  symbol_name?: string;
+  /** Zero-based position of this window when a long symbol was split (absent for unsplit chunks). */
+  chunkIndex?: number;
+  /** Number of windows the symbol was split into (absent for unsplit chunks). */
+  totalChunks?: number;
+  /**
+   * One-based position among the file's chunks with the same `symbol_fqn`, `kind` and `chunkIndex`,
+   * set from the second one on, e.g. for overloads. Part of the location id, not stored.
+   */
+  symbol_occurrence?: number;
+  /** Tokens of `semantic_text` counted by the run's tokenizer, see `--tokenizer`. */
+  token_count?: number;
+  /**
+   * Commit date of the most recent change to the chunk's lines, see `--with-blame`. Null for files
+   * git does not track. A chunk document has the value of the location it was created from.
+   */
+  last_modified?: string | null;
+  /** Author of the commit of `last_modified`. */
+  last_author?: string | null;
   semantic_text: string;
   code_vector?: number[];
   created_at: string;
@@ -428,33 +1103,100 @@ export interface CodeChunk {
 /**
  * Produces a stable Elasticsearch document id for a chunk.
  *
- * Uses SHA256(content + language + type + kind + containerPath) to ensure identical code
- * from different files maps to the same document.
+ * Uses SHA256(content + language + type + kind + containerPath + doc_comment + symbol_fqn or package_name +
+ * relative imports + embedded_context + repo_name) to ensure identical code from different files of the same
+ * repository maps to the same document.
  */
-function getChunkDocumentId(chunk: CodeChunk): string {
+export function getChunkDocumentId(chunk: CodeChunk, options: { dedup?: boolean } = {}): string {
   // IMPORTANT: Do NOT include file-specific metadata (path, branch, line numbers)
   // in the hash input. This ensures identical content shares the same ID.
-  const stable = [chunk.type, chunk.language, chunk.kind ?? '', chunk.containerPath ?? '', chunk.content].join(':');
+  const stable = [
+    chunk.type,
+    chunk.language,
+    chunk.kind ?? '',
+    chunk.containerPath ?? '',
+    normalizeChunkContent(chunk.content),
+  ];
+  // Chunk documents are never updated in place, so a changed doc comment must produce a new document.
+  // Chunks without a doc comment keep their previous ids.
+  if (chunk.doc_comment) {
+    stable.push(chunk.doc_comment);
+  }
+  // The package part of a qualified name is not in the content, so identical definitions in two
+  // packages stay separate documents.
+  if (chunk.symbol_fqn) {
+    stable.push(chunk.symbol_fqn);
+  } else if (chunk.package_name) {
+    // The qualified name already includes the package.
+    stable.push(chunk.package_name);
+  }
+  // The embedded context comes from the whole file, so copies in files with other imports are embedded
+  // (and stored) separately.
+  if (chunk.embedded_context) {
+    stable.push(chunk.embedded_context);
+  }
+  // Relative imports are resolved against the importing file, so the same statement in two
+  // directories names different files.
+  const fileImports = chunk.imports?.filter((entry) => entry.type === 'file').map((entry) => entry.path) ?? [];
+  if (fileImports.length > 0) {
+    stable.push(...fileImports);
+  }
+  // Repositories sharing an index keep separate chunk documents, so a repo filter on the chunk index
+  // is exact and deleting one repository's files never removes a chunk another repository still uses.
+  if (chunk.repo_name) {
+    stable.push(chunk.repo_name);
+  }
+  // Without dedup every file keeps its own chunk documents, and its own embeddings.
+  if (options.dedup === false) {
+    stable.push(chunk.filePath);
+  }
 
-  return createHash('sha256').update(stable).digest('hex');
+  return createHash('sha256').update(stable.join(':')).digest('hex');
 }
 
-function getChunkLocationDocumentId(location: {
-  chunk_id: string;
-  filePath: string;
-  startLine: number;
-  endLine: number;
-  git_branch?: string;
-}): string {
+/**
+ * Normalizes chunk content for hashing, so copies that only differ in line endings or trailing
+ * whitespace share a chunk document. Content without either keeps its previous id.
+ */
+export function normalizeChunkContent(content: string): string {
+  return content.replace(/\r\n?/g, '\n').replace(/[ \t]+$/gm, '');
+}
+
+/**
+ * A location of a symbol is keyed on the repository, path, branch, kind and qualified name of the
+ * symbol, so re-indexing the symbol overwrites its location even when its content or lines changed.
+ * Other chunks have no name to key on and are keyed on their chunk id and line range.
+ */
+function getChunkLocationDocumentId(location: CodeChunk & { chunk_id: string }): string {
+  if (location.symbol_fqn) {
+    return createHash('sha256')
+      .update(
+        [
+          'symbol',
+          location.repo_name ?? '',
+          location.filePath,
+          location.git_branch ?? '',
+          location.kind ?? '',
+          location.symbol_fqn,
+          String(location.chunkIndex ?? ''),
+          String(location.symbol_occurrence ?? ''),
+        ].join(':')
+      )
+      .digest('hex');
+  }
   const stable = [
     location.chunk_id,
     location.filePath,
     String(location.startLine),
     String(location.endLine),
     location.git_branch ?? '',
-  ].join(':');
+  ];
+  // The same relative path can exist in several repositories sharing an index.
+  if (location.repo_name) {
+    stable.push(location.repo_name);
+  }
 
-  return createHash('sha256').update(stable).digest('hex');
+  return createHash('sha256').update(stable.join(':')).digest('hex');
 }
 
 /**
@@ -481,6 +1223,218 @@ export interface BulkIndexResult {
   succeeded: BulkIndexSucceeded[];
   /** Documents that failed to index with their errors */
   failed: BulkIndexFailed[];
+  /** Bulk items that were resent after a retryable (429/503) item failure */
+  retried?: number;
+  /** Chunks that referenced an existing chunk document instead of embedding and creating one */
+  deduplicated?: number;
+  /** Location writes rejected because a newer read of the file was indexed already, see `source_version` */
+  versionConflicts?: number;
+  /** Milliseconds spent in bulk requests, excluding embedding and the backoff between item retries */
+  bulkDurationMs?: number;
+  /** With `storeCompressed`: bytes of the content of the chunk documents sent, and of the fields storing it */
+  contentBytes?: number;
+  storedContentBytes?: number;
+  /** Chunks that overwritten symbol locations referenced before, see {@link deleteUnreferencedChunks} */
+  replacedChunkIds?: string[];
+}
+
+/** Bulk item statuses that are resent within the same `indexCodeChunks` call. */
+const RETRYABLE_BULK_ITEM_STATUSES = new Set([429, 503]);
+
+interface BulkItemOutcome {
+  status?: number;
+  error?: unknown;
+}
+
+/**
+ * Sends action/document pairs with the bulk API and resends only the items that failed with a
+ * retryable status (429/503), with capped exponential backoff between rounds. Items that succeeded
+ * or failed with any other status are never sent again.
+ *
+ * An exception on the first request is thrown. An exception on a retry round fails only the items
+ * being retried.
+ *
+ * @returns One outcome per action, in the order of `operations`, the number of resent items and the
+ * milliseconds spent in bulk requests.
+ */
+async function bulkWithItemRetries(
+  operations: Array<BulkOperationContainer | Record<string, unknown>>,
+  action: BulkOperationType,
+  pipeline?: string
+): Promise<{ outcomes: Array<BulkItemOutcome | undefined>; retried: number; durationMs: number }> {
+  const maxRetries = indexingConfig.bulkItemMaxRetries;
+  const outcomes: Array<BulkItemOutcome | undefined> = [];
+  let pending = Array.from({ length: operations.length / 2 }, (_, i) => i);
+  let retried = 0;
+  let durationMs = 0;
+
+  for (let attempt = 0; pending.length > 0; attempt++) {
+    const roundOps = pending.flatMap((i) => [operations[2 * i], operations[2 * i + 1]]);
+    const startTime = Date.now();
+    try {
+      const response = await getClient().bulk({
+        refresh: false,
+        operations: roundOps,
+        ...(pipeline ? { pipeline } : {}),
+      });
+      durationMs += Date.now() - startTime;
+      response.items.forEach((item: Partial<Record<BulkOperationType, BulkResponseItem>>, j: number) => {
+        const opIndex = pending[j];
+        if (opIndex !== undefined) {
+          outcomes[opIndex] = item[action];
+        }
+      });
+    } catch (error) {
+      if (attempt === 0) {
+        throw error;
+      }
+      for (const opIndex of pending) {
+        outcomes[opIndex] = { error };
+      }
+      break;
+    }
+
+    const retryable = pending.filter((i) => RETRYABLE_BULK_ITEM_STATUSES.has(outcomes[i]?.status ?? 0));
+    if (retryable.length === 0 || attempt >= maxRetries) {
+      break;
+    }
+    retried += retryable.length;
+    const delayMs = computeBackoffDelayMs(
+      attempt + 1,
+      indexingConfig.bulkItemRetryBaseDelayMs,
+      indexingConfig.queueRetryMaxDelayMs
+    );
+    logger.warn(`Retrying ${retryable.length} bulk items that failed with 429/503 in ${delayMs}ms.`);
+    await new Promise((resolve) => setTimeout(resolve, delayMs));
+    pending = retryable;
+  }
+
+  return { outcomes, retried, durationMs };
+}
+
+/**
+ * Returns true if an indexing error (a `BulkIndexFailed.error` or a thrown client error) means
+ * Elasticsearch rejected the request because it is overloaded (HTTP 429).
+ */
+export function isRejectedExecutionError(error: unknown): boolean {
+  const summary = summarizeElasticsearchError(error);
+  // Bulk item failures are already summarized and carry the item status directly.
+  const status = (error as { status?: unknown } | null)?.status ?? summary.status;
+  return status === 429 || summary.type === 'es_rejected_execution_exception';
+}
+
+/**
+ * Builds the chunk document stored in `<index>` for a chunk's content.
+ *
+ * @param codeVector Vector computed by an external embedding provider, overriding the chunk's own.
+ * @param options.storeCompressed Store the content gzip-compressed in `content_gz`, with an excerpt in
+ *   `content`, see `compressContent`.
+ * @param options.storeContent When false, the content is not stored at all, and search reads it from a
+ *   checkout at the chunk's line range (default: true).
+ */
+export function buildChunkDocument(
+  base: CodeChunk,
+  now: string,
+  codeVector?: number[],
+  options: { storeCompressed?: boolean; storeContent?: boolean } = {}
+): Record<string, unknown> {
+  return {
+    type: base.type,
+    language: base.language,
+    kind: base.kind,
+    imports: base.imports,
+    symbols: base.symbols,
+    ...(base.references?.length ? { references: base.references } : {}),
+    exports: base.exports,
+    containerPath: base.containerPath,
+    ...(base.symbol_fqn ? { symbol_fqn: base.symbol_fqn } : {}),
+    ...(base.symbol_kind ? { symbol_kind: base.symbol_kind } : {}),
+    ...(base.parent_symbol ? { parent_symbol: base.parent_symbol } : {}),
+    ...(base.scope_path ? { scope_path: base.scope_path } : {}),
+    ...(base.package_name ? { package_name: base.package_name } : {}),
+    ...(base.receiver_type ? { receiver_type: base.receiver_type } : {}),
+    ...(base.repo_name ? { repo_name: base.repo_name, repo_root: base.repo_root } : {}),
+    ...(base.repo_url ? { repo_url: base.repo_url } : {}),
+    chunk_hash: base.chunk_hash,
+    ...(options.storeContent === false
+      ? {}
+      : options.storeCompressed
+        ? compressContent(base.content)
+        : { content: base.content }),
+    ...(base.preview !== undefined ? { preview: base.preview } : {}),
+    ...(base.doc_comment ? { doc_comment: base.doc_comment } : {}),
+    ...(base.overlap ? { overlap: base.overlap } : {}),
+    ...(base.symbol_id ? { symbol_id: base.symbol_id, symbol_name: base.symbol_name } : {}),
+    ...(base.totalChunks !== undefined ? { chunkIndex: base.chunkIndex, totalChunks: base.totalChunks } : {}),
+    ...(base.token_count !== undefined ? { token_count: base.token_count } : {}),
+    ...(base.last_modified !== undefined ? { last_modified: base.last_modified, last_author: base.last_author } : {}),
+    // Embedded by the inference endpoint, so normalized for embedding like the text sent to a provider.
+    ...(!elasticsearchConfig.disableSemanticText
+      ? { semantic_text: normalizeEmbeddingText(base.semantic_text, base) }
+      : {}),
+    code_vector: codeVector ?? base.code_vector,
+    created_at: now,
+    updated_at: now,
+  };
+}
+
+/**
+ * Builds the location document stored in `<index>_locations` for one occurrence of a chunk.
+ */
+export function buildLocationDocument(chunk: CodeChunk, chunkId: string, now: string): Record<string, unknown> {
+  return {
+    chunk_id: chunkId,
+    filePath: chunk.filePath,
+    startLine: chunk.startLine,
+    endLine: chunk.endLine,
+    directoryPath: chunk.directoryPath,
+    directoryName: chunk.directoryName,
+    directoryDepth: chunk.directoryDepth,
+    git_file_hash: chunk.git_file_hash,
+    git_branch: chunk.git_branch,
+    ...(chunk.repo_name
+      ? { repo_name: chunk.repo_name, repo_root: chunk.repo_root, commit_sha: chunk.commit_sha }
+      : {}),
+    ...(chunk.repo_url ? { repo_url: chunk.repo_url } : {}),
+    ...(chunk.repo_ref ? { repo_ref: chunk.repo_ref } : {}),
+    ...(chunk.file_imports?.length ? { file_imports: chunk.file_imports } : {}),
+    ...(chunk.last_modified !== undefined
+      ? { last_modified: chunk.last_modified, last_author: chunk.last_author }
+      : {}),
+    updated_at: now,
+  };
+}
+
+export interface IndexCodeChunksOptions {
+  /**
+   * When set, `code_vector` is computed by this provider before the bulk request instead of by the
+   * Elasticsearch ingest pipeline.
+   */
+  embeddingProvider?: EmbeddingProvider;
+  /** When false, chunks with identical content in different files get separate chunk documents (default: true). */
+  dedup?: boolean;
+  /** Store chunk content compressed, see `buildChunkDocument`. */
+  storeCompressed?: boolean;
+  /** When false, chunk documents are stored without their content, see `buildChunkDocument` (default: true). */
+  storeContent?: boolean;
+}
+
+/**
+ * A batch of chunks grouped by chunk document and embedded by {@link prepareCodeChunks}, waiting to be
+ * written with {@link bulkIndexPreparedChunks}.
+ */
+export interface PreparedCodeChunks {
+  chunks: CodeChunk[];
+  index: string;
+  now: string;
+  groups: Map<string, { id: string; baseChunk: CodeChunk; inputIndices: number[] }>;
+  chunkIdByInputIndex: Map<number, string>;
+  existingChunkIds: Set<string>;
+  /** Chunk documents to create, in input order: the groups whose document does not exist yet. */
+  chunkIdsInOrder: string[];
+  vectorsByChunkId: Map<string, number[]>;
+  /** Set when embedding failed, which fails every chunk of the batch. */
+  embedError?: unknown;
 }
 
 /**
@@ -493,16 +1447,33 @@ export interface BulkIndexResult {
  * On complete failures (network errors, cluster unavailable), returns all chunks
  * as failed rather than throwing.
  *
+ * It runs {@link prepareCodeChunks} and {@link bulkIndexPreparedChunks} one after the other; callers
+ * that overlap embedding with bulk requests call them separately.
+ *
  * @param chunks An array of `CodeChunk` objects to index.
  * @returns A `BulkIndexResult` with succeeded and failed documents.
  */
-export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promise<BulkIndexResult> {
+export async function indexCodeChunks(
+  chunks: CodeChunk[],
+  index: string,
+  options: IndexCodeChunksOptions = {}
+): Promise<BulkIndexResult> {
   if (chunks.length === 0) {
     return { succeeded: [], failed: [] };
   }
+  return bulkIndexPreparedChunks(await prepareCodeChunks(chunks, index, options), options);
+}
 
-  const semanticTextEnabled = !elasticsearchConfig.disableSemanticText;
-
+/**
+ * Groups a batch of chunks by chunk document, looks up which chunk documents already exist and
+ * embeds the others when `options.embeddingProvider` is set. Embedding failures are recorded in the
+ * result instead of thrown, so the batch's chunks are reported as failed by the bulk stage.
+ */
+export async function prepareCodeChunks(
+  chunks: CodeChunk[],
+  index: string,
+  options: IndexCodeChunksOptions = {}
+): Promise<PreparedCodeChunks> {
   // Test-only hook: make it possible for integration tests to deterministically create
   // in-flight indexing work and exercise worker drain / concurrency scenarios.
   // This MUST remain a no-op in production.
@@ -525,7 +1496,6 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
     }
   }
 
-  const indexName = index;
   const now = new Date().toISOString();
 
   // 1) Validate input chunks and group by stable chunk document id (content-based).
@@ -548,7 +1518,7 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
       );
     }
 
-    const chunkId = getChunkDocumentId(doc);
+    const chunkId = getChunkDocumentId(doc, { dedup: options.dedup });
     chunkIdByInputIndex.set(i, chunkId);
 
     const existing = groups.get(chunkId);
@@ -559,78 +1529,117 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
     }
   }
 
+  // Chunk docs that already exist are neither embedded nor sent again; their inputs only get locations.
+  const existingChunkIds =
+    options.dedup === false ? new Set<string>() : await getExistingChunkIds(index, Array.from(groups.keys()));
+  const chunkIdsInOrder = Array.from(groups.keys()).filter((chunkId) => !existingChunkIds.has(chunkId));
+  const prepared: PreparedCodeChunks = {
+    chunks,
+    index,
+    now,
+    groups,
+    chunkIdByInputIndex,
+    existingChunkIds,
+    chunkIdsInOrder,
+    vectorsByChunkId: new Map(),
+  };
+  if (options.embeddingProvider && chunkIdsInOrder.length > 0) {
+    try {
+      const textOptions = getEmbeddingTextOptions();
+      const contents = chunkIdsInOrder.map((chunkId) => {
+        const baseChunk = groups.get(chunkId)?.baseChunk;
+        return baseChunk ? normalizeEmbeddingText(baseChunk.content, baseChunk, textOptions) : '';
+      });
+      const vectors = await options.embeddingProvider.embed(contents);
+      chunkIdsInOrder.forEach((chunkId, i) => prepared.vectorsByChunkId.set(chunkId, vectors[i]));
+    } catch (error) {
+      prepared.embedError = summarizeElasticsearchError(error);
+      logger.error('Exception while embedding chunk documents', { ...prepared.embedError, phase: 'embed' });
+    }
+  }
+  return prepared;
+}
+
+/**
+ * Writes a batch prepared by {@link prepareCodeChunks}: creates its new chunk documents and indexes a
+ * location document per chunk, see {@link indexCodeChunks}.
+ */
+export async function bulkIndexPreparedChunks(
+  prepared: PreparedCodeChunks,
+  options: IndexCodeChunksOptions = {}
+): Promise<BulkIndexResult> {
+  const { chunks, groups, chunkIdByInputIndex, existingChunkIds, chunkIdsInOrder, vectorsByChunkId, now } = prepared;
+  const indexName = prepared.index;
+  if (chunks.length === 0) {
+    return { succeeded: [], failed: [] };
+  }
+  if (prepared.embedError !== undefined) {
+    const error = prepared.embedError;
+    return { succeeded: [], failed: chunks.map((chunk, inputIndex) => ({ chunk, inputIndex, error })) };
+  }
+
   const succeeded: BulkIndexSucceeded[] = [];
   const failed: BulkIndexFailed[] = [];
   const failedInputIndices = new Map<number, unknown>();
+  let retried = 0;
+  let versionConflicts = 0;
+  let bulkDurationMs = 0;
+  let contentBytes = 0;
+  let storedBytes = 0;
 
   // 2) Create chunk documents (one per unique content) using bulk create.
   //
   // We intentionally avoid updating existing chunk docs to prevent expensive semantic_text re-inference.
-  // If the doc already exists, bulk create returns 409, which we treat as success.
-  const chunkIdsInOrder = Array.from(groups.keys());
+  // A doc created concurrently by another batch makes bulk create return 409, which we treat as success.
   if (chunkIdsInOrder.length > 0) {
     const chunkOps: Array<BulkOperationContainer | Record<string, unknown>> = [];
     for (const chunkId of chunkIdsInOrder) {
       const group = groups.get(chunkId);
       if (!group) continue;
 
-      const base = group.baseChunk;
-      const chunkDoc: Record<string, unknown> = {
-        type: base.type,
-        language: base.language,
-        kind: base.kind,
-        imports: base.imports,
-        symbols: base.symbols,
-        exports: base.exports,
-        containerPath: base.containerPath,
-        chunk_hash: base.chunk_hash,
-        content: base.content,
-        ...(semanticTextEnabled ? { semantic_text: base.semantic_text } : {}),
-        code_vector: base.code_vector,
-        created_at: now,
-        updated_at: now,
-      };
+      const chunkDoc = buildChunkDocument(group.baseChunk, now, vectorsByChunkId.get(chunkId), {
+        storeCompressed: options.storeCompressed,
+        storeContent: options.storeContent,
+      });
+      if (options.storeCompressed) {
+        contentBytes += Buffer.byteLength(group.baseChunk.content, 'utf8');
+        storedBytes += storedContentBytes(chunkDoc as { content: string; content_gz?: string });
+      }
 
       chunkOps.push({ create: { _index: indexName, _id: chunkId } });
       chunkOps.push(chunkDoc);
     }
 
-    const bulkOptions: {
-      refresh: boolean;
-      operations: Array<BulkOperationContainer | Record<string, unknown>>;
-      pipeline?: string;
-    } = {
-      refresh: false,
-      operations: chunkOps,
-    };
-    if (indexingConfig.enableDenseVectors) {
-      bulkOptions.pipeline = codeSimilarityPipeline;
-    }
+    const pipeline =
+      indexingConfig.enableDenseVectors && !options.embeddingProvider ? codeSimilarityPipeline : undefined;
 
     try {
-      const chunkBulkResponse = await getClient().bulk(bulkOptions);
-
-      chunkBulkResponse.items.forEach(
-        (action: Partial<Record<BulkOperationType, BulkResponseItem>>, opIndex: number) => {
-          const result = action.create;
-          const chunkId = chunkIdsInOrder[opIndex];
-          if (!chunkId) return;
-          const group = groups.get(chunkId);
-          if (!group) return;
-
-          // 409 = document already exists; acceptable and expected for re-indexing.
-          if (result?.status === 409) {
-            return;
-          }
+      const chunkBulk = await bulkWithItemRetries(chunkOps, 'create', pipeline);
+      retried += chunkBulk.retried;
+      bulkDurationMs += chunkBulk.durationMs;
 
-          if (result?.error) {
-            const summarized = summarizeElasticsearchError(result.error);
-            for (const inputIndex of group.inputIndices) {
-              failedInputIndices.set(inputIndex, summarized);
-            }
+      chunkBulk.outcomes.forEach((result, opIndex) => {
+        const chunkId = chunkIdsInOrder[opIndex];
+        if (!chunkId) return;
+        const group = groups.get(chunkId);
+        if (!group) return;
+
+        // 409 = document already exists; acceptable and expected for re-indexing.
+        if (result?.status === 409) {
+          return;
+        }
+
+        if (result?.error) {
+          const summarized = {
+            ...summarizeElasticsearchError(result.error),
+            status: result.status,
+            documentId: chunkId,
+          };
+          for (const inputIndex of group.inputIndices) {
+            failedInputIndices.set(inputIndex, summarized);
           }
         }
-      );
+      });
     } catch (error) {
       const summarized = summarizeElasticsearchError(error);
       logger.error('Exception during bulk indexing (chunk documents)', summarized);
@@ -651,6 +1660,9 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
   const locationIdsInOrder: string[] = [];
   const locationOps: Array<BulkOperationContainer | Record<string, unknown>> = [];
   const inputIndicesByLocationId = new Map<string, number[]>();
+  const locationOpIndexById = new Map<string, number>();
+  // Symbol locations are overwritten in place, so the chunk a location referenced before may be orphaned.
+  const symbolLocationChunkIds = new Map<string, string>();
 
   for (let inputIndex = 0; inputIndex < chunks.length; inputIndex++) {
     if (failedInputIndices.has(inputIndex)) {
@@ -667,56 +1679,69 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
       continue;
     }
 
-    const locationId = getChunkLocationDocumentId({
-      chunk_id: chunkId,
-      filePath,
-      startLine: chunk.startLine,
-      endLine: chunk.endLine,
-      git_branch: chunk.git_branch,
-    });
+    const locationId = getChunkLocationDocumentId({ ...chunk, chunk_id: chunkId });
+    if (chunk.symbol_fqn) {
+      symbolLocationChunkIds.set(locationId, chunkId);
+    }
 
-    const locationDoc: Record<string, unknown> = {
-      chunk_id: chunkId,
-      filePath,
-      startLine: chunk.startLine,
-      endLine: chunk.endLine,
-      directoryPath: chunk.directoryPath,
-      directoryName: chunk.directoryName,
-      directoryDepth: chunk.directoryDepth,
-      git_file_hash: chunk.git_file_hash,
-      git_branch: chunk.git_branch,
-      updated_at: now,
+    const locationOp: BulkOperationContainer = {
+      index: {
+        _index: locationsIndexName,
+        _id: locationId,
+        // `external_gte`, so a location resent by the same read, e.g. after a failed batch, is written again.
+        ...(chunk.source_version !== undefined ? { version: chunk.source_version, version_type: 'external_gte' } : {}),
+      },
     };
+    const locationDoc = buildLocationDocument(chunk, chunkId, now);
 
     const existing = inputIndicesByLocationId.get(locationId);
     if (existing) {
       existing.push(inputIndex);
+      // A file read again while its earlier chunks were still queued is written from the later read.
+      const opIndex = locationOpIndexById.get(locationId) ?? 0;
+      if ((chunk.source_version ?? 0) > ((locationOps[opIndex] as BulkOperationContainer).index?.version ?? 0)) {
+        locationOps[opIndex] = locationOp;
+        locationOps[opIndex + 1] = locationDoc;
+      }
       continue;
     }
     inputIndicesByLocationId.set(locationId, [inputIndex]);
+    locationOpIndexById.set(locationId, locationOps.length);
     locationIdsInOrder.push(locationId);
-    locationOps.push({ index: { _index: locationsIndexName, _id: locationId } });
+    locationOps.push(locationOp);
     locationOps.push(locationDoc);
   }
 
+  let replacedChunkIds: string[] = [];
   if (locationOps.length > 0) {
+    replacedChunkIds = await getReplacedChunkIds(locationsIndexName, symbolLocationChunkIds);
     try {
-      const locationBulkResponse = await getClient().bulk({ refresh: false, operations: locationOps });
-      locationBulkResponse.items.forEach(
-        (action: Partial<Record<BulkOperationType, BulkResponseItem>>, opIndex: number) => {
-          const result = action.index;
-          if (!result?.error) return;
-
-          const locationId = locationIdsInOrder[opIndex];
-          if (!locationId) return;
-
-          const summarized = summarizeElasticsearchError(result.error);
-          const affectedInputIndices = inputIndicesByLocationId.get(locationId) ?? [];
-          for (const inputIndex of affectedInputIndices) {
-            failedInputIndices.set(inputIndex, summarized);
-          }
+      const locationBulk = await bulkWithItemRetries(locationOps, 'index');
+      retried += locationBulk.retried;
+      bulkDurationMs += locationBulk.durationMs;
+
+      locationBulk.outcomes.forEach((result, opIndex) => {
+        if (!result?.error) return;
+
+        const locationId = locationIdsInOrder[opIndex];
+        if (!locationId) return;
+
+        // 409 = a newer read of the file was indexed by another run; its location is kept.
+        if (result.status === 409) {
+          versionConflicts += inputIndicesByLocationId.get(locationId)?.length ?? 0;
+          return;
         }
-      );
+
+        const summarized = {
+          ...summarizeElasticsearchError(result.error),
+          status: result.status,
+          documentId: locationId,
+        };
+        const affectedInputIndices = inputIndicesByLocationId.get(locationId) ?? [];
+        for (const inputIndex of affectedInputIndices) {
+          failedInputIndices.set(inputIndex, summarized);
+        }
+      });
     } catch (error) {
       const summarized = summarizeElasticsearchError(error);
       logger.error('Exception during bulk indexing (location documents)', summarized);
@@ -728,7 +1753,9 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
     }
   }
 
-  // 4) Build final per-input results.
+  // 4) Build final per-input results. An input is deduplicated when its chunk document existed
+  // already or was created for an earlier input of the same batch.
+  let deduplicated = 0;
   for (let i = 0; i < chunks.length; i++) {
     const chunk = chunks[i];
     if (!chunk) continue;
@@ -737,6 +1764,10 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
       failed.push({ chunk, inputIndex: i, error });
     } else {
       succeeded.push({ chunk, inputIndex: i });
+      const chunkId = chunkIdByInputIndex.get(i) ?? '';
+      if (existingChunkIds.has(chunkId) || groups.get(chunkId)?.inputIndices[0] !== i) {
+        deduplicated++;
+      }
     }
   }
 
@@ -746,6 +1777,24 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
 
   logger.info(`Bulk operations completed for ${chunks.length} chunks`);
 
+  if (deduplicated > 0) {
+    logger.info(`Dedup: ${deduplicated} of ${chunks.length} chunks reused an existing chunk document`, {
+      deduplicated,
+    });
+  }
+
+  if (versionConflicts > 0) {
+    logger.warn(
+      `Version conflicts: kept the newer locations of ${versionConflicts} of ${chunks.length} chunks, ` +
+        'indexed by another run from a later read of their files',
+      { versionConflicts }
+    );
+  }
+
+  if (retried > 0 || failed.length > 0) {
+    logger.info(`Bulk item retries: ${retried} retried, ${failed.length} permanently failed of ${chunks.length}`);
+  }
+
   if (failed.length > 0) {
     // Keep logs bounded: include only a small sample to avoid OOM on large/verbose errors.
     const sample = failed.slice(0, 5).map((f) => ({
@@ -758,11 +1807,186 @@ export async function indexCodeChunks(chunks: CodeChunk[], index: string): Promi
     });
   }
 
-  return { succeeded, failed };
-}
-
-export async function getClusterHealth(): Promise<ClusterHealthResponse> {
-  return getClient().cluster.health();
+  return {
+    succeeded,
+    failed,
+    retried,
+    deduplicated,
+    ...(versionConflicts > 0 ? { versionConflicts } : {}),
+    bulkDurationMs,
+    ...(options.storeCompressed ? { contentBytes, storedContentBytes: storedBytes } : {}),
+    ...(replacedChunkIds.length > 0 ? { replacedChunkIds } : {}),
+  };
+}
+
+/**
+ * Returns the chunk ids referenced by the indexed locations among `chunkIdsByLocationId` other than
+ * the ones they are about to be overwritten with. A failed lookup returns none, leaving those chunk
+ * documents in the index.
+ */
+async function getReplacedChunkIds(
+  locationsIndexName: string,
+  chunkIdsByLocationId: Map<string, string>
+): Promise<string[]> {
+  const replaced = new Set<string>();
+  try {
+    for (const ids of chunkArray(Array.from(chunkIdsByLocationId.keys()), ES_TERMS_QUERY_BATCH_SIZE)) {
+      const response = await getClient().mget<{ chunk_id?: string }>({
+        index: locationsIndexName,
+        ids,
+        _source: ['chunk_id'],
+      });
+      for (const doc of response.docs) {
+        const previous = 'found' in doc && doc.found ? doc._source?.chunk_id : undefined;
+        if (previous && previous !== chunkIdsByLocationId.get(doc._id)) {
+          replaced.add(previous);
+        }
+      }
+    }
+  } catch (error) {
+    logger.warn('Could not look up the symbol locations being overwritten', summarizeElasticsearchError(error));
+    return [];
+  }
+  return Array.from(replaced);
+}
+
+/**
+ * Returns the ids among `chunkIds` that already have a chunk document. A failed lookup returns no
+ * ids, so every chunk is created and existing ones are reported as 409 conflicts.
+ */
+async function getExistingChunkIds(index: string, chunkIds: string[]): Promise<Set<string>> {
+  if (chunkIds.length === 0) {
+    return new Set();
+  }
+  try {
+    const response = await getClient().mget({ index, ids: chunkIds, _source: false });
+    return new Set(response.docs.filter((doc) => 'found' in doc && doc.found).map((doc) => doc._id));
+  } catch (error) {
+    logger.warn('Could not look up existing chunk documents, creating every chunk', summarizeElasticsearchError(error));
+    return new Set();
+  }
+}
+
+/**
+ * Returns the id of the location document `chunk` is indexed under: keyed on the symbol it defines
+ * or, for chunks without a qualified name, on its chunk document id, file and line range.
+ */
+export function getLocationDocumentId(chunk: CodeChunk, options: { dedup?: boolean } = {}): string {
+  return getChunkLocationDocumentId({ ...chunk, chunk_id: getChunkDocumentId(chunk, options) });
+}
+
+/** What {@link getExistingLocations} reads of a location document. */
+export interface ExistingLocation {
+  chunk_id?: string;
+  startLine?: number;
+  endLine?: number;
+  file_imports?: string[];
+}
+
+/**
+ * Returns the location documents among `locationIds` that exist in `<index>_locations`, mapped to
+ * their chunk id, line range and file imports. A failed lookup returns none, so the chunks are
+ * indexed again.
+ */
+export async function getExistingLocations(
+  index: string,
+  locationIds: string[]
+): Promise<Map<string, ExistingLocation>> {
+  const existing = new Map<string, ExistingLocation>();
+  try {
+    for (const ids of chunkArray(locationIds, ES_TERMS_QUERY_BATCH_SIZE)) {
+      const response = await getClient().mget<ExistingLocation>({
+        index: getLocationsIndexName(index),
+        ids,
+        _source: ['chunk_id', 'startLine', 'endLine', 'file_imports'],
+      });
+      for (const doc of response.docs) {
+        if ('found' in doc && doc.found) {
+          existing.set(doc._id, doc._source ?? {});
+        }
+      }
+    }
+  } catch (error) {
+    logger.warn('Could not look up existing locations, indexing every chunk again', summarizeElasticsearchError(error));
+    return new Map();
+  }
+  return existing;
+}
+
+export async function getClusterHealth(): Promise<ClusterHealthResponse> {
+  return getClient().cluster.health();
+}
+
+export interface WaitForElasticsearchOptions {
+  /** Attempts after the first failed one (default: 5). */
+  retries?: number;
+  /** Milliseconds each attempt may take (default: 10000). */
+  timeoutMs?: number;
+  /** Delay before the first retry, doubled for each further one up to 30 seconds (default: 1000). */
+  baseDelayMs?: number;
+}
+
+/**
+ * Waits until the cluster answers, so a run started together with Elasticsearch, e.g. next to a CI
+ * service container, does not fail on its first request. Connection errors, timeouts and 429, 502,
+ * 503 and 504 responses are retried with exponential backoff. Other errors, such as rejected
+ * credentials or a certificate that cannot be verified, are thrown right away, as is a cluster older
+ * than {@link MIN_ELASTICSEARCH_MAJOR_VERSION}. Errors say which setting to check.
+ *
+ * @returns The version of the cluster.
+ */
+export async function waitForElasticsearch(options: WaitForElasticsearchOptions = {}): Promise<string> {
+  const retries = options.retries ?? DEFAULT_ES_CONNECT_RETRIES;
+  const timeoutMs = options.timeoutMs ?? DEFAULT_ES_CONNECT_TIMEOUT_MS;
+  const baseDelayMs = options.baseDelayMs ?? ES_CONNECT_BASE_DELAY_MS;
+  // Outside the loop: a missing connection setting is not retried.
+  const client = getClient();
+
+  let version: string;
+  for (let attempt = 0; ; attempt++) {
+    try {
+      const info = await client.info(undefined, { requestTimeout: timeoutMs, maxRetries: 0 });
+      version = info.version.number;
+      break;
+    } catch (error) {
+      const summary = summarizeElasticsearchError(error);
+      const reason = summary.reason ?? summary.message ?? summary.type ?? 'unknown error';
+      if (summary.status === 401 || summary.status === 403) {
+        throw new Error(
+          `Elasticsearch rejected the connection check with status ${summary.status}: ${reason}. ` +
+            'Check --es-api-key, ELASTICSEARCH_API_KEY or ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD.'
+        );
+      }
+      if (summary.status !== undefined && !RETRYABLE_CONNECT_STATUSES.has(summary.status)) {
+        throw new Error(`Elasticsearch rejected the connection check with status ${summary.status}: ${reason}`);
+      }
+      if (CERTIFICATE_ERROR_PATTERN.test(reason)) {
+        throw new Error(
+          `Could not verify the TLS certificate of Elasticsearch: ${reason}. ` +
+            'Pass the CA certificate of the cluster with --es-ca-cert, or use --es-insecure for a development cluster.'
+        );
+      }
+      if (attempt >= retries) {
+        throw new Error(
+          `Could not connect to Elasticsearch after ${attempt + 1} attempts: ${reason}. ` +
+            'Check --es-node or --es-cloud-id (ELASTICSEARCH_ENDPOINT or ELASTICSEARCH_CLOUD_ID).'
+        );
+      }
+      const delayMs = computeBackoffDelayMs(attempt + 1, baseDelayMs, ES_CONNECT_MAX_DELAY_MS);
+      logger.warn(
+        `Elasticsearch is not reachable yet (${reason}), retrying in ${delayMs}ms (retry ${attempt + 1} of ${retries}).`
+      );
+      await new Promise((resolve) => setTimeout(resolve, delayMs));
+    }
+  }
+
+  const major = Number.parseInt(version, 10);
+  if (!Number.isInteger(major) || major < MIN_ELASTICSEARCH_MAJOR_VERSION) {
+    throw new Error(
+      `Elasticsearch ${version} is not supported: the indexer needs Elasticsearch ${MIN_ELASTICSEARCH_MAJOR_VERSION}.0 or later.`
+    );
+  }
+  return version;
 }
 
 export interface SearchResult extends CodeChunk {
@@ -770,6 +1994,25 @@ export interface SearchResult extends CodeChunk {
   score: number;
 }
 
+/**
+ * Returns the `index_options.type` of the `code_vector` of `index` when it searches quantized vectors,
+ * such as `int8_hnsw`, so kNN scores only approximate the similarity of the stored vectors. Returns
+ * undefined for an index of float vectors.
+ */
+export async function getVectorQuantization(index: string): Promise<string | undefined> {
+  const response = (await getClient().indices.getMapping({ index })) as unknown as Record<
+    string,
+    { mappings?: { properties?: Record<string, FieldMapping | undefined> } }
+  >;
+  for (const entry of Object.values(response)) {
+    const indexType = entry?.mappings?.properties?.code_vector?.index_options?.type;
+    if (typeof indexType === 'string' && indexType !== 'hnsw' && indexType !== 'flat') {
+      return indexType;
+    }
+  }
+  return undefined;
+}
+
 /**
  * Checks if the specified index has a semantic_text field.
  *
@@ -825,45 +2068,322 @@ export async function indexHasSemanticTextField(index: string): Promise<boolean>
   return false;
 }
 
+/**
+ * Matches location documents owned by `repoName`, plus documents indexed before chunks were tagged
+ * with a repository (those can only belong to the repository the index was created for).
+ */
+function repoOwnershipFilter(repoName: string): QueryDslQueryContainer {
+  return {
+    bool: {
+      should: [{ term: { repo_name: repoName } }, { bool: { must_not: { exists: { field: 'repo_name' } } } }],
+      minimum_should_match: 1,
+    },
+  };
+}
+
+/** Filters narrowing a semantic or kNN search of chunk documents. */
+export interface SearchFilterOptions {
+  /** Only chunks of this repository. */
+  repoName?: string;
+  /**
+   * Only chunks that call or instantiate this symbol. `Type.name` (e.g. `Greeter.Greet`) only matches
+   * method calls whose receiver type was resolved to `Type`.
+   */
+  references?: string;
+  /** Only chunks of this language. */
+  language?: string;
+  /** Only chunks defining a symbol of this kind (`symbol_kind`). */
+  symbolKind?: SymbolKind;
+  /** Only chunks of files declaring this package (`package_name`). */
+  packageName?: string;
+  /** Only these chunk documents, e.g. those found by {@link getChunkIdsForPathPrefix}. */
+  chunkIds?: string[];
+}
+
+/** Largest number of chunk documents a search can be narrowed to by {@link getChunkIdsForPathPrefix}. */
+export const MAX_PATH_PREFIX_CHUNKS = 10000;
+
+/** Builds the filter of a chunk search, or undefined when nothing is filtered. */
+function buildSearchFilter(
+  options?: SearchFilterOptions
+): QueryDslQueryContainer | QueryDslQueryContainer[] | undefined {
+  const filters: QueryDslQueryContainer[] = [];
+  if (options?.repoName) {
+    filters.push({ term: { repo_name: options.repoName } });
+  }
+  if (options?.language) {
+    filters.push({ term: { language: options.language } });
+  }
+  if (options?.symbolKind) {
+    filters.push({ term: { symbol_kind: options.symbolKind } });
+  }
+  if (options?.packageName) {
+    filters.push({ term: { package_name: options.packageName } });
+  }
+  if (options?.chunkIds) {
+    filters.push({ ids: { values: options.chunkIds } });
+  }
+  if (options?.references) {
+    const separator = options.references.lastIndexOf('.');
+    const terms: QueryDslQueryContainer[] =
+      separator > 0
+        ? [
+            { term: { 'references.receiver_type': options.references.slice(0, separator) } },
+            { term: { 'references.name': options.references.slice(separator + 1) } },
+          ]
+        : [{ term: { 'references.name': options.references } }];
+    filters.push({ nested: { path: 'references', query: { bool: { filter: terms } } } });
+  }
+  if (filters.length === 0) {
+    return undefined;
+  }
+  return filters.length === 1 ? filters[0] : filters;
+}
+
 /**
  * Performs a semantic search on the code chunks in the index.
  *
  * @param query The natural language query to search for.
  * @param index The name of the Elasticsearch index to search.
  * @param size The number of results to return (default: 10).
+ * @param options.repoName When set, only chunks of this repository are returned.
+ * @param options.references When set, only chunks referencing this symbol are returned.
+ * @param options.language When set, only chunks of this language are returned.
+ * @param options.symbolKind When set, only chunks defining a symbol of this kind are returned.
+ * @param options.packageName When set, only chunks of files declaring this package are returned.
+ * @param options.chunkIds When set, only these chunk documents are returned.
  * @returns A promise that resolves to an array of search results.
  */
-export async function searchCodeChunks(query: string, index: string, size: number = 10): Promise<SearchResult[]> {
+export async function searchCodeChunks(
+  query: string,
+  index: string,
+  size: number = 10,
+  options?: SearchFilterOptions
+): Promise<SearchResult[]> {
   const indexName = index;
+  const semanticQuery: QueryDslQueryContainer = {
+    semantic: {
+      field: 'semantic_text',
+      query: query,
+    },
+  };
+  const filter = buildSearchFilter(options);
   const response = await getClient().search<CodeChunk>({
     index: indexName,
     size,
-    query: {
-      semantic: {
-        field: 'semantic_text',
-        query: query,
-      },
-    },
+    query: filter ? { bool: { must: semanticQuery, filter } } : semanticQuery,
   });
+  return toSearchResults(response);
+}
+
+/** Where the query vector of a kNN search comes from, see {@link searchCodeChunksKnn}. */
+export interface KnnQueryOptions {
+  k: number;
+  modelId?: string;
+  queryVector?: number[];
+  numCandidates?: number;
+}
+
+/** Builds the `knn` section of a search on `code_vector`. */
+function buildKnnSearch(
+  query: string,
+  options: KnnQueryOptions,
+  filter: QueryDslQueryContainer | QueryDslQueryContainer[] | undefined
+): KnnSearch {
+  if (!options.queryVector && !options.modelId) {
+    throw new Error('A kNN search requires either options.queryVector or options.modelId.');
+  }
+  return {
+    field: 'code_vector',
+    k: options.k,
+    num_candidates: options.numCandidates ?? Math.max(100, options.k * 10),
+    ...(options.queryVector
+      ? { query_vector: options.queryVector }
+      : {
+          query_vector_builder: {
+            text_embedding: {
+              model_id: options.modelId,
+              model_text: query,
+            },
+          },
+        }),
+    ...(filter ? { filter } : {}),
+  };
+}
+
+function toSearchResults(response: SearchResponse<CodeChunk>): SearchResult[] {
   return response.hits.hits
     .filter((hit): hit is SearchHit<CodeChunk> & { _id: string } => typeof hit._id === 'string' && hit._id.length > 0)
-    .map((hit) => ({
-      id: hit._id,
-      ...(hit._source as CodeChunk),
-      score: hit._score ?? 0,
-    }));
+    .map((hit) => {
+      const { content_gz, ...source } = hit._source as CodeChunk;
+      return {
+        id: hit._id,
+        ...source,
+        // Chunks stored with `storeCompressed` keep only an excerpt in `content`, and chunks stored
+        // without `storeContent` have none.
+        ...(source.content !== undefined
+          ? { content: inflateContent({ content: source.content, content_gz }) }
+          : {}),
+        score: hit._score ?? 0,
+      };
+    });
+}
+
+/**
+ * Performs a kNN search on the dense `code_vector` field.
+ *
+ * The query vector is either passed in as `queryVector` (embedded by the same provider that populated
+ * `code_vector`), or built by Elasticsearch from `modelId`, which must be the text embedding model used
+ * by the ingest pipeline that populated `code_vector` at index time.
+ *
+ * @param query The natural language query to search for.
+ * @param index The name of the Elasticsearch index to search.
+ * @param options.k The number of nearest neighbours to return.
+ * @param options.modelId The deployed text embedding model id (ignored when `queryVector` is set).
+ * @param options.queryVector A precomputed embedding of `query`.
+ * @param options.repoName When set, only chunks of this repository are returned.
+ * @param options.references When set, only chunks referencing this symbol are returned.
+ * @param options.language When set, only chunks of this language are returned.
+ * @param options.symbolKind When set, only chunks defining a symbol of this kind are returned.
+ * @param options.packageName When set, only chunks of files declaring this package are returned.
+ * @param options.chunkIds When set, only these chunk documents are returned.
+ * @returns A promise that resolves to an array of search results.
+ */
+export async function searchCodeChunksKnn(
+  query: string,
+  index: string,
+  options: SearchFilterOptions & KnnQueryOptions
+): Promise<SearchResult[]> {
+  const knn = buildKnnSearch(query, options, buildSearchFilter(options));
+  const response = await getClient().search<CodeChunk>({
+    index,
+    size: options.k,
+    knn,
+    _source: { excludes: ['code_vector', 'semantic_text'] },
+  });
+  return toSearchResults(response);
+}
+
+/** Fields of the lexical part of a hybrid search. Names are boosted so an exact identifier ranks first. */
+const HYBRID_LEXICAL_FIELDS = ['content', 'symbol_fqn.text^3', 'symbol_name.text^3'];
+
+/**
+ * Performs a hybrid search: a BM25 `multi_match` on the code and symbol names, and a kNN search on
+ * `code_vector`, merged by {@link fuseSearchResults}.
+ *
+ * Both searches run in one `msearch` request and return up to `rankWindowSize` hits each (default:
+ * five times `k`, at least 50), so chunks ranked lower by one search can still be lifted by the other.
+ *
+ * @param query The query, matched as words against the code and embedded for the kNN search.
+ * @param index The name of the Elasticsearch index to search.
+ * @param options.k The number of fused results to return.
+ * @param options.modelId The deployed text embedding model id (ignored when `queryVector` is set).
+ * @param options.queryVector A precomputed embedding of `query`.
+ * @param options.fusion How the two result lists are merged, with the weights of each list.
+ * @returns A promise that resolves to an array of search results, with fused scores.
+ */
+export async function searchCodeChunksHybrid(
+  query: string,
+  index: string,
+  options: SearchFilterOptions & KnnQueryOptions & SearchFusionOptions & { rankWindowSize?: number }
+): Promise<SearchResult[]> {
+  const rankWindowSize = options.rankWindowSize ?? Math.max(50, options.k * 5);
+  const filter = buildSearchFilter(options);
+  const lexicalQuery: QueryDslQueryContainer = {
+    multi_match: { query, fields: HYBRID_LEXICAL_FIELDS },
+  };
+  const source = { excludes: ['code_vector', 'semantic_text'] };
+  const response = await getClient().msearch<CodeChunk>({
+    searches: [
+      { index },
+      {
+        size: rankWindowSize,
+        query: filter ? { bool: { must: lexicalQuery, filter } } : lexicalQuery,
+        _source: source,
+      },
+      { index },
+      { size: rankWindowSize, knn: buildKnnSearch(query, { ...options, k: rankWindowSize }, filter), _source: source },
+    ],
+  });
+
+  const [lexical, vector] = response.responses.map((item, position) => {
+    if ('error' in item) {
+      const name = position === 0 ? 'lexical' : 'kNN';
+      throw new Error(`Hybrid search failed in the ${name} search: ${item.error.reason ?? item.error.type}`);
+    }
+    return toSearchResults(item);
+  });
+  return fuseSearchResults(lexical, vector, options).slice(0, options.k);
 }
 
 export type ChunkLocationSummary = {
   filePath: string;
   startLine: number;
   endLine: number;
+  /** Git blob hash of the file the location was indexed from. */
+  git_file_hash?: string;
 };
 
+/** A sample of the locations of a chunk document, with the number of locations it has in total. */
+export type ChunkLocations = {
+  total: number;
+  locations: ChunkLocationSummary[];
+};
+
+/**
+ * Returns the ids of the chunk documents with a location whose file path starts with `pathPrefix`,
+ * so a search can be narrowed to a directory. Chunk documents do not store file paths, which only
+ * live in `<index>_locations`.
+ *
+ * @throws When more than {@link MAX_PATH_PREFIX_CHUNKS} chunk documents match.
+ */
+export async function getChunkIdsForPathPrefix(
+  index: string,
+  pathPrefix: string,
+  options?: { repoName?: string }
+): Promise<string[]> {
+  const client = getClient();
+  const locationsIndexName = getLocationsIndexName(index);
+  const exists = await client.indices.exists({ index: locationsIndexName });
+  if (!exists) {
+    return [];
+  }
+
+  const filter: QueryDslQueryContainer[] = [{ prefix: { filePath: pathPrefix } }];
+  if (options?.repoName) {
+    filter.push({ term: { repo_name: options.repoName } });
+  }
+  const response = await client.search({
+    index: locationsIndexName,
+    size: 0,
+    query: { bool: { filter } },
+    aggs: { chunk_ids: { terms: { field: 'chunk_id', size: MAX_PATH_PREFIX_CHUNKS } } },
+  });
+
+  const aggregation = (
+    response.aggregations as unknown as
+      | { chunk_ids?: { sum_other_doc_count?: number; buckets?: Array<{ key?: unknown }> } }
+      | undefined
+  )?.chunk_ids;
+  if ((aggregation?.sum_other_doc_count ?? 0) > 0) {
+    throw new Error(
+      `Path prefix "${pathPrefix}" matches more than ${MAX_PATH_PREFIX_CHUNKS} chunks. Use a longer prefix.`
+    );
+  }
+  return (aggregation?.buckets ?? [])
+    .map((bucket) => bucket.key)
+    .filter((key): key is string => typeof key === 'string');
+}
+
+/**
+ * Returns a sample of the locations of each chunk document, sorted by file path.
+ *
+ * @param options.filePathPrefix When set, only locations under this path are listed and counted.
+ */
 export async function getLocationsForChunkIds(
   chunkIds: string[],
-  options: { index: string; perChunkLimit?: number }
-): Promise<Record<string, ChunkLocationSummary[]>> {
+  options: { index: string; perChunkLimit?: number; filePathPrefix?: string }
+): Promise<Record<string, ChunkLocations>> {
   const indexName = options.index;
   const locationsIndexName = getLocationsIndexName(indexName);
   const perChunkLimit = Math.max(1, Math.min(50, Math.floor(options?.perChunkLimit ?? 5)));
@@ -879,13 +2399,12 @@ export async function getLocationsForChunkIds(
     return {};
   }
 
+  const chunkIdQuery: QueryDslQueryContainer = { terms: { chunk_id: uniqueChunkIds } };
   const response = await client.search({
     index: locationsIndexName,
-    query: {
-      terms: {
-        chunk_id: uniqueChunkIds,
-      },
-    },
+    query: options.filePathPrefix
+      ? { bool: { filter: [chunkIdQuery, { prefix: { filePath: options.filePathPrefix } }] } }
+      : chunkIdQuery,
     size: 0,
     aggs: {
       by_chunk: {
@@ -897,7 +2416,7 @@ export async function getLocationsForChunkIds(
           locations: {
             top_hits: {
               size: perChunkLimit,
-              _source: ['filePath', 'startLine', 'endLine'],
+              _source: ['filePath', 'startLine', 'endLine', 'git_file_hash'],
               sort: [{ filePath: { order: 'asc' } }, { startLine: { order: 'asc' } }],
             },
           },
@@ -908,11 +2427,17 @@ export async function getLocationsForChunkIds(
 
   const buckets = (
     response.aggregations as unknown as {
-      by_chunk?: { buckets?: Array<{ key?: unknown; locations?: { hits?: { hits?: Array<{ _source?: unknown }> } } }> };
+      by_chunk?: {
+        buckets?: Array<{
+          key?: unknown;
+          doc_count?: number;
+          locations?: { hits?: { hits?: Array<{ _source?: unknown }> } };
+        }>;
+      };
     }
   )?.by_chunk?.buckets;
 
-  const result: Record<string, ChunkLocationSummary[]> = {};
+  const result: Record<string, ChunkLocations> = {};
   for (const bucket of buckets ?? []) {
     const chunkId = bucket.key;
     if (typeof chunkId !== 'string') {
@@ -921,19 +2446,364 @@ export async function getLocationsForChunkIds(
     const hits = bucket.locations?.hits?.hits ?? [];
     const locations: ChunkLocationSummary[] = [];
     for (const h of hits) {
-      const s = h._source as { filePath?: unknown; startLine?: unknown; endLine?: unknown } | undefined;
+      const s = h._source as
+        | { filePath?: unknown; startLine?: unknown; endLine?: unknown; git_file_hash?: unknown }
+        | undefined;
       if (!s) continue;
       if (typeof s.filePath !== 'string') continue;
       if (typeof s.startLine !== 'number') continue;
       if (typeof s.endLine !== 'number') continue;
-      locations.push({ filePath: s.filePath, startLine: s.startLine, endLine: s.endLine });
+      locations.push({
+        filePath: s.filePath,
+        startLine: s.startLine,
+        endLine: s.endLine,
+        ...(typeof s.git_file_hash === 'string' ? { git_file_hash: s.git_file_hash } : {}),
+      });
     }
-    result[chunkId] = locations;
+    result[chunkId] = { total: bucket.doc_count ?? locations.length, locations };
   }
 
   return result;
 }
 
+/**
+ * Returns every distinct file path that currently has indexed locations.
+ *
+ * Uses a composite aggregation over `<index>_locations` so memory stays bounded for large repos.
+ *
+ * @param index The base name of the Elasticsearch index.
+ * @param options.branch When set, only locations for this branch are considered.
+ * @param options.repoName When set, only locations of this repository are considered.
+ * @returns A promise that resolves to the set of indexed file paths.
+ */
+export async function getIndexedFilePaths(
+  index: string,
+  options?: { branch?: string; repoName?: string; pageSize?: number }
+): Promise<Set<string>> {
+  const client = getClient();
+  const locationsIndexName = getLocationsIndexName(index);
+  const filePaths = new Set<string>();
+
+  const exists = await client.indices.exists({ index: locationsIndexName });
+  if (!exists) {
+    return filePaths;
+  }
+
+  const pageSize = Math.max(1, Math.min(10000, Math.floor(options?.pageSize ?? 1000)));
+  const filters: QueryDslQueryContainer[] = [];
+  if (options?.branch) {
+    filters.push({ term: { git_branch: options.branch } });
+  }
+  if (options?.repoName) {
+    filters.push(repoOwnershipFilter(options.repoName));
+  }
+  const query: QueryDslQueryContainer = filters.length > 0 ? { bool: { filter: filters } } : { match_all: {} };
+
+  let after: Record<string, FieldValue> | undefined;
+  while (true) {
+    const response = await client.search({
+      index: locationsIndexName,
+      size: 0,
+      query,
+      aggs: {
+        file_paths: {
+          composite: {
+            size: pageSize,
+            sources: [{ filePath: { terms: { field: 'filePath' } } }],
+            ...(after ? { after } : {}),
+          },
+        },
+      },
+    });
+
+    const aggregation = (
+      response.aggregations as unknown as {
+        file_paths?: { buckets?: Array<{ key?: { filePath?: unknown } }>; after_key?: Record<string, FieldValue> };
+      }
+    )?.file_paths;
+
+    const buckets = aggregation?.buckets ?? [];
+    for (const bucket of buckets) {
+      const filePath = bucket.key?.filePath;
+      if (typeof filePath === 'string' && filePath.length > 0) {
+        filePaths.add(filePath);
+      }
+    }
+
+    if (buckets.length < pageSize || !aggregation?.after_key) {
+      break;
+    }
+    after = aggregation.after_key;
+  }
+
+  return filePaths;
+}
+
+/** Locations and content hashes indexed for a file, see {@link getIndexedFileSummaries}. */
+export interface IndexedFileSummary {
+  documentCount: number;
+  /** Distinct `git_file_hash` values of the file's locations; more than one means stale locations. */
+  gitFileHashes: string[];
+}
+
+/**
+ * Returns the number of locations and the `git_file_hash` values of every indexed file.
+ *
+ * Pages through a composite aggregation over `<index>_locations`, like {@link getIndexedFilePaths}.
+ *
+ * @param index The base name of the Elasticsearch index.
+ * @param options.branch When set, only locations for this branch are considered.
+ * @param options.repoName When set, only locations of this repository are considered.
+ * @returns A promise that resolves to the summary of each indexed file, by path.
+ */
+export async function getIndexedFileSummaries(
+  index: string,
+  options?: { branch?: string; repoName?: string; pageSize?: number }
+): Promise<Map<string, IndexedFileSummary>> {
+  const client = getClient();
+  const locationsIndexName = getLocationsIndexName(index);
+  const summaries = new Map<string, IndexedFileSummary>();
+
+  const exists = await client.indices.exists({ index: locationsIndexName });
+  if (!exists) {
+    return summaries;
+  }
+
+  const pageSize = Math.max(1, Math.min(10000, Math.floor(options?.pageSize ?? 1000)));
+  const filters: QueryDslQueryContainer[] = [];
+  if (options?.branch) {
+    filters.push({ term: { git_branch: options.branch } });
+  }
+  if (options?.repoName) {
+    filters.push(repoOwnershipFilter(options.repoName));
+  }
+  const query: QueryDslQueryContainer = filters.length > 0 ? { bool: { filter: filters } } : { match_all: {} };
+
+  let after: Record<string, FieldValue> | undefined;
+  while (true) {
+    const response = await client.search({
+      index: locationsIndexName,
+      size: 0,
+      query,
+      aggs: {
+        file_paths: {
+          composite: {
+            size: pageSize,
+            sources: [{ filePath: { terms: { field: 'filePath' } } }],
+            ...(after ? { after } : {}),
+          },
+          aggs: { git_file_hashes: { terms: { field: 'git_file_hash', size: 10 } } },
+        },
+      },
+    });
+
+    const aggregation = (
+      response.aggregations as unknown as {
+        file_paths?: {
+          buckets?: Array<{
+            key?: { filePath?: unknown };
+            doc_count?: number;
+            git_file_hashes?: { buckets?: Array<{ key?: unknown }> };
+          }>;
+          after_key?: Record<string, FieldValue>;
+        };
+      }
+    )?.file_paths;
+
+    const buckets = aggregation?.buckets ?? [];
+    for (const bucket of buckets) {
+      const filePath = bucket.key?.filePath;
+      if (typeof filePath === 'string' && filePath.length > 0) {
+        summaries.set(filePath, {
+          documentCount: bucket.doc_count ?? 0,
+          gitFileHashes: (bucket.git_file_hashes?.buckets ?? [])
+            .map((hashBucket) => hashBucket.key)
+            .filter((key): key is string => typeof key === 'string'),
+        });
+      }
+    }
+
+    if (buckets.length < pageSize || !aggregation?.after_key) {
+      break;
+    }
+    after = aggregation.after_key;
+  }
+
+  return summaries;
+}
+
+/** Chunk size ranges of {@link IndexStats.chunkSizes}, in tokens. */
+export const CHUNK_SIZE_RANGES = [0, 64, 128, 256, 512, 1024, 2048];
+
+/**
+ * Each chunk's `token_count`, or an estimate from the length of its content for chunks indexed
+ * without a tokenizer. The estimate of a chunk stored with `--store-compressed` only sees its excerpt.
+ */
+const TOKEN_ESTIMATE_SCRIPT = `
+  def tokens = params._source.token_count;
+  if (tokens != null) { emit(((Number) tokens).longValue()); return; }
+  def content = params._source.content;
+  emit(content == null ? 0L : (long) Math.ceil(content.length() / params.chars_per_token));
+`;
+
+function totalHits(total: SearchTotalHits | number | undefined): number {
+  return typeof total === 'number' ? total : (total?.value ?? 0);
+}
+
+/** Count of the documents with one value of a field, see {@link IndexStats}. */
+export interface IndexStatsBucket {
+  key: string;
+  count: number;
+}
+
+/** What an index holds, see {@link getIndexStats}. */
+export interface IndexStats {
+  index: string;
+  /** Chunk documents; identical chunks share one document. */
+  chunks: number;
+  /** Location documents, one per place a chunk occurs. */
+  locations: number;
+  /** Distinct files with locations (approximate above 40,000). */
+  files: number;
+  byLanguage: IndexStatsBucket[];
+  /** Chunks without a symbol have the kind `none`. */
+  bySymbolKind: IndexStatsBucket[];
+  /** Chunks sized by an estimate from their length because they have no `token_count`. */
+  estimatedSizes: number;
+  largestChunks: Array<{
+    chunkId: string;
+    tokens: number;
+    language?: string;
+    symbolName?: string;
+    /** First location by path, and how many the chunk has. */
+    location?: ChunkLocationSummary;
+    locations: number;
+  }>;
+  /** Files with the most locations, which point at files chunked into too many pieces. */
+  filesWithMostChunks: Array<{ filePath: string; chunks: number }>;
+  /** Chunks per size range, from `from` tokens up to but excluding `to`. */
+  chunkSizes: Array<{ from: number; to: number | null; count: number }>;
+}
+
+/**
+ * Aggregates what an index holds: chunks by language and symbol kind, the largest chunks, the files
+ * with the most chunks, and the distribution of chunk sizes. Sizes are read from the `_source` of
+ * every chunk, so on large indexes this takes a while.
+ *
+ * @param index The base name of the Elasticsearch index.
+ * @param options.repoName When set, only chunks and locations of this repository are counted.
+ * @param options.top How many of the largest chunks and of the files with the most chunks to list.
+ */
+export async function getIndexStats(index: string, options?: { repoName?: string; top?: number }): Promise<IndexStats> {
+  const client = getClient();
+  const locationsIndexName = getLocationsIndexName(index);
+  const top = Math.max(1, Math.min(1000, Math.floor(options?.top ?? 10)));
+
+  const chunkQuery: QueryDslQueryContainer = options?.repoName
+    ? { bool: { filter: [{ term: { repo_name: options.repoName } }] } }
+    : { match_all: {} };
+  const chunkResponse = await client.search({
+    index,
+    size: top,
+    track_total_hits: true,
+    query: chunkQuery,
+    runtime_mappings: {
+      token_estimate: {
+        type: 'long',
+        script: { source: TOKEN_ESTIMATE_SCRIPT, params: { chars_per_token: CHARS_PER_TOKEN } },
+      },
+    },
+    fields: ['token_estimate'],
+    _source: ['language', 'symbol_name', 'symbol_fqn'],
+    sort: [{ token_estimate: { order: 'desc' } }],
+    aggs: {
+      by_language: { terms: { field: 'language', size: 100 } },
+      by_symbol_kind: { terms: { field: 'symbol_kind', size: 100, missing: 'none' } },
+      estimated: { missing: { field: 'token_count' } },
+      sizes: {
+        range: {
+          field: 'token_estimate',
+          ranges: CHUNK_SIZE_RANGES.map((from, i) => ({ from, to: CHUNK_SIZE_RANGES[i + 1] })),
+        },
+      },
+    },
+  });
+
+  const chunkAggregations = chunkResponse.aggregations as unknown as
+    | {
+        by_language?: { buckets?: Array<{ key?: unknown; doc_count?: number }> };
+        by_symbol_kind?: { buckets?: Array<{ key?: unknown; doc_count?: number }> };
+        estimated?: { doc_count?: number };
+        sizes?: { buckets?: Array<{ from?: number; to?: number; doc_count?: number }> };
+      }
+    | undefined;
+  const toBuckets = (buckets?: Array<{ key?: unknown; doc_count?: number }>): IndexStatsBucket[] =>
+    (buckets ?? [])
+      .filter((bucket) => typeof bucket.key === 'string')
+      .map((bucket) => ({ key: bucket.key as string, count: bucket.doc_count ?? 0 }));
+
+  const largestHits = chunkResponse.hits.hits.filter((hit) => typeof hit._id === 'string');
+  const chunkLocations = await getLocationsForChunkIds(
+    largestHits.map((hit) => hit._id as string),
+    { index, perChunkLimit: 1 }
+  );
+
+  let locations = 0;
+  let files = 0;
+  let filesWithMostChunks: IndexStats['filesWithMostChunks'] = [];
+  if (await client.indices.exists({ index: locationsIndexName })) {
+    const locationResponse = await client.search({
+      index: locationsIndexName,
+      size: 0,
+      track_total_hits: true,
+      query: options?.repoName ? { bool: { filter: [repoOwnershipFilter(options.repoName)] } } : { match_all: {} },
+      aggs: {
+        files: { cardinality: { field: 'filePath', precision_threshold: 40000 } },
+        top_files: { terms: { field: 'filePath', size: top } },
+      },
+    });
+    const locationAggregations = locationResponse.aggregations as unknown as
+      | {
+          files?: { value?: number };
+          top_files?: { buckets?: Array<{ key?: unknown; doc_count?: number }> };
+        }
+      | undefined;
+    locations = totalHits(locationResponse.hits.total);
+    files = locationAggregations?.files?.value ?? 0;
+    filesWithMostChunks = toBuckets(locationAggregations?.top_files?.buckets).map(({ key, count }) => ({
+      filePath: key,
+      chunks: count,
+    }));
+  }
+
+  return {
+    index,
+    chunks: totalHits(chunkResponse.hits.total),
+    locations,
+    files,
+    byLanguage: toBuckets(chunkAggregations?.by_language?.buckets),
+    bySymbolKind: toBuckets(chunkAggregations?.by_symbol_kind?.buckets),
+    estimatedSizes: chunkAggregations?.estimated?.doc_count ?? 0,
+    largestChunks: largestHits.map((hit) => {
+      const source = (hit._source ?? {}) as { language?: string; symbol_name?: string; symbol_fqn?: string };
+      const found = chunkLocations[hit._id as string];
+      return {
+        chunkId: hit._id as string,
+        tokens: Number((hit.fields as { token_estimate?: unknown[] } | undefined)?.token_estimate?.[0] ?? 0),
+        ...(source.language ? { language: source.language } : {}),
+        ...(source.symbol_fqn || source.symbol_name ? { symbolName: source.symbol_fqn || source.symbol_name } : {}),
+        ...(found?.locations[0] ? { location: found.locations[0] } : {}),
+        locations: found?.total ?? 0,
+      };
+    }),
+    chunkSizes: (chunkAggregations?.sizes?.buckets ?? []).map((bucket) => ({
+      from: bucket.from ?? 0,
+      to: bucket.to ?? null,
+      count: bucket.doc_count ?? 0,
+    })),
+  };
+}
+
 /**
  * Aggregates symbols by file path.
  *
@@ -1059,7 +2929,7 @@ export async function aggregateBySymbols(
 }
 
 /**
- * Deletes the Elasticsearch index.
+ * Deletes the Elasticsearch index, or every generation behind it when it is an alias.
  *
  * @param index The base name of the Elasticsearch index.
  * @returns A promise that resolves when the index is deleted or if it does not exist.
@@ -1069,7 +2939,7 @@ export async function deleteIndex(index: string): Promise<void> {
   const indexExists = await getClient().indices.exists({ index: indexName });
   if (indexExists) {
     logger.info(`Deleting index "${indexName}"...`);
-    await getClient().indices.delete({ index: indexName });
+    await getClient().indices.delete({ index: await resolveConcreteIndices(indexName) });
   } else {
     logger.info(`Index "${indexName}" does not exist, skipping deletion.`);
   }
@@ -1086,7 +2956,7 @@ export async function deleteLocationsIndex(index: string): Promise<void> {
   const indexExists = await getClient().indices.exists({ index: locationsIndexName });
   if (indexExists) {
     logger.info(`Deleting index "${locationsIndexName}"...`);
-    await getClient().indices.delete({ index: locationsIndexName });
+    await getClient().indices.delete({ index: await resolveConcreteIndices(locationsIndexName) });
   } else {
     logger.info(`Index "${locationsIndexName}" does not exist, skipping deletion.`);
   }
@@ -1108,7 +2978,10 @@ function chunkArray<T>(items: T[], chunkSize: number): T[][] {
 async function deleteLocationsByFilePathsAndCollectChunkIds(
   filePaths: string[],
   indexName: string,
-  deletePageSizeOverride?: number
+  deletePageSizeOverride?: number,
+  repoName?: string,
+  indexedBefore?: number,
+  keptLocationIds: string[] = []
 ): Promise<Set<string>> {
   const client = getClient();
   const locationsIndexName = getLocationsIndexName(indexName);
@@ -1143,6 +3016,14 @@ async function deleteLocationsByFilePathsAndCollectChunkIds(
       filePath: values,
     },
   }));
+  const filter: QueryDslQueryContainer[] = [];
+  if (repoName) {
+    filter.push(repoOwnershipFilter(repoName));
+  }
+  if (indexedBefore !== undefined) {
+    // Locations written by the current run have a newer `updated_at` and are kept.
+    filter.push({ range: { updated_at: { lt: new Date(indexedBefore).toISOString() } } });
+  }
 
   const startedAt = Date.now();
   let pages = 0;
@@ -1158,6 +3039,8 @@ async function deleteLocationsByFilePathsAndCollectChunkIds(
           bool: {
             should,
             minimum_should_match: 1,
+            ...(filter.length > 0 ? { filter } : {}),
+            ...(keptLocationIds.length > 0 ? { must_not: [{ ids: { values: keptLocationIds } }] } : {}),
           },
         },
         sort: ['_shard_doc'],
@@ -1302,18 +3185,33 @@ async function deleteOrphanChunkDocuments(chunkIds: string[], indexName: string)
   }
 }
 
+/**
+ * Deletes the chunk documents among `chunkIds` that no location references, e.g. the ones overwritten
+ * symbol locations referenced before. Another worker may still be writing a location that references
+ * one of them, so this runs once every worker is done, after refreshing the locations index.
+ */
+export async function deleteUnreferencedChunks(chunkIds: string[], index: string): Promise<void> {
+  if (chunkIds.length === 0) {
+    return;
+  }
+  await getClient().indices.refresh({ index: getLocationsIndexName(index) });
+  await deleteOrphanChunkDocuments(chunkIds, index);
+}
+
 /**
  * Deletes documents from the Elasticsearch index by their file paths.
  *
  * @param filePaths An array of file paths to delete documents for.
  * @param index The base name of the Elasticsearch index.
  * @param options Optional settings for deletion, such as pagination size.
+ * @param options.repoName When set, only locations of this repository are deleted, so repositories
+ * sharing an index can contain the same relative paths.
  * @returns A promise that resolves when the documents are deleted.
  */
 export async function deleteDocumentsByFilePaths(
   filePaths: string[],
   index: string,
-  options?: { deleteDocumentsPageSize?: number }
+  options?: { deleteDocumentsPageSize?: number; repoName?: string }
 ): Promise<void> {
   const indexName = index;
   // Locations are authoritative in `<index>_locations`. The primary chunk documents do not store
@@ -1328,11 +3226,47 @@ export async function deleteDocumentsByFilePaths(
   const affectedChunkIds = await deleteLocationsByFilePathsAndCollectChunkIds(
     uniqueFilePaths,
     indexName,
-    options?.deleteDocumentsPageSize
+    options?.deleteDocumentsPageSize,
+    options?.repoName
   );
   await deleteOrphanChunkDocuments(Array.from(affectedChunkIds), indexName);
 }
 
+/**
+ * Deletes the locations of re-indexed files that were not written again, together with chunk
+ * documents left without a location.
+ *
+ * Re-indexing a file overwrites the locations it still has, since location ids are derived from the
+ * symbol, or from the chunk, path and line range, and chunk documents are content-addressed. Locations of symbols the
+ * file lost keep their old `updated_at` and are removed here, after the new chunks are indexed.
+ *
+ * @param filePaths Re-indexed files to prune.
+ * @param index The base name of the Elasticsearch index.
+ * @param options.indexedBefore Epoch ms the re-index started at. Older locations of the files are stale.
+ * @param options.repoName When set, only locations of this repository are deleted.
+ * @param options.keptLocationIds Locations the files still have but that were not written again, see
+ *   `--smart-incremental`. They are not deleted.
+ */
+export async function deleteStaleLocations(
+  filePaths: string[],
+  index: string,
+  options: { indexedBefore: number; deleteDocumentsPageSize?: number; repoName?: string; keptLocationIds?: string[] }
+): Promise<void> {
+  const uniqueFilePaths = Array.from(new Set(filePaths)).filter((p) => p.length > 0);
+  if (uniqueFilePaths.length === 0) {
+    return;
+  }
+  const affectedChunkIds = await deleteLocationsByFilePathsAndCollectChunkIds(
+    uniqueFilePaths,
+    index,
+    options.deleteDocumentsPageSize,
+    options.repoName,
+    options.indexedBefore,
+    options.keptLocationIds
+  );
+  await deleteOrphanChunkDocuments(Array.from(affectedChunkIds), index);
+}
+
 /**
  * Deletes documents from the Elasticsearch index by a single file path.
  *
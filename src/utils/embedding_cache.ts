@@ -0,0 +1,150 @@
+import fs from 'fs';
+import path from 'path';
+import { createHash } from 'crypto';
+import Database from 'better-sqlite3';
+import { appConfig } from '../config';
+import { normalizeChunkContent } from './elasticsearch';
+import { EmbeddingProvider } from './embedding_provider';
+
+/** Content hashes looked up per query, below SQLite's limit on bound parameters. */
+const LOOKUP_BATCH_SIZE = 500;
+
+/** File name of the cache in the queue base directory or `--embed-cache-dir`. */
+export const EMBEDDING_CACHE_FILE_NAME = 'embedding_cache.db';
+
+/** Default `--embedding-cache` path, shared by every repository since vectors only depend on content. */
+export function getDefaultEmbeddingCachePath(): string {
+  return path.join(appConfig.queueBaseDir, EMBEDDING_CACHE_FILE_NAME);
+}
+
+/**
+ * Identifies the vectors of an embedding model: the provider, the model (or the endpoint of an unnamed
+ * one) and the dimensions. Vectors cached under another key are never returned.
+ */
+export function getEmbeddingModelKey(providerName: string, provider: EmbeddingProvider, url?: string): string {
+  return [providerName, provider.model ?? url ?? '', String(provider.dimensions)].join(':');
+}
+
+/** Hashes the text of a chunk the way chunk document ids normalize it. */
+export function hashEmbeddingText(text: string): string {
+  return createHash('sha256').update(normalizeChunkContent(text)).digest('hex');
+}
+
+/** Hits and misses of an {@link EmbeddingCache} since it was opened, counted per text. */
+export interface EmbeddingCacheStats {
+  hits: number;
+  misses: number;
+}
+
+/**
+ * Vectors already paid for, in a SQLite file keyed by model and content hash, so chunks whose content
+ * did not change are not embedded again by later runs. Vectors are stored as 32-bit floats, the
+ * precision Elasticsearch keeps for `dense_vector` fields.
+ */
+export class EmbeddingCache {
+  private readonly db: Database.Database;
+  private hits = 0;
+  private misses = 0;
+
+  constructor(readonly dbPath: string) {
+    fs.mkdirSync(path.dirname(dbPath), { recursive: true });
+    this.db = new Database(dbPath);
+    this.db.exec('PRAGMA journal_mode = WAL;');
+    this.db.exec('PRAGMA synchronous = NORMAL;');
+    this.db.exec(`
+      CREATE TABLE IF NOT EXISTS embeddings (
+        model_key TEXT NOT NULL,
+        content_hash TEXT NOT NULL,
+        vector BLOB NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (model_key, content_hash)
+      );
+    `);
+  }
+
+  /** Returns the cached vectors of `hashes` under `modelKey`, counting each hash as a hit or a miss. */
+  getMany(modelKey: string, hashes: string[]): Map<string, number[]> {
+    const vectors = new Map<string, number[]>();
+    const unique = Array.from(new Set(hashes));
+    for (let i = 0; i < unique.length; i += LOOKUP_BATCH_SIZE) {
+      const batch = unique.slice(i, i + LOOKUP_BATCH_SIZE);
+      const rows = this.db
+        .prepare(
+          `SELECT content_hash, vector FROM embeddings
+           WHERE model_key = ? AND content_hash IN (${batch.map(() => '?').join(', ')})`
+        )
+        .all(modelKey, ...batch) as { content_hash: string; vector: Buffer }[];
+      for (const row of rows) {
+        const floats = new Float32Array(row.vector.buffer, row.vector.byteOffset, row.vector.byteLength / 4);
+        vectors.set(row.content_hash, Array.from(floats));
+      }
+    }
+    for (const hash of hashes) {
+      if (vectors.has(hash)) {
+        this.hits++;
+      } else {
+        this.misses++;
+      }
+    }
+    return vectors;
+  }
+
+  /** Stores vectors by content hash under `modelKey`, in one transaction. */
+  setMany(modelKey: string, vectors: Map<string, number[]>): void {
+    const insert = this.db.prepare(
+      'INSERT OR REPLACE INTO embeddings (model_key, content_hash, vector) VALUES (?, ?, ?)'
+    );
+    this.db.transaction(() => {
+      for (const [hash, vector] of vectors) {
+        insert.run(modelKey, hash, Buffer.from(new Float32Array(vector).buffer));
+      }
+    })();
+  }
+
+  stats(): EmbeddingCacheStats {
+    return { hits: this.hits, misses: this.misses };
+  }
+
+  close(): void {
+    this.db.close();
+  }
+}
+
+/**
+ * Serves the vectors of texts embedded before from an {@link EmbeddingCache} and only sends the others
+ * to `provider`, once per distinct content. New vectors are cached as soon as they are returned, so a
+ * run that fails later does not pay for them again.
+ */
+export class CachedEmbeddingProvider implements EmbeddingProvider {
+  readonly dimensions: number;
+  readonly model?: string;
+
+  constructor(
+    private readonly provider: EmbeddingProvider,
+    private readonly cache: EmbeddingCache,
+    private readonly modelKey: string
+  ) {
+    this.dimensions = provider.dimensions;
+    this.model = provider.model;
+  }
+
+  async embed(texts: string[]): Promise<number[][]> {
+    const hashes = texts.map(hashEmbeddingText);
+    const vectors = this.cache.getMany(this.modelKey, hashes);
+    const missing = new Map<string, string>();
+    hashes.forEach((hash, i) => {
+      if (!vectors.has(hash) && !missing.has(hash)) {
+        missing.set(hash, texts[i]);
+      }
+    });
+    if (missing.size > 0) {
+      const embedded = await this.provider.embed(Array.from(missing.values()));
+      const fresh = new Map(Array.from(missing.keys()).map((hash, i) => [hash, embedded[i]]));
+      this.cache.setMany(this.modelKey, fresh);
+      for (const [hash, vector] of fresh) {
+        vectors.set(hash, vector);
+      }
+    }
+    return hashes.map((hash) => vectors.get(hash) as number[]);
+  }
+}
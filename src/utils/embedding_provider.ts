@@ -0,0 +1,381 @@
+import PQueue from 'p-queue';
+import { elasticsearchConfig, embeddingConfig } from '../config';
+import { getClient } from './elasticsearch';
+import { createLogger } from './logger';
+import { parseRetryAfter, RateLimiter } from './rate_limiter';
+import { estimateTokenCount } from './tokenizer';
+
+const logger = createLogger(undefined, { module: 'embedding' });
+
+export const EMBEDDING_PROVIDERS = ['elasticsearch', 'http', 'openai', 'cohere'] as const;
+export type EmbeddingProviderName = (typeof EMBEDDING_PROVIDERS)[number];
+
+/** Texts sent to an HTTP embedding endpoint per request. */
+export const DEFAULT_EMBEDDING_BATCH_SIZE = 32;
+/** Requests in flight at once against an HTTP embedding endpoint. */
+export const DEFAULT_EMBEDDING_CONCURRENCY = 4;
+const DEFAULT_EMBEDDING_TIMEOUT_MS = 60 * 1000;
+
+const OPENAI_EMBEDDINGS_URL = 'https://api.openai.com/v1/embeddings';
+const OPENAI_DEFAULT_MODEL = 'text-embedding-3-small';
+const COHERE_EMBED_URL = 'https://api.cohere.com/v2/embed';
+const COHERE_DEFAULT_MODEL = 'embed-english-v3.0';
+/** Cohere rejects requests with more texts than this. */
+const COHERE_MAX_BATCH_SIZE = 96;
+
+/** Output dimensions of hosted models, so the index mapping can be derived from the model name. */
+const KNOWN_MODEL_DIMENSIONS: Record<string, number> = {
+  'text-embedding-3-small': 1536,
+  'text-embedding-3-large': 3072,
+  'text-embedding-ada-002': 1536,
+  'embed-english-v3.0': 1024,
+  'embed-multilingual-v3.0': 1024,
+  'embed-english-light-v3.0': 384,
+  'embed-multilingual-light-v3.0': 384,
+  'embed-v4.0': 1536,
+};
+
+const PROBE_TEXT = 'function probe() { return true; }';
+const MAX_ERROR_BODY_LENGTH = 500;
+/** Times a rate limited request is resent before it fails. */
+const MAX_RATE_LIMITED_RETRIES = 5;
+/** Pause after a 429 without a `Retry-After` header, doubled on each retry of the request. */
+const RATE_LIMITED_BACKOFF_MS = 1000;
+/** Error bodies of a request rejected for the size of its batch rather than for one of its texts. */
+const BATCH_TOO_LARGE_PATTERN = /context length|too many tokens|token limit|exceeds? the max|too (large|long)/i;
+
+/** What the embedded texts are used for. Some providers embed queries differently from documents. */
+export type EmbeddingPurpose = 'document' | 'query';
+
+/** Turns texts into dense vectors for the `code_vector` field. */
+export interface EmbeddingProvider {
+  /** Length of every vector returned by `embed`; used as the `code_vector` mapping dims. */
+  readonly dimensions: number;
+  /** Model the vectors come from, when the provider names one. */
+  readonly model?: string;
+  /** Returns one vector per input text, in input order. */
+  embed(texts: string[]): Promise<number[][]>;
+}
+
+/**
+ * Embeds texts with a text embedding model deployed in Elasticsearch.
+ *
+ * At index time the same model runs inside the `code-similarity-pipeline` ingest pipeline, so the
+ * worker leaves vectors to Elasticsearch; this provider embeds queries and validates the model.
+ */
+export class ElasticsearchEmbeddingProvider implements EmbeddingProvider {
+  constructor(
+    private readonly modelId: string,
+    readonly dimensions: number = elasticsearchConfig.denseVectorDims
+  ) {}
+
+  async embed(texts: string[]): Promise<number[][]> {
+    if (texts.length === 0) {
+      return [];
+    }
+    const response = await getClient().ml.inferTrainedModel({
+      model_id: this.modelId,
+      docs: texts.map((text) => ({ text_field: text })),
+    });
+    return response.inference_results.map((result, i) => {
+      const vector = result.predicted_value;
+      if (!isVector(vector)) {
+        throw new Error(`Model "${this.modelId}" did not return an embedding for input ${i}.`);
+      }
+      return vector;
+    });
+  }
+}
+
+export interface HttpEmbeddingProviderOptions {
+  url: string;
+  model?: string;
+  dimensions: number;
+  /** Sent as `Authorization: Bearer <apiKey>` when set. */
+  apiKey?: string;
+  /** Texts per request (default: 32). */
+  batchSize?: number;
+  /** Requests in flight at once (default: 4). */
+  concurrency?: number;
+  /** Per-request timeout (default: 60 seconds). */
+  timeoutMs?: number;
+  /** Spaces requests under the provider's quotas (default: no limits, only `Retry-After` pauses). */
+  rateLimiter?: RateLimiter;
+  /** Injectable for tests (defaults to the global `fetch`). */
+  fetch?: typeof fetch;
+}
+
+/**
+ * Embeds texts with an HTTP embedding server, such as a self-hosted model or the OpenAI API.
+ *
+ * Requests are `POST <url>` with `{ "model": ..., "input": [...] }`. The response may be OpenAI-style
+ * (`{ "data": [{ "embedding": [...], "index": 0 }] }`) or a plain `{ "embeddings": [[...]] }`.
+ * Subclasses for other APIs override `buildRequestBody` and `parseResponse`.
+ *
+ * A request rejected with HTTP 429 is resent after the delay of its `Retry-After` header, or after
+ * an exponential backoff without one, during which no other request is sent. A batch rejected as
+ * too large (HTTP 413, or a 400 or 422 naming a token or length limit) is split in halves that are
+ * sent in turn, down to single texts.
+ */
+export class HttpEmbeddingProvider implements EmbeddingProvider {
+  readonly dimensions: number;
+  readonly model?: string;
+  private readonly url: string;
+  private readonly apiKey?: string;
+  private readonly batchSize: number;
+  private readonly requests: PQueue;
+  private readonly timeoutMs: number;
+  private readonly rateLimiter: RateLimiter;
+  private readonly fetch: typeof fetch;
+
+  constructor(options: HttpEmbeddingProviderOptions) {
+    this.url = options.url;
+    this.model = options.model;
+    this.dimensions = options.dimensions;
+    this.apiKey = options.apiKey;
+    this.batchSize = options.batchSize ?? DEFAULT_EMBEDDING_BATCH_SIZE;
+    this.requests = new PQueue({ concurrency: options.concurrency ?? DEFAULT_EMBEDDING_CONCURRENCY });
+    this.timeoutMs = options.timeoutMs ?? DEFAULT_EMBEDDING_TIMEOUT_MS;
+    this.rateLimiter = options.rateLimiter ?? new RateLimiter();
+    this.fetch = options.fetch ?? fetch;
+  }
+
+  async embed(texts: string[]): Promise<number[][]> {
+    const batches: string[][] = [];
+    for (let i = 0; i < texts.length; i += this.batchSize) {
+      batches.push(texts.slice(i, i + this.batchSize));
+    }
+    const results = await Promise.all(batches.map((batch) => this.requests.add(() => this.embedBatch(batch))));
+    return results.flat();
+  }
+
+  protected buildRequestBody(texts: string[]): Record<string, unknown> {
+    return { ...(this.model ? { model: this.model } : {}), input: texts };
+  }
+
+  protected parseResponse(body: unknown): number[][] {
+    return parseEmbeddingResponse(body);
+  }
+
+  private async embedBatch(texts: string[]): Promise<number[][]> {
+    const tokens = texts.reduce((total, text) => total + estimateTokenCount(text), 0);
+    let response: Response;
+    for (let attempt = 0; ; attempt++) {
+      await this.rateLimiter.acquire(tokens);
+      response = await this.fetch(this.url, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          ...(this.apiKey ? { Authorization: `Bearer ${this.apiKey}` } : {}),
+        },
+        body: JSON.stringify(this.buildRequestBody(texts)),
+        signal: AbortSignal.timeout(this.timeoutMs),
+      });
+      if (response.status !== 429 || attempt >= MAX_RATE_LIMITED_RETRIES) {
+        break;
+      }
+      const retryAfterMs =
+        parseRetryAfter(response.headers.get('retry-after')) ?? RATE_LIMITED_BACKOFF_MS * 2 ** attempt;
+      await response.text();
+      logger.warn(`Embedding endpoint ${this.url} rate limited a request (HTTP 429), retrying in ${retryAfterMs}ms.`);
+      this.rateLimiter.pauseFor(retryAfterMs);
+    }
+    if (!response.ok) {
+      const body = (await response.text()).slice(0, MAX_ERROR_BODY_LENGTH);
+      if (texts.length > 1 && isBatchTooLarge(response.status, body)) {
+        const half = Math.ceil(texts.length / 2);
+        logger.warn(
+          `Embedding endpoint ${this.url} rejected a batch of ${texts.length} texts as too large ` +
+            `(HTTP ${response.status}), retrying it as batches of ${half} and ${texts.length - half}.`
+        );
+        const head = await this.embedBatch(texts.slice(0, half));
+        return [...head, ...(await this.embedBatch(texts.slice(half)))];
+      }
+      throw new Error(`Embedding request to ${this.url} failed with HTTP ${response.status}: ${body}`);
+    }
+
+    const vectors = this.parseResponse(await response.json());
+    if (vectors.length !== texts.length) {
+      throw new Error(`Embedding endpoint ${this.url} returned ${vectors.length} vectors for ${texts.length} inputs.`);
+    }
+    return vectors;
+  }
+}
+
+/**
+ * Embeds texts with the Cohere v2 embed API, which takes `texts` and an `input_type` and
+ * returns `{ "embeddings": { "float": [[...]] } }`.
+ */
+export class CohereEmbeddingProvider extends HttpEmbeddingProvider {
+  private readonly inputType: string;
+
+  constructor(options: HttpEmbeddingProviderOptions & { purpose?: EmbeddingPurpose }) {
+    super({ ...options, batchSize: Math.min(options.batchSize ?? COHERE_MAX_BATCH_SIZE, COHERE_MAX_BATCH_SIZE) });
+    this.inputType = options.purpose === 'query' ? 'search_query' : 'search_document';
+  }
+
+  protected buildRequestBody(texts: string[]): Record<string, unknown> {
+    return { model: this.model, texts, input_type: this.inputType, embedding_types: ['float'] };
+  }
+
+  protected parseResponse(body: unknown): number[][] {
+    const embeddings = (body as { embeddings?: { float?: unknown } } | null)?.embeddings;
+    return parseEmbeddingResponse({ embeddings: embeddings?.float });
+  }
+}
+
+function isBatchTooLarge(status: number, body: string): boolean {
+  return status === 413 || ((status === 400 || status === 422) && BATCH_TOO_LARGE_PATTERN.test(body));
+}
+
+function isVector(value: unknown): value is number[] {
+  return Array.isArray(value) && value.length > 0 && value.every((n) => typeof n === 'number');
+}
+
+/**
+ * Extracts vectors from an OpenAI-style (`data[].embedding`) or plain (`embeddings`) response body.
+ */
+export function parseEmbeddingResponse(body: unknown): number[][] {
+  const { data, embeddings } = (body ?? {}) as { data?: unknown; embeddings?: unknown };
+  if (Array.isArray(data)) {
+    const entries = data as Array<{ embedding?: unknown; index?: unknown }>;
+    const ordered = entries.every((entry) => typeof entry.index === 'number')
+      ? [...entries].sort((a, b) => (a.index as number) - (b.index as number))
+      : entries;
+    const vectors = ordered.map((entry) => entry.embedding);
+    if (vectors.every(isVector)) {
+      return vectors;
+    }
+  } else if (Array.isArray(embeddings) && embeddings.every(isVector)) {
+    return embeddings;
+  }
+  throw new Error('Unexpected embedding response: expected "data[].embedding" or "embeddings".');
+}
+
+export interface EmbeddingProviderOptions {
+  provider?: string;
+  url?: string;
+  model?: string;
+  batchSize?: number;
+  concurrency?: number;
+  /** Shared by every request of the provider, see {@link RateLimiter}. */
+  rateLimiter?: RateLimiter;
+  purpose?: EmbeddingPurpose;
+  /** Length of the model's vectors, overriding the known dimensions of hosted models. */
+  dimensions?: number;
+}
+
+function parseEndpointUrl(value: string): string {
+  let url: URL;
+  try {
+    url = new URL(value);
+  } catch {
+    throw new Error(`Invalid --embedding-url value: ${value}. Expected an http(s) URL.`);
+  }
+  if (url.protocol !== 'http:' && url.protocol !== 'https:') {
+    throw new Error(`Invalid --embedding-url value: ${value}. Expected an http(s) URL.`);
+  }
+  return url.toString();
+}
+
+function requireApiKey(apiKey: string | undefined, envVarName: string, provider: string): string {
+  if (!apiKey) {
+    throw new Error(`${envVarName} must be set to use --embedding-provider ${provider}.`);
+  }
+  return apiKey;
+}
+
+/**
+ * Returns the output dimensions of a known hosted model, or `SCS_IDXR_DENSE_VECTOR_DIMS` otherwise.
+ */
+export function resolveModelDimensions(model: string | undefined): number {
+  return (model && KNOWN_MODEL_DIMENSIONS[model]) || elasticsearchConfig.denseVectorDims;
+}
+
+/**
+ * Creates the provider selected by `--embedding-provider`, throwing on an unknown provider, a
+ * missing or malformed `--embedding-url` or missing credentials. Call `validateEmbeddingProvider`
+ * to check it responds.
+ */
+export function createEmbeddingProvider(options: EmbeddingProviderOptions = {}): EmbeddingProvider {
+  const provider = options.provider ?? 'elasticsearch';
+  if (!EMBEDDING_PROVIDERS.includes(provider as EmbeddingProviderName)) {
+    throw new Error(
+      `Invalid --embedding-provider value: ${provider}. Expected one of: ${EMBEDDING_PROVIDERS.join(', ')}.`
+    );
+  }
+  const httpOptions = {
+    batchSize: options.batchSize,
+    concurrency: options.concurrency,
+    rateLimiter: options.rateLimiter,
+  };
+
+  switch (provider as EmbeddingProviderName) {
+    case 'elasticsearch': {
+      const modelId = options.model ?? elasticsearchConfig.denseVectorModelId;
+      if (!modelId) {
+        throw new Error(
+          'The elasticsearch embedding provider requires --embedding-model or SCS_IDXR_DENSE_VECTOR_MODEL_ID ' +
+            'to be set to a deployed text embedding model.'
+        );
+      }
+      return new ElasticsearchEmbeddingProvider(modelId);
+    }
+    case 'openai': {
+      const model = options.model ?? OPENAI_DEFAULT_MODEL;
+      return new HttpEmbeddingProvider({
+        ...httpOptions,
+        url: parseEndpointUrl(options.url ?? OPENAI_EMBEDDINGS_URL),
+        model,
+        dimensions: options.dimensions ?? resolveModelDimensions(model),
+        apiKey: requireApiKey(embeddingConfig.openaiApiKey, 'OPENAI_API_KEY', provider),
+      });
+    }
+    case 'cohere': {
+      const model = options.model ?? COHERE_DEFAULT_MODEL;
+      return new CohereEmbeddingProvider({
+        ...httpOptions,
+        url: parseEndpointUrl(options.url ?? COHERE_EMBED_URL),
+        model,
+        dimensions: options.dimensions ?? resolveModelDimensions(model),
+        apiKey: requireApiKey(embeddingConfig.cohereApiKey, 'COHERE_API_KEY', provider),
+        purpose: options.purpose,
+      });
+    }
+    case 'http': {
+      if (!options.url) {
+        throw new Error('--embedding-url is required when --embedding-provider is http.');
+      }
+      return new HttpEmbeddingProvider({
+        ...httpOptions,
+        url: parseEndpointUrl(options.url),
+        model: options.model,
+        dimensions: options.dimensions ?? elasticsearchConfig.denseVectorDims,
+        apiKey: embeddingConfig.apiKey,
+      });
+    }
+  }
+}
+
+/**
+ * Embeds a probe text so an unreachable endpoint, bad credentials, an unknown model or vectors
+ * that do not match the provider's `dimensions` fail at startup instead of mid-run.
+ */
+export async function validateEmbeddingProvider(provider: EmbeddingProvider): Promise<void> {
+  let vectors: number[][];
+  try {
+    vectors = await provider.embed([PROBE_TEXT]);
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new Error(`Embedding provider validation failed: ${message}`);
+  }
+  if (vectors.length !== 1) {
+    throw new Error(`Embedding provider validation failed: expected 1 vector, got ${vectors.length}.`);
+  }
+  if (vectors[0].length !== provider.dimensions) {
+    throw new Error(
+      `Embedding provider validation failed: vectors have ${vectors[0].length} dimensions, ` +
+        `but ${provider.dimensions} were expected (set SCS_IDXR_DENSE_VECTOR_DIMS to the model's dimensions).`
+    );
+  }
+}
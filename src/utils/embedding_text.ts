@@ -0,0 +1,144 @@
+import { embeddingConfig } from '../config';
+import type { CodeChunk } from './elasticsearch';
+
+/** Placeholders of `SCS_IDXR_EMBED_HEADER_TEMPLATE`, filled from the chunk being embedded. */
+export const EMBEDDING_HEADER_PLACEHOLDERS = ['path', 'name', 'kind', 'language'] as const;
+
+/**
+ * Transforms applied to the text of a chunk before it is embedded, each off by default. Only the
+ * embedded text is normalized: the stored `content` stays the source, so search results show real code.
+ */
+export interface EmbeddingTextOptions {
+  /** Drop block comments and whole-line comments, in the comment syntax of the chunk's language. */
+  stripComments?: boolean;
+  /** Drop a leading comment block that names a copyright or license, see {@link LICENSE_PATTERN}. */
+  stripLicense?: boolean;
+  /** Replace every run of whitespace, line breaks included, with one space. */
+  collapseWhitespace?: boolean;
+  lowercase?: boolean;
+  /** Prepended to the text, e.g. `// file: {path}\n// symbol: {name} ({kind})\n`. Not lowercased. */
+  headerTemplate?: string;
+}
+
+/** Languages with `#` or `--` line comments; the others with comments use C-style `//` and block comments. */
+const LINE_COMMENT_MARKERS: Record<string, string[]> = {
+  bash: ['#'],
+  python: ['#'],
+  yaml: ['#'],
+  properties: ['#', '!'],
+  hcl: ['#', '//'],
+  plpgsql: ['--'],
+};
+
+/** Languages without block comments among those of {@link LINE_COMMENT_MARKERS}. */
+const NO_BLOCK_COMMENT_LANGUAGES = new Set(['bash', 'python', 'yaml', 'properties']);
+
+/** Languages whose text has no comment syntax to strip. */
+const NO_COMMENT_LANGUAGES = new Set(['json', 'markdown', 'text', 'handlebars']);
+
+/** Words of a license header, matched in the comment block a chunk starts with. */
+const LICENSE_PATTERN = /\b(copyright|licen[cs]ed?|spdx-license-identifier|all rights reserved)\b/i;
+
+/**
+ * Parses a header template, turning the two characters `\n` into line breaks so a template can be
+ * given in one environment variable. Unknown placeholders fail at startup instead of being embedded.
+ */
+export function parseEmbeddingHeaderTemplate(value: string): string {
+  for (const [, placeholder] of value.matchAll(/\{(\w+)\}/g)) {
+    if (!EMBEDDING_HEADER_PLACEHOLDERS.includes(placeholder as (typeof EMBEDDING_HEADER_PLACEHOLDERS)[number])) {
+      throw new Error(
+        `Invalid configuration: SCS_IDXR_EMBED_HEADER_TEMPLATE has an unknown placeholder {${placeholder}}. ` +
+          `Expected one of: ${EMBEDDING_HEADER_PLACEHOLDERS.map((name) => `{${name}}`).join(', ')}.`
+      );
+    }
+  }
+  return value.replace(/\\n/g, '\n');
+}
+
+/** Reads the {@link EmbeddingTextOptions} of the run from the `SCS_IDXR_EMBED_*` variables. */
+export function getEmbeddingTextOptions(): EmbeddingTextOptions {
+  const headerTemplate = embeddingConfig.headerTemplate;
+  return {
+    stripComments: embeddingConfig.stripComments,
+    stripLicense: embeddingConfig.stripLicense,
+    collapseWhitespace: embeddingConfig.collapseWhitespace,
+    lowercase: embeddingConfig.lowercase,
+    ...(headerTemplate !== undefined ? { headerTemplate: parseEmbeddingHeaderTemplate(headerTemplate) } : {}),
+  };
+}
+
+function stripComments(text: string, language: string): string {
+  if (NO_COMMENT_LANGUAGES.has(language)) {
+    return text;
+  }
+  const stripped = NO_BLOCK_COMMENT_LANGUAGES.has(language) ? text : text.replace(/\/\*[\s\S]*?\*\//g, '');
+  // Trailing comments are kept, since a marker after code may as well be inside a string.
+  const markers = LINE_COMMENT_MARKERS[language] ?? ['//'];
+  return stripped
+    .split('\n')
+    .filter((line) => !markers.some((marker) => line.trimStart().startsWith(marker)))
+    .join('\n');
+}
+
+/** Returns the comment block at the start of `text`: one block comment, or a run of whole-line comments. */
+function getLeadingComment(text: string, language: string): string | undefined {
+  const leading = /^\s*/.exec(text)?.[0] ?? '';
+  const rest = text.slice(leading.length);
+  if (!NO_BLOCK_COMMENT_LANGUAGES.has(language) && rest.startsWith('/*')) {
+    const end = rest.indexOf('*/');
+    return end >= 0 ? text.slice(0, leading.length + end + 2) : undefined;
+  }
+  const markers = LINE_COMMENT_MARKERS[language] ?? ['//'];
+  const lines = text.split('\n');
+  let count = 0;
+  while (count < lines.length && markers.some((marker) => lines[count].trimStart().startsWith(marker))) {
+    count++;
+  }
+  return count > 0 ? lines.slice(0, count).join('\n') : undefined;
+}
+
+/** Removes the license header the content of `chunk` starts with from `text`, which contains that content. */
+function stripLicenseHeader(text: string, chunk: CodeChunk): string {
+  if (NO_COMMENT_LANGUAGES.has(chunk.language)) {
+    return text;
+  }
+  const header = getLeadingComment(chunk.content, chunk.language);
+  return header !== undefined && LICENSE_PATTERN.test(header) ? text.replace(header, '') : text;
+}
+
+function renderHeader(template: string, chunk: CodeChunk): string {
+  const values: Record<(typeof EMBEDDING_HEADER_PLACEHOLDERS)[number], string> = {
+    path: chunk.filePath ?? '',
+    name: chunk.scope_path ?? chunk.symbol_fqn ?? chunk.symbol_name ?? '',
+    kind: chunk.symbol_kind ?? chunk.kind ?? '',
+    language: chunk.language,
+  };
+  return template.replace(/\{(\w+)\}/g, (match, name: string) => values[name as keyof typeof values] ?? match);
+}
+
+/**
+ * Returns `text`, the content or `semantic_text` of `chunk`, as it is embedded with `options`.
+ * Identical chunks of several files share one chunk document, so `{path}` is the path of the file
+ * the document was first created from.
+ */
+export function normalizeEmbeddingText(
+  text: string,
+  chunk: CodeChunk,
+  options: EmbeddingTextOptions = getEmbeddingTextOptions()
+): string {
+  let normalized = options.stripLicense ? stripLicenseHeader(text, chunk) : text;
+  if (options.stripComments) {
+    normalized = stripComments(normalized, chunk.language);
+  }
+  // A chunk that is only a comment, such as a license header of its own, is embedded with its comments.
+  if (normalized.trim().length === 0) {
+    normalized = text;
+  }
+  if (options.collapseWhitespace) {
+    normalized = normalized.replace(/\s+/g, ' ').trim();
+  }
+  if (options.lowercase) {
+    normalized = normalized.toLowerCase();
+  }
+  return options.headerTemplate ? `${renderHeader(options.headerTemplate, chunk)}${normalized}` : normalized;
+}
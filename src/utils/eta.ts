@@ -0,0 +1,83 @@
+/** Default time window used to average throughput. */
+const DEFAULT_WINDOW_MS = 60 * 1000;
+
+interface Sample {
+  at: number;
+  completed: number;
+}
+
+/**
+ * Estimates throughput and time remaining from a running completion count.
+ *
+ * Throughput is a moving average over the samples recorded in the last `windowMs`, so the ETA
+ * follows recent speed rather than the average since the start of a long run.
+ */
+export class EtaEstimator {
+  private readonly windowMs: number;
+  private readonly samples: Sample[] = [];
+
+  constructor(windowMs: number = DEFAULT_WINDOW_MS) {
+    this.windowMs = windowMs;
+  }
+
+  /**
+   * Records the total number of units completed so far.
+   *
+   * @param completed - Cumulative completed count (not a delta).
+   * @param now - Sample time in milliseconds (defaults to `Date.now()`).
+   */
+  record(completed: number, now: number = Date.now()): void {
+    this.samples.push({ at: now, completed });
+    // Keep one sample older than the window so the average always spans the full window.
+    while (this.samples.length > 2 && this.samples[1].at <= now - this.windowMs) {
+      this.samples.shift();
+    }
+  }
+
+  /** Units completed per second over the moving window, or 0 before two samples exist. */
+  get ratePerSecond(): number {
+    if (this.samples.length < 2) {
+      return 0;
+    }
+    const first = this.samples[0];
+    const last = this.samples[this.samples.length - 1];
+    const elapsedMs = last.at - first.at;
+    if (elapsedMs <= 0) {
+      return 0;
+    }
+    return ((last.completed - first.completed) * 1000) / elapsedMs;
+  }
+
+  /**
+   * Estimates the seconds needed to complete `remaining` more units.
+   *
+   * @returns The estimate, 0 when nothing remains, or null while the rate is unknown.
+   */
+  estimateRemainingSeconds(remaining: number): number | null {
+    if (remaining <= 0) {
+      return 0;
+    }
+    const rate = this.ratePerSecond;
+    if (rate <= 0) {
+      return null;
+    }
+    return Math.ceil(remaining / rate);
+  }
+}
+
+/**
+ * Formats a duration in seconds as a compact human-readable string (e.g. `2h 5m`, `4m 10s`).
+ */
+export function formatDuration(seconds: number): string {
+  const total = Math.max(0, Math.round(seconds));
+  const hours = Math.floor(total / 3600);
+  const minutes = Math.floor((total % 3600) / 60);
+  const secs = total % 60;
+  if (hours > 0) {
+    return `${hours}h ${minutes}m`;
+  }
+  if (minutes > 0) {
+    return `${minutes}m ${secs}s`;
+  }
+  return `${secs}s`;
+}
@@ -0,0 +1,111 @@
+import fs from 'fs';
+import path from 'path';
+import { languageConfigurations } from '../languages';
+import type { LanguageConfiguration } from './parser';
+import type { createLogger } from './logger';
+
+/** Parser name that excludes matching files from indexing. */
+export const SKIP_PARSER = 'skip';
+
+/** What happens to files whose extension no enabled language handles. */
+export const UNKNOWN_EXTENSION_MODES = ['skip', 'text'] as const;
+export type UnknownExtensionMode = (typeof UNKNOWN_EXTENSION_MODES)[number];
+
+/** Files listed by {@link logParserSample}, at most one per file suffix. */
+export const PARSER_SAMPLE_SIZE = 20;
+
+/**
+ * Routes file suffixes to parsers, on top of the suffixes registered by each language.
+ *
+ * Kept as plain JSON so it can be handed to parsing worker threads as is.
+ */
+export interface ExtensionMap {
+  /** File suffix (e.g. `.gotmpl` or `.pb.go`) to a language name or {@link SKIP_PARSER}. */
+  extensions: Record<string, string>;
+  /** Parser used for files that neither the map nor an enabled language handles (default: skip). */
+  unknown?: UnknownExtensionMode;
+}
+
+/**
+ * Reads and validates an extension map file such as
+ * `{ "extensions": { ".gotmpl": "text", "*.pb.go": "skip" }, "unknown": "text" }`.
+ *
+ * A leading `*` is accepted and dropped, so `*.pb.go` and `.pb.go` are the same rule.
+ */
+export function loadExtensionMap(filePath: string): ExtensionMap {
+  let parsed: unknown;
+  try {
+    parsed = JSON.parse(fs.readFileSync(filePath, 'utf8'));
+  } catch (error) {
+    const message = error instanceof Error ? error.message : String(error);
+    throw new Error(`Could not read extension map ${filePath}: ${message}`);
+  }
+
+  const file = parsed as { extensions?: unknown; unknown?: unknown };
+  if (!file || typeof file !== 'object' || Array.isArray(file)) {
+    throw new Error(`Invalid extension map ${filePath}: expected an object with an "extensions" object.`);
+  }
+  if (file.extensions !== undefined && (typeof file.extensions !== 'object' || Array.isArray(file.extensions))) {
+    throw new Error(`Invalid extension map ${filePath}: "extensions" must map file suffixes to parser names.`);
+  }
+  if (file.unknown !== undefined && !UNKNOWN_EXTENSION_MODES.includes(file.unknown as UnknownExtensionMode)) {
+    throw new Error(
+      `Invalid extension map ${filePath}: "unknown" must be one of: ${UNKNOWN_EXTENSION_MODES.join(', ')}.`
+    );
+  }
+
+  const extensions: Record<string, string> = {};
+  for (const [key, parser] of Object.entries((file.extensions ?? {}) as Record<string, unknown>)) {
+    const suffix = key.startsWith('*') ? key.slice(1) : key;
+    if (!suffix.startsWith('.') || suffix.length < 2 || suffix.includes('/')) {
+      throw new Error(`Invalid extension map ${filePath}: "${key}" is not a file suffix such as ".gotmpl".`);
+    }
+    if (typeof parser !== 'string' || (parser !== SKIP_PARSER && !(parser in languageConfigurations))) {
+      throw new Error(
+        `Invalid extension map ${filePath}: "${key}" maps to ${JSON.stringify(parser)}. ` +
+          `Expected "${SKIP_PARSER}" or a language name: ${Object.keys(languageConfigurations).join(', ')}.`
+      );
+    }
+    extensions[suffix] = parser;
+  }
+
+  return { extensions, ...(file.unknown !== undefined ? { unknown: file.unknown as UnknownExtensionMode } : {}) };
+}
+
+/** Returns the longest suffix of `filePath`'s name that has a rule in `map`, if any. */
+export function findExtensionRule(map: ExtensionMap, filePath: string): string | undefined {
+  const name = path.basename(filePath);
+  let match: string | undefined;
+  for (const suffix of Object.keys(map.extensions)) {
+    if (name.endsWith(suffix) && name.length > suffix.length && suffix.length > (match?.length ?? 0)) {
+      match = suffix;
+    }
+  }
+  return match;
+}
+
+/**
+ * Logs the parser resolved for a sample of `files`, one file per suffix, so the routing of an
+ * extension map can be checked at startup.
+ */
+export function logParserSample(
+  files: string[],
+  resolve: (filePath: string) => LanguageConfiguration | undefined,
+  logger: ReturnType<typeof createLogger>
+): void {
+  const sample = new Map<string, string>();
+  for (const file of files) {
+    const name = path.basename(file);
+    // `.pb.go` and `.go` are listed apart, since a map can route them differently.
+    const suffix = name.slice(name.indexOf('.', 1) === -1 ? name.length : name.indexOf('.', 1));
+    if (!sample.has(suffix)) {
+      sample.set(suffix, `${file} -> ${resolve(file)?.name ?? SKIP_PARSER}`);
+      if (sample.size >= PARSER_SAMPLE_SIZE) {
+        break;
+      }
+    }
+  }
+  if (sample.size > 0) {
+    logger.info(`Resolved parsers (sample): ${Array.from(sample.values()).join(', ')}`);
+  }
+}
@@ -0,0 +1,124 @@
+import fs from 'fs';
+
+/** Bytes read from the start of a file to detect its encoding and binary content, as git does. */
+export const BINARY_SNIFF_BYTES = 8000;
+
+/** Encodings of the text files that are transcoded to UTF-8 before parsing. */
+export type TextEncoding = 'utf-8' | 'utf-16le' | 'utf-16be' | 'latin1';
+
+/** The encoding of a file, or `binary` when it is not text. */
+export type DetectedEncoding = TextEncoding | 'binary';
+
+/** Share of the sniffed code units that must have a zero high byte for a file without BOM to be UTF-16. */
+const UTF16_MIN_ZERO_RATIO = 0.75;
+
+export interface DecodedText {
+  encoding: DetectedEncoding;
+  /** The content as UTF-8, without BOM. Undefined when the content is binary or contains null characters. */
+  text?: string;
+}
+
+/** Returns true when `content` is valid UTF-8, allowing a multi-byte character cut off at its end. */
+function isUtf8(content: Buffer, allowTruncatedEnd: boolean): boolean {
+  try {
+    new TextDecoder('utf-8', { fatal: true }).decode(content, { stream: allowTruncatedEnd });
+    return true;
+  } catch {
+    return false;
+  }
+}
+
+/**
+ * Tells UTF-16 without a BOM from binary content: ASCII-range text in UTF-16 has a zero byte in
+ * most code units, always on the same side, while binary formats have them on both sides.
+ */
+function detectUtf16(sample: Buffer): 'utf-16le' | 'utf-16be' | undefined {
+  let zeroHighLe = 0;
+  let zeroHighBe = 0;
+  const units = Math.floor(sample.length / 2);
+  for (let i = 0; i < units * 2; i += 2) {
+    if (sample[i + 1] === 0 && sample[i] !== 0) {
+      zeroHighLe++;
+    } else if (sample[i] === 0 && sample[i + 1] !== 0) {
+      zeroHighBe++;
+    } else if (sample[i] === 0 && sample[i + 1] === 0) {
+      return undefined;
+    }
+  }
+  if (units === 0) {
+    return undefined;
+  }
+  if (zeroHighBe === 0 && zeroHighLe / units >= UTF16_MIN_ZERO_RATIO) {
+    return 'utf-16le';
+  }
+  if (zeroHighLe === 0 && zeroHighBe / units >= UTF16_MIN_ZERO_RATIO) {
+    return 'utf-16be';
+  }
+  return undefined;
+}
+
+/**
+ * Detects the encoding of a file from its first {@link BINARY_SNIFF_BYTES}: a UTF-8 or UTF-16 byte
+ * order mark wins, then content with null bytes is UTF-16 when they follow its pattern and binary
+ * otherwise, and the rest is UTF-8 when it is valid UTF-8 and Latin-1 when it is not.
+ */
+export function detectEncoding(content: Buffer): DetectedEncoding {
+  if (content[0] === 0xef && content[1] === 0xbb && content[2] === 0xbf) {
+    return 'utf-8';
+  }
+  // UTF-32 also starts with FF FE, but with two more zero bytes; it is not decoded.
+  if (content[0] === 0xff && content[1] === 0xfe) {
+    return content[2] === 0 && content[3] === 0 ? 'binary' : 'utf-16le';
+  }
+  if (content[0] === 0xfe && content[1] === 0xff) {
+    return 'utf-16be';
+  }
+  const sample = content.subarray(0, BINARY_SNIFF_BYTES);
+  if (sample.includes(0)) {
+    return detectUtf16(sample) ?? 'binary';
+  }
+  return isUtf8(sample, content.length > sample.length) ? 'utf-8' : 'latin1';
+}
+
+/**
+ * Transcodes file content to UTF-8 with the encoding {@link detectEncoding} finds. A file whose
+ * start looks like UTF-8 but that is not valid UTF-8 further on is read as Latin-1. Content that is
+ * binary, or text that contains null characters, has no `text` and is not parsed.
+ */
+export function decodeText(content: Buffer): DecodedText {
+  let encoding = detectEncoding(content);
+  let text: string;
+  switch (encoding) {
+    case 'binary':
+      return { encoding };
+    case 'utf-16le':
+      text = content.toString('utf16le', content[0] === 0xff && content[1] === 0xfe ? 2 : 0);
+      break;
+    case 'utf-16be': {
+      const start = content[0] === 0xfe && content[1] === 0xff ? 2 : 0;
+      const length = Math.floor((content.length - start) / 2) * 2;
+      text = Buffer.from(content.subarray(start, start + length)).swap16().toString('utf16le');
+      break;
+    }
+    case 'latin1':
+      text = content.toString('latin1');
+      break;
+    case 'utf-8': {
+      const start = content[0] === 0xef && content[1] === 0xbb && content[2] === 0xbf ? 3 : 0;
+      if (isUtf8(content.subarray(start), false)) {
+        text = content.toString('utf8', start);
+      } else {
+        encoding = 'latin1';
+        text = content.toString('latin1');
+      }
+      break;
+    }
+  }
+  // Null bytes past the sniffed start, or UTF-16 null characters.
+  return text.includes('\0') ? { encoding } : { encoding, text };
+}
+
+/** Reads a file and transcodes it to UTF-8, see {@link decodeText}. */
+export function readTextFile(filePath: string): DecodedText {
+  return decodeText(fs.readFileSync(filePath));
+}
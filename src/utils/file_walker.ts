@@ -0,0 +1,498 @@
+import { createHash } from 'crypto';
+import fs from 'fs';
+import path from 'path';
+import ignore, { Ignore } from 'ignore';
+import type { createLogger } from './logger';
+import { BINARY_SNIFF_BYTES, detectEncoding } from './file_encoding';
+
+/**
+ * Patterns that are always excluded, regardless of repository ignore files.
+ * Generated lexers/parsers are huge and add nothing useful to search results.
+ */
+export const DEFAULT_EXCLUDE_PATTERNS = ['**/*_lexer.ts', '**/*_parser.ts'];
+
+export interface WalkOptions {
+  /** Absolute path of the repository root. Ignore rules are resolved relative to it. */
+  rootDir: string;
+  /** Absolute path of the directory to walk (must be inside `rootDir`). Defaults to `rootDir`. */
+  searchDir?: string;
+  /** File suffixes to include (e.g. `.ts`). Files with other suffixes are not returned. */
+  fileSuffixes?: string[];
+  /**
+   * Decides which files are returned instead of `fileSuffixes`, e.g. `LanguageParser.getLanguageConfigForFile`
+   * when an extension map routes or skips files.
+   */
+  includeFile?: (relativePath: string) => boolean;
+  /** Additional ignore files (gitignore syntax) applied at the repository root. */
+  ignoreFiles?: string[];
+  /** Additional glob patterns that always exclude matching paths. */
+  excludePatterns?: string[];
+  /**
+   * Glob patterns of the files to return, e.g. `src/**/*.go`. Other files are skipped, and
+   * `excludePatterns` and ignore rules still apply to the matching ones.
+   */
+  includePatterns?: string[];
+  /**
+   * Whether per-directory ignore files (`.gitignore`, `.codesearchignore`) are honoured.
+   * Defaults to true. `excludePatterns` and the built-in exclusions always apply.
+   */
+  useIgnoreFiles?: boolean;
+  /** Also return the paths excluded by ignore rules in `ignoredPaths`. */
+  collectIgnoredPaths?: boolean;
+}
+
+export interface WalkResult {
+  /** Matching files, relative to `rootDir`, using `/` separators. */
+  files: string[];
+  /** Files that matched a supported suffix but were excluded by ignore rules. */
+  ignoredFileCount: number;
+  /** Directories that were excluded by ignore rules and therefore never descended into. */
+  ignoredDirectoryCount: number;
+  /**
+   * With `collectIgnoredPaths`, the excluded files and directories, relative to `rootDir`.
+   * Directories end with `/`.
+   */
+  ignoredPaths?: string[];
+}
+
+interface IgnoreLevel {
+  /** Directory that owns the rules, relative to the repository root ('' for the root). */
+  base: string;
+  matcher: Ignore;
+}
+
+/**
+ * Returns the root-level ignore files that apply to a repository: `.indexerignore` (when present
+ * and `useIgnoreFiles` is not false) followed by an optional user-supplied ignore file.
+ */
+export function getRepositoryIgnoreFiles(
+  rootDir: string,
+  options: { ignorePath?: string; useIgnoreFiles?: boolean } = {}
+): string[] {
+  const ignoreFiles: string[] = [];
+  const indexerignorePath = path.join(rootDir, '.indexerignore');
+  if ((options.useIgnoreFiles ?? true) && fs.existsSync(indexerignorePath)) {
+    ignoreFiles.push(indexerignorePath);
+  }
+  if (options.ignorePath) {
+    ignoreFiles.push(path.resolve(options.ignorePath));
+  }
+  return ignoreFiles;
+}
+
+function toPosix(p: string): string {
+  return p.split(path.sep).join('/');
+}
+
+/**
+ * Per-directory ignore files, in the order they are applied. Later files take precedence
+ * within the same directory, so `.codesearchignore` can re-include paths from `.gitignore`.
+ */
+export const DIRECTORY_IGNORE_FILES = ['.gitignore', '.codesearchignore'];
+
+function loadDirectoryIgnores(dir: string): Ignore | null {
+  let matcher: Ignore | null = null;
+  for (const fileName of DIRECTORY_IGNORE_FILES) {
+    const ignoreFilePath = path.join(dir, fileName);
+    if (fs.existsSync(ignoreFilePath)) {
+      matcher = (matcher ?? ignore()).add(fs.readFileSync(ignoreFilePath, 'utf8'));
+    }
+  }
+  return matcher;
+}
+
+/**
+ * Resolves whether a path is ignored using git's precedence rules: the deepest `.gitignore`
+ * with a matching rule (including `!` negations) wins, and the root-level extra ignore files
+ * are consulted last.
+ */
+function isIgnored(relativePath: string, levels: IgnoreLevel[], rootExtras: Ignore): boolean {
+  for (let i = levels.length - 1; i >= 0; i--) {
+    const { base, matcher } = levels[i];
+    const pathFromBase = base ? relativePath.slice(base.length + 1) : relativePath;
+    const result = matcher.test(pathFromBase);
+    if (result.ignored) {
+      return true;
+    }
+    if (result.unignored) {
+      return false;
+    }
+  }
+  return rootExtras.ignores(relativePath);
+}
+
+/**
+ * Expands the `{a,b}` alternatives of a glob, which gitignore syntax does not have, into one
+ * pattern per alternative: `src/**/*.{ts,tsx}` gives `src/**/*.ts` and `src/**/*.tsx`. Groups
+ * can be nested, and a group without a comma is kept as written.
+ */
+export function expandBraces(pattern: string): string[] {
+  const start = pattern.indexOf('{');
+  if (start === -1) {
+    return [pattern];
+  }
+  let depth = 0;
+  const commas: number[] = [];
+  for (let i = start; i < pattern.length; i++) {
+    const char = pattern[i];
+    if (char === '{') {
+      depth++;
+    } else if (char === ',' && depth === 1) {
+      commas.push(i);
+    } else if (char === '}' && --depth === 0) {
+      const prefix = pattern.slice(0, start);
+      const rest = expandBraces(pattern.slice(i + 1));
+      if (commas.length === 0) {
+        return rest.map((suffix) => pattern.slice(0, i + 1) + suffix);
+      }
+      const bounds = [start, ...commas, i];
+      const alternatives = bounds.slice(1).flatMap((end, k) => expandBraces(pattern.slice(bounds[k] + 1, end)));
+      return alternatives.flatMap((alternative) => rest.map((suffix) => prefix + alternative + suffix));
+    }
+  }
+  // An unclosed brace is literal.
+  return [pattern];
+}
+
+function expandPatterns(patterns: string[]): string[] {
+  return patterns.flatMap(expandBraces);
+}
+
+/** Loads the root-level extra ignore files and the include and exclude patterns, built-in ones included. */
+function loadRootMatchers(options: Pick<WalkOptions, 'ignoreFiles' | 'excludePatterns' | 'includePatterns'>): {
+  rootExtras: Ignore;
+  excludes: Ignore;
+  includes?: Ignore;
+} {
+  const rootExtras = ignore();
+  for (const ignoreFile of options.ignoreFiles ?? []) {
+    rootExtras.add(fs.readFileSync(ignoreFile, 'utf8'));
+  }
+
+  const excludes = ignore().add(DEFAULT_EXCLUDE_PATTERNS);
+  if (options.excludePatterns && options.excludePatterns.length > 0) {
+    excludes.add(expandPatterns(options.excludePatterns));
+  }
+  // Matched like an ignore file, so a pattern naming a directory includes the files below it.
+  const includes =
+    options.includePatterns && options.includePatterns.length > 0
+      ? ignore().add(expandPatterns(options.includePatterns))
+      : undefined;
+  return { rootExtras, excludes, includes };
+}
+
+/**
+ * Walks a repository and returns the files that should be indexed.
+ *
+ * Nested `.gitignore` and `.codesearchignore` files are honoured, and directories that are
+ * ignored are pruned without being read. Dotfiles and dot-directories are skipped, as is `.git`.
+ */
+export function walkRepositoryFiles(options: WalkOptions): WalkResult {
+  const rootDir = fs.realpathSync(options.rootDir);
+  const searchDir = options.searchDir ? fs.realpathSync(options.searchDir) : rootDir;
+  const useIgnoreFiles = options.useIgnoreFiles ?? true;
+  const loadIgnores = (dir: string) => (useIgnoreFiles ? loadDirectoryIgnores(dir) : null);
+
+  const { rootExtras, excludes, includes } = loadRootMatchers(options);
+
+  const result: WalkResult = {
+    files: [],
+    ignoredFileCount: 0,
+    ignoredDirectoryCount: 0,
+    ...(options.collectIgnoredPaths ? { ignoredPaths: [] } : {}),
+  };
+
+  // Collect ignore files from the root down to the search directory so that
+  // indexing a sub-directory still honours the rules of its ancestors.
+  const levels: IgnoreLevel[] = [];
+  const searchRelative = toPosix(path.relative(rootDir, searchDir));
+  const ancestors = searchRelative ? searchRelative.split('/') : [];
+  for (let depth = 0; depth < ancestors.length; depth++) {
+    const base = ancestors.slice(0, depth).join('/');
+    const matcher = loadIgnores(path.join(rootDir, base));
+    if (matcher) {
+      levels.push({ base, matcher });
+    }
+  }
+
+  const isExcluded = (relativePath: string) =>
+    excludes.ignores(relativePath) || isIgnored(relativePath, levels, rootExtras);
+  const isIncluded = (relativePath: string, name: string) =>
+    (!includes || includes.ignores(relativePath)) &&
+    (options.includeFile
+      ? options.includeFile(relativePath)
+      : (options.fileSuffixes ?? []).some((suffix) => name.endsWith(suffix)));
+
+  const walk = (absoluteDir: string, relativeDir: string) => {
+    const matcher = loadIgnores(absoluteDir);
+    if (matcher) {
+      levels.push({ base: relativeDir, matcher });
+    }
+
+    const entries = fs.readdirSync(absoluteDir, { withFileTypes: true });
+    entries.sort((a, b) => a.name.localeCompare(b.name));
+
+    for (const entry of entries) {
+      if (entry.name.startsWith('.')) {
+        continue;
+      }
+      const absolutePath = path.join(absoluteDir, entry.name);
+      const relativePath = relativeDir ? `${relativeDir}/${entry.name}` : entry.name;
+
+      let isDirectory = entry.isDirectory();
+      let isFile = entry.isFile();
+      if (entry.isSymbolicLink()) {
+        // Follow symlinked files, but never symlinked directories (avoids cycles).
+        try {
+          isFile = fs.statSync(absolutePath).isFile();
+        } catch {
+          isFile = false;
+        }
+        isDirectory = false;
+      }
+
+      if (isDirectory) {
+        if (isExcluded(`${relativePath}/`)) {
+          result.ignoredDirectoryCount++;
+          result.ignoredPaths?.push(`${relativePath}/`);
+          continue;
+        }
+        walk(absolutePath, relativePath);
+      } else if (isFile && isIncluded(relativePath, entry.name)) {
+        if (isExcluded(relativePath)) {
+          result.ignoredFileCount++;
+          result.ignoredPaths?.push(relativePath);
+          continue;
+        }
+        result.files.push(relativePath);
+      }
+    }
+
+    if (matcher) {
+      levels.pop();
+    }
+  };
+
+  walk(searchDir, searchRelative);
+  return result;
+}
+
+/**
+ * Returns whether a path relative to `rootDir` is excluded by the rules of {@link walkRepositoryFiles}:
+ * a dot segment, an ignore file of one of its directories, the root-level extra ignore files or an
+ * exclude pattern, or for a file, not matching the include patterns. A path inside an excluded
+ * directory is excluded too, so single paths (e.g. from a file watcher) can be checked without
+ * walking the tree.
+ *
+ * Per-directory ignore files are read once, so create a new filter when one of them changes.
+ */
+export function createPathFilter(
+  options: Pick<WalkOptions, 'rootDir' | 'ignoreFiles' | 'excludePatterns' | 'includePatterns' | 'useIgnoreFiles'>
+): (relativePath: string, isDirectory?: boolean) => boolean {
+  const useIgnoreFiles = options.useIgnoreFiles ?? true;
+  const { rootExtras, excludes, includes } = loadRootMatchers(options);
+  const directoryIgnores = new Map<string, Ignore | null>();
+  const loadIgnores = (base: string) => {
+    if (!directoryIgnores.has(base)) {
+      directoryIgnores.set(base, useIgnoreFiles ? loadDirectoryIgnores(path.join(options.rootDir, base)) : null);
+    }
+    return directoryIgnores.get(base) ?? null;
+  };
+
+  return (relativePath: string, isDirectory = false) => {
+    if (relativePath === '') {
+      // The root itself.
+      return false;
+    }
+    const segments = toPosix(relativePath).split('/');
+    if (segments.some((segment) => segment.startsWith('.'))) {
+      return true;
+    }
+    if (includes && !isDirectory && !includes.ignores(segments.join('/'))) {
+      return true;
+    }
+    const levels: IgnoreLevel[] = [];
+    for (let depth = 0; depth < segments.length; depth++) {
+      const base = segments.slice(0, depth).join('/');
+      const matcher = loadIgnores(base);
+      if (matcher) {
+        levels.push({ base, matcher });
+      }
+      // Directories are matched with a trailing slash, as the walk does.
+      const isFile = depth === segments.length - 1 && !isDirectory;
+      const checkedPath = segments.slice(0, depth + 1).join('/') + (isFile ? '' : '/');
+      if (excludes.ignores(checkedPath) || isIgnored(checkedPath, levels, rootExtras)) {
+        return true;
+      }
+    }
+    return false;
+  };
+}
+
+export { BINARY_SNIFF_BYTES };
+
+/** A walked file that is not read or parsed, with its size on disk. */
+export interface SkippedFile {
+  file: string;
+  size: number;
+  reason: 'too_large' | 'binary';
+}
+
+/** Returns true when the first {@link BINARY_SNIFF_BYTES} of a file are not text in any supported encoding. */
+function isBinaryFile(absolutePath: string): boolean {
+  const fd = fs.openSync(absolutePath, 'r');
+  try {
+    const buffer = Buffer.alloc(BINARY_SNIFF_BYTES);
+    const bytesRead = fs.readSync(fd, buffer, 0, BINARY_SNIFF_BYTES, 0);
+    return isBinaryContent(buffer.subarray(0, bytesRead));
+  } finally {
+    fs.closeSync(fd);
+  }
+}
+
+/**
+ * Returns true when the first {@link BINARY_SNIFF_BYTES} of `content` contain null bytes that are
+ * not UTF-16, see {@link detectEncoding}.
+ */
+export function isBinaryContent(content: Buffer): boolean {
+  return detectEncoding(content) === 'binary';
+}
+
+/**
+ * Splits walked files into the ones to parse and the ones to skip: files larger than
+ * `maxFileSize` bytes, checked with `stat` so they are never read, and binary files, whatever
+ * their extension. Files that can no longer be read are left to the parser to report.
+ */
+export function filterReadableFiles(
+  rootDir: string,
+  files: string[],
+  maxFileSize: number
+): { files: string[]; skipped: SkippedFile[] } {
+  const result: { files: string[]; skipped: SkippedFile[] } = { files: [], skipped: [] };
+  for (const file of files) {
+    const absolutePath = path.resolve(rootDir, file);
+    let size: number;
+    let binary: boolean;
+    try {
+      size = fs.statSync(absolutePath).size;
+      binary = size <= maxFileSize && isBinaryFile(absolutePath);
+    } catch {
+      result.files.push(file);
+      continue;
+    }
+    if (size > maxFileSize) {
+      result.skipped.push({ file, size, reason: 'too_large' });
+    } else if (binary) {
+      result.skipped.push({ file, size, reason: 'binary' });
+    } else {
+      result.files.push(file);
+    }
+  }
+  return result;
+}
+
+/** Logs how many files {@link filterReadableFiles} skipped, with each file at debug level. */
+export function logSkippedFiles(
+  skipped: SkippedFile[],
+  maxFileSize: number,
+  logger: ReturnType<typeof createLogger>
+): void {
+  if (skipped.length === 0) {
+    return;
+  }
+  const tooLarge = skipped.filter((file) => file.reason === 'too_large').length;
+  logger.info(
+    `Skipped ${tooLarge} files larger than ${maxFileSize} bytes and ${skipped.length - tooLarge} binary files.`
+  );
+  for (const file of skipped) {
+    logger.debug(`Skipped ${file.file}`, { size: file.size, reason: file.reason });
+  }
+}
+
+/**
+ * Reads a newline-delimited list of file paths, from standard input when `source` is `-`. Empty
+ * lines are ignored and `\r\n` line endings are accepted; other whitespace is part of the path.
+ */
+export function readFileList(source: string): string[] {
+  const text = fs.readFileSync(source === '-' ? 0 : source, 'utf8');
+  return text
+    .split('\n')
+    .map((line) => line.replace(/\r$/, ''))
+    .filter((line) => line.length > 0);
+}
+
+export interface ListedFiles {
+  /** Listed files, relative to `rootDir`, using `/` separators and without duplicates. */
+  files: string[];
+  /** Listed paths that are not a file, e.g. because they were deleted or name a directory. */
+  missing: string[];
+  /** Listed paths outside `rootDir`. */
+  outside: string[];
+}
+
+/**
+ * Resolves the paths of a file list (see {@link readFileList}) against `baseDir` and returns the
+ * existing files inside `rootDir`, relative to it, as {@link walkRepositoryFiles} does.
+ */
+export function resolveListedFiles(rootDir: string, baseDir: string, paths: string[]): ListedFiles {
+  const result: ListedFiles = { files: [], missing: [], outside: [] };
+  const seen = new Set<string>();
+  for (const listedPath of paths) {
+    const relativePath = path.relative(rootDir, path.resolve(baseDir, listedPath));
+    const isOutside =
+      relativePath === '..' || relativePath.startsWith(`..${path.sep}`) || path.isAbsolute(relativePath);
+    if (relativePath === '' || isOutside) {
+      result.outside.push(listedPath);
+      continue;
+    }
+    let isFile: boolean;
+    try {
+      isFile = fs.statSync(path.join(rootDir, relativePath)).isFile();
+    } catch {
+      isFile = false;
+    }
+    if (!isFile) {
+      result.missing.push(listedPath);
+      continue;
+    }
+    const file = toPosix(relativePath);
+    if (!seen.has(file)) {
+      seen.add(file);
+      result.files.push(file);
+    }
+  }
+  return result;
+}
+
+/** Picks a subset of the files of a run, see {@link sampleFiles}. */
+export interface FileSample {
+  /** Keep at most this many files, the first ones by sorted path. */
+  limit?: number;
+  /** Keep each file with this probability, from 0 (exclusive) to 1. */
+  sampleRate?: number;
+  /** Seed of the `sampleRate` draw; the same seed keeps the same files. */
+  seed?: number;
+}
+
+/** Maps a path to a number in [0, 1) that only depends on the path and the seed. */
+function samplePosition(file: string, seed: number): number {
+  return createHash('sha256').update(`${seed}:${file}`).digest().readUInt32BE(0) / 2 ** 32;
+}
+
+/**
+ * Applies `--sample-rate` and then `--limit` to the files of a run, keeping their order. A file is
+ * drawn by a hash of its path and the seed rather than by its position, so the same seed draws the
+ * same files on every run, and a file added to the repository does not change which others are drawn.
+ */
+export function sampleFiles(files: string[], sample: FileSample): string[] {
+  let sampled = files;
+  if (sample.sampleRate !== undefined) {
+    const { sampleRate, seed = 0 } = sample;
+    sampled = sampled.filter((file) => samplePosition(file, seed) < sampleRate);
+  }
+  if (sample.limit !== undefined && sampled.length > sample.limit) {
+    const kept = new Set([...sampled].sort((a, b) => (a < b ? -1 : a > b ? 1 : 0)).slice(0, sample.limit));
+    sampled = sampled.filter((file) => kept.has(file));
+  }
+  return sampled;
+}
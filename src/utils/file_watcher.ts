@@ -0,0 +1,254 @@
+import fs from 'fs';
+import path from 'path';
+import type { createLogger } from './logger';
+
+/** The subset of `fs.watch` used by {@link FileWatcher}, replaceable in tests. */
+export type WatchFunction = (
+  directory: string,
+  options: { recursive: boolean; persistent: boolean },
+  listener: (eventType: string, fileName: string | Buffer | null) => void
+) => fs.FSWatcher;
+
+export interface FileWatcherOptions {
+  /** Absolute path of the directory to watch. Changed paths are reported relative to it, with `/` separators. */
+  rootDir: string;
+  /** Milliseconds without a new event before the changed paths are handed to `onChange`. */
+  debounceMs: number;
+  /**
+   * Receives the paths changed since the last call, sorted. Paths can name files or directories, and
+   * ones that no longer exist. Calls never overlap: paths changed meanwhile are handed to the next one.
+   */
+  onChange: (relativePaths: string[]) => Promise<void>;
+  /** Paths for which this returns true are dropped, and excluded directories are never watched. */
+  isExcluded?: (relativePath: string, isDirectory: boolean) => boolean;
+  logger: ReturnType<typeof createLogger>;
+  /**
+   * Whether to use a single recursive watch. Defaults to true on macOS and Windows, where it is one
+   * native handle. Elsewhere each directory is watched on its own so excluded directories such as
+   * `node_modules` do not use up watches.
+   */
+  recursive?: boolean;
+  watch?: WatchFunction;
+}
+
+function toPosix(p: string): string {
+  return p.split(path.sep).join('/');
+}
+
+/**
+ * Swap, backup, lock and temporary files that editors write next to a file while saving it, e.g. vim's
+ * `.a.ts.swp`, `a.ts~` and `4913`, emacs' `.#a.ts` and `#a.ts#`, and JetBrains' `a.ts___jb_tmp___`.
+ * An atomic save renames the temporary file over the original, which reports the original as changed.
+ */
+const EDITOR_TEMP_FILE_PATTERNS = [
+  /\.sw[a-p]$/,
+  /~$/,
+  /^\.#/,
+  /^#.*#$/,
+  /^4913$/,
+  /___jb_(tmp|old|bak)___$/,
+  /\.(tmp|crswap)$/,
+];
+
+/** Whether a file name is one of the temporary files editors write while saving, see above. */
+export function isEditorTempFile(fileName: string): boolean {
+  const name = path.posix.basename(fileName);
+  return EDITOR_TEMP_FILE_PATTERNS.some((pattern) => pattern.test(name));
+}
+
+function isWatchLimitError(error: unknown): boolean {
+  const code = (error as NodeJS.ErrnoException | undefined)?.code;
+  return code === 'EMFILE' || code === 'ENOSPC';
+}
+
+/**
+ * Watches a directory tree and reports changed paths in debounced batches, so a burst of saves
+ * (or a branch switch) is handled as one change.
+ */
+export class FileWatcher {
+  private readonly watch: WatchFunction;
+  private readonly isExcluded: (relativePath: string, isDirectory: boolean) => boolean;
+  /** Watch handles by directory, relative to the root ('' for the root or the recursive watch). */
+  private readonly watchers = new Map<string, fs.FSWatcher>();
+  private readonly pending = new Set<string>();
+  private timer: NodeJS.Timeout | undefined;
+  private flushing: Promise<void> | undefined;
+  private recursive = false;
+  private watchLimitReached = false;
+  private closed = false;
+
+  constructor(private readonly options: FileWatcherOptions) {
+    this.watch = options.watch ?? (fs.watch as unknown as WatchFunction);
+    this.isExcluded = options.isExcluded ?? (() => false);
+  }
+
+  /** Number of open watch handles. */
+  get watchedDirectoryCount(): number {
+    return this.watchers.size;
+  }
+
+  /** Whether a single recursive watch covers the tree. */
+  get isRecursive(): boolean {
+    return this.recursive;
+  }
+
+  start(): void {
+    const { logger } = this.options;
+    const recursive = this.options.recursive ?? (process.platform === 'darwin' || process.platform === 'win32');
+    if (recursive) {
+      try {
+        this.addWatcher('', true);
+        this.recursive = true;
+        return;
+      } catch (error) {
+        logger.warn('Recursive file watching is not available, watching each directory instead.', {
+          error: error instanceof Error ? error.message : String(error),
+        });
+      }
+    }
+    this.watchTree(this.options.rootDir, '');
+  }
+
+  /** Stops watching, drops changes that were not handed to `onChange` yet, and waits for a running call. */
+  async close(): Promise<void> {
+    this.closed = true;
+    clearTimeout(this.timer);
+    this.pending.clear();
+    for (const watcher of this.watchers.values()) {
+      watcher.close();
+    }
+    this.watchers.clear();
+    await this.flushing;
+  }
+
+  private addWatcher(relativeDir: string, recursive: boolean): void {
+    const absoluteDir = relativeDir ? path.join(this.options.rootDir, relativeDir) : this.options.rootDir;
+    const watcher = this.watch(absoluteDir, { recursive, persistent: true }, (_eventType, fileName) =>
+      this.handleEvent(relativeDir, fileName === null ? null : fileName.toString())
+    );
+    watcher.on('error', (error) => {
+      // Raised e.g. when a watched directory is removed on Windows.
+      this.options.logger.debug('File watcher error', { directory: relativeDir, error: String(error) });
+      this.unwatch(relativeDir);
+    });
+    this.watchers.set(relativeDir, watcher);
+  }
+
+  /** Watches a directory and its sub-directories that are not excluded, one handle each. */
+  private watchTree(absoluteDir: string, relativeDir: string): void {
+    if (this.watchLimitReached || this.watchers.has(relativeDir)) {
+      return;
+    }
+    try {
+      this.addWatcher(relativeDir, false);
+    } catch (error) {
+      if (isWatchLimitError(error)) {
+        this.watchLimitReached = true;
+        this.options.logger.warn(
+          `Reached the limit of file watches (${(error as NodeJS.ErrnoException).code}): changes in ` +
+            `${relativeDir || '.'} and any directory not watched yet are not picked up. Exclude large ` +
+            'directories, or raise the limit (on Linux, the fs.inotify.max_user_watches sysctl).',
+          { watchedDirectories: this.watchers.size }
+        );
+      } else {
+        // The directory can be removed while the tree is being walked.
+        this.options.logger.debug('Cannot watch directory', { directory: relativeDir, error: String(error) });
+      }
+      return;
+    }
+
+    let entries: fs.Dirent[];
+    try {
+      entries = fs.readdirSync(absoluteDir, { withFileTypes: true });
+    } catch {
+      return;
+    }
+    for (const entry of entries) {
+      // Symlinked directories are not followed, as in `walkRepositoryFiles`.
+      if (!entry.isDirectory() || entry.name.startsWith('.')) {
+        continue;
+      }
+      const relativePath = relativeDir ? `${relativeDir}/${entry.name}` : entry.name;
+      if (!this.isExcluded(relativePath, true)) {
+        this.watchTree(path.join(absoluteDir, entry.name), relativePath);
+      }
+    }
+  }
+
+  /** Closes the watches of a directory and everything below it. */
+  private unwatch(relativeDir: string): void {
+    for (const [directory, watcher] of this.watchers) {
+      if (relativeDir === '' || directory === relativeDir || directory.startsWith(`${relativeDir}/`)) {
+        watcher.close();
+        this.watchers.delete(directory);
+      }
+    }
+  }
+
+  private handleEvent(relativeDir: string, fileName: string | null): void {
+    if (this.closed) {
+      return;
+    }
+    if (fileName === null) {
+      // Some platforms omit the name. Report the directory so the caller rescans it.
+      if (!this.recursive) {
+        this.record(relativeDir);
+      }
+      return;
+    }
+    const name = toPosix(fileName);
+    const relativePath = relativeDir ? `${relativeDir}/${name}` : name;
+
+    if (!this.recursive) {
+      let isDirectory = false;
+      try {
+        isDirectory = fs.lstatSync(path.join(this.options.rootDir, relativePath)).isDirectory();
+      } catch {
+        isDirectory = false;
+      }
+      if (isDirectory) {
+        if (this.isExcluded(relativePath, true)) {
+          return;
+        }
+        // A new or moved-in directory: watch it, and report it so its files are indexed.
+        this.watchTree(path.join(this.options.rootDir, relativePath), relativePath);
+      } else if (this.watchers.has(relativePath)) {
+        // A removed or moved-out directory.
+        this.unwatch(relativePath);
+      }
+    }
+
+    if (!isEditorTempFile(relativePath) && !this.isExcluded(relativePath, false)) {
+      this.record(relativePath);
+    }
+  }
+
+  private record(relativePath: string): void {
+    this.pending.add(relativePath);
+    clearTimeout(this.timer);
+    this.timer = setTimeout(() => this.flush(), this.options.debounceMs);
+  }
+
+  private flush(): void {
+    this.timer = undefined;
+    if (this.closed || this.flushing || this.pending.size === 0) {
+      // A running call reschedules when it finishes.
+      return;
+    }
+    const paths = Array.from(this.pending).sort();
+    this.pending.clear();
+    this.flushing = this.options
+      .onChange(paths)
+      .catch((error) => {
+        this.options.logger.error('Failed to index changed files', {
+          error: error instanceof Error ? error.message : String(error),
+        });
+      })
+      .finally(() => {
+        this.flushing = undefined;
+        if (!this.closed && this.pending.size > 0 && !this.timer) {
+          this.timer = setTimeout(() => this.flush(), this.options.debounceMs);
+        }
+      });
+  }
+}
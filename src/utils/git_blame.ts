@@ -0,0 +1,89 @@
+import path from 'path';
+import { execFileSync } from 'child_process';
+
+/** Commit that changed a chunk's lines most recently, see `--with-blame`. Null when git cannot tell. */
+export interface ChunkBlame {
+  /** Commit date, as an ISO 8601 timestamp in UTC. */
+  last_modified: string | null;
+  last_author: string | null;
+}
+
+/** Id git blames lines with that are not committed yet. */
+const UNCOMMITTED_SHA = '0'.repeat(40);
+
+const UNKNOWN_BLAME: ChunkBlame = { last_modified: null, last_author: null };
+
+interface BlamedCommit {
+  author?: string;
+  committerTime?: number;
+}
+
+/**
+ * Parses the output of `git blame --porcelain` into the commit of each final line number. Each commit
+ * has its headers once, at its first line; uncommitted lines are left out.
+ */
+export function parseBlamePorcelain(output: string): Map<number, BlamedCommit> {
+  const commits = new Map<string, BlamedCommit>();
+  const lines = new Map<number, BlamedCommit>();
+  let current: BlamedCommit | undefined;
+  for (const line of output.split('\n')) {
+    const header = /^([0-9a-f]{40}) \d+ (\d+)/.exec(line);
+    if (header) {
+      const [, sha, finalLine] = header;
+      current = commits.get(sha) ?? {};
+      commits.set(sha, current);
+      if (sha !== UNCOMMITTED_SHA) {
+        lines.set(Number(finalLine), current);
+      }
+    } else if (current && line.startsWith('author ')) {
+      current.author = line.slice('author '.length);
+    } else if (current && line.startsWith('committer-time ')) {
+      current.committerTime = Number(line.slice('committer-time '.length));
+    }
+  }
+  return lines;
+}
+
+/**
+ * Blames the line ranges of the chunks of one file with a single `git blame -L ... -L ...`, and returns
+ * the most recent commit of each range, in the order of `ranges`. Every chunk gets null fields when the
+ * file is not tracked by git, or is not in a git work tree.
+ */
+export function blameChunks(filePath: string, ranges: Array<{ startLine: number; endLine: number }>): ChunkBlame[] {
+  if (ranges.length === 0) {
+    return [];
+  }
+  let blamed: Map<number, BlamedCommit>;
+  try {
+    const output = execFileSync(
+      'git',
+      [
+        'blame',
+        '--porcelain',
+        ...ranges.flatMap(({ startLine, endLine }) => ['-L', `${startLine},${endLine}`]),
+        '--',
+        path.basename(filePath),
+      ],
+      { cwd: path.dirname(filePath), stdio: ['ignore', 'pipe', 'ignore'], maxBuffer: 64 * 1024 * 1024 }
+    );
+    blamed = parseBlamePorcelain(output.toString());
+  } catch {
+    return ranges.map(() => UNKNOWN_BLAME);
+  }
+
+  return ranges.map(({ startLine, endLine }) => {
+    let latest: BlamedCommit | undefined;
+    for (let line = startLine; line <= endLine; line++) {
+      const commit = blamed.get(line);
+      if (commit?.committerTime !== undefined && commit.committerTime > (latest?.committerTime ?? -1)) {
+        latest = commit;
+      }
+    }
+    return latest?.committerTime !== undefined
+      ? {
+          last_modified: new Date(latest.committerTime * 1000).toISOString(),
+          last_author: latest.author ?? null,
+        }
+      : UNKNOWN_BLAME;
+  });
+}
@@ -0,0 +1,40 @@
+import { drainIndexerWorkers } from './indexer_worker';
+import { drainProducerPools } from './producer_pool';
+
+let shutdownRequested = false;
+
+/**
+ * True once a shutdown signal was received. Commands check it between steps so they stop instead of
+ * starting new work, such as indexing the next repository, while the process winds down.
+ */
+export function isShutdownRequested(): boolean {
+  return shutdownRequested;
+}
+
+/**
+ * Stops the producer pools and indexing workers and waits for their in-flight work: files being
+ * parsed are written to the queue, bulk requests and embedding calls finish and are committed, and
+ * the queue is flushed to disk.
+ *
+ * @param timeoutMs - Upper bound on the wait; producer workers get the same bound to acknowledge.
+ * @returns `true` if everything drained in time, `false` if the timeout elapsed first.
+ */
+export async function drainForShutdown(timeoutMs: number): Promise<boolean> {
+  shutdownRequested = true;
+  let timer: NodeJS.Timeout | undefined;
+  const timedOut = new Promise<false>((resolve) => {
+    timer = setTimeout(() => resolve(false), timeoutMs);
+    timer.unref();
+  });
+  const drained = Promise.all([drainProducerPools(timeoutMs), drainIndexerWorkers()]).then(() => true as const);
+  try {
+    return await Promise.race([drained, timedOut]);
+  } finally {
+    clearTimeout(timer);
+  }
+}
+
+/** Test-only: clears the shutdown flag. */
+export function resetShutdownRequested(): void {
+  shutdownRequested = false;
+}
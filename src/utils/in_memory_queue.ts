@@ -1,6 +1,8 @@
 import { CodeChunk } from './elasticsearch';
 import { IQueue, QueuedDocument } from './queue';
-import { logger } from './logger';
+import { createLogger } from './logger';
+
+const logger = createLogger(undefined, { module: 'queue' });
 
 const MAX_RETRIES = 3; // Match SqliteQueue behavior
 
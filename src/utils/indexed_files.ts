@@ -0,0 +1,36 @@
+import fs from 'fs';
+import path from 'path';
+import { readContentHash } from './manifest';
+import { IndexedFile } from './queue';
+
+/** How the files recorded as indexed compare with the files on disk, see {@link diffIndexedFiles}. */
+export interface IndexedFilesDrift {
+  /** Files indexed but deleted since. */
+  deleted: string[];
+  /** Files whose content changed since they were indexed. */
+  changed: string[];
+  /** Files whose content is the one that was indexed. */
+  unchanged: number;
+}
+
+/**
+ * Compares the files recorded as indexed with the files under `rootDir`, by content hash. A file that
+ * exists but cannot be read is counted as unchanged, since it could not be indexed again anyway.
+ */
+export function diffIndexedFiles(rootDir: string, indexedFiles: IndexedFile[]): IndexedFilesDrift {
+  const drift: IndexedFilesDrift = { deleted: [], changed: [], unchanged: 0 };
+  for (const file of indexedFiles) {
+    const absolutePath = path.resolve(rootDir, file.filePath);
+    if (!fs.existsSync(absolutePath)) {
+      drift.deleted.push(file.filePath);
+      continue;
+    }
+    const sha256 = readContentHash(absolutePath);
+    if (sha256 !== undefined && sha256 !== file.sha256) {
+      drift.changed.push(file.filePath);
+    } else {
+      drift.unchanged++;
+    }
+  }
+  return drift;
+}
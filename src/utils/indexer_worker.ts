@@ -1,51 +1,227 @@
 import { IQueue, QueuedDocument } from './queue';
-import { indexCodeChunks } from './elasticsearch';
-import { logger as defaultLogger, createLogger } from './logger';
+import {
+  bulkIndexPreparedChunks,
+  BulkIndexResult,
+  CodeChunk,
+  deleteStaleLocations,
+  indexCodeChunks,
+  isRejectedExecutionError,
+  prepareCodeChunks,
+} from './elasticsearch';
+import { logger as defaultLogger, Logger, runWithLogContext } from './logger';
 import PQueue from 'p-queue';
 import { SqliteQueue } from './sqlite_queue';
 import { createMetrics, Metrics, createAttributes } from './metrics';
+import { AdaptiveBatchSize, DEFAULT_BULK_MIN_SIZE } from './adaptive_batch_size';
+import { indexingConfig } from '../config';
+import { ProgressReporter } from './progress_reporter';
+import { EmbeddingProvider } from './embedding_provider';
+import { METRIC_STATUS_FAILURE, METRIC_STATUS_SUCCESS } from './constants';
+import { ObservableCallback } from '@opentelemetry/api';
+import { EtaEstimator } from './eta';
+import { isIndexingPaused } from './pause_control';
 
 const POLLING_INTERVAL_MS = 1000; // 1 second
+/** How often the effective bulk size is logged while batches are being indexed. */
+const BULK_SIZE_LOG_INTERVAL_MS = 60 * 1000;
+const MAX_ERROR_MESSAGE_LENGTH = 2000;
 
-type Logger = ReturnType<typeof createLogger>;
+/** Workers whose `start()` has not returned yet, drained on shutdown. */
+const runningWorkers = new Set<IndexerWorker>();
+
+/**
+ * Stops every running worker from dequeuing and waits for their in-flight batches to be indexed and
+ * acknowledged, so a shutdown does not leave claimed documents to be indexed again by the next run.
+ */
+export async function drainIndexerWorkers(): Promise<void> {
+  await Promise.all(Array.from(runningWorkers, (worker) => worker.drain()));
+}
+
+/**
+ * Formats an indexing error (an `Error` or an Elasticsearch bulk item error) for storage in the queue.
+ */
+export function formatIndexingError(error: unknown): string {
+  let message: string;
+  if (error instanceof Error) {
+    message = error.message;
+  } else if (error && typeof error === 'object') {
+    const { type, reason } = error as { type?: unknown; reason?: unknown };
+    const parts = [type, reason].filter((part): part is string => typeof part === 'string');
+    message = parts.length > 0 ? parts.join(': ') : JSON.stringify(error);
+  } else {
+    message = String(error);
+  }
+  return message.length > MAX_ERROR_MESSAGE_LENGTH ? `${message.slice(0, MAX_ERROR_MESSAGE_LENGTH)}…` : message;
+}
 
 export interface IndexerWorkerOptions {
   queue: IQueue;
+  /** Starting bulk batch size. */
   batchSize: number;
+  /** Smallest bulk batch size to shrink to on 429s (default: 10, capped at `bulkMaxSize`). */
+  bulkMinSize?: number;
+  /** Largest bulk batch size to grow back to after sustained success (default: `batchSize`). */
+  bulkMaxSize?: number;
+  /** Batches in flight at once, each embedded and then bulk indexed (default: 1). */
   concurrency?: number;
+  /**
+   * Batches embedded at once. When this or `indexConcurrency` is set together with an
+   * `embeddingProvider`, embedding and bulk requests run as separate stages: up to
+   * `embedConcurrency + indexConcurrency` batches are in flight, and embedded batches wait for a free
+   * bulk slot, so a slow stage holds back dequeuing instead of letting batches pile up. Each defaults
+   * to `concurrency`.
+   */
+  embedConcurrency?: number;
+  /** Batches bulk indexed at once, see `embedConcurrency`. */
+  indexConcurrency?: number;
   watch?: boolean;
   logger?: Logger;
+  /** Receives the number of documents committed after each batch. */
+  progress?: ProgressReporter;
+  /** Computes `code_vector` before each bulk request (default: left to the ingest pipeline). */
+  embeddingProvider?: EmbeddingProvider;
+  /** Share one chunk document between files with identical chunk content (default: true). */
+  dedup?: boolean;
+  /** Store chunk content gzip-compressed in `content_gz`, with an excerpt in `content` (default: false). */
+  storeCompressed?: boolean;
+  /** When false, chunk documents are stored without their content, see `--no-store-content` (default: true). */
+  storeContent?: boolean;
   elasticsearchIndex: string;
   repoInfo?: { name: string; branch: string };
+  /** Shard of the sharded queue this worker drains, added to its gauges so the shards can be summed. */
+  shard?: number;
 }
 
 export class IndexerWorker {
   private queue: IQueue;
-  private batchSize: number;
+  private bulkSize: AdaptiveBatchSize;
+  private bulkTargetLatencyMs: number;
+  private resumeAt = 0;
+  private nextBulkSizeLogAt = 0;
   private concurrency: number;
   private watch: boolean;
   private consumerQueue: PQueue;
+  /** Limit the batches being embedded and bulk indexed when the two run as separate stages. */
+  private embedStage?: PQueue;
+  private indexStage?: PQueue;
   private isRunning = false;
   private elasticsearchIndex: string;
   private logger: Logger;
   private metrics: Metrics;
+  private progress?: ProgressReporter;
+  private embeddingProvider?: EmbeddingProvider;
+  private dedup: boolean;
+  private storeCompressed: boolean;
+  private storeContent: boolean;
+  /** Bytes of the chunk content sent with `storeCompressed`, and of the fields storing it. */
+  private contentBytes = 0;
+  private storedContentBytes = 0;
+  /** Chunks that symbol locations overwritten by this worker referenced before, see `getReplacedChunkIds`. */
+  private readonly replacedChunkIds = new Set<string>();
+  private repoName?: string;
+  private shard?: number;
+  private pruneDisabled = false;
+  private finished: Promise<void> = Promise.resolve();
+  private wakeUp?: () => void;
+  /** Whether the last loop iteration found indexing paused, to log each pause and resume once. */
+  private paused = false;
+  /** Numbers the batches dequeued without a lease id, to tell their log lines apart. */
+  private batchSequence = 0;
+  /** Documents committed since the worker was created, averaged into `indexer.throughput.current`. */
+  private committedCount = 0;
+  private readonly throughput = new EtaEstimator();
 
   constructor(options: IndexerWorkerOptions) {
     this.queue = options.queue;
-    this.batchSize = options.batchSize;
+    const bulkMaxSize = options.bulkMaxSize ?? options.batchSize;
+    this.bulkTargetLatencyMs = indexingConfig.bulkTargetLatencyMs;
+    this.bulkSize = new AdaptiveBatchSize({
+      minSize: options.bulkMinSize ?? Math.min(DEFAULT_BULK_MIN_SIZE, bulkMaxSize),
+      maxSize: bulkMaxSize,
+      initialSize: options.batchSize,
+      baseBackoffMs: indexingConfig.queueRetryBaseDelayMs,
+      maxBackoffMs: indexingConfig.queueRetryMaxDelayMs,
+      targetLatencyMs: this.bulkTargetLatencyMs,
+    });
     this.concurrency = options.concurrency ?? 1;
+    const { embedConcurrency, indexConcurrency } = options;
+    if (options.embeddingProvider && (embedConcurrency !== undefined || indexConcurrency !== undefined)) {
+      this.embedStage = new PQueue({ concurrency: embedConcurrency ?? this.concurrency });
+      this.indexStage = new PQueue({ concurrency: indexConcurrency ?? this.concurrency });
+      this.concurrency = this.embedStage.concurrency + this.indexStage.concurrency;
+    }
     this.watch = options.watch ?? false;
     this.consumerQueue = new PQueue({ concurrency: this.concurrency });
     this.elasticsearchIndex = options.elasticsearchIndex;
-    this.logger = options.logger ?? defaultLogger;
+    this.logger = (options.logger ?? defaultLogger).child({ module: 'worker', workerId: String(process.pid) });
     this.metrics = createMetrics(options.repoInfo);
+    this.progress = options.progress;
+    const { embeddingProvider, progress } = options;
+    if (embeddingProvider) {
+      // Embedding calls are timed on their own, so the run summary can split them from bulk requests.
+      const embed = (texts: string[]) => this.embed(embeddingProvider, texts);
+      this.embeddingProvider = {
+        dimensions: embeddingProvider.dimensions,
+        embed: progress ? (texts) => progress.timePhase('embed', () => embed(texts)) : embed,
+      };
+    }
+    this.dedup = options.dedup ?? true;
+    this.storeCompressed = options.storeCompressed ?? false;
+    this.storeContent = options.storeContent ?? true;
+    this.repoName = options.repoInfo?.name;
+    this.shard = options.shard;
   }
 
   async start(): Promise<void> {
+    const attributes = createAttributes(this.metrics, this.shard !== undefined ? { shard: this.shard } : {});
+    const observeBulkSize: ObservableCallback = (observableResult) =>
+      observableResult.observe(this.bulkSize.size, attributes);
+    // Sampled on collection too, so the rate falls to 0 once nothing is committed for a whole window.
+    const observeThroughput: ObservableCallback = (observableResult) => {
+      this.throughput.record(this.committedCount);
+      observableResult.observe(this.throughput.ratePerSecond, attributes);
+    };
+    this.metrics.indexer?.bulkSizeCurrent.addCallback(observeBulkSize);
+    this.metrics.indexer?.throughputCurrent.addCallback(observeThroughput);
+    this.finished = this.run();
+    runningWorkers.add(this);
+    try {
+      await this.finished;
+      this.logContentStorage();
+    } finally {
+      runningWorkers.delete(this);
+      this.metrics.indexer?.bulkSizeCurrent.removeCallback(observeBulkSize);
+      this.metrics.indexer?.throughputCurrent.removeCallback(observeThroughput);
+    }
+  }
+
+  /** Calls the embedding provider, recording the request and its duration. */
+  private async embed(embeddingProvider: EmbeddingProvider, texts: string[]): Promise<number[][]> {
+    const startTime = Date.now();
+    let status = METRIC_STATUS_FAILURE;
+    try {
+      const vectors = await embeddingProvider.embed(texts);
+      status = METRIC_STATUS_SUCCESS;
+      return vectors;
+    } finally {
+      const attributes = createAttributes(this.metrics, { status });
+      this.metrics.indexer?.embeddingRequests.add(1, attributes);
+      this.metrics.indexer?.embeddingDuration.record(Date.now() - startTime, attributes);
+    }
+  }
+
+  private async run(): Promise<void> {
     this.isRunning = true;
+    // A restarted worker starts from the configured size, not from where the last run left off.
+    this.bulkSize.reset();
+    this.resumeAt = 0;
+    this.nextBulkSizeLogAt = Date.now() + BULK_SIZE_LOG_INTERVAL_MS;
     this.logger.info('IndexerWorker started', {
       concurrency: this.concurrency,
-      batchSize: this.batchSize,
+      ...(this.embedStage && this.indexStage
+        ? { embedConcurrency: this.embedStage.concurrency, indexConcurrency: this.indexStage.concurrency }
+        : {}),
+      batchSize: this.bulkSize.size,
       watch: this.watch,
     });
 
@@ -54,7 +230,8 @@ export class IndexerWorker {
     }
 
     while (this.isRunning) {
-      // Backpressure: Only dequeue a new batch if we have a free worker slot.
+      // Backpressure: Only dequeue a new batch if we have a free worker slot, so at most
+      // `concurrency` batches are held in memory while Elasticsearch is slow.
       // Check both pending (waiting) and active (running) tasks
       const totalActiveTasks = this.consumerQueue.size + this.consumerQueue.pending;
       if (totalActiveTasks >= this.concurrency) {
@@ -63,17 +240,54 @@ export class IndexerWorker {
         continue;
       }
 
-      const documentBatch = await this.queue.dequeue(this.batchSize);
+      // While paused, batches in flight finish but nothing else is claimed, so resuming picks up the
+      // documents still pending in the queue without indexing any twice.
+      if (isIndexingPaused()) {
+        if (!this.paused) {
+          this.paused = true;
+          this.logger.info('Indexing paused: no new batches are dequeued until it is resumed.', {
+            inFlight: totalActiveTasks,
+          });
+        }
+        await this.sleep(POLLING_INTERVAL_MS);
+        continue;
+      }
+      if (this.paused) {
+        this.paused = false;
+        this.logger.info('Indexing resumed.');
+      }
+
+      // Back off after Elasticsearch rejected a bulk request (429) before sending more work.
+      const backoffMs = this.resumeAt - Date.now();
+      if (backoffMs > 0) {
+        await this.sleep(backoffMs);
+        continue;
+      }
+
+      const documentBatch = await this.queue.dequeue(this.bulkSize.size);
 
       if (documentBatch.length > 0) {
-        this.logger.info(`Dequeued batch of ${documentBatch.length} documents. Active tasks: ${totalActiveTasks + 1}`);
+        // The lease id is the one stored in the queue rows, so a batch can be found in the database.
+        const batchId = documentBatch[0].leaseId ?? String(++this.batchSequence);
+        this.logger.info(
+          `Dequeued batch of ${documentBatch.length} documents (bulk size ${this.bulkSize.size}). ` +
+            `Active tasks: ${totalActiveTasks + 1}`,
+          { batchId }
+        );
         // Add the task to the queue. Do not await.
         // p-queue will manage running it concurrently.
-        this.consumerQueue.add(() => this.processBatch(documentBatch));
+        // Every line logged while the batch is indexed, including the Elasticsearch client's, carries its id.
+        this.consumerQueue.add(() =>
+          runWithLogContext({ batchId, workerId: String(process.pid) }, () => this.processBatch(documentBatch))
+        );
+        this.logBulkSize();
       } else {
         if (this.watch) {
+          if (totalActiveTasks === 0) {
+            await this.pruneStaleLocations();
+          }
           // If in watch mode and the queue is empty, wait before polling again.
-          await new Promise((resolve) => setTimeout(resolve, POLLING_INTERVAL_MS));
+          await this.sleep(POLLING_INTERVAL_MS);
         } else {
           // If not in watch mode and dequeue returns empty:
           // - If there are still in-flight tasks, wait for a task to complete and retry.
@@ -83,6 +297,14 @@ export class IndexerWorker {
             await new Promise<void>((resolve) => this.consumerQueue.once('next', resolve));
             continue;
           }
+          // Documents waiting on retry backoff are still pending work; wait for them instead of exiting.
+          const nextAttemptDelayMs = this.queue instanceof SqliteQueue ? this.queue.getNextAttemptDelayMs() : null;
+          if (nextAttemptDelayMs !== null) {
+            this.logger.info(`Waiting ${nextAttemptDelayMs}ms for documents in retry backoff.`);
+            await this.sleep(Math.min(nextAttemptDelayMs, POLLING_INTERVAL_MS));
+            continue;
+          }
+          await this.pruneStaleLocations();
           break;
         }
       }
@@ -99,9 +321,92 @@ export class IndexerWorker {
       return;
     }
     this.isRunning = false;
+    this.wakeUp?.();
     this.logger.info('IndexerWorker stopping...');
   }
 
+  /**
+   * Chunks that symbol locations overwritten by this worker referenced before. Workers of other shards
+   * may reference them too, so they are deleted with `deleteUnreferencedChunks` once all workers finish.
+   */
+  getReplacedChunkIds(): string[] {
+    return Array.from(this.replacedChunkIds);
+  }
+
+  /**
+   * Stops dequeuing and resolves once the batches already in flight are committed or requeued and
+   * the queue state is flushed to disk.
+   */
+  async drain(): Promise<void> {
+    const inFlight = this.consumerQueue.size + this.consumerQueue.pending;
+    if (this.isRunning && inFlight > 0) {
+      this.logger.info(`Draining ${inFlight} in-flight batches before exiting...`);
+    }
+    this.stop();
+    await this.finished;
+    await this.consumerQueue.onIdle();
+    if (this.queue instanceof SqliteQueue) {
+      this.queue.flush();
+    }
+  }
+
+  /**
+   * Deletes the locations that re-indexed files no longer have, once all of their new documents are
+   * indexed. A failure is logged and pruning is skipped for the rest of the run; the files stay
+   * marked, so the next run prunes them.
+   */
+  private async pruneStaleLocations(): Promise<void> {
+    if (this.pruneDisabled || !(this.queue instanceof SqliteQueue)) {
+      return;
+    }
+    const files = this.queue.getPrunableLocationFiles();
+    if (files.size === 0) {
+      return;
+    }
+    // Files re-indexed by symbol keep the locations that were not enqueued again, so they are pruned one by one.
+    const keptLocationIds = this.queue.getKeptLocationIds();
+    const filesByIndexedBefore = new Map<number, string[]>();
+    for (const [filePath, indexedBefore] of files) {
+      if (!keptLocationIds.has(filePath)) {
+        filesByIndexedBefore.set(indexedBefore, [...(filesByIndexedBefore.get(indexedBefore) ?? []), filePath]);
+      }
+    }
+    try {
+      for (const [indexedBefore, filePaths] of filesByIndexedBefore) {
+        await deleteStaleLocations(filePaths, this.elasticsearchIndex, { indexedBefore, repoName: this.repoName });
+      }
+      for (const [filePath, kept] of keptLocationIds) {
+        const indexedBefore = files.get(filePath);
+        if (indexedBefore !== undefined) {
+          await deleteStaleLocations([filePath], this.elasticsearchIndex, {
+            indexedBefore,
+            repoName: this.repoName,
+            keptLocationIds: kept,
+          });
+        }
+      }
+      await this.queue.clearPrunedLocationFiles(files);
+      this.logger.info(`Pruned stale locations of ${files.size} re-indexed files.`);
+    } catch (error) {
+      this.pruneDisabled = true;
+      this.logger.warn('Failed to prune stale locations of re-indexed files; they will be pruned on the next run.', {
+        error: error instanceof Error ? error.message : String(error),
+      });
+    }
+  }
+
+  /** Waits for `ms`, or until the worker is stopped. */
+  private sleep(ms: number): Promise<void> {
+    return new Promise((resolve) => {
+      const timer = setTimeout(() => this.wakeUp?.(), ms);
+      this.wakeUp = () => {
+        clearTimeout(timer);
+        this.wakeUp = undefined;
+        resolve();
+      };
+    });
+  }
+
   private async processBatch(batch: QueuedDocument[]): Promise<boolean> {
     const startTime = Date.now();
     const commonMetricAttributes = createAttributes(this.metrics, {
@@ -111,11 +416,24 @@ export class IndexerWorker {
     let committed: QueuedDocument[] = [];
     let requeued: QueuedDocument[] = [];
 
+    // Keep the batch's lease alive while it is indexed, so stale recovery in another worker does not
+    // claim and index the same documents again.
+    const leaseIds = Array.from(new Set(batch.map((item) => item.leaseId).filter((id): id is string => !!id)));
+    const leaseRenewal =
+      this.queue instanceof SqliteQueue && leaseIds.length > 0
+        ? setInterval(
+            () => this.renewLeases(leaseIds),
+            Math.max(POLLING_INTERVAL_MS, Math.floor(indexingConfig.queueVisibilityTimeoutMs / 3))
+          )
+        : undefined;
+    leaseRenewal?.unref();
+
     try {
-      const codeChunks = batch.map((item) => item.document);
-      const result = await indexCodeChunks(codeChunks, this.elasticsearchIndex);
+      const { result, bulkStartTime } = await this.indexBatch(batch.map((item) => item.document), startTime);
 
       const duration = Date.now() - startTime;
+      // Time spent waiting for a bulk slot is not bulk latency, so it does not shrink the bulk size.
+      const bulkLatency = Date.now() - bulkStartTime;
 
       // Map indexCodeChunks results back to the exact queue rows by input index.
       // Do NOT map by chunk_hash: chunk_hash is not guaranteed unique (content collisions),
@@ -124,21 +442,54 @@ export class IndexerWorker {
         .map((s) => batch[s.inputIndex])
         .filter((doc): doc is QueuedDocument => doc !== undefined);
 
-      const failedDocs = result.failed
-        .map((f) => batch[f.inputIndex])
-        .filter((doc): doc is QueuedDocument => doc !== undefined);
+      const failedDocs: QueuedDocument[] = [];
+      const errors = new Map<string, string>();
+      for (const failure of result.failed) {
+        const doc = batch[failure.inputIndex];
+        if (doc !== undefined) {
+          failedDocs.push(doc);
+          errors.set(doc.id, formatIndexingError(failure.error));
+        }
+      }
 
       // Commit succeeded documents
       if (succeededDocs.length > 0) {
         await this.queue.commit(succeededDocs);
         committed = succeededDocs;
+        this.committedCount += succeededDocs.length;
+        this.throughput.record(this.committedCount);
+        this.progress?.recordIndexed(succeededDocs.length, result.deduplicated, result.versionConflicts);
       }
 
       // Requeue failed documents
       if (failedDocs.length > 0) {
-        await this.queue.requeue(failedDocs);
+        await this.queue.requeue(failedDocs, { errors });
         requeued = failedDocs;
-        this.logger.error(`Requeueing ${failedDocs.length} failed documents from batch of ${batch.length}.`);
+        this.logger.error(`Requeueing ${failedDocs.length} failed documents from batch of ${batch.length}.`, {
+          queueIds: failedDocs.map((doc) => doc.id),
+        });
+      }
+
+      this.contentBytes += result.contentBytes ?? 0;
+      this.storedContentBytes += result.storedContentBytes ?? 0;
+      for (const chunkId of result.replacedChunkIds ?? []) {
+        this.replacedChunkIds.add(chunkId);
+      }
+      if (result.bulkDurationMs !== undefined) {
+        this.metrics.indexer?.bulkDuration.record(result.bulkDurationMs, commonMetricAttributes);
+      }
+      if (result.retried) {
+        this.metrics.indexer?.bulkItemsRetried.add(result.retried, commonMetricAttributes);
+      }
+      if (result.failed.length > 0) {
+        this.metrics.indexer?.bulkItemsFailed.add(result.failed.length, commonMetricAttributes);
+      }
+
+      const rejectedCount = result.failed.filter((failure) => isRejectedExecutionError(failure.error)).length;
+      if (rejectedCount > 0) {
+        this.recordRejection(rejectedCount);
+      } else {
+        this.recordSuccess(bulkLatency);
       }
 
       // Record metrics
@@ -178,9 +529,14 @@ export class IndexerWorker {
         remaining: remaining.length,
       });
 
+      if (isRejectedExecutionError(error)) {
+        this.recordRejection(batch.length);
+      }
+
       if (remaining.length > 0) {
         try {
-          await this.queue.requeue(remaining);
+          const message = formatIndexingError(error);
+          await this.queue.requeue(remaining, { errors: new Map(remaining.map((d) => [d.id, message])) });
           this.logger.warn(`Requeued ${remaining.length} documents after exception.`);
         } catch (requeueError) {
           this.logger.error('Failed to requeue documents after exception; they may remain stuck until stale recovery', {
@@ -191,7 +547,112 @@ export class IndexerWorker {
 
       this.metrics.indexer?.batchFailed.add(1, commonMetricAttributes);
       return false;
+    } finally {
+      clearInterval(leaseRenewal);
+    }
+  }
+
+  /**
+   * Embeds and bulk indexes a batch, in the embedding and bulk stages when they are separate. Returns
+   * the result and when its bulk request started.
+   */
+  private async indexBatch(
+    codeChunks: CodeChunk[],
+    startTime: number
+  ): Promise<{ result: BulkIndexResult; bulkStartTime: number }> {
+    const options = {
+      embeddingProvider: this.embeddingProvider,
+      dedup: this.dedup,
+      storeCompressed: this.storeCompressed,
+      storeContent: this.storeContent,
+    };
+    const timeBulk = (index: () => Promise<BulkIndexResult>) =>
+      this.progress ? this.progress.timePhase('bulk', index) : index();
+
+    const { embedStage, indexStage } = this;
+    if (!embedStage || !indexStage) {
+      const result = await timeBulk(() => indexCodeChunks(codeChunks, this.elasticsearchIndex, options));
+      return { result, bulkStartTime: startTime };
+    }
+    const prepared = await embedStage.add(() => prepareCodeChunks(codeChunks, this.elasticsearchIndex, options));
+    return indexStage.add(async () => {
+      const bulkStartTime = Date.now();
+      const result = await timeBulk(() => bulkIndexPreparedChunks(prepared, options));
+      return { result, bulkStartTime };
+    });
+  }
+
+  private renewLeases(leaseIds: string[]): void {
+    if (!(this.queue instanceof SqliteQueue)) {
+      return;
+    }
+    try {
+      this.queue.renewLeases(leaseIds);
+    } catch (error) {
+      this.logger.warn('Failed to renew the lease of an in-flight batch', {
+        error: error instanceof Error ? error.message : String(error),
+      });
+    }
+  }
+
+  /**
+   * Halves the bulk batch size and pauses dequeuing after Elasticsearch rejected documents with a 429.
+   */
+  private recordRejection(rejectedCount: number): void {
+    const backoffMs = this.bulkSize.recordRejection();
+    this.resumeAt = Math.max(this.resumeAt, Date.now() + backoffMs);
+    this.logger.warn(
+      `Elasticsearch rejected ${rejectedCount} documents (429). Reduced bulk size to ${this.bulkSize.size} ` +
+        `and backing off ${backoffMs}ms.`
+    );
+  }
+
+  /** Grows the bulk batch size after sustained fast batches, or shrinks it after a slow one. */
+  private recordSuccess(durationMs: number): void {
+    const previous = this.bulkSize.size;
+    if (!this.bulkSize.recordSuccess(durationMs)) {
+      return;
+    }
+    if (this.bulkSize.size > previous) {
+      this.logger.info(`Increased bulk size to ${this.bulkSize.size} after sustained success.`);
+    } else {
+      this.logger.info(
+        `Reduced bulk size to ${this.bulkSize.size}: the bulk request took ${durationMs}ms, ` +
+          `above the ${this.bulkTargetLatencyMs}ms target.`
+      );
+    }
+  }
+
+  /**
+   * Logs how much smaller the content of the chunk documents this worker created was stored, so the
+   * saving of `storeCompressed` can be judged per corpus.
+   */
+  private logContentStorage(): void {
+    if (!this.storeCompressed || this.contentBytes === 0) {
+      return;
+    }
+    const percentOfContent = Math.round((this.storedContentBytes / this.contentBytes) * 1000) / 10;
+    this.logger.info(
+      `Stored ${this.contentBytes} bytes of chunk content compressed in ${this.storedContentBytes} bytes ` +
+        `(${percentOfContent}% of the content).`,
+      { contentBytes: this.contentBytes, storedContentBytes: this.storedContentBytes }
+    );
+  }
+
+  /** Logs the effective bulk size and the batches since the last such line, once per interval. */
+  private logBulkSize(): void {
+    const now = Date.now();
+    if (now < this.nextBulkSizeLogAt) {
+      return;
     }
+    this.nextBulkSizeLogAt = now + BULK_SIZE_LOG_INTERVAL_MS;
+    const stats = this.bulkSize.takeStats();
+    const latency = stats.averageLatencyMs === null ? 'unknown' : `${stats.averageLatencyMs}ms`;
+    this.logger.info(
+      `Bulk size ${stats.size}: ${stats.batches} batches since the last report, ${stats.rejected} rejected (429), ` +
+        `average latency ${latency}.`,
+      { bulkSize: stats.size, batches: stats.batches, rejected: stats.rejected, latencyMs: stats.averageLatencyMs }
+    );
   }
 
   async onIdle(): Promise<void> {
@@ -1,4 +1,5 @@
 // src/utils/logger.ts
+import { AsyncLocalStorage } from 'async_hooks';
 import { getLoggerProvider } from './otel_provider';
 import { SeverityNumber } from '@opentelemetry/api-logs';
 import { ATTR_REPO_NAME, ATTR_REPO_BRANCH } from './constants';
@@ -23,32 +24,258 @@ interface RepoInfo {
   branch: string;
 }
 
+/** Console log formats. `pretty` is another name for `text`. */
+export const LOG_FORMATS = ['text', 'json', 'pretty'] as const;
+export type LogFormat = (typeof LOG_FORMATS)[number];
+
+/** Log levels from the most to the least verbose. */
+export const LOG_LEVELS = ['debug', 'info', 'warn', 'error'] as const;
+export type LogLevelName = (typeof LOG_LEVELS)[number];
+
+/** Level of the lines of modules without an override, when `--log-level` does not set one. */
+const DEFAULT_LOG_LEVEL: LogLevelName = 'info';
+
+/** Parts of the pipeline whose level can be overridden, e.g. `--log-level info,queue=warn`. */
+export const LOG_MODULES = ['queue', 'elasticsearch', 'embedding', 'parser', 'worker', 'watch'] as const;
+export type LogModule = (typeof LOG_MODULES)[number];
+
+/**
+ * Fields attached to the lines of a logger, see {@link Logger.child} and {@link runWithLogContext}.
+ * Every field but `module` is logged as metadata.
+ */
+export interface LogContext {
+  module?: LogModule;
+  /** Repository-relative path of the file being processed. */
+  file?: string;
+  /** Batch of documents dequeued and indexed together. */
+  batchId?: string;
+  /** Process id of the indexer, followed by the thread id in a parsing worker thread. */
+  workerId?: string;
+}
+
+/** Level of the lines of each module, as parsed by {@link parseLogLevels}. */
+export interface LogLevels {
+  level: LogLevelName;
+  modules: Partial<Record<LogModule, LogLevelName>>;
+}
+
+/** Pipeline phase a log line belongs to, reported as `phase` in JSON logs. */
+export type LogPhase = 'enqueue' | 'embed' | 'bulk' | 'done';
+
+/** A log line as handed from a worker thread to the main thread, see {@link forwardLogs}. */
+export interface LogEntry {
+  level: LogLevel;
+  message: string;
+  metadata: object;
+  repoInfo?: RepoInfo;
+  timestamp: string;
+  phase?: LogPhase;
+  module?: LogModule;
+}
+
+let currentPhase: LogPhase | undefined;
+let parsedLevels: { spec: string; levels: LogLevels } | undefined;
+const contextStorage = new AsyncLocalStorage<LogContext>();
+let forward: ((entry: LogEntry) => void) | undefined;
+let statusLine: string | undefined;
+const secrets = new Set<string>();
+
+/** Replaces a credential, such as an API key, with `***` wherever it appears in the lines logged from now on. */
+export function addLogSecret(secret: string | undefined): void {
+  if (secret !== undefined && secret.length > 0) {
+    secrets.add(secret);
+  }
+}
+
+function redactSecrets(text: string): string {
+  let redacted = text;
+  for (const secret of secrets) {
+    redacted = redacted.split(secret).join('***');
+  }
+  return redacted;
+}
+
+/**
+ * Parses a `--log-level` value: a comma-separated list of a level for every module and of
+ * `module=level` overrides, such as `info,queue=warn,elasticsearch=debug`.
+ */
+export function parseLogLevels(spec: string): LogLevels {
+  const levels: LogLevels = { level: DEFAULT_LOG_LEVEL, modules: {} };
+  for (const part of spec.split(',')) {
+    const entry = part.trim().toLowerCase();
+    if (entry.length === 0) {
+      continue;
+    }
+    const separator = entry.indexOf('=');
+    const name = separator === -1 ? undefined : entry.slice(0, separator).trim();
+    const level = (separator === -1 ? entry : entry.slice(separator + 1).trim()) as LogLevelName;
+    if (!LOG_LEVELS.includes(level)) {
+      throw new Error(`Unknown log level "${level}". Expected one of: ${LOG_LEVELS.join(', ')}.`);
+    }
+    if (name === undefined) {
+      levels.level = level;
+    } else if (LOG_MODULES.includes(name as LogModule)) {
+      levels.modules[name as LogModule] = level;
+    } else {
+      throw new Error(`Unknown log module "${name}". Expected one of: ${LOG_MODULES.join(', ')}.`);
+    }
+  }
+  return levels;
+}
+
+/** The levels set by `SCS_IDXR_LOG_LEVEL`, or the default ones when it is not set or not valid. */
+function getLogLevels(): LogLevels {
+  const spec = appConfig.logLevel ?? '';
+  if (parsedLevels?.spec !== spec) {
+    let levels: LogLevels;
+    try {
+      levels = parseLogLevels(spec);
+    } catch {
+      levels = parseLogLevels('');
+    }
+    parsedLevels = { spec, levels };
+  }
+  return parsedLevels.levels;
+}
+
+/** Whether lines of `level` logged by `module` are written, see {@link parseLogLevels}. */
+export function isLogLevelEnabled(level: LogLevelName, module?: LogModule): boolean {
+  const levels = getLogLevels();
+  const threshold = (module && levels.modules[module]) ?? levels.level;
+  return LOG_LEVELS.indexOf(level) >= LOG_LEVELS.indexOf(threshold);
+}
+
+/**
+ * Runs `fn` with `context` attached to every line logged until it settles, including by the
+ * functions it calls, so the lines about a batch or a file can be told apart from the others logged
+ * concurrently. Nested contexts are merged; the context of a logger takes precedence.
+ */
+export function runWithLogContext<T>(context: LogContext, fn: () => T): T {
+  return contextStorage.run({ ...contextStorage.getStore(), ...context }, fn);
+}
+
+/** Clears the current terminal line, so the next output replaces the status line. */
+const CLEAR_LINE = '\r\x1b[2K';
+
+/**
+ * Sets the phase attached to subsequent log lines. A `phase` key in a line's metadata overrides it.
+ */
+export function setLogPhase(phase: LogPhase | undefined): void {
+  currentPhase = phase;
+}
+
+/**
+ * Hands every log line of this thread to `send` instead of writing it. Worker threads use it to post
+ * their lines to the main thread, which writes them with {@link writeLogEntry} in its own format.
+ */
+export function forwardLogs(send: ((entry: LogEntry) => void) | undefined): void {
+  forward = send;
+}
+
+/**
+ * Draws `text` on the last terminal line, below the log output, and keeps it there: each log line is
+ * written above it and the status line is redrawn. `undefined` removes it. Only meant for a TTY.
+ */
+export function setStatusLine(text: string | undefined): void {
+  if (text === undefined) {
+    if (statusLine !== undefined) {
+      process.stdout.write(CLEAR_LINE);
+    }
+  } else {
+    process.stdout.write(`${CLEAR_LINE}${text}`);
+  }
+  statusLine = text;
+}
+
+function formatLogLine(entry: LogEntry): string {
+  const hasMetadata = entry.metadata && Object.keys(entry.metadata).length > 0;
+  if (appConfig.logFormat === 'json') {
+    const line = {
+      level: entry.level.toLowerCase(),
+      ts: entry.timestamp,
+      ...(entry.phase ? { phase: entry.phase } : {}),
+      ...(entry.module ? { module: entry.module } : {}),
+      message: entry.message,
+      ...(entry.repoInfo ? { repo: entry.repoInfo.name, branch: entry.repoInfo.branch } : {}),
+    };
+    try {
+      // Counters follow the fixed fields and can never override them.
+      return JSON.stringify(hasMetadata ? { ...line, ...entry.metadata, ...line } : line);
+    } catch {
+      return JSON.stringify({ ...line, metadataError: 'Metadata serialization failed' });
+    }
+  }
+  const moduleTag = entry.module ? ` [${entry.module}]` : '';
+  let logMessage = `[${entry.timestamp}] [${entry.level}]${moduleTag} ${entry.message}`;
+  if (hasMetadata) {
+    try {
+      logMessage += ` ${JSON.stringify(entry.metadata)}`;
+    } catch {
+      logMessage += ' [Metadata serialization failed]';
+    }
+  }
+  return logMessage;
+}
+
 /**
  * Internal logging function that handles both console and OpenTelemetry output.
  *
- * - Outputs text format logs to console (unless NODE_ENV=test)
+ * - Drops lines below the level of their module (see {@link isLogLevelEnabled})
+ * - Outputs text or JSON format logs to console (unless NODE_ENV=test)
  * - Sends structured logs to OpenTelemetry collector if enabled
  * - Attaches repository context and custom metadata to OTel logs
+ * - In a worker thread that forwards its logs, posts the entry to the main thread instead
  *
  * @param level - The log level (INFO, WARN, ERROR, DEBUG).
  * @param message - The log message.
  * @param metadata - Additional metadata to attach to the log entry.
  * @param repoInfo - Optional repository context (name and branch).
+ * @param context - Fields of the logger, added to the ones of {@link runWithLogContext}.
  */
-function log(level: LogLevel, message: string, metadata: object = {}, repoInfo?: RepoInfo) {
+function log(level: LogLevel, message: string, metadata: object = {}, repoInfo?: RepoInfo, context?: LogContext) {
+  const { module, ...fields } = { ...contextStorage.getStore(), ...context };
+  if (!isLogLevelEnabled(level.toLowerCase() as LogLevelName, module)) {
+    return;
+  }
+  const { phase, ...rest } = metadata as { phase?: LogPhase };
+  const entry: LogEntry = {
+    level,
+    message,
+    metadata: { ...fields, ...rest },
+    repoInfo,
+    timestamp: new Date().toISOString(),
+    phase: phase ?? currentPhase,
+    module,
+  };
+  if (forward) {
+    try {
+      forward(entry);
+      return;
+    } catch {
+      // Metadata that cannot be posted to the main thread is written by this thread instead.
+    }
+  }
+  writeLogEntry(entry);
+}
+
+/**
+ * Writes a log entry to the console and OpenTelemetry. Entries forwarded by a worker thread without
+ * a phase get the phase of this thread.
+ */
+export function writeLogEntry(entry: LogEntry): void {
+  const { level, message, metadata, repoInfo, module } = entry;
+  const phase = entry.phase ?? currentPhase;
+
   // Silent mode: skip console output in test environment
   if (appConfig.nodeEnv !== 'test' || appConfig.forceLogging) {
-    // Always output text to console (unless in test mode without SCS_IDXR_FORCE_LOGGING)
-    const timestamp = new Date().toISOString();
-    let logMessage = `[${timestamp}] [${level}] ${message}`;
-    if (metadata && Object.keys(metadata).length > 0) {
-      try {
-        logMessage += ` ${JSON.stringify(metadata)}`;
-      } catch {
-        logMessage += ' [Metadata serialization failed]';
-      }
+    // Always output to console (unless in test mode without SCS_IDXR_FORCE_LOGGING)
+    if (statusLine !== undefined) {
+      process.stdout.write(CLEAR_LINE);
+    }
+    console.log(redactSecrets(formatLogLine({ ...entry, phase })));
+    if (statusLine !== undefined) {
+      process.stdout.write(statusLine);
     }
-    console.log(logMessage);
   }
 
   // Send to OTel if enabled
@@ -59,21 +286,41 @@ function log(level: LogLevel, message: string, metadata: object = {}, repoInfo?:
     const attributes: Record<string, string | number | boolean> = {
       ...(metadata as Record<string, string | number | boolean>),
     };
+    for (const [key, value] of Object.entries(attributes)) {
+      if (typeof value === 'string') {
+        attributes[key] = redactSecrets(value);
+      }
+    }
 
     if (repoInfo) {
       attributes[ATTR_REPO_NAME] = repoInfo.name;
       attributes[ATTR_REPO_BRANCH] = repoInfo.branch;
     }
+    if (phase) {
+      attributes.phase = phase;
+    }
+    if (module) {
+      attributes.module = module;
+    }
 
     logger.emit({
       severityNumber: LOG_LEVEL_TO_SEVERITY[level],
       severityText: level,
-      body: message,
+      body: redactSecrets(message),
       attributes,
     });
   }
 }
 
+export interface Logger {
+  info: (message: string, metadata?: object) => void;
+  warn: (message: string, metadata?: object) => void;
+  error: (message: string, metadata?: object) => void;
+  debug: (message: string, metadata?: object) => void;
+  /** Returns a logger with the same repository whose lines also carry `context`. */
+  child: (context: LogContext) => Logger;
+}
+
 /**
  * Creates a logger instance with optional repository context.
  *
@@ -81,7 +328,8 @@ function log(level: LogLevel, message: string, metadata: object = {}, repoInfo?:
  * If repository information is provided, it will be attached to all log entries from this logger.
  *
  * @param repoInfo - Optional repository context to attach to all logs (name and branch).
- * @returns A logger object with info, warn, error, and debug methods.
+ * @param context - Optional fields to attach to all logs, such as the module whose level applies.
+ * @returns A logger object with info, warn, error, debug and child methods.
  *
  * @example
  * // Create a logger without context
@@ -92,13 +340,18 @@ function log(level: LogLevel, message: string, metadata: object = {}, repoInfo?:
  * // Create a logger with repository context
  * const repoLogger = createLogger({ name: 'kibana', branch: 'main' });
  * repoLogger.info('Processing repository', { fileCount: 42 });
+ *
+ * @example
+ * // Create a logger whose level can be set with `--log-level queue=debug`
+ * const queueLogger = createLogger(undefined, { module: 'queue' });
  */
-export function createLogger(repoInfo?: RepoInfo) {
+export function createLogger(repoInfo?: RepoInfo, context?: LogContext): Logger {
   return {
-    info: (message: string, metadata?: object) => log(LogLevel.INFO, message, metadata, repoInfo),
-    warn: (message: string, metadata?: object) => log(LogLevel.WARN, message, metadata, repoInfo),
-    error: (message: string, metadata?: object) => log(LogLevel.ERROR, message, metadata, repoInfo),
-    debug: (message: string, metadata?: object) => log(LogLevel.DEBUG, message, metadata, repoInfo),
+    info: (message: string, metadata?: object) => log(LogLevel.INFO, message, metadata, repoInfo, context),
+    warn: (message: string, metadata?: object) => log(LogLevel.WARN, message, metadata, repoInfo, context),
+    error: (message: string, metadata?: object) => log(LogLevel.ERROR, message, metadata, repoInfo, context),
+    debug: (message: string, metadata?: object) => log(LogLevel.DEBUG, message, metadata, repoInfo, context),
+    child: (childContext: LogContext) => createLogger(repoInfo, { ...context, ...childContext }),
   };
 }
 
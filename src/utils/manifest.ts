@@ -0,0 +1,154 @@
+import fs from 'fs';
+import path from 'path';
+import { createHash } from 'crypto';
+import { EnqueueResult } from './queue';
+
+export const MANIFEST_FILE_NAME = 'manifest.json';
+const MANIFEST_VERSION = 1;
+
+/**
+ * A single file entry in the index manifest.
+ */
+export interface ManifestEntry {
+  /** SHA-256 of the file content at the time it was enqueued. */
+  sha256: string;
+  /** Number of chunks produced for the file. */
+  chunkCount: number;
+  /** ISO timestamp of when the file's chunks were enqueued. */
+  enqueuedAt: string;
+  /** First SQLite queue row id assigned to the file's chunks (absent when no chunks were produced). */
+  firstDocumentId?: number;
+  /** Last SQLite queue row id assigned to the file's chunks (absent when no chunks were produced). */
+  lastDocumentId?: number;
+}
+
+export interface Manifest {
+  version: number;
+  repoName: string;
+  branch: string;
+  commitHash?: string;
+  updatedAt: string;
+  files: Record<string, ManifestEntry>;
+}
+
+/**
+ * Resolves the manifest path from the `--manifest [path]` option.
+ * `true` (flag without a value) selects `manifest.json` in the queue directory.
+ */
+export function resolveManifestPath(option: string | boolean | undefined, queueDir: string): string | undefined {
+  if (option === undefined || option === false) {
+    return undefined;
+  }
+  if (option === true) {
+    return path.join(queueDir, MANIFEST_FILE_NAME);
+  }
+  return path.resolve(option);
+}
+
+export function hashFileContent(filePath: string): string {
+  return hashContent(fs.readFileSync(filePath));
+}
+
+/** Returns the SHA-256 of content that is not read from disk, as {@link hashFileContent} does for a file. */
+export function hashContent(content: Buffer | string): string {
+  return createHash('sha256').update(content).digest('hex');
+}
+
+/**
+ * Returns the SHA-256 of the file content, or undefined when the file cannot be read.
+ */
+export function readContentHash(filePath: string): string | undefined {
+  try {
+    return hashFileContent(filePath);
+  } catch {
+    return undefined;
+  }
+}
+
+/**
+ * Records (or replaces) the manifest entry for a file that was just enqueued. `sha256` is computed
+ * from the file at `absolutePath` when it is not given.
+ */
+export function recordManifestEntry(
+  manifest: Manifest,
+  relativePath: string,
+  absolutePath: string,
+  chunkCount: number,
+  idRange: EnqueueResult | void,
+  sha256?: string
+): void {
+  manifest.files[relativePath] = {
+    sha256: sha256 ?? hashFileContent(absolutePath),
+    chunkCount,
+    enqueuedAt: new Date().toISOString(),
+    ...(idRange ? { firstDocumentId: idRange.firstId, lastDocumentId: idRange.lastId } : {}),
+  };
+}
+
+export function createManifest(repoName: string, branch: string): Manifest {
+  return {
+    version: MANIFEST_VERSION,
+    repoName,
+    branch,
+    updatedAt: new Date().toISOString(),
+    files: {},
+  };
+}
+
+/**
+ * Loads an existing manifest, or creates an empty one when the file is missing or unreadable.
+ */
+export function loadManifest(manifestPath: string, repoName: string, branch: string): Manifest {
+  if (!fs.existsSync(manifestPath)) {
+    return createManifest(repoName, branch);
+  }
+  try {
+    const parsed = JSON.parse(fs.readFileSync(manifestPath, 'utf8')) as Partial<Manifest>;
+    if (!parsed || typeof parsed.files !== 'object' || parsed.files === null) {
+      return createManifest(repoName, branch);
+    }
+    return {
+      ...createManifest(repoName, branch),
+      commitHash: parsed.commitHash,
+      files: parsed.files,
+    };
+  } catch {
+    return createManifest(repoName, branch);
+  }
+}
+
+/**
+ * Reads the manifest written by an earlier run, keeping the repository and branch it records.
+ *
+ * @throws If the file is missing or is not a manifest.
+ */
+export function readManifest(manifestPath: string): Manifest {
+  if (!fs.existsSync(manifestPath)) {
+    throw new Error(`Manifest not found at ${manifestPath}. Index with --manifest to write one.`);
+  }
+  const parsed = JSON.parse(fs.readFileSync(manifestPath, 'utf8')) as Partial<Manifest> | null;
+  if (!parsed || typeof parsed.files !== 'object' || parsed.files === null) {
+    throw new Error(`${manifestPath} is not an index manifest: it has no "files".`);
+  }
+  return {
+    ...createManifest(parsed.repoName ?? '', parsed.branch ?? ''),
+    ...parsed,
+    files: parsed.files,
+  };
+}
+
+/**
+ * Writes the manifest atomically (write to a temp file, then rename) so readers never see
+ * a partially written file.
+ */
+export function writeManifest(manifestPath: string, manifest: Manifest): void {
+  fs.mkdirSync(path.dirname(manifestPath), { recursive: true });
+  const sortedFiles: Record<string, ManifestEntry> = {};
+  for (const filePath of Object.keys(manifest.files).sort()) {
+    sortedFiles[filePath] = manifest.files[filePath];
+  }
+  const output: Manifest = { ...manifest, updatedAt: new Date().toISOString(), files: sortedFiles };
+  const tempPath = `${manifestPath}.${process.pid}.tmp`;
+  fs.writeFileSync(tempPath, `${JSON.stringify(output, null, 2)}\n`);
+  fs.renameSync(tempPath, manifestPath);
+}
@@ -16,6 +16,7 @@ export interface ParserMetrics {
   filesFailed: Counter;
   chunksCreated: Counter;
   chunksSkipped: Counter;
+  chunksSplit: Counter;
   chunkSize: Histogram;
 }
 
@@ -42,6 +43,13 @@ export interface IndexerMetrics {
   batchFailed: Counter;
   batchDuration: Histogram;
   batchSize: Histogram;
+  bulkItemsRetried: Counter;
+  bulkItemsFailed: Counter;
+  bulkDuration: Histogram;
+  bulkSizeCurrent: ObservableGauge;
+  throughputCurrent: ObservableGauge;
+  embeddingRequests: Counter;
+  embeddingDuration: Histogram;
 }
 
 /**
@@ -107,6 +115,10 @@ export function createMetrics(repoInfo?: RepoInfo): Metrics {
       description: 'Total number of chunks skipped due to size exceeding maxChunkSizeBytes',
       unit: 'chunks',
     }),
+    chunksSplit: meter.createCounter('parser.chunks.split', {
+      description: 'Total number of chunks split into parts because they exceeded the size or token budget',
+      unit: 'chunks',
+    }),
     chunkSize: meter.createHistogram('parser.chunks.size', {
       description: 'Distribution of chunk sizes in bytes',
       unit: 'bytes',
@@ -171,6 +183,34 @@ export function createMetrics(repoInfo?: RepoInfo): Metrics {
       description: 'Distribution of batch sizes',
       unit: 'documents',
     }),
+    bulkItemsRetried: meter.createCounter('indexer.bulk.items.retried', {
+      description: 'Total number of bulk items resent after a 429/503 item failure',
+      unit: 'documents',
+    }),
+    bulkItemsFailed: meter.createCounter('indexer.bulk.items.failed', {
+      description: 'Total number of bulk items that still failed after item retries',
+      unit: 'documents',
+    }),
+    bulkDuration: meter.createHistogram('indexer.bulk.duration', {
+      description: 'Elasticsearch bulk request time in milliseconds, including item retries',
+      unit: 'milliseconds',
+    }),
+    bulkSizeCurrent: meter.createObservableGauge('indexer.bulk.size.current', {
+      description: 'Current number of chunks per bulk request, as adapted to the cluster',
+      unit: 'documents',
+    }),
+    throughputCurrent: meter.createObservableGauge('indexer.throughput.current', {
+      description: 'Documents indexed per second, averaged over the last minute',
+      unit: 'documents/s',
+    }),
+    embeddingRequests: meter.createCounter('indexer.embedding.requests', {
+      description: 'Total number of requests to the embedding provider',
+      unit: 'requests',
+    }),
+    embeddingDuration: meter.createHistogram('indexer.embedding.duration', {
+      description: 'Embedding request time in milliseconds',
+      unit: 'milliseconds',
+    }),
   };
 
   return {
@@ -1,7 +1,12 @@
 // src/utils/otel_provider.ts
 import { LoggerProvider, BatchLogRecordProcessor } from '@opentelemetry/sdk-logs';
 import { OTLPLogExporter } from '@opentelemetry/exporter-logs-otlp-http';
-import { MeterProvider, PeriodicExportingMetricReader, AggregationTemporality } from '@opentelemetry/sdk-metrics';
+import {
+  MeterProvider,
+  MetricReader,
+  PeriodicExportingMetricReader,
+  AggregationTemporality,
+} from '@opentelemetry/sdk-metrics';
 import { OTLPMetricExporter } from '@opentelemetry/exporter-metrics-otlp-http';
 import { Resource, envDetectorSync } from '@opentelemetry/resources';
 import { ATTR_SERVICE_NAME, ATTR_SERVICE_VERSION } from '@opentelemetry/semantic-conventions';
@@ -35,6 +40,8 @@ if (diagLevel !== DiagLogLevel.NONE) {
 
 let loggerProvider: LoggerProvider | null = null;
 let meterProvider: MeterProvider | null = null;
+// Readers added by `addMetricReader`, such as the Prometheus endpoint, on top of the OTLP export.
+let additionalMetricReaders: MetricReader[] = [];
 
 function buildOtlpSignalUrl(endpoint: string, signalPath: '/v1/logs' | '/v1/metrics'): string {
   const trimmed = endpoint.trim();
@@ -181,16 +188,17 @@ export function getLoggerProvider(): LoggerProvider | null {
  *
  * Creates a MeterProvider configured with:
  * - Resource attributes (auto-detected + custom service info)
- * - OTLP HTTP exporter for sending metrics to a collector
+ * - OTLP HTTP exporter for sending metrics to a collector, when SCS_IDXR_OTEL_METRICS_ENABLED is true
  * - Periodic metric reader for scheduled metric export
+ * - The readers registered with `addMetricReader`
  *
  * Respects indexer OpenTelemetry environment variables:
  * - OTEL_RESOURCE_ATTRIBUTES: Additional resource attributes
  *
- * @returns The MeterProvider instance if SCS_IDXR_OTEL_METRICS_ENABLED is true, otherwise null.
+ * @returns The MeterProvider instance if SCS_IDXR_OTEL_METRICS_ENABLED is true or a reader was added, otherwise null.
  */
 export function getMeterProvider(): MeterProvider | null {
-  if (!otelConfig.metricsEnabled) {
+  if (!otelConfig.metricsEnabled && additionalMetricReaders.length === 0) {
     return null;
   }
 
@@ -208,30 +216,46 @@ export function getMeterProvider(): MeterProvider | null {
 
   const resource = createResource(defaultAttributes);
 
-  const metricsEndpoint = otelConfig.metricsEndpoint;
-  const metricsHeaders = otelConfig.headers;
-
-  const exporter = new OTLPMetricExporter({
-    url: buildOtlpSignalUrl(metricsEndpoint, '/v1/metrics'),
-    headers: parseHeaders(metricsHeaders),
-    // Configure Delta temporality for Elasticsearch compatibility
-    // Elasticsearch exporter only supports Delta temporality for histograms
-    temporalityPreference: AggregationTemporality.DELTA,
-  });
-
-  const metricReader = new PeriodicExportingMetricReader({
-    exporter,
-    exportIntervalMillis: otelConfig.metricExportIntervalMs,
-  });
+  const readers: MetricReader[] = [...additionalMetricReaders];
+  if (otelConfig.metricsEnabled) {
+    const metricsEndpoint = otelConfig.metricsEndpoint;
+    const metricsHeaders = otelConfig.headers;
+
+    const exporter = new OTLPMetricExporter({
+      url: buildOtlpSignalUrl(metricsEndpoint, '/v1/metrics'),
+      headers: parseHeaders(metricsHeaders),
+      // Configure Delta temporality for Elasticsearch compatibility
+      // Elasticsearch exporter only supports Delta temporality for histograms
+      temporalityPreference: AggregationTemporality.DELTA,
+    });
+
+    readers.push(
+      new PeriodicExportingMetricReader({
+        exporter,
+        exportIntervalMillis: otelConfig.metricExportIntervalMs,
+      })
+    );
+  }
 
   meterProvider = new MeterProvider({
     resource,
-    readers: [metricReader],
+    readers,
   });
 
   return meterProvider;
 }
 
+/**
+ * Registers an additional metric reader, so metrics are recorded even when the OTLP export is disabled.
+ * The reader is shut down together with the MeterProvider.
+ *
+ * @param reader - The reader to attach to the current and any future MeterProvider.
+ */
+export function addMetricReader(reader: MetricReader): void {
+  additionalMetricReaders.push(reader);
+  meterProvider?.addMetricReader(reader);
+}
+
 /**
  * Gracefully shuts down the OpenTelemetry LoggerProvider and MeterProvider.
  *
@@ -251,7 +275,11 @@ export async function shutdown(): Promise<void> {
   if (meterProvider) {
     promises.push(meterProvider.shutdown());
     meterProvider = null;
+  } else {
+    // Readers that were never attached to a provider still hold their resources, e.g. a listening server.
+    promises.push(...additionalMetricReaders.map((reader) => reader.shutdown()));
   }
+  additionalMetricReaders = [];
 
   await Promise.all(promises);
 }
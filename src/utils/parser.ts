@@ -1,13 +1,12 @@
 // src/utils/parser.ts
 import Parser from 'tree-sitter';
-import fs from 'fs';
 import path from 'path';
 import { createHash } from 'crypto';
 import { execFileSync } from 'child_process';
 import { languageConfigurations, parseLanguageNames } from '../languages';
-import { CodeChunk, SymbolInfo, ExportInfo } from './elasticsearch';
+import { CodeChunk, SymbolInfo, ExportInfo, ReferenceInfo } from './elasticsearch';
 import { indexingConfig } from '../config';
-import { logger } from './logger';
+import { createLogger } from './logger';
 import {
   CHUNK_TYPE_CODE,
   CHUNK_TYPE_DOC,
@@ -23,10 +22,18 @@ import {
   PARSER_TYPE_TEXT,
   PARSER_TYPE_HANDLEBARS,
   PARSER_TYPE_TREE_SITTER,
+  PARSER_TYPE_WHOLE_FILE,
 } from './constants';
 import { isSharedExtensionAllowed } from './shared_extensions';
+import { ExtensionMap, findExtensionRule, SKIP_PARSER } from './extension_map';
+import { CHUNK_KIND_FILE, ChunkGranularity, ChunkGranularityMap, DEFAULT_CHUNK_GRANULARITY } from './chunk_granularity';
+import { getSymbolKind, isSymbolKindIncluded, SymbolKindFilter } from './symbol_kinds';
+import { readTextFile } from './file_encoding';
+import { estimateTokenCount, Tokenizer } from './tokenizer';
+import { buildChunkPreview } from './chunk_preview';
 
 const { Query } = Parser;
+const logger = createLogger(undefined, { module: 'parser' });
 
 function getGitRoot(cwd: string): string {
   // When running inside git hooks (e.g. husky), git may set GIT_DIR/GIT_WORK_TREE
@@ -78,6 +85,23 @@ function createChunkHash(params: {
   return createHash('sha256').update(stableId).digest('hex');
 }
 
+/**
+ * Sets `symbol_occurrence` on the chunks of a file that repeat the qualified name, kind and window
+ * of an earlier chunk, such as overloads, so each keeps a location document of its own.
+ */
+function numberSymbolOccurrences(chunks: CodeChunk[]): CodeChunk[] {
+  const seen = new Map<string, number>();
+  return chunks.map((chunk) => {
+    if (!chunk.symbol_fqn) {
+      return chunk;
+    }
+    const key = [chunk.kind ?? '', chunk.symbol_fqn, chunk.chunkIndex ?? ''].join(':');
+    const occurrence = (seen.get(key) ?? 0) + 1;
+    seen.set(key, occurrence);
+    return occurrence > 1 ? { ...chunk, symbol_occurrence: occurrence } : chunk;
+  });
+}
+
 /**
  * Extracts directory information from a file path.
  * @param filePath The relative file path
@@ -116,6 +140,10 @@ export interface LanguageConfiguration {
   exportQueries?: string[];
 }
 
+/** What happens to a file that produces more chunks than `maxChunksPerFile`, see `--chunk-overflow`. */
+export const CHUNK_OVERFLOW_ACTIONS = ['skip', 'whole-file'] as const;
+export type ChunkOverflowAction = (typeof CHUNK_OVERFLOW_ACTIONS)[number];
+
 export interface ParseResult {
   chunks: CodeChunk[];
   metrics: {
@@ -123,10 +151,33 @@ export interface ParseResult {
     filesFailed: number;
     chunksCreated: number;
     chunksSkipped: number;
+    /** Tree-sitter chunks that exceeded the size or token budget and were split into parts. */
+    chunksSplit: number;
     chunkSizes: number[];
     language: string;
     parserType: string;
   };
+  /** Set when the file failed to parse and `chunks` is its whole-file fallback chunk, see `wholeFileFallback`. */
+  parseError?: string;
+  /**
+   * Set when the file produced more chunks than `maxChunksPerFile`: their number, and whether the file
+   * was skipped (`chunks` is empty) or indexed as one whole-file chunk.
+   */
+  chunkOverflow?: { chunks: number; action: ChunkOverflowAction };
+}
+
+/**
+ * Content of a file that is not read from disk, such as an archive entry, see `LanguageParser.parseFile`.
+ */
+export interface InMemoryFile {
+  content: string;
+  /** Directory that relative imports are resolved against, in place of the file's git root. */
+  rootDir: string;
+}
+
+/** Returns the id git gives `content` as a blob, as `git hash-object` does for a file. */
+function hashGitBlob(content: string): string {
+  return createHash('sha1').update(`blob ${Buffer.byteLength(content, 'utf8')}\0`).update(content).digest('hex');
 }
 
 interface FileMetadata {
@@ -148,6 +199,501 @@ interface ChunkParams {
   timestamp: string;
 }
 
+export interface LanguageParserOptions {
+  /**
+   * Lines of trailing context taken from the following sibling of each tree-sitter chunk and
+   * stored in the chunk's `overlap` field. Defaults to 0 (no overlap).
+   */
+  chunkOverlapLines?: number;
+  /** Prepend each chunk's leading doc comment to its `semantic_text`. Defaults to false. */
+  embedDocComments?: boolean;
+  /**
+   * Prepend each tree-sitter chunk's package, method receiver type and file imports to its
+   * `semantic_text`. Defaults to false.
+   */
+  embedContext?: boolean;
+  /**
+   * Tree-sitter chunks whose token count exceeds this are split into line-aligned parts.
+   * Defaults to 0 (disabled).
+   */
+  maxChunkTokens?: number;
+  /**
+   * Counts the tokens of chunks for `maxChunkTokens`, and stores each chunk's count as `token_count`.
+   * Without one, tokens are estimated from the length and no count is stored.
+   */
+  tokenizer?: Tokenizer;
+  /** Routes file suffixes to languages or skips them, ahead of the suffixes registered by each language. */
+  extensionMap?: ExtensionMap;
+  /** Chunk granularity per language name. Languages without an entry are chunked per symbol. */
+  chunkGranularity?: ChunkGranularityMap;
+  /** Symbol kinds to chunk, see {@link SymbolKindFilter}. Defaults to every kind. */
+  symbolKinds?: SymbolKindFilter;
+  /**
+   * Index a file that fails to parse as one whole-file chunk, reported through `ParseResult.parseError`,
+   * instead of throwing. Defaults to false.
+   */
+  wholeFileFallback?: boolean;
+  /**
+   * A file producing more chunks than this is skipped or indexed as one whole-file chunk, as
+   * `chunkOverflow` says, and reported through `ParseResult.chunkOverflow`. Defaults to 0 (no limit).
+   */
+  maxChunksPerFile?: number;
+  /** Defaults to `skip`. */
+  chunkOverflow?: ChunkOverflowAction;
+  /**
+   * Lines of each chunk stored in its `preview` field, with the signature of a function or method
+   * that starts below them. Defaults to 0 (no preview).
+   */
+  previewLines?: number;
+}
+
+/**
+ * Capture names of declarations that can carry a leading doc comment. A node qualifies when any query
+ * captures it under one of these names, including the inner capture of a `*_with_doc` pattern.
+ */
+const DOC_COMMENT_CAPTURES = new Set([
+  'function',
+  'method',
+  'class',
+  'interface',
+  'type',
+  'struct',
+  'enum',
+  'union',
+  'trait',
+  'impl',
+  'module',
+  'namespace',
+  'template',
+  'object',
+  'variable',
+  'const',
+  'static',
+  'macro',
+  'define',
+  'export',
+  'table',
+  'view',
+]);
+
+/** Bodies between a member and the declaration it belongs to, such as Java and Kotlin class bodies. */
+const CONTAINER_BODY_TYPES = new Set([
+  'class_body',
+  'interface_body',
+  'enum_body',
+  'enum_body_declarations',
+  'enum_class_body',
+]);
+
+/** Declarations whose name qualifies the members in their body, see {@link CONTAINER_BODY_TYPES}. */
+const CONTAINER_TYPES = new Set([
+  'class_declaration',
+  'function_declaration',
+  'class_definition',
+  'interface_declaration',
+  'enum_declaration',
+  'object_declaration',
+]);
+
+/** Nodes allowed between a doc comment and the declaration it documents (e.g. Rust `#[derive(...)]`). */
+const DOC_COMMENT_TRANSPARENT_TYPES = new Set(['attribute_item']);
+
+function isCommentNode(node: Parser.SyntaxNode): boolean {
+  return node.type.includes('comment');
+}
+
+/** Last row of a node. Some grammars end line comments at column 0 of the following row. */
+function getLastRow(node: Parser.SyntaxNode): number {
+  const { row, column } = node.endPosition;
+  return column === 0 && row > node.startPosition.row ? row - 1 : row;
+}
+
+/** Returns the docstring of a Python function or class: a string literal as the first body statement. */
+function getPythonDocstring(node: Parser.SyntaxNode): string | undefined {
+  if (node.type !== 'function_definition' && node.type !== 'class_definition') {
+    return undefined;
+  }
+  const firstStatement = node.childForFieldName('body')?.firstNamedChild;
+  const literal = firstStatement?.type === 'expression_statement' ? firstStatement.firstNamedChild : null;
+  return literal?.type === 'string' ? literal.text : undefined;
+}
+
+/**
+ * Returns the `decorated_definition` wrapping a Python definition, so chunks include decorators such
+ * as `@app.route(...)`. Any other node is returned unchanged.
+ */
+function withDecorators(node: Parser.SyntaxNode): Parser.SyntaxNode {
+  const parent = node.parent;
+  const isDecorated =
+    parent?.type === 'decorated_definition' &&
+    parent.childForFieldName('definition')?.startIndex === node.startIndex;
+  return isDecorated && parent ? parent : node;
+}
+
+/**
+ * Returns the dotted names of the Python classes and functions whose bodies contain a definition,
+ * outermost first (e.g. `Outer.Inner` for a method of a nested class), so methods and nested
+ * functions are linked to their enclosing symbol.
+ */
+function getPythonEnclosingName(node: Parser.SyntaxNode): string {
+  const names: string[] = [];
+  let current = node;
+  while (current.type === 'function_definition' || current.type === 'class_definition') {
+    const body = withDecorators(current).parent;
+    const owner = body?.type === 'block' ? body.parent : null;
+    if (!owner || (owner.type !== 'function_definition' && owner.type !== 'class_definition')) {
+      break;
+    }
+    names.unshift(owner.childForFieldName('name')?.text ?? '');
+    current = owner;
+  }
+  return names.filter(Boolean).join('.');
+}
+
+/** Symbol captures that name a definition, as opposed to a call or usage. */
+const DEFINITION_SYMBOL_KINDS = new Set([
+  'function.name',
+  'method.name',
+  'class.name',
+  'interface.name',
+  'type.name',
+  'struct.name',
+  'enum.name',
+  'union.name',
+  'trait.name',
+  'object.name',
+  'namespace.name',
+  'macro.name',
+  'table.name',
+  'view.name',
+]);
+
+/** Symbol captures that reference another symbol, mapped to the kind of reference they record. */
+const REFERENCE_CAPTURE_KINDS: Record<string, ReferenceInfo['kind']> = {
+  'function.call': 'function',
+  'method.call': 'method',
+  'macro.call': 'macro',
+  'class.instantiation': 'type',
+  'struct.instantiation': 'type',
+  'type.embedded': 'type',
+};
+
+/** Fields holding the receiver of a method call across grammars (Go, Rust/Scala, C++, Java). */
+const RECEIVER_FIELDS = ['operand', 'value', 'argument', 'object'];
+
+const IDENTIFIER_PATTERN = /^[A-Za-z_$][\w$]*$/;
+
+/** Go nodes whose parameters and body declare the variables a method call can be made on. */
+const GO_FUNCTION_TYPES = new Set(['function_declaration', 'method_declaration', 'func_literal']);
+
+/** Strips the pointer and type arguments of a Go type (`*Greeter[T]` is `Greeter`). */
+function getGoTypeName(text: string): string {
+  return text.replace(/^\*/, '').replace(/\[.*\]$/, '');
+}
+
+/**
+ * Returns the type a Go expression is statically known to have: composite literals (`Greeter{}`,
+ * `&Greeter{}`) and `new(Greeter)`. Anything else, such as a constructor call, is not resolved.
+ */
+function getGoExpressionType(node: Parser.SyntaxNode): string | undefined {
+  if (node.type === 'unary_expression' && node.childForFieldName('operator')?.text === '&') {
+    const operand = node.childForFieldName('operand');
+    return operand ? getGoExpressionType(operand) : undefined;
+  }
+  if (node.type === 'composite_literal') {
+    const type = node.childForFieldName('type');
+    return type ? getGoTypeName(type.text) : undefined;
+  }
+  if (node.type === 'call_expression' && node.childForFieldName('function')?.text === 'new') {
+    const type = node.childForFieldName('arguments')?.namedChildren[0];
+    return type ? getGoTypeName(type.text) : undefined;
+  }
+  return undefined;
+}
+
+/** Returns the closest Go function, method or function literal containing `node`. */
+function getGoEnclosingFunction(node: Parser.SyntaxNode): Parser.SyntaxNode | null {
+  let current = node.parent;
+  while (current && !GO_FUNCTION_TYPES.has(current.type)) {
+    current = current.parent;
+  }
+  return current;
+}
+
+/**
+ * Returns the type of the Go variable `name` as seen from `node`, looking at the parameters and the
+ * declarations before `node` in each enclosing function, then at package-level `var` declarations.
+ * The last declaration wins, so a variable redeclared with `:=` resolves to its latest type.
+ */
+function getGoVariableType(node: Parser.SyntaxNode, name: string): string | undefined {
+  const typeOfSpec = (spec: Parser.SyntaxNode): string | undefined => {
+    const names = spec.childrenForFieldName('name');
+    const index = names.findIndex((child) => child.text === name);
+    if (index === -1) {
+      return undefined;
+    }
+    const type = spec.childForFieldName('type');
+    if (type) {
+      return getGoTypeName(type.text);
+    }
+    const value = spec.childForFieldName('value')?.namedChildren[index];
+    return value ? getGoExpressionType(value) : undefined;
+  };
+
+  for (let scope = getGoEnclosingFunction(node); scope; scope = getGoEnclosingFunction(scope)) {
+    let resolved: string | undefined;
+    let declared = false;
+    for (const declaration of scope.descendantsOfType(['short_var_declaration', 'var_spec'])) {
+      const isInScope = getGoEnclosingFunction(declaration)?.startIndex === scope.startIndex;
+      if (declaration.startIndex >= node.startIndex || !isInScope) {
+        continue;
+      }
+      if (declaration.type === 'var_spec') {
+        if (declaration.childrenForFieldName('name').some((child) => child.text === name)) {
+          declared = true;
+          resolved = typeOfSpec(declaration);
+        }
+        continue;
+      }
+      const names = declaration.childForFieldName('left')?.namedChildren ?? [];
+      const index = names.findIndex((child) => child.text === name);
+      if (index !== -1) {
+        declared = true;
+        const value = declaration.childForFieldName('right')?.namedChildren[index];
+        resolved = value ? getGoExpressionType(value) : undefined;
+      }
+    }
+    if (declared) {
+      return resolved;
+    }
+    const parameters = [scope.childForFieldName('receiver'), scope.childForFieldName('parameters')];
+    for (const parameter of parameters.flatMap((list) => list?.namedChildren ?? [])) {
+      if (parameter.childrenForFieldName('name').some((child) => child.text === name)) {
+        const type = parameter.childForFieldName('type');
+        return type ? getGoTypeName(type.text) : undefined;
+      }
+    }
+  }
+
+  let root = node;
+  while (root.parent) {
+    root = root.parent;
+  }
+  for (const spec of root.descendantsOfType('var_spec')) {
+    if (!getGoEnclosingFunction(spec) && spec.childrenForFieldName('name').some((child) => child.text === name)) {
+      return typeOfSpec(spec);
+    }
+  }
+  return undefined;
+}
+
+/**
+ * Builds the reference recorded for a call or instantiation capture. Go and Rust capture the whole
+ * selector of a method call (`g.Greet`), the other grammars only the method name. For Go, the type
+ * of the receiver is recorded too when it can be read from the declaration of the receiver variable.
+ */
+function getReference(node: Parser.SyntaxNode, kind: ReferenceInfo['kind'], language: string): ReferenceInfo {
+  if (kind !== 'method') {
+    return { name: node.text, kind };
+  }
+  const field = node.childForFieldName('field');
+  const owner = field ? node : node.parent;
+  const receiver = RECEIVER_FIELDS.map((name) => owner?.childForFieldName(name)).find(Boolean)?.text;
+  if (!receiver || !IDENTIFIER_PATTERN.test(receiver)) {
+    return { name: (field ?? node).text, kind };
+  }
+  const receiverType = language === 'go' ? getGoVariableType(node, receiver) : undefined;
+  return {
+    name: (field ?? node).text,
+    kind,
+    receiver,
+    ...(receiverType ? { receiver_type: receiverType } : {}),
+  };
+}
+
+/** Returns the package a file declares (Go `package`, Java, Kotlin and Scala `package` clauses), if any. */
+function getPackageName(root: Parser.SyntaxNode): string {
+  const clause = root.namedChildren.find(
+    (child) =>
+      child.type === 'package_clause' || child.type === 'package_declaration' || child.type === 'package_header'
+  );
+  return clause?.namedChildren.find((child) => child.type.includes('identifier'))?.text ?? '';
+}
+
+/**
+ * Formats the file context embedded with a chunk by `embedContext`, or returns undefined when there
+ * is none (e.g. a file without a package declaration or imports).
+ */
+function formatEmbeddedContext(context: {
+  packageName: string;
+  receiverType: string;
+  imports: string[];
+}): string | undefined {
+  const lines: string[] = [];
+  if (context.packageName) {
+    lines.push(`package: ${context.packageName}`);
+  }
+  if (context.receiverType) {
+    lines.push(`receiver: ${context.receiverType}`);
+  }
+  if (context.imports.length > 0) {
+    lines.push(`imports: ${context.imports.join(', ')}`);
+  }
+  return lines.length > 0 ? lines.join('\n') : undefined;
+}
+
+/** Returns the receiver type of a Go method declaration (`Greeter` for `func (g *Greeter) Greet()`). */
+function getReceiverTypeName(node: Parser.SyntaxNode): string {
+  const receiver = node.childForFieldName('receiver');
+  const parameter = receiver?.namedChildren.find((child) => child.type === 'parameter_declaration');
+  return getGoTypeName(parameter?.childForFieldName('type')?.text ?? '');
+}
+
+/**
+ * Returns the names of the symbols enclosing a definition, outermost first: the classes, functions
+ * and Rust `impl` blocks whose bodies contain it, or the receiver type of a Go method.
+ */
+function getEnclosingSymbolNames(definition: Parser.SyntaxNode, receiverType: string): string[] {
+  const names: string[] = [];
+  for (let ancestor = definition.parent; ancestor; ancestor = ancestor.parent) {
+    let nameNode: Parser.SyntaxNode | null | undefined;
+    if (ancestor.type === 'impl_item') {
+      nameNode = ancestor.childForFieldName('type');
+    } else if (getSymbolKind(ancestor) !== undefined) {
+      // Kotlin declarations have no name field, see CONTAINER_TYPES.
+      nameNode =
+        ancestor.childForFieldName('name') ??
+        (CONTAINER_TYPES.has(ancestor.type)
+          ? ancestor.namedChildren.find((child) => child.type === 'identifier' || child.type === 'type_identifier')
+          : undefined);
+    }
+    if (nameNode) {
+      names.unshift(nameNode.text);
+    }
+  }
+  return names.length === 0 && receiverType ? [receiverType] : names;
+}
+
+/**
+ * Returns the contiguous comment block immediately preceding a declaration (or the Python docstring).
+ *
+ * Only comments on the lines directly above the declaration count: a blank line ends the block, so a
+ * license header or an unrelated comment further up is never attributed to the symbol. A comment
+ * that starts on the same line as earlier code is a trailing comment of that code and is dropped.
+ */
+function getLeadingDocComment(node: Parser.SyntaxNode, languageName: string): string | undefined {
+  if (languageName === 'python') {
+    const docstring = getPythonDocstring(node);
+    if (docstring) {
+      return docstring;
+    }
+  }
+
+  // Comments precede the outermost node starting on the symbol's line (e.g. `export`), or its decorators.
+  let anchor = node;
+  while (
+    anchor.parent?.parent &&
+    (anchor.parent.startPosition.row === anchor.startPosition.row || anchor.parent.type === 'decorated_definition')
+  ) {
+    anchor = anchor.parent;
+  }
+
+  const comments: Parser.SyntaxNode[] = [];
+  let nextRow = anchor.startPosition.row;
+  let sibling = anchor.previousNamedSibling;
+  while (sibling && getLastRow(sibling) === nextRow - 1) {
+    if (isCommentNode(sibling)) {
+      comments.unshift(sibling);
+    } else if (!DOC_COMMENT_TRANSPARENT_TYPES.has(sibling.type)) {
+      break;
+    }
+    nextRow = sibling.startPosition.row;
+    sibling = sibling.previousNamedSibling;
+  }
+
+  // `previousSibling` includes punctuation, so a comment after an opening `{` is treated as trailing too.
+  const beforeFirst = comments[0]?.previousSibling;
+  if (beforeFirst && getLastRow(beforeFirst) === comments[0].startPosition.row) {
+    comments.shift();
+  }
+  return comments.length > 0 ? comments.map((comment) => comment.text).join('\n') : undefined;
+}
+
+/**
+ * Builds the trailing overlap for a tree-sitter chunk from its next named sibling.
+ *
+ * The overlap is at most `maxLines` lines, never reaches past the end of the file, and always
+ * stops one line short of the sibling's last line so an entire adjacent symbol is never copied.
+ */
+function getSiblingOverlap(node: Parser.SyntaxNode, sourceLines: string[], maxLines: number): string | undefined {
+  const sibling = node.nextNamedSibling;
+  if (maxLines <= 0 || !sibling) {
+    return undefined;
+  }
+  const startRow = sibling.startPosition.row;
+  const siblingLineCount = sibling.endPosition.row - startRow + 1;
+  const lineCount = Math.min(maxLines, siblingLineCount - 1, sourceLines.length - startRow);
+  if (lineCount <= 0) {
+    return undefined;
+  }
+  return sourceLines.slice(startRow, startRow + lineCount).join('\n');
+}
+
+interface ChunkWindow {
+  content: string;
+  /** First line of the window, relative to the start of the split text (0-based). */
+  firstLine: number;
+  /** Last line of the window, relative to the start of the split text (0-based, inclusive). */
+  lastLine: number;
+  /** Character offset of the window within the split text. */
+  offset: number;
+}
+
+/**
+ * Splits text into line-aligned windows of at most `maxChars` characters. Each window after the
+ * first starts with enough trailing lines of the previous window to repeat at least `overlapChars`
+ * characters, while always advancing by at least one line. A single line longer than `maxChars`
+ * becomes its own window.
+ */
+function splitIntoWindows(text: string, maxChars: number, overlapChars: number): ChunkWindow[] {
+  const lines = text.split('\n');
+  const lineOffsets: number[] = [];
+  let offset = 0;
+  for (const line of lines) {
+    lineOffsets.push(offset);
+    offset += line.length + 1;
+  }
+
+  const windows: ChunkWindow[] = [];
+  let first = 0;
+  while (first < lines.length) {
+    let last = first;
+    let size = lines[first].length;
+    while (last + 1 < lines.length && size + 1 + lines[last + 1].length <= maxChars) {
+      last++;
+      size += 1 + lines[last].length;
+    }
+    windows.push({
+      content: lines.slice(first, last + 1).join('\n'),
+      firstLine: first,
+      lastLine: last,
+      offset: lineOffsets[first],
+    });
+    if (last + 1 >= lines.length) {
+      break;
+    }
+
+    let next = last + 1;
+    let repeated = 0;
+    while (next - 1 > first && repeated < overlapChars) {
+      next--;
+      repeated += lines[next].length + 1;
+    }
+    first = next;
+  }
+  return windows;
+}
+
 /**
  * Base structure for parser metric data
  */
@@ -156,6 +702,7 @@ const BASE_PARSER_METRIC_DATA: ParseResult['metrics'] = {
   filesFailed: 0,
   chunksCreated: 0,
   chunksSkipped: 0,
+  chunksSplit: 0,
   chunkSizes: [],
   language: '',
   parserType: '',
@@ -164,8 +711,30 @@ const BASE_PARSER_METRIC_DATA: ParseResult['metrics'] = {
 export class LanguageParser {
   private languages: Map<string, LanguageConfiguration>;
   public fileSuffixMap: Map<string, LanguageConfiguration>;
-
-  constructor(languages?: string) {
+  private chunkOverlapLines: number;
+  private embedDocComments: boolean;
+  private embedContext: boolean;
+  private maxChunkTokens: number;
+  private tokenizer?: Tokenizer;
+  private extensionMap?: ExtensionMap;
+  private chunkGranularity: ChunkGranularityMap;
+  private symbolKinds?: SymbolKindFilter;
+  private wholeFileFallback: boolean;
+  private maxChunksPerFile: number;
+  private chunkOverflow: ChunkOverflowAction;
+  private previewLines: number;
+  /** Set while `parseFile` parses content that is not read from disk. */
+  private inMemoryFile?: InMemoryFile;
+  /** The content `parseFile` parses, transcoded to UTF-8. */
+  private sourceCode = '';
+
+  constructor(languages?: string, options: LanguageParserOptions = {}) {
+    this.chunkOverlapLines = Math.max(0, Math.floor(options.chunkOverlapLines ?? 0));
+    this.embedDocComments = options.embedDocComments ?? false;
+    this.embedContext = options.embedContext ?? false;
+    this.maxChunkTokens = Math.max(0, Math.floor(options.maxChunkTokens ?? 0));
+    this.previewLines = Math.max(0, Math.floor(options.previewLines ?? 0));
+    this.tokenizer = options.tokenizer;
     this.languages = new Map();
     this.fileSuffixMap = new Map();
     const languageNames = parseLanguageNames(languages);
@@ -184,11 +753,39 @@ export class LanguageParser {
         this.fileSuffixMap.set(suffix, config);
       }
     }
+    this.extensionMap = options.extensionMap;
+    this.chunkGranularity = options.chunkGranularity ?? {};
+    this.symbolKinds = options.symbolKinds;
+    this.wholeFileFallback = options.wholeFileFallback ?? false;
+    this.maxChunksPerFile = Math.max(0, Math.floor(options.maxChunksPerFile ?? 0));
+    this.chunkOverflow = options.chunkOverflow ?? 'skip';
+  }
+
+  private getChunkGranularity(language: string): ChunkGranularity {
+    return this.chunkGranularity[language] ?? DEFAULT_CHUNK_GRANULARITY;
   }
 
-  private getLanguageConfigForFile(filePath: string): LanguageConfiguration | undefined {
+  /**
+   * Returns the language that parses `filePath`, or undefined when the file is not indexed. An
+   * extension map rule wins over the suffixes registered by languages, and a rule naming a language
+   * that is not enabled skips the file. Files neither handles fall back to the map's `unknown` mode.
+   */
+  public getLanguageConfigForFile(filePath: string): LanguageConfiguration | undefined {
+    const rule = this.extensionMap ? findExtensionRule(this.extensionMap, filePath) : undefined;
+    if (rule !== undefined) {
+      return this.languages.get(this.extensionMap?.extensions[rule] ?? SKIP_PARSER);
+    }
     const fileExt = path.extname(filePath);
-    return this.fileSuffixMap.get(fileExt);
+    const config = this.fileSuffixMap.get(fileExt);
+    if (!config && this.extensionMap?.unknown === 'text') {
+      return languageConfigurations.text;
+    }
+    return config;
+  }
+
+  /** Returns the directory relative imports of `filePath` are resolved against. */
+  private getRepoRoot(filePath: string): string {
+    return this.inMemoryFile?.rootDir ?? getGitRoot(path.dirname(filePath));
   }
 
   /**
@@ -197,8 +794,15 @@ export class LanguageParser {
    * @returns File content, git hash, and timestamp
    */
   private readFileWithMetadata(filePath: string): FileMetadata {
+    if (this.inMemoryFile) {
+      return {
+        content: this.sourceCode,
+        gitFileHash: hashGitBlob(this.sourceCode),
+        timestamp: new Date().toISOString(),
+      };
+    }
     return {
-      content: fs.readFileSync(filePath, 'utf8'),
+      content: this.sourceCode,
       // Use execFileSync to prevent shell injection from special characters in file paths
       gitFileHash: execFileSync('git', ['hash-object', filePath]).toString().trim(),
       timestamp: new Date().toISOString(),
@@ -241,6 +845,7 @@ export class LanguageParser {
       content: params.content,
     });
     const directoryInfo = extractDirectoryInfo(params.relativePath);
+    const preview = buildChunkPreview(params.content, this.previewLines);
 
     const baseChunk: Omit<CodeChunk, 'semantic_text' | 'code_vector'> = {
       type: CHUNK_TYPE_DOC,
@@ -251,6 +856,7 @@ export class LanguageParser {
       git_branch: params.gitBranch,
       chunk_hash: chunkHash,
       content: params.content,
+      ...(preview !== undefined ? { preview } : {}),
       startLine: params.startLine,
       endLine: params.endLine,
       created_at: params.timestamp,
@@ -360,7 +966,31 @@ export class LanguageParser {
     return { chunks, chunksSkipped };
   }
 
-  public parseFile(filePath: string, gitBranch: string, relativePath: string): ParseResult {
+  /**
+   * Parses a file into chunks.
+   *
+   * @param filePath - Absolute path to the file
+   * @param gitBranch - Git branch name
+   * @param relativePath - Relative path from repository root, stored as each chunk's `filePath`
+   * @param inMemoryFile - Content to parse instead of reading `filePath`, which is then only used
+   *   to pick the language and to resolve relative imports
+   */
+  public parseFile(
+    filePath: string,
+    gitBranch: string,
+    relativePath: string,
+    inMemoryFile?: InMemoryFile
+  ): ParseResult {
+    this.inMemoryFile = inMemoryFile;
+    try {
+      return this.parseFileContent(filePath, gitBranch, relativePath);
+    } finally {
+      this.inMemoryFile = undefined;
+      this.sourceCode = '';
+    }
+  }
+
+  private parseFileContent(filePath: string, gitBranch: string, relativePath: string): ParseResult {
     const langConfig = this.getLanguageConfigForFile(filePath);
     if (!langConfig) {
       console.warn(`Unsupported file type: ${path.extname(filePath)}`);
@@ -376,9 +1006,25 @@ export class LanguageParser {
     };
 
     try {
+      if (this.inMemoryFile) {
+        this.sourceCode = this.inMemoryFile.content;
+      } else {
+        const { encoding, text } = readTextFile(filePath);
+        if (text === undefined) {
+          logger.warn(`Skipping ${filePath}, which is not text (detected encoding: ${encoding}).`);
+          return { chunks: [], metrics: metricData };
+        }
+        this.sourceCode = text;
+      }
+
       let chunks: CodeChunk[];
 
-      if (langConfig.parser === null) {
+      if (langConfig.parser === null && this.getChunkGranularity(langConfig.name) === 'file') {
+        const result = this.parseWholeFile(filePath, gitBranch, relativePath, langConfig.name);
+        chunks = result.chunks;
+        metricData.chunksSkipped += result.chunksSkipped;
+        metricData.parserType = PARSER_TYPE_WHOLE_FILE;
+      } else if (langConfig.parser === null) {
         if (langConfig.name === LANG_MARKDOWN) {
           const result = this.parseMarkdown(filePath, gitBranch, relativePath);
           chunks = result.chunks;
@@ -411,21 +1057,72 @@ export class LanguageParser {
         const result = this.parseWithTreeSitter(filePath, gitBranch, relativePath, langConfig);
         chunks = result.chunks;
         metricData.chunksSkipped += result.chunksSkipped;
+        metricData.chunksSplit += result.chunksSplit;
         metricData.parserType = PARSER_TYPE_TREE_SITTER;
       }
 
+      // Generated files, such as compiled protobufs, can produce tens of thousands of tiny chunks.
+      let chunkOverflow: ParseResult['chunkOverflow'];
+      if (this.maxChunksPerFile > 0 && chunks.length > this.maxChunksPerFile) {
+        chunkOverflow = { chunks: chunks.length, action: this.chunkOverflow };
+        logger.warn(
+          `${relativePath} produced ${chunks.length} chunks, more than the limit of ${this.maxChunksPerFile}; ` +
+            (this.chunkOverflow === 'skip' ? 'skipping the file.' : 'indexing it as one whole-file chunk.')
+        );
+        if (this.chunkOverflow === 'whole-file') {
+          const result = this.parseWholeFile(filePath, gitBranch, relativePath, langConfig.name);
+          chunks = result.chunks;
+          metricData.chunksSkipped += result.chunksSkipped;
+          metricData.parserType = PARSER_TYPE_WHOLE_FILE;
+        } else {
+          chunks = [];
+        }
+      } else if (indexingConfig.chunksPerFileWarning > 0 && chunks.length > indexingConfig.chunksPerFileWarning) {
+        logger.warn(
+          `${relativePath} produced ${chunks.length} chunks, more than ${indexingConfig.chunksPerFileWarning} ` +
+            '(SCS_IDXR_CHUNKS_PER_FILE_WARNING). If it is generated, exclude it or set a chunk limit per file.'
+        );
+      }
+
+      chunks = numberSymbolOccurrences(this.addTokenCounts(chunks));
       metricData.filesProcessed = 1;
       metricData.chunksCreated = chunks.length;
       metricData.chunkSizes = chunks.map((c) => Buffer.byteLength(c.content, 'utf8'));
 
-      return { chunks, metrics: metricData };
+      return { chunks, metrics: metricData, ...(chunkOverflow ? { chunkOverflow } : {}) };
     } catch (error) {
       logger.error(`Failed to parse file ${filePath}:`, error instanceof Error ? error : new Error(String(error)));
       metricData.filesFailed = 1;
-      throw error;
+      // A file that could not be read has no content to fall back to.
+      if (!this.wholeFileFallback || this.sourceCode === '') {
+        throw error;
+      }
+      const chunks = this.addTokenCounts(
+        this.parseWholeFile(filePath, gitBranch, relativePath, langConfig.name).chunks
+      );
+      return {
+        chunks,
+        metrics: {
+          ...metricData,
+          filesProcessed: 1,
+          chunksCreated: chunks.length,
+          chunkSizes: chunks.map((c) => Buffer.byteLength(c.content, 'utf8')),
+          parserType: PARSER_TYPE_WHOLE_FILE,
+        },
+        parseError: error instanceof Error ? error.message : String(error),
+      };
     }
   }
 
+  /** Stores each chunk's `token_count` when a tokenizer is set. */
+  private addTokenCounts(chunks: CodeChunk[]): CodeChunk[] {
+    // The count is taken on the embedded text, which includes the header and any doc comment.
+    const tokenizer = this.tokenizer;
+    return tokenizer
+      ? chunks.map((chunk) => ({ ...chunk, token_count: tokenizer.countTokens(chunk.semantic_text) }))
+      : chunks;
+  }
+
   /**
    * Parses files by splitting content into chunks based on a delimiter pattern.
    * Each chunk represents a logical section separated by the delimiter.
@@ -598,17 +1295,19 @@ export class LanguageParser {
     gitBranch: string,
     relativePath: string,
     langConfig: LanguageConfiguration
-  ): { chunks: CodeChunk[]; chunksSkipped: number } {
+  ): { chunks: CodeChunk[]; chunksSkipped: number; chunksSplit: number } {
     const now = new Date().toISOString();
     const parser = new Parser();
     parser.setLanguage(langConfig.parser);
 
-    const sourceCode = fs.readFileSync(filePath, 'utf8');
+    const sourceCode = this.sourceCode;
     const tree = parser.parse(sourceCode);
     const query = new Query(langConfig.parser, langConfig.queries.join('\n'));
     const matches = query.matches(tree.rootNode);
     // Use execFileSync to prevent shell injection from special characters in file paths
-    const gitFileHash = execFileSync('git', ['hash-object', filePath]).toString().trim();
+    const gitFileHash = this.inMemoryFile
+      ? hashGitBlob(sourceCode)
+      : execFileSync('git', ['hash-object', filePath]).toString().trim();
 
     // Tree-sitter capture names for imports and exports
     const IMPORT_CAPTURE_NAMES = {
@@ -662,14 +1361,23 @@ export class LanguageParser {
             type = 'file';
             if (!path.isAbsolute(importPath)) {
               const resolvedPath = path.resolve(path.dirname(filePath), importPath);
-              // Use execFileSync to prevent shell injection from special characters in directory paths
-              const gitRoot = getGitRoot(path.dirname(filePath));
+              const gitRoot = this.getRepoRoot(filePath);
               importPath = path.relative(gitRoot, resolvedPath);
             }
+          } else if (langConfig.name === 'python' && importPath.startsWith('.')) {
+            // Relative imports (`from ..pkg.mod import x`): one dot is the file's package, each further dot its parent.
+            const dots = importPath.length - importPath.replace(/^\.+/, '').length;
+            const resolvedPath = path.resolve(
+              path.dirname(filePath),
+              ...Array<string>(dots - 1).fill('..'),
+              ...importPath.slice(dots).split('.').filter(Boolean)
+            );
+            const gitRoot = this.getRepoRoot(filePath);
+            importPath = path.relative(gitRoot, resolvedPath) || '.';
+            type = 'file';
           } else if (importPath.startsWith('.')) {
             const resolvedPath = path.resolve(path.dirname(filePath), importPath);
-            // Use execFileSync to prevent shell injection from special characters in directory paths
-            const gitRoot = getGitRoot(path.dirname(filePath));
+            const gitRoot = this.getRepoRoot(filePath);
             importPath = path.relative(gitRoot, resolvedPath);
             type = 'file';
           }
@@ -679,12 +1387,23 @@ export class LanguageParser {
     }
 
     const symbolsByLine: { [line: number]: SymbolInfo[] } = {};
+    const referencesByLine: { [line: number]: ReferenceInfo[] } = {};
+    // Definition names with their position, so a chunk is only named after a symbol it contains.
+    const definitionNames: { name: string; line: number; startIndex: number }[] = [];
     if (langConfig.symbolQueries) {
       const symbolQuery = new Query(langConfig.parser, langConfig.symbolQueries.join('\n'));
       const symbolMatches = symbolQuery.matches(tree.rootNode);
+      // A declarator such as `const f = () => {}` matches both a function and a variable pattern;
+      // record it once, as a function.
+      const functionNameNodes = new Set(
+        symbolMatches.filter((m) => m.captures[0].name === 'function.name').map((m) => m.captures[0].node.startIndex)
+      );
       for (const m of symbolMatches) {
         const capture = m.captures[0];
         const kind = capture.name || 'symbol';
+        if (kind === 'variable.name' && functionNameNodes.has(capture.node.startIndex)) {
+          continue;
+        }
         const line = capture.node.startPosition.row + 1;
         if (!symbolsByLine[line]) {
           symbolsByLine[line] = [];
@@ -694,8 +1413,23 @@ export class LanguageParser {
           kind,
           line,
         });
+        if (DEFINITION_SYMBOL_KINDS.has(kind)) {
+          definitionNames.push({ name: capture.node.text, line, startIndex: capture.node.startIndex });
+        }
+        const referenceKind = REFERENCE_CAPTURE_KINDS[kind];
+        if (referenceKind) {
+          if (!referencesByLine[line]) {
+            referencesByLine[line] = [];
+          }
+          referencesByLine[line].push(getReference(capture.node, referenceKind, langConfig.name));
+        }
       }
     }
+    // Files without a package declaration (or languages without packages) get no package context.
+    const packageName = getPackageName(tree.rootNode);
+    const fileImports = Array.from(
+      new Set(Object.values(importsByLine).flatMap((entries) => entries.map((entry) => entry.path)))
+    );
 
     // For Python, check if __all__ is defined and use it as the authoritative export list
     let pythonAllSet: Set<string> | null = null;
@@ -775,8 +1509,7 @@ export class LanguageParser {
             if (exportTarget.startsWith('.')) {
               try {
                 const resolvedPath = path.resolve(path.dirname(filePath), exportTarget);
-                // Use execFileSync to prevent shell injection from special characters in directory paths
-                const gitRoot = getGitRoot(path.dirname(filePath));
+                const gitRoot = this.getRepoRoot(filePath);
                 exportTarget = path.relative(gitRoot, resolvedPath);
               } catch (error) {
                 logger.warn(
@@ -834,7 +1567,9 @@ export class LanguageParser {
             }
           }
 
-          const line = match.captures[0].node.startPosition.row + 1;
+          // Exports are recorded on the line of their name, which a captured declaration can start above.
+          const lineCapture = match.captures.find((c) => c.name === EXPORT_CAPTURE_NAMES.NAME) ?? match.captures[0];
+          const line = lineCapture.node.startPosition.row + 1;
           if (!exportsByLine[line]) {
             exportsByLine[line] = [];
           }
@@ -856,19 +1591,151 @@ export class LanguageParser {
       ).values()
     );
 
+    const documentableNodes = new Set<string>();
+    for (const match of matches) {
+      for (const capture of match.captures) {
+        if (DOC_COMMENT_CAPTURES.has(capture.name)) {
+          documentableNodes.add(`${capture.node.startIndex}-${capture.node.endIndex}`);
+        }
+      }
+    }
+
+    const sourceLines = this.chunkOverlapLines > 0 ? sourceCode.split('\n') : [];
+    const windowOverlapChars = indexingConfig.codeChunkOverlapChars;
+
+    // In file mode the whole file is a single definition that carries every symbol, import and export.
+    const wholeFile = this.getChunkGranularity(langConfig.name) === 'file';
+    const allDefinitions = wholeFile
+      ? sourceCode.trim().length > 0
+        ? [tree.rootNode]
+        : []
+      : uniqueMatches.map(({ captures }) => captures[0].node);
+    const definitions = this.filterSymbolKinds(allDefinitions);
+    if (definitions.length < allDefinitions.length) {
+      logger.debug(`Filtered ${allDefinitions.length - definitions.length} chunks by symbol kind in ${relativePath}`);
+    }
+
     let chunksSkipped = 0;
-    const chunks = uniqueMatches
-      .map(({ captures }): CodeChunk | null => {
-        const node = captures[0].node;
-        const content = node.text;
-        const contentSize = Buffer.byteLength(content, 'utf8');
+    let chunksSplit = 0;
+    const chunks = definitions.flatMap((definition): CodeChunk[] => {
+      // The chunk spans the decorators of a decorated definition; the definition keeps its kind and docs.
+      const node = withDecorators(definition);
+      const content = node.text;
+      const nodeStartLine = node.startPosition.row + 1;
+      const nodeEndLine = getLastRow(node) + 1;
+      // Java and Kotlin annotations are part of the declaration's `modifiers`, which can start lines above its name.
+      const modifiers = definition.children.find((child) => child.type === 'modifiers');
+      const declarationLine = (modifiers?.nextSibling ?? definition).startPosition.row + 1;
+      const docComment =
+        !isCommentNode(definition) && documentableNodes.has(`${definition.startIndex}-${definition.endIndex}`)
+          ? getLeadingDocComment(definition, langConfig.name)
+          : undefined;
+
+      // Symbols that fit the budget stay a single chunk; longer ones become overlapping windows. The
+      // character budget is the tighter of SCS_IDXR_MAX_CODE_CHUNK_CHARS and the token limit, scaled by
+      // the symbol's own characters per token.
+      const tokens = this.maxChunkTokens > 0 ? this.countTokens(content) : 0;
+      const maxChunkChars = [
+        indexingConfig.maxCodeChunkChars,
+        tokens > this.maxChunkTokens ? Math.floor((content.length * this.maxChunkTokens) / tokens) : 0,
+      ]
+        .filter((budget) => budget > 0)
+        .reduce((min, budget) => Math.min(min, budget), Infinity);
+      const isSplit = content.length > maxChunkChars;
+      const windows: ChunkWindow[] = isSplit
+        ? splitIntoWindows(content, maxChunkChars, windowOverlapChars)
+        : [{ content, firstLine: 0, lastLine: node.endPosition.row - node.startPosition.row, offset: 0 }];
+      if (isSplit) {
+        chunksSplit++;
+      }
+
+      let containerPath = '';
+      let parent = node.parent;
+      if (parent) {
+        while (parent && CONTAINER_BODY_TYPES.has(parent.type)) {
+          parent = parent.parent;
+        }
+
+        if (parent && CONTAINER_TYPES.has(parent.type)) {
+          const nameNode = parent.namedChildren.find(
+            (child) => child.type === 'identifier' || child.type === 'type_identifier'
+          );
+          if (nameNode) {
+            containerPath = nameNode.text;
+          }
+        } else if (parent?.type === 'declaration_list' && parent.parent) {
+          // Rust items nested in `impl Type { ... }` / `trait Name { ... }` blocks.
+          const owner = parent.parent;
+          const nameNode =
+            owner.type === 'impl_item'
+              ? owner.childForFieldName('type')
+              : owner.type === 'trait_item'
+                ? owner.childForFieldName('name')
+                : null;
+          if (nameNode) {
+            containerPath = nameNode.text;
+          }
+        } else if (langConfig.name === 'python') {
+          containerPath = getPythonEnclosingName(definition);
+        }
+      }
+
+      // Only the definition's own name on its declaration line names the chunk, not e.g. a call
+      // chunk that happens to share the line.
+      const definitionName = wholeFile
+        ? undefined
+        : definitionNames.find(
+            (symbol) =>
+              symbol.line === declarationLine &&
+              symbol.startIndex >= definition.startIndex &&
+              symbol.startIndex < definition.endIndex
+          );
+      const receiverType = getReceiverTypeName(definition);
+      const symbolKind = wholeFile ? undefined : getSymbolKind(definition);
+      const qualifier = containerPath || receiverType;
+      // Symbol chunks are placed in their enclosing symbols, e.g. `Greeter.Greet` for a method.
+      const scopeNames = definitionName ? getEnclosingSymbolNames(definition, receiverType) : [];
+      const scopePath = definitionName ? [...scopeNames, definitionName.name].join('.') : undefined;
+      const parentSymbol = scopeNames[scopeNames.length - 1];
+      const symbolFqn = definitionName
+        ? [packageName, qualifier, definitionName.name].filter(Boolean).join('.')
+        : undefined;
+
+      const directoryInfo = extractDirectoryInfo(relativePath);
+      const embeddedContext = this.embedContext
+        ? formatEmbeddedContext({ packageName, receiverType, imports: fileImports })
+        : undefined;
+      const overlap = getSiblingOverlap(node, sourceLines, this.chunkOverlapLines);
+      // The preview of every part of a function or method shows its declaration line.
+      const signatureLine =
+        symbolKind === 'function' || symbolKind === 'method'
+          ? content.split('\n')[declarationLine - nodeStartLine]
+          : undefined;
+
+      // Parts of a split symbol share an id derived from the whole symbol (content-based, like chunk
+      // ids) and its name, so every part can be traced back to the symbol it came from.
+      const splitSymbol = isSplit
+        ? {
+            symbol_id: createHash('sha256')
+              .update([CHUNK_TYPE_CODE, langConfig.name, definition.type, content].join(':'))
+              .digest('hex'),
+            symbol_name: wholeFile
+              ? undefined
+              : (symbolsByLine[nodeStartLine]?.[0]?.name ?? (containerPath || undefined)),
+          }
+        : undefined;
+
+      const windowChunks: CodeChunk[] = [];
+      windows.forEach((window, windowIndex) => {
+        const contentSize = Buffer.byteLength(window.content, 'utf8');
         if (contentSize > indexingConfig.maxChunkSizeBytes) {
           logger.warn(`Skipping chunk in ${filePath} because it is larger than maxChunkSizeBytes`);
           chunksSkipped++;
-          return null;
+          return;
         }
-        const startLine = node.startPosition.row + 1;
-        const endLine = node.endPosition.row + 1;
+        const startLine = nodeStartLine + window.firstLine;
+        const endLine = nodeStartLine + window.lastLine;
+        const startIndex = node.startIndex + window.offset;
         const chunkHash = createChunkHash({
           type: CHUNK_TYPE_CODE,
           language: langConfig.name,
@@ -877,52 +1744,63 @@ export class LanguageParser {
           gitFileHash,
           startLine,
           endLine,
-          startIndex: node.startIndex,
-          endIndex: node.endIndex,
-          content: content,
+          startIndex,
+          endIndex: isSplit ? startIndex + window.content.length : node.endIndex,
+          content: window.content,
         });
 
-        let containerPath = '';
-        let parent = node.parent;
-        if (parent) {
-          if (parent.type === 'class_body') {
-            parent = parent.parent;
-          }
-
-          if (
-            parent &&
-            (parent.type === 'class_declaration' ||
-              parent.type === 'function_declaration' ||
-              parent.type === 'class_definition')
-          ) {
-            const nameNode = parent.namedChildren.find(
-              (child) => child.type === 'identifier' || child.type === 'type_identifier'
-            );
-            if (nameNode) {
-              containerPath = nameNode.text;
-            }
+        // Exports belong to the symbol's first line, so only the first window carries them. Imports
+        // belong to every line of the symbol (e.g. the specs of a Go `import ( ... )` block), and are
+        // also carried by the first window only. A whole-file chunk carries those of every line.
+        const isFirstWindow = windowIndex === 0;
+        const chunkImports: NonNullable<CodeChunk['imports']> = [];
+        if (isFirstWindow) {
+          for (let i = nodeStartLine; i <= nodeEndLine; i++) {
+            chunkImports.push(...(importsByLine[i] ?? []));
           }
         }
-
-        const chunkImports = importsByLine[startLine] || [];
         const chunkSymbols: SymbolInfo[] = [];
+        const chunkReferences = new Map<string, ReferenceInfo>();
         for (let i = startLine; i <= endLine; i++) {
           if (symbolsByLine[i]) {
             chunkSymbols.push(...symbolsByLine[i]);
           }
+          for (const reference of referencesByLine[i] ?? []) {
+            const key = [reference.kind, reference.receiver, reference.receiver_type, reference.name].join(':');
+            chunkReferences.set(key, reference);
+          }
         }
-        const chunkExports = exportsByLine[startLine] || [];
-
-        const directoryInfo = extractDirectoryInfo(relativePath);
+        // Decorators come before the declaration line that exports are recorded on.
+        const chunkExports = !isFirstWindow
+          ? []
+          : wholeFile
+            ? Object.values(exportsByLine).flat()
+            : exportsByLine[declarationLine] || [];
+        const windowOverlap = windowIndex === windows.length - 1 ? overlap : undefined;
+        const windowDocComment = isFirstWindow ? docComment : undefined;
+        const preview = buildChunkPreview(
+          window.content,
+          this.previewLines,
+          signatureLine !== undefined ? { line: signatureLine, index: declarationLine - startLine } : undefined
+        );
 
         const baseChunk: Omit<CodeChunk, 'semantic_text' | 'code_vector'> = {
           type: CHUNK_TYPE_CODE,
           language: langConfig.name,
-          kind: node.type,
+          kind: wholeFile ? CHUNK_KIND_FILE : definition.type,
           imports: chunkImports,
           symbols: chunkSymbols,
+          ...(chunkReferences.size > 0 ? { references: Array.from(chunkReferences.values()) } : {}),
           exports: chunkExports,
           containerPath,
+          ...(symbolFqn ? { symbol_fqn: symbolFqn } : {}),
+          ...(symbolKind ? { symbol_kind: symbolKind } : {}),
+          ...(parentSymbol ? { parent_symbol: parentSymbol } : {}),
+          ...(scopePath ? { scope_path: scopePath } : {}),
+          ...(packageName ? { package_name: packageName } : {}),
+          ...(receiverType ? { receiver_type: receiverType } : {}),
+          ...(fileImports.length > 0 ? { file_imports: fileImports } : {}),
+          ...(embeddedContext ? { embedded_context: embeddedContext } : {}),
           filePath: relativePath,
           ...directoryInfo,
           git_file_hash: gitFileHash,
@@ -930,19 +1808,66 @@ export class LanguageParser {
           chunk_hash: chunkHash,
           startLine,
           endLine,
-          content: content,
+          content: window.content,
+          ...(preview !== undefined ? { preview } : {}),
+          ...(windowDocComment ? { doc_comment: windowDocComment } : {}),
+          ...(windowOverlap ? { overlap: windowOverlap } : {}),
+          ...(splitSymbol
+            ? {
+                symbol_id: splitSymbol.symbol_id,
+                ...(splitSymbol.symbol_name ? { symbol_name: splitSymbol.symbol_name } : {}),
+                chunkIndex: windowIndex,
+                totalChunks: windows.length,
+              }
+            : {}),
           created_at: now,
           updated_at: now,
         };
 
-        return {
+        windowChunks.push({
           ...baseChunk,
           semantic_text: this.prepareSemanticText(baseChunk),
-        };
-      })
-      .filter((chunk): chunk is CodeChunk => chunk !== null);
+        });
+      });
+      return windowChunks;
+    });
 
-    return { chunks, chunksSkipped };
+    if (chunksSplit > 0) {
+      logger.debug(`Split ${chunksSplit} oversized chunks in ${relativePath} into parts`);
+    }
+
+    return { chunks, chunksSkipped, chunksSplit };
+  }
+
+  /**
+   * Drops the definitions of symbol kinds that are filtered out, and the statement, call and comment
+   * chunks inside them, so e.g. the body of an excluded method leaves nothing in the index.
+   */
+  private filterSymbolKinds(definitions: Parser.SyntaxNode[]): Parser.SyntaxNode[] {
+    const filter = this.symbolKinds;
+    if (!filter || (!filter.include && !filter.exclude)) {
+      return definitions;
+    }
+    const excluded: Parser.SyntaxNode[] = [];
+    const kept: Parser.SyntaxNode[] = [];
+    for (const definition of definitions) {
+      const kind = getSymbolKind(definition);
+      if (kind !== undefined && !isSymbolKindIncluded(kind, filter)) {
+        excluded.push(definition);
+      } else {
+        kept.push(definition);
+      }
+    }
+    return kept.filter(
+      (definition) =>
+        getSymbolKind(definition) !== undefined ||
+        !excluded.some((symbol) => definition.startIndex >= symbol.startIndex && definition.endIndex <= symbol.endIndex)
+    );
+  }
+
+  /** Counts tokens with the configured tokenizer, or estimates them from the length. */
+  private countTokens(text: string): number {
+    return this.tokenizer ? this.tokenizer.countTokens(text) : estimateTokenCount(text);
   }
 
   private prepareSemanticText(
@@ -963,7 +1888,15 @@ export class LanguageParser {
     if (chunk.containerPath) {
       header.push(`containerPath: ${chunk.containerPath}`);
     }
+    // The file context is the exception: chunks that embed it are kept apart by their document id.
+    if (chunk.embedded_context) {
+      header.push(chunk.embedded_context);
+    }
 
-    return `${header.join('\n')}\n\n${chunk.content}`;
+    // Doc comments and overlap are embedded with the chunk for recall, but kept out of `content`.
+    const embedDocComments = this.embedDocComments || this.getChunkGranularity(chunk.language) === 'symbol+doc';
+    const docComment = embedDocComments && chunk.doc_comment ? `${chunk.doc_comment}\n\n` : '';
+    const overlap = chunk.overlap ? `\n\n${chunk.overlap}` : '';
+    return `${header.join('\n')}\n\n${docComment}${chunk.content}${overlap}`;
   }
 }
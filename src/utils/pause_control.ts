@@ -0,0 +1,34 @@
+import fs from 'fs';
+
+let pausedBySignal = false;
+let pauseFile: string | undefined;
+
+/**
+ * True while indexing is paused by SIGUSR1 (until SIGUSR2) or by the presence of the `--pause-file`.
+ * Indexing workers check it before each dequeue: batches in flight still finish and are committed,
+ * and producers keep filling the queue.
+ */
+export function isIndexingPaused(): boolean {
+  return pausedBySignal || (pauseFile !== undefined && fs.existsSync(pauseFile));
+}
+
+/** Pauses indexing until {@link resumeIndexing}, whether or not the pause file exists. */
+export function pauseIndexing(): void {
+  pausedBySignal = true;
+}
+
+/** Lifts a pause of {@link pauseIndexing}. A pause file that still exists keeps indexing paused. */
+export function resumeIndexing(): void {
+  pausedBySignal = false;
+}
+
+/** Sets the file whose presence pauses indexing, see `--pause-file`; `undefined` stops watching it. */
+export function setPauseFile(filePath: string | undefined): void {
+  pauseFile = filePath;
+}
+
+/** Test-only: resumes indexing and forgets the pause file. */
+export function resetPauseControl(): void {
+  pausedBySignal = false;
+  pauseFile = undefined;
+}
@@ -0,0 +1,395 @@
+import { Worker } from 'worker_threads';
+import PQueue from 'p-queue';
+import type { CodeChunk } from './elasticsearch';
+import type { InMemoryFile, ParseResult } from './parser';
+import { MESSAGE_STATUS_LOG, MESSAGE_TYPE_SHUTDOWN } from './constants';
+import { LogEntry, logger, writeLogEntry } from './logger';
+
+/** Crashed or hung workers a pool replaces before it gives up, see `--max-worker-restarts`. */
+export const DEFAULT_MAX_WORKER_RESTARTS = 10;
+
+/** Message a producer worker posts back for each parsed file, see `producer_worker.ts`. */
+export interface ProducerMessage {
+  status?: string;
+  data?: CodeChunk[];
+  metrics?: ParseResult['metrics'];
+  error?: string;
+  /** Why a file sent with `status: success` failed to parse, when it was indexed as a whole-file chunk. */
+  parseError?: string;
+  /** Set when the file produced more chunks than `--max-chunks-per-file`, see `ParseResult.chunkOverflow`. */
+  chunkOverflow?: ParseResult['chunkOverflow'];
+  filePath?: string;
+}
+
+export interface ProducerPoolOptions {
+  /** Creates one parsing worker thread. Called once per pool slot, and again when a worker crashes. */
+  createWorker: () => Worker;
+  /** Number of worker threads parsing files concurrently. */
+  size: number;
+  /**
+   * Parsed chunks waiting for the writer above which no further file is handed to a worker, so a
+   * slow queue database bounds memory instead of buffering every parsed file.
+   */
+  highWaterMark: number;
+  /**
+   * Workers replaced after crashing, exiting or hanging before `run()` stops handing out files and
+   * rejects. Defaults to {@link DEFAULT_MAX_WORKER_RESTARTS}.
+   */
+  maxRestarts?: number;
+  /** Longest a worker may take to answer a file before it is considered hung and replaced. No limit when omitted. */
+  heartbeatTimeoutMs?: number;
+}
+
+/** Request posted to a worker to parse one file, with its content when it is not read from disk. */
+export type ProducerRequest = {
+  filePath: string;
+  gitBranch: string;
+  relativePath: string;
+  inMemoryFile?: InMemoryFile;
+};
+
+/** Pools whose `run()` has not returned yet, drained on shutdown. */
+const runningPools = new Set<ProducerPool>();
+
+/**
+ * Stops every running pool from handing out files and waits for the files being parsed to be
+ * written to the queue. Each worker is then asked to wind down and given `ackTimeoutMs` to
+ * acknowledge before it is terminated.
+ */
+export async function drainProducerPools(ackTimeoutMs: number): Promise<void> {
+  await Promise.all(Array.from(runningPools, (pool) => pool.drain(ackTimeoutMs)));
+}
+
+function isLogMessage(message: unknown): message is { status: typeof MESSAGE_STATUS_LOG; entry: LogEntry } {
+  return (message as ProducerMessage | undefined)?.status === MESSAGE_STATUS_LOG;
+}
+
+/**
+ * Calls `handler` for the next `event` of the worker. Forwarded log lines are not replies, so they
+ * never complete a `message` listener.
+ */
+function addOneTimeListener(
+  worker: Worker,
+  event: 'message' | 'error' | 'exit',
+  handler: (...args: unknown[]) => void
+): () => void {
+  const w = worker as unknown as {
+    on: (event: string, handler: (...args: unknown[]) => void) => void;
+    off?: (event: string, handler: (...args: unknown[]) => void) => void;
+    removeListener?: (event: string, handler: (...args: unknown[]) => void) => void;
+  };
+
+  // Critical: remove the listener when the opposite event wins the race.
+  const remove = () => {
+    if (typeof w.off === 'function') {
+      w.off(event, listener);
+    } else if (typeof w.removeListener === 'function') {
+      w.removeListener(event, listener);
+    }
+  };
+  const listener = (...args: unknown[]) => {
+    if (event === 'message' && isLogMessage(args[0])) {
+      return;
+    }
+    remove();
+    handler(...args);
+  };
+  w.on(event, listener);
+  return remove;
+}
+
+/**
+ * A bounded pool of parsing worker threads feeding a single serialized writer.
+ *
+ * Files are parsed concurrently, but every parsed file is handed to `write` one at a time, in the
+ * order parsing finished, which respects SQLite's single-writer model. A file's chunks are written
+ * together in one call, so their order (and the row ids they get) matches the parser output.
+ * Cross-file order is not preserved.
+ */
+export class ProducerPool {
+  private readonly options: ProducerPoolOptions;
+  private readonly writer = new PQueue({ concurrency: 1 });
+  private bufferedChunks = 0;
+  private capacityWaiters: Array<() => void> = [];
+  private draining = false;
+  private ackTimeoutMs = 0;
+  private finished: Promise<void> = Promise.resolve();
+
+  constructor(options: ProducerPoolOptions) {
+    this.options = options;
+  }
+
+  /**
+   * Parses every file and writes the results. Resolves once all files have been written and the
+   * worker threads are terminated.
+   *
+   * @param files The files to parse. An async iterable is only read as workers become free, so a
+   *   streamed source such as an archive is not read ahead of the parsing. When it throws, `run()`
+   *   rejects with its error once the files already handed out are written.
+   * @param toRequest Builds the message posted to the worker for a file.
+   * @param write Receives the worker's reply for a file. A rejected write is reported through
+   *   `onError` and does not stop the remaining files.
+   * @param onError Receives a file that could not be written, or whose worker crashed, exited or
+   *   hung twice while parsing it. The worker is replaced each time, and `run()` rejects once more
+   *   than `maxRestarts` workers were replaced.
+   */
+  async run(
+    files: string[] | AsyncIterable<string>,
+    toRequest: (file: string) => ProducerRequest,
+    write: (file: string, message: ProducerMessage) => Promise<void> | void,
+    onError: (file: string, error: unknown) => void
+  ): Promise<void> {
+    if (Array.isArray(files) && files.length === 0) {
+      return;
+    }
+    this.finished = this.produce(files, toRequest, write, onError);
+    runningPools.add(this);
+    try {
+      await this.finished;
+    } finally {
+      runningPools.delete(this);
+    }
+  }
+
+  /** True once `drain()` was called, in which case `run()` may have skipped files. */
+  get isDraining(): boolean {
+    return this.draining;
+  }
+
+  /**
+   * Stops handing out files and resolves once the files already being parsed are written and the
+   * workers have wound down. Files that were not handed out yet are skipped.
+   */
+  async drain(ackTimeoutMs: number): Promise<void> {
+    this.draining = true;
+    this.ackTimeoutMs = ackTimeoutMs;
+    const waiters = this.capacityWaiters;
+    this.capacityWaiters = [];
+    waiters.forEach((resolve) => resolve());
+    await this.finished;
+  }
+
+  private async produce(
+    files: string[] | AsyncIterable<string>,
+    toRequest: (file: string) => ProducerRequest,
+    write: (file: string, message: ProducerMessage) => Promise<void> | void,
+    onError: (file: string, error: unknown) => void
+  ): Promise<void> {
+    const fileCount = Array.isArray(files) ? files.length : Infinity;
+    const poolSize = Math.max(1, Math.min(Math.floor(this.options.size), fileCount));
+    const maxRestarts = this.options.maxRestarts ?? DEFAULT_MAX_WORKER_RESTARTS;
+    const heartbeatTimeoutMs = this.options.heartbeatTimeoutMs;
+    // Workers the pool stopped using: their `exit` is expected.
+    const retiredWorkers = new WeakSet<Worker>();
+    let restarts = 0;
+    let restartError: Error | undefined;
+
+    const createWorker = (): Worker => {
+      const worker = this.options.createWorker();
+      worker.on('message', (message: unknown) => {
+        if (isLogMessage(message)) {
+          writeLogEntry(message.entry);
+        }
+      });
+      // A crash is reported by the `exit` that follows, or to the file being parsed.
+      worker.on('error', () => {});
+      worker.on('exit', (code: unknown) => {
+        // A worker parsing a file is replaced when its file is rejected; an idle one would never answer.
+        const index = idleWorkers.indexOf(worker);
+        if (!retiredWorkers.has(worker) && index !== -1) {
+          idleWorkers.splice(index, 1);
+          replaceWorker(worker, new Error(`Producer worker exited with code ${code} while idle`));
+        }
+      });
+      return worker;
+    };
+    const idleWorkers = Array.from({ length: poolSize }, () => createWorker());
+    const allWorkers = new Set(idleWorkers);
+    const workerWaiters: Array<(worker: Worker | undefined) => void> = [];
+
+    /** Resolves with the next free worker, or undefined once the pool gave up restarting workers. */
+    const acquireWorker = async (): Promise<Worker | undefined> => {
+      if (restartError) return undefined;
+      const worker = idleWorkers.pop();
+      if (worker) return worker;
+      return await new Promise<Worker | undefined>((resolve) => workerWaiters.push(resolve));
+    };
+
+    const releaseWorker = (worker: Worker): void => {
+      const waiter = workerWaiters.shift();
+      if (waiter) {
+        waiter(worker);
+      } else {
+        idleWorkers.push(worker);
+      }
+    };
+
+    const replaceWorker = (worker: Worker, error: unknown): void => {
+      retiredWorkers.add(worker);
+      allWorkers.delete(worker);
+      void worker.terminate();
+      const reason = error instanceof Error ? error.message : String(error);
+      if (++restarts > maxRestarts) {
+        restartError ??= new Error(
+          `Producer workers failed ${restarts} times, more than --max-worker-restarts ${maxRestarts}. ` +
+            `Last failure: ${reason}`
+        );
+        workerWaiters.splice(0).forEach((resolve) => resolve(undefined));
+        return;
+      }
+      logger.warn(`Producer worker failed, starting a replacement (${restarts}/${maxRestarts} restarts)`, {
+        error: reason,
+      });
+      const replacement = createWorker();
+      allWorkers.add(replacement);
+      releaseWorker(replacement);
+    };
+
+    const parse = (worker: Worker, file: string): Promise<ProducerMessage> =>
+      new Promise<ProducerMessage>((resolve, reject) => {
+        const cleanups: Array<() => void> = [];
+        const cleanup = () => cleanups.forEach((fn) => fn());
+        cleanups.push(
+          addOneTimeListener(worker, 'message', (message: unknown) => {
+            cleanup();
+            resolve(message as ProducerMessage);
+          })
+        );
+        cleanups.push(
+          addOneTimeListener(worker, 'error', (error: unknown) => {
+            cleanup();
+            reject(error);
+          })
+        );
+        // A worker can exit without an `error`, e.g. when the thread calls `process.exit()`.
+        cleanups.push(
+          addOneTimeListener(worker, 'exit', (code: unknown) => {
+            cleanup();
+            reject(new Error(`Producer worker exited with code ${code}`));
+          })
+        );
+        if (heartbeatTimeoutMs !== undefined) {
+          const timer = setTimeout(() => {
+            cleanup();
+            reject(new Error(`Producer worker did not answer within ${heartbeatTimeoutMs}ms`));
+          }, heartbeatTimeoutMs);
+          cleanups.push(() => clearTimeout(timer));
+        }
+        worker.postMessage(toRequest(file));
+      });
+
+    const handOut = (worker: Worker, file: string, isRetry: boolean): Promise<void> =>
+      parse(worker, file).then(
+        (message) => {
+          releaseWorker(worker);
+          this.bufferWrite(file, message, write, onError);
+        },
+        async (error) => {
+          replaceWorker(worker, error);
+          // Nothing of an unanswered file was written, so it is parsed once more by a fresh worker.
+          if (!isRetry && !this.draining) {
+            const next = await acquireWorker();
+            if (next && !this.draining) {
+              return handOut(next, file, true);
+            }
+            if (next) {
+              releaseWorker(next);
+            }
+          }
+          onError(file, error);
+        }
+      );
+
+    const parseJobs: Promise<void>[] = [];
+    // A failing source, such as a corrupt archive, stops the loop; the files handed out are still written.
+    let sourceError: unknown;
+    try {
+      for await (const file of files) {
+        // Back-pressure: stop handing out files while the writer is too far behind.
+        await this.waitForCapacity();
+        if (this.draining || restartError) {
+          break;
+        }
+        const worker = await acquireWorker();
+        if (!worker) {
+          break;
+        }
+        if (this.draining) {
+          releaseWorker(worker);
+          break;
+        }
+        parseJobs.push(handOut(worker, file, false));
+      }
+    } catch (error) {
+      sourceError = error;
+    }
+
+    await Promise.all(parseJobs);
+    await this.writer.onIdle();
+    await Promise.all(
+      Array.from(allWorkers, async (worker) => {
+        retiredWorkers.add(worker);
+        if (this.draining) {
+          await this.windDown(worker);
+        }
+        await worker.terminate();
+      })
+    );
+    if (sourceError !== undefined) {
+      throw sourceError;
+    }
+    if (restartError) {
+      throw restartError;
+    }
+  }
+
+  /**
+   * Asks an idle worker to exit and waits up to `ackTimeoutMs` for its acknowledgement. Every file
+   * it was given has been answered, so the next message it posts is the acknowledgement.
+   */
+  private async windDown(worker: Worker): Promise<void> {
+    let timer: NodeJS.Timeout | undefined;
+    let removeListener: () => void = () => {};
+    await new Promise<void>((resolve) => {
+      removeListener = addOneTimeListener(worker, 'message', () => resolve());
+      timer = setTimeout(resolve, this.ackTimeoutMs);
+      worker.postMessage({ type: MESSAGE_TYPE_SHUTDOWN });
+    });
+    clearTimeout(timer);
+    removeListener();
+  }
+
+  private bufferWrite(
+    file: string,
+    message: ProducerMessage,
+    write: (file: string, message: ProducerMessage) => Promise<void> | void,
+    onError: (file: string, error: unknown) => void
+  ): void {
+    const chunkCount = Array.isArray(message.data) ? message.data.length : 0;
+    this.bufferedChunks += chunkCount;
+    void this.writer.add(async () => {
+      try {
+        await write(file, message);
+      } catch (error) {
+        onError(file, error);
+      } finally {
+        this.bufferedChunks -= chunkCount;
+        this.releaseCapacity();
+      }
+    });
+  }
+
+  private async waitForCapacity(): Promise<void> {
+    while (!this.draining && this.bufferedChunks > this.options.highWaterMark) {
+      await new Promise<void>((resolve) => this.capacityWaiters.push(resolve));
+    }
+  }
+
+  private releaseCapacity(): void {
+    if (this.bufferedChunks <= this.options.highWaterMark) {
+      const waiters = this.capacityWaiters;
+      this.capacityWaiters = [];
+      waiters.forEach((resolve) => resolve());
+    }
+  }
+}
@@ -1,60 +1,191 @@
 /**
  * This is a worker thread that is responsible for parsing files.
  *
- * It receives file paths from the main thread, parses them using the
- * `LanguageParser`, and then sends the resulting code chunks back to the main
- * thread.
+ * It receives file paths from the main thread, or the content of archive
+ * entries, parses them using the `LanguageParser`, and then sends the
+ * resulting code chunks back to the main thread.
  */
-import { parentPort, workerData } from 'worker_threads';
-import { LanguageParser, type ParseResult } from './parser';
-import { createLogger } from './logger';
-import { MESSAGE_STATUS_SUCCESS, MESSAGE_STATUS_FAILURE } from './constants';
+import { parentPort, threadId, workerData } from 'worker_threads';
+import { LanguageParser, type InMemoryFile, type ParseResult } from './parser';
+import type { ExtensionMap } from './extension_map';
+import type { ChunkGranularityMap } from './chunk_granularity';
+import type { SymbolKindFilter } from './symbol_kinds';
+import { getTokenizer } from './tokenizer';
+import { blameChunks } from './git_blame';
+import { createLogger, forwardLogs, runWithLogContext } from './logger';
+import {
+  MESSAGE_STATUS_SUCCESS,
+  MESSAGE_STATUS_FAILURE,
+  MESSAGE_STATUS_LOG,
+  MESSAGE_STATUS_SHUTDOWN,
+  MESSAGE_TYPE_SHUTDOWN,
+} from './constants';
 
-const workerContext = workerData as { repoName?: unknown; gitBranch?: unknown; languages?: unknown };
+// Log lines of this thread, including the parser's, are written by the main thread, so they share
+// its format and phase instead of interleaving differently formatted output on stdout.
+if (parentPort) {
+  const port = parentPort;
+  forwardLogs((entry) => port.postMessage({ status: MESSAGE_STATUS_LOG, entry }));
+}
+
+const workerContext = workerData as {
+  repoName?: unknown;
+  gitBranch?: unknown;
+  languages?: unknown;
+  chunkOverlapLines?: unknown;
+  embedDocComments?: unknown;
+  embedContext?: unknown;
+  maxChunkTokens?: unknown;
+  previewLines?: unknown;
+  tokenizer?: unknown;
+  extensionMap?: unknown;
+  chunkGranularity?: unknown;
+  symbolKinds?: unknown;
+  wholeFileFallback?: unknown;
+  maxChunksPerFile?: unknown;
+  chunkOverflow?: unknown;
+  withBlame?: unknown;
+  repoRoot?: unknown;
+  commitSha?: unknown;
+  repoUrl?: unknown;
+  repoRef?: unknown;
+};
 const repoName = typeof workerContext.repoName === 'string' ? workerContext.repoName : undefined;
 const repoBranch = typeof workerContext.gitBranch === 'string' ? workerContext.gitBranch : undefined;
 const languages = typeof workerContext.languages === 'string' ? workerContext.languages : undefined;
-const logger = repoName && repoBranch ? createLogger({ name: repoName, branch: repoBranch }) : createLogger();
+const chunkOverlapLines =
+  typeof workerContext.chunkOverlapLines === 'number' ? workerContext.chunkOverlapLines : undefined;
+const logger = createLogger(repoName && repoBranch ? { name: repoName, branch: repoBranch } : undefined, {
+  module: 'parser',
+});
+const workerId = `${process.pid}/${threadId}`;
+
+const embedDocComments = workerContext.embedDocComments === true;
+const embedContext = workerContext.embedContext === true;
+const maxChunkTokens = typeof workerContext.maxChunkTokens === 'number' ? workerContext.maxChunkTokens : undefined;
+const previewLines = typeof workerContext.previewLines === 'number' ? workerContext.previewLines : undefined;
+const tokenizer = typeof workerContext.tokenizer === 'string' ? getTokenizer(workerContext.tokenizer) : undefined;
+const extensionMap =
+  workerContext.extensionMap && typeof workerContext.extensionMap === 'object'
+    ? (workerContext.extensionMap as ExtensionMap)
+    : undefined;
+const chunkGranularity =
+  workerContext.chunkGranularity && typeof workerContext.chunkGranularity === 'object'
+    ? (workerContext.chunkGranularity as ChunkGranularityMap)
+    : undefined;
+const symbolKinds =
+  workerContext.symbolKinds && typeof workerContext.symbolKinds === 'object'
+    ? (workerContext.symbolKinds as SymbolKindFilter)
+    : undefined;
+const wholeFileFallback = workerContext.wholeFileFallback === true;
+const maxChunksPerFile =
+  typeof workerContext.maxChunksPerFile === 'number' ? workerContext.maxChunksPerFile : undefined;
+const chunkOverflow = workerContext.chunkOverflow === 'whole-file' ? 'whole-file' : 'skip';
+const withBlame = workerContext.withBlame === true;
+const languageParser = new LanguageParser(languages, {
+  chunkOverlapLines,
+  embedDocComments,
+  embedContext,
+  maxChunkTokens,
+  previewLines,
+  tokenizer,
+  extensionMap,
+  chunkGranularity,
+  symbolKinds,
+  wholeFileFallback,
+  maxChunksPerFile,
+  chunkOverflow,
+});
 
-const languageParser = new LanguageParser(languages);
+// Every chunk is tagged with the repository it came from, so repositories can share an index.
+const repoMetadata = repoName
+  ? {
+      repo_name: repoName,
+      repo_root: typeof workerContext.repoRoot === 'string' ? workerContext.repoRoot : undefined,
+      commit_sha: typeof workerContext.commitSha === 'string' ? workerContext.commitSha : undefined,
+      repo_url: typeof workerContext.repoUrl === 'string' ? workerContext.repoUrl : undefined,
+      repo_ref: typeof workerContext.repoRef === 'string' ? workerContext.repoRef : undefined,
+    }
+  : {};
 
 parentPort?.on(
   'message',
-  ({ filePath, gitBranch, relativePath }: { filePath: string | null; gitBranch: string; relativePath: string }) => {
+  ({
+    type,
+    filePath,
+    gitBranch,
+    relativePath,
+    inMemoryFile,
+  }: {
+    type?: string;
+    filePath: string | null;
+    gitBranch: string;
+    relativePath: string;
+    inMemoryFile?: InMemoryFile;
+  }) => {
     if (filePath === null) {
       parentPort?.close();
       return;
     }
-
-    try {
-      const result = languageParser.parseFile(filePath, gitBranch, relativePath);
-      parentPort?.postMessage({
-        status: MESSAGE_STATUS_SUCCESS,
-        data: result.chunks,
-        filePath,
-        metrics: result.metrics,
-      });
-    } catch (error) {
-      const errorMessage = error instanceof Error ? error.message : 'An unknown error occurred';
-      logger.error('Failed to parse file', { file: filePath, error: errorMessage });
-
-      // Create base metric data for failure case
-      const failureMetrics: ParseResult['metrics'] = {
-        filesProcessed: 0,
-        filesFailed: 1,
-        chunksCreated: 0,
-        chunksSkipped: 0,
-        chunkSizes: [],
-        language: '',
-        parserType: '',
-      };
-
-      parentPort?.postMessage({
-        status: MESSAGE_STATUS_FAILURE,
-        error: errorMessage,
-        filePath,
-        metrics: failureMetrics,
-      });
+    // Messages are handled in order, so the file being parsed has already been answered.
+    if (type === MESSAGE_TYPE_SHUTDOWN) {
+      parentPort?.postMessage({ status: MESSAGE_STATUS_SHUTDOWN });
+      parentPort?.close();
+      return;
     }
+
+    // Lines logged while parsing, including the parser's, name the file and the thread parsing it.
+    runWithLogContext({ workerId, file: relativePath }, () => {
+      try {
+        // Taken before the file is read, so a later read of the same file always has a greater version.
+        const sourceVersion = Date.now();
+        const result = languageParser.parseFile(filePath, gitBranch, relativePath, inMemoryFile);
+        // Content that is not read from a work tree, such as an archive entry, has no blame.
+        const blame = withBlame
+          ? inMemoryFile
+            ? result.chunks.map(() => ({ last_modified: null, last_author: null }))
+            : blameChunks(
+                filePath,
+                result.chunks.map((chunk) => ({ startLine: chunk.startLine ?? 1, endLine: chunk.endLine ?? 1 }))
+              )
+          : undefined;
+        parentPort?.postMessage({
+          status: MESSAGE_STATUS_SUCCESS,
+          data: result.chunks.map((chunk, i) => ({
+            ...chunk,
+            ...repoMetadata,
+            ...blame?.[i],
+            source_version: sourceVersion,
+          })),
+          filePath,
+          metrics: result.metrics,
+          parseError: result.parseError,
+          chunkOverflow: result.chunkOverflow,
+        });
+      } catch (error) {
+        const errorMessage = error instanceof Error ? error.message : 'An unknown error occurred';
+        logger.error('Failed to parse file', { error: errorMessage });
+
+        // Create base metric data for failure case
+        const failureMetrics: ParseResult['metrics'] = {
+          filesProcessed: 0,
+          filesFailed: 1,
+          chunksCreated: 0,
+          chunksSkipped: 0,
+          chunksSplit: 0,
+          chunkSizes: [],
+          // Names the parser that failed in the recorded parse failure.
+          language: languageParser.getLanguageConfigForFile(filePath)?.name ?? '',
+          parserType: '',
+        };
+
+        parentPort?.postMessage({
+          status: MESSAGE_STATUS_FAILURE,
+          error: errorMessage,
+          filePath,
+          metrics: failureMetrics,
+        });
+      }
+    });
   }
 );
@@ -0,0 +1,520 @@
+import { EtaEstimator, formatDuration } from './eta';
+import { logger as defaultLogger, createLogger, setStatusLine } from './logger';
+import { appConfig } from '../config';
+import type { CodeChunk } from './elasticsearch';
+import type { SkippedFile } from './file_walker';
+import type { ChunkOverflowAction } from './parser';
+import type { RateLimitUtilization } from './rate_limiter';
+import { isIndexingPaused } from './pause_control';
+
+export const PROGRESS_FORMATS = ['text', 'json'] as const;
+export type ProgressFormat = (typeof PROGRESS_FORMATS)[number];
+
+/** How often a progress line is emitted while a phase is running. */
+export const DEFAULT_PROGRESS_INTERVAL_MS = 30 * 1000;
+
+/** How often the progress bar is redrawn on a terminal. */
+export const DEFAULT_PROGRESS_BAR_INTERVAL_MS = 5 * 1000;
+
+/** Characters between the brackets of the progress bar. */
+const PROGRESS_BAR_WIDTH = 30;
+
+export type ProgressPhase = 'enqueue' | 'index';
+
+/** Phases whose wall-clock time is measured, see {@link ProgressReporter.timePhase}. */
+export type TimedPhase = 'enqueue' | 'embed' | 'bulk';
+
+/** Queue counts read once per report during the index phase. */
+export interface QueueDepth {
+  /** Chunks waiting in the queue (pending or processing). */
+  remaining: number;
+  /** Chunks committed since the enqueue started, including those of an interrupted run. */
+  completed: number;
+  /** False while a producer is still adding chunks to the queue. */
+  enqueueCompleted: boolean;
+}
+
+/** A file that failed to parse, for the run summary and `--fail-on-parse-error`. */
+export interface ParseFailure {
+  file: string;
+  error: string;
+  /** Language whose parser failed, when one was chosen. */
+  language?: string;
+  /** The file was indexed as one whole-file chunk instead, see `--whole-file-fallback`. */
+  wholeFile?: boolean;
+}
+
+/** Failures listed by {@link formatParseFailures} before the rest are only counted. */
+const MAX_LISTED_PARSE_FAILURES = 20;
+
+/** Lists parse failures as `path (error)`, noting the files indexed as a whole file. */
+export function formatParseFailures(failures: readonly ParseFailure[]): string {
+  const listed = failures
+    .slice(0, MAX_LISTED_PARSE_FAILURES)
+    .map((failure) => `${failure.file} (${failure.error}${failure.wholeFile ? ', indexed as a whole file' : ''})`)
+    .join(', ');
+  const more = failures.length - MAX_LISTED_PARSE_FAILURES;
+  return more > 0 ? `${listed} and ${more} more` : listed;
+}
+
+/** Groups parse failures by error message, the most frequent first, with the files of each in order. */
+export function groupParseFailures(failures: readonly ParseFailure[]): { error: string; files: string[] }[] {
+  const groups = new Map<string, string[]>();
+  for (const failure of failures) {
+    const files = groups.get(failure.error) ?? [];
+    files.push(failure.file);
+    groups.set(failure.error, files);
+  }
+  return Array.from(groups, ([error, files]) => ({ error, files })).sort((a, b) => b.files.length - a.files.length);
+}
+
+/** A file that produced more chunks than `--max-chunks-per-file`, for the run summary. */
+export interface ChunkOverflowFile {
+  file: string;
+  /** Chunks the file produced before the limit was applied. */
+  chunks: number;
+  /** Whether the file was left out or indexed as one whole-file chunk, see `--chunk-overflow`. */
+  action: ChunkOverflowAction;
+}
+
+/** Lists files over the chunk limit as `path (N chunks, skipped)`, the most chunks first. */
+export function formatChunkOverflows(files: readonly ChunkOverflowFile[]): string {
+  const listed = [...files]
+    .sort((a, b) => b.chunks - a.chunks)
+    .slice(0, MAX_LISTED_PARSE_FAILURES)
+    .map((file) => {
+      const action = file.action === 'skip' ? 'skipped' : 'indexed as a whole file';
+      return `${file.file} (${file.chunks} chunks, ${action})`;
+    })
+    .join(', ');
+  const more = files.length - MAX_LISTED_PARSE_FAILURES;
+  return more > 0 ? `${listed} and ${more} more` : listed;
+}
+
+/** One progress event. In JSON mode each event is written to stdout as a single line. */
+export interface ProgressEvent {
+  type: 'progress';
+  timestamp: string;
+  repo?: string;
+  branch?: string;
+  phase: ProgressPhase;
+  filesTotal: number;
+  filesEnqueued: number;
+  filesFailed: number;
+  /** Files not parsed because they are larger than `--max-file-size` or binary. */
+  filesSkipped: number;
+  chunksProduced: number;
+  /** Bytes of chunk content produced. */
+  bytesProduced: number;
+  chunksIndexed: number;
+  /** Chunks indexed as a reference to an existing chunk document, without being embedded again. */
+  chunksDeduplicated: number;
+  /** Chunks whose location was not written because another run had indexed a later read of the file. */
+  chunksVersionConflicts: number;
+  /** Wall-clock milliseconds spent in each phase so far. */
+  phaseMs: Record<TimedPhase, number>;
+  /** Chunks still waiting in the queue (index phase only). */
+  chunksRemaining?: number;
+  /**
+   * Chunks the queue will have held once the enqueue finishes (index phase only). While files are
+   * still being enqueued it is projected from the share of files done.
+   */
+  chunksTotal?: number;
+  /** Files (enqueue phase) or chunks (index phase) done, in percent, or null while unknown. */
+  percentComplete: number | null;
+  /** Chunks produced (enqueue phase) or indexed (index phase) per second, over a moving window. */
+  chunksPerSecond: number;
+  /** Estimated seconds until the current phase finishes, or null while unknown. */
+  etaSeconds: number | null;
+  /** Embedding requests and tokens over the last minute, when `--embed-rpm` or `--embed-tpm` is set. */
+  embeddingUtilization?: RateLimitUtilization;
+  /** Indexing is paused by SIGUSR1 or `--pause-file`: nothing is dequeued, files are still enqueued. */
+  paused?: boolean;
+}
+
+export interface ProgressReporterOptions {
+  format?: ProgressFormat;
+  repoName?: string;
+  branch?: string;
+  intervalMs?: number;
+  logger?: ReturnType<typeof createLogger>;
+  /** Output for JSON lines (defaults to stdout). */
+  write?: (line: string) => void;
+  now?: () => number;
+  /**
+   * Redraw a progress bar in place instead of logging text lines (defaults to true when stdout is a
+   * TTY and logs are plain text).
+   */
+  bar?: boolean;
+  /** Returns the use of the embedding rate limits. Called once per report. */
+  getEmbeddingUtilization?: () => RateLimitUtilization;
+}
+
+/**
+ * Collects producer and worker progress counters and periodically reports them.
+ *
+ * Recording is a plain counter update on the calling thread, and events are emitted from an
+ * unref'd timer, so reporting never blocks parsing or indexing and never keeps the process alive.
+ */
+export class ProgressReporter {
+  private readonly format: ProgressFormat;
+  private readonly repoName?: string;
+  private readonly branch?: string;
+  private readonly intervalMs: number;
+  private readonly logger: ReturnType<typeof createLogger>;
+  private readonly write: (line: string) => void;
+  private readonly now: () => number;
+  private readonly bar: boolean;
+  private readonly getEmbeddingUtilization?: () => RateLimitUtilization;
+
+  private phase: ProgressPhase = 'enqueue';
+  private timer?: NodeJS.Timeout;
+  private filesTotal = 0;
+  private filesEnqueued = 0;
+  private filesFailed = 0;
+  private readonly skipped: SkippedFile[] = [];
+  private readonly failures: ParseFailure[] = [];
+  private readonly chunkOverflows: ChunkOverflowFile[] = [];
+  private chunksProduced = 0;
+  private bytesProduced = 0;
+  private chunksIndexed = 0;
+  private chunksDeduplicated = 0;
+  private chunksVersionConflicts = 0;
+  private phaseMs: Record<TimedPhase, number> = { enqueue: 0, embed: 0, bulk: 0 };
+  private activePhases: Record<TimedPhase, number> = { enqueue: 0, embed: 0, bulk: 0 };
+  private phaseClockAt?: number;
+  private endEnqueue?: () => void;
+  private getQueueDepth?: () => QueueDepth;
+  private fileEta = new EtaEstimator();
+  private chunkEta = new EtaEstimator();
+
+  constructor(options: ProgressReporterOptions = {}) {
+    this.format = options.format ?? 'text';
+    this.repoName = options.repoName;
+    this.branch = options.branch;
+    // Console output is silent under test, like the logger's.
+    this.bar =
+      this.format === 'text' &&
+      (options.bar ??
+        (process.stdout.isTTY === true && appConfig.logFormat === 'text' && appConfig.nodeEnv !== 'test'));
+    this.intervalMs =
+      options.intervalMs ?? (this.bar ? DEFAULT_PROGRESS_BAR_INTERVAL_MS : DEFAULT_PROGRESS_INTERVAL_MS);
+    this.logger = options.logger ?? defaultLogger;
+    this.write = options.write ?? ((line) => process.stdout.write(`${line}\n`));
+    this.now = options.now ?? Date.now;
+    this.getEmbeddingUtilization = options.getEmbeddingUtilization;
+  }
+
+  /** Starts the enqueue phase for `filesTotal` files. Counters accumulate across calls. */
+  startEnqueue(filesTotal: number): void {
+    this.phase = 'enqueue';
+    this.filesTotal += filesTotal;
+    this.fileEta = new EtaEstimator();
+    this.chunkEta = new EtaEstimator();
+    this.endEnqueue ??= this.beginPhase('enqueue');
+    this.sample();
+    this.startTimer();
+  }
+
+  /** Adds files to the enqueue total, for sources that are counted while they are read, such as archives. */
+  recordFilesFound(count: number): void {
+    this.filesTotal += count;
+  }
+
+  /** Records a parsed file, the number of chunks it enqueued and their total content size. */
+  recordFileEnqueued(chunkCount: number, bytes = 0): void {
+    this.filesEnqueued++;
+    this.chunksProduced += chunkCount;
+    this.bytesProduced += bytes;
+  }
+
+  /** Records a file that failed to parse, listing it in the run summary when `failure` is given. */
+  recordFileFailed(failure?: ParseFailure): void {
+    this.filesFailed++;
+    if (failure) {
+      this.failures.push(failure);
+    }
+  }
+
+  /** Records a file that failed to parse but was enqueued as a whole-file chunk. */
+  recordParseFailure(failure: ParseFailure): void {
+    this.failures.push(failure);
+  }
+
+  /** Files recorded by {@link recordFileFailed} and {@link recordParseFailure}, for the run summary. */
+  get parseFailures(): readonly ParseFailure[] {
+    return this.failures;
+  }
+
+  /** Records a file that produced more chunks than `--max-chunks-per-file`. */
+  recordChunkOverflow(file: ChunkOverflowFile): void {
+    this.chunkOverflows.push(file);
+  }
+
+  /** Files recorded by {@link recordChunkOverflow}, for the run summary. */
+  get chunkOverflowFiles(): readonly ChunkOverflowFile[] {
+    return this.chunkOverflows;
+  }
+
+  /** Records files that were not read, see `filterReadableFiles`. */
+  recordFilesSkipped(files: SkippedFile[]): void {
+    this.skipped.push(...files);
+  }
+
+  /** Files recorded by {@link recordFilesSkipped}, for the run summary. */
+  get skippedFiles(): readonly SkippedFile[] {
+    return this.skipped;
+  }
+
+  /**
+   * Starts the index phase.
+   *
+   * @param getQueueDepth - Returns the counts of the queue being indexed. Called once per report.
+   */
+  startIndexing(getQueueDepth?: () => QueueDepth): void {
+    this.phase = 'index';
+    this.finishEnqueue();
+    this.getQueueDepth = getQueueDepth;
+    this.chunkEta = new EtaEstimator();
+    this.sample();
+    this.startTimer();
+  }
+
+  /**
+   * Records chunks committed to Elasticsearch, `deduplicated` of which reused an existing chunk document
+   * and `versionConflicts` of which kept the newer location another run wrote.
+   */
+  recordIndexed(count: number, deduplicated = 0, versionConflicts = 0): void {
+    this.chunksIndexed += count;
+    this.chunksDeduplicated += deduplicated;
+    this.chunksVersionConflicts += versionConflicts;
+  }
+
+  /**
+   * Runs `fn` and counts the time it takes towards `phase`. Time during which several phases are
+   * running is counted once, towards embed first, then bulk, then enqueue, so the phases add up to
+   * wall-clock time even with concurrent batches.
+   */
+  async timePhase<T>(phase: 'embed' | 'bulk', fn: () => Promise<T>): Promise<T> {
+    const end = this.beginPhase(phase);
+    try {
+      return await fn();
+    } finally {
+      end();
+    }
+  }
+
+  /** Builds an event from the current counters. */
+  snapshot(): ProgressEvent {
+    this.sample();
+    const event: ProgressEvent = {
+      type: 'progress',
+      timestamp: new Date(this.now()).toISOString(),
+      repo: this.repoName,
+      branch: this.branch,
+      phase: this.phase,
+      filesTotal: this.filesTotal,
+      filesEnqueued: this.filesEnqueued,
+      filesFailed: this.filesFailed,
+      filesSkipped: this.skipped.length,
+      chunksProduced: this.chunksProduced,
+      bytesProduced: this.bytesProduced,
+      chunksIndexed: this.chunksIndexed,
+      chunksDeduplicated: this.chunksDeduplicated,
+      chunksVersionConflicts: this.chunksVersionConflicts,
+      phaseMs: this.phaseTimes(),
+      chunksPerSecond: Math.round(this.chunkEta.ratePerSecond * 10) / 10,
+      percentComplete: null,
+      etaSeconds: null,
+      embeddingUtilization: this.getEmbeddingUtilization?.(),
+      ...(isIndexingPaused() ? { paused: true } : {}),
+    };
+
+    const filesDone = this.filesEnqueued + this.filesFailed;
+    if (this.phase === 'enqueue') {
+      event.etaSeconds = this.fileEta.estimateRemainingSeconds(this.filesTotal - filesDone);
+      event.percentComplete = this.filesTotal > 0 ? percent(filesDone, this.filesTotal) : null;
+    } else if (this.getQueueDepth) {
+      const depth = this.getQueueDepth();
+      event.chunksRemaining = depth.remaining;
+      const queued = depth.completed + depth.remaining;
+      // Chunks of the files not enqueued yet are not in the queue, so the total grows with the share
+      // of files done. Without a file count the total is unknown until the enqueue completes.
+      const enqueueShare = depth.enqueueCompleted ? 1 : this.filesTotal > 0 ? filesDone / this.filesTotal : 0;
+      if (enqueueShare > 0) {
+        const total = Math.max(queued, Math.round(queued / enqueueShare));
+        event.chunksTotal = total;
+        event.percentComplete = total > 0 ? percent(depth.completed, total) : 100;
+        event.etaSeconds = this.chunkEta.estimateRemainingSeconds(total - depth.completed);
+      }
+    }
+    return event;
+  }
+
+  /** Emits one progress event. Errors are logged and never propagate to the indexing pipeline. */
+  report(): void {
+    try {
+      const event = this.snapshot();
+      if (this.format === 'json') {
+        this.write(JSON.stringify(event));
+      } else if (this.bar) {
+        setStatusLine(formatProgressBar(event));
+      } else if (appConfig.logFormat === 'json') {
+        // JSON log lines carry the counters as fields; the log phase is tracked by the logger itself.
+        this.logger.info(formatProgressLine(event), {
+          filesTotal: event.filesTotal,
+          filesEnqueued: event.filesEnqueued,
+          filesFailed: event.filesFailed,
+          filesSkipped: event.filesSkipped,
+          chunksProduced: event.chunksProduced,
+          bytesProduced: event.bytesProduced,
+          chunksIndexed: event.chunksIndexed,
+          chunksDeduplicated: event.chunksDeduplicated,
+          chunksVersionConflicts: event.chunksVersionConflicts,
+          chunksRemaining: event.chunksRemaining,
+          chunksTotal: event.chunksTotal,
+          percentComplete: event.percentComplete,
+          chunksPerSecond: event.chunksPerSecond,
+          etaSeconds: event.etaSeconds,
+          embeddingRequestsPerMinute: event.embeddingUtilization?.requestsPerMinute,
+          embeddingTokensPerMinute: event.embeddingUtilization?.tokensPerMinute,
+          paused: event.paused,
+        });
+      } else {
+        this.logger.info(formatProgressLine(event));
+      }
+    } catch (error) {
+      this.logger.warn('Failed to report progress', {
+        error: error instanceof Error ? error.message : String(error),
+      });
+    }
+  }
+
+  /** Emits a final event and stops the timer. */
+  stop(): void {
+    this.finishEnqueue();
+    if (!this.timer) {
+      return;
+    }
+    clearInterval(this.timer);
+    this.timer = undefined;
+    if (this.bar) {
+      // The last state stays in the scrollback as a regular line.
+      setStatusLine(undefined);
+      this.logger.info(formatProgressLine(this.snapshot()));
+      return;
+    }
+    this.report();
+  }
+
+  private finishEnqueue(): void {
+    this.endEnqueue?.();
+    this.endEnqueue = undefined;
+  }
+
+  private beginPhase(phase: TimedPhase): () => void {
+    this.advancePhaseClock();
+    this.activePhases[phase]++;
+    let ended = false;
+    return () => {
+      if (ended) {
+        return;
+      }
+      ended = true;
+      this.advancePhaseClock();
+      this.activePhases[phase]--;
+    };
+  }
+
+  /** Attributes the time since the last phase change to the phase that was running. */
+  private advancePhaseClock(): void {
+    const now = this.now();
+    const running = (['embed', 'bulk', 'enqueue'] as const).find((phase) => this.activePhases[phase] > 0);
+    if (running && this.phaseClockAt !== undefined) {
+      this.phaseMs[running] += now - this.phaseClockAt;
+    }
+    this.phaseClockAt = now;
+  }
+
+  private phaseTimes(): Record<TimedPhase, number> {
+    this.advancePhaseClock();
+    return { ...this.phaseMs };
+  }
+
+  private sample(): void {
+    const now = this.now();
+    this.fileEta.record(this.filesEnqueued + this.filesFailed, now);
+    this.chunkEta.record(this.phase === 'enqueue' ? this.chunksProduced : this.chunksIndexed, now);
+  }
+
+  private startTimer(): void {
+    if (this.timer) {
+      return;
+    }
+    this.timer = setInterval(() => this.report(), this.intervalMs);
+    this.timer.unref();
+  }
+}
+
+/** Total UTF-8 size of the chunks' content, as recorded by {@link ProgressReporter.recordFileEnqueued}. */
+export function chunkContentBytes(chunks: CodeChunk[]): number {
+  return chunks.reduce((total, chunk) => total + Buffer.byteLength(chunk.content), 0);
+}
+
+/** Rounds `done / total` to a percentage with one decimal. */
+function percent(done: number, total: number): number {
+  return Math.min(100, Math.floor((done * 1000) / total) / 10);
+}
+
+/** Formats an event as a progress bar for a terminal, e.g. `[######-----] 42.0% index ...`. */
+export function formatProgressBar(event: ProgressEvent, width: number = PROGRESS_BAR_WIDTH): string {
+  const filled = event.percentComplete === null ? 0 : Math.round((event.percentComplete / 100) * width);
+  const bar = `[${'#'.repeat(filled)}${'-'.repeat(width - filled)}]`;
+  const share = event.percentComplete === null ? '  ?%' : `${event.percentComplete.toFixed(1)}%`;
+  const eta = event.etaSeconds === null ? 'unknown' : formatDuration(event.etaSeconds);
+  const done =
+    event.phase === 'enqueue'
+      ? `${event.filesEnqueued + event.filesFailed}/${event.filesTotal} files`
+      : `${event.chunksIndexed} chunks indexed` +
+        (event.chunksRemaining === undefined ? '' : `, ${event.chunksRemaining} remaining`);
+  return (
+    `${bar} ${share} ${event.phase}${event.paused ? ' (paused)' : ''}: ${done}, ` +
+    `${event.chunksPerSecond} chunks/s, ETA ${eta}${formatEmbeddingUtilization(event.embeddingUtilization)}`
+  );
+}
+
+/** Formats the use of each embedding rate limit, e.g. `, embedding 45/60 rpm (75%)`. */
+function formatEmbeddingUtilization(utilization: RateLimitUtilization | undefined): string {
+  if (!utilization) {
+    return '';
+  }
+  const limits = [
+    [utilization.requestsPerMinute, utilization.requestLimit, 'rpm'],
+    [utilization.tokensPerMinute, utilization.tokenLimit, 'tpm'],
+  ] as const;
+  const used = limits
+    .filter(([, limit]) => limit !== undefined)
+    .map(([value, limit, unit]) => `${value}/${limit} ${unit} (${Math.round((value * 100) / (limit as number))}%)`);
+  return used.length > 0 ? `, embedding ${used.join(', ')}` : '';
+}
+
+/** Formats an event as a single human-readable line. */
+export function formatProgressLine(event: ProgressEvent): string {
+  const eta = event.etaSeconds === null ? 'unknown' : formatDuration(event.etaSeconds);
+  if (event.phase === 'enqueue') {
+    const filesDone = event.filesEnqueued + event.filesFailed;
+    return (
+      `Progress (enqueue): ${filesDone}/${event.filesTotal} files, ${event.chunksProduced} chunks produced, ` +
+      `${event.chunksPerSecond} chunks/s, ETA ${eta}${event.paused ? ', indexing paused' : ''}`
+    );
+  }
+  const remaining = event.chunksRemaining === undefined ? '' : `, ${event.chunksRemaining} remaining`;
+  const share =
+    event.percentComplete === null || event.chunksTotal === undefined
+      ? ''
+      : `, ${event.percentComplete.toFixed(1)}% of ${event.chunksTotal}`;
+  return (
+    `Progress (index${event.paused ? ', paused' : ''}): ${event.chunksIndexed} chunks indexed${remaining}${share}, ` +
+    `${event.chunksPerSecond} chunks/s, ETA ${eta}${formatEmbeddingUtilization(event.embeddingUtilization)}`
+  );
+}
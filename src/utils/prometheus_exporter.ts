@@ -0,0 +1,186 @@
+// src/utils/prometheus_exporter.ts
+import http from 'http';
+import { AddressInfo } from 'net';
+import { Attributes } from '@opentelemetry/api';
+import { DataPointType, MetricData, MetricReader, ResourceMetrics } from '@opentelemetry/sdk-metrics';
+import { addMetricReader, getMeterProvider } from './otel_provider';
+
+/** Content type of the Prometheus text exposition format. */
+export const PROMETHEUS_CONTENT_TYPE = 'text/plain; version=0.0.4; charset=utf-8';
+
+/** Path the metrics are served on. */
+export const PROMETHEUS_METRICS_PATH = '/metrics';
+
+function sanitizeMetricName(name: string): string {
+  return name.replace(/[^a-zA-Z0-9_:]/g, '_').replace(/^([0-9])/, '_$1');
+}
+
+function sanitizeLabelName(name: string): string {
+  return name.replace(/[^a-zA-Z0-9_]/g, '_').replace(/^([0-9])/, '_$1');
+}
+
+function escapeLabelValue(value: string): string {
+  return value.replace(/\\/g, '\\\\').replace(/\n/g, '\\n').replace(/"/g, '\\"');
+}
+
+function escapeHelp(text: string): string {
+  return text.replace(/\\/g, '\\\\').replace(/\n/g, '\\n');
+}
+
+function formatValue(value: number): string {
+  if (Number.isNaN(value)) {
+    return 'NaN';
+  }
+  if (value === Infinity) {
+    return '+Inf';
+  }
+  if (value === -Infinity) {
+    return '-Inf';
+  }
+  return String(value);
+}
+
+function formatLabels(attributes: Attributes, extraLabels: Record<string, string> = {}): string {
+  const labels = [...Object.entries(attributes), ...Object.entries(extraLabels)]
+    .filter(([, value]) => value !== undefined)
+    .map(([name, value]) => `${sanitizeLabelName(name)}="${escapeLabelValue(String(value))}"`);
+  return labels.length > 0 ? `{${labels.join(',')}}` : '';
+}
+
+function serializeMetric(metric: MetricData): string[] {
+  if (metric.dataPoints.length === 0) {
+    return [];
+  }
+  const baseName = sanitizeMetricName(metric.descriptor.name);
+  const header = (name: string, type: string) => [
+    ...(metric.descriptor.description ? [`# HELP ${name} ${escapeHelp(metric.descriptor.description)}`] : []),
+    `# TYPE ${name} ${type}`,
+  ];
+
+  switch (metric.dataPointType) {
+    case DataPointType.SUM: {
+      // Up-down counters can decrease, so they are exposed as gauges.
+      const name = metric.isMonotonic ? `${baseName}_total` : baseName;
+      return [
+        ...header(name, metric.isMonotonic ? 'counter' : 'gauge'),
+        ...metric.dataPoints.map((point) => `${name}${formatLabels(point.attributes)} ${formatValue(point.value)}`),
+      ];
+    }
+    case DataPointType.GAUGE:
+      return [
+        ...header(baseName, 'gauge'),
+        ...metric.dataPoints.map((point) => `${baseName}${formatLabels(point.attributes)} ${formatValue(point.value)}`),
+      ];
+    case DataPointType.HISTOGRAM:
+      return [
+        ...header(baseName, 'histogram'),
+        ...metric.dataPoints.flatMap((point) => {
+          const { boundaries, counts } = point.value.buckets;
+          // OpenTelemetry counts each bucket on its own, Prometheus buckets are cumulative.
+          let cumulativeCount = 0;
+          const buckets = boundaries.map((boundary, i) => {
+            cumulativeCount += counts[i] ?? 0;
+            const labels = formatLabels(point.attributes, { le: formatValue(boundary) });
+            return `${baseName}_bucket${labels} ${cumulativeCount}`;
+          });
+          const labels = formatLabels(point.attributes);
+          return [
+            ...buckets,
+            `${baseName}_bucket${formatLabels(point.attributes, { le: '+Inf' })} ${point.value.count}`,
+            `${baseName}_sum${labels} ${formatValue(point.value.sum ?? 0)}`,
+            `${baseName}_count${labels} ${point.value.count}`,
+          ];
+        }),
+      ];
+    default:
+      // Exponential histograms are not configured for any instrument.
+      return [];
+  }
+}
+
+/**
+ * Serializes collected metrics in the Prometheus text exposition format. Metric names have their dots
+ * replaced by underscores and counters get a `_total` suffix, e.g. `queue.documents.enqueued` is
+ * exposed as `queue_documents_enqueued_total`.
+ */
+export function serializePrometheusMetrics(resourceMetrics: ResourceMetrics): string {
+  const lines = resourceMetrics.scopeMetrics.flatMap((scopeMetrics) => scopeMetrics.metrics.flatMap(serializeMetric));
+  return lines.length > 0 ? `${lines.join('\n')}\n` : '';
+}
+
+/**
+ * Metric reader that serves the metrics recorded in this process on `GET /metrics`, collecting them
+ * on each scrape. Call `listen()` to start the server; it is closed when the reader is shut down.
+ */
+export class PrometheusMetricReader extends MetricReader {
+  private server?: http.Server;
+
+  constructor(private readonly port: number) {
+    super();
+  }
+
+  /**
+   * Starts the HTTP server.
+   *
+   * @returns The port the server listens on, which is chosen by the system when `port` is 0.
+   * @throws When the port cannot be bound, e.g. because it is already in use.
+   */
+  async listen(): Promise<number> {
+    const server = http.createServer((request, response) => void this.handleRequest(request, response));
+    await new Promise<void>((resolve, reject) => {
+      server.once('error', reject);
+      server.listen(this.port, () => {
+        server.off('error', reject);
+        resolve();
+      });
+    });
+    this.server = server;
+    return (server.address() as AddressInfo).port;
+  }
+
+  private async handleRequest(request: http.IncomingMessage, response: http.ServerResponse): Promise<void> {
+    const requestPath = (request.url ?? '').split('?')[0];
+    if (request.method !== 'GET' || requestPath !== PROMETHEUS_METRICS_PATH) {
+      response.writeHead(404, { 'Content-Type': 'text/plain; charset=utf-8' });
+      response.end('Not found\n');
+      return;
+    }
+    try {
+      // Errors of single callbacks are reported by the SDK; the metrics that were collected are still served.
+      const { resourceMetrics } = await this.collect();
+      response.writeHead(200, { 'Content-Type': PROMETHEUS_CONTENT_TYPE });
+      response.end(serializePrometheusMetrics(resourceMetrics));
+    } catch (error) {
+      response.writeHead(500, { 'Content-Type': 'text/plain; charset=utf-8' });
+      response.end(`Failed to collect metrics: ${error instanceof Error ? error.message : String(error)}\n`);
+    }
+  }
+
+  protected async onForceFlush(): Promise<void> {
+    // Metrics are pulled by the scraper, there is nothing to push.
+  }
+
+  protected async onShutdown(): Promise<void> {
+    const server = this.server;
+    this.server = undefined;
+    if (server) {
+      await new Promise<void>((resolve) => server.close(() => resolve()));
+    }
+  }
+}
+
+/**
+ * Serves the metrics of this process in the Prometheus format on `http://<host>:<port>/metrics`.
+ * Metrics are recorded from then on even when the OTLP export is disabled. The server is closed by
+ * `shutdown()` from `otel_provider`.
+ *
+ * @returns The port the server listens on.
+ */
+export async function startMetricsServer(port: number): Promise<number> {
+  const reader = new PrometheusMetricReader(port);
+  const boundPort = await reader.listen();
+  addMetricReader(reader);
+  // Binds the reader right away, so a scrape before the first instrument is created is answered.
+  getMeterProvider();
+  return boundPort;
+}
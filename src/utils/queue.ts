@@ -1,15 +1,82 @@
 import { CodeChunk } from './elasticsearch';
+import type { ParseFailure } from './progress_reporter';
 
 export interface QueuedDocument {
   id: string;
   document: CodeChunk;
+  /** Identifies the dequeue that claimed the document. Only that claim may requeue it. */
+  leaseId?: string;
+}
+
+/**
+ * Row id range assigned to a batch of enqueued documents (inclusive).
+ */
+export interface EnqueueResult {
+  firstId: number;
+  lastId: number;
+}
+
+export interface EnqueueOptions {
+  /** Higher priorities are dequeued first. Defaults to `QUEUE_PRIORITY_DEFAULT`. */
+  priority?: number;
+  /**
+   * File the documents were parsed from. Queues that support resuming record it (even when no
+   * documents were produced) so an interrupted enqueue can skip the file on the next run.
+   */
+  sourceFile?: EnqueuedFile;
+}
+
+/** A source file recorded during an enqueue session, keyed by its repository-relative path. */
+export interface EnqueuedFile {
+  filePath: string;
+  /** File modification time (epoch ms) when the file was parsed. Needed to resume an interrupted enqueue. */
+  mtimeMs?: number;
+  /**
+   * SHA-256 of the file content. It becomes the file's indexed hash once all of its documents are
+   * committed, so an unchanged file can be skipped on the next run.
+   */
+  sha256?: string;
+  /** Chunks the file has, when some are indexed already and not enqueued again (default: the documents enqueued). */
+  chunkCount?: number;
+  /** Index the file's documents are written to, recorded with its indexed hash. */
+  indexName?: string;
+}
+
+/** A file whose documents were all indexed, as recorded by {@link IQueueWithEnqueueMetadata.getIndexedFiles}. */
+export interface IndexedFile {
+  /** Path relative to the repository root. */
+  filePath: string;
+  /** Content hash of the file when it was indexed. */
+  sha256: string;
+  indexedAt: string;
+  /** Chunks the file had, 0 for a file without chunks. */
+  chunkCount: number;
+  /** Index the file was written to, null for files recorded before the index name was kept. */
+  indexName: string | null;
+}
+
+/** A file whose last parse failed, as recorded by {@link IQueueWithEnqueueMetadata.recordParseFailure}. */
+export interface RecordedParseFailure {
+  /** Path relative to the repository root. */
+  filePath: string;
+  /** Language whose parser failed, null when no parser was chosen. */
+  language: string | null;
+  error: string;
+  /** The file was indexed as one whole-file chunk instead, see `--whole-file-fallback`. */
+  wholeFile: boolean;
+  failedAt: string;
+}
+
+export interface RequeueOptions {
+  /** Error message per queued document id, stored as the document's last error. */
+  errors?: Map<string, string>;
 }
 
 export interface IQueue {
-  enqueue(documents: CodeChunk[]): Promise<void>;
+  enqueue(documents: CodeChunk[], options?: EnqueueOptions): Promise<EnqueueResult | void>;
   dequeue(count: number): Promise<QueuedDocument[]>;
   commit(documents: QueuedDocument[]): Promise<void>;
-  requeue(documents: QueuedDocument[]): Promise<void>;
+  requeue(documents: QueuedDocument[], options?: RequeueOptions): Promise<void>;
   clear(): Promise<void>;
   markEnqueueCompleted(): Promise<void>;
   isEnqueueCompleted(): boolean;
@@ -25,4 +92,24 @@ export interface IQueueWithEnqueueMetadata extends IQueue {
   markEnqueueStarted(): Promise<void>;
   setEnqueueCommitHash(commitHash: string): Promise<void>;
   getEnqueueCommitHash(): string | null;
+  /** Files recorded by the current (incomplete) enqueue session, mapped to their modification time. */
+  getEnqueuedFiles(): Map<string, number>;
+  /** Content hashes of files whose documents were all indexed, keyed by file path. */
+  getFileHashes(): Map<string, string>;
+  /** Files whose documents were all indexed, with their hash, chunk count and index. */
+  getIndexedFiles(indexName?: string): IndexedFile[];
+  /** Forgets the indexed hash of files whose documents were removed from the index. */
+  deleteFileHashes(filePaths: string[]): Promise<void>;
+  /** Forgets every indexed hash, e.g. when the index itself is deleted. */
+  clearFileHashes(): Promise<void>;
+  /**
+   * Marks re-indexed files whose locations written before `indexedBefore` (epoch ms) are stale. The
+   * indexer worker deletes them once the files' new documents are indexed, except `keptLocationIds`:
+   * locations the files still have but that were not enqueued again.
+   */
+  markLocationsStale(filePaths: string[], indexedBefore: number, keptLocationIds?: string[]): Promise<void>;
+  /** Records that a file failed to parse, replacing an earlier failure of the same file. */
+  recordParseFailure(failure: ParseFailure): void;
+  /** Forgets the parse failures of files that parsed again or were deleted. */
+  clearParseFailures(filePaths: string[]): void;
 }
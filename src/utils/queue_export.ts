@@ -0,0 +1,283 @@
+import fs from 'fs';
+import path from 'path';
+import { createHash } from 'crypto';
+import { QUEUE_STATE_TABLES, QueueStateTable, SqliteQueue } from './sqlite_queue';
+
+/** Identifies queue export files, see {@link exportQueue}. */
+export const QUEUE_EXPORT_FORMAT = 'scs-queue-export';
+export const QUEUE_EXPORT_VERSION = 1;
+
+/**
+ * Document fields kept in an export: where a chunk comes from and its hashes, but nothing derived from
+ * the source, such as its content, symbols, imports or vector.
+ */
+const EXPORTED_DOCUMENT_FIELDS = [
+  'type',
+  'language',
+  'kind',
+  'filePath',
+  'directoryPath',
+  'directoryName',
+  'directoryDepth',
+  'git_file_hash',
+  'git_branch',
+  'repo_name',
+  'repo_root',
+  'repo_url',
+  'repo_ref',
+  'commit_sha',
+  'chunk_hash',
+  'startLine',
+  'endLine',
+  'chunkIndex',
+  'totalChunks',
+  'created_at',
+  'updated_at',
+];
+
+/** Columns holding a file path, per table. */
+const PATH_COLUMNS: Partial<Record<QueueStateTable, string>> = {
+  indexed_files: 'file_path',
+  pending_file_hashes: 'file_path',
+  enqueued_files: 'file_path',
+  stale_location_files: 'file_path',
+  parse_failures: 'file_path',
+};
+
+/** Tables renamed since exports were first written, so older exports can still be imported. */
+const LEGACY_TABLE_NAMES: Record<string, QueueStateTable> = {
+  file_hashes: 'indexed_files',
+};
+
+export type QueueExportFormat = 'json' | 'ndjson';
+
+export interface QueueExportHeader {
+  format: typeof QUEUE_EXPORT_FORMAT;
+  version: number;
+  repoName: string;
+  exportedAt: string;
+  /** True when directory names were replaced by hashes, see {@link redactPath}. */
+  redactedPaths: boolean;
+}
+
+/** One row of a queue state table. */
+export interface QueueExportRecord {
+  table: QueueStateTable;
+  row: Record<string, unknown>;
+}
+
+export interface QueueExportOptions {
+  repoName: string;
+  redactPaths?: boolean;
+  /** Default: `json` for files ending in `.json`, `ndjson` otherwise, see {@link getExportFormat}. */
+  format?: QueueExportFormat;
+}
+
+/** Picks the export format from a file name: `.json` files hold one JSON object, others one record per line. */
+export function getExportFormat(filePath: string): QueueExportFormat {
+  return path.extname(filePath).toLowerCase() === '.json' ? 'json' : 'ndjson';
+}
+
+/** Replaces a directory with a short hash of it, so files in the same directory keep a common prefix. */
+export function redactDirectory(directory: string): string {
+  if (directory === '' || directory === '.') {
+    return directory;
+  }
+  return `dir-${createHash('sha256').update(directory).digest('hex').slice(0, 12)}`;
+}
+
+/** Replaces the directory of a path with its hash and keeps the file name, e.g. `dir-1a2b3c4d5e6f/index.ts`. */
+export function redactPath(filePath: string): string {
+  const directory = path.posix.dirname(filePath.replace(/\\/g, '/'));
+  const baseName = path.posix.basename(filePath.replace(/\\/g, '/'));
+  return directory === '.' ? baseName : `${redactDirectory(directory)}/${baseName}`;
+}
+
+/** Keeps the non-source fields of a queued document, with its paths redacted when asked. */
+function exportDocument(document: string, redactPaths: boolean): string {
+  let parsed: Record<string, unknown>;
+  try {
+    parsed = JSON.parse(document) as Record<string, unknown>;
+  } catch {
+    // Unreadable documents are kept as such, without their text, so the row can still be reproduced.
+    return JSON.stringify({ unreadable: true, content: '', semantic_text: '' });
+  }
+  const exported: Record<string, unknown> = {};
+  for (const field of EXPORTED_DOCUMENT_FIELDS) {
+    if (parsed[field] !== undefined) {
+      exported[field] = parsed[field];
+    }
+  }
+  if (redactPaths) {
+    if (typeof exported.filePath === 'string') {
+      exported.filePath = redactPath(exported.filePath);
+    }
+    if (typeof exported.directoryPath === 'string') {
+      exported.directoryName = redactDirectory(exported.directoryPath);
+      exported.directoryPath = redactDirectory(exported.directoryPath);
+    }
+    if (typeof exported.repo_root === 'string') {
+      exported.repo_root = redactDirectory(exported.repo_root);
+    }
+  }
+  // Kept so an imported queue holds valid chunk documents.
+  return JSON.stringify({ ...exported, content: '', semantic_text: '' });
+}
+
+/** Replaces the paths of a document in its last error, which often quotes them. */
+function redactError(error: string, document: string): string {
+  let redacted = error;
+  try {
+    const parsed = JSON.parse(document) as { filePath?: unknown; repo_root?: unknown };
+    if (typeof parsed.repo_root === 'string' && parsed.repo_root !== '') {
+      redacted = redacted.split(parsed.repo_root).join(redactDirectory(parsed.repo_root));
+    }
+    if (typeof parsed.filePath === 'string' && parsed.filePath !== '') {
+      redacted = redacted.split(parsed.filePath).join(redactPath(parsed.filePath));
+    }
+  } catch {
+    // The document is unreadable, so there are no known paths to replace.
+  }
+  return redacted;
+}
+
+function emptyCounts(): Record<QueueStateTable, number> {
+  return Object.fromEntries(QUEUE_STATE_TABLES.map((table) => [table, 0])) as Record<QueueStateTable, number>;
+}
+
+function exportRow(
+  table: QueueStateTable,
+  row: Record<string, unknown>,
+  redactPaths: boolean
+): Record<string, unknown> {
+  if ((table === 'queue' || table === 'dead_letter') && typeof row.document === 'string') {
+    const lastError =
+      redactPaths && typeof row.last_error === 'string' ? redactError(row.last_error, row.document) : row.last_error;
+    return { ...row, document: exportDocument(row.document, redactPaths), last_error: lastError };
+  }
+  const pathColumn = PATH_COLUMNS[table];
+  if (redactPaths && pathColumn && typeof row[pathColumn] === 'string') {
+    return { ...row, [pathColumn]: redactPath(row[pathColumn] as string) };
+  }
+  return row;
+}
+
+/**
+ * Writes the state of a queue to `filePath`: its metadata, the rows of every status (pending,
+ * processing, and dead-lettered), the hashes of the files already indexed and the files of the
+ * current enqueue. Documents keep only their paths, line ranges and hashes, never their source.
+ * Rows are streamed, so large queues are not held in memory.
+ *
+ * @returns The number of rows written per table.
+ */
+export function exportQueue(
+  queue: SqliteQueue,
+  filePath: string,
+  options: QueueExportOptions
+): Record<QueueStateTable, number> {
+  const format = options.format ?? getExportFormat(filePath);
+  const redactPaths = options.redactPaths ?? false;
+  const header: QueueExportHeader = {
+    format: QUEUE_EXPORT_FORMAT,
+    version: QUEUE_EXPORT_VERSION,
+    repoName: options.repoName,
+    exportedAt: new Date().toISOString(),
+    redactedPaths: redactPaths,
+  };
+  const counts = emptyCounts();
+
+  const fd = fs.openSync(filePath, 'w');
+  try {
+    if (format === 'json') {
+      fs.writeSync(fd, `${JSON.stringify(header).slice(0, -1)},"records":[`);
+    } else {
+      fs.writeSync(fd, `${JSON.stringify(header)}\n`);
+    }
+    let first = true;
+    for (const table of QUEUE_STATE_TABLES) {
+      for (const row of queue.iterateTableRows(table)) {
+        const record: QueueExportRecord = { table, row: exportRow(table, row, redactPaths) };
+        if (format === 'json') {
+          fs.writeSync(fd, `${first ? '' : ','}\n${JSON.stringify(record)}`);
+        } else {
+          fs.writeSync(fd, `${JSON.stringify(record)}\n`);
+        }
+        first = false;
+        counts[table]++;
+      }
+    }
+    if (format === 'json') {
+      fs.writeSync(fd, '\n]}\n');
+    }
+  } finally {
+    fs.closeSync(fd);
+  }
+  return counts;
+}
+
+/**
+ * Reads a file written by {@link exportQueue}, in the format given by its name.
+ *
+ * @throws When the file is not a queue export or was written by a newer version.
+ */
+export function readQueueExport(filePath: string): { header: QueueExportHeader; records: QueueExportRecord[] } {
+  const text = fs.readFileSync(filePath, 'utf8');
+  let header: QueueExportHeader;
+  let records: unknown[];
+  try {
+    if (getExportFormat(filePath) === 'json') {
+      const { records: jsonRecords, ...jsonHeader } = JSON.parse(text) as QueueExportHeader & { records?: unknown };
+      header = jsonHeader;
+      records = Array.isArray(jsonRecords) ? jsonRecords : [];
+    } else {
+      const lines = text.split('\n').filter((line) => line.trim() !== '');
+      header = JSON.parse(lines[0] ?? '{}') as QueueExportHeader;
+      records = lines.slice(1).map((line) => JSON.parse(line) as unknown);
+    }
+  } catch (error) {
+    throw new Error(`${filePath} is not a queue export: ${error instanceof Error ? error.message : String(error)}`);
+  }
+  if (header.format !== QUEUE_EXPORT_FORMAT) {
+    throw new Error(`${filePath} is not a queue export.`);
+  }
+  if (typeof header.version !== 'number' || header.version > QUEUE_EXPORT_VERSION) {
+    throw new Error(`${filePath} was written by a newer version (format version ${header.version}).`);
+  }
+  const tables = new Set<string>(QUEUE_STATE_TABLES);
+  const validRecords = records.map((record): QueueExportRecord => {
+    const { table, row } = (record ?? {}) as { table?: unknown; row?: unknown };
+    const name = typeof table === 'string' ? (LEGACY_TABLE_NAMES[table] ?? table) : undefined;
+    if (name === undefined || !tables.has(name) || !row || typeof row !== 'object') {
+      throw new Error(`${filePath} holds an invalid record: ${JSON.stringify(record)}`);
+    }
+    return { table: name as QueueStateTable, row: row as Record<string, unknown> };
+  });
+  return { header, records: validRecords };
+}
+
+/**
+ * Loads an export read by {@link readQueueExport} into a new queue database at `dbPath`.
+ *
+ * @throws When `dbPath` exists already, so an import never overwrites a live queue.
+ * @returns The number of rows loaded per table.
+ */
+export async function importQueue(
+  exported: { header: QueueExportHeader; records: QueueExportRecord[] },
+  dbPath: string
+): Promise<Record<QueueStateTable, number>> {
+  if (fs.existsSync(dbPath)) {
+    throw new Error(`A queue database already exists at ${dbPath}.`);
+  }
+  const queue = new SqliteQueue({ dbPath, repoName: exported.header.repoName, branch: 'unknown' });
+  await queue.initialize();
+  try {
+    const counts = emptyCounts();
+    for (const table of QUEUE_STATE_TABLES) {
+      const rows = exported.records.filter((record) => record.table === table).map((record) => record.row);
+      counts[table] = queue.insertTableRows(table, rows);
+    }
+    return counts;
+  } finally {
+    queue.close();
+  }
+}
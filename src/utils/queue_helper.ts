@@ -1,6 +1,22 @@
 import fs from 'fs';
 import path from 'path';
 import { appConfig } from '../config';
+import { SqliteQueue } from './sqlite_queue';
+import { listQueueShardPaths, openQueueShards, ShardedQueue } from './queue_shards';
+
+/**
+ * Lists the repositories that have a queue database in the queue base directory.
+ */
+export function listQueueRepoNames(): string[] {
+  const queueBaseDir = appConfig.queueBaseDir;
+  if (!fs.existsSync(queueBaseDir)) {
+    return [];
+  }
+  return fs
+    .readdirSync(queueBaseDir)
+    .filter((name) => fs.existsSync(path.join(queueBaseDir, name, 'queue.db')))
+    .sort();
+}
 
 /**
  * Auto-detect repository name if not specified
@@ -21,10 +37,7 @@ export function resolveRepoName(repoName?: string): string {
     process.exit(1);
   }
 
-  const repos = fs.readdirSync(queueBaseDir).filter((name) => {
-    const queueDbPath = path.join(queueBaseDir, name, 'queue.db');
-    return fs.existsSync(queueDbPath);
-  });
+  const repos = listQueueRepoNames();
 
   if (repos.length === 1) {
     console.log(`Auto-detected repository: ${repos[0]}`);
@@ -53,3 +66,52 @@ export function getQueueDir(repoName: string): string {
 export function getQueueDbPath(repoName: string): string {
   return path.join(getQueueDir(repoName), 'queue.db');
 }
+
+/**
+ * Opens the queue of an already indexed repository for the `queue:*` maintenance commands.
+ * Schema upgrades are applied, so queues created by older versions can be inspected.
+ *
+ * @throws If the queue database does not exist.
+ */
+export async function openExistingQueue(repoName: string): Promise<SqliteQueue> {
+  const dbPath = getQueueDbPath(repoName);
+  if (!fs.existsSync(dbPath)) {
+    throw new Error(`Queue database not found at ${dbPath}`);
+  }
+  const queue = new SqliteQueue({ dbPath, repoName, branch: 'unknown' });
+  await queue.initialize();
+  return queue;
+}
+
+/**
+ * Paths of the shards of a repository's queue, see `--queue-shards`. Only the queue database itself
+ * is listed when the queue is not sharded or does not exist.
+ */
+export function getQueueDbPaths(repoName: string): string[] {
+  const paths = listQueueShardPaths(getQueueDir(repoName));
+  return paths.length > 0 ? paths : [getQueueDbPath(repoName)];
+}
+
+/**
+ * Opens every shard of the queue of an already indexed repository, in shard order, for the
+ * maintenance commands that handle each shard on its own.
+ *
+ * @throws If the queue database does not exist.
+ */
+export async function openExistingQueueShards(repoName: string): Promise<SqliteQueue[]> {
+  const dbPath = getQueueDbPath(repoName);
+  if (!fs.existsSync(dbPath)) {
+    throw new Error(`Queue database not found at ${dbPath}`);
+  }
+  const shards = getQueueDbPaths(repoName).length;
+  return openQueueShards(getQueueDir(repoName), { repoName, branch: 'unknown', shards });
+}
+
+/**
+ * Opens the queue of an already indexed repository with its counts summed over all of its shards.
+ *
+ * @throws If the queue database does not exist.
+ */
+export async function openExistingShardedQueue(repoName: string): Promise<ShardedQueue> {
+  return new ShardedQueue(await openExistingQueueShards(repoName));
+}
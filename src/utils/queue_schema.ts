@@ -0,0 +1,216 @@
+import Database from 'better-sqlite3';
+import { QUEUE_PRIORITY_DEFAULT, QUEUE_STATUS_FAILED, QUEUE_STATUS_PENDING } from './constants';
+import type { Logger } from './logger';
+
+/** File path of a queued document, used to find the remaining documents of a file. */
+export const DOCUMENT_FILE_PATH = "json_extract(document, '$.filePath')";
+
+/** A step of the queue schema, applied once to databases of the previous version. */
+export interface QueueMigration {
+  version: number;
+  description: string;
+  migrate: (db: Database.Database) => void;
+}
+
+/**
+ * Adds a column unless the table has it already. Databases written before the schema was versioned
+ * may have any of the columns of a later step, so every step is written to be applied again safely.
+ */
+function addColumn(db: Database.Database, table: string, column: string, definition: string): void {
+  const columns = db.prepare(`PRAGMA table_info(${table})`).all() as Array<{ name: string }>;
+  if (!columns.some((existing) => existing.name === column)) {
+    db.exec(`ALTER TABLE ${table} ADD COLUMN ${column} ${definition};`);
+  }
+}
+
+function hasTable(db: Database.Database, table: string): boolean {
+  return db.prepare("SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?").get(table) !== undefined;
+}
+
+/** Steps of the queue schema in the order they are applied. Append new steps; never edit released ones. */
+export const QUEUE_MIGRATIONS: QueueMigration[] = [
+  {
+    version: 1,
+    description: 'queue, queue metadata and file hashes',
+    migrate: (db) => {
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS queue (
+          id INTEGER PRIMARY KEY AUTOINCREMENT,
+          batch_id TEXT NOT NULL,
+          document TEXT NOT NULL,
+          status TEXT NOT NULL DEFAULT '${QUEUE_STATUS_PENDING}',
+          retry_count INTEGER NOT NULL DEFAULT 0,
+          created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+      db.exec('CREATE INDEX IF NOT EXISTS idx_status_created ON queue (status, created_at);');
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS queue_metadata (
+          key TEXT PRIMARY KEY,
+          value TEXT NOT NULL,
+          updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+      // A database that already has its successor keeps it; see version 4.
+      if (!hasTable(db, 'indexed_files')) {
+        db.exec(`
+          CREATE TABLE IF NOT EXISTS file_hashes (
+            file_path TEXT PRIMARY KEY,
+            sha256 TEXT NOT NULL,
+            indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+          );
+        `);
+      }
+    },
+  },
+  {
+    version: 2,
+    description: 'processing start and worker pid of claimed documents',
+    migrate: (db) => {
+      addColumn(db, 'queue', 'processing_started_at', 'TIMESTAMP');
+      addColumn(db, 'queue', 'worker_pid', 'INTEGER');
+    },
+  },
+  {
+    version: 3,
+    description: 'files of the current enqueue session',
+    migrate: (db) => {
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS enqueued_files (
+          file_path TEXT PRIMARY KEY,
+          mtime_ms INTEGER NOT NULL,
+          enqueued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+    },
+  },
+  {
+    version: 4,
+    description: 'indexed files with their chunk count and index, committed with their last document',
+    migrate: (db) => {
+      if (hasTable(db, 'file_hashes')) {
+        db.exec('ALTER TABLE file_hashes RENAME TO indexed_files;');
+      }
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS indexed_files (
+          file_path TEXT PRIMARY KEY,
+          sha256 TEXT NOT NULL,
+          indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS pending_file_hashes (
+          file_path TEXT PRIMARY KEY,
+          sha256 TEXT NOT NULL
+        );
+      `);
+      for (const table of ['indexed_files', 'pending_file_hashes']) {
+        addColumn(db, table, 'chunk_count', 'INTEGER NOT NULL DEFAULT 0');
+        addColumn(db, table, 'index_name', 'TEXT');
+      }
+      db.exec(`CREATE INDEX IF NOT EXISTS idx_queue_file_path ON queue (${DOCUMENT_FILE_PATH});`);
+    },
+  },
+  {
+    version: 5,
+    description: 'priorities, leases, retry backoff and the dead-letter table',
+    migrate: (db) => {
+      addColumn(db, 'queue', 'lease_id', 'TEXT');
+      addColumn(db, 'queue', 'priority', `INTEGER NOT NULL DEFAULT ${QUEUE_PRIORITY_DEFAULT}`);
+      addColumn(db, 'queue', 'next_attempt_at', 'INTEGER');
+      addColumn(db, 'queue', 'last_error', 'TEXT');
+      addColumn(db, 'queue', 'last_error_at', 'TIMESTAMP');
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS dead_letter (
+          id INTEGER PRIMARY KEY,
+          batch_id TEXT NOT NULL,
+          document TEXT NOT NULL,
+          priority INTEGER NOT NULL DEFAULT ${QUEUE_PRIORITY_DEFAULT},
+          attempts INTEGER NOT NULL,
+          last_error TEXT,
+          last_error_at TIMESTAMP,
+          dead_lettered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+      // Documents left in the terminal `failed` status of older versions are dead-lettered.
+      const failed = `status = '${QUEUE_STATUS_FAILED}'`;
+      db.exec(
+        `DELETE FROM pending_file_hashes WHERE file_path IN (SELECT ${DOCUMENT_FILE_PATH} FROM queue WHERE ${failed})`
+      );
+      db.exec(
+        `INSERT OR REPLACE INTO dead_letter (id, batch_id, document, priority, attempts, last_error, last_error_at)
+         SELECT id, batch_id, document, priority, retry_count + 1, last_error, last_error_at FROM queue WHERE ${failed}`
+      );
+      db.exec(`DELETE FROM queue WHERE ${failed}`);
+      db.exec('CREATE INDEX IF NOT EXISTS idx_status_priority_id ON queue (status, priority DESC, id);');
+    },
+  },
+  {
+    version: 6,
+    description: 'stale locations of re-indexed files and parse failures',
+    migrate: (db) => {
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS stale_location_files (
+          file_path TEXT PRIMARY KEY,
+          indexed_before INTEGER NOT NULL
+        );
+      `);
+      addColumn(db, 'stale_location_files', 'kept_location_ids', 'TEXT');
+      db.exec(`
+        CREATE TABLE IF NOT EXISTS parse_failures (
+          file_path TEXT PRIMARY KEY,
+          language TEXT,
+          error TEXT NOT NULL,
+          whole_file INTEGER NOT NULL DEFAULT 0,
+          failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+    },
+  },
+];
+
+/** Schema version of the queue databases this indexer writes. */
+export const QUEUE_SCHEMA_VERSION = QUEUE_MIGRATIONS[QUEUE_MIGRATIONS.length - 1].version;
+
+/** Returns the schema version recorded in a queue database, 0 for one written before versioning. */
+export function getQueueSchemaVersion(db: Database.Database): number {
+  return db.pragma('user_version', { simple: true }) as number;
+}
+
+/**
+ * Brings a queue database to {@link QUEUE_SCHEMA_VERSION}: applies the steps after the version in its
+ * `user_version` pragma, in order and in one transaction, so a failed step leaves the database as it
+ * was. A database written before versioning (version 0) goes through every step.
+ *
+ * @returns The version the database had before.
+ * @throws When the database was written by a newer indexer, whose schema this one cannot know.
+ */
+export function migrateQueueSchema(db: Database.Database, dbPath: string, logger?: Logger): number {
+  const fromVersion = getQueueSchemaVersion(db);
+  if (fromVersion > QUEUE_SCHEMA_VERSION) {
+    throw new Error(
+      `Queue database ${dbPath} has schema version ${fromVersion}, newer than version ${QUEUE_SCHEMA_VERSION} ` +
+        'supported by this indexer. Upgrade the indexer, or remove the queue directory to start over.'
+    );
+  }
+  if (fromVersion === QUEUE_SCHEMA_VERSION) {
+    return fromVersion;
+  }
+
+  const tables = db.prepare("SELECT COUNT(*) AS count FROM sqlite_master WHERE type = 'table'").get() as {
+    count: number;
+  };
+  db.transaction(() => {
+    for (const migration of QUEUE_MIGRATIONS) {
+      if (migration.version > fromVersion) {
+        migration.migrate(db);
+      }
+    }
+    db.pragma(`user_version = ${QUEUE_SCHEMA_VERSION}`);
+  })();
+  // A new database is created at the current version, so only upgrades are logged.
+  if (tables.count > 0) {
+    logger?.info(`Migrated queue database ${dbPath} from schema version ${fromVersion} to ${QUEUE_SCHEMA_VERSION}`);
+  }
+  return fromVersion;
+}
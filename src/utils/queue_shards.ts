@@ -0,0 +1,281 @@
+import fs from 'fs';
+import path from 'path';
+import { createHash } from 'crypto';
+import { CodeChunk } from './elasticsearch';
+import type { ParseFailure } from './progress_reporter';
+import {
+  EnqueueOptions,
+  EnqueueResult,
+  IndexedFile,
+  IQueueWithEnqueueMetadata,
+  QueuedDocument,
+  RecordedParseFailure,
+  RequeueOptions,
+} from './queue';
+import { DeadLetterEntry, SqliteQueue } from './sqlite_queue';
+
+/** File name of the first shard, which is the whole queue of an unsharded repository. */
+export const QUEUE_DB_FILE_NAME = 'queue.db';
+
+/** Upper bound of `--queue-shards`; each shard is a database file with its own writer. */
+export const MAX_QUEUE_SHARDS = 64;
+
+/** Path of shard `shard` of the queue in `queueDir`: `queue.db` for shard 0, `queue-shard-<n>.db` otherwise. */
+export function getQueueShardPath(queueDir: string, shard: number): string {
+  return path.join(queueDir, shard === 0 ? QUEUE_DB_FILE_NAME : `queue-shard-${shard}.db`);
+}
+
+/** Paths of the queue shards that exist in `queueDir`, in shard order. Empty when there is no queue yet. */
+export function listQueueShardPaths(queueDir: string): string[] {
+  const paths: string[] = [];
+  for (let shard = 0; shard < MAX_QUEUE_SHARDS; shard++) {
+    const shardPath = getQueueShardPath(queueDir, shard);
+    if (!fs.existsSync(shardPath)) {
+      break;
+    }
+    paths.push(shardPath);
+  }
+  return paths;
+}
+
+/** Deletes every queue shard in `queueDir`, with its WAL files, so the queue can be created with another count. */
+export function removeQueueShards(queueDir: string): void {
+  for (const shardPath of listQueueShardPaths(queueDir)) {
+    for (const suffix of ['', '-wal', '-shm']) {
+      fs.rmSync(`${shardPath}${suffix}`, { force: true });
+    }
+  }
+}
+
+/**
+ * Shard count of a run in `queueDir`: `requested` (default: the count of the existing queue, or 1).
+ * A queue with another count is only resharded by a clean run, since its files would be routed to
+ * other shards; the existing shards are removed then.
+ *
+ * @throws When the existing queue has another count and `clean` is not set.
+ */
+export function resolveQueueShardCount(queueDir: string, requested: number | undefined, clean: boolean): number {
+  const existing = listQueueShardPaths(queueDir).length;
+  if (requested === undefined || existing === 0 || existing === requested) {
+    return requested ?? Math.max(existing, 1);
+  }
+  if (!clean) {
+    throw new Error(
+      `The queue in ${queueDir} has ${existing} shards, but --queue-shards is ${requested}. ` +
+        'Run with --clean to reshard it.'
+    );
+  }
+  removeQueueShards(queueDir);
+  return requested;
+}
+
+/** Shard of the queue that holds the documents and the state of `filePath`. */
+export function getQueueShard(filePath: string, shardCount: number): number {
+  if (shardCount <= 1) {
+    return 0;
+  }
+  return createHash('sha1').update(filePath).digest().readUInt32BE(0) % shardCount;
+}
+
+export interface QueueShardOptions {
+  repoName?: string;
+  branch?: string;
+  maxAttempts?: number;
+  /** Database files the queue is split into (default: 1). */
+  shards?: number;
+}
+
+/** Opens and initializes each shard of the queue in `queueDir`, creating the missing ones. */
+export async function openQueueShards(queueDir: string, options: QueueShardOptions = {}): Promise<SqliteQueue[]> {
+  const shardCount = options.shards ?? 1;
+  const queues: SqliteQueue[] = [];
+  for (let shard = 0; shard < shardCount; shard++) {
+    const queue = new SqliteQueue({
+      dbPath: getQueueShardPath(queueDir, shard),
+      repoName: options.repoName,
+      branch: options.branch,
+      maxAttempts: options.maxAttempts,
+      ...(shardCount > 1 ? { shard } : {}),
+    });
+    await queue.initialize();
+    queues.push(queue);
+  }
+  return queues;
+}
+
+/**
+ * Opens the queue in `queueDir` for a producer: the queue database itself, or a {@link ShardedQueue}
+ * over its shards when it is split into several.
+ */
+export async function openQueue(
+  queueDir: string,
+  options: QueueShardOptions = {}
+): Promise<SqliteQueue | ShardedQueue> {
+  const shards = await openQueueShards(queueDir, options);
+  return shards.length === 1 ? shards[0] : new ShardedQueue(shards);
+}
+
+function groupByShard<T>(items: T[], shardCount: number, getFilePath: (item: T) => string | undefined): T[][] {
+  const groups: T[][] = Array.from({ length: shardCount }, () => []);
+  for (const item of items) {
+    groups[getQueueShard(getFilePath(item) ?? '', shardCount)].push(item);
+  }
+  return groups;
+}
+
+/**
+ * A queue split into several SQLite files by the path of the file each document was parsed from, so
+ * that the workers bound to each shard do not contend for one writer. All the documents and the state
+ * of a file live in one shard.
+ *
+ * Documents dequeued here are committed and requeued in the shard of their file path, and row ids
+ * are only unique within a shard. Counts are summed over the shards, and the enqueue session is
+ * completed only once every shard has completed it.
+ */
+export class ShardedQueue implements IQueueWithEnqueueMetadata {
+  constructor(readonly shards: SqliteQueue[]) {}
+
+  private shardOf(filePath: string | undefined): SqliteQueue {
+    return this.shards[getQueueShard(filePath ?? '', this.shards.length)];
+  }
+
+  async enqueue(documents: CodeChunk[], options?: EnqueueOptions): Promise<EnqueueResult | void> {
+    if (options?.sourceFile) {
+      return this.shardOf(options.sourceFile.filePath).enqueue(documents, options);
+    }
+    const groups = groupByShard(documents, this.shards.length, (document) => document.filePath);
+    const shards = groups.map((_, shard) => shard).filter((shard) => groups[shard].length > 0);
+    if (shards.length === 1) {
+      return this.shards[shards[0]].enqueue(groups[shards[0]], options);
+    }
+    // Row id ranges of several shards do not describe one batch.
+    for (const shard of shards) {
+      await this.shards[shard].enqueue(groups[shard], options);
+    }
+  }
+
+  async dequeue(count: number): Promise<QueuedDocument[]> {
+    const documents: QueuedDocument[] = [];
+    for (const shard of this.shards) {
+      if (documents.length >= count) {
+        break;
+      }
+      documents.push(...(await shard.dequeue(count - documents.length)));
+    }
+    return documents;
+  }
+
+  async commit(documents: QueuedDocument[]): Promise<void> {
+    const groups = groupByShard(documents, this.shards.length, (document) => document.document.filePath);
+    await Promise.all(groups.map((group, shard) => (group.length > 0 ? this.shards[shard].commit(group) : undefined)));
+  }
+
+  async requeue(documents: QueuedDocument[], options?: RequeueOptions): Promise<void> {
+    const groups = groupByShard(documents, this.shards.length, (document) => document.document.filePath);
+    await Promise.all(
+      groups.map((group, shard) => (group.length > 0 ? this.shards[shard].requeue(group, options) : undefined))
+    );
+  }
+
+  async clear(): Promise<void> {
+    await Promise.all(this.shards.map((shard) => shard.clear()));
+  }
+
+  async markEnqueueStarted(): Promise<void> {
+    await Promise.all(this.shards.map((shard) => shard.markEnqueueStarted()));
+  }
+
+  async markEnqueueCompleted(): Promise<void> {
+    await Promise.all(this.shards.map((shard) => shard.markEnqueueCompleted()));
+  }
+
+  isEnqueueCompleted(): boolean {
+    return this.shards.every((shard) => shard.isEnqueueCompleted());
+  }
+
+  async setEnqueueCommitHash(commitHash: string): Promise<void> {
+    await Promise.all(this.shards.map((shard) => shard.setEnqueueCommitHash(commitHash)));
+  }
+
+  getEnqueueCommitHash(): string | null {
+    return this.shards[0].getEnqueueCommitHash();
+  }
+
+  getEnqueuedFiles(): Map<string, number> {
+    return new Map(this.shards.flatMap((shard) => Array.from(shard.getEnqueuedFiles())));
+  }
+
+  getFileHashes(): Map<string, string> {
+    return new Map(this.shards.flatMap((shard) => Array.from(shard.getFileHashes())));
+  }
+
+  getIndexedFiles(indexName?: string): IndexedFile[] {
+    return this.shards
+      .flatMap((shard) => shard.getIndexedFiles(indexName))
+      .sort((a, b) => a.filePath.localeCompare(b.filePath));
+  }
+
+  async deleteFileHashes(filePaths: string[]): Promise<void> {
+    const groups = groupByShard(filePaths, this.shards.length, (filePath) => filePath);
+    await Promise.all(groups.map((group, shard) => this.shards[shard].deleteFileHashes(group)));
+  }
+
+  async clearFileHashes(): Promise<void> {
+    await Promise.all(this.shards.map((shard) => shard.clearFileHashes()));
+  }
+
+  async markLocationsStale(filePaths: string[], indexedBefore: number, keptLocationIds?: string[]): Promise<void> {
+    const groups = groupByShard(filePaths, this.shards.length, (filePath) => filePath);
+    await Promise.all(
+      groups.map((group, shard) =>
+        group.length > 0 ? this.shards[shard].markLocationsStale(group, indexedBefore, keptLocationIds) : undefined
+      )
+    );
+  }
+
+  recordParseFailure(failure: ParseFailure): void {
+    this.shardOf(failure.file).recordParseFailure(failure);
+  }
+
+  clearParseFailures(filePaths: string[]): void {
+    groupByShard(filePaths, this.shards.length, (filePath) => filePath).forEach((group, shard) =>
+      this.shards[shard].clearParseFailures(group)
+    );
+  }
+
+  getParseFailures(): RecordedParseFailure[] {
+    return this.shards
+      .flatMap((shard) => shard.getParseFailures())
+      .sort((a, b) => a.filePath.localeCompare(b.filePath));
+  }
+
+  /** Dead-lettered documents of every shard. Their ids are only unique within their shard. */
+  getDeadLetterEntries(): DeadLetterEntry[] {
+    return this.shards.flatMap((shard) => shard.getDeadLetterEntries());
+  }
+
+  getDeadLetteredCount(): number {
+    return this.shards.reduce((total, shard) => total + shard.getDeadLetteredCount(), 0);
+  }
+
+  getRemainingCount(): number {
+    return this.shards.reduce((total, shard) => total + shard.getRemainingCount(), 0);
+  }
+
+  getCompletedCount(): number {
+    return this.shards.reduce((total, shard) => total + shard.getCompletedCount(), 0);
+  }
+
+  getPendingFileCount(): number {
+    return this.shards.reduce((total, shard) => total + shard.getPendingFileCount(), 0);
+  }
+
+  /** True when any shard still has a document with a row id in the range, which may be another file's. */
+  hasDocumentsInRange(firstId: number, lastId: number): boolean {
+    return this.shards.some((shard) => shard.hasDocumentsInRange(firstId, lastId));
+  }
+
+  close(): void {
+    this.shards.forEach((shard) => shard.close());
+  }
+}
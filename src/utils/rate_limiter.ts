@@ -0,0 +1,194 @@
+import { createLogger, Logger } from './logger';
+
+const MINUTE_MS = 60 * 1000;
+
+/** How often the requests held back by the limiter are summed up in a log line while it throttles. */
+const THROTTLE_LOG_INTERVAL_MS = MINUTE_MS;
+
+/** Quota a bucket holds when full: one second's worth, so requests are spread over the minute. */
+const BURST_MS = 1000;
+
+export interface RateLimiterOptions {
+  /** Requests allowed per minute (unlimited when unset). */
+  requestsPerMinute?: number;
+  /** Tokens allowed per minute (unlimited when unset). */
+  tokensPerMinute?: number;
+  /** Receives the throttling reports (default: the `embedding` module logger). */
+  logger?: Logger;
+}
+
+/** What held back a request: a bucket, or a pause after a 429 response. */
+type ThrottleCause = 'requests' | 'tokens' | 'pause';
+
+const THROTTLE_CAUSES: Record<ThrottleCause, string> = {
+  requests: 'the --embed-rpm limit',
+  tokens: 'the --embed-tpm limit',
+  pause: 'a rate limited (HTTP 429) pause',
+};
+
+/** Requests and tokens sent over the last minute, next to the configured limits. */
+export interface RateLimitUtilization {
+  requestsPerMinute: number;
+  tokensPerMinute: number;
+  requestLimit?: number;
+  tokenLimit?: number;
+}
+
+/**
+ * Refills at `perMinute / 60000` per millisecond up to one second's worth. A take larger than the
+ * bucket waits for a full bucket and leaves it in debt, so large requests still pass and the rate
+ * over time stays at `perMinute`.
+ */
+class TokenBucket {
+  private readonly capacity: number;
+  private readonly refillPerMs: number;
+  private balance: number;
+  private updatedAt: number;
+
+  constructor(perMinute: number, now: number) {
+    this.refillPerMs = perMinute / MINUTE_MS;
+    this.capacity = Math.max(1, this.refillPerMs * BURST_MS);
+    this.balance = this.capacity;
+    this.updatedAt = now;
+  }
+
+  /** Milliseconds until `amount` can be taken. */
+  waitMs(amount: number, now: number): number {
+    this.refill(now);
+    const needed = Math.min(amount, this.capacity);
+    return this.balance >= needed ? 0 : Math.ceil((needed - this.balance) / this.refillPerMs);
+  }
+
+  take(amount: number, now: number): void {
+    this.refill(now);
+    this.balance -= amount;
+  }
+
+  private refill(now: number): void {
+    this.balance = Math.min(this.capacity, this.balance + (now - this.updatedAt) * this.refillPerMs);
+    this.updatedAt = now;
+  }
+}
+
+/**
+ * Spaces requests to an API with a requests-per-minute and a tokens-per-minute quota, with a token
+ * bucket for each. Callers are let through in order. Without limits it only applies the pauses
+ * requested with {@link RateLimiter.pauseFor}, such as a `Retry-After` header or a 429 backoff.
+ *
+ * While requests are held back, a line saying how many waited, for how long and behind which limit
+ * is logged right away and then at most once a minute, so a drop in throughput can be told apart.
+ */
+export class RateLimiter {
+  readonly requestLimit?: number;
+  readonly tokenLimit?: number;
+  private readonly requests?: TokenBucket;
+  private readonly tokens?: TokenBucket;
+  private pausedUntil = 0;
+  private turn: Promise<void> = Promise.resolve();
+  /** Requests let through over the last minute, for {@link RateLimiter.utilization}. */
+  private sent: Array<{ at: number; tokens: number }> = [];
+  private readonly logger: Logger;
+  /** Requests held back since the last throttling report, and the time they waited per cause. */
+  private throttled = { requests: 0, waitedMs: { requests: 0, tokens: 0, pause: 0 } };
+  private lastThrottleLogAt?: number;
+
+  constructor(options: RateLimiterOptions = {}) {
+    const now = Date.now();
+    this.requestLimit = options.requestsPerMinute;
+    this.tokenLimit = options.tokensPerMinute;
+    this.requests = this.requestLimit !== undefined ? new TokenBucket(this.requestLimit, now) : undefined;
+    this.tokens = this.tokenLimit !== undefined ? new TokenBucket(this.tokenLimit, now) : undefined;
+    this.logger = options.logger ?? createLogger(undefined, { module: 'embedding' });
+  }
+
+  /** Resolves once a request using an estimated `tokens` tokens may be sent. */
+  acquire(tokens = 0): Promise<void> {
+    this.turn = this.turn.then(() => this.waitForCapacity(tokens));
+    return this.turn;
+  }
+
+  /** Holds back every request for `ms`, on top of the limits. */
+  pauseFor(ms: number): void {
+    this.pausedUntil = Math.max(this.pausedUntil, Date.now() + ms);
+  }
+
+  /** Requests and estimated tokens let through over the last minute. */
+  utilization(): RateLimitUtilization {
+    this.forgetBefore(Date.now() - MINUTE_MS);
+    return {
+      requestsPerMinute: this.sent.length,
+      tokensPerMinute: this.sent.reduce((total, request) => total + request.tokens, 0),
+      requestLimit: this.requestLimit,
+      tokenLimit: this.tokenLimit,
+    };
+  }
+
+  private async waitForCapacity(tokens: number): Promise<void> {
+    const startedAt = Date.now();
+    let cause: ThrottleCause | undefined;
+    for (;;) {
+      const now = Date.now();
+      const waits: Record<ThrottleCause, number> = {
+        pause: this.pausedUntil - now,
+        requests: this.requests?.waitMs(1, now) ?? 0,
+        tokens: this.tokens?.waitMs(tokens, now) ?? 0,
+      };
+      const longest = (Object.keys(waits) as ThrottleCause[]).reduce((a, b) => (waits[b] > waits[a] ? b : a));
+      if (waits[longest] <= 0) {
+        break;
+      }
+      cause = longest;
+      await new Promise((resolve) => setTimeout(resolve, waits[longest]));
+    }
+    const now = Date.now();
+    if (cause) {
+      this.recordThrottled(cause, now - startedAt, now);
+    }
+    this.requests?.take(1, now);
+    this.tokens?.take(tokens, now);
+    this.forgetBefore(now - MINUTE_MS);
+    this.sent.push({ at: now, tokens });
+  }
+
+  private recordThrottled(cause: ThrottleCause, waitedMs: number, now: number): void {
+    this.throttled.requests++;
+    this.throttled.waitedMs[cause] += waitedMs;
+    if (this.lastThrottleLogAt !== undefined && now - this.lastThrottleLogAt < THROTTLE_LOG_INTERVAL_MS) {
+      return;
+    }
+    const { requests, waitedMs: byCause } = this.throttled;
+    const main = (Object.keys(byCause) as ThrottleCause[]).reduce((a, b) => (byCause[b] > byCause[a] ? b : a));
+    const totalMs = byCause.requests + byCause.tokens + byCause.pause;
+    this.logger.info(
+      `Embedding requests are throttled by ${THROTTLE_CAUSES[main]} ` +
+        `(${requests} held back, waiting ${(totalMs / 1000).toFixed(1)}s in total).`,
+      { throttledRequests: requests, throttledMs: totalMs, ...this.utilization() }
+    );
+    this.lastThrottleLogAt = now;
+    this.throttled = { requests: 0, waitedMs: { requests: 0, tokens: 0, pause: 0 } };
+  }
+
+  private forgetBefore(time: number): void {
+    let expired = 0;
+    while (expired < this.sent.length && this.sent[expired].at <= time) {
+      expired++;
+    }
+    this.sent.splice(0, expired);
+  }
+}
+
+/**
+ * Parses a `Retry-After` header, in seconds or as an HTTP date, into milliseconds from `now`.
+ * Returns undefined when the header is missing or malformed.
+ */
+export function parseRetryAfter(value: string | null, now: number = Date.now()): number | undefined {
+  if (value === null || value.trim() === '') {
+    return undefined;
+  }
+  const seconds = Number(value);
+  if (Number.isFinite(seconds)) {
+    return seconds >= 0 ? Math.ceil(seconds * 1000) : undefined;
+  }
+  const date = Date.parse(value);
+  return Number.isNaN(date) ? undefined : Math.max(0, date - now);
+}
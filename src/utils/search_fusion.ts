@@ -0,0 +1,57 @@
+import type { SearchResult } from './elasticsearch';
+
+/**
+ * How the lexical (BM25) and vector (kNN) result lists of a hybrid search are merged: `rrf` ranks by
+ * reciprocal rank, `linear` by a weighted sum of the scores of each list scaled to 0..1.
+ */
+export const SEARCH_FUSIONS = ['rrf', 'linear'] as const;
+export type SearchFusion = (typeof SEARCH_FUSIONS)[number];
+
+/** Rank constant of reciprocal rank fusion; higher values flatten the advantage of top ranks. */
+export const DEFAULT_RRF_RANK_CONSTANT = 60;
+
+export interface SearchFusionOptions {
+  fusion: SearchFusion;
+  /** Weight of the lexical list (default: 1). */
+  lexicalWeight?: number;
+  /** Weight of the vector list (default: 1). */
+  vectorWeight?: number;
+  /** Rank constant for `rrf` (default: {@link DEFAULT_RRF_RANK_CONSTANT}). */
+  rankConstant?: number;
+}
+
+/**
+ * Merges the results of a lexical and a vector search of the same chunks, best first. A chunk found
+ * by only one search gets nothing from the other, and each result's `score` is its fused score.
+ */
+export function fuseSearchResults(
+  lexical: SearchResult[],
+  vector: SearchResult[],
+  options: SearchFusionOptions
+): SearchResult[] {
+  const fused = new Map<string, SearchResult>();
+  const add = (results: SearchResult[], weight: number) => {
+    const scores = options.fusion === 'rrf' ? reciprocalRanks(results, options.rankConstant) : scaledScores(results);
+    results.forEach((result, index) => {
+      const score = weight * scores[index];
+      const existing = fused.get(result.id);
+      fused.set(result.id, existing ? { ...existing, score: existing.score + score } : { ...result, score });
+    });
+  };
+  add(lexical, options.lexicalWeight ?? 1);
+  add(vector, options.vectorWeight ?? 1);
+  // Sorting is stable, so ties keep lexical hits ahead of vector-only hits.
+  return Array.from(fused.values()).sort((a, b) => b.score - a.score);
+}
+
+function reciprocalRanks(results: SearchResult[], rankConstant = DEFAULT_RRF_RANK_CONSTANT): number[] {
+  return results.map((_, index) => 1 / (rankConstant + index + 1));
+}
+
+/** Min-max scales the scores of one list, since BM25 and vector similarity scores are not comparable. */
+function scaledScores(results: SearchResult[]): number[] {
+  const scores = results.map((result) => result.score);
+  const min = Math.min(...scores);
+  const range = Math.max(...scores) - min;
+  return scores.map((score) => (range > 0 ? (score - min) / range : 1));
+}
@@ -0,0 +1,95 @@
+import { CodeChunk, getChunkDocumentId, getExistingLocations, getLocationDocumentId } from './elasticsearch';
+
+/** Lines of a file, 1-based and inclusive. */
+export interface LineRange {
+  start: number;
+  end: number;
+}
+
+const HUNK_HEADER = /^@@ -\d+(?:,\d+)? \+(\d+)(?:,(\d+))? @@/;
+
+/**
+ * Collects the lines each hunk of a `git diff -U0 --no-prefix` changed in the new version of each
+ * file. A hunk that only deletes lines covers the lines around the deletion. Files whose path git
+ * quotes, or whose diff has no hunks because they are binary, are left out.
+ */
+export function parseDiffHunks(diff: string): Map<string, LineRange[]> {
+  const hunks = new Map<string, LineRange[]>();
+  let file: string | undefined;
+  let inHeader = false;
+  for (const line of diff.split('\n')) {
+    if (line.startsWith('diff --git ')) {
+      file = undefined;
+      inHeader = true;
+    } else if (inHeader && line.startsWith('+++ ')) {
+      // Git appends a tab to names containing spaces, and quotes names with special characters.
+      const name = line.slice(4).replace(/\t$/, '');
+      file = name === '/dev/null' || name.startsWith('"') ? undefined : name;
+      if (file) {
+        hunks.set(file, []);
+      }
+    } else if (line.startsWith('@@ ')) {
+      inHeader = false;
+      const match = HUNK_HEADER.exec(line);
+      if (file && match) {
+        const start = Number(match[1]);
+        const count = match[2] === undefined ? 1 : Number(match[2]);
+        hunks.get(file)?.push(count === 0 ? { start, end: start + 1 } : { start, end: start + count - 1 });
+      }
+    }
+  }
+  return hunks;
+}
+
+/** Returns true when the chunk's lines overlap one of `ranges`. */
+export function intersectsLineRanges(chunk: Pick<CodeChunk, 'startLine' | 'endLine'>, ranges: LineRange[]): boolean {
+  return ranges.some((range) => chunk.startLine <= range.end && chunk.endLine >= range.start);
+}
+
+export interface ChangedChunks {
+  /** Chunks to enqueue: the changed ones and those the index does not hold at this position. */
+  chunks: CodeChunk[];
+  /** Locations of the other chunks, which are indexed already and are left in place. */
+  keptLocationIds: string[];
+}
+
+/**
+ * Selects the chunks of a modified file to enqueue with `--smart-incremental`: those whose lines
+ * intersect the diff's hunks. The others are only kept when their location is indexed already with
+ * the same chunk, lines and file imports, since those change when lines above them were added or
+ * removed or when something they derive from the rest of the file, such as the package of their
+ * qualified name, changed. Chunk documents are content-addressed, so a chunk that only moved is indexed without
+ * being embedded again.
+ */
+export async function selectChangedChunks(
+  chunks: CodeChunk[],
+  hunks: LineRange[],
+  index: string,
+  options: { dedup?: boolean } = {}
+): Promise<ChangedChunks> {
+  const untouched = new Map<CodeChunk, string>();
+  for (const chunk of chunks) {
+    if (!intersectsLineRanges(chunk, hunks)) {
+      untouched.set(chunk, getLocationDocumentId(chunk, { dedup: options.dedup }));
+    }
+  }
+  const existing = await getExistingLocations(index, Array.from(untouched.values()));
+  const kept = new Set<CodeChunk>();
+  const keptLocationIds: string[] = [];
+  for (const [chunk, locationId] of untouched) {
+    const indexed = existing.get(locationId);
+    // Locations also carry the file's imports, which are not part of their id, and the location of a
+    // symbol keeps its id when the symbol moves or its chunk changes.
+    if (
+      indexed &&
+      indexed.chunk_id === getChunkDocumentId(chunk, { dedup: options.dedup }) &&
+      indexed.startLine === chunk.startLine &&
+      indexed.endLine === chunk.endLine &&
+      (indexed.file_imports ?? []).join('\n') === (chunk.file_imports ?? []).join('\n')
+    ) {
+      kept.add(chunk);
+      keptLocationIds.push(locationId);
+    }
+  }
+  return { chunks: chunks.filter((chunk) => !kept.has(chunk)), keptLocationIds };
+}
@@ -0,0 +1,71 @@
+import fs from 'fs';
+import path from 'path';
+import { createHash } from 'crypto';
+import type { ChunkLocationSummary } from './elasticsearch';
+import { decodeText } from './file_encoding';
+
+/** The lines of a chunk location read from a checkout, for chunk documents stored without their content. */
+export interface SourceSnippet {
+  filePath: string;
+  startLine: number;
+  endLine: number;
+  /** The lines of the location, or undefined when the file cannot be read as text. */
+  content?: string;
+  /**
+   * The file is not the one the location was indexed from: it is missing, shorter than the location, or
+   * its git blob hash differs from the location's `git_file_hash`. Its lines may not be the chunk's code.
+   */
+  stale: boolean;
+}
+
+/** Hashes content the way `git hash-object` does, which is the `git_file_hash` of its locations. */
+function hashGitBlob(content: Buffer): string {
+  return createHash('sha1').update(`blob ${content.length}\0`).update(content).digest('hex');
+}
+
+/** Reads the lines of `location` from the checkout at `repoRoot`, see {@link SourceSnippet}. */
+export function readSourceSnippet(repoRoot: string, location: ChunkLocationSummary): SourceSnippet {
+  const snippet = { filePath: location.filePath, startLine: location.startLine, endLine: location.endLine };
+  const root = path.resolve(repoRoot);
+  const filePath = path.resolve(root, location.filePath);
+  const relativePath = path.relative(root, filePath);
+  if (relativePath.startsWith('..') || path.isAbsolute(relativePath)) {
+    return { ...snippet, stale: true };
+  }
+  let bytes: Buffer;
+  try {
+    bytes = fs.readFileSync(filePath);
+  } catch {
+    return { ...snippet, stale: true };
+  }
+  const { text } = decodeText(bytes);
+  if (text === undefined) {
+    return { ...snippet, stale: true };
+  }
+  const lines = text.split(/\r?\n/);
+  return {
+    ...snippet,
+    content: lines.slice(location.startLine - 1, location.endLine).join('\n'),
+    stale:
+      location.endLine > lines.length ||
+      (location.git_file_hash !== undefined && location.git_file_hash !== hashGitBlob(bytes)),
+  };
+}
+
+/**
+ * Reads a chunk's code from the checkout at `repoRoot`, at the first of its locations whose file is
+ * unchanged since it was indexed, or else at its first location that can be read.
+ */
+export function readChunkSource(repoRoot: string, locations: ChunkLocationSummary[]): SourceSnippet | undefined {
+  let fallback: SourceSnippet | undefined;
+  for (const location of locations) {
+    const snippet = readSourceSnippet(repoRoot, location);
+    if (!snippet.stale) {
+      return snippet;
+    }
+    if (!fallback || (fallback.content === undefined && snippet.content !== undefined)) {
+      fallback = snippet;
+    }
+  }
+  return fallback;
+}
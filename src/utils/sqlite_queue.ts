@@ -1,18 +1,86 @@
 import path from 'path';
 import fs from 'fs';
+import { randomUUID } from 'crypto';
 import Database from 'better-sqlite3';
-import { IQueueWithEnqueueMetadata, QueuedDocument } from './queue';
+import { ObservableCallback, ObservableGauge } from '@opentelemetry/api';
+import {
+  EnqueuedFile,
+  EnqueueOptions,
+  EnqueueResult,
+  IndexedFile,
+  IQueueWithEnqueueMetadata,
+  QueuedDocument,
+  RecordedParseFailure,
+  RequeueOptions,
+} from './queue';
 import { CodeChunk } from './elasticsearch';
-import { logger, createLogger } from './logger';
+import type { ParseFailure } from './progress_reporter';
+import { createLogger, Logger } from './logger';
 import { createMetrics, Metrics, createAttributes } from './metrics';
-import { QUEUE_STATUS_PENDING, QUEUE_STATUS_PROCESSING, QUEUE_STATUS_FAILED } from './constants';
-
+import { indexingConfig } from '../config';
+import { DOCUMENT_FILE_PATH, migrateQueueSchema } from './queue_schema';
+import {
+  QUEUE_STATUS_PENDING,
+  QUEUE_STATUS_PROCESSING,
+  QUEUE_STATUS_FAILED,
+  QUEUE_PRIORITY_DEFAULT,
+} from './constants';
+
+/** Default number of attempts before a document is moved to the dead-letter table. */
 export const MAX_RETRIES = 3;
-const STALE_TIMEOUT_MS = 5 * 60 * 1000; // 5 minutes
 const WAL_CHECKPOINT_INTERVAL = 100; // Checkpoint every ~100 commits (10% probability per commit)
 
 const QUEUE_METADATA_KEY_ENQUEUE_COMPLETED = 'enqueue_completed';
 const QUEUE_METADATA_KEY_ENQUEUE_COMMIT_HASH = 'enqueue_commit_hash';
+const QUEUE_METADATA_KEY_DOCUMENTS_COMPLETED = 'documents_completed';
+
+/** Tables holding a queue's state, in the order `queue:export` writes them and `queue:import` loads them. */
+export const QUEUE_STATE_TABLES = [
+  'queue_metadata',
+  'queue',
+  'dead_letter',
+  'indexed_files',
+  'pending_file_hashes',
+  'enqueued_files',
+  'stale_location_files',
+  'parse_failures',
+] as const;
+export type QueueStateTable = (typeof QUEUE_STATE_TABLES)[number];
+
+/**
+ * Computes the delay before the next attempt using capped exponential backoff with jitter.
+ *
+ * The un-jittered delay is `baseDelayMs * 2^(attempt - 1)`, capped at `maxDelayMs`. Jitter picks a
+ * value in the upper half of that range so retries from the same failure spread out without ever
+ * retrying much earlier than intended.
+ *
+ * @param attempt - The attempt number that just failed (1-based)
+ * @param baseDelayMs - Delay after the first failure
+ * @param maxDelayMs - Upper bound for any delay
+ * @param random - Random source in [0, 1) (injectable for tests)
+ */
+export function computeBackoffDelayMs(
+  attempt: number,
+  baseDelayMs: number,
+  maxDelayMs: number,
+  random: () => number = Math.random
+): number {
+  if (baseDelayMs <= 0) {
+    return 0;
+  }
+  const exponential = baseDelayMs * Math.pow(2, Math.max(0, attempt - 1));
+  const capped = Math.min(maxDelayMs, exponential);
+  return Math.floor(capped / 2 + random() * (capped / 2));
+}
+
+/** Returns the size of a file in bytes, or 0 when it does not exist. */
+function getFileSize(filePath: string): number {
+  try {
+    return fs.statSync(filePath).size;
+  } catch {
+    return 0;
+  }
+}
 
 /**
  * Check if a process with the given PID is currently running.
@@ -38,10 +106,39 @@ function isProcessRunning(pid: number | null): boolean {
   }
 }
 
+/** Outcome of {@link SqliteQueue.compact}. */
+export interface CompactResult {
+  /** Whether VACUUM rebuilt the database file. */
+  vacuumed: boolean;
+  /** Whether the row id sequence was reset because the queue and dead-letter table were empty. */
+  sequenceReset: boolean;
+  /** Size of the database and WAL files before and after compaction. */
+  bytesBefore: number;
+  bytesAfter: number;
+}
+
 export interface SqliteQueueOptions {
   dbPath: string;
   repoName?: string;
   branch?: string;
+  /** Attempts before a document is dead-lettered (default: `SCS_IDXR_QUEUE_MAX_ATTEMPTS`). */
+  maxAttempts?: number;
+  /** Shard of a sharded queue, added to its queue size gauges so the shards can be summed. */
+  shard?: number;
+}
+
+/**
+ * A document that exhausted its indexing attempts and was moved out of the queue.
+ */
+export interface DeadLetterEntry {
+  /** Original queue row id (kept when the document is requeued). */
+  id: number;
+  /** The serialized `CodeChunk`. */
+  document: string;
+  attempts: number;
+  lastError: string | null;
+  lastErrorAt: string | null;
+  deadLetteredAt: string;
 }
 
 // Cache TTL for queue stats - prevents blocking event loop with frequent SQL queries
@@ -49,22 +146,30 @@ const STATS_CACHE_TTL_MS = 5000; // 5 seconds
 
 export class SqliteQueue implements IQueueWithEnqueueMetadata {
   private db: Database.Database;
-  private logger: ReturnType<typeof createLogger>;
+  private dbPath: string;
+  private logger: Logger;
   private metrics: Metrics;
   private commitCount = 0;
+  private maxAttemptsOverride?: number;
+  private deadLetteredCount = 0;
+  private shard?: number;
 
   // Cache for queue stats to prevent blocking event loop during OTEL metrics export
   private cachedStats = { pending: 0, processing: 0, failed: 0 };
   private statsCacheTime = 0;
+  private gaugeCallbacks: Array<() => void> = [];
 
   constructor(options: SqliteQueueOptions) {
-    const { dbPath, repoName, branch } = options;
+    const { dbPath, repoName, branch, maxAttempts, shard } = options;
+    this.maxAttemptsOverride = maxAttempts;
+    this.shard = shard;
     const dir = path.dirname(dbPath);
     if (!fs.existsSync(dir)) {
       fs.mkdirSync(dir, { recursive: true });
     }
     this.db = new Database(dbPath);
-    this.logger = repoName && branch ? createLogger({ name: repoName, branch }) : logger;
+    this.dbPath = dbPath;
+    this.logger = createLogger(repoName && branch ? { name: repoName, branch } : undefined, { module: 'queue' });
     this.metrics = repoName && branch ? createMetrics({ name: repoName, branch }) : createMetrics();
   }
 
@@ -75,46 +180,10 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     this.db.exec('PRAGMA cache_size = -64000;'); // 64MB cache (negative = KB)
     this.db.exec('PRAGMA temp_store = MEMORY;'); // Temp tables in memory
     this.db.exec('PRAGMA mmap_size = 268435456;'); // 256MB memory-mapped I/O
-    this.db.exec(`
-      CREATE TABLE IF NOT EXISTS queue (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        batch_id TEXT NOT NULL,
-        document TEXT NOT NULL,
-        status TEXT NOT NULL DEFAULT '${QUEUE_STATUS_PENDING}',
-        retry_count INTEGER NOT NULL DEFAULT 0,
-        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-        processing_started_at TIMESTAMP,
-        worker_pid INTEGER
-      );
-    `);
-    // Compound index for efficient dequeue: WHERE status + ORDER BY created_at
-    // This single index covers all status-based queries and provides sorted access by created_at
-    this.db.exec('CREATE INDEX IF NOT EXISTS idx_status_created ON queue (status, created_at);');
-
-    // Create metadata table for tracking enqueue completion
-    this.db.exec(`
-      CREATE TABLE IF NOT EXISTS queue_metadata (
-        key TEXT PRIMARY KEY,
-        value TEXT NOT NULL,
-        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-      );
-    `);
-
-    // Schema upgrade: add processing_started_at column if it doesn't exist (for existing databases)
-    try {
-      this.db.exec('ALTER TABLE queue ADD COLUMN processing_started_at TIMESTAMP;');
-      this.logger.info('Added processing_started_at column to queue table');
-    } catch {
-      // Column already exists, ignore error
-    }
-
-    // Schema upgrade: add worker_pid column if it doesn't exist (for existing databases)
-    try {
-      this.db.exec('ALTER TABLE queue ADD COLUMN worker_pid INTEGER;');
-      this.logger.info('Added worker_pid column to queue table');
-    } catch {
-      // Column already exists, ignore error
-    }
+    // Truncate the WAL back to this size after checkpoints instead of keeping its largest size
+    this.db.exec(`PRAGMA journal_size_limit = ${indexingConfig.queueWalTruncateMb * 1024 * 1024};`);
+    // Creates the tables of a new queue, or upgrades those of an older version (refusing newer ones).
+    migrateQueueSchema(this.db, this.dbPath, this.logger);
 
     // Set up observable gauges for queue sizes
     this.setupQueueGauges();
@@ -129,24 +198,34 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     }
 
     // Observable gauge for pending documents
-    this.metrics.queue.queueSizePending.addCallback((observableResult) => {
+    this.addQueueGaugeCallback(this.metrics.queue.queueSizePending, (observableResult) => {
       const stats = this.getQueueStats();
-      observableResult.observe(stats.pending, createAttributes(this.metrics, { status: QUEUE_STATUS_PENDING }));
+      observableResult.observe(stats.pending, this.gaugeAttributes(QUEUE_STATUS_PENDING));
     });
 
     // Observable gauge for processing documents
-    this.metrics.queue.queueSizeProcessing.addCallback((observableResult) => {
+    this.addQueueGaugeCallback(this.metrics.queue.queueSizeProcessing, (observableResult) => {
       const stats = this.getQueueStats();
-      observableResult.observe(stats.processing, createAttributes(this.metrics, { status: QUEUE_STATUS_PROCESSING }));
+      observableResult.observe(stats.processing, this.gaugeAttributes(QUEUE_STATUS_PROCESSING));
     });
 
     // Observable gauge for failed documents
-    this.metrics.queue.queueSizeFailed.addCallback((observableResult) => {
+    this.addQueueGaugeCallback(this.metrics.queue.queueSizeFailed, (observableResult) => {
       const stats = this.getQueueStats();
-      observableResult.observe(stats.failed, createAttributes(this.metrics, { status: QUEUE_STATUS_FAILED }));
+      observableResult.observe(stats.failed, this.gaugeAttributes(QUEUE_STATUS_FAILED));
     });
   }
 
+  private gaugeAttributes(status: string): Record<string, string | number> {
+    return createAttributes(this.metrics, { status, ...(this.shard !== undefined ? { shard: this.shard } : {}) });
+  }
+
+  /** Registers a gauge callback that `close()` removes, so a closed database is not read on collection. */
+  private addQueueGaugeCallback(gauge: ObservableGauge, callback: ObservableCallback): void {
+    gauge.addCallback(callback);
+    this.gaugeCallbacks.push(() => gauge.removeCallback(callback));
+  }
+
   /**
    * Gets current queue statistics by status.
    * Results are cached for STATS_CACHE_TTL_MS to prevent blocking the event loop
@@ -176,37 +255,57 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
         this.cachedStats.pending = row.count;
       } else if (row.status === QUEUE_STATUS_PROCESSING) {
         this.cachedStats.processing = row.count;
-      } else if (row.status === QUEUE_STATUS_FAILED) {
-        this.cachedStats.failed = row.count;
       }
     }
+    const deadLetter = this.db.prepare('SELECT COUNT(*) as count FROM dead_letter').get() as { count: number };
+    this.cachedStats.failed = deadLetter.count;
 
     this.statsCacheTime = now;
     return this.cachedStats;
   }
 
-  async enqueue(documents: CodeChunk[]): Promise<void> {
+  async enqueue(documents: CodeChunk[], options?: EnqueueOptions): Promise<EnqueueResult | void> {
+    const sourceFile = options?.sourceFile;
     if (documents.length === 0) {
+      if (sourceFile) {
+        this.db.transaction(() => this.recordEnqueuedFile(sourceFile, 0))();
+      }
       return;
     }
 
     const batchId = new Date().toISOString();
-    const insert = this.db.prepare('INSERT INTO queue (batch_id, document) VALUES (?, ?)');
-    const transaction = this.db.transaction((docs) => {
-      for (const doc of docs) {
-        insert.run(batchId, JSON.stringify(doc));
+    const priority = options?.priority ?? QUEUE_PRIORITY_DEFAULT;
+    const insert = this.db.prepare('INSERT INTO queue (batch_id, document, priority) VALUES (?, ?, ?)');
+    const transaction = this.db.transaction((docs: CodeChunk[]): EnqueueResult => {
+      // Recording the file in the same transaction means a crash never leaves it half-enqueued.
+      if (sourceFile) {
+        this.recordEnqueuedFile(sourceFile, docs.length);
       }
+      let firstId = 0;
+      let lastId = 0;
+      docs.forEach((doc, index) => {
+        const id = Number(insert.run(batchId, JSON.stringify(doc), priority).lastInsertRowid);
+        if (index === 0) {
+          firstId = id;
+        }
+        lastId = id;
+      });
+      return { firstId, lastId };
     });
-    transaction(documents);
+    const result = transaction(documents);
 
-    this.logger.info(`Enqueued batch of ${documents.length} documents with batch_id: ${batchId}`);
+    this.logger.info(`Enqueued batch of ${documents.length} documents with batch_id: ${batchId}`, { priority });
 
     // Record enqueue metrics
     this.metrics.queue?.documentsEnqueued.add(documents.length, createAttributes(this.metrics));
+
+    return result;
   }
 
   async dequeue(count: number): Promise<QueuedDocument[]> {
     const currentPid = process.pid;
+    // Every dequeue is its own lease, so batches claimed by workers of the same process stay apart.
+    const leaseId = randomUUID();
 
     // Use UPDATE ... RETURNING to atomically claim rows for this worker
     // This prevents race conditions when multiple workers dequeue concurrently
@@ -215,18 +314,35 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
       SET
         status = '${QUEUE_STATUS_PROCESSING}',
         processing_started_at = CURRENT_TIMESTAMP,
-        worker_pid = ?
+        worker_pid = ?,
+        lease_id = ?
       WHERE id IN (
         SELECT id
         FROM queue
         WHERE status = '${QUEUE_STATUS_PENDING}'
-        ORDER BY created_at
+        AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+        ORDER BY priority DESC, id ASC
         LIMIT ?
       )
       RETURNING id, batch_id, document
     `);
 
-    const rows = updateStmt.all(currentPid, count) as { id: number; batch_id: string; document: string }[];
+    // Expired leases are reclaimed in the same write transaction as the claim, so a document whose
+    // worker crashed is handed out again by the next dequeue, and to one worker only.
+    let reclaimed = 0;
+    const rows = this.db
+      .transaction(() => {
+        reclaimed = this.reclaimExpiredLeases();
+        return updateStmt.all(currentPid, leaseId, Date.now(), count) as {
+          id: number;
+          batch_id: string;
+          document: string;
+        }[];
+      })
+      .immediate();
+    if (reclaimed > 0) {
+      this.logger.warn(`Requeued ${reclaimed} documents whose lease expired.`);
+    }
 
     if (rows.length === 0) {
       return [];
@@ -238,9 +354,46 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     return rows.map((row) => ({
       id: `${row.batch_id}_${row.id}`, // Composite ID
       document: JSON.parse(row.document),
+      leaseId,
     }));
   }
 
+  /**
+   * Extends the leases of documents that are still being indexed, so stale recovery does not hand
+   * them to another worker while this one is waiting on Elasticsearch.
+   *
+   * @returns The number of rows whose lease was renewed.
+   */
+  renewLeases(leaseIds: string[]): number {
+    const renewStmt = this.db.prepare(
+      'UPDATE queue SET processing_started_at = CURRENT_TIMESTAMP WHERE status = ? AND lease_id = ?'
+    );
+    return this.db.transaction(() => {
+      let renewed = 0;
+      for (const leaseId of leaseIds) {
+        renewed += renewStmt.run(QUEUE_STATUS_PROCESSING, leaseId).changes;
+      }
+      return renewed;
+    })();
+  }
+
+  /**
+   * Returns the documents whose rows are still claimed by the lease they were dequeued with.
+   * Documents without a lease (not returned by `dequeue`) are always kept.
+   */
+  private filterLeasedDocuments(documents: QueuedDocument[]): QueuedDocument[] {
+    const leaseStmt = this.db.prepare('SELECT lease_id FROM queue WHERE id = ? AND status = ?');
+    return documents.filter((document) => {
+      if (document.leaseId === undefined) {
+        return true;
+      }
+      const row = leaseStmt.get(parseInt(document.id.split('_').pop() || '0', 10), QUEUE_STATUS_PROCESSING) as
+        | { lease_id: string | null }
+        | undefined;
+      return row?.lease_id === document.leaseId;
+    });
+  }
+
   async commit(documents: QueuedDocument[]): Promise<void> {
     if (documents.length === 0) {
       return;
@@ -251,13 +404,21 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     const BATCH_SIZE = 500;
 
     let totalChanges = 0;
-
-    for (let i = 0; i < ids.length; i += BATCH_SIZE) {
-      const batchIds = ids.slice(i, i + BATCH_SIZE);
-      const deleteStmt = this.db.prepare(`DELETE FROM queue WHERE id IN (${batchIds.map(() => '?').join(',')})`);
-      const result = deleteStmt.run(...batchIds);
-      totalChanges += result.changes;
-    }
+    const filePaths = new Set(
+      documents.map((d) => d.document.filePath).filter((filePath): filePath is string => Boolean(filePath))
+    );
+
+    // A file's hash is promoted in the same transaction that removes its last document.
+    this.db.transaction(() => {
+      for (let i = 0; i < ids.length; i += BATCH_SIZE) {
+        const batchIds = ids.slice(i, i + BATCH_SIZE);
+        const deleteStmt = this.db.prepare(`DELETE FROM queue WHERE id IN (${batchIds.map(() => '?').join(',')})`);
+        const result = deleteStmt.run(...batchIds);
+        totalChanges += result.changes;
+      }
+      this.promoteFileHashes(filePaths);
+      this.addCompletedCount(totalChanges);
+    })();
 
     this.logger.info(`Committed and deleted ${totalChanges} documents.`);
 
@@ -266,25 +427,143 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     this.metrics.queue?.documentsDeleted.add(totalChanges, createAttributes(this.metrics));
 
     // Periodic WAL checkpoint to prevent unbounded WAL growth
-    // PASSIVE mode won't block - it just checkpoints what it can
     this.commitCount++;
     if (this.commitCount % WAL_CHECKPOINT_INTERVAL === 0) {
-      try {
-        this.db.exec('PRAGMA wal_checkpoint(PASSIVE);');
+      this.checkpointWal();
+    }
+  }
+
+  /**
+   * Checkpoints the WAL. PASSIVE mode won't block - it just checkpoints what it can. A WAL that
+   * still exceeds `SCS_IDXR_QUEUE_WAL_TRUNCATE_MB` (readers kept it from being reset during a long
+   * run) is checkpointed with TRUNCATE, which waits for readers and empties the file.
+   */
+  private checkpointWal(): void {
+    try {
+      this.db.exec('PRAGMA wal_checkpoint(PASSIVE);');
+      const walBytes = getFileSize(`${this.dbPath}-wal`);
+      if (walBytes > indexingConfig.queueWalTruncateMb * 1024 * 1024) {
+        this.db.exec('PRAGMA wal_checkpoint(TRUNCATE);');
+        this.logger.info(`WAL checkpoint completed, truncated ${walBytes} bytes of WAL`);
+      } else {
         this.logger.info('WAL checkpoint completed');
+      }
+    } catch (error) {
+      // Non-fatal - checkpoint will happen eventually
+      this.logger.warn('WAL checkpoint failed', { error });
+    }
+  }
+
+  /**
+   * Checkpoints the whole WAL into the database file, so the queue state is on disk before the
+   * process exits. Does nothing once the queue is closed.
+   */
+  flush(): void {
+    if (!this.db.open) {
+      return;
+    }
+    try {
+      this.db.exec('PRAGMA wal_checkpoint(TRUNCATE);');
+    } catch (error) {
+      this.logger.warn('Failed to flush the queue WAL', { error });
+    }
+  }
+
+  /**
+   * Returns the bytes held by free pages, which VACUUM returns to the file system.
+   */
+  getFreeBytes(): number {
+    const pageSize = this.db.pragma('page_size', { simple: true }) as number;
+    const freePages = this.db.pragma('freelist_count', { simple: true }) as number;
+    return pageSize * freePages;
+  }
+
+  /**
+   * Shrinks the queue database on disk. Row ids restart at 1 when the queue and the dead-letter table
+   * are empty, the file is rebuilt with VACUUM to drop the free pages left by deleted rows, and the
+   * WAL is truncated.
+   *
+   * VACUUM cannot run inside a transaction and needs exclusive access to the database, so this is
+   * only called at safe points: before a worker starts dequeuing, from `clear()` and from
+   * `queue:maintain`. When another connection holds the database, the VACUUM is skipped.
+   *
+   * @param options.minFreeBytes Skip the VACUUM when free pages hold fewer bytes than this (default: 0).
+   */
+  compact(options: { minFreeBytes?: number } = {}): CompactResult {
+    const bytesBefore = this.getDiskSize();
+
+    const isEmpty = (table: string) =>
+      (this.db.prepare(`SELECT COUNT(*) as count FROM ${table}`).get() as { count: number }).count === 0;
+    const sequenceReset = isEmpty('queue') && isEmpty('dead_letter');
+    if (sequenceReset) {
+      this.db.prepare("DELETE FROM sqlite_sequence WHERE name = 'queue'").run();
+    }
+
+    let vacuumed = false;
+    const freeBytes = this.getFreeBytes();
+    if (freeBytes >= (options.minFreeBytes ?? 0)) {
+      try {
+        this.db.exec('VACUUM;');
+        vacuumed = true;
       } catch (error) {
-        // Non-fatal - checkpoint will happen eventually
-        this.logger.warn('WAL checkpoint failed', { error });
+        this.logger.warn('Skipped VACUUM of the queue database, it is in use by another connection', {
+          error: error instanceof Error ? error.message : String(error),
+        });
       }
     }
+    try {
+      this.db.exec('PRAGMA wal_checkpoint(TRUNCATE);');
+    } catch (error) {
+      this.logger.warn('WAL checkpoint failed', { error });
+    }
+
+    const bytesAfter = this.getDiskSize();
+    if (vacuumed) {
+      this.logger.info(`Compacted queue database from ${bytesBefore} to ${bytesAfter} bytes.`);
+    }
+    return { vacuumed, sequenceReset, bytesBefore, bytesAfter };
+  }
+
+  /** Size of the database file and its WAL. */
+  private getDiskSize(): number {
+    return getFileSize(this.dbPath) + getFileSize(`${this.dbPath}-wal`);
   }
 
-  async requeue(documents: QueuedDocument[]): Promise<void> {
+  async requeue(documents: QueuedDocument[], options: RequeueOptions = {}): Promise<void> {
     if (documents.length === 0) {
       return;
     }
+    // A row that was recovered and claimed again belongs to its new lease; resetting it would let a
+    // third worker index it as well.
+    const leasedDocuments = this.filterLeasedDocuments(documents);
+    if (leasedDocuments.length < documents.length) {
+      const lostCount = documents.length - leasedDocuments.length;
+      this.logger.warn(`Skipped requeueing ${lostCount} documents whose lease was lost to another worker.`);
+      documents = leasedDocuments;
+      if (documents.length === 0) {
+        return;
+      }
+    }
     const ids = documents.map((d) => parseInt(d.id.split('_').pop() || '0', 10));
 
+    if (options.errors && options.errors.size > 0) {
+      const errorStmt = this.db.prepare(
+        'UPDATE queue SET last_error = ?, last_error_at = CURRENT_TIMESTAMP WHERE id = ?'
+      );
+      const errors = options.errors;
+      this.db.transaction(() => {
+        documents.forEach((document, index) => {
+          const error = errors.get(document.id);
+          if (error !== undefined) {
+            errorStmt.run(error, ids[index]);
+          }
+        });
+      })();
+    }
+    const maxAttempts = this.getMaxAttempts();
+    const baseDelayMs = indexingConfig.queueRetryBaseDelayMs;
+    const maxDelayMs = indexingConfig.queueRetryMaxDelayMs;
+
     // Batch size for SQLite operations to avoid "too many SQL variables" error
     // SQLite default limit is usually 999 or 32766, so 500 is safe
     const BATCH_SIZE = 500;
@@ -297,35 +576,46 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
       );
       const rowsToRequeue = selectRetriesStmt.all(...batchIds) as { id: number; retry_count: number }[];
 
-      const toRequeue: number[] = [];
+      const toRequeue: { id: number; attempt: number }[] = [];
       const toFail: number[] = [];
 
       for (const row of rowsToRequeue) {
-        if (row.retry_count + 1 >= MAX_RETRIES) {
+        // retry_count is the number of attempts that have already failed before this one.
+        const attempt = row.retry_count + 1;
+        if (attempt >= maxAttempts) {
           toFail.push(row.id);
         } else {
-          toRequeue.push(row.id);
+          toRequeue.push({ id: row.id, attempt });
         }
       }
 
       if (toRequeue.length > 0) {
+        // The next attempt time is persisted so backoff survives process restarts.
         const requeueStmt = this.db.prepare(
-          `UPDATE queue SET status = '${QUEUE_STATUS_PENDING}', retry_count = retry_count + 1, processing_started_at = NULL, worker_pid = NULL WHERE id IN (${toRequeue.map(() => '?').join(',')})`
+          `UPDATE queue SET status = '${QUEUE_STATUS_PENDING}', retry_count = retry_count + 1, processing_started_at = NULL, worker_pid = NULL, lease_id = NULL, next_attempt_at = ? WHERE id = ?`
         );
-        requeueStmt.run(...toRequeue);
-        this.logger.warn(`Requeued ${toRequeue.length} documents (batch ${Math.floor(i / BATCH_SIZE) + 1}).`);
+        const now = Date.now();
+        let maxDelayScheduled = 0;
+        this.db.transaction(() => {
+          for (const { id, attempt } of toRequeue) {
+            const delay = computeBackoffDelayMs(attempt, baseDelayMs, maxDelayMs);
+            maxDelayScheduled = Math.max(maxDelayScheduled, delay);
+            requeueStmt.run(delay > 0 ? now + delay : null, id);
+          }
+        })();
+        this.logger.warn(`Requeued ${toRequeue.length} documents (batch ${Math.floor(i / BATCH_SIZE) + 1}).`, {
+          maxBackoffMs: maxDelayScheduled,
+        });
 
         // Record requeue metrics
         this.metrics.queue?.documentsRequeued.add(toRequeue.length, createAttributes(this.metrics));
       }
 
       if (toFail.length > 0) {
-        const failStmt = this.db.prepare(
-          `UPDATE queue SET status = '${QUEUE_STATUS_FAILED}' WHERE id IN (${toFail.map(() => '?').join(',')})`
-        );
-        failStmt.run(...toFail);
+        const moved = this.moveToDeadLetter(`id IN (${toFail.map(() => '?').join(',')})`, toFail);
+        this.deadLetteredCount += moved;
         this.logger.error(
-          `Moved ${toFail.length} documents to failed status after ${MAX_RETRIES} retries (batch ${Math.floor(i / BATCH_SIZE) + 1}).`
+          `Moved ${moved} documents to the dead-letter table after ${maxAttempts} attempts (batch ${Math.floor(i / BATCH_SIZE) + 1}).`
         );
 
         // Record failed metrics
@@ -334,6 +624,141 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     }
   }
 
+  /**
+   * Moves the queue rows matching `where` to the dead-letter table, keeping their id and last error.
+   *
+   * @returns The number of documents moved.
+   */
+  private moveToDeadLetter(where: string, params: unknown[]): number {
+    return this.db.transaction(() => {
+      // A file with a dead-lettered document is not fully indexed, so its new hash is dropped.
+      this.db
+        .prepare(
+          `DELETE FROM pending_file_hashes WHERE file_path IN (SELECT ${DOCUMENT_FILE_PATH} FROM queue WHERE ${where})`
+        )
+        .run(...params);
+      this.db
+        .prepare(
+          `INSERT OR REPLACE INTO dead_letter (id, batch_id, document, priority, attempts, last_error, last_error_at)
+           SELECT id, batch_id, document, priority, retry_count + 1, last_error, last_error_at FROM queue WHERE ${where}`
+        )
+        .run(...params);
+      return this.db.prepare(`DELETE FROM queue WHERE ${where}`).run(...params).changes;
+    })();
+  }
+
+  /**
+   * Number of documents still waiting to be indexed (pending or processing).
+   * Uses the same short-lived cache as the queue size gauges.
+   */
+  getRemainingCount(): number {
+    const stats = this.getQueueStats();
+    return stats.pending + stats.processing;
+  }
+
+  /**
+   * Number of documents this queue instance moved to the dead-letter table.
+   */
+  getDeadLetteredCount(): number {
+    return this.deadLetteredCount;
+  }
+
+  getDeadLetterEntries(): DeadLetterEntry[] {
+    return this.db
+      .prepare(
+        `SELECT id, document, attempts, last_error AS lastError, last_error_at AS lastErrorAt,
+                dead_lettered_at AS deadLetteredAt
+         FROM dead_letter
+         ORDER BY id`
+      )
+      .all() as DeadLetterEntry[];
+  }
+
+  /** Iterates over the raw rows of one of the queue's state tables, in insertion order. */
+  iterateTableRows(table: QueueStateTable): IterableIterator<Record<string, unknown>> {
+    return this.db.prepare(`SELECT * FROM ${table} ORDER BY rowid`).iterate() as IterableIterator<
+      Record<string, unknown>
+    >;
+  }
+
+  /**
+   * Inserts raw rows into one of the queue's state tables in a single transaction. A column the table
+   * does not have fails the whole insert, so rows from an incompatible schema are never loaded partially.
+   *
+   * @returns The number of rows inserted.
+   */
+  insertTableRows(table: QueueStateTable, rows: Record<string, unknown>[]): number {
+    const columns = new Set(
+      (this.db.prepare(`PRAGMA table_info(${table})`).all() as { name: string }[]).map((column) => column.name)
+    );
+    this.db.transaction(() => {
+      for (const row of rows) {
+        const names = Object.keys(row);
+        const unknownColumn = names.find((name) => !columns.has(name));
+        if (unknownColumn !== undefined) {
+          throw new Error(`Table ${table} has no column "${unknownColumn}".`);
+        }
+        this.db
+          .prepare(`INSERT INTO ${table} (${names.join(', ')}) VALUES (${names.map(() => '?').join(', ')})`)
+          .run(...names.map((name) => row[name]));
+      }
+    })();
+    return rows.length;
+  }
+
+  /**
+   * Moves dead-lettered documents back to the queue as `pending` with a fresh attempt budget.
+   * The last error is kept until the document is indexed.
+   *
+   * @param ids Dead-letter ids to requeue. Requeues every dead-lettered document when omitted.
+   * @returns The number of documents requeued.
+   */
+  requeueDeadLetter(ids?: number[]): number {
+    const requeue = (where: string, params: unknown[]): number => {
+      this.db
+        .prepare(
+          `INSERT INTO queue (id, batch_id, document, status, retry_count, priority, last_error, last_error_at)
+           SELECT id, batch_id, document, '${QUEUE_STATUS_PENDING}', 0, priority, last_error, last_error_at
+           FROM dead_letter WHERE ${where}`
+        )
+        .run(...params);
+      return this.db.prepare(`DELETE FROM dead_letter WHERE ${where}`).run(...params).changes;
+    };
+
+    return this.db.transaction(() => {
+      if (ids === undefined) {
+        return requeue('1 = 1', []);
+      }
+      // Batch to stay under SQLite's bound parameter limit
+      const BATCH_SIZE = 500;
+      let changes = 0;
+      for (let i = 0; i < ids.length; i += BATCH_SIZE) {
+        const batchIds = ids.slice(i, i + BATCH_SIZE);
+        changes += requeue(`id IN (${batchIds.map(() => '?').join(',')})`, batchIds);
+      }
+      return changes;
+    })();
+  }
+
+  private getMaxAttempts(): number {
+    return this.maxAttemptsOverride ?? indexingConfig.queueMaxAttempts;
+  }
+
+  /**
+   * Returns how long until the earliest pending document that is waiting on retry backoff
+   * becomes available, or null if no pending documents are delayed.
+   */
+  getNextAttemptDelayMs(): number | null {
+    const now = Date.now();
+    const row = this.db
+      .prepare('SELECT MIN(next_attempt_at) as next FROM queue WHERE status = ? AND next_attempt_at > ?')
+      .get(QUEUE_STATUS_PENDING, now) as { next: number | null } | undefined;
+    if (!row || row.next === null || row.next === undefined) {
+      return null;
+    }
+    return Math.max(0, row.next - now);
+  }
+
   async requeueStaleTasks(): Promise<void> {
     this.logger.info('Checking for stale tasks...');
 
@@ -368,7 +793,8 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
           UPDATE queue
           SET status = ?,
               processing_started_at = NULL,
-              worker_pid = NULL
+              worker_pid = NULL,
+              lease_id = NULL
           WHERE status = ?
           AND worker_pid IN (${batchPids.map(() => '?').join(',')})
         `
@@ -386,15 +812,39 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
 
     // 2. Requeue items that have timed out (stale timestamp), regardless of PID
     // This catches items with NULL PIDs (legacy) and items where the worker is alive but stuck
-    const staleMinutes = Math.floor(STALE_TIMEOUT_MS / (60 * 1000));
-    const staleWindow = `-${staleMinutes} minutes`;
+    const reclaimed = this.reclaimExpiredLeases();
+    if (reclaimed > 0) {
+      this.logger.warn(`Requeued ${reclaimed} timed-out tasks.`);
+    }
+
+    // Log total processing count for visibility
+    const countResult = this.db
+      .prepare('SELECT COUNT(*) as count FROM queue WHERE status = ?')
+      .get(QUEUE_STATUS_PROCESSING) as { count: number };
+    if (countResult.count > 0) {
+      this.logger.info(`${countResult.count} tasks remain in processing state (active workers).`);
+    } else {
+      this.logger.info('No tasks in processing state.');
+    }
+  }
+
+  /**
+   * Returns documents whose lease expired to `pending`: claimed longer than the visibility timeout
+   * ago and not renewed since. Rows without a claim time, left by older versions, count as expired.
+   *
+   * @returns The number of documents requeued.
+   */
+  private reclaimExpiredLeases(): number {
+    const staleSeconds = Math.max(1, Math.floor(indexingConfig.queueVisibilityTimeoutMs / 1000));
+    const staleWindow = `-${staleSeconds} seconds`;
     const result = this.db
       .prepare(
         `
       UPDATE queue
       SET status = ?,
           processing_started_at = NULL,
-          worker_pid = NULL
+          worker_pid = NULL,
+          lease_id = NULL
       WHERE status = ?
       AND (
         processing_started_at IS NULL
@@ -406,33 +856,228 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
       .run(QUEUE_STATUS_PENDING, QUEUE_STATUS_PROCESSING, staleWindow);
 
     if (result.changes > 0) {
-      this.logger.warn(`Requeued ${result.changes} timed-out tasks.`);
       this.metrics.queue?.documentsRequeued.add(result.changes, createAttributes(this.metrics, { reason: 'timeout' }));
     }
-
-    // Log total processing count for visibility
-    const countResult = this.db
-      .prepare('SELECT COUNT(*) as count FROM queue WHERE status = ?')
-      .get(QUEUE_STATUS_PROCESSING) as { count: number };
-    if (countResult.count > 0) {
-      this.logger.info(`${countResult.count} tasks remain in processing state (active workers).`);
-    } else {
-      this.logger.info('No tasks in processing state.');
-    }
+    return result.changes;
   }
 
   /**
-   * Clear all items from the queue (pending, processing, and failed)
+   * Clear all items from the queue (pending, processing, and dead-lettered)
    * Used when doing a clean reindex to start completely fresh
    */
   async clear(): Promise<void> {
-    this.logger.info('Clearing queue (removing all items including failed)');
+    this.logger.info('Clearing queue (removing all items including dead-lettered)');
     const result = this.db.prepare('DELETE FROM queue').run();
-    this.logger.info(`Cleared ${result.changes} items from queue`);
+    const deadLetter = this.db.prepare('DELETE FROM dead_letter').run();
+    this.logger.info(`Cleared ${result.changes} items from queue and ${deadLetter.changes} from the dead-letter table`);
 
     // Also clear enqueue metadata
     this.db.prepare('DELETE FROM queue_metadata WHERE key = ?').run(QUEUE_METADATA_KEY_ENQUEUE_COMPLETED);
     this.db.prepare('DELETE FROM queue_metadata WHERE key = ?').run(QUEUE_METADATA_KEY_ENQUEUE_COMMIT_HASH);
+    this.db.prepare('DELETE FROM queue_metadata WHERE key = ?').run(QUEUE_METADATA_KEY_DOCUMENTS_COMPLETED);
+    this.db.prepare('DELETE FROM enqueued_files').run();
+    // Hashes of files that were never fully indexed can no longer be promoted.
+    this.db.prepare('DELETE FROM pending_file_hashes').run();
+    // Every file is parsed again.
+    this.db.prepare('DELETE FROM parse_failures').run();
+
+    // Deleted rows only free pages inside the file; compacting returns them to the file system.
+    this.compact();
+  }
+
+  /**
+   * Records a file as enqueued. When the file was already recorded (it changed since an interrupted
+   * enqueue), its pending documents from that earlier attempt are removed first.
+   *
+   * A content hash replaces the file's indexed hash: it takes effect immediately for a file without
+   * documents, and otherwise once the file's last document is committed.
+   * Must be called inside a transaction, before the file's documents are inserted.
+   */
+  private recordEnqueuedFile(sourceFile: EnqueuedFile, documentCount: number): void {
+    const { filePath, mtimeMs, sha256, chunkCount = documentCount, indexName } = sourceFile;
+    if (mtimeMs !== undefined) {
+      const existing = this.db.prepare('SELECT 1 FROM enqueued_files WHERE file_path = ?').get(filePath);
+      if (existing) {
+        this.db
+          .prepare(`DELETE FROM queue WHERE status = ? AND ${DOCUMENT_FILE_PATH} = ?`)
+          .run(QUEUE_STATUS_PENDING, filePath);
+      }
+      this.db
+        .prepare('INSERT OR REPLACE INTO enqueued_files (file_path, mtime_ms) VALUES (?, ?)')
+        .run(filePath, Math.floor(mtimeMs));
+    }
+
+    if (sha256 === undefined) {
+      return;
+    }
+    this.db.prepare('DELETE FROM indexed_files WHERE file_path = ?').run(filePath);
+    const table = documentCount === 0 ? 'indexed_files' : 'pending_file_hashes';
+    this.db
+      .prepare(`INSERT OR REPLACE INTO ${table} (file_path, sha256, chunk_count, index_name) VALUES (?, ?, ?, ?)`)
+      .run(filePath, sha256, chunkCount, indexName ?? null);
+  }
+
+  /**
+   * Promotes the pending hash of each file that has no documents left in the queue.
+   * Must be called inside the transaction that deleted the committed documents.
+   */
+  private promoteFileHashes(filePaths: Set<string>): void {
+    const remaining = this.db.prepare(`SELECT 1 FROM queue WHERE ${DOCUMENT_FILE_PATH} = ? LIMIT 1`);
+    const promote = this.db.prepare(
+      `INSERT OR REPLACE INTO indexed_files (file_path, sha256, chunk_count, index_name)
+       SELECT file_path, sha256, chunk_count, index_name FROM pending_file_hashes WHERE file_path = ?`
+    );
+    const removePending = this.db.prepare('DELETE FROM pending_file_hashes WHERE file_path = ?');
+    for (const filePath of filePaths) {
+      if (!remaining.get(filePath)) {
+        promote.run(filePath);
+        removePending.run(filePath);
+      }
+    }
+  }
+
+  getFileHashes(): Map<string, string> {
+    const rows = this.db.prepare('SELECT file_path, sha256 FROM indexed_files').all() as {
+      file_path: string;
+      sha256: string;
+    }[];
+    return new Map(rows.map((row) => [row.file_path, row.sha256]));
+  }
+
+  /**
+   * Lists the files whose documents were all indexed, by path. Given an index, files recorded for
+   * another index are left out; files recorded before the index name was kept are always listed.
+   */
+  getIndexedFiles(indexName?: string): IndexedFile[] {
+    const where = indexName === undefined ? '' : 'WHERE index_name IS NULL OR index_name = ?';
+    return this.db
+      .prepare(
+        `SELECT file_path AS filePath, sha256, indexed_at AS indexedAt, chunk_count AS chunkCount,
+                index_name AS indexName
+         FROM indexed_files ${where}
+         ORDER BY file_path`
+      )
+      .all(...(indexName === undefined ? [] : [indexName])) as IndexedFile[];
+  }
+
+  /**
+   * True when a document with a row id from `firstId` to `lastId` is still in the queue, pending,
+   * processing or waiting for a retry. Requeued documents keep their row id.
+   */
+  hasDocumentsInRange(firstId: number, lastId: number): boolean {
+    return this.db.prepare('SELECT 1 FROM queue WHERE id BETWEEN ? AND ? LIMIT 1').get(firstId, lastId) !== undefined;
+  }
+
+  /** Number of files with documents still in the queue, whose new state is not recorded yet. */
+  getPendingFileCount(): number {
+    const row = this.db.prepare('SELECT COUNT(*) AS count FROM pending_file_hashes').get() as { count: number };
+    return row.count;
+  }
+
+  async deleteFileHashes(filePaths: string[]): Promise<void> {
+    const deleteHash = this.db.prepare('DELETE FROM indexed_files WHERE file_path = ?');
+    const deletePending = this.db.prepare('DELETE FROM pending_file_hashes WHERE file_path = ?');
+    this.db.transaction(() => {
+      for (const filePath of filePaths) {
+        deleteHash.run(filePath);
+        deletePending.run(filePath);
+      }
+    })();
+  }
+
+  async clearFileHashes(): Promise<void> {
+    const result = this.db.prepare('DELETE FROM indexed_files').run();
+    this.db.prepare('DELETE FROM pending_file_hashes').run();
+    this.logger.info(`Cleared ${result.changes} file content hashes`);
+  }
+
+  recordParseFailure(failure: ParseFailure): void {
+    this.db
+      .prepare(
+        `INSERT OR REPLACE INTO parse_failures (file_path, language, error, whole_file, failed_at)
+         VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+      )
+      .run(failure.file, failure.language || null, failure.error, failure.wholeFile ? 1 : 0);
+  }
+
+  clearParseFailures(filePaths: string[]): void {
+    const remove = this.db.prepare('DELETE FROM parse_failures WHERE file_path = ?');
+    this.db.transaction(() => {
+      for (const filePath of filePaths) {
+        remove.run(filePath);
+      }
+    })();
+  }
+
+  /** Files whose last parse failed, by path. */
+  getParseFailures(): RecordedParseFailure[] {
+    const rows = this.db
+      .prepare(
+        `SELECT file_path AS filePath, language, error, whole_file AS wholeFile, failed_at AS failedAt
+         FROM parse_failures
+         ORDER BY file_path`
+      )
+      .all() as Array<Omit<RecordedParseFailure, 'wholeFile'> & { wholeFile: number }>;
+    return rows.map((row) => ({ ...row, wholeFile: row.wholeFile === 1 }));
+  }
+
+  async markLocationsStale(filePaths: string[], indexedBefore: number, keptLocationIds?: string[]): Promise<void> {
+    const mark = this.db.prepare(
+      'INSERT OR REPLACE INTO stale_location_files (file_path, indexed_before, kept_location_ids) VALUES (?, ?, ?)'
+    );
+    const kept = keptLocationIds ? JSON.stringify(keptLocationIds) : null;
+    this.db.transaction(() => {
+      for (const filePath of filePaths) {
+        mark.run(filePath, Math.floor(indexedBefore), kept);
+      }
+    })();
+  }
+
+  /** Returns the locations kept by {@link markLocationsStale}, for the marked files that have any. */
+  getKeptLocationIds(): Map<string, string[]> {
+    const rows = this.db
+      .prepare('SELECT file_path, kept_location_ids FROM stale_location_files WHERE kept_location_ids IS NOT NULL')
+      .all() as { file_path: string; kept_location_ids: string }[];
+    return new Map(rows.map((row) => [row.file_path, JSON.parse(row.kept_location_ids) as string[]]));
+  }
+
+  /**
+   * Returns the files marked by {@link markLocationsStale} that have no documents left in the queue,
+   * mapped to the time their re-index started. Files with dead-lettered documents keep their old
+   * locations until those documents are requeued and indexed.
+   */
+  getPrunableLocationFiles(): Map<string, number> {
+    const rows = this.db
+      .prepare(
+        `SELECT file_path, indexed_before FROM stale_location_files
+         WHERE NOT EXISTS (SELECT 1 FROM queue WHERE ${DOCUMENT_FILE_PATH} = stale_location_files.file_path)
+           AND NOT EXISTS (SELECT 1 FROM dead_letter WHERE ${DOCUMENT_FILE_PATH} = stale_location_files.file_path)`
+      )
+      .all() as { file_path: string; indexed_before: number }[];
+    return new Map(rows.map((row) => [row.file_path, row.indexed_before]));
+  }
+
+  /**
+   * Forgets pruned files. A file marked again since it was read keeps its newer mark.
+   */
+  async clearPrunedLocationFiles(files: Map<string, number>): Promise<void> {
+    const clear = this.db.prepare('DELETE FROM stale_location_files WHERE file_path = ? AND indexed_before = ?');
+    this.db.transaction(() => {
+      for (const [filePath, indexedBefore] of files) {
+        clear.run(filePath, indexedBefore);
+      }
+    })();
+  }
+
+  /**
+   * Returns the files recorded since the last completed enqueue, mapped to their modification time.
+   */
+  getEnqueuedFiles(): Map<string, number> {
+    const rows = this.db.prepare('SELECT file_path, mtime_ms FROM enqueued_files').all() as {
+      file_path: string;
+      mtime_ms: number;
+    }[];
+    return new Map(rows.map((row) => [row.file_path, row.mtime_ms]));
   }
 
   /**
@@ -442,6 +1087,11 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
    * We store a boolean-like value; `isEnqueueCompleted()` only returns true when the value is exactly "true".
    */
   async markEnqueueStarted(): Promise<void> {
+    // A new session starts counting completed documents from zero; a resumed one keeps its count.
+    const queued = this.db.prepare('SELECT COUNT(*) as count FROM queue').get() as { count: number };
+    if (queued.count === 0) {
+      this.db.prepare('DELETE FROM queue_metadata WHERE key = ?').run(QUEUE_METADATA_KEY_DOCUMENTS_COMPLETED);
+    }
     this.db
       .prepare(
         `INSERT OR REPLACE INTO queue_metadata (key, value, updated_at)
@@ -461,6 +1111,8 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
          VALUES (?, ?, CURRENT_TIMESTAMP)`
       )
       .run(QUEUE_METADATA_KEY_ENQUEUE_COMPLETED, 'true');
+    // File tracking only matters while an enqueue can still be resumed.
+    this.db.prepare('DELETE FROM enqueued_files').run();
     this.logger.info('Marked enqueue as completed');
   }
 
@@ -487,6 +1139,35 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
     return typeof result?.value === 'string' && result.value.length > 0 ? result.value : null;
   }
 
+  /**
+   * Number of documents committed since the current enqueue session started.
+   *
+   * Committed documents are deleted from the queue, so this counter is the only record of work
+   * already done when a run resumes.
+   */
+  getCompletedCount(): number {
+    const result = this.db
+      .prepare('SELECT value FROM queue_metadata WHERE key = ?')
+      .get(QUEUE_METADATA_KEY_DOCUMENTS_COMPLETED) as { value: string } | undefined;
+    const count = result ? parseInt(result.value, 10) : 0;
+    return Number.isFinite(count) ? count : 0;
+  }
+
+  private addCompletedCount(count: number): void {
+    if (count <= 0) {
+      return;
+    }
+    this.db
+      .prepare(
+        `INSERT INTO queue_metadata (key, value, updated_at)
+         VALUES (?, ?, CURRENT_TIMESTAMP)
+         ON CONFLICT(key) DO UPDATE SET
+           value = CAST(CAST(value AS INTEGER) + CAST(excluded.value AS INTEGER) AS TEXT),
+           updated_at = CURRENT_TIMESTAMP`
+      )
+      .run(QUEUE_METADATA_KEY_DOCUMENTS_COMPLETED, String(count));
+  }
+
   /**
    * Check if enqueue was completed
    */
@@ -498,6 +1179,7 @@ export class SqliteQueue implements IQueueWithEnqueueMetadata {
   }
 
   close(): void {
+    this.gaugeCallbacks.splice(0).forEach((removeCallback) => removeCallback());
     this.db.close();
   }
 }
@@ -0,0 +1,123 @@
+import fs from 'fs';
+import { once } from 'events';
+import { CodeChunk, getChunkDocumentId, ReferenceInfo } from './elasticsearch';
+import type { SymbolKind } from './symbol_kinds';
+
+/** A symbol defined in a parsed file, one JSON line of `--export-symbols`. */
+export interface SymbolRecord {
+  /**
+   * The `symbol_id` shared by the parts of a split symbol, or else the id of the symbol's chunk
+   * document, so a record can be joined with the index.
+   */
+  id: string;
+  repo: string;
+  /** Fully-qualified name, e.g. `main.Greeter.Greet`. */
+  name: string;
+  /** Language-independent kind, e.g. `method`, when the language maps the definition to one. */
+  kind?: SymbolKind;
+  /** Syntax node type of the definition, e.g. `method_declaration`. */
+  nodeType?: string;
+  language: string;
+  file: string;
+  startLine: number;
+  endLine: number;
+  /** Innermost symbol enclosing this one, e.g. `Greeter` for `Greeter.Greet`. */
+  parent?: string;
+  /** Symbols the definition calls or instantiates, by name as the parser recorded them. */
+  references: ReferenceInfo[];
+  /** Chunk documents the symbol is stored in, more than one when it was split. */
+  chunkIds: string[];
+}
+
+/**
+ * Returns the symbols defined by the chunks of one file, in the order of the chunks. The parts of a
+ * split symbol become one record spanning all of their lines. Chunks that do not define a named
+ * symbol, such as Markdown sections or whole-file chunks, have no record.
+ */
+export function collectSymbols(
+  chunks: CodeChunk[],
+  repoName: string,
+  options: { dedup?: boolean } = {}
+): SymbolRecord[] {
+  const records = new Map<string, SymbolRecord>();
+  const referenceKeys = new Map<string, Set<string>>();
+  for (const chunk of chunks) {
+    if (!chunk.symbol_fqn || !chunk.filePath || chunk.startLine === undefined || chunk.endLine === undefined) {
+      continue;
+    }
+    const chunkId = getChunkDocumentId(chunk, { dedup: options.dedup });
+    const id = chunk.symbol_id ?? chunkId;
+    let record = records.get(id);
+    if (!record) {
+      record = {
+        id,
+        repo: repoName,
+        name: chunk.symbol_fqn,
+        ...(chunk.symbol_kind ? { kind: chunk.symbol_kind } : {}),
+        ...(chunk.kind ? { nodeType: chunk.kind } : {}),
+        language: chunk.language,
+        file: chunk.filePath,
+        startLine: chunk.startLine,
+        endLine: chunk.endLine,
+        ...(chunk.parent_symbol ? { parent: chunk.parent_symbol } : {}),
+        references: [],
+        chunkIds: [],
+      };
+      records.set(id, record);
+    }
+    const keys = referenceKeys.get(id) ?? new Set<string>();
+    referenceKeys.set(id, keys);
+    record.startLine = Math.min(record.startLine, chunk.startLine);
+    record.endLine = Math.max(record.endLine, chunk.endLine);
+    record.chunkIds.push(chunkId);
+    for (const reference of chunk.references ?? []) {
+      const key = [reference.kind, reference.receiver, reference.receiver_type, reference.name].join(':');
+      if (!keys.has(key)) {
+        keys.add(key);
+        record.references.push(reference);
+      }
+    }
+  }
+  return Array.from(records.values());
+}
+
+/**
+ * Streams symbol records to a JSON Lines file as files are parsed, waiting for the file to drain
+ * so that memory stays bounded however large the repository is.
+ */
+export class SymbolExportWriter {
+  private readonly stream: fs.WriteStream;
+  private error: Error | undefined;
+  /** Records written so far. */
+  symbols = 0;
+
+  constructor(readonly filePath: string) {
+    this.stream = fs.createWriteStream(filePath);
+    this.stream.on('error', (error) => {
+      this.error = error;
+    });
+  }
+
+  async write(records: SymbolRecord[]): Promise<void> {
+    if (this.error) {
+      throw this.error;
+    }
+    if (records.length === 0) {
+      return;
+    }
+    this.symbols += records.length;
+    if (!this.stream.write(records.map((record) => `${JSON.stringify(record)}\n`).join(''))) {
+      await once(this.stream, 'drain');
+    }
+  }
+
+  /** Flushes and closes the file. */
+  async close(): Promise<void> {
+    await new Promise<void>((resolve, reject) => {
+      this.stream.end((error?: Error | null) => (error ? reject(error) : resolve()));
+    });
+    if (this.error) {
+      throw this.error;
+    }
+  }
+}
@@ -0,0 +1,174 @@
+import type Parser from 'tree-sitter';
+
+/**
+ * Language-independent kinds of the symbols chunked by tree-sitter. Classes and type aliases are
+ * `type`, the structs of C, C++, Go and Rust are `struct`, traits are `interface`, and constants
+ * and variables are `variable`.
+ */
+export const SYMBOL_KINDS = ['function', 'method', 'type', 'struct', 'interface', 'enum', 'variable'] as const;
+export type SymbolKind = (typeof SYMBOL_KINDS)[number];
+
+/** Other names accepted by {@link parseSymbolKinds}. */
+const SYMBOL_KIND_ALIASES: Record<string, SymbolKind> = {
+  func: 'function',
+  fn: 'function',
+  class: 'type',
+  trait: 'interface',
+  const: 'variable',
+  var: 'variable',
+};
+
+/** Definition node types of every language, mapped to their symbol kind. */
+const NODE_TYPE_KINDS: Record<string, SymbolKind> = {
+  function_declaration: 'function',
+  function_definition: 'function',
+  function_item: 'function',
+  function_signature_item: 'function',
+  generator_function_declaration: 'function',
+  create_function: 'function',
+  method_declaration: 'method',
+  constructor_declaration: 'method',
+  method_definition: 'method',
+  type_declaration: 'type',
+  type_definition: 'type',
+  type_alias_declaration: 'type',
+  type_item: 'type',
+  class_declaration: 'type',
+  abstract_class_declaration: 'type',
+  class_definition: 'type',
+  class_specifier: 'type',
+  struct_specifier: 'struct',
+  struct_item: 'struct',
+  union_specifier: 'type',
+  object_definition: 'type',
+  object_declaration: 'type',
+  companion_object: 'type',
+  create_type: 'type',
+  interface_declaration: 'interface',
+  annotation_type_declaration: 'interface',
+  trait_item: 'interface',
+  trait_definition: 'interface',
+  enum_declaration: 'enum',
+  enum_specifier: 'enum',
+  enum_item: 'enum',
+  enum_definition: 'enum',
+  const_declaration: 'variable',
+  var_declaration: 'variable',
+  lexical_declaration: 'variable',
+  variable_declaration: 'variable',
+  const_item: 'variable',
+  static_item: 'variable',
+  val_definition: 'variable',
+  property_declaration: 'variable',
+  var_definition: 'variable',
+};
+
+/** Nodes between a function and the type it is declared in, e.g. a Python class body or a C++ template. */
+const TYPE_BODY_NODE_TYPES = new Set([
+  'block',
+  'class_body',
+  'interface_body',
+  'enum_body',
+  'enum_body_declarations',
+  'enum_class_body',
+  'declaration_list',
+  'decorated_definition',
+  'field_declaration_list',
+  'template_body',
+  'template_declaration',
+]);
+
+/** Nodes whose functions are methods, such as Rust `impl` blocks and C++ classes. */
+const METHOD_OWNER_NODE_TYPES = new Set([
+  'class_definition',
+  'class_declaration',
+  'class_specifier',
+  'struct_specifier',
+  'impl_item',
+  'trait_item',
+  'object_definition',
+  'object_declaration',
+  'companion_object',
+  'interface_declaration',
+  'enum_declaration',
+  'trait_definition',
+]);
+
+/**
+ * Which symbol kinds are chunked: only the `include` kinds when given, otherwise all but the
+ * `exclude` kinds. Chunks that are not a symbol, such as statements and comments, are kept.
+ *
+ * Kept as plain JSON so it can be handed to parsing worker threads as is.
+ */
+export interface SymbolKindFilter {
+  include?: SymbolKind[];
+  exclude?: SymbolKind[];
+}
+
+/**
+ * Parses and validates a kind list such as `func,method,type`, so an unknown kind fails at startup
+ * instead of silently filtering nothing. `flag` names the option in error messages.
+ */
+export function parseSymbolKinds(flag: string, value: string): SymbolKind[] {
+  const kinds = new Set<SymbolKind>();
+  for (const name of value.split(',').map((part) => part.trim().toLowerCase())) {
+    if (name.length === 0) {
+      continue;
+    }
+    const kind = SYMBOL_KINDS.includes(name as SymbolKind) ? (name as SymbolKind) : SYMBOL_KIND_ALIASES[name];
+    if (kind === undefined) {
+      throw new Error(`Invalid ${flag} value: unknown kind "${name}". Expected one of: ${SYMBOL_KINDS.join(', ')}.`);
+    }
+    kinds.add(kind);
+  }
+  if (kinds.size === 0) {
+    throw new Error(`Invalid ${flag} value: empty list. Expected one of: ${SYMBOL_KINDS.join(', ')}.`);
+  }
+  return Array.from(kinds);
+}
+
+/**
+ * Returns the kind of the symbol a definition node declares, or undefined for nodes that are not a
+ * symbol. Functions declared in a class, `impl` or trait body are methods, and an `export`
+ * statement has the kind of the declaration it exports.
+ */
+export function getSymbolKind(node: Parser.SyntaxNode): SymbolKind | undefined {
+  if (node.type === 'export_statement') {
+    const declaration = node.childForFieldName('declaration');
+    return declaration ? getSymbolKind(declaration) : undefined;
+  }
+  if (node.type === 'type_declaration') {
+    // Go declares structs and interfaces as types; a grouped `type ( ... )` counts as its first spec.
+    const spec = node.namedChildren.find((child) => child.type === 'type_spec');
+    const type = spec?.childForFieldName('type')?.type;
+    if (type === 'struct_type') {
+      return 'struct';
+    }
+    if (type === 'interface_type') {
+      return 'interface';
+    }
+  }
+  if (node.type === 'class_declaration') {
+    // Kotlin declares interfaces and enum classes as classes too.
+    const marker = node.children.find((child) => child.type === 'interface' || child.type === 'enum_class_body');
+    if (marker) {
+      return marker.type === 'interface' ? 'interface' : 'enum';
+    }
+  }
+  const kind = NODE_TYPE_KINDS[node.type];
+  if (kind !== 'function') {
+    return kind;
+  }
+  let owner = node.parent;
+  while (owner && TYPE_BODY_NODE_TYPES.has(owner.type)) {
+    owner = owner.parent;
+  }
+  return owner && METHOD_OWNER_NODE_TYPES.has(owner.type) ? 'method' : kind;
+}
+
+export function isSymbolKindIncluded(kind: SymbolKind, filter: SymbolKindFilter): boolean {
+  if (filter.include) {
+    return filter.include.includes(kind);
+  }
+  return !filter.exclude?.includes(kind);
+}
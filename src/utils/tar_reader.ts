@@ -0,0 +1,331 @@
+import fs from 'fs';
+import path from 'path';
+import zlib from 'zlib';
+import { spawn } from 'child_process';
+import { pipeline, Readable } from 'stream';
+
+const BLOCK_SIZE = 512;
+const GZIP_MAGIC = [0x1f, 0x8b];
+/** Bytes skipped per read when an entry's content is not needed. */
+const SKIP_READ_BYTES = 1024 * 1024;
+
+/** Suffixes of the archives that are read instead of a repository directory, see {@link isArchivePath}. */
+export const ARCHIVE_SUFFIXES = ['.tar', '.tar.gz', '.tgz'];
+
+/** Returns whether `filePath` names a tar archive (optionally gzip-compressed) by its suffix. */
+export function isArchivePath(filePath: string): boolean {
+  const lowerCasePath = filePath.toLowerCase();
+  return ARCHIVE_SUFFIXES.some((suffix) => lowerCasePath.endsWith(suffix));
+}
+
+/** Returns the archive's file name without its suffix, e.g. `kibana` for `/ci/kibana.tar.gz`. */
+export function getArchiveBaseName(filePath: string): string {
+  const name = path.basename(filePath);
+  const suffix = ARCHIVE_SUFFIXES.find((candidate) => name.toLowerCase().endsWith(candidate)) ?? '';
+  return name.slice(0, name.length - suffix.length);
+}
+
+/**
+ * Normalizes an entry name into a relative path with `/` separators, e.g. `./src/a.ts` into
+ * `src/a.ts`. Returns undefined for names that would point outside the archive root: absolute
+ * paths and paths with `..` segments.
+ */
+export function normalizeArchivePath(name: string): string | undefined {
+  const posixName = name.replace(/\\/g, '/');
+  if (posixName.startsWith('/') || /^[A-Za-z]:\//.test(posixName)) {
+    return undefined;
+  }
+  const normalized = path.posix.normalize(posixName).replace(/\/+$/, '');
+  if (normalized === '..' || normalized.startsWith('../') || normalized === '.' || normalized === '') {
+    return undefined;
+  }
+  return normalized;
+}
+
+export type TarEntryType = 'file' | 'directory' | 'symlink' | 'hardlink' | 'other';
+
+export interface TarEntry {
+  /** Name as stored in the archive, including long names from GNU and PAX extension headers. */
+  name: string;
+  type: TarEntryType;
+  /** Content size in bytes. */
+  size: number;
+  /** The entry's content, for regular files accepted by `readContent`. */
+  content?: Buffer;
+}
+
+export interface ReadTarOptions {
+  /** Decides which regular files have their content read. The content of other entries is skipped. */
+  readContent?: (entry: Omit<TarEntry, 'content'>) => boolean;
+}
+
+/** Reads exact byte counts from a stream, without holding more than one stream chunk besides the result. */
+class ByteReader {
+  private buffered: Buffer[] = [];
+  private bufferedLength = 0;
+  private ended = false;
+
+  constructor(private readonly source: AsyncIterator<Buffer>) {}
+
+  /** Returns `length` bytes, or fewer when the stream ends first. */
+  async read(length: number): Promise<Buffer> {
+    while (this.bufferedLength < length && !this.ended) {
+      const next = await this.source.next();
+      if (next.done) {
+        this.ended = true;
+      } else {
+        this.buffered.push(next.value);
+        this.bufferedLength += next.value.length;
+      }
+    }
+    const all = this.buffered.length === 1 ? this.buffered[0] : Buffer.concat(this.buffered, this.bufferedLength);
+    const result = all.subarray(0, Math.min(length, all.length));
+    const rest = all.subarray(result.length);
+    this.buffered = rest.length > 0 ? [rest] : [];
+    this.bufferedLength = rest.length;
+    return result;
+  }
+
+  /** Reads `length` bytes without keeping them. Returns false when the stream ends first. */
+  async skip(length: number): Promise<boolean> {
+    let remaining = length;
+    while (remaining > 0) {
+      const skipped = await this.read(Math.min(remaining, SKIP_READ_BYTES));
+      if (skipped.length === 0) {
+        return false;
+      }
+      remaining -= skipped.length;
+    }
+    return true;
+  }
+}
+
+function readString(block: Buffer, offset: number, length: number): string {
+  const end = block.indexOf(0, offset);
+  return block.toString('utf8', offset, end === -1 || end > offset + length ? offset + length : end);
+}
+
+function readNumber(block: Buffer, offset: number, length: number): number {
+  // Values that do not fit the octal field, such as sizes of 8 GiB and more, are stored in base-256.
+  if (block[offset] & 0x80) {
+    let value = block[offset] & 0x7f;
+    for (let i = offset + 1; i < offset + length; i++) {
+      value = value * 256 + block[i];
+    }
+    return value;
+  }
+  const text = readString(block, offset, length).trim();
+  return text ? parseInt(text, 8) : 0;
+}
+
+function hasValidChecksum(block: Buffer): boolean {
+  let sum = 0;
+  for (let i = 0; i < BLOCK_SIZE; i++) {
+    // The checksum field itself is summed as spaces.
+    sum += i >= 148 && i < 156 ? 0x20 : block[i];
+  }
+  return sum === readNumber(block, 148, 8);
+}
+
+/** Parses PAX extended header records (`<length> <key>=<value>\n`). */
+function parsePaxRecords(data: Buffer): Record<string, string> {
+  const records: Record<string, string> = {};
+  let offset = 0;
+  while (offset < data.length) {
+    const space = data.indexOf(0x20, offset);
+    const length = parseInt(data.toString('utf8', offset, space), 10);
+    if (space === -1 || !Number.isInteger(length) || length <= 0) {
+      break;
+    }
+    const record = data.toString('utf8', space + 1, offset + length - 1);
+    const separator = record.indexOf('=');
+    if (separator > 0) {
+      records[record.slice(0, separator)] = record.slice(separator + 1);
+    }
+    offset += length;
+  }
+  return records;
+}
+
+function toEntryType(typeFlag: string): TarEntryType {
+  switch (typeFlag) {
+    case '0':
+    case '\0':
+    case '7':
+      return 'file';
+    case '5':
+      return 'directory';
+    case '2':
+      return 'symlink';
+    case '1':
+      return 'hardlink';
+    default:
+      return 'other';
+  }
+}
+
+function isGzipFile(filePath: string): boolean {
+  const fd = fs.openSync(filePath, 'r');
+  try {
+    const magic = Buffer.alloc(GZIP_MAGIC.length);
+    const bytesRead = fs.readSync(fd, magic, 0, magic.length, 0);
+    return bytesRead === magic.length && GZIP_MAGIC.every((byte, i) => magic[i] === byte);
+  } finally {
+    fs.closeSync(fd);
+  }
+}
+
+/**
+ * Streams the entries of a tar archive, decompressing it first when it is gzip-compressed
+ * (detected from its content, not its suffix). Entries are read one at a time, so only the
+ * content of the current entry is held in memory. Ustar, GNU long names and PAX `path` and
+ * `size` records are supported.
+ *
+ * @throws When the archive is not a tar archive or ends in the middle of an entry.
+ */
+export async function* readTarEntries(archivePath: string, options: ReadTarOptions = {}): AsyncGenerator<TarEntry> {
+  const fileStream = fs.createReadStream(archivePath);
+  const stream: Readable = isGzipFile(archivePath)
+    ? pipeline(fileStream, zlib.createGunzip(), () => {
+        // Errors are thrown by the iterator below.
+      })
+    : fileStream;
+  try {
+    yield* readTarStream(stream, archivePath, options);
+  } finally {
+    fileStream.destroy();
+  }
+}
+
+/**
+ * Streams the entries of an uncompressed tar archive from `stream`, see {@link readTarEntries}.
+ * `archiveName` names the archive in errors. The stream is destroyed once the entries are read.
+ */
+export async function* readTarStream(
+  stream: Readable,
+  archiveName: string,
+  options: ReadTarOptions = {}
+): AsyncGenerator<TarEntry> {
+  const reader = new ByteReader(stream[Symbol.asyncIterator]());
+  // Extension headers apply to the entry that follows them.
+  let longName: string | undefined;
+  let paxRecords: Record<string, string> = {};
+
+  try {
+    while (true) {
+      const header = await reader.read(BLOCK_SIZE);
+      // Archives end with two zero blocks, but some writers leave them out.
+      if (header.length === 0 || header.every((byte) => byte === 0)) {
+        return;
+      }
+      if (header.length < BLOCK_SIZE || !hasValidChecksum(header)) {
+        throw new Error(`${archiveName} is not a valid tar archive.`);
+      }
+
+      const typeFlag = String.fromCharCode(header[156]);
+      const size = paxRecords.size !== undefined ? Number(paxRecords.size) : readNumber(header, 124, 12);
+      const paddedSize = Math.ceil(size / BLOCK_SIZE) * BLOCK_SIZE;
+      const isExtensionHeader = typeFlag === 'L' || typeFlag === 'x' || typeFlag === 'K' || typeFlag === 'g';
+
+      if (isExtensionHeader) {
+        const data = await reader.read(paddedSize);
+        if (data.length < paddedSize) {
+          throw new Error(`Unexpected end of archive ${archiveName}.`);
+        }
+        if (typeFlag === 'L') {
+          longName = readString(data, 0, size);
+        } else if (typeFlag === 'x') {
+          paxRecords = parsePaxRecords(data.subarray(0, size));
+        }
+        // Long link names and global PAX headers do not affect which files are indexed.
+        continue;
+      }
+
+      // The ustar prefix field holds other data in old GNU archives.
+      const prefix = header.toString('latin1', 257, 263) === 'ustar\0' ? readString(header, 345, 155) : '';
+      const storedName = prefix ? `${prefix}/${readString(header, 0, 100)}` : readString(header, 0, 100);
+      const entry: TarEntry = {
+        name: paxRecords.path ?? longName ?? storedName,
+        type: toEntryType(typeFlag),
+        size,
+      };
+      longName = undefined;
+      paxRecords = {};
+
+      if (entry.type === 'file' && options.readContent?.(entry)) {
+        const data = await reader.read(paddedSize);
+        if (data.length < paddedSize) {
+          throw new Error(`Unexpected end of archive ${archiveName}.`);
+        }
+        entry.content = Buffer.from(data.subarray(0, size));
+      } else if (!(await reader.skip(paddedSize))) {
+        throw new Error(`Unexpected end of archive ${archiveName}.`);
+      }
+      yield entry;
+    }
+  } finally {
+    stream.destroy();
+  }
+}
+
+/**
+ * Returns the directory every entry of the archive is under, e.g. `kibana-8.15.0` for the tarballs
+ * GitHub and `git archive --prefix` produce, or undefined when entries are at the archive root or
+ * under several directories. Only entry headers are read.
+ */
+export async function findArchiveRoot(archivePath: string): Promise<string | undefined> {
+  let root: string | undefined;
+  for await (const entry of readTarEntries(archivePath)) {
+    const name = normalizeArchivePath(entry.name);
+    if (name === undefined) {
+      continue;
+    }
+    const separator = name.indexOf('/');
+    const topLevel = separator === -1 ? name : name.slice(0, separator);
+    // A file at the root, even alone, means there is no top-level directory to strip.
+    if ((separator === -1 && entry.type !== 'directory') || (root !== undefined && topLevel !== root)) {
+      return undefined;
+    }
+    root = topLevel;
+  }
+  return root;
+}
+
+/**
+ * Streams the entries of `ref` in the repository at `repoPath` from `git archive`, without a
+ * checkout of the ref. Entry names are relative to the repository root, and files marked
+ * `export-ignore` in `.gitattributes` are left out by git.
+ *
+ * @throws When git cannot archive the ref, e.g. because it does not exist.
+ */
+export async function* readGitArchiveEntries(
+  repoPath: string,
+  ref: string,
+  options: ReadTarOptions = {}
+): AsyncGenerator<TarEntry> {
+  const git = spawn('git', ['archive', '--format=tar', ref], { cwd: repoPath, stdio: ['ignore', 'pipe', 'pipe'] });
+  const stderr: Buffer[] = [];
+  git.stderr.on('data', (data: Buffer) => stderr.push(data));
+  const exited = new Promise<number | null>((resolve, reject) => {
+    git.on('error', reject);
+    git.on('close', resolve);
+  });
+  // Awaited once the entries are read; a spawn failure also ends stdout.
+  exited.catch(() => {});
+
+  let completed = false;
+  try {
+    yield* readTarStream(git.stdout, `git archive ${ref}`, options);
+    completed = true;
+  } finally {
+    if (!completed) {
+      // Entries were abandoned or could not be read: git is stopped instead of writing the rest.
+      git.kill();
+    }
+  }
+  const code = await exited;
+  if (code !== 0) {
+    const message = Buffer.concat(stderr).toString().trim();
+    throw new Error(`git archive ${ref} failed in ${repoPath}${message ? `: ${message}` : '.'}`);
+  }
+}
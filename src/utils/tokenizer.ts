@@ -0,0 +1,121 @@
+import type { CodeChunk } from './elasticsearch';
+
+export const TOKENIZERS = ['bpe', 'whitespace', 'chars'] as const;
+export type TokenizerName = (typeof TOKENIZERS)[number];
+
+/** Counts the tokens of a text the way an embedding model would, see {@link getTokenizer}. */
+export interface Tokenizer {
+  readonly name: string;
+  countTokens(text: string): number;
+}
+
+/**
+ * Rough characters-per-token ratio for source code. Code tokenizes denser than prose, so this errs
+ * on the side of splitting a little early rather than exceeding the embedding model's limit.
+ */
+export const CHARS_PER_TOKEN = 3;
+
+/**
+ * Estimates how many embedding model tokens `text` uses, without loading a tokenizer.
+ */
+export function estimateTokenCount(text: string): number {
+  return Math.ceil(text.length / CHARS_PER_TOKEN);
+}
+
+/**
+ * Splits text into the pieces BPE merges never cross, like the pre-tokenizer of OpenAI's
+ * `cl100k_base`: contractions, words with one leading non-letter, runs of up to three digits,
+ * punctuation runs and whitespace.
+ */
+const BPE_PIECES =
+  /'(?:[sdmt]|ll|ve|re)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+/giu;
+
+/** Characters a BPE vocabulary merges into one token on average within a long piece. */
+const BPE_CHARS_PER_MERGE = 4;
+
+/**
+ * Approximates a tiktoken-style BPE without its vocabulary: every pre-tokenizer piece is at least
+ * one token, and long identifiers or indentation runs are one token per four characters.
+ */
+const bpeTokenizer: Tokenizer = {
+  name: 'bpe',
+  countTokens(text) {
+    let tokens = 0;
+    for (const [piece] of text.matchAll(BPE_PIECES)) {
+      tokens += Math.max(1, Math.ceil(piece.length / BPE_CHARS_PER_MERGE));
+    }
+    return tokens;
+  },
+};
+
+/** Counts runs of non-whitespace characters. */
+const whitespaceTokenizer: Tokenizer = {
+  name: 'whitespace',
+  countTokens(text) {
+    return text.match(/\S+/g)?.length ?? 0;
+  },
+};
+
+const charsTokenizer: Tokenizer = { name: 'chars', countTokens: estimateTokenCount };
+
+const TOKENIZER_IMPLEMENTATIONS: Record<TokenizerName, Tokenizer> = {
+  bpe: bpeTokenizer,
+  whitespace: whitespaceTokenizer,
+  chars: charsTokenizer,
+};
+
+/** Validates a `--tokenizer` value. */
+export function parseTokenizerName(name: string): TokenizerName {
+  if (!TOKENIZERS.includes(name as TokenizerName)) {
+    throw new Error(`Invalid --tokenizer value: ${name}. Expected one of: ${TOKENIZERS.join(', ')}.`);
+  }
+  return name as TokenizerName;
+}
+
+/** Returns the tokenizer selected by `--tokenizer`, throwing on an unknown name. */
+export function getTokenizer(name: string): Tokenizer {
+  return TOKENIZER_IMPLEMENTATIONS[parseTokenizerName(name)];
+}
+
+/** The tokenizer matching an embedding provider's models: BPE for OpenAI, whitespace otherwise. */
+export function getDefaultTokenizerName(provider = 'elasticsearch'): TokenizerName {
+  return provider === 'openai' ? 'bpe' : 'whitespace';
+}
+
+/** Chunks listed by {@link LargestChunks.format}. */
+export const LARGEST_CHUNKS_LOGGED = 5;
+
+/** Keeps the chunks with the highest `token_count` seen during a run, largest first. */
+export class LargestChunks {
+  private readonly chunks: Array<Pick<CodeChunk, 'filePath' | 'startLine' | 'endLine'> & { tokens: number }> = [];
+
+  constructor(private readonly limit = LARGEST_CHUNKS_LOGGED) {}
+
+  get size(): number {
+    return this.chunks.length;
+  }
+
+  add(chunk: Pick<CodeChunk, 'filePath' | 'startLine' | 'endLine' | 'token_count'>): void {
+    const tokens = chunk.token_count;
+    if (tokens === undefined) {
+      return;
+    }
+    if (this.chunks.length >= this.limit && tokens <= this.chunks[this.chunks.length - 1].tokens) {
+      return;
+    }
+    const at = this.chunks.findIndex((other) => other.tokens < tokens);
+    const entry = { filePath: chunk.filePath, startLine: chunk.startLine, endLine: chunk.endLine, tokens };
+    this.chunks.splice(at === -1 ? this.chunks.length : at, 0, entry);
+    this.chunks.length = Math.min(this.chunks.length, this.limit);
+  }
+
+  /** Lists the chunks as `path:start-end (N tokens)`, marking those over `maxTokens` when it is set. */
+  format(maxTokens = 0): string {
+    return this.chunks
+      .map((chunk) => {
+        const over = maxTokens > 0 && chunk.tokens > maxTokens ? ', over --max-chunk-tokens' : '';
+        return `${chunk.filePath}:${chunk.startLine}-${chunk.endLine} (${chunk.tokens} tokens${over})`;
+      })
+      .join(', ');
+  }
+}
@@ -0,0 +1,37 @@
+package com.example.api;
+
+import org.springframework.web.bind.annotation.GetMapping;
+import org.springframework.web.bind.annotation.RestController;
+
+/**
+ * Serves the users of the API.
+ */
+@RestController
+public class UserController {
+    private final UserRepository repository;
+
+    public UserController(UserRepository repository) {
+        this.repository = repository;
+    }
+
+    /**
+     * Returns every user.
+     */
+    @GetMapping("/users")
+    public List<User> listUsers() {
+        return repository.findAll();
+    }
+}
+
+interface UserRepository {
+    List<User> findAll();
+}
+
+enum Role {
+    ADMIN,
+    MEMBER
+}
+
+@interface Audited {
+    String value() default "";
+}
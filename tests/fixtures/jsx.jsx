@@ -0,0 +1,36 @@
+import React, { memo, useEffect, useState } from 'react';
+
+/**
+ * Tracks the window width.
+ */
+export function useWindowWidth() {
+  const [width, setWidth] = useState(window.innerWidth);
+  useEffect(() => {
+    const onResize = () => setWidth(window.innerWidth);
+    window.addEventListener('resize', onResize);
+    return () => window.removeEventListener('resize', onResize);
+  }, []);
+  return width;
+}
+
+export const Greeting = ({ name }) => {
+  const width = useWindowWidth();
+  return (
+    <p className={width > 600 ? 'wide' : 'narrow'}>
+      Hello, {name}!
+    </p>
+  );
+};
+
+const Badge = memo(({ count }) => <span className="badge">{count}</span>);
+
+export class Profile extends React.Component {
+  render() {
+    return (
+      <section>
+        <Greeting name={this.props.name} />
+        <Badge count={this.props.count} />
+      </section>
+    );
+  }
+}
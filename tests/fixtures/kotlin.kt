@@ -0,0 +1,36 @@
+package com.example.greeting
+
+import org.springframework.web.bind.annotation.GetMapping
+import org.springframework.web.bind.annotation.RestController
+
+/**
+ * Greets the users of the API.
+ */
+@RestController
+class GreetingController(private val service: GreetingService) {
+    @GetMapping("/greeting")
+    fun greeting(name: String): String {
+        return service.greet(name)
+    }
+}
+
+/** A greeting sent to a user. */
+data class Greeting(val id: Long, val content: String)
+
+interface GreetingService {
+    fun greet(name: String): String
+}
+
+object Greetings {
+    const val DEFAULT_NAME = "World"
+}
+
+enum class Tone {
+    FORMAL,
+    CASUAL,
+}
+
+/** Shouts a text. */
+fun String.shout(): String = uppercase() + "!"
+
+private fun helper(): Int = 42
@@ -0,0 +1,32 @@
+from flask import Flask
+
+app = Flask(__name__)
+
+
+@app.route("/users/<user_id>")
+def get_user(user_id):
+    """Return a single user."""
+
+    def load(key):
+        return {"id": key}
+
+    return load(user_id)
+
+
+async def fetch_all(client):
+    """Fetch every user."""
+    return await client.get("/users")
+
+
+class UserService:
+    """Loads and caches users."""
+
+    def __init__(self, client):
+        self.client = client
+
+    @staticmethod
+    def cache_key(user_id):
+        return f"user:{user_id}"
+
+    async def refresh(self):
+        return await fetch_all(self.client)
@@ -0,0 +1,14 @@
+class Outer:
+    class Inner:
+        @property
+        def name(self): return "inner"
+
+        def build(self):
+            def step():
+                def leaf(): """Innermost helper."""
+                return leaf
+
+            return step
+
+
+def one_liner(x): return x * 2
@@ -0,0 +1,54 @@
+use std::collections::HashMap;
+use std::fmt;
+
+/// A simple key/value store.
+pub struct Store {
+    items: HashMap<String, String>,
+}
+
+/// The possible outcomes of a lookup.
+pub enum Lookup {
+    Found(String),
+    Missing,
+}
+
+/// Types that can describe themselves.
+pub trait Describe {
+    fn describe(&self) -> String;
+}
+
+impl Store {
+    /// Creates an empty store.
+    pub fn new() -> Self {
+        Store { items: HashMap::new() }
+    }
+
+    pub fn get(&self, key: &str) -> Lookup {
+        match self.items.get(key) {
+            Some(value) => Lookup::Found(value.clone()),
+            None => Lookup::Missing,
+        }
+    }
+}
+
+impl fmt::Display for Store {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        write!(f, "Store({} items)", self.items.len())
+    }
+}
+
+macro_rules! square {
+    ($x:expr) => {
+        $x * $x
+    };
+}
+
+/// Prints a greeting.
+pub fn greet(name: &str) {
+    println!("Hello, {}!", name);
+    let _ = square!(2);
+}
+
+fn private_helper() -> u32 {
+    vec![1, 2, 3].iter().sum()
+}
@@ -0,0 +1,12 @@
+export function parseAmount(value: string): number {
+  const amount = Number(value.replace(/,/g, ''));
+  return Number.isFinite(amount) ? amount : 0;
+}
+
+export function formatAmount(amount: number): string {
+  return amount.toFixed(2);
+}
+
+export function sumAmounts(values: string[]): number {
+  return values.reduce((total, value) => total + parseAmount(value), 0);
+}
@@ -0,0 +1,40 @@
+import React, { useState } from 'react';
+
+/**
+ * Props for the counter component.
+ */
+export interface CounterProps {
+  label: string;
+  initial?: number;
+}
+
+export type Theme = 'light' | 'dark';
+
+/**
+ * Renders a labelled counter button.
+ */
+export const Counter = ({ label, initial = 0 }: CounterProps) => {
+  const [count, setCount] = useState(initial);
+  return (
+    <div className="counter">
+      <span>{label}</span>
+      <button onClick={() => setCount(count + 1)}>{count}</button>
+      {count > 10 && <strong>Too many!</strong>}
+    </div>
+  );
+};
+
+export class Panel extends React.Component<{ title: string }> {
+  render() {
+    return <section title={this.props.title}>{this.props.children}</section>;
+  }
+}
+
+export function App() {
+  return (
+    <>
+      <Counter label="Clicks" />
+      <Panel title="Main" />
+    </>
+  );
+}
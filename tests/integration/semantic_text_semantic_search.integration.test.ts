@@ -1,4 +1,4 @@
-import { getClient } from '../../src/utils/elasticsearch';
+import { getClient, deleteIndex, deleteLocationsIndex } from '../../src/utils/elasticsearch';
 import { setup } from '../../src/commands/setup_command';
 import { indexRepos } from '../../src/commands/index_command';
 import { describe, it, expect, beforeAll, afterAll } from 'vitest';
@@ -36,8 +36,8 @@ describe('Integration Test - semantic_text retrievability via semantic queries',
   afterAll(async () => {
     try {
       const client = getClient();
-      await client.indices.delete({ index: TEST_INDEX });
-      await client.indices.delete({ index: `${TEST_INDEX}_locations` });
+      await deleteIndex(TEST_INDEX);
+      await deleteLocationsIndex(TEST_INDEX);
       await client.indices.delete({ index: `${TEST_INDEX}_settings` });
     } catch {
       // ignore
@@ -4,7 +4,14 @@ import path from 'path';
 import fs from 'fs';
 import Database from 'better-sqlite3';
 
-import { getClient, createIndex, createLocationsIndex, CodeChunk } from '../../src/utils/elasticsearch';
+import {
+  getClient,
+  createIndex,
+  createLocationsIndex,
+  deleteIndex,
+  deleteLocationsIndex,
+  CodeChunk,
+} from '../../src/utils/elasticsearch';
 import { SqliteQueue } from '../../src/utils/sqlite_queue';
 import { IndexerWorker } from '../../src/utils/indexer_worker';
 
@@ -60,12 +67,12 @@ describe('Integration Test - Worker drain + concurrency + stale recovery (determ
       const client = getClient();
       for (const idx of createdIndices) {
         try {
-          await client.indices.delete({ index: idx });
+          await deleteIndex(idx);
         } catch {
           // ignore
         }
         try {
-          await client.indices.delete({ index: `${idx}_locations` });
+          await deleteLocationsIndex(idx);
         } catch {
           // ignore
         }
@@ -0,0 +1,111 @@
+import { describe, it, expect } from 'vitest';
+import { AdaptiveBatchSize } from '../../src/utils/adaptive_batch_size';
+
+describe('AdaptiveBatchSize', () => {
+  it('should start at the initial size clamped to the bounds', () => {
+    expect(new AdaptiveBatchSize({ minSize: 10, maxSize: 100 }).size).toBe(100);
+    expect(new AdaptiveBatchSize({ minSize: 10, maxSize: 100, initialSize: 50 }).size).toBe(50);
+    expect(new AdaptiveBatchSize({ minSize: 10, maxSize: 100, initialSize: 500 }).size).toBe(100);
+    expect(new AdaptiveBatchSize({ minSize: 10, maxSize: 100, initialSize: 1 }).size).toBe(10);
+  });
+
+  it('should halve the size on each rejection down to the minimum', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, baseBackoffMs: 0 });
+
+    batchSize.recordRejection();
+    expect(batchSize.size).toBe(50);
+    batchSize.recordRejection();
+    expect(batchSize.size).toBe(25);
+    batchSize.recordRejection();
+    expect(batchSize.size).toBe(12);
+    batchSize.recordRejection();
+    expect(batchSize.size).toBe(10);
+  });
+
+  it('should return a growing backoff delay for consecutive rejections', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 1, maxSize: 100, baseBackoffMs: 1000, maxBackoffMs: 3000 });
+
+    const first = batchSize.recordRejection();
+    const second = batchSize.recordRejection();
+    const third = batchSize.recordRejection();
+
+    expect(first).toBeGreaterThan(0);
+    expect(first).toBeLessThanOrEqual(1000);
+    expect(second).toBeLessThanOrEqual(2000);
+    expect(third).toBeLessThanOrEqual(3000);
+  });
+
+  it('should grow back toward the maximum after sustained success', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, initialSize: 40, growthInterval: 3 });
+
+    expect(batchSize.recordSuccess()).toBe(false);
+    expect(batchSize.recordSuccess()).toBe(false);
+    expect(batchSize.recordSuccess()).toBe(true);
+    expect(batchSize.size).toBe(50);
+
+    for (let i = 0; i < 30; i++) {
+      batchSize.recordSuccess();
+    }
+    expect(batchSize.size).toBe(100);
+  });
+
+  it('should reset the success streak on rejection', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, growthInterval: 2, baseBackoffMs: 0 });
+
+    batchSize.recordSuccess();
+    batchSize.recordRejection();
+    expect(batchSize.recordSuccess()).toBe(false);
+    expect(batchSize.size).toBe(50);
+  });
+
+  it('should shrink by a quarter after a batch slower than the target latency', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, growthInterval: 2, targetLatencyMs: 1000 });
+
+    expect(batchSize.recordSuccess(500)).toBe(false);
+    expect(batchSize.recordSuccess(1500)).toBe(true);
+    expect(batchSize.size).toBe(75);
+    // The slow batch restarts the success streak.
+    expect(batchSize.recordSuccess(500)).toBe(false);
+    expect(batchSize.recordSuccess(500)).toBe(true);
+    expect(batchSize.size).toBe(93);
+
+    for (let i = 0; i < 20; i++) {
+      batchSize.recordSuccess(5000);
+    }
+    expect(batchSize.size).toBe(10);
+  });
+
+  it('should ignore latency without a target', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, initialSize: 40, growthInterval: 1 });
+
+    expect(batchSize.recordSuccess(60_000)).toBe(true);
+    expect(batchSize.size).toBe(50);
+  });
+
+  it('should return to the initial size on reset', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, initialSize: 40, baseBackoffMs: 0 });
+
+    batchSize.recordRejection();
+    batchSize.reset();
+
+    expect(batchSize.size).toBe(40);
+    expect(batchSize.takeStats()).toEqual({ size: 40, batches: 0, rejected: 0, averageLatencyMs: null });
+  });
+
+  it('should report the batches since the last stats', () => {
+    const batchSize = new AdaptiveBatchSize({ minSize: 10, maxSize: 100, baseBackoffMs: 0 });
+
+    batchSize.recordSuccess(100);
+    batchSize.recordSuccess(300);
+    batchSize.recordRejection();
+
+    expect(batchSize.takeStats()).toEqual({ size: 50, batches: 3, rejected: 1, averageLatencyMs: 200 });
+    expect(batchSize.takeStats()).toEqual({ size: 50, batches: 0, rejected: 0, averageLatencyMs: null });
+  });
+
+  it('should throw when the minimum is greater than the maximum', () => {
+    expect(() => new AdaptiveBatchSize({ minSize: 20, maxSize: 10 })).toThrow(
+      'Bulk min size (20) cannot be greater than max size (10).'
+    );
+  });
+});
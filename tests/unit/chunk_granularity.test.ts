@@ -0,0 +1,32 @@
+import { parseChunkGranularity } from '../../src/utils/chunk_granularity';
+import { describe, it, expect } from 'vitest';
+
+describe('parseChunkGranularity', () => {
+  it('should map languages to modes', () => {
+    expect(parseChunkGranularity('typescript:file, go:symbol+doc,,python:symbol')).toEqual({
+      typescript: 'file',
+      go: 'symbol+doc',
+      python: 'symbol',
+    });
+  });
+
+  it('should reject entries without a language or mode', () => {
+    expect(() => parseChunkGranularity('typescript')).toThrow(
+      'Invalid chunk granularity "typescript": expected language:mode, such as "typescript:file".'
+    );
+    expect(() => parseChunkGranularity(':file')).toThrow('expected language:mode');
+  });
+
+  it('should reject unknown languages and modes', () => {
+    expect(() => parseChunkGranularity('cobol:file')).toThrow(
+      'Invalid chunk granularity "cobol:file": unknown language "cobol".'
+    );
+    expect(() => parseChunkGranularity('go:function')).toThrow(
+      'Invalid chunk granularity "go:function": unknown mode "function". Expected one of: file, symbol, symbol+doc.'
+    );
+  });
+
+  it('should reject a language listed twice', () => {
+    expect(() => parseChunkGranularity('go:file,go:symbol')).toThrow('"go" is listed more than once');
+  });
+});
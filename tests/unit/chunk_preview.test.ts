@@ -0,0 +1,36 @@
+import { describe, it, expect } from 'vitest';
+
+import { buildChunkPreview, PREVIEW_MAX_LINE_CHARS } from '../../src/utils/chunk_preview';
+
+describe('buildChunkPreview', () => {
+  const content = 'func Greet() {\n\tname := "world"\n\tfmt.Println(name)\n\treturn\n}';
+
+  it('should keep the first lines and return nothing for 0 lines', () => {
+    expect(buildChunkPreview(content, 2)).toBe('func Greet() {\n\tname := "world"');
+    expect(buildChunkPreview(content, 10)).toBe(content);
+    expect(buildChunkPreview(content, 0)).toBeUndefined();
+  });
+
+  it('should add a signature outside of the first lines', () => {
+    const signature = { line: 'func (g Greeter) Greet() {', index: -40 };
+
+    expect(buildChunkPreview('\tfmt.Println(g.name)\n}', 1, signature)).toBe(
+      'func (g Greeter) Greet() {\n…\n\tfmt.Println(g.name)'
+    );
+    expect(buildChunkPreview(`@Override\n@Deprecated\n${content}`, 2, { line: '  func Greet() {', index: 2 })).toBe(
+      '@Override\n@Deprecated\n…\nfunc Greet() {'
+    );
+    expect(buildChunkPreview(content, 2, { line: 'func Greet() {', index: 0 })).toBe(buildChunkPreview(content, 2));
+  });
+
+  it('should cut long lines without splitting a character', () => {
+    const line = `const greeting = "${'👋'.repeat(PREVIEW_MAX_LINE_CHARS)}";`;
+    const preview = buildChunkPreview(line, 1)!;
+    const chars = Array.from(preview);
+
+    expect(chars).toHaveLength(PREVIEW_MAX_LINE_CHARS);
+    expect(chars[chars.length - 1]).toBe('…');
+    expect(chars[chars.length - 2]).toBe('👋');
+    expect(preview).not.toMatch(/[\uD800-\uDBFF](?![\uDC00-\uDFFF])/);
+  });
+});
@@ -0,0 +1,31 @@
+import { randomBytes } from 'crypto';
+import { describe, it, expect } from 'vitest';
+
+import {
+  compressContent,
+  CONTENT_EXCERPT_CHARS,
+  inflateContent,
+  storedContentBytes,
+} from '../../src/utils/content_compression';
+
+describe('compressContent', () => {
+  it('should keep an excerpt and restore the whole content', () => {
+    const content = 'func (g Greeter) Greet(name string) {\n\tfmt.Println("Hello,", name)\n}\n'.repeat(20);
+
+    const stored = compressContent(content);
+
+    expect(stored.content).toBe(content.slice(0, CONTENT_EXCERPT_CHARS));
+    expect(stored.content_gz).toEqual(expect.any(String));
+    expect(storedContentBytes(stored)).toBeLessThan(Buffer.byteLength(content));
+    expect(inflateContent(stored)).toBe(content);
+  });
+
+  it('should store content as is when compressing would not make it smaller', () => {
+    const short = 'const a = 1;';
+    const incompressible = randomBytes(600).toString('base64');
+
+    expect(compressContent(short)).toEqual({ content: short });
+    expect(compressContent(incompressible)).toEqual({ content: incompressible });
+    expect(inflateContent({ content: short })).toBe(short);
+  });
+});
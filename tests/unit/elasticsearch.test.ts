@@ -1,9 +1,13 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
 import { Client } from '@elastic/elasticsearch';
 import { beforeEach, describe, it, expect, vi, afterEach } from 'vitest';
 import type { Mock } from 'vitest';
 
 import * as elasticsearch from '../../src/utils/elasticsearch';
 import { CodeChunk } from '../../src/utils/elasticsearch';
+import { compressContent, CONTENT_EXCERPT_CHARS } from '../../src/utils/content_compression';
 import { withTestEnv } from './utils/test_env';
 
 const MOCK_CHUNK: CodeChunk = {
@@ -26,17 +30,21 @@ const MOCK_CHUNK: CodeChunk = {
 
 describe('indexCodeChunks', () => {
   let mockBulk: Mock;
+  let mockMget: Mock;
   let mockClient: Client;
 
   beforeEach(() => {
     // Create a mock client with all necessary methods
     mockBulk = vi.fn();
+    mockMget = vi.fn().mockResolvedValue({ docs: [] });
     mockClient = {
       bulk: mockBulk,
+      mget: mockMget,
       indices: {
         exists: vi.fn(),
         create: vi.fn(),
         delete: vi.fn(),
+        refresh: vi.fn(),
       },
       get: vi.fn(),
       index: vi.fn(),
@@ -166,6 +174,539 @@ describe('indexCodeChunks', () => {
     expect(result.succeeded).toHaveLength(1);
     expect(result.failed).toHaveLength(1);
   });
+
+  it('should keep the item status so rejected chunks can be detected', async () => {
+    const chunkA: CodeChunk = { ...MOCK_CHUNK, filePath: 'a.ts', startLine: 1, endLine: 1 };
+
+    mockBulk.mockResolvedValue({
+      errors: true,
+      items: [
+        {
+          create: {
+            status: 429,
+            error: { type: 'es_rejected_execution_exception', reason: 'rejected execution' },
+          },
+        },
+      ],
+    });
+
+    const result = await withTestEnv(
+      { SCS_IDXR_BULK_ITEM_MAX_RETRIES: '2', SCS_IDXR_BULK_ITEM_RETRY_BASE_DELAY_MS: '0' },
+      () => elasticsearch.indexCodeChunks([chunkA], 'test-index')
+    );
+    expect(mockBulk).toHaveBeenCalledTimes(3);
+    expect(result.retried).toBe(2);
+    expect(result.failed).toHaveLength(1);
+    expect(result.failed[0].error).toMatchObject({ type: 'es_rejected_execution_exception', status: 429 });
+    expect(elasticsearch.isRejectedExecutionError(result.failed[0].error)).toBe(true);
+  });
+
+  it('should resend only the items that failed with 429 or 503', async () => {
+    const chunkA: CodeChunk = { ...MOCK_CHUNK, content: 'const a = 1;', filePath: 'a.ts' };
+    const chunkB: CodeChunk = { ...MOCK_CHUNK, content: 'const b = 2;', filePath: 'b.ts' };
+    const chunkC: CodeChunk = { ...MOCK_CHUNK, content: 'const c = 3;', filePath: 'c.ts' };
+
+    mockBulk
+      .mockResolvedValueOnce({
+        errors: true,
+        items: [
+          { create: { status: 201 } },
+          { create: { status: 429, error: { type: 'es_rejected_execution_exception', reason: 'rejected' } } },
+          { create: { status: 503, error: { type: 'unavailable_shards_exception', reason: 'primary not active' } } },
+        ],
+      })
+      .mockResolvedValueOnce({ errors: false, items: [{ create: { status: 201 } }, { create: { status: 201 } }] })
+      .mockResolvedValueOnce({ errors: false, items: [1, 2, 3].map(() => ({ index: { status: 201 } })) });
+
+    const result = await withTestEnv({ SCS_IDXR_BULK_ITEM_RETRY_BASE_DELAY_MS: '0' }, () =>
+      elasticsearch.indexCodeChunks([chunkA, chunkB, chunkC], 'test-index')
+    );
+
+    expect(result.succeeded).toHaveLength(3);
+    expect(result.failed).toHaveLength(0);
+    expect(result.retried).toBe(2);
+    const retryOps = (mockBulk.mock.calls[1]?.[0] as { operations: unknown[] }).operations;
+    expect(retryOps).toHaveLength(4);
+    expect(retryOps[1]).toMatchObject({ content: 'const b = 2;' });
+    expect(retryOps[3]).toMatchObject({ content: 'const c = 3;' });
+  });
+
+  it('should not retry mapping errors and report the document id and reason', async () => {
+    const chunkA: CodeChunk = { ...MOCK_CHUNK, filePath: 'a.ts', startLine: 1, endLine: 1 };
+
+    mockBulk.mockResolvedValueOnce({
+      errors: true,
+      items: [{ create: { status: 400, error: { type: 'mapper_parsing_exception', reason: 'bad field' } } }],
+    });
+
+    const result = await elasticsearch.indexCodeChunks([chunkA], 'test-index');
+
+    expect(mockBulk).toHaveBeenCalledTimes(1);
+    expect(result.retried).toBe(0);
+    expect(result.failed[0].error).toMatchObject({
+      type: 'mapper_parsing_exception',
+      reason: 'bad field',
+      status: 400,
+      documentId: expect.stringMatching(/^[0-9a-f]{64}$/),
+    });
+  });
+
+  it('should fail only the retried items when a retry request throws', async () => {
+    const chunkA: CodeChunk = { ...MOCK_CHUNK, content: 'const a = 1;', filePath: 'a.ts' };
+    const chunkB: CodeChunk = { ...MOCK_CHUNK, content: 'const b = 2;', filePath: 'b.ts' };
+
+    mockBulk
+      .mockResolvedValueOnce({
+        errors: true,
+        items: [{ create: { status: 201 } }, { create: { status: 429, error: { type: 'rejected' } } }],
+      })
+      .mockRejectedValueOnce(new Error('socket hang up'))
+      .mockResolvedValueOnce({ errors: false, items: [{ index: { status: 201 } }] });
+
+    const result = await withTestEnv({ SCS_IDXR_BULK_ITEM_RETRY_BASE_DELAY_MS: '0' }, () =>
+      elasticsearch.indexCodeChunks([chunkA, chunkB], 'test-index')
+    );
+
+    expect(result.succeeded.map((s) => s.inputIndex)).toEqual([0]);
+    expect(result.failed.map((f) => f.inputIndex)).toEqual([1]);
+    expect(result.failed[0].error).toMatchObject({ message: 'socket hang up' });
+  });
+
+  it('should store doc_comment on the chunk doc and include it in the chunk id', async () => {
+    const plain: CodeChunk = { ...MOCK_CHUNK, filePath: 'a.ts', startLine: 1, endLine: 1 };
+    const documented: CodeChunk = { ...plain, doc_comment: '/** Says hello. */' };
+
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await elasticsearch.indexCodeChunks([plain], 'test-index');
+    await elasticsearch.indexCodeChunks([documented], 'test-index');
+
+    const plainOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    const documentedOps = (mockBulk.mock.calls[2]?.[0] as { operations: unknown[] }).operations;
+    const plainId = (plainOps[0] as { create: { _id: string } }).create._id;
+    const documentedId = (documentedOps[0] as { create: { _id: string } }).create._id;
+
+    expect((plainOps[1] as Record<string, unknown>).doc_comment).toBeUndefined();
+    expect((documentedOps[1] as Record<string, unknown>).doc_comment).toBe('/** Says hello. */');
+    expect(documentedId).not.toBe(plainId);
+  });
+
+  it('should store references and symbol_fqn on the chunk doc', async () => {
+    const mainPkg: CodeChunk = {
+      ...MOCK_CHUNK,
+      symbol_fqn: 'main.greet',
+      references: [{ name: 'Println', kind: 'method', receiver: 'fmt' }],
+    };
+    const otherPkg: CodeChunk = { ...mainPkg, symbol_fqn: 'util.greet' };
+
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await elasticsearch.indexCodeChunks([mainPkg, otherPkg], 'test-index');
+
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps).toHaveLength(4);
+    expect(chunkOps[1]).toMatchObject({
+      symbol_fqn: 'main.greet',
+      references: [{ name: 'Println', kind: 'method', receiver: 'fmt' }],
+    });
+    expect((chunkOps[3] as Record<string, unknown>).symbol_fqn).toBe('util.greet');
+  });
+
+  it('should keep identical chunks of different repositories apart and tag their locations', async () => {
+    const repoA: CodeChunk = { ...MOCK_CHUNK, repo_name: 'a', repo_root: '/src/a', commit_sha: 'sha-a' };
+    const repoB: CodeChunk = { ...MOCK_CHUNK, repo_name: 'b', repo_root: '/src/b', commit_sha: 'sha-b' };
+
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await elasticsearch.indexCodeChunks([repoA, repoB], 'test-index');
+
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    const locationOps = (mockBulk.mock.calls[1]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps).toHaveLength(4);
+    expect(chunkOps[1]).toMatchObject({ repo_name: 'a', repo_root: '/src/a' });
+    expect(chunkOps[1]).not.toHaveProperty('commit_sha');
+    expect(locationOps).toHaveLength(4);
+    expect(locationOps[1]).toMatchObject({ filePath: 'test.ts', repo_name: 'a', commit_sha: 'sha-a' });
+    expect(locationOps[3]).toMatchObject({ filePath: 'test.ts', repo_name: 'b', commit_sha: 'sha-b' });
+    const locationIds = [locationOps[0], locationOps[2]].map((op) => (op as { index: { _id: string } }).index._id);
+    expect(locationIds[0]).not.toBe(locationIds[1]);
+  });
+
+  it('should stamp the repository URL on chunks and locations and its ref on locations only', async () => {
+    const chunk: CodeChunk = {
+      ...MOCK_CHUNK,
+      repo_name: 'a',
+      repo_url: 'https://github.com/org/a',
+      repo_ref: 'v1.0.0',
+    };
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await elasticsearch.indexCodeChunks([chunk], 'test-index');
+
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    const locationOps = (mockBulk.mock.calls[1]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps[1]).toMatchObject({ repo_url: 'https://github.com/org/a' });
+    expect(chunkOps[1]).not.toHaveProperty('repo_ref');
+    expect(locationOps[1]).toMatchObject({ repo_url: 'https://github.com/org/a', repo_ref: 'v1.0.0' });
+  });
+
+  it('should store vectors from the embedding provider and skip the ingest pipeline', async () => {
+    const chunkA: CodeChunk = { ...MOCK_CHUNK, content: 'const a = 1;', filePath: 'a.ts' };
+    const chunkB: CodeChunk = { ...MOCK_CHUNK, content: 'const b = 2;', filePath: 'b.ts' };
+    const embed = vi.fn(async (texts: string[]) => texts.map((_, i) => [i, i + 0.5]));
+
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await withTestEnv({ SCS_IDXR_ENABLE_DENSE_VECTORS: 'true' }, () =>
+      elasticsearch.indexCodeChunks([chunkA, chunkB], 'test-index', { embeddingProvider: { dimensions: 2, embed } })
+    );
+
+    expect(embed).toHaveBeenCalledWith(['const a = 1;', 'const b = 2;']);
+    const chunkRequest = mockBulk.mock.calls[0]?.[0] as { operations: unknown[]; pipeline?: string };
+    expect(chunkRequest.pipeline).toBeUndefined();
+    expect(chunkRequest.operations[1]).toMatchObject({ content: 'const a = 1;', code_vector: [0, 0.5] });
+    expect(chunkRequest.operations[3]).toMatchObject({ content: 'const b = 2;', code_vector: [1, 1.5] });
+  });
+
+  it('should embed the normalized text but store the original content', async () => {
+    const content = '// Adds one.\nexport const Inc = (n: number) =>\n  n + 1;';
+    const chunk: CodeChunk = {
+      ...MOCK_CHUNK,
+      content,
+      semantic_text: content,
+      scope_path: 'Inc',
+      symbol_kind: 'variable',
+    };
+    const embed = vi.fn(async (texts: string[]) => texts.map(() => [0.1, 0.2]));
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await withTestEnv(
+      {
+        SCS_IDXR_EMBED_STRIP_COMMENTS: 'true',
+        SCS_IDXR_EMBED_COLLAPSE_WHITESPACE: 'true',
+        SCS_IDXR_EMBED_LOWERCASE: 'true',
+        SCS_IDXR_EMBED_HEADER_TEMPLATE: '// file: {path}\\n// symbol: {name} ({kind})\\n',
+      },
+      () => elasticsearch.indexCodeChunks([chunk], 'test-index', { embeddingProvider: { dimensions: 2, embed } })
+    );
+
+    const embedded = '// file: test.ts\n// symbol: Inc (variable)\nexport const inc = (n: number) => n + 1;';
+    expect(embed).toHaveBeenCalledWith([embedded]);
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps[1]).toMatchObject({ content, semantic_text: embedded });
+  });
+
+  it('should strip license headers and comments from the embedded text only, keeping doc_comment', async () => {
+    const content =
+      '/*\n * Copyright 2024 Example Inc.\n * Licensed under the Apache License 2.0.\n */\n// Counter.\nlet count = 0;';
+    const chunk: CodeChunk = { ...MOCK_CHUNK, content, semantic_text: content, doc_comment: '/** Counts calls. */' };
+    const embed = vi.fn(async (texts: string[]) => texts.map(() => [0.1, 0.2]));
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    await withTestEnv({ SCS_IDXR_EMBED_STRIP_LICENSE: 'true', SCS_IDXR_EMBED_STRIP_COMMENTS: 'true' }, () =>
+      elasticsearch.indexCodeChunks([chunk], 'test-index', { embeddingProvider: { dimensions: 2, embed } })
+    );
+
+    expect(embed).toHaveBeenCalledWith(['\nlet count = 0;']);
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps[1]).toMatchObject({ content, doc_comment: '/** Counts calls. */' });
+  });
+
+  it('should embed in prepareCodeChunks and report an embedding failure from the bulk stage', async () => {
+    const embed = vi.fn(async () => {
+      throw new Error('embedding endpoint unavailable');
+    });
+
+    const prepared = await elasticsearch.prepareCodeChunks([MOCK_CHUNK], 'test-index', {
+      embeddingProvider: { dimensions: 2, embed },
+    });
+    expect(embed).toHaveBeenCalledTimes(1);
+    expect(mockBulk).not.toHaveBeenCalled();
+
+    const result = await elasticsearch.bulkIndexPreparedChunks(prepared);
+
+    expect(mockBulk).not.toHaveBeenCalled();
+    expect(result.succeeded).toHaveLength(0);
+    expect(result.failed).toEqual([
+      { chunk: MOCK_CHUNK, inputIndex: 0, error: { message: 'embedding endpoint unavailable' } },
+    ]);
+  });
+
+  it('should neither embed nor create chunk docs that already exist', async () => {
+    const shared: CodeChunk = { ...MOCK_CHUNK, content: 'const a = 1;', filePath: 'a.ts' };
+    const copy: CodeChunk = { ...shared, filePath: 'vendor/a.ts', startLine: 7, endLine: 7 };
+    const fresh: CodeChunk = { ...MOCK_CHUNK, content: 'const c = 3;', filePath: 'c.ts' };
+    const sharedId = elasticsearch.getChunkDocumentId(shared);
+    const embed = vi.fn(async (texts: string[]) => texts.map(() => [0.1, 0.2]));
+
+    mockMget.mockResolvedValueOnce({ docs: [{ _id: sharedId, found: true }, { _id: 'other', found: false }] });
+    mockBulk
+      .mockResolvedValueOnce({ errors: false, items: [{ create: { status: 201 } }] })
+      .mockResolvedValueOnce({ errors: false, items: [1, 2, 3].map(() => ({ index: { status: 201 } })) });
+
+    const result = await elasticsearch.indexCodeChunks([shared, copy, fresh], 'test-index', {
+      embeddingProvider: { dimensions: 2, embed },
+    });
+
+    expect(mockMget).toHaveBeenCalledWith(expect.objectContaining({ index: 'test-index', _source: false }));
+    expect(embed).toHaveBeenCalledWith(['const c = 3;']);
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps).toHaveLength(2);
+    expect(chunkOps[1]).toMatchObject({ content: 'const c = 3;' });
+    const locationOps = (mockBulk.mock.calls[1]?.[0] as { operations: unknown[] }).operations;
+    expect(locationOps.filter((_, i) => i % 2 === 1).map((op) => (op as { chunk_id: string }).chunk_id)).toEqual([
+      sharedId,
+      sharedId,
+      elasticsearch.getChunkDocumentId(fresh),
+    ]);
+    expect(result.succeeded).toHaveLength(3);
+    expect(result.deduplicated).toBe(2);
+  });
+
+  it('should version locations by the read of their file and keep newer ones on conflict', async () => {
+    const stale: CodeChunk = { ...MOCK_CHUNK, filePath: 'a.ts', source_version: 1_000 };
+    const reread: CodeChunk = { ...stale, source_version: 3_000 };
+    const other: CodeChunk = { ...MOCK_CHUNK, filePath: 'b.ts', source_version: 2_000 };
+    mockBulk
+      .mockResolvedValueOnce({ errors: false, items: [{ create: { status: 201 } }] })
+      .mockResolvedValueOnce({
+        errors: true,
+        items: [
+          { index: { status: 201 } },
+          { index: { status: 409, error: { type: 'version_conflict_engine_exception', reason: 'newer version' } } },
+        ],
+      });
+
+    const result = await elasticsearch.indexCodeChunks([stale, other, reread], 'test-index');
+
+    const locationOps = (mockBulk.mock.calls[1]?.[0] as { operations: Array<Record<string, unknown>> }).operations;
+    // The location read twice in the batch is written once, from its later read.
+    expect(locationOps.filter((_, i) => i % 2 === 0)).toEqual([
+      { index: expect.objectContaining({ version: 3_000, version_type: 'external_gte' }) },
+      { index: expect.objectContaining({ version: 2_000, version_type: 'external_gte' }) },
+    ]);
+    expect(result.succeeded).toHaveLength(3);
+    expect(result.failed).toHaveLength(0);
+    expect(result.versionConflicts).toBe(1);
+  });
+
+  it('should give copies that differ only in line endings or trailing whitespace the same chunk id', () => {
+    const clean: CodeChunk = { ...MOCK_CHUNK, content: 'function a() {\n  return 1;\n}' };
+    const crlf: CodeChunk = { ...clean, content: 'function a() {  \r\n  return 1;\t\r\n}' };
+    const changed: CodeChunk = { ...clean, content: 'function a() {\n  return 2;\n}' };
+
+    expect(elasticsearch.getChunkDocumentId(crlf)).toBe(elasticsearch.getChunkDocumentId(clean));
+    expect(elasticsearch.getChunkDocumentId(changed)).not.toBe(elasticsearch.getChunkDocumentId(clean));
+  });
+
+  it('should give identical chunks with relative imports of different files separate chunk ids', () => {
+    const base: CodeChunk = { ...MOCK_CHUNK, content: "import { a } from './a';" };
+    const inSrc: CodeChunk = { ...base, imports: [{ path: 'src/a', type: 'file', symbols: ['a'] }] };
+    const inLib: CodeChunk = { ...base, imports: [{ path: 'lib/a', type: 'file', symbols: ['a'] }] };
+    const external: CodeChunk = { ...base, imports: [{ path: 'a', type: 'module', symbols: ['a'] }] };
+
+    expect(elasticsearch.getChunkDocumentId(inSrc)).not.toBe(elasticsearch.getChunkDocumentId(inLib));
+    expect(elasticsearch.getChunkDocumentId(external)).toBe(elasticsearch.getChunkDocumentId(base));
+  });
+
+  it('should keep chunks of other packages or with other embedded context apart', () => {
+    const base: CodeChunk = { ...MOCK_CHUNK, language: 'go', content: 'return nil' };
+    const inMain: CodeChunk = { ...base, package_name: 'main' };
+    const inUtil: CodeChunk = { ...base, package_name: 'util' };
+    const qualified: CodeChunk = { ...inMain, symbol_fqn: 'main.Run' };
+    const withContext: CodeChunk = { ...inMain, embedded_context: 'package: main\nimports: fmt' };
+
+    expect(elasticsearch.getChunkDocumentId(inMain)).not.toBe(elasticsearch.getChunkDocumentId(inUtil));
+    expect(elasticsearch.getChunkDocumentId(qualified)).toBe(
+      elasticsearch.getChunkDocumentId({ ...base, symbol_fqn: 'main.Run' })
+    );
+    expect(elasticsearch.getChunkDocumentId(withContext)).not.toBe(elasticsearch.getChunkDocumentId(inMain));
+  });
+
+  it('should store package and receiver on chunk docs and file imports on locations', () => {
+    const chunk: CodeChunk = {
+      ...MOCK_CHUNK,
+      package_name: 'main',
+      receiver_type: 'Greeter',
+      file_imports: ['fmt'],
+      embedded_context: 'package: main',
+    };
+
+    const chunkDoc = elasticsearch.buildChunkDocument(chunk, 'now');
+    const locationDoc = elasticsearch.buildLocationDocument(chunk, 'chunk-id', 'now');
+
+    expect(chunkDoc).toMatchObject({ package_name: 'main', receiver_type: 'Greeter' });
+    expect(chunkDoc).not.toHaveProperty('file_imports');
+    expect(chunkDoc).not.toHaveProperty('embedded_context');
+    expect(locationDoc).toMatchObject({ file_imports: ['fmt'] });
+  });
+
+  it('should store the token count on chunk docs only', () => {
+    const chunk: CodeChunk = { ...MOCK_CHUNK, token_count: 42 };
+
+    expect(elasticsearch.buildChunkDocument(chunk, 'now')).toMatchObject({ token_count: 42 });
+    expect(elasticsearch.buildLocationDocument(chunk, 'chunk-id', 'now')).not.toHaveProperty('token_count');
+  });
+
+  it('should store blame fields on chunk docs and locations, keeping nulls of untracked files', () => {
+    const blamed: CodeChunk = { ...MOCK_CHUNK, last_modified: '2025-06-01T12:00:00.000Z', last_author: 'Bo' };
+    const untracked: CodeChunk = { ...MOCK_CHUNK, last_modified: null, last_author: null };
+
+    expect(elasticsearch.buildChunkDocument(blamed, 'now')).toMatchObject({
+      last_modified: '2025-06-01T12:00:00.000Z',
+      last_author: 'Bo',
+    });
+    expect(elasticsearch.buildLocationDocument(untracked, 'chunk-id', 'now')).toMatchObject({
+      last_modified: null,
+      last_author: null,
+    });
+    expect(elasticsearch.buildLocationDocument(MOCK_CHUNK, 'chunk-id', 'now')).not.toHaveProperty('last_modified');
+  });
+
+  it('should store long content compressed and report the stored size', async () => {
+    const content = 'export const value = compute();\n'.repeat(40);
+    const chunk: CodeChunk = { ...MOCK_CHUNK, content };
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    const result = await elasticsearch.indexCodeChunks([chunk], 'test-index', { storeCompressed: true });
+
+    const chunkDoc = (mockBulk.mock.calls[0]?.[0] as { operations: Array<Record<string, unknown>> }).operations[1];
+    expect(chunkDoc.content).toBe(content.slice(0, CONTENT_EXCERPT_CHARS));
+    expect(chunkDoc.content_gz).toEqual(expect.any(String));
+    expect(result.contentBytes).toBe(content.length);
+    expect(result.storedContentBytes).toBeLessThan(content.length);
+  });
+
+  it('should store chunk docs without content when storeContent is false', () => {
+    const chunkDoc = elasticsearch.buildChunkDocument(MOCK_CHUNK, 'now', undefined, { storeContent: false });
+
+    expect(chunkDoc).not.toHaveProperty('content');
+    expect(chunkDoc).not.toHaveProperty('content_gz');
+    expect(chunkDoc).toMatchObject({ chunk_hash: MOCK_CHUNK.chunk_hash, language: MOCK_CHUNK.language });
+  });
+
+  it('should keep a chunk doc per file without dedup', async () => {
+    const chunkA: CodeChunk = { ...MOCK_CHUNK, filePath: 'a.ts' };
+    const chunkB: CodeChunk = { ...MOCK_CHUNK, filePath: 'b.ts' };
+
+    mockBulk.mockImplementation(async ({ operations }: { operations: unknown[] }) => ({
+      errors: false,
+      items: operations.filter((_, i) => i % 2 === 0).map(() => ({ create: { status: 201 } })),
+    }));
+
+    const result = await elasticsearch.indexCodeChunks([chunkA, chunkB], 'test-index', { dedup: false });
+
+    expect(mockMget).not.toHaveBeenCalled();
+    const chunkOps = (mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations;
+    expect(chunkOps).toHaveLength(4);
+    expect(result.deduplicated).toBe(0);
+  });
+
+  it('should fail every chunk without indexing when the embedding provider throws', async () => {
+    const embed = vi.fn(async () => {
+      throw new Error('connect ECONNREFUSED');
+    });
+
+    const result = await elasticsearch.indexCodeChunks([MOCK_CHUNK], 'test-index', {
+      embeddingProvider: { dimensions: 2, embed },
+    });
+
+    expect(mockBulk).not.toHaveBeenCalled();
+    expect(result.succeeded).toHaveLength(0);
+    expect(result.failed).toHaveLength(1);
+    expect(result.failed[0].error).toMatchObject({ message: 'connect ECONNREFUSED' });
+  });
+
+  it('should key the location of a symbol on its repository, path, kind and qualified name', () => {
+    const method: CodeChunk = { ...MOCK_CHUNK, kind: 'method_definition', symbol_fqn: 'Cart.total', repo_name: 'shop' };
+    const locationId = elasticsearch.getLocationDocumentId(method);
+
+    const edited: CodeChunk = { ...method, content: 'total() { return 2; }', startLine: 4, endLine: 6 };
+    expect(elasticsearch.getLocationDocumentId(edited)).toBe(locationId);
+    expect(elasticsearch.getLocationDocumentId({ ...method, symbol_occurrence: 2 })).not.toBe(locationId);
+    expect(elasticsearch.getLocationDocumentId({ ...method, filePath: 'other.ts' })).not.toBe(locationId);
+    expect(elasticsearch.getLocationDocumentId({ ...method, repo_name: 'admin' })).not.toBe(locationId);
+    expect(elasticsearch.getLocationDocumentId({ ...MOCK_CHUNK, startLine: 2, endLine: 2 })).not.toBe(
+      elasticsearch.getLocationDocumentId(MOCK_CHUNK)
+    );
+  });
+
+  it('should report the chunk doc an overwritten symbol location referenced before without deleting it', async () => {
+    const method: CodeChunk = { ...MOCK_CHUNK, kind: 'method_definition', symbol_fqn: 'Cart.total' };
+    const locationId = elasticsearch.getLocationDocumentId(method);
+    const previous = { _id: locationId, found: true, _source: { chunk_id: 'old-chunk' } };
+    mockMget.mockImplementation(async ({ _source }: { _source: unknown }) => ({
+      docs: _source === false ? [] : [previous],
+    }));
+    mockBulk
+      .mockResolvedValueOnce({ errors: false, items: [{ create: { status: 201 } }] })
+      .mockResolvedValueOnce({ errors: false, items: [{ index: { status: 201 } }] });
+
+    const result = await elasticsearch.indexCodeChunks([method], 'test-index');
+
+    expect(result.succeeded).toHaveLength(1);
+    expect(result.replacedChunkIds).toEqual(['old-chunk']);
+    const locationOps = (mockBulk.mock.calls[1]?.[0] as { operations: unknown[] }).operations;
+    expect(locationOps[0]).toMatchObject({ index: { _index: 'test-index_locations', _id: locationId } });
+    // Another worker may still reference it, so it is only deleted once all workers are done.
+    expect(mockBulk).toHaveBeenCalledTimes(2);
+    expect(mockClient.indices.refresh).not.toHaveBeenCalled();
+  });
+
+  it('should delete the replaced chunk docs no location references after refreshing the locations', async () => {
+    (mockClient.search as Mock).mockResolvedValue({
+      aggregations: { present: { buckets: [{ key: 'reused-chunk' }] } },
+    });
+    mockBulk.mockResolvedValueOnce({ errors: false, items: [{ delete: { status: 200 } }] });
+
+    await elasticsearch.deleteUnreferencedChunks(['old-chunk', 'reused-chunk'], 'test-index');
+
+    expect(mockClient.indices.refresh).toHaveBeenCalledWith({ index: 'test-index_locations' });
+    expect((mockBulk.mock.calls[0]?.[0] as { operations: unknown[] }).operations).toEqual([
+      { delete: { _index: 'test-index', _id: 'old-chunk' } },
+    ]);
+  });
+});
+
+describe('isRejectedExecutionError', () => {
+  it('should detect 429 statuses', () => {
+    expect(elasticsearch.isRejectedExecutionError({ status: 429 })).toBe(true);
+    expect(elasticsearch.isRejectedExecutionError({ meta: { statusCode: 429 } })).toBe(true);
+  });
+
+  it('should detect es_rejected_execution_exception errors', () => {
+    expect(elasticsearch.isRejectedExecutionError({ type: 'es_rejected_execution_exception' })).toBe(true);
+  });
+
+  it('should not treat other errors as rejections', () => {
+    expect(elasticsearch.isRejectedExecutionError({ type: 'mapper_parsing_exception', status: 400 })).toBe(false);
+    expect(elasticsearch.isRejectedExecutionError(new Error('network down'))).toBe(false);
+    expect(elasticsearch.isRejectedExecutionError(null)).toBe(false);
+  });
 });
 
 describe('deleteDocumentsByFilePath', () => {
@@ -246,6 +787,29 @@ describe('deleteDocumentsByFilePath', () => {
     const secondBulkArgs = mockBulk.mock.calls[1]?.[0] as { operations: unknown[] };
     expect(secondBulkArgs.operations).toEqual([{ delete: { _index: 'idx', _id: 'chunk-1' } }]);
   });
+
+  it('should only delete locations of re-indexed files that are older than the run', async () => {
+    mockIndicesExists.mockResolvedValue(true);
+    mockOpenPit.mockResolvedValue({ id: 'pit-1' });
+    mockSearch
+      .mockResolvedValueOnce({ hits: { hits: [{ _id: 'loc-old', sort: [1], _source: { chunk_id: 'chunk-1' } }] } })
+      .mockResolvedValueOnce({ hits: { hits: [] } })
+      // chunk-1 is still used by another location, so the chunk document stays.
+      .mockResolvedValueOnce({ aggregations: { present: { buckets: [{ key: 'chunk-1' }] } } });
+    mockBulk.mockResolvedValueOnce({ errors: false, items: [{ delete: { status: 200 } }] });
+    const indexedBefore = Date.parse('2025-06-01T12:00:00.000Z');
+
+    await elasticsearch.deleteStaleLocations(['a.ts', 'a.ts'], 'idx', { indexedBefore, repoName: 'repo' });
+
+    const scan = mockSearch.mock.calls[0]?.[0] as { query: { bool: Record<string, unknown> } };
+    expect(scan.query.bool.should).toEqual([{ terms: { filePath: ['a.ts'] } }]);
+    expect(scan.query.bool.filter).toEqual([
+      expect.objectContaining({ bool: expect.objectContaining({ minimum_should_match: 1 }) }),
+      { range: { updated_at: { lt: '2025-06-01T12:00:00.000Z' } } },
+    ]);
+    expect(mockBulk).toHaveBeenCalledTimes(1);
+    expect(mockBulk.mock.calls[0]?.[0].operations).toEqual([{ delete: { _index: 'idx_locations', _id: 'loc-old' } }]);
+  });
 });
 
 describe('Elasticsearch Client Configuration', () => {
@@ -264,6 +828,50 @@ describe('Elasticsearch Client Configuration', () => {
     });
   });
 
+  describe('WHEN building the client options', () => {
+    const connectionEnv = {
+      ELASTICSEARCH_ENDPOINT: 'https://localhost:9200',
+      ELASTICSEARCH_CLOUD_ID: undefined,
+      ELASTICSEARCH_API_KEY: 'api-key',
+      ELASTICSEARCH_CA_CERT: undefined,
+      ELASTICSEARCH_INSECURE: undefined,
+    };
+
+    it('SHOULD read the CA certificate and skip verification only when asked', () => {
+      const caCert = path.join(os.tmpdir(), `es-ca-${process.pid}-${Date.now()}.pem`);
+      fs.writeFileSync(caCert, 'PEM');
+      return withTestEnv({ ...connectionEnv, ELASTICSEARCH_CA_CERT: caCert }, () => {
+        try {
+          expect(elasticsearch.getClientOptions()).toMatchObject({
+            node: 'https://localhost:9200',
+            auth: { apiKey: 'api-key' },
+            tls: { ca: Buffer.from('PEM') },
+          });
+          expect(elasticsearch.getClientOptions().tls).not.toHaveProperty('rejectUnauthorized');
+          process.env.ELASTICSEARCH_INSECURE = 'true';
+          expect(elasticsearch.getClientOptions().tls).toMatchObject({ rejectUnauthorized: false });
+        } finally {
+          fs.unlinkSync(caCert);
+        }
+      });
+    });
+
+    it('SHOULD fail with the path of a CA certificate that cannot be read', () =>
+      withTestEnv({ ...connectionEnv, ELASTICSEARCH_CA_CERT: '/missing/ca.pem' }, () => {
+        expect(() => elasticsearch.getClientOptions()).toThrow(
+          'Could not read the Elasticsearch CA certificate /missing/ca.pem'
+        );
+      }));
+
+    it('SHOULD prefer the Cloud ID and require credentials for it', () =>
+      withTestEnv({ ...connectionEnv, ELASTICSEARCH_CLOUD_ID: 'deployment:ZXhhbXBsZQ==' }, () => {
+        expect(elasticsearch.getClientOptions()).toMatchObject({ cloud: { id: 'deployment:ZXhhbXBsZQ==' } });
+        expect(elasticsearch.getClientOptions()).not.toHaveProperty('node');
+        process.env.ELASTICSEARCH_API_KEY = '';
+        expect(() => elasticsearch.getClientOptions()).toThrow('Elasticsearch Cloud authentication not configured');
+      }));
+  });
+
   describe('WHEN using elasticsearchConfig', () => {
     it('SHOULD export elasticsearchConfig', () => {
       expect(elasticsearch.elasticsearchConfig).toBeDefined();
@@ -309,74 +917,984 @@ describe('Elasticsearch Client Configuration', () => {
   });
 });
 
-describe('indexHasSemanticTextField', () => {
-  let mockGetMapping: Mock;
-  let mockClient: Client;
+describe('createIndex', () => {
+  afterEach(() => {
+    elasticsearch.setClient(undefined);
+  });
 
-  beforeEach(() => {
-    mockGetMapping = vi.fn();
-    mockClient = {
+  function setIndicesClient(existingDims: number): { create: Mock; putMapping: Mock } {
+    const create = vi.fn();
+    const putMapping = vi.fn();
+    elasticsearch.setClient({
       indices: {
-        getMapping: mockGetMapping,
+        exists: vi.fn().mockResolvedValue(existingDims > 0),
+        create,
+        putMapping,
+        getMapping: vi.fn().mockResolvedValue({
+          'test-index': { mappings: { properties: { code_vector: { type: 'dense_vector', dims: existingDims } } } },
+        }),
       },
-    } as unknown as Client;
+    } as unknown as Client);
+    return { create, putMapping };
+  }
 
-    elasticsearch.setClient(mockClient);
-  });
+  it('should map code_vector with the dimensions of the embedding provider', () =>
+    withTestEnv({ SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true' }, async () => {
+      const { create } = setIndicesClient(0);
 
-  afterEach(() => {
-    vi.clearAllMocks();
-    elasticsearch.setClient(undefined);
-  });
+      await elasticsearch.createIndex('test-index', { vectorDims: 1536 });
 
-  it('should return true when semantic_text mapping exists', async () => {
-    mockGetMapping.mockResolvedValue({
-      'test-index': {
-        mappings: {
-          properties: {
-            semantic_text: { type: 'semantic_text' },
-          },
-        },
-      },
-    });
+      const request = create.mock.calls[0]?.[0] as { mappings: { properties: { code_vector: { dims: number } } } };
+      expect(request.mappings.properties.code_vector.dims).toBe(1536);
+    }));
 
-    await expect(elasticsearch.indexHasSemanticTextField('test-index')).resolves.toBe(true);
-  });
+  it('should fail when an existing index maps different dimensions', () =>
+    withTestEnv({ SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true' }, async () => {
+      setIndicesClient(768);
 
-  it('should return false when semantic_text mapping does not exist', async () => {
-    mockGetMapping.mockResolvedValue({
-      'test-index': {
-        mappings: {
-          properties: {
-            content: { type: 'text' },
-          },
-        },
-      },
-    });
+      await expect(elasticsearch.createIndex('test-index', { vectorDims: 1536 })).rejects.toThrow(
+        'Index "test-index" maps code_vector with 768 dimensions, but the embedding provider produces 1536.'
+      );
+      await expect(elasticsearch.createIndex('test-index', { vectorDims: 768 })).resolves.toBeUndefined();
+    }));
 
-    await expect(elasticsearch.indexHasSemanticTextField('test-index')).resolves.toBe(false);
-  });
+  it('should map symbol_kind as a keyword on an existing index', () =>
+    withTestEnv({ SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true' }, async () => {
+      const { create, putMapping } = setIndicesClient(768);
 
-  it('should return false when semantic_text exists with wrong type', async () => {
-    mockGetMapping.mockResolvedValue({
-      'test-index': {
-        mappings: {
-          properties: {
-            semantic_text: { type: 'text' },
-          },
+      await elasticsearch.createIndex('test-index', { vectorDims: 768 });
+
+      expect(create).not.toHaveBeenCalled();
+      expect(putMapping).toHaveBeenCalledWith({
+        index: 'test-index',
+        properties: {
+          symbol_kind: { type: 'keyword' },
+          parent_symbol: { type: 'keyword' },
+          scope_path: { type: 'keyword', fields: { text: { type: 'text', analyzer: 'code_analyzer' } } },
+          preview: { type: 'text', index: false },
+          last_modified: { type: 'date' },
+          last_author: { type: 'keyword' },
         },
+      });
+    }));
+
+  it('should check the pipeline dimensions when dense vectors are enabled without a provider', () =>
+    withTestEnv(
+      {
+        SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true',
+        SCS_IDXR_ENABLE_DENSE_VECTORS: 'true',
+        SCS_IDXR_DENSE_VECTOR_DIMS: '384',
       },
-    });
+      async () => {
+        setIndicesClient(768);
 
-    await expect(elasticsearch.indexHasSemanticTextField('test-index')).resolves.toBe(false);
-  });
+        await expect(elasticsearch.createIndex('test-index')).rejects.toThrow(
+          'Index "test-index" maps code_vector with 768 dimensions, but SCS_IDXR_DENSE_VECTOR_DIMS is set to 384.'
+        );
+      }
+    ));
 
-  it('should throw a friendly error when index does not exist', async () => {
-    const error = Object.assign(new Error('Not Found'), { meta: { statusCode: 404 } });
-    mockGetMapping.mockRejectedValue(error);
+  it('should map code_vector with the configured dimensions and similarity', () =>
+    withTestEnv(
+      {
+        SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true',
+        SCS_IDXR_DENSE_VECTOR_DIMS: '1024',
+        SCS_IDXR_DENSE_VECTOR_SIMILARITY: 'dot_product',
+      },
+      async () => {
+        const { create } = setIndicesClient(0);
+
+        await elasticsearch.createIndex('test-index');
+
+        const request = create.mock.calls[0]?.[0] as { mappings: { properties: { code_vector: unknown } } };
+        expect(request.mappings.properties.code_vector).toMatchObject({ dims: 1024, similarity: 'dot_product' });
+      }
+    ));
+
+  it('should fail when an existing index maps another similarity', () =>
+    withTestEnv(
+      { SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true', SCS_IDXR_DENSE_VECTOR_SIMILARITY: 'dot_product' },
+      async () => {
+        setIndicesClient(768);
+
+        await expect(elasticsearch.createIndex('test-index', { vectorDims: 768 })).rejects.toThrow(
+          'Index "test-index" maps code_vector with cosine similarity, but --similarity is dot_product. ' +
+            'Reindex with --clean to recreate the index, or pass --similarity cosine to keep it.'
+        );
+      }
+    ));
+
+  it('should index code_vector with the configured quantization', () =>
+    withTestEnv(
+      { SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true', SCS_IDXR_DENSE_VECTOR_QUANTIZATION: 'bbq_hnsw' },
+      async () => {
+        const { create } = setIndicesClient(0);
+
+        await elasticsearch.createIndex('test-index', { vectorDims: 1024 });
+
+        const request = create.mock.calls[0]?.[0] as { mappings: { properties: { code_vector: unknown } } };
+        expect(request.mappings.properties.code_vector).toMatchObject({ index_options: { type: 'bbq_hnsw' } });
+        await expect(elasticsearch.createIndex('test-index', { vectorDims: 32 })).rejects.toThrow(
+          '--vector-quantization bbq_hnsw requires at least 64 dimensions, but the embedding provider produces 32.'
+        );
+      }
+    ));
+
+  it('should fail when an existing index is quantized differently', () =>
+    withTestEnv(
+      { SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true', SCS_IDXR_DENSE_VECTOR_QUANTIZATION: 'int8_hnsw' },
+      async () => {
+        setIndicesClient(768);
+
+        await expect(elasticsearch.createIndex('test-index', { vectorDims: 768 })).rejects.toThrow(
+          'Index "test-index" indexes code_vector with the default index options, but --vector-quantization is ' +
+            'int8_hnsw. Reindex with --clean to recreate the index, or omit --vector-quantization to keep it.'
+        );
+      }
+    ));
+
+  it('should check the dimensions each quantization supports', () => {
+    expect(() => elasticsearch.assertVectorQuantizationDims('int4_hnsw', 385, 'the provider produces')).toThrow(
+      '--vector-quantization int4_hnsw requires an even number of dimensions, but the provider produces 385.'
+    );
+    expect(() => elasticsearch.assertVectorQuantizationDims('none', 8192, 'the provider produces')).toThrow(
+      'requires at most 4096 dimensions'
+    );
+    expect(() => elasticsearch.assertVectorQuantizationDims('int8_hnsw', 385, 'the provider produces')).not.toThrow();
+  });
+
+  it('should create indices with the mapping file and check its dimensions', async () => {
+    const tmpDir = fs.mkdtempSync(path.join(os.tmpdir(), 'mapping-file-'));
+    const mappingFile = path.join(tmpDir, 'mapping.json');
+    const mappings = {
+      properties: {
+        content: { type: 'text', analyzer: 'code_analyzer' },
+        code_vector: { type: 'dense_vector', dims: 1024, similarity: 'l2_norm' },
+      },
+    };
+    fs.writeFileSync(mappingFile, JSON.stringify({ mappings }));
+
+    try {
+      await withTestEnv({ SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true', SCS_IDXR_MAPPING_FILE: mappingFile }, async () => {
+        const { create } = setIndicesClient(0);
+
+        await elasticsearch.createIndex('test-index', { vectorDims: 1024 });
+
+        const request = create.mock.calls[0]?.[0] as { mappings: unknown; settings: { analysis?: unknown } };
+        expect(request.mappings).toEqual(mappings);
+        expect(request.settings.analysis).toBeDefined();
+        await expect(elasticsearch.createIndex('test-index', { vectorDims: 1536 })).rejects.toThrow(
+          `--mapping-file ${mappingFile} maps code_vector with 1024 dimensions, but the embedding provider ` +
+            'produces 1536.'
+        );
+      });
+    } finally {
+      fs.rmSync(tmpDir, { recursive: true, force: true });
+    }
+  });
+
+  it('should fail when an existing index uses another inference endpoint for semantic_text', () =>
+    withTestEnv(
+      { SCS_IDXR_DISABLE_SEMANTIC_TEXT: undefined, SCS_IDXR_ELASTICSEARCH_INFERENCE_ID: '.elser-2-elastic' },
+      async () => {
+        const getMapping = vi.fn().mockResolvedValue({
+          'test-index-000001': {
+            mappings: { properties: { semantic_text: { type: 'semantic_text', inference_id: 'my-e5' } } },
+          },
+        });
+        elasticsearch.setClient({
+          indices: { exists: vi.fn().mockResolvedValue(true), getMapping },
+        } as unknown as Client);
+
+        await expect(elasticsearch.createIndex('test-index')).rejects.toThrow(
+          'Index "test-index" embeds semantic_text with the inference endpoint "my-e5", but ' +
+            'SCS_IDXR_ELASTICSEARCH_INFERENCE_ID is ".elser-2-elastic".'
+        );
+
+        getMapping.mockResolvedValue({
+          'test-index-000001': { mappings: { properties: { semantic_text: { type: 'text' } } } },
+        });
+        await expect(elasticsearch.createIndex('test-index')).rejects.toThrow(
+          'Index "test-index" has no semantic_text field'
+        );
+      }
+    ));
+});
+
+describe('waitForElasticsearch', () => {
+  afterEach(() => {
+    elasticsearch.setClient(undefined);
+  });
+
+  const connectionError = () => Object.assign(new Error('connect ECONNREFUSED 127.0.0.1:9200'), { meta: {} });
+  const responseError = (statusCode: number, reason: string) =>
+    Object.assign(new Error(reason), { meta: { statusCode, body: { error: { type: 'error', reason } } } });
+
+  function setInfoClient(info: Mock) {
+    elasticsearch.setClient({ info } as unknown as Client);
+  }
+
+  it('should retry until the cluster answers', async () => {
+    const info = vi
+      .fn()
+      .mockRejectedValueOnce(connectionError())
+      .mockRejectedValueOnce(responseError(503, 'master_not_discovered_exception'))
+      .mockResolvedValue({ version: { number: '8.15.0' } });
+    setInfoClient(info);
+
+    await expect(elasticsearch.waitForElasticsearch({ retries: 2, timeoutMs: 500, baseDelayMs: 0 })).resolves.toBe(
+      '8.15.0'
+    );
+    expect(info).toHaveBeenCalledTimes(3);
+    expect(info).toHaveBeenCalledWith(undefined, { requestTimeout: 500, maxRetries: 0 });
+  });
+
+  it('should give up after the configured retries', async () => {
+    const info = vi.fn().mockRejectedValue(connectionError());
+    setInfoClient(info);
+
+    await expect(elasticsearch.waitForElasticsearch({ retries: 2, baseDelayMs: 0 })).rejects.toThrow(
+      'Could not connect to Elasticsearch after 3 attempts: connect ECONNREFUSED 127.0.0.1:9200'
+    );
+    expect(info).toHaveBeenCalledTimes(3);
+  });
+
+  it('should not retry certificates that cannot be verified', async () => {
+    const info = vi.fn().mockRejectedValue(new Error('self-signed certificate in certificate chain'));
+    setInfoClient(info);
+
+    await expect(elasticsearch.waitForElasticsearch({ retries: 5, baseDelayMs: 0 })).rejects.toThrow(
+      'Pass the CA certificate of the cluster with --es-ca-cert'
+    );
+    expect(info).toHaveBeenCalledTimes(1);
+  });
+
+  it('should reject clusters older than the supported major version', async () => {
+    setInfoClient(vi.fn().mockResolvedValue({ version: { number: '7.17.9' } }));
+
+    await expect(elasticsearch.waitForElasticsearch({ retries: 0 })).rejects.toThrow(
+      'Elasticsearch 7.17.9 is not supported: the indexer needs Elasticsearch 8.0 or later.'
+    );
+  });
+
+  it('should not retry rejected credentials', async () => {
+    const info = vi.fn().mockRejectedValue(responseError(401, 'unable to authenticate user'));
+    setInfoClient(info);
+
+    await expect(elasticsearch.waitForElasticsearch({ retries: 5, baseDelayMs: 0 })).rejects.toThrow(
+      'Elasticsearch rejected the connection check with status 401: unable to authenticate user'
+    );
+    expect(info).toHaveBeenCalledTimes(1);
+  });
+});
+
+describe('index aliases', () => {
+  afterEach(() => {
+    elasticsearch.setClient(undefined);
+  });
+
+  function setAliasClient(existing: string[], aliases: Record<string, string[]>) {
+    const indices = {
+      exists: vi.fn(async ({ index }: { index: string }) => existing.includes(index) || index in aliases),
+      existsAlias: vi.fn(async ({ name }: { name: string }) => name in aliases),
+      getAlias: vi.fn(async ({ name }: { name: string }) =>
+        Object.fromEntries(aliases[name].map((target) => [target, { aliases: { [name]: {} } }]))
+      ),
+      get: vi.fn(async ({ index }: { index: string }) => {
+        const prefix = index.replace(/\*$/, '');
+        const names = new Set([...existing, ...Object.values(aliases).flat()]);
+        return Object.fromEntries([...names].filter((name) => name.startsWith(prefix)).map((name) => [name, {}]));
+      }),
+      create: vi.fn(),
+      delete: vi.fn(),
+      updateAliases: vi.fn(),
+    };
+    elasticsearch.setClient({ indices } as unknown as Client);
+    return indices;
+  }
+
+  it('should create a new index as the first generation behind an alias', () =>
+    withTestEnv({ SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true' }, async () => {
+      const indices = setAliasClient([], {});
+
+      await elasticsearch.createIndex('code-search');
+
+      const request = indices.create.mock.calls[0]?.[0];
+      expect(request.index).toBe('code-search-000001');
+      expect(request.aliases).toEqual({ 'code-search': {} });
+      expect(request.mappings.properties.content).toEqual({ type: 'text', analyzer: 'code_analyzer' });
+      expect(request.settings.analysis.analyzer.code_analyzer).toBeDefined();
+    }));
+
+  it('should create the locations index next to the aliased generation', async () => {
+    const indices = setAliasClient([], { 'code-search': ['code-search-000001'] });
+
+    await elasticsearch.createLocationsIndex('code-search');
+
+    expect(indices.create).toHaveBeenCalledWith(
+      expect.objectContaining({
+        index: 'code-search-000001_locations',
+        aliases: { 'code-search_locations': {} },
+      })
+    );
+  });
+
+  it('should create the next generation for a rebuild without aliasing it', () =>
+    withTestEnv({ SCS_IDXR_DISABLE_SEMANTIC_TEXT: 'true' }, async () => {
+      const indices = setAliasClient(['code-search-000003'], { 'code-search': ['code-search-000002'] });
+
+      const generationName = await elasticsearch.createRebuildIndex('code-search', { vectorDims: 1536 });
+
+      expect(generationName).toBe('code-search-000004');
+      const created = indices.create.mock.calls.map((call) => call[0]);
+      expect(created.map((request) => request.index)).toEqual(['code-search-000004', 'code-search-000004_locations']);
+      expect(created.every((request) => request.aliases === undefined)).toBe(true);
+      expect(created[0].mappings.properties.code_vector.dims).toBe(1536);
+    }));
+
+  it('should not create a rebuild generation for an index that does not exist', async () => {
+    const indices = setAliasClient([], {});
+
+    await expect(elasticsearch.createRebuildIndex('code-search')).resolves.toBeUndefined();
+    expect(indices.create).not.toHaveBeenCalled();
+  });
+
+  it('should swap both aliases in one request and delete the old generation on request', async () => {
+    const indices = setAliasClient([], {
+      'code-search': ['code-search-000001'],
+      'code-search_locations': ['code-search-000001_locations'],
+    });
+
+    const oldIndices = await elasticsearch.swapIndexAlias('code-search', 'code-search-000002', { keepOldIndices: 0 });
+
+    expect(indices.updateAliases).toHaveBeenCalledTimes(1);
+    expect(indices.updateAliases).toHaveBeenCalledWith({
+      actions: [
+        { add: { index: 'code-search-000002', alias: 'code-search' } },
+        { remove: { index: 'code-search-000001', alias: 'code-search' } },
+        { add: { index: 'code-search-000002_locations', alias: 'code-search_locations' } },
+        { remove: { index: 'code-search-000001_locations', alias: 'code-search_locations' } },
+      ],
+    });
+    expect(oldIndices).toEqual(['code-search-000001', 'code-search-000001_locations']);
+    expect(indices.delete).toHaveBeenCalledWith({
+      index: ['code-search-000001', 'code-search-000001_locations'],
+      ignore_unavailable: true,
+    });
+  });
+
+  it('should keep the newest previous generations and delete older ones', async () => {
+    const indices = setAliasClient(['code-search-000001', 'code-search-000002', 'code-search-archive'], {
+      'code-search': ['code-search-000003'],
+      'code-search_locations': ['code-search-000003_locations'],
+    });
+
+    const oldIndices = await elasticsearch.swapIndexAlias('code-search', 'code-search-000004', { keepOldIndices: 1 });
+
+    expect(oldIndices).toEqual(['code-search-000003', 'code-search-000003_locations']);
+    expect(indices.delete).toHaveBeenCalledWith({
+      index: [
+        'code-search-000002',
+        'code-search-000002_locations',
+        'code-search-000001',
+        'code-search-000001_locations',
+      ],
+      ignore_unavailable: true,
+    });
+  });
+
+  it('should keep every previous generation by default', async () => {
+    const indices = setAliasClient(['code-search-000001'], { 'code-search': ['code-search-000002'] });
+
+    await elasticsearch.swapIndexAlias('code-search', 'code-search-000003');
+
+    expect(indices.get).not.toHaveBeenCalled();
+    expect(indices.delete).not.toHaveBeenCalled();
+  });
+
+  it('should move a shared read alias to the new generation in the same request', async () => {
+    const indices = setAliasClient([], {
+      'code-search': ['code-search-000001'],
+      'code-search_locations': ['code-search-000001_locations'],
+      'all-code': ['docs-000001', 'code-search-000001'],
+      'all-code_locations': ['docs-000001_locations', 'code-search-000001_locations'],
+    });
+
+    await elasticsearch.swapIndexAlias('code-search', 'code-search-000002', { readAlias: 'all-code' });
+
+    expect(indices.updateAliases).toHaveBeenCalledTimes(1);
+    expect(indices.updateAliases).toHaveBeenCalledWith({
+      actions: [
+        { add: { index: 'code-search-000002', alias: 'code-search' } },
+        { remove: { index: 'code-search-000001', alias: 'code-search' } },
+        { add: { index: 'code-search-000002', alias: 'all-code' } },
+        { remove: { index: 'code-search-000001', alias: 'all-code' } },
+        { add: { index: 'code-search-000002_locations', alias: 'code-search_locations' } },
+        { remove: { index: 'code-search-000001_locations', alias: 'code-search_locations' } },
+        { add: { index: 'code-search-000002_locations', alias: 'all-code_locations' } },
+        { remove: { index: 'code-search-000001_locations', alias: 'all-code_locations' } },
+      ],
+    });
+  });
+
+  it('should add a read alias to an index updated in place', async () => {
+    const indices = setAliasClient(['legacy_locations'], {
+      'code-search': ['code-search-000001'],
+      'code-search_locations': ['code-search-000001_locations'],
+      'all-code': ['code-search-000001'],
+    });
+
+    await elasticsearch.addReadAlias('code-search', 'all-code');
+    await elasticsearch.addReadAlias('legacy', 'all-code');
+
+    expect(indices.updateAliases).toHaveBeenCalledTimes(2);
+    expect(indices.updateAliases).toHaveBeenNthCalledWith(1, {
+      actions: [{ add: { index: 'code-search-000001_locations', alias: 'all-code_locations' } }],
+    });
+    expect(indices.updateAliases).toHaveBeenNthCalledWith(2, {
+      actions: [{ add: { index: 'legacy_locations', alias: 'all-code_locations' } }],
+    });
+  });
+
+  it('should replace an index that uses the alias name', async () => {
+    const indices = setAliasClient(['code-search', 'code-search_locations'], {});
+
+    const oldIndices = await elasticsearch.swapIndexAlias('code-search', 'code-search-000001');
+
+    expect(indices.updateAliases).toHaveBeenCalledWith({
+      actions: [
+        { add: { index: 'code-search-000001', alias: 'code-search' } },
+        { remove_index: { index: 'code-search' } },
+        { add: { index: 'code-search-000001_locations', alias: 'code-search_locations' } },
+        { remove_index: { index: 'code-search_locations' } },
+      ],
+    });
+    expect(oldIndices).toEqual([]);
+    expect(indices.delete).not.toHaveBeenCalled();
+  });
+});
+
+describe('indexHasSemanticTextField', () => {
+  let mockGetMapping: Mock;
+  let mockClient: Client;
+
+  beforeEach(() => {
+    mockGetMapping = vi.fn();
+    mockClient = {
+      indices: {
+        getMapping: mockGetMapping,
+      },
+    } as unknown as Client;
+
+    elasticsearch.setClient(mockClient);
+  });
+
+  afterEach(() => {
+    vi.clearAllMocks();
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should return true when semantic_text mapping exists', async () => {
+    mockGetMapping.mockResolvedValue({
+      'test-index': {
+        mappings: {
+          properties: {
+            semantic_text: { type: 'semantic_text' },
+          },
+        },
+      },
+    });
+
+    await expect(elasticsearch.indexHasSemanticTextField('test-index')).resolves.toBe(true);
+  });
+
+  it('should return false when semantic_text mapping does not exist', async () => {
+    mockGetMapping.mockResolvedValue({
+      'test-index': {
+        mappings: {
+          properties: {
+            content: { type: 'text' },
+          },
+        },
+      },
+    });
+
+    await expect(elasticsearch.indexHasSemanticTextField('test-index')).resolves.toBe(false);
+  });
+
+  it('should return false when semantic_text exists with wrong type', async () => {
+    mockGetMapping.mockResolvedValue({
+      'test-index': {
+        mappings: {
+          properties: {
+            semantic_text: { type: 'text' },
+          },
+        },
+      },
+    });
+
+    await expect(elasticsearch.indexHasSemanticTextField('test-index')).resolves.toBe(false);
+  });
+
+  it('should throw a friendly error when index does not exist', async () => {
+    const error = Object.assign(new Error('Not Found'), { meta: { statusCode: 404 } });
+    mockGetMapping.mockRejectedValue(error);
 
     await expect(elasticsearch.indexHasSemanticTextField('missing-index')).rejects.toThrow(
       'Index "missing-index" does not exist'
     );
   });
 });
+
+describe('searchCodeChunksKnn', () => {
+  let mockSearch: Mock;
+
+  beforeEach(() => {
+    mockSearch = vi.fn().mockResolvedValue({
+      hits: {
+        hits: [
+          { _id: 'chunk-1', _score: 0.92, _source: MOCK_CHUNK },
+          { _id: '', _score: 0.5, _source: MOCK_CHUNK },
+        ],
+      },
+    });
+    elasticsearch.setClient({ search: mockSearch } as unknown as Client);
+  });
+
+  afterEach(() => {
+    vi.clearAllMocks();
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should build the query vector from the configured model', async () => {
+    const results = await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', {
+      k: 5,
+      modelId: 'my-model',
+    });
+
+    expect(mockSearch).toHaveBeenCalledWith(
+      expect.objectContaining({
+        index: 'test-index',
+        size: 5,
+        knn: {
+          field: 'code_vector',
+          k: 5,
+          num_candidates: 100,
+          query_vector_builder: { text_embedding: { model_id: 'my-model', model_text: 'retry with backoff' } },
+        },
+      })
+    );
+    expect(results).toHaveLength(1);
+    expect(results[0]).toMatchObject({ id: 'chunk-1', score: 0.92, filePath: 'test.ts' });
+  });
+
+  it('should inflate content stored compressed', async () => {
+    const content = 'export const value = compute();\n'.repeat(40);
+    mockSearch.mockResolvedValue({
+      hits: { hits: [{ _id: 'chunk-1', _score: 0.9, _source: { ...MOCK_CHUNK, ...compressContent(content) } }] },
+    });
+
+    const results = await elasticsearch.searchCodeChunksKnn('compute', 'test-index', { k: 5, modelId: 'my-model' });
+
+    expect(results[0].content).toBe(content);
+    expect(results[0]).not.toHaveProperty('content_gz');
+  });
+
+  it('should filter by repository when repoName is set', async () => {
+    await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', {
+      k: 5,
+      modelId: 'my-model',
+      repoName: 'payments',
+    });
+
+    expect(mockSearch).toHaveBeenCalledWith(
+      expect.objectContaining({
+        knn: expect.objectContaining({ filter: { term: { repo_name: 'payments' } } }),
+      })
+    );
+  });
+
+  it('should filter by referenced symbol and receiver type', async () => {
+    await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', {
+      k: 5,
+      modelId: 'my-model',
+      repoName: 'payments',
+      references: 'Greeter.Greet',
+    });
+
+    const knn = (mockSearch.mock.calls[0]?.[0] as { knn: Record<string, unknown> }).knn;
+    expect(knn.filter).toEqual([
+      { term: { repo_name: 'payments' } },
+      {
+        nested: {
+          path: 'references',
+          query: {
+            bool: {
+              filter: [
+                { term: { 'references.receiver_type': 'Greeter' } },
+                { term: { 'references.name': 'Greet' } },
+              ],
+            },
+          },
+        },
+      },
+    ]);
+  });
+
+  it('should use a precomputed query vector instead of a model', async () => {
+    await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', { k: 5, queryVector: [0.1, 0.2] });
+
+    const knn = (mockSearch.mock.calls[0]?.[0] as { knn: Record<string, unknown> }).knn;
+    expect(knn.query_vector).toEqual([0.1, 0.2]);
+    expect(knn).not.toHaveProperty('query_vector_builder');
+  });
+
+  it('should filter by language and chunk ids', async () => {
+    await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', {
+      k: 5,
+      modelId: 'my-model',
+      language: 'go',
+      chunkIds: ['chunk-1', 'chunk-2'],
+    });
+
+    const knn = (mockSearch.mock.calls[0]?.[0] as { knn: Record<string, unknown> }).knn;
+    expect(knn.filter).toEqual([{ term: { language: 'go' } }, { ids: { values: ['chunk-1', 'chunk-2'] } }]);
+  });
+
+  it('should filter by package', async () => {
+    await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', {
+      k: 5,
+      modelId: 'my-model',
+      packageName: 'main',
+    });
+
+    const knn = (mockSearch.mock.calls[0]?.[0] as { knn: Record<string, unknown> }).knn;
+    expect(knn.filter).toEqual({ term: { package_name: 'main' } });
+  });
+
+  it('should pre-filter the kNN candidates by language and symbol kind', async () => {
+    await elasticsearch.searchCodeChunksKnn('retry with backoff', 'test-index', {
+      k: 5,
+      modelId: 'my-model',
+      language: 'go',
+      symbolKind: 'method',
+    });
+
+    const request = mockSearch.mock.calls[0]?.[0] as { knn: Record<string, unknown>; query?: unknown };
+    expect(request.knn.filter).toEqual([{ term: { language: 'go' } }, { term: { symbol_kind: 'method' } }]);
+    expect(request).not.toHaveProperty('query');
+    expect(request).not.toHaveProperty('post_filter');
+  });
+});
+
+describe('searchCodeChunksHybrid', () => {
+  let mockMsearch: Mock;
+
+  beforeEach(() => {
+    mockMsearch = vi.fn().mockResolvedValue({
+      responses: [
+        {
+          hits: {
+            hits: [
+              { _id: 'exact', _score: 9, _source: MOCK_CHUNK },
+              { _id: 'both', _score: 4, _source: MOCK_CHUNK },
+            ],
+          },
+        },
+        {
+          hits: {
+            hits: [
+              { _id: 'both', _score: 0.9, _source: MOCK_CHUNK },
+              { _id: 'similar', _score: 0.7, _source: MOCK_CHUNK },
+            ],
+          },
+        },
+      ],
+    });
+    elasticsearch.setClient({ msearch: mockMsearch } as unknown as Client);
+  });
+
+  afterEach(() => {
+    vi.clearAllMocks();
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should send a filtered BM25 and kNN search in one request and fuse them', async () => {
+    const results = await elasticsearch.searchCodeChunksHybrid('getUserById', 'test-index', {
+      k: 2,
+      modelId: 'my-model',
+      language: 'go',
+      fusion: 'rrf',
+    });
+
+    const searches = (mockMsearch.mock.calls[0]?.[0] as { searches: Array<Record<string, unknown>> }).searches;
+    expect(searches[0]).toEqual({ index: 'test-index' });
+    expect(searches[1]).toMatchObject({
+      size: 50,
+      query: {
+        bool: {
+          must: {
+            multi_match: { query: 'getUserById', fields: ['content', 'symbol_fqn.text^3', 'symbol_name.text^3'] },
+          },
+          filter: { term: { language: 'go' } },
+        },
+      },
+    });
+    expect(searches[3]).toMatchObject({
+      size: 50,
+      knn: { field: 'code_vector', k: 50, filter: { term: { language: 'go' } } },
+    });
+    expect(results.map((result) => result.id)).toEqual(['both', 'exact']);
+  });
+
+  it('should weight the lexical results with linear fusion', async () => {
+    const results = await elasticsearch.searchCodeChunksHybrid('getUserById', 'test-index', {
+      k: 3,
+      queryVector: [0.1, 0.2],
+      fusion: 'linear',
+      lexicalWeight: 3,
+    });
+
+    expect(results.map((result) => [result.id, result.score])).toEqual([
+      ['exact', 3],
+      ['both', 1],
+      ['similar', 0],
+    ]);
+  });
+
+  it('should fail when one of the searches fails', async () => {
+    mockMsearch.mockResolvedValue({
+      responses: [{ hits: { hits: [] } }, { error: { type: 'illegal_argument_exception', reason: 'no code_vector' } }],
+    });
+
+    await expect(
+      elasticsearch.searchCodeChunksHybrid('getUserById', 'test-index', { k: 2, modelId: 'my-model', fusion: 'rrf' })
+    ).rejects.toThrow('Hybrid search failed in the kNN search: no code_vector');
+  });
+});
+
+describe('getChunkIdsForPathPrefix', () => {
+  const mockSearch = vi.fn();
+  const mockExists = vi.fn();
+
+  beforeEach(() => {
+    mockExists.mockResolvedValue(true);
+    elasticsearch.setClient({ search: mockSearch, indices: { exists: mockExists } } as unknown as Client);
+  });
+
+  afterEach(() => {
+    vi.clearAllMocks();
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should return the chunk ids located under the prefix', async () => {
+    mockSearch.mockResolvedValue({
+      aggregations: { chunk_ids: { sum_other_doc_count: 0, buckets: [{ key: 'chunk-1' }, { key: 'chunk-2' }] } },
+    });
+
+    const chunkIds = await elasticsearch.getChunkIdsForPathPrefix('test-index', 'src/utils/', { repoName: 'repo' });
+
+    expect(chunkIds).toEqual(['chunk-1', 'chunk-2']);
+    expect(mockSearch).toHaveBeenCalledWith(
+      expect.objectContaining({
+        index: 'test-index_locations',
+        query: { bool: { filter: [{ prefix: { filePath: 'src/utils/' } }, { term: { repo_name: 'repo' } }] } },
+      })
+    );
+  });
+
+  it('should throw when the prefix matches too many chunks', async () => {
+    mockSearch.mockResolvedValue({ aggregations: { chunk_ids: { sum_other_doc_count: 5, buckets: [] } } });
+
+    await expect(elasticsearch.getChunkIdsForPathPrefix('test-index', 's')).rejects.toThrow(
+      `Path prefix "s" matches more than ${elasticsearch.MAX_PATH_PREFIX_CHUNKS} chunks. Use a longer prefix.`
+    );
+  });
+
+  it('should return no ids without a locations index', async () => {
+    mockExists.mockResolvedValue(false);
+
+    expect(await elasticsearch.getChunkIdsForPathPrefix('test-index', 'src/')).toEqual([]);
+    expect(mockSearch).not.toHaveBeenCalled();
+  });
+});
+
+describe('getIndexedFileSummaries', () => {
+  const mockSearch = vi.fn();
+  const mockExists = vi.fn();
+
+  beforeEach(() => {
+    mockExists.mockResolvedValue(true);
+    elasticsearch.setClient({ search: mockSearch, indices: { exists: mockExists } } as unknown as Client);
+  });
+
+  afterEach(() => {
+    vi.clearAllMocks();
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should page through the location counts and content hashes of each file', async () => {
+    mockSearch
+      .mockResolvedValueOnce({
+        aggregations: {
+          file_paths: {
+            buckets: [
+              { key: { filePath: 'a.ts' }, doc_count: 3, git_file_hashes: { buckets: [{ key: 'hash-a' }] } },
+            ],
+            after_key: { filePath: 'a.ts' },
+          },
+        },
+      })
+      .mockResolvedValueOnce({
+        aggregations: {
+          file_paths: {
+            buckets: [
+              {
+                key: { filePath: 'b.ts' },
+                doc_count: 2,
+                git_file_hashes: { buckets: [{ key: 'hash-b' }, { key: 'old-b' }] },
+              },
+            ],
+          },
+        },
+      });
+
+    const summaries = await elasticsearch.getIndexedFileSummaries('test-index', {
+      branch: 'main',
+      repoName: 'repo',
+      pageSize: 1,
+    });
+
+    expect(summaries).toEqual(
+      new Map([
+        ['a.ts', { documentCount: 3, gitFileHashes: ['hash-a'] }],
+        ['b.ts', { documentCount: 2, gitFileHashes: ['hash-b', 'old-b'] }],
+      ])
+    );
+    expect(mockSearch).toHaveBeenCalledTimes(2);
+    expect(mockSearch.mock.calls[1][0]).toMatchObject({
+      index: 'test-index_locations',
+      aggs: { file_paths: { composite: { after: { filePath: 'a.ts' } } } },
+    });
+    expect(mockSearch.mock.calls[0][0].query.bool.filter[0]).toEqual({ term: { git_branch: 'main' } });
+  });
+
+  it('should return no files without a locations index', async () => {
+    mockExists.mockResolvedValue(false);
+
+    expect((await elasticsearch.getIndexedFileSummaries('test-index')).size).toBe(0);
+    expect(mockSearch).not.toHaveBeenCalled();
+  });
+});
+
+describe('getIndexStats', () => {
+  const mockSearch = vi.fn();
+  const mockExists = vi.fn();
+
+  beforeEach(() => {
+    mockExists.mockResolvedValue(true);
+    elasticsearch.setClient({ search: mockSearch, indices: { exists: mockExists } } as unknown as Client);
+  });
+
+  afterEach(() => {
+    vi.clearAllMocks();
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should aggregate the chunk and location indexes', async () => {
+    mockSearch
+      .mockResolvedValueOnce({
+        hits: {
+          total: { value: 120, relation: 'eq' },
+          hits: [
+            {
+              _id: 'chunk-big',
+              _source: { language: 'typescript', symbol_fqn: 'fixtures.data' },
+              fields: { token_estimate: [3100] },
+            },
+          ],
+        },
+        aggregations: {
+          by_language: { buckets: [{ key: 'typescript', doc_count: 100 }, { key: 'markdown', doc_count: 20 }] },
+          by_symbol_kind: { buckets: [{ key: 'function', doc_count: 70 }, { key: 'none', doc_count: 50 }] },
+          estimated: { doc_count: 120 },
+          sizes: { buckets: [{ from: 0, to: 64, doc_count: 119 }, { from: 2048, doc_count: 1 }] },
+        },
+      })
+      .mockResolvedValueOnce({
+        aggregations: {
+          by_chunk: {
+            buckets: [
+              {
+                key: 'chunk-big',
+                doc_count: 2,
+                locations: {
+                  hits: { hits: [{ _source: { filePath: 'src/fixtures.ts', startLine: 1, endLine: 900 } }] },
+                },
+              },
+            ],
+          },
+        },
+      })
+      .mockResolvedValueOnce({
+        hits: { total: { value: 150, relation: 'eq' }, hits: [] },
+        aggregations: {
+          files: { value: 12 },
+          top_files: { buckets: [{ key: 'src/generated.ts', doc_count: 80 }] },
+        },
+      });
+
+    const stats = await elasticsearch.getIndexStats('test-index', { repoName: 'repo', top: 1 });
+
+    expect(stats).toEqual({
+      index: 'test-index',
+      chunks: 120,
+      locations: 150,
+      files: 12,
+      byLanguage: [
+        { key: 'typescript', count: 100 },
+        { key: 'markdown', count: 20 },
+      ],
+      bySymbolKind: [
+        { key: 'function', count: 70 },
+        { key: 'none', count: 50 },
+      ],
+      estimatedSizes: 120,
+      largestChunks: [
+        {
+          chunkId: 'chunk-big',
+          tokens: 3100,
+          language: 'typescript',
+          symbolName: 'fixtures.data',
+          location: { filePath: 'src/fixtures.ts', startLine: 1, endLine: 900 },
+          locations: 2,
+        },
+      ],
+      filesWithMostChunks: [{ filePath: 'src/generated.ts', chunks: 80 }],
+      chunkSizes: [
+        { from: 0, to: 64, count: 119 },
+        { from: 2048, to: null, count: 1 },
+      ],
+    });
+    expect(mockSearch.mock.calls[0][0]).toMatchObject({
+      index: 'test-index',
+      size: 1,
+      query: { bool: { filter: [{ term: { repo_name: 'repo' } }] } },
+      sort: [{ token_estimate: { order: 'desc' } }],
+    });
+    expect(mockSearch.mock.calls[2][0]).toMatchObject({
+      index: 'test-index_locations',
+      aggs: { top_files: { terms: { field: 'filePath', size: 1 } } },
+    });
+  });
+
+  it('should report no locations without a locations index', async () => {
+    mockExists.mockResolvedValue(false);
+    mockSearch.mockResolvedValueOnce({ hits: { total: { value: 0, relation: 'eq' }, hits: [] }, aggregations: {} });
+
+    const stats = await elasticsearch.getIndexStats('test-index');
+
+    expect(stats).toMatchObject({ chunks: 0, locations: 0, files: 0, filesWithMostChunks: [], largestChunks: [] });
+    expect(mockSearch).toHaveBeenCalledTimes(1);
+  });
+});
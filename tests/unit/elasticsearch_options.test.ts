@@ -0,0 +1,57 @@
+import { Command } from 'commander';
+import { describe, it, expect } from 'vitest';
+
+import { addElasticsearchOptions, applyElasticsearchOptions } from '../../src/commands/elasticsearch_options';
+import { elasticsearchConfig } from '../../src/config';
+import { withTestEnv } from './utils/test_env';
+
+const connectionEnv = {
+  ELASTICSEARCH_ENDPOINT: 'http://localhost:9200',
+  ELASTICSEARCH_CLOUD_ID: 'deployment:ZXhhbXBsZQ==',
+  ELASTICSEARCH_API_KEY: undefined,
+  ELASTICSEARCH_CA_CERT: undefined,
+  ELASTICSEARCH_INSECURE: undefined,
+};
+
+describe('Elasticsearch options', () => {
+  it('should parse the flags after the command name and override the environment', () =>
+    withTestEnv(connectionEnv, async () => {
+      const program = addElasticsearchOptions(new Command().exitOverride());
+      program.command('index').action(() => {});
+      await program.parseAsync(
+        ['index', '--es-node', 'https://es.internal:9200', '--es-api-key', 'key', '--es-ca-cert', __filename],
+        { from: 'user' }
+      );
+
+      applyElasticsearchOptions(program.opts());
+
+      expect(elasticsearchConfig.endpoint).toBe('https://es.internal:9200');
+      // The Cloud ID would be preferred over the node the flag names.
+      expect(elasticsearchConfig.cloudId).toBeUndefined();
+      expect(elasticsearchConfig.apiKey).toBe('key');
+      expect(elasticsearchConfig.caCert).toBe(__filename);
+      expect(elasticsearchConfig.insecure).toBe(false);
+    }));
+
+  it('should replace the endpoint with --es-cloud-id', () =>
+    withTestEnv(connectionEnv, () => {
+      applyElasticsearchOptions({ esCloudId: 'other:ZXhhbXBsZQ==', esInsecure: true });
+
+      expect(elasticsearchConfig.cloudId).toBe('other:ZXhhbXBsZQ==');
+      expect(elasticsearchConfig.endpoint).toBeUndefined();
+      expect(elasticsearchConfig.insecure).toBe(true);
+    }));
+
+  it('should reject invalid flags', () =>
+    withTestEnv(connectionEnv, () => {
+      expect(() => applyElasticsearchOptions({ esNode: 'http://a:9200', esCloudId: 'b' })).toThrow(
+        '--es-node and --es-cloud-id cannot be used together.'
+      );
+      expect(() => applyElasticsearchOptions({ esNode: 'localhost:9200' })).toThrow(
+        'Invalid --es-node value: localhost:9200. Expected an http or https URL.'
+      );
+      expect(() => applyElasticsearchOptions({ esCaCert: '/missing/ca.pem' })).toThrow(
+        'Invalid --es-ca-cert value: /missing/ca.pem. The file does not exist.'
+      );
+    }));
+});
@@ -0,0 +1,64 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect, vi } from 'vitest';
+
+import { CachedEmbeddingProvider, EmbeddingCache, getEmbeddingModelKey } from '../../src/utils/embedding_cache';
+import { EmbeddingProvider } from '../../src/utils/embedding_provider';
+
+describe('CachedEmbeddingProvider', () => {
+  let tmpDir: string;
+  let cache: EmbeddingCache;
+
+  beforeEach(() => {
+    tmpDir = fs.mkdtempSync(path.join(os.tmpdir(), 'embedding-cache-'));
+    cache = new EmbeddingCache(path.join(tmpDir, 'embedding_cache.db'));
+  });
+
+  afterEach(() => {
+    cache.close();
+    fs.rmSync(tmpDir, { recursive: true, force: true });
+  });
+
+  /** Returns a provider whose vector for a text is `[text length, 0.5]`. */
+  const createProvider = (model = 'model-a'): EmbeddingProvider & { embed: ReturnType<typeof vi.fn> } => ({
+    dimensions: 2,
+    model,
+    embed: vi.fn(async (texts: string[]) => texts.map((text) => [text.length, 0.5])),
+  });
+
+  it('should only embed texts whose normalized content was not embedded before', async () => {
+    const provider = createProvider();
+    const cached = new CachedEmbeddingProvider(provider, cache, getEmbeddingModelKey('http', provider));
+
+    expect(await cached.embed(['const a = 1;', 'const b = 22;', 'const a = 1;'])).toEqual([
+      [12, 0.5],
+      [13, 0.5],
+      [12, 0.5],
+    ]);
+    expect(await cached.embed(['const b = 22; \t', 'const c = 333;'])).toEqual([
+      [13, 0.5],
+      [14, 0.5],
+    ]);
+
+    expect(provider.embed.mock.calls).toEqual([[['const a = 1;', 'const b = 22;']], [['const c = 333;']]]);
+    expect(cache.stats()).toEqual({ hits: 1, misses: 4 });
+  });
+
+  it('should keep vectors across runs and not share them between models', async () => {
+    const embedWith = (provider: EmbeddingProvider, texts: string[]) =>
+      new CachedEmbeddingProvider(provider, cache, getEmbeddingModelKey('http', provider)).embed(texts);
+    await embedWith(createProvider(), ['const a = 1;']);
+    cache.close();
+    cache = new EmbeddingCache(path.join(tmpDir, 'embedding_cache.db'));
+
+    const sameModel = createProvider();
+    await embedWith(sameModel, ['const a = 1;']);
+    const otherModel = createProvider('model-b');
+    await embedWith(otherModel, ['const a = 1;']);
+
+    expect(sameModel.embed).not.toHaveBeenCalled();
+    expect(otherModel.embed).toHaveBeenCalledTimes(1);
+    expect(cache.stats()).toEqual({ hits: 1, misses: 1 });
+  });
+});
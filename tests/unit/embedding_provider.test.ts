@@ -0,0 +1,394 @@
+import { Client } from '@elastic/elasticsearch';
+import { afterEach, describe, it, expect, vi } from 'vitest';
+
+import * as elasticsearch from '../../src/utils/elasticsearch';
+import {
+  CohereEmbeddingProvider,
+  createEmbeddingProvider,
+  ElasticsearchEmbeddingProvider,
+  HttpEmbeddingProvider,
+  parseEmbeddingResponse,
+  resolveModelDimensions,
+  validateEmbeddingProvider,
+} from '../../src/utils/embedding_provider';
+import { RateLimiter } from '../../src/utils/rate_limiter';
+import { withTestEnv } from './utils/test_env';
+
+function jsonResponse(body: unknown, status = 200): Response {
+  return new Response(JSON.stringify(body), { status });
+}
+
+/** Fake endpoint that embeds each text as `[length]`. */
+function createFetch() {
+  let inFlight = 0;
+  let maxInFlight = 0;
+  const fetchMock = vi.fn(async (_url: string | URL | Request, init?: RequestInit) => {
+    inFlight++;
+    maxInFlight = Math.max(maxInFlight, inFlight);
+    await new Promise((resolve) => setTimeout(resolve, 5));
+    inFlight--;
+    const { input } = JSON.parse(String(init?.body)) as { input: string[] };
+    return jsonResponse({ data: input.map((text, index) => ({ index, embedding: [text.length] })) });
+  });
+  return { fetchMock, maxInFlight: () => maxInFlight };
+}
+
+describe('HttpEmbeddingProvider', () => {
+  it('should split texts into batches and keep the input order', async () => {
+    const { fetchMock } = createFetch();
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      model: 'code-embed',
+      batchSize: 2,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    const vectors = await provider.embed(['a', 'bb', 'ccc', 'dddd', 'eeeee']);
+
+    expect(vectors).toEqual([[1], [2], [3], [4], [5]]);
+    expect(fetchMock).toHaveBeenCalledTimes(3);
+    expect(JSON.parse(String(fetchMock.mock.calls[0]?.[1]?.body))).toEqual({ model: 'code-embed', input: ['a', 'bb'] });
+  });
+
+  it('should limit the number of requests in flight', async () => {
+    const { fetchMock, maxInFlight } = createFetch();
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      batchSize: 1,
+      concurrency: 2,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await provider.embed(['a', 'b', 'c', 'd', 'e']);
+
+    expect(fetchMock).toHaveBeenCalledTimes(5);
+    expect(maxInFlight()).toBe(2);
+  });
+
+  it('should throw with the status and body of a failed request', async () => {
+    const fetchMock = vi.fn(async () => new Response('model not loaded', { status: 503 }));
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await expect(provider.embed(['a'])).rejects.toThrow(
+      'Embedding request to http://embedder/v1/embeddings failed with HTTP 503: model not loaded'
+    );
+  });
+
+  it('should split a batch rejected as too large and keep the input order', async () => {
+    const fetchMock = vi.fn(async (_url: string | URL | Request, init?: RequestInit) => {
+      const { input } = JSON.parse(String(init?.body)) as { input: string[] };
+      return input.length > 2
+        ? new Response('payload too large', { status: 413 })
+        : jsonResponse({ embeddings: input.map((text) => [text.length]) });
+    });
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    const vectors = await provider.embed(['a', 'bb', 'ccc', 'dddd', 'eeeee']);
+
+    expect(vectors).toEqual([[1], [2], [3], [4], [5]]);
+    expect(fetchMock.mock.calls.map(([, init]) => JSON.parse(String(init?.body)).input)).toEqual([
+      ['a', 'bb', 'ccc', 'dddd', 'eeeee'],
+      ['a', 'bb', 'ccc'],
+      ['a', 'bb'],
+      ['ccc'],
+      ['dddd', 'eeeee'],
+    ]);
+  });
+
+  it('should split a batch over the token limit and fail on a single text over it', async () => {
+    const fetchMock = vi.fn(async () => new Response('maximum context length is 8192 tokens', { status: 400 }));
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await expect(provider.embed(['a', 'b'])).rejects.toThrow('failed with HTTP 400');
+    expect(fetchMock).toHaveBeenCalledTimes(2);
+  });
+
+  it('should not split a batch rejected for another reason', async () => {
+    const fetchMock = vi.fn(async () => new Response('unknown model', { status: 400 }));
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await expect(provider.embed(['a', 'b'])).rejects.toThrow('failed with HTTP 400: unknown model');
+    expect(fetchMock).toHaveBeenCalledTimes(1);
+  });
+
+  it('should throw when the endpoint returns the wrong number of vectors', async () => {
+    const fetchMock = vi.fn(async () => jsonResponse({ embeddings: [[1]] }));
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await expect(provider.embed(['a', 'b'])).rejects.toThrow('returned 1 vectors for 2 inputs');
+  });
+
+  it('should send the API key as a bearer token', async () => {
+    const fetchMock = vi.fn(async () => jsonResponse({ embeddings: [[1]] }));
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      apiKey: 'secret',
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await provider.embed(['a']);
+
+    const headers = (fetchMock.mock.calls[0] as unknown as [string, RequestInit])[1].headers;
+    expect(headers).toMatchObject({ Authorization: 'Bearer secret' });
+  });
+
+  it('should wait for the rate limiter with the estimated tokens of each batch', async () => {
+    const { fetchMock } = createFetch();
+    const rateLimiter = new RateLimiter({ requestsPerMinute: 600 });
+    const acquireSpy = vi.spyOn(rateLimiter, 'acquire');
+    const provider = new HttpEmbeddingProvider({
+      url: 'http://embedder/v1/embeddings',
+      dimensions: 1,
+      batchSize: 2,
+      rateLimiter,
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    await provider.embed(['a'.repeat(30), 'b'.repeat(30), 'c'.repeat(3)]);
+
+    expect(acquireSpy.mock.calls).toEqual([[20], [1]]);
+  });
+
+  it('should resend a rate limited request after the Retry-After delay', async () => {
+    vi.useFakeTimers();
+    try {
+      const sentAt: number[] = [];
+      const fetchMock = vi.fn(async () => {
+        sentAt.push(Date.now());
+        return sentAt.length === 1
+          ? new Response('slow down', { status: 429, headers: { 'Retry-After': '7' } })
+          : jsonResponse({ embeddings: [[1]] });
+      });
+      const provider = new HttpEmbeddingProvider({
+        url: 'http://embedder/v1/embeddings',
+        dimensions: 1,
+        fetch: fetchMock as unknown as typeof fetch,
+      });
+
+      const vectors = provider.embed(['a']);
+      await vi.advanceTimersByTimeAsync(10_000);
+
+      await expect(vectors).resolves.toEqual([[1]]);
+      expect(sentAt[1] - sentAt[0]).toBe(7000);
+    } finally {
+      vi.useRealTimers();
+    }
+  });
+
+  it('should back off through the rate limiter when a 429 has no Retry-After header', async () => {
+    vi.useFakeTimers();
+    try {
+      const sentAt: number[] = [];
+      const fetchMock = vi.fn(async () => {
+        sentAt.push(Date.now());
+        return sentAt.length <= 2 ? new Response('slow down', { status: 429 }) : jsonResponse({ embeddings: [[1]] });
+      });
+      const rateLimiter = new RateLimiter();
+      const pauseSpy = vi.spyOn(rateLimiter, 'pauseFor');
+      const provider = new HttpEmbeddingProvider({
+        url: 'http://embedder/v1/embeddings',
+        dimensions: 1,
+        rateLimiter,
+        fetch: fetchMock as unknown as typeof fetch,
+      });
+
+      const vectors = provider.embed(['a']);
+      await vi.advanceTimersByTimeAsync(10_000);
+
+      await expect(vectors).resolves.toEqual([[1]]);
+      expect(pauseSpy.mock.calls).toEqual([[1000], [2000]]);
+      expect([sentAt[1] - sentAt[0], sentAt[2] - sentAt[1]]).toEqual([1000, 2000]);
+    } finally {
+      vi.useRealTimers();
+    }
+  });
+
+  it('should fail a request still rate limited after the retries', async () => {
+    vi.useFakeTimers();
+    try {
+      const fetchMock = vi.fn(async () => new Response('slow down', { status: 429 }));
+      const provider = new HttpEmbeddingProvider({
+        url: 'http://embedder/v1/embeddings',
+        dimensions: 1,
+        fetch: fetchMock as unknown as typeof fetch,
+      });
+
+      const vectors = provider.embed(['a']);
+      const rejection = expect(vectors).rejects.toThrow('failed with HTTP 429: slow down');
+      await vi.advanceTimersByTimeAsync(60_000);
+
+      await rejection;
+      expect(fetchMock).toHaveBeenCalledTimes(6);
+    } finally {
+      vi.useRealTimers();
+    }
+  });
+});
+
+describe('CohereEmbeddingProvider', () => {
+  it('should send texts with the input type and read float embeddings', async () => {
+    const fetchMock = vi.fn(async () => jsonResponse({ embeddings: { float: [[0.1], [0.2]] } }));
+    const provider = new CohereEmbeddingProvider({
+      url: 'https://api.cohere.com/v2/embed',
+      model: 'embed-english-v3.0',
+      dimensions: 1,
+      purpose: 'query',
+      fetch: fetchMock as unknown as typeof fetch,
+    });
+
+    const vectors = await provider.embed(['a', 'b']);
+
+    expect(vectors).toEqual([[0.1], [0.2]]);
+    const init = (fetchMock.mock.calls[0] as unknown as [string, RequestInit])[1];
+    expect(JSON.parse(String(init.body))).toEqual({
+      model: 'embed-english-v3.0',
+      texts: ['a', 'b'],
+      input_type: 'search_query',
+      embedding_types: ['float'],
+    });
+  });
+});
+
+describe('parseEmbeddingResponse', () => {
+  it('should order OpenAI-style data by index', () => {
+    const vectors = parseEmbeddingResponse({
+      data: [
+        { index: 1, embedding: [2] },
+        { index: 0, embedding: [1] },
+      ],
+    });
+    expect(vectors).toEqual([[1], [2]]);
+  });
+
+  it('should accept a plain embeddings array', () => {
+    expect(parseEmbeddingResponse({ embeddings: [[1, 2]] })).toEqual([[1, 2]]);
+  });
+
+  it('should reject unknown response shapes', () => {
+    expect(() => parseEmbeddingResponse({ result: [] })).toThrow('Unexpected embedding response');
+  });
+});
+
+describe('ElasticsearchEmbeddingProvider', () => {
+  afterEach(() => {
+    elasticsearch.setClient(undefined);
+  });
+
+  it('should embed texts with the deployed model', async () => {
+    const inferTrainedModel = vi.fn().mockResolvedValue({
+      inference_results: [{ predicted_value: [0.1, 0.2] }, { predicted_value: [0.3, 0.4] }],
+    });
+    elasticsearch.setClient({ ml: { inferTrainedModel } } as unknown as Client);
+
+    const vectors = await new ElasticsearchEmbeddingProvider('my-model').embed(['a', 'b']);
+
+    expect(inferTrainedModel).toHaveBeenCalledWith({
+      model_id: 'my-model',
+      docs: [{ text_field: 'a' }, { text_field: 'b' }],
+    });
+    expect(vectors).toEqual([
+      [0.1, 0.2],
+      [0.3, 0.4],
+    ]);
+  });
+});
+
+describe('createEmbeddingProvider', () => {
+  it('should create an HTTP provider with SCS_IDXR_DENSE_VECTOR_DIMS dimensions', () =>
+    withTestEnv({ SCS_IDXR_DENSE_VECTOR_DIMS: '384' }, () => {
+      const provider = createEmbeddingProvider({ provider: 'http', url: 'https://embedder/v1/embeddings' });
+      expect(provider).toBeInstanceOf(HttpEmbeddingProvider);
+      expect(provider.dimensions).toBe(384);
+    }));
+
+  it('should reject an unknown provider', () => {
+    expect(() => createEmbeddingProvider({ provider: 'local' })).toThrow(
+      'Invalid --embedding-provider value: local. Expected one of: elasticsearch, http, openai, cohere.'
+    );
+  });
+
+  it('should derive the dimensions of hosted providers from the model', () =>
+    withTestEnv({ OPENAI_API_KEY: 'sk-test', COHERE_API_KEY: 'co-test' }, () => {
+      expect(createEmbeddingProvider({ provider: 'openai' }).dimensions).toBe(1536);
+      expect(createEmbeddingProvider({ provider: 'openai', model: 'text-embedding-3-large' }).dimensions).toBe(3072);
+      const cohere = createEmbeddingProvider({ provider: 'cohere' });
+      expect(cohere).toBeInstanceOf(CohereEmbeddingProvider);
+      expect(cohere.dimensions).toBe(1024);
+    }));
+
+  it('should require credentials for hosted providers', () =>
+    withTestEnv({ OPENAI_API_KEY: undefined, COHERE_API_KEY: undefined }, () => {
+      expect(() => createEmbeddingProvider({ provider: 'openai' })).toThrow(
+        'OPENAI_API_KEY must be set to use --embedding-provider openai.'
+      );
+      expect(() => createEmbeddingProvider({ provider: 'cohere' })).toThrow(
+        'COHERE_API_KEY must be set to use --embedding-provider cohere.'
+      );
+    }));
+
+  it('should require an http(s) URL for the HTTP provider', () => {
+    expect(() => createEmbeddingProvider({ provider: 'http' })).toThrow('--embedding-url is required');
+    expect(() => createEmbeddingProvider({ provider: 'http', url: 'embedder:8080' })).toThrow(
+      'Invalid --embedding-url value: embedder:8080'
+    );
+    expect(() => createEmbeddingProvider({ provider: 'http', url: 'not a url' })).toThrow(
+      'Invalid --embedding-url value: not a url'
+    );
+  });
+});
+
+describe('resolveModelDimensions', () => {
+  it('should fall back to SCS_IDXR_DENSE_VECTOR_DIMS for unknown models', () =>
+    withTestEnv({ SCS_IDXR_DENSE_VECTOR_DIMS: undefined }, () => {
+      expect(resolveModelDimensions('embed-english-light-v3.0')).toBe(384);
+      expect(resolveModelDimensions('my-local-model')).toBe(768);
+      expect(resolveModelDimensions(undefined)).toBe(768);
+    }));
+});
+
+describe('validateEmbeddingProvider', () => {
+  it('should pass when the probe vector matches the provider dimensions', async () => {
+    await expect(validateEmbeddingProvider({ dimensions: 3, embed: async () => [[0, 0, 0]] })).resolves.toBeUndefined();
+  });
+
+  it('should fail on a dimension mismatch', async () => {
+    await expect(validateEmbeddingProvider({ dimensions: 768, embed: async () => [[0, 0]] })).rejects.toThrow(
+      'vectors have 2 dimensions, but 768 were expected'
+    );
+  });
+
+  it('should wrap errors from the provider', async () => {
+    const provider = {
+      dimensions: 768,
+      embed: async () => {
+        throw new Error('getaddrinfo ENOTFOUND embedder');
+      },
+    };
+    await expect(validateEmbeddingProvider(provider)).rejects.toThrow(
+      'Embedding provider validation failed: getaddrinfo ENOTFOUND embedder'
+    );
+  });
+});
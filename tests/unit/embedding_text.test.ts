@@ -0,0 +1,100 @@
+import { describe, it, expect } from 'vitest';
+
+import { CodeChunk } from '../../src/utils/elasticsearch';
+import {
+  getEmbeddingTextOptions,
+  normalizeEmbeddingText,
+  parseEmbeddingHeaderTemplate,
+} from '../../src/utils/embedding_text';
+import { withTestEnv } from './utils/test_env';
+
+const chunk = (language: string, content: string): CodeChunk => ({
+  type: 'code',
+  language,
+  kind: 'function_declaration',
+  filePath: 'src/greet',
+  chunk_hash: 'chunk_hash_1',
+  content,
+  semantic_text: content,
+  created_at: new Date().toISOString(),
+  updated_at: new Date().toISOString(),
+});
+
+describe('normalizeEmbeddingText', () => {
+  it('should return the text unchanged without options', () => {
+    const content = '// Greets.\nfunc Greet() {\n\tfmt.Println("Hi")\n}';
+
+    expect(normalizeEmbeddingText(content, chunk('go', content), {})).toBe(content);
+  });
+
+  it('should strip comments in the syntax of the chunk language', () => {
+    const go = '/* Package-level\n   greeting. */\nfunc Greet() {\n\t// Say hi.\n\tfmt.Println("http://x") // inline\n}';
+    const python = '# Greets.\ndef greet():\n    # Say hi.\n    print("/* not a comment */")';
+
+    expect(normalizeEmbeddingText(go, chunk('go', go), { stripComments: true })).toBe(
+      '\nfunc Greet() {\n\tfmt.Println("http://x") // inline\n}'
+    );
+    expect(normalizeEmbeddingText(python, chunk('python', python), { stripComments: true })).toBe(
+      'def greet():\n    print("/* not a comment */")'
+    );
+  });
+
+  it('should strip a leading license header, also after the semantic_text header', () => {
+    const content =
+      '# Copyright 2024 Example Inc.\n# SPDX-License-Identifier: MIT\nimport os\n# Paths.\nROOT = os.getcwd()';
+    const semanticText = `language: python\nkind: module\n\n${content}`;
+    const notice = '// Greets the user.\nfunc Greet() {}';
+
+    expect(normalizeEmbeddingText(content, chunk('python', content), { stripLicense: true })).toBe(
+      '\nimport os\n# Paths.\nROOT = os.getcwd()'
+    );
+    expect(normalizeEmbeddingText(semanticText, chunk('python', content), { stripLicense: true })).toBe(
+      'language: python\nkind: module\n\n\nimport os\n# Paths.\nROOT = os.getcwd()'
+    );
+    expect(normalizeEmbeddingText(notice, chunk('go', notice), { stripLicense: true })).toBe(notice);
+  });
+
+  it('should embed a chunk that is only a comment with its comments', () => {
+    const license = '/*\n * Licensed under the Apache License, Version 2.0.\n */';
+
+    expect(normalizeEmbeddingText(license, chunk('java', license), { stripLicense: true, lowercase: true })).toBe(
+      license.toLowerCase()
+    );
+  });
+
+  it('should fill the header template from the chunk and fall back to the node kind', () => {
+    const content = 'func Greet() {}';
+    const named: CodeChunk = { ...chunk('go', content), scope_path: 'Greeter.Greet', symbol_kind: 'method' };
+    const template = parseEmbeddingHeaderTemplate('// {language} {path}\\n// symbol: {name} ({kind})\\n');
+
+    expect(normalizeEmbeddingText(content, named, { headerTemplate: template, lowercase: true })).toBe(
+      '// go src/greet\n// symbol: Greeter.Greet (method)\nfunc greet() {}'
+    );
+    expect(normalizeEmbeddingText(content, chunk('go', content), { headerTemplate: template })).toBe(
+      '// go src/greet\n// symbol:  (function_declaration)\nfunc Greet() {}'
+    );
+  });
+});
+
+describe('getEmbeddingTextOptions', () => {
+  it('should read the transforms from the environment and reject unknown placeholders', async () => {
+    const options = await withTestEnv(
+      { SCS_IDXR_EMBED_COLLAPSE_WHITESPACE: 'true', SCS_IDXR_EMBED_HEADER_TEMPLATE: 'file: {path}\\n' },
+      () => getEmbeddingTextOptions()
+    );
+    expect(options).toEqual({
+      stripComments: false,
+      stripLicense: false,
+      collapseWhitespace: true,
+      lowercase: false,
+      headerTemplate: 'file: {path}\n',
+    });
+
+    await expect(
+      withTestEnv({ SCS_IDXR_EMBED_HEADER_TEMPLATE: 'file: {file}' }, () => getEmbeddingTextOptions())
+    ).rejects.toThrow(
+      'Invalid configuration: SCS_IDXR_EMBED_HEADER_TEMPLATE has an unknown placeholder {file}. ' +
+        'Expected one of: {path}, {name}, {kind}, {language}.'
+    );
+  });
+});
@@ -135,4 +135,57 @@ describe('Enqueue Completion Tracking', () => {
       expect(dequeued.length).toBe(50);
     });
   });
+
+  describe('WHEN tracking enqueued files', () => {
+    it('SHOULD record the source file of each enqueue, including files without documents', async () => {
+      await queue.enqueue([createMockChunk(1)], { sourceFile: { filePath: '/test/file1.ts', mtimeMs: 1000 } });
+      await queue.enqueue([], { sourceFile: { filePath: '/test/empty.ts', mtimeMs: 2000.5 } });
+
+      expect(queue.getEnqueuedFiles()).toEqual(
+        new Map([
+          ['/test/file1.ts', 1000],
+          ['/test/empty.ts', 2000],
+        ])
+      );
+    });
+
+    it('SHOULD persist enqueued files across queue instances', async () => {
+      await queue.enqueue([createMockChunk(1)], { sourceFile: { filePath: '/test/file1.ts', mtimeMs: 1000 } });
+      queue.close();
+
+      const queue2 = new SqliteQueue({
+        dbPath: queueDbPath,
+        repoName: 'test-repo',
+        branch: 'main',
+      });
+      await queue2.initialize();
+
+      expect(queue2.getEnqueuedFiles().get('/test/file1.ts')).toBe(1000);
+      queue2.close();
+    });
+
+    it('SHOULD replace the pending documents of a file that is enqueued again', async () => {
+      await queue.enqueue([createMockChunk(1), createMockChunk(1)], {
+        sourceFile: { filePath: '/test/file1.ts', mtimeMs: 1000 },
+      });
+      await queue.enqueue([createMockChunk(2)], { sourceFile: { filePath: '/test/file2.ts', mtimeMs: 1000 } });
+      await queue.enqueue([{ ...createMockChunk(1), content: 'changed' }], {
+        sourceFile: { filePath: '/test/file1.ts', mtimeMs: 3000 },
+      });
+
+      const dequeued = await queue.dequeue(100);
+      expect(dequeued.map((doc) => doc.document.content).sort()).toEqual(['changed', 'test content 2']);
+      expect(queue.getEnqueuedFiles().get('/test/file1.ts')).toBe(3000);
+    });
+
+    it('SHOULD forget enqueued files when enqueue completes or the queue is cleared', async () => {
+      await queue.enqueue([createMockChunk(1)], { sourceFile: { filePath: '/test/file1.ts', mtimeMs: 1000 } });
+      await queue.markEnqueueCompleted();
+      expect(queue.getEnqueuedFiles().size).toBe(0);
+
+      await queue.enqueue([createMockChunk(2)], { sourceFile: { filePath: '/test/file2.ts', mtimeMs: 1000 } });
+      await queue.clear();
+      expect(queue.getEnqueuedFiles().size).toBe(0);
+    });
+  });
 });
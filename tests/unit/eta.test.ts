@@ -0,0 +1,42 @@
+import { describe, it, expect } from 'vitest';
+import { EtaEstimator, formatDuration } from '../../src/utils/eta';
+
+describe('EtaEstimator', () => {
+  it('should report an unknown rate until two samples exist', () => {
+    const eta = new EtaEstimator();
+    expect(eta.ratePerSecond).toBe(0);
+    expect(eta.estimateRemainingSeconds(100)).toBeNull();
+
+    eta.record(0, 0);
+    expect(eta.estimateRemainingSeconds(100)).toBeNull();
+  });
+
+  it('should estimate the remaining time from the observed rate', () => {
+    const eta = new EtaEstimator();
+    eta.record(0, 0);
+    eta.record(50, 10_000);
+
+    expect(eta.ratePerSecond).toBe(5);
+    expect(eta.estimateRemainingSeconds(100)).toBe(20);
+    expect(eta.estimateRemainingSeconds(0)).toBe(0);
+  });
+
+  it('should follow recent throughput within the moving window', () => {
+    const eta = new EtaEstimator(10_000);
+    eta.record(0, 0);
+    eta.record(1000, 10_000);
+    // Throughput drops to 1/s after the first window.
+    eta.record(1010, 20_000);
+    eta.record(1020, 30_000);
+
+    expect(eta.ratePerSecond).toBe(1);
+  });
+});
+
+describe('formatDuration', () => {
+  it('should format seconds, minutes and hours', () => {
+    expect(formatDuration(42)).toBe('42s');
+    expect(formatDuration(250)).toBe('4m 10s');
+    expect(formatDuration(7500)).toBe('2h 5m');
+  });
+});
@@ -0,0 +1,189 @@
+import { execFileSync } from 'child_process';
+import fs from 'fs';
+import http from 'http';
+import { AddressInfo } from 'net';
+import os from 'os';
+import path from 'path';
+import { PassThrough } from 'stream';
+import { afterEach, beforeEach, describe, it, expect, vi } from 'vitest';
+
+import {
+  ChangeEventQueue,
+  consumeChangeEvents,
+  listenForEvents,
+  parseChangeEvent,
+  parseEventSource,
+  readEventStream,
+} from '../../src/commands/event_stream';
+import * as incrementalModule from '../../src/commands/incremental_index_command';
+import * as elasticsearchModule from '../../src/utils/elasticsearch';
+
+const createTestLogger = () =>
+  ({ info: vi.fn(), warn: vi.fn(), error: vi.fn() }) as unknown as Parameters<typeof readEventStream>[2];
+
+describe('parseEventSource', () => {
+  it('should read stdin for -', () => {
+    expect(parseEventSource('-')).toEqual({ type: 'stdin' });
+  });
+
+  it('should listen on a port or host:port', () => {
+    expect(parseEventSource('9470')).toEqual({ type: 'http', host: '127.0.0.1', port: 9470 });
+    expect(parseEventSource('0.0.0.0:9470')).toEqual({ type: 'http', host: '0.0.0.0', port: 9470 });
+  });
+
+  it('should reject anything else', () => {
+    expect(() => parseEventSource('events.jsonl')).toThrow('Invalid --events value: events.jsonl.');
+    expect(() => parseEventSource('70000')).toThrow('Invalid --events value: 70000.');
+  });
+});
+
+describe('parseChangeEvent', () => {
+  it('should read the changed paths and the commit', () => {
+    expect(
+      parseChangeEvent('{"commit":"3f1c2a9","added":["src/new.ts"],"modified":["./src/app.ts"],"deleted":["docs/"]}')
+    ).toEqual({ commit: '3f1c2a9', added: ['src/new.ts'], modified: ['src/app.ts'], deleted: ['docs'] });
+  });
+
+  it('should default missing lists to empty', () => {
+    expect(parseChangeEvent('{"deleted":["a.ts"]}')).toEqual({ added: [], modified: [], deleted: ['a.ts'] });
+  });
+
+  it('should reject malformed events and paths outside the repository', () => {
+    expect(() => parseChangeEvent('not json')).toThrow('Invalid change event: not valid JSON.');
+    expect(() => parseChangeEvent('["a.ts"]')).toThrow('must be a JSON object');
+    expect(() => parseChangeEvent('{"commit":42}')).toThrow('commit must be a non-empty string');
+    expect(() => parseChangeEvent('{"added":"a.ts"}')).toThrow('added must be an array of paths');
+    expect(() => parseChangeEvent('{"deleted":["../secrets"]}')).toThrow('not a path inside the repository');
+    expect(() => parseChangeEvent('{"modified":["/etc/passwd"]}')).toThrow('not a path inside the repository');
+  });
+});
+
+describe('ChangeEventQueue', () => {
+  it('should return events in arrival order, then undefined once closed', async () => {
+    const events = new ChangeEventQueue();
+    const first = events.next();
+    events.push({ commit: 'a', added: [], modified: [], deleted: [] });
+    events.push({ commit: 'b', added: [], modified: [], deleted: [] });
+    events.close();
+    events.push({ commit: 'c', added: [], modified: [], deleted: [] });
+
+    expect((await first)?.commit).toBe('a');
+    expect((await events.next())?.commit).toBe('b');
+    expect(await events.next()).toBeUndefined();
+  });
+});
+
+describe('readEventStream', () => {
+  it('should queue valid lines, skip invalid ones and close at the end of the stream', async () => {
+    const input = new PassThrough();
+    const events = new ChangeEventQueue();
+    const logger = createTestLogger();
+    readEventStream(input, events, logger);
+
+    input.end('{"commit":"a","added":["a.ts"]}\n\nnot json\n{"commit":"b","deleted":["b.ts"]}\n');
+
+    expect((await events.next())?.commit).toBe('a');
+    expect((await events.next())?.commit).toBe('b');
+    expect(await events.next()).toBeUndefined();
+    expect(logger.warn).toHaveBeenCalledWith(
+      'Skipping line 3 of the event stream: Invalid change event: not valid JSON.'
+    );
+  });
+});
+
+describe('listenForEvents', () => {
+  let server: http.Server | undefined;
+
+  afterEach(async () => {
+    await new Promise<void>((resolve) => (server ? server.close(() => resolve()) : resolve()));
+    server = undefined;
+  });
+
+  const listen = async (events: ChangeEventQueue) => {
+    server = await listenForEvents({ host: '127.0.0.1', port: 0 }, events, createTestLogger());
+    return `http://127.0.0.1:${(server.address() as AddressInfo).port}/`;
+  };
+
+  it('should queue the events of a POST and answer 202', async () => {
+    const events = new ChangeEventQueue();
+    const url = await listen(events);
+
+    const response = await fetch(url, {
+      method: 'POST',
+      body: '{"commit":"a","added":["a.ts"]}\n{"commit":"b","modified":["b.ts"]}\n',
+    });
+
+    expect(response.status).toBe(202);
+    expect(await response.json()).toEqual({ accepted: 2, queued: 2 });
+    expect(events.length).toBe(2);
+  });
+
+  it('should queue none of the events of a request with an invalid one', async () => {
+    const events = new ChangeEventQueue();
+    const url = await listen(events);
+
+    const response = await fetch(url, { method: 'POST', body: '{"commit":"a"}\n{"added":[1]}\n' });
+
+    expect(response.status).toBe(400);
+    expect(await response.json()).toEqual({ error: 'Invalid change event: added must be an array of paths.' });
+    expect(events.length).toBe(0);
+  });
+
+  it('should answer 405 to other methods', async () => {
+    const url = await listen(new ChangeEventQueue());
+
+    const response = await fetch(url);
+
+    expect(response.status).toBe(405);
+    expect(response.headers.get('allow')).toBe('POST');
+  });
+});
+
+describe('consumeChangeEvents', () => {
+  let repoDir: string;
+  let queueDir: string;
+
+  beforeEach(() => {
+    repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'change-events-'));
+    queueDir = fs.mkdtempSync(path.join(os.tmpdir(), 'change-events-queue-'));
+    execFileSync('git', ['init', '-q'], { cwd: repoDir, stdio: 'ignore' });
+    fs.writeFileSync(path.join(repoDir, 'a.ts'), 'export const a = 1;\n');
+    execFileSync('git', ['add', '-A'], { cwd: repoDir, stdio: 'ignore' });
+    execFileSync('git', ['-c', 'user.name=Ann', '-c', 'user.email=test@example.com', 'commit', '-qm', 'a'], {
+      cwd: repoDir,
+      stdio: 'ignore',
+    });
+  });
+
+  afterEach(() => {
+    fs.rmSync(repoDir, { recursive: true, force: true });
+    fs.rmSync(queueDir, { recursive: true, force: true });
+    vi.restoreAllMocks();
+  });
+
+  it('should record the commit of each indexed event as the last indexed commit', async () => {
+    const head = execFileSync('git', ['rev-parse', 'HEAD'], { cwd: repoDir }).toString().trim();
+    vi.spyOn(incrementalModule, 'indexFileChanges').mockResolvedValue({
+      filesToIndex: ['a.ts'],
+      filesToDelete: [],
+      interrupted: false,
+    });
+    vi.spyOn(elasticsearchModule, 'createSettingsIndex').mockResolvedValue(undefined);
+    const updateSpy = vi.spyOn(elasticsearchModule, 'updateLastIndexedCommit').mockResolvedValue(undefined);
+    const input = new PassThrough();
+    const indexQueue = vi.fn(async () => {});
+
+    const consumed = consumeChangeEvents(
+      repoDir,
+      { source: { type: 'stdin' }, input, queueDir, elasticsearchIndex: 'code', repoName: 'demo', branch: 'main' },
+      indexQueue
+    );
+    // The second event is for a commit the checkout is not at, so its files may not match it.
+    input.end(`{"commit":"${head.slice(0, 7)}","modified":["a.ts"]}\n{"commit":"0000000","modified":["a.ts"]}\n`);
+
+    expect(await consumed).toEqual({ applied: 2, failed: 0, dropped: 0 });
+    expect(indexQueue).toHaveBeenCalledTimes(2);
+    expect(updateSpy).toHaveBeenCalledTimes(1);
+    expect(updateSpy).toHaveBeenCalledWith('main', head, 'code', 'demo');
+  });
+});
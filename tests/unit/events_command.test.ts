@@ -0,0 +1,37 @@
+import { afterEach, describe, it, expect, vi } from 'vitest';
+
+import { eventsCommand } from '../../src/commands/events_command';
+import * as indexCommandModule from '../../src/commands/index_command';
+
+describe('eventsCommand', () => {
+  afterEach(() => {
+    vi.restoreAllMocks();
+  });
+
+  it('should run with the options of index and the event source', async () => {
+    const indexSpy = vi.spyOn(indexCommandModule, 'indexRepos').mockResolvedValue(undefined);
+
+    await eventsCommand.parseAsync([
+      'node',
+      'test',
+      '/path/to/repo',
+      '--events',
+      '9470',
+      '--alias',
+      'code-search',
+      '--no-store-content',
+      '--max-chunks-per-file',
+      '500',
+    ]);
+
+    expect(indexSpy).toHaveBeenCalledWith(
+      ['/path/to/repo'],
+      expect.objectContaining({
+        alias: 'code-search',
+        storeContent: false,
+        maxChunksPerFile: '500',
+        events: { type: 'http', host: '127.0.0.1', port: 9470 },
+      })
+    );
+  });
+});
@@ -0,0 +1,118 @@
+import { findExtensionRule, loadExtensionMap, logParserSample } from '../../src/utils/extension_map';
+import { LanguageParser } from '../../src/utils/parser';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect, vi } from 'vitest';
+
+describe('extension map', () => {
+  let tempDir: string;
+
+  const writeMap = (content: unknown) => {
+    const filePath = path.join(tempDir, 'map.json');
+    fs.writeFileSync(filePath, typeof content === 'string' ? content : JSON.stringify(content));
+    return filePath;
+  };
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'extension-map-test-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  describe('loadExtensionMap', () => {
+    it('should load rules and drop a leading wildcard', () => {
+      const filePath = writeMap({ extensions: { '.gotmpl': 'text', '*.pb.go': 'skip' }, unknown: 'text' });
+
+      expect(loadExtensionMap(filePath)).toEqual({
+        extensions: { '.gotmpl': 'text', '.pb.go': 'skip' },
+        unknown: 'text',
+      });
+    });
+
+    it('should reject unreadable and malformed files', () => {
+      expect(() => loadExtensionMap(path.join(tempDir, 'missing.json'))).toThrow('Could not read extension map');
+      expect(() => loadExtensionMap(writeMap('{'))).toThrow('Could not read extension map');
+      expect(() => loadExtensionMap(writeMap([]))).toThrow('expected an object with an "extensions" object');
+      expect(() => loadExtensionMap(writeMap({ extensions: ['.go'] }))).toThrow('"extensions" must map file suffixes');
+    });
+
+    it('should reject bad suffixes, parsers and unknown modes', () => {
+      expect(() => loadExtensionMap(writeMap({ extensions: { gotmpl: 'text' } }))).toThrow(
+        '"gotmpl" is not a file suffix such as ".gotmpl".'
+      );
+      expect(() => loadExtensionMap(writeMap({ extensions: { '.gotmpl': 'jinja' } }))).toThrow(
+        '".gotmpl" maps to "jinja". Expected "skip" or a language name:'
+      );
+      expect(() => loadExtensionMap(writeMap({ extensions: {}, unknown: 'markdown' }))).toThrow(
+        '"unknown" must be one of: skip, text.'
+      );
+    });
+  });
+
+  describe('findExtensionRule', () => {
+    it('should pick the longest matching suffix of the file name', () => {
+      const map = { extensions: { '.go': 'go', '.pb.go': 'skip' } };
+
+      expect(findExtensionRule(map, 'api/service.pb.go')).toBe('.pb.go');
+      expect(findExtensionRule(map, 'api/service.go')).toBe('.go');
+      expect(findExtensionRule(map, 'api/service.ts')).toBeUndefined();
+      expect(findExtensionRule(map, 'api/.go')).toBeUndefined();
+    });
+  });
+
+  describe('LanguageParser routing', () => {
+    it('should let map rules override and skip language suffixes', () => {
+      const parser = new LanguageParser('go,text', {
+        extensionMap: { extensions: { '.pb.go': 'skip', '.gotmpl': 'text' } },
+      });
+
+      expect(parser.getLanguageConfigForFile('main.go')?.name).toBe('go');
+      expect(parser.getLanguageConfigForFile('api/service.pb.go')).toBeUndefined();
+      expect(parser.getLanguageConfigForFile('templates/page.gotmpl')?.name).toBe('text');
+      expect(parser.getLanguageConfigForFile('Dockerfile.bin')).toBeUndefined();
+    });
+
+    it('should skip files routed to a language that is not enabled', () => {
+      const parser = new LanguageParser('go', { extensionMap: { extensions: { '.gotmpl': 'text' } } });
+
+      expect(parser.getLanguageConfigForFile('templates/page.gotmpl')).toBeUndefined();
+    });
+
+    it('should parse unknown extensions as text when asked to', () => {
+      const parser = new LanguageParser('go', { extensionMap: { extensions: { '.lock': 'skip' }, unknown: 'text' } });
+
+      expect(parser.getLanguageConfigForFile('config/app.conf')?.name).toBe('text');
+      expect(parser.getLanguageConfigForFile('go.sum.lock')).toBeUndefined();
+      expect(parser.getLanguageConfigForFile('main.go')?.name).toBe('go');
+    });
+
+    it('should parse a routed file with the mapped language', () => {
+      const filePath = path.join(tempDir, 'page.gotmpl');
+      fs.writeFileSync(filePath, '{{ define "page" }}\n<h1>{{ .Title }}</h1>\n{{ end }}\n');
+      const parser = new LanguageParser('go,text', { extensionMap: { extensions: { '.gotmpl': 'text' } } });
+
+      const result = parser.parseFile(filePath, 'main', 'templates/page.gotmpl');
+
+      expect(result.chunks.length).toBeGreaterThan(0);
+      expect(result.chunks.every((chunk) => chunk.language === 'text')).toBe(true);
+    });
+  });
+
+  describe('logParserSample', () => {
+    it('should log one file per suffix', () => {
+      const logger = { info: vi.fn() };
+      const parser = new LanguageParser('go', { extensionMap: { extensions: { '.pb.go': 'skip' } } });
+
+      logParserSample(
+        ['a.go', 'b.go', 'api/c.pb.go'],
+        (file) => parser.getLanguageConfigForFile(file),
+        logger as unknown as Parameters<typeof logParserSample>[2]
+      );
+
+      expect(logger.info).toHaveBeenCalledWith('Resolved parsers (sample): a.go -> go, api/c.pb.go -> skip');
+    });
+  });
+});
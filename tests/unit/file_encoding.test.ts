@@ -0,0 +1,61 @@
+import { describe, it, expect } from 'vitest';
+
+import { BINARY_SNIFF_BYTES, decodeText, detectEncoding } from '../../src/utils/file_encoding';
+
+const SOURCE = "const greeting = 'héllo wörld';\n";
+
+describe('detectEncoding', () => {
+  it('should detect byte order marks', () => {
+    expect(detectEncoding(Buffer.concat([Buffer.from([0xef, 0xbb, 0xbf]), Buffer.from(SOURCE)]))).toBe('utf-8');
+    expect(detectEncoding(Buffer.concat([Buffer.from([0xff, 0xfe]), Buffer.from(SOURCE, 'utf16le')]))).toBe(
+      'utf-16le'
+    );
+    expect(detectEncoding(Buffer.from([0xfe, 0xff, 0x00, 0x61]))).toBe('utf-16be');
+    expect(detectEncoding(Buffer.from([0xff, 0xfe, 0x00, 0x00, 0x61, 0x00, 0x00, 0x00]))).toBe('binary');
+  });
+
+  it('should tell UTF-16 without a BOM from binary content', () => {
+    expect(detectEncoding(Buffer.from(SOURCE, 'utf16le'))).toBe('utf-16le');
+    expect(detectEncoding(Buffer.from(SOURCE, 'utf16le').swap16())).toBe('utf-16be');
+    expect(detectEncoding(Buffer.from([0x47, 0x00, 0x11, 0x10]))).toBe('binary');
+    expect(detectEncoding(Buffer.from([0x89, 0x50, 0x4e, 0x47, 0x00, 0x00, 0x00, 0x0d]))).toBe('binary');
+  });
+
+  it('should fall back to Latin-1 for content that is not UTF-8', () => {
+    expect(detectEncoding(Buffer.from(SOURCE))).toBe('utf-8');
+    expect(detectEncoding(Buffer.from(SOURCE, 'latin1'))).toBe('latin1');
+  });
+
+  it('should accept a UTF-8 character cut off at the end of the sniffed bytes', () => {
+    const content = Buffer.from(`${'a'.repeat(BINARY_SNIFF_BYTES - 1)}é`);
+
+    expect(detectEncoding(content)).toBe('utf-8');
+  });
+});
+
+describe('decodeText', () => {
+  it('should transcode every text encoding to UTF-8 without BOM', () => {
+    const encoded = [
+      Buffer.from(SOURCE),
+      Buffer.concat([Buffer.from([0xef, 0xbb, 0xbf]), Buffer.from(SOURCE)]),
+      Buffer.concat([Buffer.from([0xff, 0xfe]), Buffer.from(SOURCE, 'utf16le')]),
+      Buffer.concat([Buffer.from([0xfe, 0xff]), Buffer.from(SOURCE, 'utf16le').swap16()]),
+      Buffer.from(SOURCE, 'latin1'),
+    ];
+
+    expect(encoded.map((content) => decodeText(content).text)).toEqual(encoded.map(() => SOURCE));
+  });
+
+  it('should read files that are not valid UTF-8 past the sniffed bytes as Latin-1', () => {
+    const content = Buffer.concat([Buffer.from('a'.repeat(BINARY_SNIFF_BYTES)), Buffer.from('é', 'latin1')]);
+
+    expect(decodeText(content)).toEqual({ encoding: 'latin1', text: `${'a'.repeat(BINARY_SNIFF_BYTES)}é` });
+  });
+
+  it('should not return text for binary content or content with null characters', () => {
+    const nullPastSniff = Buffer.concat([Buffer.from('a'.repeat(BINARY_SNIFF_BYTES)), Buffer.from([0x00])]);
+
+    expect(decodeText(Buffer.from([0x47, 0x00, 0x11, 0x10]))).toEqual({ encoding: 'binary' });
+    expect(decodeText(nullPastSniff)).toEqual({ encoding: 'utf-8' });
+  });
+});
@@ -0,0 +1,353 @@
+import {
+  createPathFilter,
+  expandBraces,
+  filterReadableFiles,
+  readFileList,
+  resolveListedFiles,
+  sampleFiles,
+  walkRepositoryFiles,
+} from '../../src/utils/file_walker';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+describe('walkRepositoryFiles', () => {
+  let rootDir: string;
+
+  const writeFile = (relativePath: string, content = '') => {
+    const absolutePath = path.join(rootDir, relativePath);
+    fs.mkdirSync(path.dirname(absolutePath), { recursive: true });
+    fs.writeFileSync(absolutePath, content);
+  };
+
+  beforeEach(() => {
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'file-walker-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should return files with supported suffixes only', () => {
+    writeFile('src/a.ts');
+    writeFile('src/b.md');
+    writeFile('src/c.bin');
+
+    const result = walkRepositoryFiles({ rootDir, fileSuffixes: ['.ts', '.md'] });
+
+    expect(result.files).toEqual(['src/a.ts', 'src/b.md']);
+  });
+
+  it('should let includeFile decide which files are returned', () => {
+    writeFile('api/service.go');
+    writeFile('api/service.pb.go');
+    writeFile('templates/page.gotmpl');
+
+    const result = walkRepositoryFiles({ rootDir, includeFile: (file) => !file.endsWith('.pb.go') });
+
+    expect(result.files).toEqual(['api/service.go', 'templates/page.gotmpl']);
+  });
+
+  it('should prune ignored directories and count skipped entries', () => {
+    writeFile('.gitignore', 'node_modules/\ndist/\n*.gen.ts\n');
+    writeFile('node_modules/pkg/index.ts');
+    writeFile('dist/out.ts');
+    writeFile('src/keep.ts');
+    writeFile('src/skip.gen.ts');
+
+    const result = walkRepositoryFiles({ rootDir, fileSuffixes: ['.ts'] });
+
+    expect(result.files).toEqual(['src/keep.ts']);
+    expect(result.ignoredDirectoryCount).toBe(2);
+    expect(result.ignoredFileCount).toBe(1);
+  });
+
+  it('should list the ignored paths when asked to', () => {
+    writeFile('.gitignore', 'dist/\n*.gen.ts\n');
+    writeFile('dist/out.ts');
+    writeFile('src/keep.ts');
+    writeFile('src/skip.gen.ts');
+
+    expect(walkRepositoryFiles({ rootDir, fileSuffixes: ['.ts'] }).ignoredPaths).toBeUndefined();
+    const result = walkRepositoryFiles({ rootDir, fileSuffixes: ['.ts'], collectIgnoredPaths: true });
+
+    expect(result.ignoredPaths).toEqual(['dist/', 'src/skip.gen.ts']);
+  });
+
+  it('should let nested .gitignore rules override parent rules', () => {
+    writeFile('.gitignore', '*.log.ts\n');
+    writeFile('pkg/.gitignore', '!keep.log.ts\nlocal.ts\n');
+    writeFile('root.log.ts');
+    writeFile('pkg/keep.log.ts');
+    writeFile('pkg/other.log.ts');
+    writeFile('pkg/local.ts');
+    writeFile('local.ts');
+
+    const result = walkRepositoryFiles({ rootDir, fileSuffixes: ['.ts'] });
+
+    expect(result.files).toEqual(['local.ts', 'pkg/keep.log.ts']);
+  });
+
+  it('should apply extra ignore files and exclude globs', () => {
+    writeFile('custom.ignore', 'generated/\n');
+    writeFile('generated/a.ts');
+    writeFile('vendor/lib.ts');
+    writeFile('src/a.ts');
+    writeFile('src/a_parser.ts');
+
+    const result = walkRepositoryFiles({
+      rootDir,
+      fileSuffixes: ['.ts'],
+      ignoreFiles: [path.join(rootDir, 'custom.ignore')],
+      excludePatterns: ['vendor/**'],
+    });
+
+    expect(result.files).toEqual(['src/a.ts']);
+  });
+
+  it('should only return files matching the include globs, minus excluded and ignored ones', () => {
+    writeFile('.gitignore', 'src/gen/\n');
+    writeFile('src/api/handler.go');
+    writeFile('src/api/handler_test.go');
+    writeFile('src/gen/types.go');
+    writeFile('src/main.go');
+    writeFile('src/ui/app.tsx');
+    writeFile('tools/lint.go');
+    writeFile('docs/guide.md');
+
+    const result = walkRepositoryFiles({
+      rootDir,
+      fileSuffixes: ['.go', '.tsx', '.md'],
+      includePatterns: ['src/**/*.{go,tsx}', 'docs'],
+      excludePatterns: ['*_test.go'],
+    });
+
+    expect(result.files).toEqual(['docs/guide.md', 'src/api/handler.go', 'src/main.go', 'src/ui/app.tsx']);
+    expect(result.ignoredDirectoryCount).toBe(1);
+  });
+
+  it('should honour ancestor .gitignore files when walking a sub-directory', () => {
+    writeFile('.gitignore', 'secret.ts\n');
+    writeFile('pkg/secret.ts');
+    writeFile('pkg/public.ts');
+
+    const result = walkRepositoryFiles({
+      rootDir,
+      searchDir: path.join(rootDir, 'pkg'),
+      fileSuffixes: ['.ts'],
+    });
+
+    expect(result.files).toEqual(['pkg/public.ts']);
+  });
+
+  it('should honour .codesearchignore with negation patterns', () => {
+    writeFile('.gitignore', 'build/\n');
+    writeFile('.codesearchignore', 'fixtures/*\n!fixtures/keep.ts\n');
+    writeFile('build/out.ts');
+    writeFile('fixtures/drop.ts');
+    writeFile('fixtures/keep.ts');
+
+    const result = walkRepositoryFiles({ rootDir, fileSuffixes: ['.ts'] });
+
+    expect(result.files).toEqual(['fixtures/keep.ts']);
+  });
+
+  it('should skip repository ignore files when useIgnoreFiles is false', () => {
+    writeFile('.gitignore', 'build/\n');
+    writeFile('.codesearchignore', 'docs/\n');
+    writeFile('build/out.ts');
+    writeFile('docs/guide.ts');
+    writeFile('vendor/lib.ts');
+
+    const result = walkRepositoryFiles({
+      rootDir,
+      fileSuffixes: ['.ts'],
+      excludePatterns: ['vendor/'],
+      useIgnoreFiles: false,
+    });
+
+    expect(result.files).toEqual(['build/out.ts', 'docs/guide.ts']);
+  });
+});
+
+describe('createPathFilter', () => {
+  let rootDir: string;
+
+  const writeFile = (relativePath: string, content = '') => {
+    const absolutePath = path.join(rootDir, relativePath);
+    fs.mkdirSync(path.dirname(absolutePath), { recursive: true });
+    fs.writeFileSync(absolutePath, content);
+  };
+
+  beforeEach(() => {
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'path-filter-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should exclude the paths the walk skips, including paths inside excluded directories', () => {
+    writeFile('.gitignore', 'dist/\n');
+    writeFile('pkg/.gitignore', '*.gen.ts\n!keep.gen.ts\n');
+    writeFile('custom.ignore', 'generated/\n');
+
+    const isExcluded = createPathFilter({
+      rootDir,
+      ignoreFiles: [path.join(rootDir, 'custom.ignore')],
+      excludePatterns: ['vendor/**'],
+    });
+
+    expect(isExcluded('src/a.ts')).toBe(false);
+    expect(isExcluded('dist', true)).toBe(true);
+    expect(isExcluded('dist/deep/out.ts')).toBe(true);
+    expect(isExcluded('pkg/api.gen.ts')).toBe(true);
+    expect(isExcluded('pkg/keep.gen.ts')).toBe(false);
+    expect(isExcluded('generated/a.ts')).toBe(true);
+    expect(isExcluded('vendor/lib.ts')).toBe(true);
+    expect(isExcluded('src/a_parser.ts')).toBe(true);
+    expect(isExcluded('.github/workflow.ts')).toBe(true);
+    expect(isExcluded('')).toBe(false);
+  });
+
+  it('should exclude files that do not match the include globs, but not directories', () => {
+    writeFile('.gitignore', 'src/gen/\n');
+
+    const isExcluded = createPathFilter({
+      rootDir,
+      includePatterns: ['src/**/*.g?'],
+      excludePatterns: ['src/vendor/'],
+    });
+
+    expect(isExcluded('src/a.go')).toBe(false);
+    expect(isExcluded('src/deep/b.go')).toBe(false);
+    expect(isExcluded('src/a.ts')).toBe(true);
+    expect(isExcluded('lib/a.go')).toBe(true);
+    expect(isExcluded('lib', true)).toBe(false);
+    expect(isExcluded('src/gen/a.go')).toBe(true);
+    expect(isExcluded('src/vendor/a.go')).toBe(true);
+  });
+
+  it('should only apply the exclude patterns when useIgnoreFiles is false', () => {
+    writeFile('.gitignore', 'dist/\n');
+
+    const isExcluded = createPathFilter({ rootDir, excludePatterns: ['vendor/'], useIgnoreFiles: false });
+
+    expect(isExcluded('dist/out.ts')).toBe(false);
+    expect(isExcluded('vendor/lib.ts')).toBe(true);
+  });
+});
+
+describe('expandBraces', () => {
+  it('should expand each alternative, including nested and repeated groups', () => {
+    expect(expandBraces('src/**/*.{ts,tsx}')).toEqual(['src/**/*.ts', 'src/**/*.tsx']);
+    expect(expandBraces('{a,b}/{c,d}')).toEqual(['a/c', 'a/d', 'b/c', 'b/d']);
+    expect(expandBraces('a{b,c{d,e}}f')).toEqual(['abf', 'acdf', 'acef']);
+  });
+
+  it('should keep patterns without alternatives as written', () => {
+    expect(expandBraces('*.go')).toEqual(['*.go']);
+    expect(expandBraces('x{y}z')).toEqual(['x{y}z']);
+    expect(expandBraces('open{a,b')).toEqual(['open{a,b']);
+  });
+});
+
+describe('filterReadableFiles', () => {
+  let rootDir: string;
+
+  beforeEach(() => {
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'file-filter-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should skip files over the size limit and binary files, whatever their extension', () => {
+    fs.writeFileSync(path.join(rootDir, 'small.ts'), 'export const a = 1;\n');
+    fs.writeFileSync(path.join(rootDir, 'app.min.js'), 'x'.repeat(2048));
+    fs.writeFileSync(path.join(rootDir, 'video.ts'), Buffer.from([0x47, 0x00, 0x11, 0x10]));
+
+    const result = filterReadableFiles(rootDir, ['small.ts', 'app.min.js', 'video.ts', 'missing.ts'], 1024);
+
+    expect(result.files).toEqual(['small.ts', 'missing.ts']);
+    expect(result.skipped).toEqual([
+      { file: 'app.min.js', size: 2048, reason: 'too_large' },
+      { file: 'video.ts', size: 4, reason: 'binary' },
+    ]);
+  });
+
+  it('should only sniff the start of a file for null bytes', () => {
+    fs.writeFileSync(path.join(rootDir, 'late.txt'), Buffer.concat([Buffer.alloc(9000, 'a'), Buffer.from([0])]));
+
+    expect(filterReadableFiles(rootDir, ['late.txt'], 1024 * 1024).files).toEqual(['late.txt']);
+  });
+});
+
+describe('file lists', () => {
+  let rootDir: string;
+
+  beforeEach(() => {
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'file-list-'));
+    fs.mkdirSync(path.join(rootDir, 'src'));
+    fs.writeFileSync(path.join(rootDir, 'src', 'a.ts'), 'export const a = 1;\n');
+    fs.writeFileSync(path.join(rootDir, 'src', 'my file.ts'), 'export const b = 2;\n');
+  });
+
+  afterEach(() => {
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should read one path per line, ignoring empty lines and carriage returns', () => {
+    const listPath = path.join(rootDir, 'files.txt');
+    fs.writeFileSync(listPath, 'src/a.ts\r\n\nsrc/my file.ts\n');
+
+    expect(readFileList(listPath)).toEqual(['src/a.ts', 'src/my file.ts']);
+  });
+
+  it('should resolve listed paths and report the ones that are missing or outside the root', () => {
+    const result = resolveListedFiles(rootDir, path.join(rootDir, 'src'), [
+      'a.ts',
+      path.join(rootDir, 'src', 'my file.ts'),
+      './a.ts',
+      'deleted.ts',
+      '.',
+      '../../outside.ts',
+    ]);
+
+    expect(result.files).toEqual(['src/a.ts', 'src/my file.ts']);
+    expect(result.missing).toEqual(['deleted.ts', '.']);
+    expect(result.outside).toEqual(['../../outside.ts']);
+  });
+});
+
+describe('sampleFiles', () => {
+  const files = Array.from({ length: 1000 }, (_, i) => `src/file_${i}.ts`);
+
+  it('should keep the first files by sorted path in their walk order', () => {
+    expect(sampleFiles(['src/c.ts', 'README.md', 'src/a.ts', 'lib/b.ts'], { limit: 2 })).toEqual([
+      'README.md',
+      'lib/b.ts',
+    ]);
+  });
+
+  it('should draw the same fraction of files for the same seed', () => {
+    const sampled = sampleFiles(files, { sampleRate: 0.1, seed: 7 });
+
+    expect(sampled.length).toBeGreaterThan(70);
+    expect(sampled.length).toBeLessThan(130);
+    expect(sampleFiles(files, { sampleRate: 0.1, seed: 7 })).toEqual(sampled);
+    expect(sampleFiles(files, { sampleRate: 0.1, seed: 8 })).not.toEqual(sampled);
+    const withNewFile = sampleFiles(['src/new.ts', ...files], { sampleRate: 0.1, seed: 7 });
+    expect(withNewFile.filter((file) => file !== 'src/new.ts')).toEqual(sampled);
+  });
+
+  it('should apply the limit to the sampled files', () => {
+    const sampled = sampleFiles(files, { sampleRate: 0.1 });
+
+    const firstPaths = new Set([...sampled].sort().slice(0, 5));
+    expect(sampleFiles(files, { sampleRate: 0.1, limit: 5 })).toEqual(sampled.filter((file) => firstPaths.has(file)));
+    expect(sampleFiles(files, {})).toEqual(files);
+  });
+});
@@ -0,0 +1,244 @@
+import { EventEmitter } from 'events';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect, vi } from 'vitest';
+
+import { FileWatcher, isEditorTempFile, WatchFunction } from '../../src/utils/file_watcher';
+import { createLogger } from '../../src/utils/logger';
+
+type Listener = (eventType: string, fileName: string | null) => void;
+
+/** Fake `fs.watch` that records each watched directory and lets tests emit events on it. */
+function createFakeWatch(options: { failRecursive?: boolean; limit?: number } = {}) {
+  const listeners = new Map<string, Listener>();
+  const closed: string[] = [];
+  const watch = vi.fn((directory: string, watchOptions: { recursive: boolean }, listener: Listener) => {
+    if (watchOptions.recursive && options.failRecursive) {
+      throw Object.assign(new Error('recursive watch not supported'), { code: 'ERR_FEATURE_UNAVAILABLE_ON_PLATFORM' });
+    }
+    if (options.limit !== undefined && listeners.size >= options.limit) {
+      throw Object.assign(new Error('ENOSPC: System limit for number of file watchers reached'), { code: 'ENOSPC' });
+    }
+    listeners.set(directory, listener);
+    const watcher = new EventEmitter() as EventEmitter & { close: () => void };
+    watcher.close = () => {
+      closed.push(directory);
+      listeners.delete(directory);
+    };
+    return watcher as unknown as fs.FSWatcher;
+  });
+  const emit = (directory: string, eventType: string, fileName: string | null) =>
+    listeners.get(directory)?.(eventType, fileName);
+  return { watch: watch as unknown as WatchFunction, listeners, closed, emit };
+}
+
+describe('FileWatcher', () => {
+  let rootDir: string;
+  const logger = {
+    debug: vi.fn(),
+    info: vi.fn(),
+    warn: vi.fn(),
+    error: vi.fn(),
+  } as unknown as ReturnType<typeof createLogger>;
+
+  beforeEach(() => {
+    vi.clearAllMocks();
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'file-watcher-'));
+    fs.mkdirSync(path.join(rootDir, 'src', 'nested'), { recursive: true });
+    fs.mkdirSync(path.join(rootDir, 'node_modules', 'pkg'), { recursive: true });
+    fs.mkdirSync(path.join(rootDir, '.git'));
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should watch each directory that is not excluded', async () => {
+    const fake = createFakeWatch();
+    const watcher = new FileWatcher({
+      rootDir,
+      debounceMs: 10,
+      onChange: vi.fn(),
+      isExcluded: (relativePath) => relativePath === 'node_modules',
+      recursive: false,
+      watch: fake.watch,
+      logger,
+    });
+
+    watcher.start();
+
+    expect(Array.from(fake.listeners.keys()).sort()).toEqual(
+      [rootDir, path.join(rootDir, 'src'), path.join(rootDir, 'src', 'nested')].sort()
+    );
+    await watcher.close();
+    expect(fake.listeners.size).toBe(0);
+    expect(watcher.watchedDirectoryCount).toBe(0);
+  });
+
+  it('should fall back to watching each directory when a recursive watch fails', async () => {
+    const fake = createFakeWatch({ failRecursive: true });
+    const watcher = new FileWatcher({
+      rootDir,
+      debounceMs: 10,
+      onChange: vi.fn(),
+      recursive: true,
+      watch: fake.watch,
+      logger,
+    });
+
+    watcher.start();
+
+    expect(watcher.isRecursive).toBe(false);
+    expect(fake.listeners.has(path.join(rootDir, 'src', 'nested'))).toBe(true);
+    expect(logger.warn).toHaveBeenCalledWith(
+      'Recursive file watching is not available, watching each directory instead.',
+      expect.anything()
+    );
+    await watcher.close();
+  });
+
+  it('should warn once and stop adding watches at the watch limit', async () => {
+    const fake = createFakeWatch({ limit: 1 });
+    const watcher = new FileWatcher({
+      rootDir,
+      debounceMs: 10,
+      onChange: vi.fn(),
+      recursive: false,
+      watch: fake.watch,
+      logger,
+    });
+
+    watcher.start();
+
+    expect(watcher.watchedDirectoryCount).toBe(1);
+    expect(logger.warn).toHaveBeenCalledTimes(1);
+    expect(vi.mocked(logger.warn).mock.calls[0][0]).toContain('fs.inotify.max_user_watches');
+    await watcher.close();
+  });
+
+  it('should debounce rapid events into one sorted batch', async () => {
+    vi.useFakeTimers();
+    const onChange = vi.fn().mockResolvedValue(undefined);
+    const fake = createFakeWatch();
+    const watcher = new FileWatcher({ rootDir, debounceMs: 100, onChange, recursive: true, watch: fake.watch, logger });
+    watcher.start();
+
+    fake.emit(rootDir, 'change', 'src/b.ts');
+    await vi.advanceTimersByTimeAsync(60);
+    fake.emit(rootDir, 'change', 'src/a.ts');
+    await vi.advanceTimersByTimeAsync(60);
+    fake.emit(rootDir, 'rename', 'src/b.ts');
+    expect(onChange).not.toHaveBeenCalled();
+
+    await vi.advanceTimersByTimeAsync(100);
+    expect(onChange).toHaveBeenCalledTimes(1);
+    expect(onChange).toHaveBeenCalledWith(['src/a.ts', 'src/b.ts']);
+    await watcher.close();
+  });
+
+  it('should drop the temporary files of an atomic save and report the saved file', async () => {
+    vi.useFakeTimers();
+    const onChange = vi.fn().mockResolvedValue(undefined);
+    const fake = createFakeWatch();
+    const watcher = new FileWatcher({ rootDir, debounceMs: 10, onChange, recursive: true, watch: fake.watch, logger });
+    watcher.start();
+
+    fake.emit(rootDir, 'rename', 'src/4913');
+    fake.emit(rootDir, 'rename', 'src/.a.ts.swp');
+    fake.emit(rootDir, 'rename', 'src/a.ts~');
+    fake.emit(rootDir, 'rename', 'src/a.ts');
+    fake.emit(rootDir, 'rename', 'src/b.ts___jb_tmp___');
+    await vi.advanceTimersByTimeAsync(10);
+
+    expect(onChange).toHaveBeenCalledTimes(1);
+    expect(onChange).toHaveBeenCalledWith(['src/a.ts']);
+    await watcher.close();
+  });
+
+  it('should hand changes made during a running call to the next one', async () => {
+    vi.useFakeTimers();
+    let finishFirstCall: () => void = () => {};
+    const onChange = vi
+      .fn()
+      .mockImplementationOnce(() => new Promise<void>((resolve) => (finishFirstCall = resolve)))
+      .mockResolvedValue(undefined);
+    const fake = createFakeWatch();
+    const watcher = new FileWatcher({ rootDir, debounceMs: 10, onChange, recursive: true, watch: fake.watch, logger });
+    watcher.start();
+
+    fake.emit(rootDir, 'change', 'src/a.ts');
+    await vi.advanceTimersByTimeAsync(10);
+    fake.emit(rootDir, 'change', 'src/b.ts');
+    await vi.advanceTimersByTimeAsync(50);
+    expect(onChange).toHaveBeenCalledTimes(1);
+
+    finishFirstCall();
+    await vi.advanceTimersByTimeAsync(10);
+    expect(onChange).toHaveBeenCalledTimes(2);
+    expect(onChange).toHaveBeenLastCalledWith(['src/b.ts']);
+    await watcher.close();
+  });
+
+  it('should watch new directories and close the watches of removed ones', async () => {
+    vi.useFakeTimers();
+    const onChange = vi.fn().mockResolvedValue(undefined);
+    const fake = createFakeWatch();
+    const watcher = new FileWatcher({
+      rootDir,
+      debounceMs: 10,
+      onChange,
+      isExcluded: (relativePath) => relativePath.startsWith('node_modules'),
+      recursive: false,
+      watch: fake.watch,
+      logger,
+    });
+    watcher.start();
+
+    fs.mkdirSync(path.join(rootDir, 'lib', 'deep'), { recursive: true });
+    fake.emit(rootDir, 'rename', 'lib');
+    expect(fake.listeners.has(path.join(rootDir, 'lib', 'deep'))).toBe(true);
+
+    fs.rmSync(path.join(rootDir, 'src'), { recursive: true });
+    fake.emit(rootDir, 'rename', 'src');
+    expect(fake.closed).toEqual(
+      expect.arrayContaining([path.join(rootDir, 'src'), path.join(rootDir, 'src', 'nested')])
+    );
+
+    fake.emit(rootDir, 'change', 'node_modules');
+    await vi.advanceTimersByTimeAsync(10);
+    expect(onChange).toHaveBeenCalledWith(['lib', 'src']);
+    await watcher.close();
+  });
+
+  it('should drop pending changes on close', async () => {
+    vi.useFakeTimers();
+    const onChange = vi.fn().mockResolvedValue(undefined);
+    const fake = createFakeWatch();
+    const watcher = new FileWatcher({ rootDir, debounceMs: 10, onChange, recursive: true, watch: fake.watch, logger });
+    watcher.start();
+
+    fake.emit(rootDir, 'change', 'src/a.ts');
+    await watcher.close();
+    await vi.advanceTimersByTimeAsync(50);
+
+    expect(onChange).not.toHaveBeenCalled();
+    expect(fake.closed).toEqual([rootDir]);
+  });
+});
+
+describe('isEditorTempFile', () => {
+  it('should match the swap, backup, lock and temporary files of common editors', () => {
+    const names = ['.a.ts.swp', '.a.ts.swo', 'a.ts~', '.#a.ts', '#a.ts#', '4913', 'a.ts___jb_old___', 'a.ts.tmp'];
+    for (const name of names) {
+      expect(isEditorTempFile(`src/${name}`)).toBe(true);
+    }
+  });
+
+  it('should not match source files', () => {
+    for (const name of ['a.ts', 'swap.go', 'README.md', '.eslintrc.js', 'v4913.ts']) {
+      expect(isEditorTempFile(`src/${name}`)).toBe(false);
+    }
+  });
+});
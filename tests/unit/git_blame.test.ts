@@ -0,0 +1,76 @@
+import { execFileSync } from 'child_process';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { blameChunks, parseBlamePorcelain } from '../../src/utils/git_blame';
+
+describe('blameChunks', () => {
+  let repoDir: string;
+
+  beforeEach(() => {
+    repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'git-blame-'));
+    const commit = (author: string, date: string) =>
+      execFileSync('git', ['-c', `user.name=${author}`, '-c', 'user.email=test@example.com', 'commit', '-qam', date], {
+        cwd: repoDir,
+        stdio: 'ignore',
+        env: { ...process.env, GIT_COMMITTER_DATE: date },
+      });
+    execFileSync('git', ['init', '-q'], { cwd: repoDir, stdio: 'ignore' });
+    fs.writeFileSync(path.join(repoDir, 'greet.go'), 'package demo\n\nfunc greet() {\n\tprintln("hi")\n}\n');
+    execFileSync('git', ['add', '-A'], { cwd: repoDir, stdio: 'ignore' });
+    commit('Ann', '2024-01-01T00:00:00Z');
+    fs.writeFileSync(path.join(repoDir, 'greet.go'), 'package demo\n\nfunc greet() {\n\tprintln("hello")\n}\n');
+    commit('Bo', '2025-06-01T12:00:00Z');
+  });
+
+  afterEach(() => {
+    fs.rmSync(repoDir, { recursive: true, force: true });
+  });
+
+  it('should return the latest commit of each line range from one blame of the file', () => {
+    fs.appendFileSync(path.join(repoDir, 'greet.go'), '\nfunc wave() {}\n');
+
+    expect(
+      blameChunks(path.join(repoDir, 'greet.go'), [
+        { startLine: 1, endLine: 1 },
+        { startLine: 3, endLine: 5 },
+        { startLine: 7, endLine: 7 },
+      ])
+    ).toEqual([
+      { last_modified: '2024-01-01T00:00:00.000Z', last_author: 'Ann' },
+      { last_modified: '2025-06-01T12:00:00.000Z', last_author: 'Bo' },
+      // Lines that are not committed yet have no commit to report.
+      { last_modified: null, last_author: null },
+    ]);
+  });
+
+  it('should leave the fields null for files git does not track', () => {
+    fs.writeFileSync(path.join(repoDir, 'untracked.go'), 'package demo\n');
+
+    expect(blameChunks(path.join(repoDir, 'untracked.go'), [{ startLine: 1, endLine: 1 }])).toEqual([
+      { last_modified: null, last_author: null },
+    ]);
+  });
+});
+
+describe('parseBlamePorcelain', () => {
+  it('should reuse the headers of a commit for its later lines', () => {
+    const sha = 'a'.repeat(40);
+    const output = [
+      `${sha} 1 1 2`,
+      'author Ann',
+      'committer-time 1704067200',
+      'filename greet.go',
+      '\tpackage demo',
+      `${sha} 2 2`,
+      'filename greet.go',
+      '\t',
+    ].join('\n');
+
+    const lines = parseBlamePorcelain(output);
+    expect(lines.get(2)).toEqual({ author: 'Ann', committerTime: 1704067200 });
+    expect(lines.get(2)).toBe(lines.get(1));
+  });
+});
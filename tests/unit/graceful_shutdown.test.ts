@@ -0,0 +1,33 @@
+import { afterEach, describe, it, expect, vi } from 'vitest';
+import { drainForShutdown, isShutdownRequested, resetShutdownRequested } from '../../src/utils/graceful_shutdown';
+import { drainIndexerWorkers } from '../../src/utils/indexer_worker';
+import { drainProducerPools } from '../../src/utils/producer_pool';
+
+vi.mock('../../src/utils/indexer_worker', () => ({ drainIndexerWorkers: vi.fn() }));
+vi.mock('../../src/utils/producer_pool', () => ({ drainProducerPools: vi.fn() }));
+
+describe('drainForShutdown', () => {
+  afterEach(() => {
+    resetShutdownRequested();
+    vi.clearAllMocks();
+  });
+
+  it('should flag the shutdown and drain producers and indexing workers', async () => {
+    vi.mocked(drainIndexerWorkers).mockResolvedValue(undefined);
+    vi.mocked(drainProducerPools).mockResolvedValue(undefined);
+
+    expect(isShutdownRequested()).toBe(false);
+    await expect(drainForShutdown(1000)).resolves.toBe(true);
+
+    expect(isShutdownRequested()).toBe(true);
+    expect(drainProducerPools).toHaveBeenCalledWith(1000);
+    expect(drainIndexerWorkers).toHaveBeenCalledTimes(1);
+  });
+
+  it('should give up when in-flight work outlasts the timeout', async () => {
+    vi.mocked(drainIndexerWorkers).mockReturnValue(new Promise<void>(() => {}));
+    vi.mocked(drainProducerPools).mockResolvedValue(undefined);
+
+    await expect(drainForShutdown(20)).resolves.toBe(false);
+  });
+});
@@ -1,14 +1,20 @@
 import { incrementalIndex } from '../../src/commands/incremental_index_command';
+import { index as fullIndex } from '../../src/commands/full_index_producer';
 import * as elasticsearch from '../../src/utils/elasticsearch';
 import simpleGit from 'simple-git';
 import { IQueueWithEnqueueMetadata } from '../../src/utils/queue';
 import { SqliteQueue } from '../../src/utils/sqlite_queue';
 import { Worker } from 'worker_threads';
 import { beforeEach, describe, it, expect, vi } from 'vitest';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { hashFileContent } from '../../src/utils/manifest';
 
 vi.mock('simple-git');
 vi.mock('../../src/utils/elasticsearch');
 vi.mock('../../src/utils/git_helper');
+vi.mock('../../src/commands/full_index_producer');
 
 vi.mock('../../src/utils/sqlite_queue', () => {
   const MockSqliteQueue = vi.fn();
@@ -59,6 +65,14 @@ describe('incrementalIndex', () => {
       setEnqueueCommitHash: vi.fn(),
       getEnqueueCommitHash: vi.fn().mockReturnValue(null),
       isEnqueueCompleted: vi.fn().mockReturnValue(true),
+      getEnqueuedFiles: vi.fn().mockReturnValue(new Map()),
+      getFileHashes: vi.fn().mockReturnValue(new Map()),
+      getIndexedFiles: vi.fn().mockReturnValue([]),
+      deleteFileHashes: vi.fn(),
+      clearFileHashes: vi.fn(),
+      markLocationsStale: vi.fn(),
+      recordParseFailure: vi.fn(),
+      clearParseFailures: vi.fn(),
     };
 
     mockedSqliteQueue.mockImplementation(function () {
@@ -119,13 +133,15 @@ describe('incrementalIndex', () => {
 
     await incrementalIndex('/test/repo', { queueDir: '.test-queue', elasticsearchIndex: 'test-index' });
 
-    // Deletes are batched: old rename path, modified file, and deleted file are removed in one call.
+    // Deletes are batched: the old rename path and the deleted file are removed in one call.
     expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledTimes(1);
     expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledWith(
-      expect.arrayContaining(['src/old_file.ts', 'src/modified_file.ts', 'src/deleted_file.ts']),
+      expect.arrayContaining(['src/old_file.ts', 'src/deleted_file.ts']),
       'test-index',
       expect.objectContaining({ deleteDocumentsPageSize: undefined })
     );
+    // The modified file is re-indexed in place and only its stale locations are pruned afterwards.
+    expect(workQueue.markLocationsStale).toHaveBeenCalledWith(['src/modified_file.ts'], expect.any(Number));
 
     // Ensure we didn't attempt to delete paths that should only be indexed.
     const deleteArgs = (mockedElasticsearch.deleteDocumentsByFilePaths as unknown as { mock: { calls: unknown[][] } })
@@ -134,9 +150,22 @@ describe('incrementalIndex', () => {
       expect.arrayContaining(['src/added_file.ts', 'src/new_file.ts', 'src/copied_file.ts'])
     );
     expect(deleteArgs).not.toEqual(expect.arrayContaining(['src/original_file.ts']));
+    expect(deleteArgs).not.toContain('src/modified_file.ts');
 
     // Verify that parsing workers are created (pooling may reuse workers)
     expect(mockedWorker).toHaveBeenCalled();
+    // Chunks are tagged with the repository and the commit the files were read at.
+    expect(mockedWorker).toHaveBeenCalledWith(
+      expect.any(String),
+      expect.objectContaining({
+        workerData: expect.objectContaining({ repoName: 'repo', repoRoot: '/test/repo', commitSha: 'new-commit-hash' }),
+      })
+    );
+    expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledWith(
+      expect.any(Array),
+      'test-index',
+      expect.objectContaining({ repoName: 'repo' })
+    );
 
     const indexedFiles = postedMessages.map((msg) => msg.relativePath);
     expect(indexedFiles).toHaveLength(4);
@@ -145,4 +174,436 @@ describe('incrementalIndex', () => {
     expect(indexedFiles).toContain('src/added_file.ts');
     expect(indexedFiles).toContain('src/modified_file.ts');
   });
+
+  it('should only re-index a sample of the added and modified files and still delete removed ones', async () => {
+    const gitDiffOutput = ['R100\tsrc/old_file.ts\tsrc/new_file.ts', 'A\tsrc/added_file.ts', 'M\tsrc/modified_file.ts'];
+    const git = {
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('/test/repo') // gitRoot
+        .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+      diff: vi.fn().mockResolvedValue(gitDiffOutput.join('\n')),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+
+    await incrementalIndex('/test/repo', {
+      queueDir: '.test-queue',
+      elasticsearchIndex: 'test-index',
+      sample: { limit: 2 },
+    });
+
+    expect(postedMessages.map((msg) => msg.relativePath).sort()).toEqual(['src/added_file.ts', 'src/modified_file.ts']);
+    expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledWith(
+      ['src/old_file.ts'],
+      'test-index',
+      expect.any(Object)
+    );
+  });
+
+  it('should fail after parsing every file with failOnParseError and leave the enqueue incomplete', async () => {
+    const git = {
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('/test/repo') // gitRoot
+        .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+      diff: vi.fn().mockResolvedValue(['A\tsrc/broken.ts', 'A\tsrc/fine.ts'].join('\n')),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+    mockedWorker.mockImplementation(function () {
+      let onMessage: (message: unknown) => void = () => {};
+      const worker = {
+        on: vi.fn((event, cb) => {
+          if (event === 'message') {
+            onMessage = cb;
+          }
+          return worker;
+        }),
+        postMessage: vi.fn((message: { relativePath: string }) => {
+          postedMessages.push(message);
+          const failed = message.relativePath === 'src/broken.ts';
+          setTimeout(() => onMessage(failed ? { status: 'failure', error: 'unexpected node' } : { status: 'success' }));
+        }),
+        terminate: vi.fn(),
+        ref: vi.fn(),
+        unref: vi.fn(),
+      };
+      return worker;
+    });
+
+    await expect(
+      incrementalIndex('/test/repo', {
+        queueDir: '.test-queue',
+        elasticsearchIndex: 'test-index',
+        failOnParseError: true,
+      })
+    ).rejects.toThrow('1 files failed to parse with --fail-on-parse-error: src/broken.ts (unexpected node)');
+    expect(postedMessages.map((msg) => msg.relativePath)).toEqual(['src/broken.ts', 'src/fine.ts']);
+    expect(workQueue.markEnqueueCompleted).not.toHaveBeenCalled();
+  });
+
+  describe('prune', () => {
+    const runInRepo = async (options: { prune?: boolean; pruneDryRun?: boolean }) => {
+      const repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'incremental-missing-'));
+      fs.mkdirSync(path.join(repoDir, 'src'));
+      fs.writeFileSync(path.join(repoDir, 'src', 'present.ts'), 'export const a = 1;');
+      fs.writeFileSync(path.join(repoDir, 'src', 'modified_file.ts'), 'export const b = 2;');
+      // Still on disk, but excluded by an ignore file added since the last run.
+      fs.writeFileSync(path.join(repoDir, 'src', 'ignored.ts'), 'export const c = 3;');
+      fs.writeFileSync(path.join(repoDir, '.indexerignore'), 'src/ignored.ts\n');
+
+      try {
+        const git = {
+          revparse: vi
+            .fn()
+            .mockResolvedValueOnce('main') // gitBranch
+            .mockResolvedValueOnce(repoDir) // gitRoot
+            .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+          diff: vi.fn().mockResolvedValue('M\tsrc/modified_file.ts'),
+        } as unknown as ReturnType<typeof simpleGit>;
+        mockedSimpleGit.mockReturnValue(git);
+        mockedElasticsearch.getIndexedFilePaths.mockResolvedValue(
+          new Set(['src/present.ts', 'src/modified_file.ts', 'src/ignored.ts', 'src/gone.ts'])
+        );
+
+        await incrementalIndex(repoDir, { queueDir: '.test-queue', elasticsearchIndex: 'test-index', ...options });
+        return repoDir;
+      } finally {
+        fs.rmSync(repoDir, { recursive: true, force: true });
+      }
+    };
+
+    it('should delete indexed files that no longer exist on disk', async () => {
+      const repoDir = await runInRepo({ prune: true });
+
+      expect(mockedElasticsearch.getIndexedFilePaths).toHaveBeenCalledWith('test-index', {
+        branch: 'main',
+        repoName: path.basename(repoDir),
+      });
+      expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledTimes(1);
+      expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledWith(
+        ['src/gone.ts'],
+        'test-index',
+        expect.objectContaining({ deleteDocumentsPageSize: undefined })
+      );
+      expect(workQueue.deleteFileHashes).toHaveBeenCalledWith(['src/gone.ts']);
+    });
+
+    it('should only report the files to delete with pruneDryRun', async () => {
+      await runInRepo({ prune: true, pruneDryRun: true });
+
+      expect(mockedElasticsearch.getIndexedFilePaths).toHaveBeenCalled();
+      expect(mockedElasticsearch.deleteDocumentsByFilePaths).not.toHaveBeenCalled();
+      expect(workQueue.deleteFileHashes).toHaveBeenCalledWith([]);
+    });
+
+    it('should not look for missing files without prune', async () => {
+      await runInRepo({});
+
+      expect(mockedElasticsearch.getIndexedFilePaths).not.toHaveBeenCalled();
+      expect(mockedElasticsearch.deleteDocumentsByFilePaths).not.toHaveBeenCalled();
+    });
+  });
+
+  it('should not delete anything when the repository root cannot be read', async () => {
+    const git = {
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('/does/not/exist') // gitRoot
+        .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+      diff: vi.fn().mockResolvedValue(''),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+    mockedElasticsearch.getIndexedFilePaths.mockResolvedValue(new Set(['src/gone.ts']));
+
+    await incrementalIndex('/does/not/exist', {
+      queueDir: '.test-queue',
+      elasticsearchIndex: 'test-index',
+      prune: true,
+    });
+
+    expect(mockedElasticsearch.getIndexedFilePaths).not.toHaveBeenCalled();
+    expect(mockedElasticsearch.deleteDocumentsByFilePaths).not.toHaveBeenCalled();
+  });
+
+  describe('content hash skip', () => {
+    const runWithUnchangedFile = async (force: boolean) => {
+      const repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'incremental-hash-'));
+      fs.mkdirSync(path.join(repoDir, 'src'));
+      fs.writeFileSync(path.join(repoDir, 'src', 'same.ts'), 'export const a = 1;');
+      fs.writeFileSync(path.join(repoDir, 'src', 'changed.ts'), 'export const b = 2;');
+      vi.mocked(workQueue.getFileHashes).mockReturnValue(
+        new Map([
+          ['src/same.ts', hashFileContent(path.join(repoDir, 'src', 'same.ts'))],
+          ['src/changed.ts', 'stale-hash'],
+        ])
+      );
+
+      try {
+        const git = {
+          revparse: vi
+            .fn()
+            .mockResolvedValueOnce('main') // gitBranch
+            .mockResolvedValueOnce(repoDir) // gitRoot
+            .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+          diff: vi.fn().mockResolvedValue('M\tsrc/same.ts\nM\tsrc/changed.ts'),
+        } as unknown as ReturnType<typeof simpleGit>;
+        mockedSimpleGit.mockReturnValue(git);
+        mockedElasticsearch.getIndexedFilePaths.mockResolvedValue(new Set(['src/same.ts', 'src/changed.ts']));
+
+        await incrementalIndex(repoDir, { queueDir: '.test-queue', elasticsearchIndex: 'test-index', force });
+      } finally {
+        fs.rmSync(repoDir, { recursive: true, force: true });
+      }
+    };
+
+    it('should not prune or re-parse files whose content hash is unchanged', async () => {
+      await runWithUnchangedFile(false);
+
+      expect(postedMessages.map((msg) => msg.relativePath)).toEqual(['src/changed.ts']);
+      expect(workQueue.markLocationsStale).toHaveBeenCalledWith(['src/changed.ts'], expect.any(Number));
+      expect(mockedElasticsearch.deleteDocumentsByFilePaths).not.toHaveBeenCalled();
+    });
+
+    it('should re-parse unchanged files with --force', async () => {
+      await runWithUnchangedFile(true);
+
+      expect(postedMessages.map((msg) => msg.relativePath).sort()).toEqual(['src/changed.ts', 'src/same.ts']);
+    });
+  });
+
+  it('should re-index and remove files that drifted from their indexed state outside of the diff', async () => {
+    const repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'incremental-drift-'));
+    fs.mkdirSync(path.join(repoDir, 'src'));
+    fs.writeFileSync(path.join(repoDir, 'src', 'same.ts'), 'export const a = 1;');
+    fs.writeFileSync(path.join(repoDir, 'src', 'edited.ts'), 'export const b = 2;');
+    const indexedFile = (filePath: string, sha256: string) => ({
+      filePath,
+      sha256,
+      indexedAt: '2025-01-01 00:00:00',
+      chunkCount: 1,
+      indexName: 'test-index',
+    });
+    vi.mocked(workQueue.getIndexedFiles).mockReturnValue([
+      indexedFile('src/same.ts', hashFileContent(path.join(repoDir, 'src', 'same.ts'))),
+      indexedFile('src/edited.ts', 'stale-hash'),
+      indexedFile('src/removed.ts', 'removed-hash'),
+    ]);
+
+    try {
+      const git = {
+        revparse: vi
+          .fn()
+          .mockResolvedValueOnce('main') // gitBranch
+          .mockResolvedValueOnce(repoDir) // gitRoot
+          .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+        diff: vi.fn().mockResolvedValue(''),
+      } as unknown as ReturnType<typeof simpleGit>;
+      mockedSimpleGit.mockReturnValue(git);
+
+      await incrementalIndex(repoDir, { queueDir: '.test-queue', elasticsearchIndex: 'test-index' });
+    } finally {
+      fs.rmSync(repoDir, { recursive: true, force: true });
+    }
+
+    expect(workQueue.getIndexedFiles).toHaveBeenCalledWith('test-index');
+    expect(postedMessages.map((msg) => msg.relativePath)).toEqual(['src/edited.ts']);
+    expect(workQueue.markLocationsStale).toHaveBeenCalledWith(['src/edited.ts'], expect.any(Number));
+    expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledWith(
+      ['src/removed.ts'],
+      'test-index',
+      expect.anything()
+    );
+  });
+
+  it('should mark modified files with the locations they keep with --smart-incremental', async () => {
+    const repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'incremental-smart-'));
+    fs.mkdirSync(path.join(repoDir, 'src'));
+    fs.writeFileSync(path.join(repoDir, 'src', 'changed.ts'), 'export const b = 3;');
+    fs.writeFileSync(path.join(repoDir, 'src', 'renamed.ts'), 'export const c = 4;');
+    mockedElasticsearch.getExistingLocations.mockResolvedValue(new Map());
+    const hunks = [
+      'diff --git src/changed.ts src/changed.ts',
+      '--- src/changed.ts',
+      '+++ src/changed.ts',
+      '@@ -1 +1 @@',
+    ];
+
+    try {
+      const git = {
+        revparse: vi
+          .fn()
+          .mockResolvedValueOnce('main') // gitBranch
+          .mockResolvedValueOnce(repoDir) // gitRoot
+          .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+        diff: vi
+          .fn()
+          .mockResolvedValueOnce('M\tsrc/changed.ts\nM\tsrc/renamed.ts')
+          .mockResolvedValueOnce(hunks.join('\n')),
+        remote: vi.fn().mockResolvedValue('https://github.com/test/repo.git'),
+        pull: vi.fn().mockResolvedValue(undefined),
+      } as unknown as ReturnType<typeof simpleGit>;
+      mockedSimpleGit.mockReturnValue(git);
+      mockedElasticsearch.getLastIndexedCommit.mockResolvedValue('old-commit-hash');
+
+      await incrementalIndex(repoDir, {
+        queueDir: '.test-queue',
+        elasticsearchIndex: 'test-index',
+        smartIncremental: true,
+      });
+
+      expect(git.diff).toHaveBeenLastCalledWith(expect.arrayContaining(['-U0', 'old-commit-hash..HEAD']));
+      // A file without hunks, such as one whose path git quotes, has all its stale locations pruned.
+      expect(workQueue.markLocationsStale).toHaveBeenCalledWith(['src/renamed.ts'], expect.any(Number));
+      expect(workQueue.markLocationsStale).toHaveBeenCalledWith(['src/changed.ts'], expect.any(Number), []);
+    } finally {
+      fs.rmSync(repoDir, { recursive: true, force: true });
+    }
+  });
+
+  it('should diff against the --since ref instead of the last indexed commit', async () => {
+    const git = {
+      checkIsRepo: vi.fn().mockResolvedValue(true),
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('since-commit-hash\n') // --since ref
+        .mockResolvedValueOnce('/does/not/exist') // gitRoot
+        .mockResolvedValueOnce('new-commit-hash'), // newCommitHash
+      diff: vi.fn().mockResolvedValue('D\tsrc/deleted_file.ts'),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+
+    await incrementalIndex('/does/not/exist', {
+      queueDir: '.test-queue',
+      elasticsearchIndex: 'test-index',
+      since: 'v1.0.0',
+    });
+
+    expect(git.revparse).toHaveBeenCalledWith(['--verify', '--quiet', 'v1.0.0^{commit}']);
+    expect(git.diff).toHaveBeenCalledWith(['--name-status', 'since-commit-hash..HEAD']);
+    expect(mockedElasticsearch.getLastIndexedCommit).not.toHaveBeenCalled();
+    expect(mockedElasticsearch.deleteDocumentsByFilePaths).toHaveBeenCalledWith(
+      ['src/deleted_file.ts'],
+      'test-index',
+      expect.anything()
+    );
+    expect(fullIndex).not.toHaveBeenCalled();
+  });
+
+  it('should diff up to the --until ref when it is the checked-out commit', async () => {
+    const git = {
+      checkIsRepo: vi.fn().mockResolvedValue(true),
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('since-commit-hash\n') // --since ref
+        .mockResolvedValueOnce('until-commit-hash\n') // --until ref
+        .mockResolvedValueOnce('until-commit-hash') // HEAD, checked against --until
+        .mockResolvedValueOnce('/does/not/exist') // gitRoot
+        .mockResolvedValueOnce('until-commit-hash'), // newCommitHash
+      diff: vi.fn().mockResolvedValue('D\tsrc/deleted_file.ts'),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+
+    await incrementalIndex('/does/not/exist', {
+      queueDir: '.test-queue',
+      elasticsearchIndex: 'test-index',
+      since: 'base-sha',
+      until: 'head-sha',
+    });
+
+    expect(git.revparse).toHaveBeenCalledWith(['--verify', '--quiet', 'head-sha^{commit}']);
+    expect(git.diff).toHaveBeenCalledWith(['--name-status', 'since-commit-hash..until-commit-hash']);
+  });
+
+  it('should throw when the --until ref is not the checked-out commit', async () => {
+    const git = {
+      checkIsRepo: vi.fn().mockResolvedValue(true),
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('since-commit-hash') // --since ref
+        .mockResolvedValueOnce('until-commit-hash') // --until ref
+        .mockResolvedValueOnce('head-commit-hash'), // HEAD
+      diff: vi.fn(),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+
+    await expect(
+      incrementalIndex('/test/repo', {
+        queueDir: '.test-queue',
+        elasticsearchIndex: 'test-index',
+        since: 'base-sha',
+        until: 'other-sha',
+      })
+    ).rejects.toThrow(
+      '--until other-sha (until-commit-hash) is not the checked-out commit (head-commit-hash). ' +
+        'Check it out first: changed files are read from the working tree.'
+    );
+    expect(git.diff).not.toHaveBeenCalled();
+  });
+
+  it('should throw when the --until ref does not resolve to a commit', async () => {
+    const git = {
+      checkIsRepo: vi.fn().mockResolvedValue(true),
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockResolvedValueOnce('since-commit-hash') // --since ref
+        .mockRejectedValueOnce(new Error('fatal: Needed a single revision')), // --until ref
+      diff: vi.fn(),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+
+    await expect(
+      incrementalIndex('/test/repo', {
+        queueDir: '.test-queue',
+        elasticsearchIndex: 'test-index',
+        since: 'base-sha',
+        until: 'no-such-ref',
+      })
+    ).rejects.toThrow('Invalid --until value: no-such-ref does not resolve to a commit.');
+    expect(fullIndex).not.toHaveBeenCalled();
+  });
+
+  it('should fall back to a full index when the --since ref is invalid', async () => {
+    const git = {
+      checkIsRepo: vi.fn().mockResolvedValue(true),
+      revparse: vi
+        .fn()
+        .mockResolvedValueOnce('main') // gitBranch
+        .mockRejectedValueOnce(new Error('fatal: Needed a single revision')), // --since ref
+      diff: vi.fn(),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+    const options = { queueDir: '.test-queue', elasticsearchIndex: 'test-index', since: 'no-such-ref' };
+
+    await incrementalIndex('/test/repo', options);
+
+    expect(fullIndex).toHaveBeenCalledWith('/test/repo', false, options);
+    expect(git.diff).not.toHaveBeenCalled();
+  });
+
+  it('should fall back to a full index when the directory is not a git repository', async () => {
+    const git = {
+      checkIsRepo: vi.fn().mockResolvedValue(false),
+      revparse: vi.fn(),
+      diff: vi.fn(),
+    } as unknown as ReturnType<typeof simpleGit>;
+    mockedSimpleGit.mockReturnValue(git);
+
+    await incrementalIndex('/test/dir', {
+      queueDir: '.test-queue',
+      elasticsearchIndex: 'test-index',
+      branch: 'main',
+      since: 'HEAD~1',
+    });
+
+    expect(fullIndex).toHaveBeenCalledWith('/test/dir', false, expect.objectContaining({ since: 'HEAD~1' }));
+    expect(git.revparse).not.toHaveBeenCalled();
+    expect(git.diff).not.toHaveBeenCalled();
+  });
 });
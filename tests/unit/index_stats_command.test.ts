@@ -0,0 +1,63 @@
+import { describe, it, expect } from 'vitest';
+
+import { formatIndexStats, formatTable } from '../../src/commands/index_stats_command';
+import type { IndexStats } from '../../src/utils/elasticsearch';
+
+describe('formatTable', () => {
+  it('should pad the columns and right-align columns of numbers', () => {
+    expect(
+      formatTable([
+        ['Language', 'Chunks'],
+        ['typescript', '1200'],
+        ['go', '35'],
+      ])
+    ).toBe(['Language    Chunks', 'typescript    1200', 'go              35'].join('\n'));
+  });
+});
+
+describe('formatIndexStats', () => {
+  const stats: IndexStats = {
+    index: 'code-search',
+    chunks: 200,
+    locations: 240,
+    files: 30,
+    byLanguage: [{ key: 'typescript', count: 200 }],
+    bySymbolKind: [
+      { key: 'function', count: 150 },
+      { key: 'none', count: 50 },
+    ],
+    estimatedSizes: 0,
+    largestChunks: [
+      {
+        chunkId: 'chunk-big',
+        tokens: 3100,
+        symbolName: 'fixtures.data',
+        location: { filePath: 'src/fixtures.ts', startLine: 1, endLine: 900 },
+        locations: 2,
+      },
+    ],
+    filesWithMostChunks: [{ filePath: 'src/generated.ts', chunks: 120 }],
+    chunkSizes: [
+      { from: 0, to: 64, count: 199 },
+      { from: 2048, to: null, count: 1 },
+    ],
+  };
+
+  it('should print a table per aggregation with the share of the chunks', () => {
+    const output = formatIndexStats(stats);
+
+    expect(output).toContain('Index code-search: 200 chunks at 240 locations in 30 files');
+    expect(output).toContain('function        150  75.0%');
+    expect(output).toContain('0-63       199  99.5%');
+    expect(output).toContain('2048+        1   0.5%');
+    expect(output).toContain('src/fixtures.ts:1-900    3100          2  fixtures.data');
+    expect(output).toContain('src/generated.ts               120');
+    expect(output).not.toContain('estimated');
+  });
+
+  it('should say when sizes are estimated', () => {
+    expect(formatIndexStats({ ...stats, estimatedSizes: 200 })).toContain(
+      'Sizes of 200 chunks are estimated from their length'
+    );
+  });
+});
@@ -0,0 +1,95 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { readIndexStatus } from '../../src/commands/index_status_command';
+import { CodeChunk } from '../../src/utils/elasticsearch';
+import { hashContent } from '../../src/utils/manifest';
+import { SqliteQueue } from '../../src/utils/sqlite_queue';
+
+const chunk = (filePath: string): CodeChunk => ({
+  type: 'code',
+  language: 'typescript',
+  filePath,
+  directoryPath: 'src',
+  directoryName: 'src',
+  directoryDepth: 1,
+  git_file_hash: `hash-${filePath}`,
+  git_branch: 'main',
+  chunk_hash: `chunk-${filePath}`,
+  startLine: 1,
+  endLine: 1,
+  content: 'export const a = 1;',
+  semantic_text: 'export const a = 1;',
+  created_at: new Date().toISOString(),
+  updated_at: new Date().toISOString(),
+});
+
+describe('readIndexStatus', () => {
+  let repoPath: string;
+  let queue: SqliteQueue;
+
+  beforeEach(async () => {
+    repoPath = fs.mkdtempSync(path.join(os.tmpdir(), 'index-status-'));
+    fs.mkdirSync(path.join(repoPath, 'src'));
+    queue = new SqliteQueue({ dbPath: path.join(repoPath, '.queue', 'queue.db') });
+    await queue.initialize();
+  });
+
+  afterEach(() => {
+    queue.close();
+    fs.rmSync(repoPath, { recursive: true, force: true });
+  });
+
+  it('should report what is indexed, what drifted on disk and what is still queued', async () => {
+    fs.writeFileSync(path.join(repoPath, 'src', 'same.ts'), 'export const a = 1;');
+    fs.writeFileSync(path.join(repoPath, 'src', 'edited.ts'), 'export const b = 3;');
+    const indexName = 'code-search';
+    await queue.enqueue([chunk('src/same.ts')], {
+      sourceFile: { filePath: 'src/same.ts', sha256: hashContent('export const a = 1;'), indexName },
+    });
+    await queue.enqueue([chunk('src/edited.ts')], {
+      sourceFile: { filePath: 'src/edited.ts', sha256: hashContent('export const b = 2;'), indexName },
+    });
+    await queue.enqueue([], { sourceFile: { filePath: 'src/removed.ts', sha256: 'removed', indexName } });
+    await queue.commit(await queue.dequeue(2));
+    await queue.enqueue([chunk('src/new.ts')], { sourceFile: { filePath: 'src/new.ts', sha256: 'new', indexName } });
+
+    const status = readIndexStatus(queue, { repoName: 'repo', repoPath, indexName });
+
+    expect(status).toMatchObject({
+      indexedFiles: 3,
+      indexedChunks: 2,
+      lastIndexedAt: expect.any(String),
+      deletedFiles: ['src/removed.ts'],
+      changedFiles: ['src/edited.ts'],
+      queuedFiles: 1,
+      queuedDocuments: 1,
+      deadLetteredDocuments: 0,
+      parseFailedFiles: 0,
+    });
+    expect(status).not.toHaveProperty('parseFailures');
+  });
+
+  it('should list the files whose last parse failed when asked', () => {
+    queue.recordParseFailure({ file: 'src/broken.ts', error: 'Unexpected token', language: 'typescript' });
+
+    const status = readIndexStatus(
+      queue,
+      { repoName: 'repo', repoPath, indexName: 'code-search' },
+      { parseFailures: true }
+    );
+
+    expect(status.parseFailedFiles).toBe(1);
+    expect(status.parseFailures).toEqual([
+      {
+        filePath: 'src/broken.ts',
+        language: 'typescript',
+        error: 'Unexpected token',
+        wholeFile: false,
+        failedAt: expect.any(String),
+      },
+    ]);
+  });
+});
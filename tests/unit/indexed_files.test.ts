@@ -0,0 +1,42 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { diffIndexedFiles } from '../../src/utils/indexed_files';
+import { hashContent } from '../../src/utils/manifest';
+import { IndexedFile } from '../../src/utils/queue';
+
+const indexedFile = (filePath: string, content: string): IndexedFile => ({
+  filePath,
+  sha256: hashContent(content),
+  indexedAt: '2025-01-01 00:00:00',
+  chunkCount: 1,
+  indexName: 'code-search',
+});
+
+describe('diffIndexedFiles', () => {
+  let rootDir: string;
+
+  beforeEach(() => {
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'indexed-files-'));
+    fs.mkdirSync(path.join(rootDir, 'src'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should list the files deleted or changed since they were indexed', () => {
+    fs.writeFileSync(path.join(rootDir, 'src', 'same.ts'), 'export const a = 1;');
+    fs.writeFileSync(path.join(rootDir, 'src', 'edited.ts'), 'export const b = 3;');
+
+    const drift = diffIndexedFiles(rootDir, [
+      indexedFile('src/same.ts', 'export const a = 1;'),
+      indexedFile('src/edited.ts', 'export const b = 2;'),
+      indexedFile('src/removed.ts', 'export const c = 4;'),
+    ]);
+
+    expect(drift).toEqual({ deleted: ['src/removed.ts'], changed: ['src/edited.ts'], unchanged: 1 });
+  });
+});
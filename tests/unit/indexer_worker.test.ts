@@ -1,15 +1,27 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { MeterProvider } from '@opentelemetry/sdk-metrics';
 import { describe, it, expect, beforeEach, afterEach, vi } from 'vitest';
-import { IndexerWorker } from '../../src/utils/indexer_worker';
+import { drainIndexerWorkers, IndexerWorker } from '../../src/utils/indexer_worker';
 import { InMemoryQueue } from '../../src/utils/in_memory_queue';
+import { SqliteQueue } from '../../src/utils/sqlite_queue';
 import * as elasticsearch from '../../src/utils/elasticsearch';
-import { CodeChunk, BulkIndexResult } from '../../src/utils/elasticsearch';
+import { CodeChunk, BulkIndexResult, PreparedCodeChunks } from '../../src/utils/elasticsearch';
+import { EmbeddingProvider } from '../../src/utils/embedding_provider';
 import { logger } from '../../src/utils/logger';
+import * as otelProvider from '../../src/utils/otel_provider';
+import { PrometheusMetricReader } from '../../src/utils/prometheus_exporter';
+import { pauseIndexing, resetPauseControl, resumeIndexing, setPauseFile } from '../../src/utils/pause_control';
 
 vi.mock('../../src/utils/elasticsearch', async () => {
   const actual = await vi.importActual('../../src/utils/elasticsearch');
   return {
     ...actual,
     indexCodeChunks: vi.fn(),
+    prepareCodeChunks: vi.fn(),
+    bulkIndexPreparedChunks: vi.fn(),
+    deleteStaleLocations: vi.fn(),
   };
 });
 
@@ -58,6 +70,7 @@ describe('IndexerWorker', () => {
     if (concurrentWorker) {
       concurrentWorker.stop();
     }
+    resetPauseControl();
     vi.clearAllTimers();
     vi.useRealTimers();
   });
@@ -80,7 +93,12 @@ describe('IndexerWorker', () => {
 
     await concurrentWorker.start();
 
-    expect(elasticsearch.indexCodeChunks).toHaveBeenCalledWith([MOCK_CHUNK], testIndex);
+    expect(elasticsearch.indexCodeChunks).toHaveBeenCalledWith([MOCK_CHUNK], testIndex, {
+      embeddingProvider: undefined,
+      dedup: true,
+      storeCompressed: false,
+      storeContent: true,
+    });
     expect(commitSpy).toHaveBeenCalled();
   });
 
@@ -218,6 +236,7 @@ describe('IndexerWorker', () => {
     const requeuedDocs = requeueSpy.mock.calls[0][0];
     expect(requeuedDocs).toHaveLength(1);
     expect(requeuedDocs[0].document.chunk_hash).toBe(MOCK_CHUNK.chunk_hash);
+    expect(requeueSpy.mock.calls[0][1]?.errors?.get(requeuedDocs[0].id)).toBe('mapper_parsing_exception');
     // After requeue, it succeeds
     expect(commitSpy).toHaveBeenCalled();
   });
@@ -352,4 +371,445 @@ describe('IndexerWorker', () => {
     expect(requeuedDocs).toHaveLength(1);
     expect(requeuedDocs[0].document.chunk_hash).toBe('bad_chunk');
   });
+
+  it('should halve the bulk size and retry only rejected documents on a 429', async () => {
+    const chunks = Array.from({ length: 8 }, (_, i) => ({ ...MOCK_CHUNK, chunk_hash: `chunk_${i}` }));
+
+    concurrentWorker = new IndexerWorker({
+      queue,
+      batchSize: 8,
+      bulkMinSize: 2,
+      concurrency: 1,
+      watch: false,
+      logger,
+      elasticsearchIndex: testIndex,
+    });
+
+    await queue.enqueue(chunks);
+    const requeueSpy = vi.spyOn(queue, 'requeue');
+
+    // First call: Elasticsearch rejects the second half of the batch. Later calls succeed.
+    let callCount = 0;
+    vi.mocked(elasticsearch.indexCodeChunks).mockImplementation(async (inputChunks) => {
+      callCount++;
+      if (callCount === 1) {
+        return {
+          succeeded: inputChunks.slice(0, 4).map((chunk, inputIndex) => ({ chunk, inputIndex })),
+          failed: inputChunks.slice(4).map((chunk, i) => ({
+            chunk,
+            inputIndex: i + 4,
+            error: { type: 'es_rejected_execution_exception', status: 429 },
+          })),
+        };
+      }
+      return successResult(inputChunks);
+    });
+
+    await concurrentWorker.start();
+
+    const batchSizes = vi.mocked(elasticsearch.indexCodeChunks).mock.calls.map(([inputChunks]) => inputChunks.length);
+    expect(batchSizes).toEqual([8, 4]);
+    expect(requeueSpy).toHaveBeenCalledTimes(1);
+    expect(requeueSpy.mock.calls[0][0].map((doc) => doc.document.chunk_hash)).toEqual([
+      'chunk_4',
+      'chunk_5',
+      'chunk_6',
+      'chunk_7',
+    ]);
+  });
+
+  it('should shrink the bulk size after a bulk request slower than the target latency', async () => {
+    const chunks = Array.from({ length: 7 }, (_, i) => ({ ...MOCK_CHUNK, chunk_hash: `chunk_${i}` }));
+    process.env.SCS_IDXR_BULK_TARGET_LATENCY_MS = '5';
+    try {
+      concurrentWorker = new IndexerWorker({
+        queue,
+        batchSize: 4,
+        bulkMinSize: 1,
+        concurrency: 1,
+        watch: false,
+        logger,
+        elasticsearchIndex: testIndex,
+      });
+    } finally {
+      delete process.env.SCS_IDXR_BULK_TARGET_LATENCY_MS;
+    }
+
+    await queue.enqueue(chunks);
+    vi.mocked(elasticsearch.indexCodeChunks).mockImplementation(async (inputChunks) => {
+      await new Promise((resolve) => setTimeout(resolve, 20));
+      return successResult(inputChunks);
+    });
+
+    await concurrentWorker.start();
+
+    const batchSizes = vi.mocked(elasticsearch.indexCodeChunks).mock.calls.map(([inputChunks]) => inputChunks.length);
+    expect(batchSizes).toEqual([4, 3]);
+  });
+
+  it('should start a restarted worker from the configured bulk size', async () => {
+    const rejected = { type: 'es_rejected_execution_exception', status: 429 };
+    concurrentWorker = new IndexerWorker({
+      queue,
+      batchSize: 4,
+      bulkMinSize: 1,
+      concurrency: 1,
+      watch: false,
+      logger,
+      elasticsearchIndex: testIndex,
+    });
+
+    let callCount = 0;
+    vi.mocked(elasticsearch.indexCodeChunks).mockImplementation(async (inputChunks) => {
+      callCount++;
+      return callCount === 1 ? failedResult(inputChunks, rejected) : successResult(inputChunks);
+    });
+    await queue.enqueue(Array.from({ length: 4 }, (_, i) => ({ ...MOCK_CHUNK, chunk_hash: `first_${i}` })));
+    await concurrentWorker.start();
+    await queue.enqueue(Array.from({ length: 4 }, (_, i) => ({ ...MOCK_CHUNK, chunk_hash: `second_${i}` })));
+    await concurrentWorker.start();
+
+    const batchSizes = vi.mocked(elasticsearch.indexCodeChunks).mock.calls.map(([inputChunks]) => inputChunks.length);
+    // The first run shrank to 2 after the rejection; the second run starts at 4 again.
+    expect(batchSizes.slice(0, 3)).toEqual([4, 2, 2]);
+    expect(batchSizes[3]).toBe(4);
+  });
+
+  it('should commit in-flight batches and stop dequeuing when drained', async () => {
+    concurrentWorker = new IndexerWorker({
+      queue,
+      batchSize: 1,
+      concurrency: 2,
+      watch: true,
+      logger,
+      elasticsearchIndex: testIndex,
+    });
+
+    const chunks = Array.from({ length: 5 }, (_, i) => ({ ...MOCK_CHUNK, chunk_hash: `chunk_${i}` }));
+    await queue.enqueue(chunks);
+    const commitSpy = vi.spyOn(queue, 'commit');
+
+    let releaseBatches: () => void = () => {};
+    const batchesReleased = new Promise<void>((resolve) => (releaseBatches = resolve));
+    vi.mocked(elasticsearch.indexCodeChunks).mockImplementation(async (inputChunks) => {
+      await batchesReleased;
+      return successResult(inputChunks);
+    });
+
+    const started = concurrentWorker.start();
+    await vi.waitFor(() => expect(elasticsearch.indexCodeChunks).toHaveBeenCalledTimes(2));
+
+    const drained = drainIndexerWorkers();
+    releaseBatches();
+    await drained;
+    await started;
+
+    expect(elasticsearch.indexCodeChunks).toHaveBeenCalledTimes(2);
+    expect(commitSpy).toHaveBeenCalledTimes(2);
+  });
+
+  it('should finish the in-flight batch but dequeue nothing else while paused', async () => {
+    concurrentWorker = new IndexerWorker({
+      queue,
+      batchSize: 1,
+      concurrency: 1,
+      watch: false,
+      logger,
+      elasticsearchIndex: testIndex,
+    });
+
+    const chunks = Array.from({ length: 3 }, (_, i) => ({ ...MOCK_CHUNK, chunk_hash: `chunk_${i}` }));
+    await queue.enqueue(chunks);
+    const commitSpy = vi.spyOn(queue, 'commit');
+
+    let releaseFirst: () => void = () => {};
+    const firstReleased = new Promise<void>((resolve) => (releaseFirst = resolve));
+    vi.mocked(elasticsearch.indexCodeChunks).mockImplementation(async (inputChunks) => {
+      await firstReleased;
+      return successResult(inputChunks);
+    });
+
+    const started = concurrentWorker.start();
+    await vi.waitFor(() => expect(elasticsearch.indexCodeChunks).toHaveBeenCalledTimes(1));
+    pauseIndexing();
+    releaseFirst();
+    await vi.waitFor(() => expect(commitSpy).toHaveBeenCalledTimes(1));
+    await new Promise((resolve) => setTimeout(resolve, 50));
+    expect(elasticsearch.indexCodeChunks).toHaveBeenCalledTimes(1);
+
+    resumeIndexing();
+    await started;
+
+    const indexed = vi.mocked(elasticsearch.indexCodeChunks).mock.calls.map(([batch]) => batch[0].chunk_hash);
+    expect(indexed).toEqual(['chunk_0', 'chunk_1', 'chunk_2']);
+  });
+
+  it('should wait while the pause file exists instead of finishing', async () => {
+    const pauseDir = fs.mkdtempSync(path.join(os.tmpdir(), 'pause-file-'));
+    const pauseFile = path.join(pauseDir, 'paused');
+    fs.writeFileSync(pauseFile, '');
+    setPauseFile(pauseFile);
+    try {
+      concurrentWorker = new IndexerWorker({
+        queue,
+        batchSize: 10,
+        concurrency: 1,
+        watch: false,
+        logger,
+        elasticsearchIndex: testIndex,
+      });
+      await queue.enqueue([MOCK_CHUNK]);
+      vi.mocked(elasticsearch.indexCodeChunks).mockResolvedValue(successResult([MOCK_CHUNK]));
+
+      let finished = false;
+      const started = concurrentWorker.start().then(() => (finished = true));
+      await new Promise((resolve) => setTimeout(resolve, 50));
+      expect(finished).toBe(false);
+      expect(elasticsearch.indexCodeChunks).not.toHaveBeenCalled();
+
+      fs.rmSync(pauseFile);
+      await started;
+      expect(elasticsearch.indexCodeChunks).toHaveBeenCalledTimes(1);
+    } finally {
+      fs.rmSync(pauseDir, { recursive: true, force: true });
+    }
+  });
+
+  it('should collect the chunks replaced by overwritten symbol locations instead of deleting them', async () => {
+    concurrentWorker = new IndexerWorker({
+      queue,
+      batchSize: 1,
+      concurrency: 1,
+      watch: false,
+      logger,
+      elasticsearchIndex: testIndex,
+    });
+    await queue.enqueue([MOCK_CHUNK, { ...MOCK_CHUNK, chunk_hash: 'chunk_hash_2' }]);
+    vi.mocked(elasticsearch.indexCodeChunks)
+      .mockResolvedValueOnce({ ...successResult([MOCK_CHUNK]), replacedChunkIds: ['old-1', 'old-2'] })
+      .mockResolvedValueOnce({ ...successResult([MOCK_CHUNK]), replacedChunkIds: ['old-2'] });
+
+    await concurrentWorker.start();
+
+    expect(concurrentWorker.getReplacedChunkIds()).toEqual(['old-1', 'old-2']);
+  });
+
+  it('should report the gauges of each queue shard with its shard attribute', async () => {
+    const reader = new PrometheusMetricReader(0);
+    const meterProvider = new MeterProvider({ readers: [reader] });
+    const meterProviderSpy = vi.spyOn(otelProvider, 'getMeterProvider').mockReturnValue(meterProvider);
+    try {
+      const workers = [0, 1].map(
+        (shard) =>
+          new IndexerWorker({
+            queue: new InMemoryQueue(),
+            batchSize: 10 * (shard + 1),
+            watch: true,
+            logger,
+            elasticsearchIndex: testIndex,
+            repoInfo: { name: 'repo', branch: 'main' },
+            shard,
+          })
+      );
+      const started = workers.map((worker) => worker.start());
+
+      const { resourceMetrics } = await reader.collect();
+      const observed = (name: string) =>
+        resourceMetrics.scopeMetrics
+          .flatMap((scope) => scope.metrics)
+          .filter((metric) => metric.descriptor.name === name)
+          .flatMap((metric) => metric.dataPoints)
+          .map(({ attributes, value }) => ({ shard: attributes['shard'], value }));
+
+      // One series per shard, so a sum over them covers the whole repository.
+      expect(observed('indexer.bulk.size.current')).toEqual([
+        { shard: 0, value: 10 },
+        { shard: 1, value: 20 },
+      ]);
+      expect(observed('indexer.throughput.current').map(({ shard }) => shard)).toEqual([0, 1]);
+
+      workers.forEach((worker) => worker.stop());
+      await Promise.all(started);
+    } finally {
+      meterProviderSpy.mockRestore();
+      await meterProvider.shutdown();
+    }
+  });
+
+  it('should prune stale locations of re-indexed files once their documents are indexed', async () => {
+    const queueDir = fs.mkdtempSync(path.join(os.tmpdir(), 'indexer-worker-prune-'));
+    const sqliteQueue = new SqliteQueue({ dbPath: path.join(queueDir, 'queue.db') });
+    await sqliteQueue.initialize();
+    try {
+      await sqliteQueue.markLocationsStale(['test.ts'], 1000);
+      await sqliteQueue.enqueue([MOCK_CHUNK]);
+      vi.mocked(elasticsearch.indexCodeChunks).mockResolvedValue(successResult([MOCK_CHUNK]));
+      vi.mocked(elasticsearch.deleteStaleLocations).mockResolvedValue(undefined);
+
+      concurrentWorker = new IndexerWorker({
+        queue: sqliteQueue,
+        batchSize: 10,
+        watch: false,
+        logger,
+        elasticsearchIndex: testIndex,
+        repoInfo: { name: 'repo', branch: 'main' },
+      });
+      await concurrentWorker.start();
+
+      expect(elasticsearch.deleteStaleLocations).toHaveBeenCalledWith(['test.ts'], testIndex, {
+        indexedBefore: 1000,
+        repoName: 'repo',
+      });
+      expect(sqliteQueue.getPrunableLocationFiles().size).toBe(0);
+    } finally {
+      sqliteQueue.close();
+      fs.rmSync(queueDir, { recursive: true, force: true });
+    }
+  });
+});
+
+describe('IndexerWorker embedding and bulk stages', () => {
+  const testIndex = 'test-index';
+  const embeddingProvider: EmbeddingProvider = { dimensions: 3, embed: async () => [] };
+  const sleep = (ms: number) => new Promise((resolve) => setTimeout(resolve, ms));
+
+  /** One chunk per fixture file, the corpus every run below indexes. */
+  const fixtureChunks = (): CodeChunk[] => {
+    const fixturesDir = path.join(__dirname, '../fixtures');
+    return fs
+      .readdirSync(fixturesDir)
+      .sort()
+      .map((fileName) => {
+        const content = fs.readFileSync(path.join(fixturesDir, fileName), 'utf8');
+        return { ...MOCK_CHUNK, filePath: fileName, chunk_hash: fileName, content, semantic_text: content };
+      });
+  };
+
+  /** Runs `fn`, counting the calls of a stage running at once. */
+  const track = async <T>(counter: { current: number; max: number }, fn: () => Promise<T>): Promise<T> => {
+    counter.current++;
+    counter.max = Math.max(counter.max, counter.current);
+    try {
+      return await fn();
+    } finally {
+      counter.current--;
+    }
+  };
+
+  beforeEach(() => {
+    vi.useRealTimers();
+    vi.mocked(elasticsearch.indexCodeChunks).mockReset();
+    vi.mocked(elasticsearch.prepareCodeChunks).mockReset();
+    vi.mocked(elasticsearch.bulkIndexPreparedChunks).mockReset();
+  });
+
+  it('should limit the batches embedded and bulk indexed at once separately', async () => {
+    const queue = new InMemoryQueue();
+    const chunks = fixtureChunks();
+    await queue.enqueue(chunks);
+    const embedding = { current: 0, max: 0 };
+    const bulk = { current: 0, max: 0 };
+    vi.mocked(elasticsearch.prepareCodeChunks).mockImplementation((inputChunks) =>
+      track(embedding, async () => {
+        await sleep(10);
+        return { chunks: inputChunks } as PreparedCodeChunks;
+      })
+    );
+    vi.mocked(elasticsearch.bulkIndexPreparedChunks).mockImplementation((prepared) =>
+      track(bulk, async () => {
+        await sleep(20);
+        return successResult(prepared.chunks);
+      })
+    );
+    const commitSpy = vi.spyOn(queue, 'commit');
+
+    await new IndexerWorker({
+      queue,
+      batchSize: 2,
+      concurrency: 1,
+      embedConcurrency: 3,
+      indexConcurrency: 2,
+      watch: false,
+      logger,
+      elasticsearchIndex: testIndex,
+      embeddingProvider,
+    }).start();
+
+    expect(embedding.max).toBe(3);
+    expect(bulk.max).toBe(2);
+    expect(elasticsearch.indexCodeChunks).not.toHaveBeenCalled();
+    expect(commitSpy.mock.calls.flatMap(([docs]) => docs)).toHaveLength(chunks.length);
+  });
+
+  it('should requeue a batch whose embedding failed', async () => {
+    const queue = new InMemoryQueue();
+    await queue.enqueue([MOCK_CHUNK]);
+    const embedError = { type: 'embedding_error' };
+    vi.mocked(elasticsearch.prepareCodeChunks).mockResolvedValue({
+      chunks: [MOCK_CHUNK],
+      embedError,
+    } as PreparedCodeChunks);
+    vi.mocked(elasticsearch.bulkIndexPreparedChunks)
+      .mockResolvedValueOnce(failedResult([MOCK_CHUNK], embedError))
+      .mockResolvedValue(successResult([MOCK_CHUNK]));
+    const requeueSpy = vi.spyOn(queue, 'requeue');
+
+    await new IndexerWorker({
+      queue,
+      batchSize: 10,
+      indexConcurrency: 1,
+      watch: false,
+      logger,
+      elasticsearchIndex: testIndex,
+      embeddingProvider,
+    }).start();
+
+    expect(requeueSpy).toHaveBeenCalledTimes(1);
+    expect(elasticsearch.bulkIndexPreparedChunks).toHaveBeenCalledTimes(2);
+  });
+
+  // Benchmark: the fixture corpus against an embedding endpoint and a cluster that each take 25ms
+  // per batch and serve one request at a time in both runs.
+  it('should index the fixture corpus faster when embedding overlaps bulk requests', async () => {
+    const stageMs = 25;
+    const embed = () => sleep(stageMs);
+    const bulkIndex = () => sleep(stageMs);
+
+    const run = async (stages: boolean) => {
+      const queue = new InMemoryQueue();
+      await queue.enqueue(fixtureChunks());
+      const startTime = Date.now();
+      await new IndexerWorker({
+        queue,
+        batchSize: 2,
+        concurrency: 1,
+        ...(stages ? { embedConcurrency: 1, indexConcurrency: 1 } : {}),
+        watch: false,
+        logger,
+        elasticsearchIndex: testIndex,
+        embeddingProvider,
+      }).start();
+      return Date.now() - startTime;
+    };
+
+    vi.mocked(elasticsearch.indexCodeChunks).mockImplementation(async (inputChunks) => {
+      await embed();
+      await bulkIndex();
+      return successResult(inputChunks);
+    });
+    const sequentialMs = await run(false);
+
+    vi.mocked(elasticsearch.prepareCodeChunks).mockImplementation(async (inputChunks) => {
+      await embed();
+      return { chunks: inputChunks } as PreparedCodeChunks;
+    });
+    vi.mocked(elasticsearch.bulkIndexPreparedChunks).mockImplementation(async (prepared) => {
+      await bulkIndex();
+      return successResult(prepared.chunks);
+    });
+    const pipelinedMs = await run(true);
+
+    // Sequential batches take two stage times each, overlapping ones about one.
+    expect(pipelinedMs).toBeLessThan(sequentialMs * 0.75);
+  });
 });
@@ -0,0 +1,157 @@
+import { inspectFailuresCommand } from '../../src/commands/inspect_failures_command';
+import { SqliteQueue } from '../../src/utils/sqlite_queue';
+import { CodeChunk } from '../../src/utils/elasticsearch';
+import { appConfig } from '../../src/config';
+import path from 'path';
+import fs from 'fs';
+import Database from 'better-sqlite3';
+import { beforeEach, afterEach, describe, it, expect, vi } from 'vitest';
+
+// Mock the config to use a temporary directory
+vi.mock('../../src/config', () => ({
+  appConfig: {
+    queueBaseDir: './.test-queues-inspect',
+  },
+  otelConfig: {
+    enabled: false,
+    serviceName: 'test-service',
+    endpoint: 'http://localhost:4318',
+    headers: '',
+    metricsEnabled: false,
+    metricsEndpoint: 'http://localhost:4318',
+    metricExportIntervalMs: 60000,
+  },
+}));
+
+// Mock the logger to prevent console output during tests
+vi.mock('../../src/utils/logger', () => ({
+  createLogger: vi.fn(() => ({
+    info: vi.fn(),
+    error: vi.fn(),
+    warn: vi.fn(),
+    debug: vi.fn(),
+  })),
+  logger: {
+    info: vi.fn(),
+    error: vi.fn(),
+    warn: vi.fn(),
+  },
+}));
+
+const MOCK_CHUNK_1: CodeChunk = {
+  type: 'code',
+  language: 'typescript',
+  filePath: 'test1.ts',
+  directoryPath: '',
+  directoryName: '',
+  directoryDepth: 0,
+  git_file_hash: 'hash1',
+  git_branch: 'main',
+  chunk_hash: 'chunk_hash_1',
+  startLine: 1,
+  endLine: 1,
+  content: 'const a = 1;',
+  semantic_text: 'const a = 1;',
+  created_at: new Date().toISOString(),
+  updated_at: new Date().toISOString(),
+};
+
+const MOCK_CHUNK_2: CodeChunk = {
+  type: 'code',
+  language: 'typescript',
+  filePath: 'test2.ts',
+  directoryPath: '',
+  directoryName: '',
+  directoryDepth: 0,
+  git_file_hash: 'hash2',
+  git_branch: 'main',
+  chunk_hash: 'chunk_hash_2',
+  startLine: 1,
+  endLine: 1,
+  content: 'const b = 2;',
+  semantic_text: 'const b = 2;',
+  created_at: new Date().toISOString(),
+  updated_at: new Date().toISOString(),
+};
+
+describe('inspectFailuresCommand', () => {
+  const repoName = 'test-repo';
+  const queueDir = path.join(appConfig.queueBaseDir, repoName);
+  const dbPath = path.join(queueDir, 'queue.db');
+  let queue: SqliteQueue;
+
+  const runCommand = async (args: string[]): Promise<string> => {
+    const output: string[] = [];
+    const logSpy = vi.spyOn(console, 'log').mockImplementation((message?: unknown) => {
+      output.push(String(message));
+    });
+    try {
+      await inspectFailuresCommand.parseAsync(['', '', '--repo-name', repoName, ...args]);
+    } finally {
+      logSpy.mockRestore();
+    }
+    return output.join('\n');
+  };
+
+  beforeEach(async () => {
+    fs.mkdirSync(queueDir, { recursive: true });
+
+    queue = new SqliteQueue({ dbPath });
+    await queue.initialize();
+    await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+
+    // Rows in the legacy `failed` status are moved to the dead-letter table when the command opens the queue.
+    const db = new Database(dbPath);
+    db.exec(`
+      UPDATE queue
+      SET status = 'failed',
+          retry_count = 2,
+          last_error = 'mapper_parsing_exception: failed to parse',
+          last_error_at = '2024-01-01 00:00:00'
+    `);
+    db.close();
+
+    // Commander keeps option values between parseAsync calls.
+    inspectFailuresCommand.setOptionValue('json', undefined);
+    inspectFailuresCommand.setOptionValue('requeue', undefined);
+    inspectFailuresCommand.setOptionValue('path', undefined);
+  });
+
+  afterEach(() => {
+    queue.close();
+    fs.rmSync(appConfig.queueBaseDir, { recursive: true, force: true });
+  });
+
+  it('should print failures with attempts and the last error as JSON', async () => {
+    const output = JSON.parse(await runCommand(['--json']));
+
+    expect(output.repoName).toBe(repoName);
+    expect(output.failures).toEqual([
+      {
+        id: 1,
+        filePath: 'test1.ts',
+        attempts: 3,
+        lastError: 'mapper_parsing_exception: failed to parse',
+        lastErrorAt: '2024-01-01 00:00:00',
+        deadLetteredAt: expect.any(String),
+      },
+      expect.objectContaining({ id: 2, filePath: 'test2.ts' }),
+    ]);
+  });
+
+  it('should requeue only the failures matching --path', async () => {
+    await runCommand(['--requeue', '--path', 'test2.ts']);
+
+    const db = new Database(dbPath);
+    const rows = db.prepare('SELECT id, status, retry_count FROM queue ORDER BY id').all() as {
+      id: number;
+      status: string;
+      retry_count: number;
+    }[];
+    const deadLetterIds = db.prepare('SELECT id FROM dead_letter ORDER BY id').all();
+    db.close();
+
+    expect(rows).toEqual([{ id: 2, status: 'pending', retry_count: 0 }]);
+    expect(deadLetterIds).toEqual([{ id: 1 }]);
+  });
+});
@@ -1,4 +1,14 @@
-import { createLogger, logger } from '../../src/utils/logger';
+import {
+  addLogSecret,
+  createLogger,
+  forwardLogs,
+  LogEntry,
+  logger,
+  parseLogLevels,
+  runWithLogContext,
+  setLogPhase,
+  writeLogEntry,
+} from '../../src/utils/logger';
 import { beforeEach, afterEach, describe, it, expect, vi } from 'vitest';
 import type { Mock } from 'vitest';
 
@@ -50,6 +60,15 @@ describe('Logger', () => {
         const logOutput = consoleLogSpy.mock.calls[0][0];
         expect(logOutput).toMatch(/^\[\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z\]/);
       });
+
+      it('redacts registered secrets from the message and metadata', () => {
+        addLogSecret('c2VjcmV0LWFwaS1rZXk=');
+        logger.warn('Request with ApiKey c2VjcmV0LWFwaS1rZXk= failed', { header: 'ApiKey c2VjcmV0LWFwaS1rZXk=' });
+
+        const logOutput = consoleLogSpy.mock.calls[0][0];
+        expect(logOutput).not.toContain('c2VjcmV0LWFwaS1rZXk=');
+        expect(logOutput).toContain('Request with ApiKey *** failed {"header":"ApiKey ***"}');
+      });
     });
 
     describe('when NODE_ENV is `test`', () => {
@@ -66,6 +85,141 @@ describe('Logger', () => {
     });
   });
 
+  describe('JSON format', () => {
+    beforeEach(() => {
+      process.env.NODE_ENV = 'production';
+      process.env.SCS_IDXR_OTEL_LOGGING_ENABLED = 'false';
+      process.env.SCS_IDXR_LOG_FORMAT = 'json';
+    });
+
+    afterEach(() => {
+      setLogPhase(undefined);
+      forwardLogs(undefined);
+    });
+
+    it('outputs one JSON object with the level, timestamp, phase, repository and metadata', () => {
+      setLogPhase('enqueue');
+      createLogger({ name: 'kibana', branch: 'main' }).warn('test message', { files: 3 });
+
+      expect(JSON.parse(consoleLogSpy.mock.calls[0][0])).toEqual({
+        level: 'warn',
+        ts: expect.stringMatching(/^\d{4}-\d{2}-\d{2}T/),
+        phase: 'enqueue',
+        message: 'test message',
+        repo: 'kibana',
+        branch: 'main',
+        files: 3,
+      });
+    });
+
+    it('lets a phase in the metadata override the current phase', () => {
+      setLogPhase('bulk');
+      logger.error('embedding failed', { phase: 'embed' });
+
+      expect(JSON.parse(consoleLogSpy.mock.calls[0][0])).toMatchObject({ phase: 'embed', message: 'embedding failed' });
+    });
+
+    it('does not let metadata override the fixed fields', () => {
+      logger.info('test message', { level: 'debug', message: 'other' });
+
+      expect(JSON.parse(consoleLogSpy.mock.calls[0][0])).toMatchObject({ level: 'info', message: 'test message' });
+    });
+  });
+
+  describe('forwarding', () => {
+    beforeEach(() => {
+      process.env.NODE_ENV = 'production';
+      process.env.SCS_IDXR_OTEL_LOGGING_ENABLED = 'false';
+    });
+
+    afterEach(() => {
+      setLogPhase(undefined);
+      forwardLogs(undefined);
+    });
+
+    it('hands entries to the forwarder instead of writing them', () => {
+      const entries: LogEntry[] = [];
+      forwardLogs((entry) => entries.push(entry));
+
+      logger.info('parsed', { file: 'a.ts' });
+
+      expect(consoleLogSpy).not.toHaveBeenCalled();
+      expect(entries).toEqual([expect.objectContaining({ message: 'parsed', metadata: { file: 'a.ts' } })]);
+    });
+
+    it('writes forwarded entries with the phase of the receiving thread', () => {
+      process.env.SCS_IDXR_LOG_FORMAT = 'json';
+      const entries: LogEntry[] = [];
+      forwardLogs((entry) => entries.push(entry));
+      logger.info('parsed');
+      forwardLogs(undefined);
+
+      setLogPhase('enqueue');
+      writeLogEntry(entries[0]);
+
+      expect(JSON.parse(consoleLogSpy.mock.calls[0][0])).toMatchObject({ phase: 'enqueue', message: 'parsed' });
+    });
+  });
+
+  describe('levels and context', () => {
+    beforeEach(() => {
+      process.env.NODE_ENV = 'production';
+      process.env.SCS_IDXR_OTEL_LOGGING_ENABLED = 'false';
+    });
+
+    it('parses a level and module overrides', () => {
+      expect(parseLogLevels('WARN, queue=error,elasticsearch=debug')).toEqual({
+        level: 'warn',
+        modules: { queue: 'error', elasticsearch: 'debug' },
+      });
+      expect(parseLogLevels('')).toEqual({ level: 'info', modules: {} });
+      expect(() => parseLogLevels('verbose')).toThrow('Unknown log level "verbose"');
+    });
+
+    it('drops debug lines by default', () => {
+      logger.debug('debug message');
+
+      expect(consoleLogSpy).not.toHaveBeenCalled();
+    });
+
+    it('applies the level of a module to the lines of its loggers', () => {
+      process.env.SCS_IDXR_LOG_LEVEL = 'warn,elasticsearch=debug';
+      const queueLogger = createLogger(undefined, { module: 'queue' });
+      const esLogger = createLogger(undefined, { module: 'elasticsearch' });
+
+      queueLogger.info('enqueued');
+      queueLogger.warn('requeued');
+      esLogger.debug('bulk request');
+      logger.info('started');
+
+      expect(consoleLogSpy.mock.calls.map((call) => call[0])).toEqual([
+        expect.stringMatching(/\[WARN\] \[queue\] requeued$/),
+        expect.stringMatching(/\[DEBUG\] \[elasticsearch\] bulk request$/),
+      ]);
+    });
+
+    it('attaches the fields of child loggers and of the surrounding context', async () => {
+      process.env.SCS_IDXR_LOG_FORMAT = 'json';
+      const workerLogger = createLogger({ name: 'kibana', branch: 'main' }).child({ module: 'worker', workerId: '42' });
+
+      await runWithLogContext({ batchId: 'b1', workerId: 'other' }, async () => {
+        await Promise.resolve();
+        workerLogger.info('indexed', { documents: 2 });
+      });
+      workerLogger.info('finished');
+
+      expect(JSON.parse(consoleLogSpy.mock.calls[0][0])).toMatchObject({
+        module: 'worker',
+        message: 'indexed',
+        repo: 'kibana',
+        workerId: '42',
+        batchId: 'b1',
+        documents: 2,
+      });
+      expect(JSON.parse(consoleLogSpy.mock.calls[1][0])).not.toHaveProperty('batchId');
+    });
+  });
+
   describe('log levels', () => {
     beforeEach(() => {
       process.env.NODE_ENV = 'production';
@@ -121,6 +275,10 @@ describe('Logger', () => {
     });
 
     describe('.debug()', () => {
+      beforeEach(() => {
+        process.env.SCS_IDXR_LOG_LEVEL = 'debug';
+      });
+
       it('outputs with DEBUG level', () => {
         logger.debug('debug message');
 
@@ -0,0 +1,95 @@
+import {
+  createManifest,
+  loadManifest,
+  readManifest,
+  recordManifestEntry,
+  resolveManifestPath,
+  writeManifest,
+} from '../../src/utils/manifest';
+import { createHash } from 'crypto';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+describe('manifest', () => {
+  let tempDir: string;
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'manifest-test-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  it('should resolve the manifest path from the CLI option', () => {
+    expect(resolveManifestPath(undefined, '/queues/repo')).toBeUndefined();
+    expect(resolveManifestPath(true, '/queues/repo')).toBe(path.join('/queues/repo', 'manifest.json'));
+    expect(resolveManifestPath('/tmp/out.json', '/queues/repo')).toBe(path.resolve('/tmp/out.json'));
+  });
+
+  it('should record the content hash, chunk count and document id range', () => {
+    const filePath = path.join(tempDir, 'a.ts');
+    fs.writeFileSync(filePath, 'export const a = 1;');
+    const manifest = createManifest('repo', 'main');
+
+    recordManifestEntry(manifest, 'src/a.ts', filePath, 2, { firstId: 10, lastId: 11 });
+    recordManifestEntry(manifest, 'src/empty.ts', filePath, 0, undefined);
+
+    expect(manifest.files['src/a.ts']).toEqual({
+      sha256: createHash('sha256').update('export const a = 1;').digest('hex'),
+      chunkCount: 2,
+      enqueuedAt: expect.any(String),
+      firstDocumentId: 10,
+      lastDocumentId: 11,
+    });
+    expect(manifest.files['src/empty.ts']).not.toHaveProperty('firstDocumentId');
+  });
+
+  it('should write a manifest and load it back for merging', () => {
+    const manifestPath = path.join(tempDir, 'nested', 'manifest.json');
+    const manifest = createManifest('repo', 'main');
+    manifest.commitHash = 'abc123';
+    manifest.files['b.ts'] = { sha256: 'b', chunkCount: 1, enqueuedAt: '2024-01-01T00:00:00.000Z' };
+    manifest.files['a.ts'] = { sha256: 'a', chunkCount: 1, enqueuedAt: '2024-01-01T00:00:00.000Z' };
+
+    writeManifest(manifestPath, manifest);
+    const loaded = loadManifest(manifestPath, 'repo', 'main');
+
+    expect(Object.keys(loaded.files)).toEqual(['a.ts', 'b.ts']);
+    expect(loaded.commitHash).toBe('abc123');
+    expect(fs.readdirSync(path.dirname(manifestPath))).toEqual(['manifest.json']);
+  });
+
+  it('should start from an empty manifest when the file is missing or invalid', () => {
+    const manifestPath = path.join(tempDir, 'manifest.json');
+    expect(loadManifest(manifestPath, 'repo', 'main').files).toEqual({});
+
+    fs.writeFileSync(manifestPath, 'not json');
+    expect(loadManifest(manifestPath, 'repo', 'main').files).toEqual({});
+  });
+
+  it('should read a manifest with the repository and branch it records', () => {
+    const manifestPath = path.join(tempDir, 'manifest.json');
+    const manifest = createManifest('repo', 'release');
+    manifest.files['a.ts'] = { sha256: 'a', chunkCount: 1, enqueuedAt: '2024-01-01T00:00:00.000Z' };
+    writeManifest(manifestPath, manifest);
+
+    const read = readManifest(manifestPath);
+
+    expect(read.branch).toBe('release');
+    expect(read.repoName).toBe('repo');
+    expect(Object.keys(read.files)).toEqual(['a.ts']);
+  });
+
+  it('should throw when the manifest to read is missing or has no files', () => {
+    const manifestPath = path.join(tempDir, 'manifest.json');
+    expect(() => readManifest(manifestPath)).toThrow(
+      `Manifest not found at ${manifestPath}. Index with --manifest to write one.`
+    );
+
+    fs.writeFileSync(manifestPath, '{"version": 1}');
+    expect(() => readManifest(manifestPath)).toThrow(`${manifestPath} is not an index manifest: it has no "files".`);
+  });
+});
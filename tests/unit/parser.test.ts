@@ -1,9 +1,11 @@
 import { LanguageParser } from '../../src/utils/parser';
+import { estimateTokenCount } from '../../src/utils/tokenizer';
 import { CodeChunk } from '../../src/utils/elasticsearch';
+import type { SymbolKind } from '../../src/utils/symbol_kinds';
 import path from 'path';
 import fs from 'fs';
 import os from 'os';
-import { describe, it, expect, beforeAll } from 'vitest';
+import { describe, it, expect, beforeAll, vi } from 'vitest';
 import { withTestEnv } from './utils/test_env';
 
 const MOCK_TIMESTAMP = '[TIMESTAMP]';
@@ -11,7 +13,9 @@ const MOCK_TIMESTAMP = '[TIMESTAMP]';
 // Supported languages for testing
 const TEST_LANGUAGES = [
   'typescript',
+  'tsx',
   'javascript',
+  'jsx',
   'markdown',
   'yaml',
   'java',
@@ -28,6 +32,8 @@ const TEST_LANGUAGES = [
   'scala',
   'hcl',
   'plpgsql',
+  'rust',
+  'kotlin',
 ].join(',');
 
 describe('LanguageParser', () => {
@@ -64,6 +70,259 @@ describe('LanguageParser', () => {
     );
   });
 
+  it('should record the calls of each Go chunk as references', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/usage.go');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/usage.go');
+    const main = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.main');
+
+    expect(main?.kind).toBe('function_declaration');
+    expect(main?.references).toEqual([
+      { name: 'greet', kind: 'function' },
+      { name: 'Greeter', kind: 'type' },
+      { name: 'Greet', kind: 'method', receiver: 'g', receiver_type: 'Greeter' },
+    ]);
+    // `fmt` is a package, not a variable, so its calls keep the receiver name only.
+    const greet = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.greet');
+    expect(greet?.references).toEqual([{ name: 'Println', kind: 'method', receiver: 'fmt' }]);
+  });
+
+  it('should record the fields, interface methods and embedded types of Go type declarations', () => {
+    const source = [
+      'package main',
+      '',
+      'type Greeter struct {',
+      '\t*Base',
+      '\tio.Writer',
+      '\tName, Greeting string',
+      '}',
+      '',
+      'type Speaker interface {',
+      '\tfmt.Stringer',
+      '\tSpeak(to string) error',
+      '}',
+      '',
+    ].join('\n');
+    const tmpFile = path.join(os.tmpdir(), `temp_go_members_${process.pid}_${Date.now()}.go`);
+    fs.writeFileSync(tmpFile, source);
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'members.go');
+      const greeter = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.Greeter');
+      const speaker = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.Speaker');
+
+      expect(greeter?.symbols?.map((symbol) => [symbol.kind, symbol.name])).toEqual([
+        ['type.name', 'Greeter'],
+        ['type.embedded', 'Base'],
+        ['type.embedded', 'io.Writer'],
+        ['field.name', 'Name'],
+        ['field.name', 'Greeting'],
+      ]);
+      expect(greeter?.references).toEqual([
+        { name: 'Base', kind: 'type' },
+        { name: 'io.Writer', kind: 'type' },
+      ]);
+      expect(speaker?.symbols?.map((symbol) => [symbol.kind, symbol.name])).toEqual([
+        ['type.name', 'Speaker'],
+        ['type.embedded', 'fmt.Stringer'],
+        ['method.name', 'Speak'],
+      ]);
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should resolve Go receiver types from parameters and declarations', () => {
+    const source = [
+      'package main',
+      '',
+      'var defaultStore Store',
+      '',
+      'func (s *Service) Run(c *Client, name string) {',
+      '\ts.Log(name)',
+      '\tc.Send(name)',
+      '\tw := new(Writer)',
+      '\tw.Flush()',
+      '\tp := &Pool{}',
+      '\tp.Get()',
+      '\tdefaultStore.Save(name)',
+      '\tconn := c.Dial()',
+      '\tconn.Close()',
+      '}',
+      '',
+    ].join('\n');
+    const tmpFile = path.join(os.tmpdir(), `temp_go_receivers_${process.pid}_${Date.now()}.go`);
+    fs.writeFileSync(tmpFile, source);
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'service.go');
+      const run = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.Service.Run');
+
+      expect(run?.references).toEqual(
+        expect.arrayContaining([
+          { name: 'Log', kind: 'method', receiver: 's', receiver_type: 'Service' },
+          { name: 'Send', kind: 'method', receiver: 'c', receiver_type: 'Client' },
+          { name: 'Flush', kind: 'method', receiver: 'w', receiver_type: 'Writer' },
+          { name: 'Get', kind: 'method', receiver: 'p', receiver_type: 'Pool' },
+          { name: 'Save', kind: 'method', receiver: 'defaultStore', receiver_type: 'Store' },
+          // The result type of a call is not known without type checking, so only the name is recorded.
+          { name: 'Close', kind: 'method', receiver: 'conn' },
+        ])
+      );
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should qualify Go symbols with their package and method receiver', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/usage.go');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/usage.go');
+    const names = result.chunks.filter((chunk) => chunk.symbol_fqn).map((chunk) => [chunk.kind, chunk.symbol_fqn]);
+
+    expect(names).toEqual(
+      expect.arrayContaining([
+        ['function_declaration', 'main.greet'],
+        ['type_declaration', 'main.Greeter'],
+        ['method_declaration', 'main.Greeter.Greet'],
+        ['function_declaration', 'main.main'],
+      ])
+    );
+    // Call chunks reference symbols but do not define one.
+    const calls = result.chunks.filter((chunk) => chunk.kind === 'call_expression');
+    expect(calls.length).toBeGreaterThan(0);
+    expect(calls.every((chunk) => chunk.symbol_fqn === undefined)).toBe(true);
+  });
+
+  it('should record the enclosing scope of Go symbols, with the receiver type as the parent of a method', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/usage.go');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/usage.go');
+    const scopes = result.chunks
+      .filter((chunk) => chunk.symbol_fqn)
+      .map((chunk) => [chunk.symbol_fqn, chunk.scope_path, chunk.parent_symbol]);
+
+    expect(scopes).toEqual(
+      expect.arrayContaining([
+        ['main.greet', 'greet', undefined],
+        ['main.Greeter', 'Greeter', undefined],
+        ['main.Greeter.Greet', 'Greeter.Greet', 'Greeter'],
+      ])
+    );
+  });
+
+  it('should record Java method calls with their receiver and qualify methods with their class', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/usage.java');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/usage.java');
+    const main = result.chunks.find((chunk) => chunk.symbol_fqn === 'Main.main');
+
+    expect(main?.references).toEqual([
+      { name: 'Greeter', kind: 'type' },
+      { name: 'greet', kind: 'method', receiver: 'greeter' },
+    ]);
+    // `System.out` is not a plain identifier, so the call keeps only the method name.
+    const greet = result.chunks.find((chunk) => chunk.symbol_fqn === 'Greeter.greet');
+    expect(greet?.references).toEqual([{ name: 'println', kind: 'method' }]);
+  });
+
+  it('should treat module-scope arrow function consts as functions', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/typescript.ts');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/typescript.ts');
+    const allSymbols = result.chunks.flatMap((chunk) => chunk.symbols);
+
+    expect(allSymbols).toEqual(
+      expect.arrayContaining([
+        expect.objectContaining({ name: 'myVar', kind: 'function.name' }),
+        expect.objectContaining({ name: 'myMethod', kind: 'method.name' }),
+        expect.objectContaining({ name: 'MyType', kind: 'type.name' }),
+        expect.objectContaining({ name: 'MyInterface', kind: 'interface.name' }),
+      ])
+    );
+    expect(allSymbols).not.toContainEqual(expect.objectContaining({ name: 'myVar', kind: 'variable.name' }));
+  });
+
+  it('should parse TSX fixtures correctly', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/tsx.tsx');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/tsx.tsx');
+
+    expect(result.metrics.filesFailed).toBe(0);
+    expect(result.chunks.length).toBeGreaterThan(0);
+    expect(result.chunks.every((chunk) => chunk.language === 'tsx')).toBe(true);
+
+    const allSymbols = result.chunks.flatMap((chunk) => chunk.symbols);
+    expect(allSymbols).toEqual(
+      expect.arrayContaining([
+        expect.objectContaining({ name: 'CounterProps', kind: 'interface.name' }),
+        expect.objectContaining({ name: 'Theme', kind: 'type.name' }),
+        expect.objectContaining({ name: 'Counter', kind: 'function.name' }),
+        expect.objectContaining({ name: 'Panel', kind: 'class.name' }),
+        expect.objectContaining({ name: 'render', kind: 'method.name' }),
+        expect.objectContaining({ name: 'App', kind: 'function.name' }),
+      ])
+    );
+    // Consts inside a function body stay variables.
+    expect(allSymbols).not.toContainEqual(expect.objectContaining({ name: 'count', kind: 'function.name' }));
+
+    const renderChunk = result.chunks.find((chunk) => chunk.kind === 'method_definition');
+    expect(renderChunk).toMatchObject({ containerPath: 'Panel' });
+    expect(renderChunk?.content).toContain('<section');
+
+    const docChunk = result.chunks.find((chunk) => chunk.content.includes('Renders a labelled counter button.'));
+    expect(docChunk).toBeDefined();
+  });
+
+  it('should chunk a functional TSX component as one chunk named after its const', () => {
+    const source = [
+      "import React from 'react';",
+      '',
+      'export const Button = ({ label }: { label: string }) => {',
+      '  const [pressed, setPressed] = React.useState(false);',
+      '  return (',
+      '    <button onClick={() => setPressed(!pressed)}>',
+      '      {label}',
+      '    </button>',
+      '  );',
+      '};',
+      '',
+    ].join('\n');
+    const tmpFile = path.join(os.tmpdir(), `temp_tsx_component_${process.pid}_${Date.now()}.tsx`);
+    fs.writeFileSync(tmpFile, source);
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'button.tsx');
+      const components = result.chunks.filter((chunk) => chunk.content.includes('<button'));
+
+      expect(components).toHaveLength(1);
+      expect(components[0]).toMatchObject({
+        kind: 'export_statement',
+        symbol_fqn: 'Button',
+        startLine: 3,
+        endLine: 10,
+      });
+      expect(components[0].content).toContain('</button>');
+      expect(result.chunks.map((chunk) => chunk.kind)).toEqual(['import_statement', 'export_statement']);
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should parse JSX fixtures into one chunk per component and hook', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/jsx.jsx');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/jsx.jsx');
+
+    expect(result.metrics.filesFailed).toBe(0);
+    expect(result.chunks.every((chunk) => chunk.language === 'jsx')).toBe(true);
+    const named = result.chunks
+      .filter((chunk) => chunk.symbol_fqn)
+      .map(({ symbol_fqn, startLine, endLine }) => ({ symbol_fqn, startLine, endLine }));
+    expect(named).toEqual([
+      { symbol_fqn: 'useWindowWidth', startLine: 6, endLine: 14 },
+      { symbol_fqn: 'Greeting', startLine: 16, endLine: 23 },
+      { symbol_fqn: 'Badge', startLine: 25, endLine: 25 },
+      { symbol_fqn: 'Profile', startLine: 27, endLine: 36 },
+      { symbol_fqn: 'Profile.render', startLine: 28, endLine: 35 },
+    ]);
+    // Hooks and handlers inside a component stay in its chunk.
+    expect(result.chunks.find((chunk) => chunk.content.startsWith('useEffect'))).toBeUndefined();
+    expect(result.chunks.flatMap((chunk) => chunk.symbols)).toContainEqual(
+      expect.objectContaining({ name: 'Badge', kind: 'function.name' })
+    );
+  });
+
   it('should parse JavaScript fixtures correctly', () => {
     const filePath = path.resolve(__dirname, '../fixtures/javascript.js');
     const result = parser.parseFile(filePath, 'main', 'tests/fixtures/javascript.js');
@@ -191,12 +450,229 @@ Content 2`;
     expect(cleanTimestamps(result.chunks)).toMatchSnapshot();
   });
 
+  it('should parse Rust fixtures with correct chunk boundaries', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/rust.rs');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/rust.rs');
+    const boundaries = result.chunks.map((chunk) => ({
+      kind: chunk.kind,
+      startLine: chunk.startLine,
+      endLine: chunk.endLine,
+    }));
+
+    expect(result.chunks.every((chunk) => chunk.language === 'rust')).toBe(true);
+    expect(boundaries).toEqual(
+      expect.arrayContaining([
+        { kind: 'use_declaration', startLine: 1, endLine: 1 },
+        { kind: 'line_comment', startLine: 4, endLine: 4 },
+        { kind: 'struct_item', startLine: 5, endLine: 7 },
+        { kind: 'line_comment', startLine: 9, endLine: 9 },
+        { kind: 'enum_item', startLine: 10, endLine: 13 },
+        { kind: 'trait_item', startLine: 16, endLine: 18 },
+        { kind: 'impl_item', startLine: 20, endLine: 32 },
+        { kind: 'function_item', startLine: 22, endLine: 24 },
+        { kind: 'function_item', startLine: 26, endLine: 31 },
+        { kind: 'impl_item', startLine: 34, endLine: 38 },
+        { kind: 'macro_definition', startLine: 40, endLine: 44 },
+        { kind: 'function_item', startLine: 47, endLine: 50 },
+        // Macro invocations must not swallow the rest of the file.
+        { kind: 'function_item', startLine: 52, endLine: 54 },
+      ])
+    );
+
+    const methodChunk = result.chunks.find((chunk) => chunk.kind === 'function_item' && chunk.startLine === 22);
+    expect(methodChunk?.containerPath).toBe('Store');
+
+    const allExports = result.chunks.flatMap((chunk) => chunk.exports || []);
+    expect(allExports).toEqual(
+      expect.arrayContaining([
+        expect.objectContaining({ name: 'Store', type: 'named' }),
+        expect.objectContaining({ name: 'Lookup', type: 'named' }),
+        expect.objectContaining({ name: 'greet', type: 'named' }),
+      ])
+    );
+    expect(allExports).not.toEqual(expect.arrayContaining([expect.objectContaining({ name: 'private_helper' })]));
+  });
+
+  it('should parse Java definitions with their annotations and Javadoc', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/java_definitions.java');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/java_definitions.java');
+    const boundaries = result.chunks.map((chunk) => ({
+      kind: chunk.kind,
+      startLine: chunk.startLine,
+      endLine: chunk.endLine,
+    }));
+
+    expect(boundaries).toEqual(
+      expect.arrayContaining([
+        { kind: 'class_declaration', startLine: 9, endLine: 24 },
+        { kind: 'constructor_declaration', startLine: 13, endLine: 15 },
+        // The `@GetMapping` annotation is part of the method's chunk.
+        { kind: 'method_declaration', startLine: 20, endLine: 23 },
+        { kind: 'interface_declaration', startLine: 26, endLine: 28 },
+        { kind: 'method_declaration', startLine: 27, endLine: 27 },
+        { kind: 'enum_declaration', startLine: 30, endLine: 33 },
+        { kind: 'annotation_type_declaration', startLine: 35, endLine: 37 },
+      ])
+    );
+
+    const controller = result.chunks.find((chunk) => chunk.kind === 'class_declaration');
+    expect(controller?.symbol_fqn).toBe('com.example.api.UserController');
+    expect(controller?.doc_comment).toBe('/**\n * Serves the users of the API.\n */');
+
+    const listUsers = result.chunks.find((chunk) => chunk.kind === 'method_declaration' && chunk.startLine === 20);
+    expect(listUsers?.content).toMatch(/^@GetMapping\("\/users"\)/);
+    expect(listUsers?.symbol_fqn).toBe('com.example.api.UserController.listUsers');
+    expect(listUsers?.doc_comment).toBe('/**\n     * Returns every user.\n     */');
+    expect(listUsers?.exports).toEqual([expect.objectContaining({ name: 'listUsers', type: 'named' })]);
+
+    const findAll = result.chunks.find((chunk) => chunk.kind === 'method_declaration' && chunk.startLine === 27);
+    expect(findAll?.symbol_fqn).toBe('com.example.api.UserRepository.findAll');
+  });
+
+  it('should parse Kotlin fixtures with correct chunk boundaries', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/kotlin.kt');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/kotlin.kt');
+    const boundaries = result.chunks.map((chunk) => ({
+      kind: chunk.kind,
+      startLine: chunk.startLine,
+      endLine: chunk.endLine,
+    }));
+
+    expect(result.chunks.every((chunk) => chunk.language === 'kotlin')).toBe(true);
+    expect(boundaries).toEqual(
+      expect.arrayContaining([
+        { kind: 'import_header', startLine: 3, endLine: 3 },
+        { kind: 'multiline_comment', startLine: 6, endLine: 8 },
+        { kind: 'class_declaration', startLine: 9, endLine: 15 },
+        // The `@GetMapping` annotation is part of the function's chunk.
+        { kind: 'function_declaration', startLine: 11, endLine: 14 },
+        { kind: 'class_declaration', startLine: 18, endLine: 18 },
+        { kind: 'class_declaration', startLine: 20, endLine: 22 },
+        { kind: 'function_declaration', startLine: 21, endLine: 21 },
+        { kind: 'object_declaration', startLine: 24, endLine: 26 },
+        { kind: 'property_declaration', startLine: 25, endLine: 25 },
+        { kind: 'class_declaration', startLine: 28, endLine: 31 },
+        { kind: 'function_declaration', startLine: 34, endLine: 34 },
+        { kind: 'function_declaration', startLine: 36, endLine: 36 },
+      ])
+    );
+
+    const controller = result.chunks.find((chunk) => chunk.kind === 'class_declaration' && chunk.startLine === 9);
+    expect(controller?.symbol_fqn).toBe('com.example.greeting.GreetingController');
+    expect(controller?.doc_comment).toBe('/**\n * Greets the users of the API.\n */');
+
+    const endpoint = result.chunks.find((chunk) => chunk.kind === 'function_declaration' && chunk.startLine === 11);
+    expect(endpoint?.symbol_fqn).toBe('com.example.greeting.GreetingController.greeting');
+
+    const greeting = result.chunks.find((chunk) => chunk.kind === 'class_declaration' && chunk.startLine === 18);
+    expect(greeting?.doc_comment).toBe('/** A greeting sent to a user. */');
+
+    // Extension functions are named after the function, not the receiver type.
+    const shout = result.chunks.find((chunk) => chunk.kind === 'function_declaration' && chunk.startLine === 34);
+    expect(shout?.symbol_fqn).toBe('com.example.greeting.shout');
+    expect(shout?.doc_comment).toBe('/** Shouts a text. */');
+
+    const allExports = result.chunks.flatMap((chunk) => chunk.exports || []);
+    expect(allExports).toEqual(
+      expect.arrayContaining([
+        expect.objectContaining({ name: 'GreetingController', type: 'named' }),
+        expect.objectContaining({ name: 'Greetings', type: 'named' }),
+        expect.objectContaining({ name: 'shout', type: 'named' }),
+      ])
+    );
+    expect(allExports).not.toEqual(expect.arrayContaining([expect.objectContaining({ name: 'helper' })]));
+  });
+
   it('should parse Python fixtures correctly', () => {
     const filePath = path.resolve(__dirname, '../fixtures/python.py');
     const result = parser.parseFile(filePath, 'main', 'tests/fixtures/python.py');
     expect(cleanTimestamps(result.chunks)).toMatchSnapshot();
   });
 
+  describe('Python definitions', () => {
+    const parseDefinitions = (): CodeChunk[] => {
+      const filePath = path.resolve(__dirname, '../fixtures/python_definitions.py');
+      return parser
+        .parseFile(filePath, 'main', 'tests/fixtures/python_definitions.py')
+        .chunks.filter((chunk) => chunk.kind === 'function_definition' || chunk.kind === 'class_definition');
+    };
+    // The first function name in a function chunk is its own; later ones belong to nested functions.
+    const findDefinition = (chunks: CodeChunk[], name: string) =>
+      chunks.find(
+        (chunk) =>
+          chunk.kind === 'function_definition' &&
+          chunk.symbols.find((symbol) => symbol.kind === 'function.name')?.name === name
+      );
+
+    it('includes decorators in the chunk of a decorated function', () => {
+      const chunks = parseDefinitions();
+      const getUser = findDefinition(chunks, 'get_user');
+
+      expect(getUser?.content.startsWith('@app.route("/users/<user_id>")\ndef get_user(user_id):')).toBe(true);
+      expect(getUser?.startLine).toBe(6);
+      expect(getUser?.endLine).toBe(13);
+      expect(getUser?.doc_comment).toBe('"""Return a single user."""');
+      expect(getUser?.exports).toEqual([{ name: 'get_user', type: 'named' }]);
+      // The definition is not chunked a second time without its decorator.
+      expect(chunks.filter((chunk) => chunk.content.startsWith('def get_user('))).toHaveLength(0);
+    });
+
+    it('emits async functions and methods with their line ranges', () => {
+      const chunks = parseDefinitions();
+      const fetchAll = findDefinition(chunks, 'fetch_all');
+      const refresh = findDefinition(chunks, 'refresh');
+
+      expect(fetchAll?.content.startsWith('async def fetch_all(client):')).toBe(true);
+      expect([fetchAll?.startLine, fetchAll?.endLine]).toEqual([16, 18]);
+      expect(fetchAll?.doc_comment).toBe('"""Fetch every user."""');
+      expect(refresh?.content.startsWith('async def refresh(self):')).toBe(true);
+      expect([refresh?.startLine, refresh?.endLine]).toEqual([31, 32]);
+    });
+
+    it('links methods and nested functions to the enclosing symbol', () => {
+      const chunks = parseDefinitions();
+      const service = chunks.find((chunk) => chunk.kind === 'class_definition');
+      const cacheKey = findDefinition(chunks, 'cache_key');
+
+      expect([service?.startLine, service?.endLine]).toEqual([21, 32]);
+      expect(service?.doc_comment).toBe('"""Loads and caches users."""');
+      expect(findDefinition(chunks, '__init__')?.containerPath).toBe('UserService');
+      expect(findDefinition(chunks, 'refresh')?.containerPath).toBe('UserService');
+      expect(cacheKey?.content.startsWith('@staticmethod\n')).toBe(true);
+      expect(cacheKey?.containerPath).toBe('UserService');
+
+      const load = findDefinition(chunks, 'load');
+      expect([load?.startLine, load?.endLine]).toEqual([10, 11]);
+      expect(load?.containerPath).toBe('get_user');
+      expect(findDefinition(chunks, 'fetch_all')?.containerPath).toBe('');
+    });
+
+    it('qualifies deeply nested definitions and keeps the line ranges of one-line bodies', () => {
+      const filePath = path.resolve(__dirname, '../fixtures/python_nested.py');
+      const chunks = parser
+        .parseFile(filePath, 'main', 'tests/fixtures/python_nested.py')
+        .chunks.filter((chunk) => chunk.kind === 'function_definition' || chunk.kind === 'class_definition');
+      const inner = chunks.find(
+        (chunk) => chunk.kind === 'class_definition' && chunk.content.startsWith('class Inner')
+      );
+      const name = findDefinition(chunks, 'name');
+      const leaf = findDefinition(chunks, 'leaf');
+      const oneLiner = findDefinition(chunks, 'one_liner');
+
+      expect(inner?.containerPath).toBe('Outer');
+      expect(name?.content).toBe('@property\n        def name(self): return "inner"');
+      expect([name?.startLine, name?.endLine]).toEqual([3, 4]);
+      expect(name?.containerPath).toBe('Outer.Inner');
+      expect(findDefinition(chunks, 'build')?.containerPath).toBe('Outer.Inner');
+      expect(findDefinition(chunks, 'step')?.containerPath).toBe('Outer.Inner.build');
+      expect([leaf?.startLine, leaf?.endLine]).toEqual([8, 8]);
+      expect(leaf?.containerPath).toBe('Outer.Inner.build.step');
+      expect(leaf?.doc_comment).toBe('"""Innermost helper."""');
+      expect([oneLiner?.startLine, oneLiner?.endLine]).toEqual([14, 14]);
+      expect(oneLiner?.containerPath).toBe('');
+    });
+  });
+
   it('should parse JSON fixtures correctly', () => {
     const filePath = path.resolve(__dirname, '../fixtures/json.json');
     const result = parser.parseFile(filePath, 'main', 'tests/fixtures/json.json');
@@ -535,6 +1011,102 @@ Content 2`;
     );
   });
 
+  it('should capture the fmt import from the Go fixture', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/go.go');
+    const result = parser.parseFile(filePath, 'main', 'tests/fixtures/go.go');
+    const allImports = result.chunks.flatMap((chunk) => chunk.imports || []);
+    expect(allImports).toContainEqual({ path: 'fmt', type: 'module', symbols: [] });
+  });
+
+  it('should capture every spec of a Go import block', () => {
+    const source = ['package main', '', 'import (', '\t"fmt"', '\tstr "strings"', ')', ''].join('\n');
+    const tmpFile = path.join(os.tmpdir(), `temp_go_imports_${process.pid}_${Date.now()}.go`);
+    fs.writeFileSync(tmpFile, source);
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'imports.go');
+      const importChunk = result.chunks.find((chunk) => chunk.kind === 'import_declaration');
+
+      expect(importChunk?.imports?.map((entry) => entry.path)).toEqual(['fmt', 'strings']);
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should capture CommonJS require calls', () => {
+    const source = [
+      "const fs = require('fs');",
+      "const { join, resolve } = require('path');",
+      "require('dotenv');",
+      '',
+    ].join('\n');
+    const tmpFile = path.join(os.tmpdir(), `temp_js_require_${process.pid}_${Date.now()}.js`);
+    fs.writeFileSync(tmpFile, source);
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'require.js');
+      const allImports = result.chunks.flatMap((chunk) => chunk.imports || []);
+
+      expect(allImports).toEqual(
+        expect.arrayContaining([
+          { path: 'fs', type: 'module', symbols: ['fs'] },
+          { path: 'path', type: 'module', symbols: ['join'] },
+          { path: 'path', type: 'module', symbols: ['resolve'] },
+          { path: 'dotenv', type: 'module', symbols: [] },
+        ])
+      );
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should transcode UTF-16 files to UTF-8 before parsing', () => {
+    const source = "export function greet() {\n  return 'héllo';\n}\n";
+    const tmpFile = path.join(os.tmpdir(), `temp_utf16_${process.pid}_${Date.now()}.ts`);
+    fs.writeFileSync(tmpFile, Buffer.concat([Buffer.from([0xff, 0xfe]), Buffer.from(source, 'utf16le')]));
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'greet.ts');
+
+      expect(result.chunks.some((chunk) => chunk.content.includes("return 'héllo';"))).toBe(true);
+      expect(result.metrics.filesProcessed).toBe(1);
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should skip files with null bytes past the sniffed start', () => {
+    const tmpFile = path.join(os.tmpdir(), `temp_null_bytes_${process.pid}_${Date.now()}.ts`);
+    fs.writeFileSync(tmpFile, Buffer.concat([Buffer.from('const a = 1;\n'.repeat(1000)), Buffer.from([0, 1, 2])]));
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'nulls.ts');
+
+      expect(result.chunks).toEqual([]);
+      expect(result.metrics).toMatchObject({ filesProcessed: 0, filesFailed: 0, language: 'typescript' });
+    } finally {
+      fs.unlinkSync(tmpFile);
+    }
+  });
+
+  it('should resolve Python relative imports to repository paths', () => {
+    // Relative imports are resolved against the git root, so the file must live inside the repository.
+    const tmpDir = fs.mkdtempSync(path.join(__dirname, '../fixtures/temp_python_imports_'));
+    const tmpFile = path.join(tmpDir, 'module.py');
+    fs.writeFileSync(tmpFile, ['from . import sibling', 'from ..pkg.mod import helper', 'import os', ''].join('\n'));
+    try {
+      const result = parser.parseFile(tmpFile, 'main', 'module.py');
+      const allImports = result.chunks.flatMap((chunk) => chunk.imports || []);
+      const repoRoot = path.resolve(__dirname, '../..');
+
+      expect(allImports).toEqual(
+        expect.arrayContaining([
+          { path: path.relative(repoRoot, tmpDir), type: 'file', symbols: ['sibling'] },
+          { path: path.join('tests', 'fixtures', 'pkg', 'mod'), type: 'file', symbols: ['helper'] },
+          { path: 'os', type: 'module', symbols: [] },
+        ])
+      );
+    } finally {
+      fs.rmSync(tmpDir, { recursive: true, force: true });
+    }
+  });
+
   it('should filter Bash exports correctly (export vs readonly/local)', () => {
     const testScript = `export EXPORTED_VAR="exported"
 readonly READONLY_VAR="readonly"
@@ -765,6 +1337,538 @@ Line 18`;
     });
   });
 
+  describe('Chunk Overlap', () => {
+    const goSource = `package demo
+
+func first() int {
+\treturn 1
+}
+
+func second() int {
+\ta := 1
+\tb := 2
+\treturn a + b
+}
+
+func third() int { return 3 }
+`;
+
+    const parseFunctions = (overlapParser: LanguageParser): CodeChunk[] => {
+      const tempFile = path.join(__dirname, '../fixtures', 'temp_overlap.go');
+      fs.writeFileSync(tempFile, goSource);
+      try {
+        return overlapParser
+          .parseFile(tempFile, 'main', 'temp_overlap.go')
+          .chunks.filter((chunk) => chunk.kind === 'function_declaration');
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    };
+
+    it('does not add overlap by default', () => {
+      const chunks = parseFunctions(new LanguageParser('go'));
+      expect(chunks).toHaveLength(3);
+      expect(chunks.every((chunk) => chunk.overlap === undefined)).toBe(true);
+    });
+
+    it('adds trailing context from the next sibling without changing content', () => {
+      const [first] = parseFunctions(new LanguageParser('go', { chunkOverlapLines: 2 }));
+      expect(first.content).toBe('func first() int {\n\treturn 1\n}');
+      expect(first.overlap).toBe('func second() int {\n\ta := 1');
+      expect(first.semantic_text).toContain(first.overlap);
+    });
+
+    it('never copies an entire neighbouring symbol', () => {
+      const [first, second, third] = parseFunctions(new LanguageParser('go', { chunkOverlapLines: 50 }));
+      // second() spans 5 lines, so at most 4 of them are copied.
+      expect(first.overlap?.split('\n')).toHaveLength(4);
+      // third() is a single line and the last symbol in the file.
+      expect(second.overlap).toBeUndefined();
+      expect(third.overlap).toBeUndefined();
+    });
+  });
+
+  describe('Chunk Preview', () => {
+    const pythonSource = '@cache\n@trace\n@retry(times=3)\ndef greet(name):\n    return f"Hello, {name}"\n';
+
+    const parseGreet = (previewParser: LanguageParser): CodeChunk | undefined => {
+      const tempFile = path.join(__dirname, '../fixtures', 'temp_preview.py');
+      fs.writeFileSync(tempFile, pythonSource);
+      try {
+        return previewParser
+          .parseFile(tempFile, 'main', 'temp_preview.py')
+          .chunks.find((chunk) => chunk.kind === 'function_definition');
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    };
+
+    it('does not add a preview by default', () => {
+      expect(parseGreet(new LanguageParser('python'))?.preview).toBeUndefined();
+    });
+
+    it('stores the first lines and the signature of a function below them', () => {
+      const greet = parseGreet(new LanguageParser('python', { previewLines: 2 }));
+      expect(greet?.content.startsWith('@cache\n')).toBe(true);
+      expect(greet?.preview).toBe('@cache\n@trace\n…\ndef greet(name):');
+
+      expect(parseGreet(new LanguageParser('python', { previewLines: 5 }))?.preview).toBe(greet?.content);
+    });
+  });
+
+  describe('Long Symbol Splitting', () => {
+    const bodyLines = Array.from({ length: 198 }, (_, i) => `\tvalue${i} := compute(${i})`);
+    const goSource = `package demo\n\nfunc long() {\n${bodyLines.join('\n')}\n}\n\nfunc short() {}\n`;
+
+    const parseLongFunction = (parser = new LanguageParser('go')) => {
+      const tempFile = path.join(__dirname, '../fixtures', 'temp_long_function.go');
+      fs.writeFileSync(tempFile, goSource);
+      try {
+        return parser.parseFile(tempFile, 'main', 'temp_long_function.go');
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    };
+    const parseFunctions = (parser?: LanguageParser): CodeChunk[] =>
+      parseLongFunction(parser).chunks.filter((chunk) => chunk.kind === 'function_declaration');
+
+    it('keeps one chunk per symbol by default', () => {
+      const chunks = parseFunctions();
+      expect(chunks).toHaveLength(2);
+      expect(chunks[0].startLine).toBe(3);
+      expect(chunks[0].endLine).toBe(202);
+      expect(chunks.every((chunk) => chunk.chunkIndex === undefined && chunk.totalChunks === undefined)).toBe(true);
+    });
+
+    it('splits a 200-line function into overlapping windows within the budget', () =>
+      withTestEnv({ SCS_IDXR_MAX_CODE_CHUNK_CHARS: '1000', SCS_IDXR_CODE_CHUNK_OVERLAP_CHARS: '100' }, () => {
+        const chunks = parseFunctions();
+        const windows = chunks.filter((chunk) => chunk.totalChunks !== undefined);
+        const short = chunks.find((chunk) => chunk.content === 'func short() {}');
+
+        expect(windows.length).toBeGreaterThan(1);
+        expect(short?.totalChunks).toBeUndefined();
+        windows.forEach((window, index) => {
+          expect(window.chunkIndex).toBe(index);
+          expect(window.totalChunks).toBe(windows.length);
+          expect(window.content.length).toBeLessThanOrEqual(1000);
+        });
+        for (let i = 1; i < windows.length; i++) {
+          // Consecutive windows share lines, and each window starts further into the function.
+          expect(windows[i].startLine).toBeLessThanOrEqual(windows[i - 1].endLine!);
+          expect(windows[i].startLine).toBeGreaterThan(windows[i - 1].startLine!);
+        }
+        expect(windows[0].startLine).toBe(3);
+        expect(windows[windows.length - 1].endLine).toBe(202);
+      }));
+
+    it('splits symbols over --max-chunk-tokens into parts that share a symbol id', () => {
+      const parser = new LanguageParser('go', { maxChunkTokens: 300 });
+      const result = parseLongFunction(parser);
+      const parts = result.chunks.filter((chunk) => chunk.totalChunks !== undefined);
+
+      expect(result.metrics.chunksSplit).toBe(1);
+      expect(parts.length).toBeGreaterThan(1);
+      parts.forEach((part, index) => {
+        expect(estimateTokenCount(part.content)).toBeLessThanOrEqual(300);
+        expect(part.chunkIndex).toBe(index);
+        expect(part.symbol_id).toBe(parts[0].symbol_id);
+        expect(part.symbol_name).toBe('long');
+      });
+      expect(parts[0].symbol_id).toMatch(/^[0-9a-f]{64}$/);
+      expect(result.chunks.find((chunk) => chunk.content === 'func short() {}')?.symbol_id).toBeUndefined();
+    });
+
+    it('splits by the injected tokenizer and stores its count on every chunk', () => {
+      // One token per line: long() has 200, a quarter of them fit.
+      const tokenizer = { name: 'lines', countTokens: (text: string) => text.split('\n').length };
+      const result = parseLongFunction(new LanguageParser('go', { maxChunkTokens: 50, tokenizer }));
+      const parts = result.chunks.filter((chunk) => chunk.totalChunks !== undefined);
+
+      expect(result.metrics.chunksSplit).toBe(1);
+      expect(parts.length).toBeGreaterThanOrEqual(4);
+      expect(result.chunks.find((chunk) => chunk.content === 'func short() {}')?.totalChunks).toBeUndefined();
+      result.chunks.forEach((chunk) => {
+        expect(chunk.token_count).toBe(tokenizer.countTokens(chunk.semantic_text));
+      });
+      expect(parseFunctions()[0].token_count).toBeUndefined();
+    });
+  });
+
+  describe('Doc Comments', () => {
+    const goSource = `// Copyright header, separated by a blank line.
+
+package demo
+
+// Add returns the sum of a and b.
+// It never overflows in tests.
+func Add(a, b int) int {
+\treturn a + b
+}
+
+var limit = 10 // trailing comment
+func Undocumented() {}
+`;
+    const pythonSource = `def greet(name):
+    """Return a greeting."""
+    return "hi " + name
+`;
+
+    const parseSource = (docParser: LanguageParser, fileName: string, source: string): CodeChunk[] => {
+      const tempFile = path.join(__dirname, '../fixtures', fileName);
+      fs.writeFileSync(tempFile, source);
+      try {
+        return docParser.parseFile(tempFile, 'main', fileName).chunks;
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    };
+
+    it('stores the contiguous comment block above a declaration', () => {
+      const chunks = parseSource(new LanguageParser('go'), 'temp_doc_comments.go', goSource);
+      const add = chunks.find((chunk) => chunk.content.startsWith('func Add'));
+      expect(add?.doc_comment).toBe('// Add returns the sum of a and b.\n// It never overflows in tests.');
+      expect(add?.content.startsWith('func Add')).toBe(true);
+      expect(add?.semantic_text).not.toContain('Add returns the sum');
+    });
+
+    it('ignores headers separated by a blank line and trailing comments', () => {
+      const chunks = parseSource(new LanguageParser('go'), 'temp_doc_comments.go', goSource);
+      const undocumented = chunks.find((chunk) => chunk.content.startsWith('func Undocumented'));
+      expect(undocumented).toBeDefined();
+      expect(undocumented?.doc_comment).toBeUndefined();
+      expect(chunks.every((chunk) => !chunk.doc_comment?.includes('Copyright'))).toBe(true);
+    });
+
+    it('uses the docstring of Python functions', () => {
+      const chunks = parseSource(new LanguageParser('python'), 'temp_doc_comments.py', pythonSource);
+      const greet = chunks.find((chunk) => chunk.kind === 'function_definition');
+      expect(greet?.doc_comment).toBe('"""Return a greeting."""');
+    });
+
+    it('embeds doc comments in semantic_text when enabled', () => {
+      const docParser = new LanguageParser('go', { embedDocComments: true });
+      const chunks = parseSource(docParser, 'temp_doc_comments.go', goSource);
+      const add = chunks.find((chunk) => chunk.content.startsWith('func Add'));
+      expect(add?.semantic_text).toContain(`${add?.doc_comment}\n\nfunc Add`);
+      expect(add?.content.startsWith('func Add')).toBe(true);
+    });
+  });
+
+  describe('File Context', () => {
+    const usagePath = path.resolve(__dirname, '../fixtures/usage.go');
+
+    it('stores the package, file imports and receiver type of Go chunks', () => {
+      const result = parser.parseFile(usagePath, 'main', 'tests/fixtures/usage.go');
+      const greet = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.Greeter.Greet');
+      const main = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.main');
+
+      expect(greet).toMatchObject({ package_name: 'main', receiver_type: 'Greeter', file_imports: ['fmt'] });
+      expect(main).toMatchObject({ package_name: 'main', file_imports: ['fmt'] });
+      expect(main).not.toHaveProperty('receiver_type');
+      expect(greet?.semantic_text).not.toContain('package: main');
+      expect(greet).not.toHaveProperty('embedded_context');
+    });
+
+    it('embeds the file context in semantic_text when enabled', () => {
+      const contextParser = new LanguageParser('go', { embedContext: true });
+      const result = contextParser.parseFile(usagePath, 'main', 'tests/fixtures/usage.go');
+      const greet = result.chunks.find((chunk) => chunk.symbol_fqn === 'main.Greeter.Greet');
+
+      expect(greet?.embedded_context).toBe('package: main\nreceiver: Greeter\nimports: fmt');
+      expect(greet?.semantic_text).toContain('package: main\nreceiver: Greeter\nimports: fmt\n\nfunc (g Greeter)');
+    });
+
+    it('omits the package of files without a package declaration', () => {
+      const contextParser = new LanguageParser('python', { embedContext: true });
+      const filePath = path.resolve(__dirname, '../fixtures/python.py');
+      const result = contextParser.parseFile(filePath, 'main', 'tests/fixtures/python.py');
+
+      expect(result.chunks.length).toBeGreaterThan(0);
+      for (const chunk of result.chunks) {
+        expect(chunk).not.toHaveProperty('package_name');
+        expect(chunk.embedded_context).toBe('imports: os');
+      }
+    });
+  });
+
+  describe('In-Memory Files', () => {
+    const archiveRoot = path.join(os.tmpdir(), 'missing-archive.tar');
+
+    it('parses content that is not on disk and resolves relative imports against the given root', () => {
+      const content = "import { helper } from '../lib/helper';\n\nexport function run() {\n  return helper();\n}\n";
+      const result = parser.parseFile(path.join(archiveRoot, 'src/run.ts'), 'main', 'src/run.ts', {
+        content,
+        rootDir: archiveRoot,
+      });
+
+      expect(result.metrics.filesProcessed).toBe(1);
+      expect(result.chunks.length).toBeGreaterThan(0);
+      expect(result.chunks.every((chunk) => chunk.filePath === 'src/run.ts')).toBe(true);
+      expect(result.chunks.flatMap((chunk) => chunk.imports || [])).toContainEqual({
+        path: 'lib/helper',
+        type: 'file',
+        symbols: ['helper'],
+      });
+    });
+
+    it('gives in-memory content the git blob hash of the same file', () => {
+      const tmpFile = path.join(__dirname, '../fixtures/temp_in_memory.md');
+      const content = '# Title\n\nSome text.\n';
+      fs.writeFileSync(tmpFile, content);
+      try {
+        const fromDisk = parser.parseFile(tmpFile, 'main', 'notes.md');
+        const inMemory = parser.parseFile(path.join(archiveRoot, 'notes.md'), 'main', 'notes.md', {
+          content,
+          rootDir: archiveRoot,
+        });
+
+        expect(inMemory.chunks.map((chunk) => chunk.git_file_hash)).toEqual(
+          fromDisk.chunks.map((chunk) => chunk.git_file_hash)
+        );
+        expect(inMemory.chunks.map((chunk) => chunk.content)).toEqual(fromDisk.chunks.map((chunk) => chunk.content));
+      } finally {
+        fs.unlinkSync(tmpFile);
+      }
+    });
+  });
+
+  describe('Parse Errors', () => {
+    const archiveRoot = path.join(os.tmpdir(), 'missing-archive.tar');
+    const content = 'package main\n\nfunc main() {\n\tfmt.Println("mid-refactor"\n}\n';
+
+    /** Makes the tree-sitter chunker throw, as it does for content it cannot handle. */
+    const failTreeSitter = () =>
+      vi
+        .spyOn(LanguageParser.prototype as unknown as { parseWithTreeSitter: () => never }, 'parseWithTreeSitter')
+        .mockImplementation(() => {
+          throw new Error('unexpected node');
+        });
+
+    it('throws by default so the file is reported as failed', () => {
+      const spy = failTreeSitter();
+      try {
+        expect(() =>
+          parser.parseFile(path.join(archiveRoot, 'main.go'), 'main', 'main.go', { content, rootDir: archiveRoot })
+        ).toThrow('unexpected node');
+      } finally {
+        spy.mockRestore();
+      }
+    });
+
+    it('falls back to one whole-file chunk with wholeFileFallback', () => {
+      const fallbackParser = new LanguageParser('go', { wholeFileFallback: true });
+      const spy = failTreeSitter();
+      try {
+        const result = fallbackParser.parseFile(path.join(archiveRoot, 'main.go'), 'main', 'main.go', {
+          content,
+          rootDir: archiveRoot,
+        });
+
+        expect(result.parseError).toBe('unexpected node');
+        expect(result.metrics).toMatchObject({ filesProcessed: 1, filesFailed: 1, parserType: 'whole-file' });
+        expect(result.chunks).toHaveLength(1);
+        expect(result.chunks[0]).toMatchObject({ content, language: 'go', startLine: 1, filePath: 'main.go' });
+      } finally {
+        spy.mockRestore();
+      }
+    });
+  });
+
+  describe('Chunks Per File', () => {
+    const archiveRoot = path.join(os.tmpdir(), 'generated-archive.tar');
+    const content = 'package api\n\nfunc A() {}\n\nfunc B() {}\n\nfunc C() {}\n';
+    const parseGenerated = (generatedParser: LanguageParser) =>
+      generatedParser.parseFile(path.join(archiveRoot, 'api.pb.go'), 'main', 'api.pb.go', {
+        content,
+        rootDir: archiveRoot,
+      });
+
+    it('keeps every chunk without a limit', () => {
+      const result = parseGenerated(new LanguageParser('go'));
+
+      expect(result.chunks.length).toBeGreaterThan(2);
+      expect(result.chunkOverflow).toBeUndefined();
+    });
+
+    it('skips a file over maxChunksPerFile', () => {
+      const result = parseGenerated(new LanguageParser('go', { maxChunksPerFile: 2 }));
+
+      expect(result.chunks).toEqual([]);
+      expect(result.chunkOverflow).toEqual({ chunks: 3, action: 'skip' });
+      expect(result.metrics).toMatchObject({ filesProcessed: 1, chunksCreated: 0 });
+    });
+
+    it('indexes a file over maxChunksPerFile as one whole-file chunk with the whole-file action', () => {
+      const result = parseGenerated(new LanguageParser('go', { maxChunksPerFile: 2, chunkOverflow: 'whole-file' }));
+
+      expect(result.chunkOverflow).toEqual({ chunks: 3, action: 'whole-file' });
+      expect(result.metrics.parserType).toBe('whole-file');
+      expect(result.chunks).toHaveLength(1);
+      expect(result.chunks[0]).toMatchObject({ content, startLine: 1, filePath: 'api.pb.go' });
+    });
+  });
+
+  describe('Chunk Granularity', () => {
+    const goSource = `package main
+
+func greet(name string) {}
+
+type Greeter struct{}
+
+func (g Greeter) Greet(name string) {}
+`;
+
+    const parseSource = (granularityParser: LanguageParser, fileName: string, source: string): CodeChunk[] => {
+      const tempFile = path.join(__dirname, '../fixtures', fileName);
+      fs.writeFileSync(tempFile, source);
+      try {
+        return granularityParser.parseFile(tempFile, 'main', fileName).chunks;
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    };
+
+    it('makes a chunk per symbol by default', () => {
+      const chunks = parseSource(new LanguageParser('go'), 'temp_granularity.go', goSource);
+      expect(chunks.map((chunk) => [chunk.kind, chunk.symbol_fqn])).toEqual([
+        ['function_declaration', 'main.greet'],
+        ['type_declaration', 'main.Greeter'],
+        ['method_declaration', 'main.Greeter.Greet'],
+      ]);
+    });
+
+    it('makes a single chunk of the whole file in file mode', () => {
+      const fileParser = new LanguageParser('go', { chunkGranularity: { go: 'file' } });
+      const chunks = parseSource(fileParser, 'temp_granularity.go', goSource);
+
+      expect(chunks).toHaveLength(1);
+      expect(chunks[0].kind).toBe('file');
+      expect(chunks[0].symbol_fqn).toBeUndefined();
+      expect(chunks[0].startLine).toBe(1);
+      expect(chunks[0].content.trimEnd()).toBe(goSource.trimEnd());
+      expect(chunks[0].symbols?.map((symbol) => symbol.name)).toEqual(
+        expect.arrayContaining(['greet', 'Greeter', 'Greet'])
+      );
+      expect(chunks[0].exports).toEqual([expect.objectContaining({ name: 'Greeter' })]);
+    });
+
+    it('only changes the languages it is configured for', () => {
+      const fileParser = new LanguageParser('go,markdown', { chunkGranularity: { markdown: 'file' } });
+      const markdown = parseSource(fileParser, 'temp_granularity.md', '# One\n\nFirst.\n\n# Two\n\nSecond.\n');
+      const go = parseSource(fileParser, 'temp_granularity.go', goSource);
+
+      expect(markdown).toHaveLength(1);
+      expect(markdown[0].content).toContain('# Two');
+      expect(go).toHaveLength(3);
+    });
+
+    it('embeds doc comments in symbol+doc mode', () => {
+      const source = `package main
+
+// greet says hello.
+func greet(name string) {}
+`;
+      const docParser = new LanguageParser('go', { chunkGranularity: { go: 'symbol+doc' } });
+      const greet = parseSource(docParser, 'temp_granularity.go', source).find(
+        (chunk) => chunk.kind === 'function_declaration'
+      );
+
+      expect(greet?.doc_comment).toBe('// greet says hello.');
+      expect(greet?.semantic_text).toContain('// greet says hello.\n\nfunc greet');
+    });
+  });
+
+  describe('Symbol Kind Filter', () => {
+    const usageGo = path.resolve(__dirname, '../fixtures/usage.go');
+
+    it('drops excluded methods and the chunks inside them, and keeps functions', () => {
+      const all = new LanguageParser('go').parseFile(usageGo, 'main', 'tests/fixtures/usage.go').chunks;
+      const filterParser = new LanguageParser('go', { symbolKinds: { exclude: ['method'] } });
+      const chunks = filterParser.parseFile(usageGo, 'main', 'tests/fixtures/usage.go').chunks;
+
+      expect(all.find((chunk) => chunk.symbol_fqn === 'main.Greeter.Greet')).toBeDefined();
+      expect(chunks.find((chunk) => chunk.symbol_fqn === 'main.Greeter.Greet')).toBeUndefined();
+      expect(chunks.find((chunk) => chunk.symbol_fqn === 'main.greet')).toBeDefined();
+      // The `fmt.Println` call in the body of `Greet` goes with it, the one in `greet` stays.
+      expect(chunks.filter((chunk) => chunk.startLine >= 12 && chunk.endLine <= 14)).toEqual([]);
+      expect(chunks.filter((chunk) => chunk.startLine >= 6 && chunk.endLine <= 8).length).toBeGreaterThan(1);
+      expect(chunks.length).toBeLessThan(all.length);
+    });
+
+    it('records the symbol kind of symbol chunks only', () => {
+      const chunks = new LanguageParser('go').parseFile(usageGo, 'main', 'tests/fixtures/usage.go').chunks;
+      const kindOf = (fqn: string) => chunks.find((chunk) => chunk.symbol_fqn === fqn)?.symbol_kind;
+
+      expect(kindOf('main.greet')).toBe('function');
+      expect(kindOf('main.Greeter.Greet')).toBe('method');
+      expect(kindOf('main.Greeter')).toBe('struct');
+      expect(chunks.find((chunk) => chunk.kind === 'import_declaration')?.symbol_kind).toBeUndefined();
+    });
+
+    it('only keeps the included kinds, with functions in a class body counted as methods', () => {
+      const source = `class Greeter:
+    def greet(self):
+        pass
+
+def main():
+    pass
+`;
+      const tempFile = path.join(__dirname, '../fixtures', 'temp_symbol_kinds.py');
+      fs.writeFileSync(tempFile, source);
+      try {
+        const filterParser = new LanguageParser('python', { symbolKinds: { include: ['method'] } });
+        const chunks = filterParser.parseFile(tempFile, 'main', 'temp_symbol_kinds.py').chunks;
+
+        expect(chunks.map((chunk) => [chunk.kind, chunk.startLine])).toEqual([['function_definition', 2]]);
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    });
+
+    it('counts Go structs and interfaces apart from other type declarations', () => {
+      const source = `package main
+
+type Greeter struct {
+\tName string
+}
+
+type Speaker interface {
+\tSpeak() string
+}
+
+type ID int
+`;
+      const tempFile = path.join(__dirname, '../fixtures', 'temp_symbol_kinds.go');
+      fs.writeFileSync(tempFile, source);
+      try {
+        const chunksOf = (include: SymbolKind[]) =>
+          new LanguageParser('go', { symbolKinds: { include } })
+            .parseFile(tempFile, 'main', 'temp_symbol_kinds.go')
+            .chunks.filter((chunk) => chunk.kind === 'type_declaration')
+            .map((chunk) => chunk.symbol_fqn);
+
+        expect(chunksOf(['struct'])).toEqual(['main.Greeter']);
+        expect(chunksOf(['interface'])).toEqual(['main.Speaker']);
+        expect(chunksOf(['type'])).toEqual(['main.ID']);
+      } finally {
+        fs.unlinkSync(tempFile);
+      }
+    });
+
+    it('counts Kotlin interfaces and enum classes by their keyword rather than as classes', () => {
+      const kotlinFile = path.resolve(__dirname, '../fixtures/kotlin.kt');
+      const filterParser = new LanguageParser('kotlin', { symbolKinds: { include: ['interface', 'enum'] } });
+      const chunks = filterParser.parseFile(kotlinFile, 'main', 'tests/fixtures/kotlin.kt').chunks;
+      const declarations = chunks.filter((chunk) => chunk.kind.endsWith('_declaration'));
+
+      expect(declarations.map((chunk) => [chunk.kind, chunk.startLine])).toEqual([
+        ['class_declaration', 20],
+        ['class_declaration', 28],
+      ]);
+    });
+  });
+
   describe('Line Number Calculation', () => {
     it('should calculate correct line numbers for Markdown files', () => {
       const filePath = path.resolve(__dirname, '../fixtures/markdown.md');
@@ -2,20 +2,16 @@ import { LanguageParser } from '../../src/utils/parser';
 import { logger } from '../../src/utils/logger';
 import { describe, it, expect, vi, beforeEach } from 'vitest';
 
-vi.mock('../../src/utils/logger', () => ({
-  logger: {
+// The parser logs through its own module logger, so both exports share the same mock.
+vi.mock('../../src/utils/logger', () => {
+  const logger = {
     warn: vi.fn(),
     info: vi.fn(),
     error: vi.fn(),
     debug: vi.fn(),
-  },
-  createLogger: vi.fn().mockReturnValue({
-    warn: vi.fn(),
-    info: vi.fn(),
-    error: vi.fn(),
-    debug: vi.fn(),
-  }),
-}));
+  };
+  return { logger, createLogger: vi.fn().mockReturnValue(logger) };
+});
 
 vi.mock('../../src/languages', async () => {
   const actual = await vi.importActual<typeof import('../../src/languages')>('../../src/languages');
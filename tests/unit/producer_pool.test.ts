@@ -0,0 +1,334 @@
+import { EventEmitter } from 'events';
+import { Worker } from 'worker_threads';
+import { describe, it, expect, vi } from 'vitest';
+
+import { drainProducerPools, ProducerPool, ProducerMessage, ProducerRequest } from '../../src/utils/producer_pool';
+import { CodeChunk } from '../../src/utils/elasticsearch';
+import * as loggerModule from '../../src/utils/logger';
+
+/**
+ * Fake parsing worker that replies with `chunksPerFile` chunks, or crashes for files named `crash`
+ * (and for the first attempt at `flaky`), exits for `exit`, never answers `hang`, forwards a log line
+ * before replying for files named `noisy`, and acknowledges shutdown messages unless told not to.
+ */
+class FakeWorker extends EventEmitter {
+  static inFlight = 0;
+  static maxInFlight = 0;
+  static flakyAttempts = 0;
+  requests: ProducerRequest[] = [];
+  terminate = vi.fn(async () => 0);
+
+  constructor(
+    private readonly chunksPerFile: number,
+    private readonly acknowledgesShutdown: boolean
+  ) {
+    super();
+  }
+
+  postMessage(request: ProducerRequest | { type: string }): void {
+    if ('type' in request) {
+      if (this.acknowledgesShutdown) {
+        setTimeout(() => this.emit('message', { status: 'shutdown' }), 1);
+      }
+      return;
+    }
+    this.requests.push(request);
+    FakeWorker.inFlight++;
+    FakeWorker.maxInFlight = Math.max(FakeWorker.maxInFlight, FakeWorker.inFlight);
+    setTimeout(() => {
+      FakeWorker.inFlight--;
+      if (request.relativePath === 'crash' || (request.relativePath === 'flaky' && FakeWorker.flakyAttempts++ === 0)) {
+        this.emit('error', new Error('worker crashed'));
+        this.emit('exit', 1);
+        return;
+      }
+      if (request.relativePath === 'exit') {
+        this.emit('exit', 0);
+        return;
+      }
+      if (request.relativePath === 'hang') {
+        return;
+      }
+      if (request.relativePath === 'noisy') {
+        this.emit('message', { status: 'log', entry: { level: 'WARN', message: 'noisy file', metadata: {} } });
+      }
+      const data = Array.from({ length: this.chunksPerFile }, (_, index) => ({
+        content: `${request.relativePath}#${index}`,
+      }));
+      this.emit('message', { status: 'success', data: data as unknown as CodeChunk[], filePath: request.filePath });
+    }, 5);
+  }
+}
+
+function createPool(options: {
+  size: number;
+  highWaterMark: number;
+  chunksPerFile?: number;
+  acknowledgesShutdown?: boolean;
+  maxRestarts?: number;
+  heartbeatTimeoutMs?: number;
+}) {
+  FakeWorker.inFlight = 0;
+  FakeWorker.maxInFlight = 0;
+  FakeWorker.flakyAttempts = 0;
+  const workers: FakeWorker[] = [];
+  const pool = new ProducerPool({
+    size: options.size,
+    highWaterMark: options.highWaterMark,
+    maxRestarts: options.maxRestarts,
+    heartbeatTimeoutMs: options.heartbeatTimeoutMs,
+    createWorker: () => {
+      const worker = new FakeWorker(options.chunksPerFile ?? 1, options.acknowledgesShutdown ?? true);
+      workers.push(worker);
+      return worker as unknown as Worker;
+    },
+  });
+  return { pool, workers };
+}
+
+const toRequest = (file: string): ProducerRequest => ({
+  filePath: `/repo/${file}`,
+  gitBranch: 'main',
+  relativePath: file,
+});
+
+describe('ProducerPool', () => {
+  it('should parse files concurrently and write them one at a time', async () => {
+    const { pool, workers } = createPool({ size: 3, highWaterMark: 100 });
+    const files = Array.from({ length: 10 }, (_, index) => `file${index}.ts`);
+    let writing = 0;
+    let maxWriting = 0;
+    const written: string[] = [];
+
+    await pool.run(
+      files,
+      toRequest,
+      async (file) => {
+        writing++;
+        maxWriting = Math.max(maxWriting, writing);
+        await new Promise((resolve) => setTimeout(resolve, 1));
+        written.push(file);
+        writing--;
+      },
+      () => {}
+    );
+
+    expect(workers).toHaveLength(3);
+    expect(FakeWorker.maxInFlight).toBe(3);
+    expect(maxWriting).toBe(1);
+    expect(written.sort()).toEqual([...files].sort());
+    expect(workers.every((worker) => worker.terminate.mock.calls.length === 1)).toBe(true);
+  });
+
+  it('should keep the chunks of a file together and in parser order', async () => {
+    const { pool } = createPool({ size: 2, highWaterMark: 100, chunksPerFile: 3 });
+    const messages = new Map<string, ProducerMessage>();
+
+    await pool.run(['a.ts', 'b.ts'], toRequest, (file, message) => void messages.set(file, message), () => {});
+
+    expect(messages.get('a.ts')?.data?.map((chunk) => chunk.content)).toEqual(['a.ts#0', 'a.ts#1', 'a.ts#2']);
+  });
+
+  it('should stop handing out files while the writer is behind the high-water mark', async () => {
+    const { pool, workers } = createPool({ size: 4, highWaterMark: 5, chunksPerFile: 5 });
+    const files = Array.from({ length: 6 }, (_, index) => `file${index}.ts`);
+    let releaseWriter: () => void = () => {};
+    const writerBlocked = new Promise<void>((resolve) => (releaseWriter = resolve));
+    const dispatchedWhileBlocked: number[] = [];
+
+    const run = pool.run(
+      files,
+      toRequest,
+      async (file) => {
+        if (file === 'file0.ts') {
+          await writerBlocked;
+        }
+      },
+      () => {}
+    );
+
+    await new Promise((resolve) => setTimeout(resolve, 50));
+    dispatchedWhileBlocked.push(workers.reduce((count, worker) => count + worker.requests.length, 0));
+    releaseWriter();
+    await run;
+
+    // Four files start at once and a fifth takes the first free worker; the buffered replies then hold back the last.
+    expect(dispatchedWhileBlocked[0]).toBeLessThan(files.length);
+    expect(workers.reduce((count, worker) => count + worker.requests.length, 0)).toBe(files.length);
+  });
+
+  it('should read an async iterable only as workers become free', async () => {
+    const { pool } = createPool({ size: 2, highWaterMark: 100 });
+    const written: string[] = [];
+    let pulled = 0;
+    let maxAhead = 0;
+    async function* files() {
+      for (let index = 0; index < 6; index++) {
+        pulled++;
+        maxAhead = Math.max(maxAhead, pulled - written.length);
+        yield `file${index}.ts`;
+      }
+    }
+
+    await pool.run(files(), toRequest, (file) => void written.push(file), () => {});
+
+    expect(written.sort()).toEqual(['file0.ts', 'file1.ts', 'file2.ts', 'file3.ts', 'file4.ts', 'file5.ts']);
+    // Files are pulled as workers become free, not all up front.
+    expect(maxAhead).toBeLessThan(6);
+  });
+
+  it('should write the files handed out before an async iterable fails, then reject', async () => {
+    const { pool, workers } = createPool({ size: 2, highWaterMark: 100 });
+    const written: string[] = [];
+    async function* files() {
+      yield 'a.ts';
+      throw new Error('corrupt archive');
+    }
+
+    await expect(pool.run(files(), toRequest, (file) => void written.push(file), () => {})).rejects.toThrow(
+      'corrupt archive'
+    );
+    expect(written).toEqual(['a.ts']);
+    expect(workers.every((worker) => worker.terminate.mock.calls.length === 1)).toBe(true);
+  });
+
+  it('should replace a crashed worker and report the file once it crashed a second worker', async () => {
+    const { pool, workers } = createPool({ size: 1, highWaterMark: 100 });
+    const onError = vi.fn();
+    const written: string[] = [];
+
+    await pool.run(['crash', 'b.ts'], toRequest, (file) => void written.push(file), onError);
+
+    expect(onError).toHaveBeenCalledTimes(1);
+    expect(onError).toHaveBeenCalledWith('crash', expect.objectContaining({ message: 'worker crashed' }));
+    expect(written).toEqual(['b.ts']);
+    // The worker that crashed, the one that parsed `b.ts` and crashed on the retry, and its replacement.
+    expect(workers).toHaveLength(3);
+  });
+
+  it('should hand the file of a crashed worker to its replacement', async () => {
+    const { pool, workers } = createPool({ size: 1, highWaterMark: 100 });
+    const onError = vi.fn();
+    const written: string[] = [];
+
+    await pool.run(['a.ts', 'flaky', 'b.ts'], toRequest, (file) => void written.push(file), onError);
+
+    expect(onError).not.toHaveBeenCalled();
+    // Files answered before the crash are written once.
+    expect(written.sort()).toEqual(['a.ts', 'b.ts', 'flaky']);
+    expect(workers).toHaveLength(2);
+    expect(workers[0].requests.map((request) => request.relativePath)).toEqual(['a.ts', 'flaky']);
+    expect(workers[1].requests.map((request) => request.relativePath).sort()).toEqual(['b.ts', 'flaky']);
+  });
+
+  it('should replace a worker that exits without answering', async () => {
+    const { pool, workers } = createPool({ size: 1, highWaterMark: 100 });
+    const onError = vi.fn();
+    const written: string[] = [];
+
+    await pool.run(['exit', 'b.ts'], toRequest, (file) => void written.push(file), onError);
+
+    expect(onError).toHaveBeenCalledWith(
+      'exit',
+      expect.objectContaining({ message: 'Producer worker exited with code 0' })
+    );
+    expect(written).toEqual(['b.ts']);
+    expect(workers[0].terminate).toHaveBeenCalled();
+  });
+
+  it('should replace an idle worker that exits', async () => {
+    const { pool, workers } = createPool({ size: 2, highWaterMark: 100 });
+    const written: string[] = [];
+    async function* files() {
+      yield 'a.ts';
+      await new Promise((resolve) => setTimeout(resolve, 20));
+      // `a.ts` went to the last worker created, so the first one is idle.
+      workers[0].emit('exit', 1);
+      yield 'b.ts';
+      yield 'c.ts';
+    }
+
+    await pool.run(files(), toRequest, (file) => void written.push(file), () => {});
+
+    expect(written.sort()).toEqual(['a.ts', 'b.ts', 'c.ts']);
+    expect(workers).toHaveLength(3);
+    expect(workers[0].requests).toHaveLength(0);
+  });
+
+  it('should replace a worker that does not answer within the heartbeat timeout', async () => {
+    const { pool, workers } = createPool({ size: 1, highWaterMark: 100, heartbeatTimeoutMs: 30 });
+    const onError = vi.fn();
+    const written: string[] = [];
+
+    await pool.run(['hang', 'b.ts'], toRequest, (file) => void written.push(file), onError);
+
+    expect(onError).toHaveBeenCalledWith(
+      'hang',
+      expect.objectContaining({ message: 'Producer worker did not answer within 30ms' })
+    );
+    expect(written).toEqual(['b.ts']);
+    expect(workers[0].terminate).toHaveBeenCalled();
+    expect(workers[1].terminate).toHaveBeenCalled();
+  });
+
+  it('should stop handing out files and reject once more than maxRestarts workers failed', async () => {
+    const { pool, workers } = createPool({ size: 1, highWaterMark: 100, maxRestarts: 1 });
+    const onError = vi.fn();
+    const written: string[] = [];
+
+    await expect(
+      pool.run(['a.ts', 'crash', 'b.ts', 'c.ts'], toRequest, (file) => void written.push(file), onError)
+    ).rejects.toThrow(
+      'Producer workers failed 2 times, more than --max-worker-restarts 1. Last failure: worker crashed'
+    );
+
+    expect(written).toEqual(['a.ts', 'b.ts']);
+    expect(onError).toHaveBeenCalledWith('crash', expect.objectContaining({ message: 'worker crashed' }));
+    // No replacement is started past the limit, and `c.ts` is never handed out.
+    expect(workers).toHaveLength(2);
+    expect(workers.flatMap((worker) => worker.requests.map((request) => request.relativePath))).not.toContain('c.ts');
+  });
+
+  it('should write forwarded log lines without treating them as replies', async () => {
+    const writeSpy = vi.spyOn(loggerModule, 'writeLogEntry').mockImplementation(() => {});
+    const { pool } = createPool({ size: 1, highWaterMark: 100, chunksPerFile: 2 });
+    const messages = new Map<string, ProducerMessage>();
+
+    await pool.run(['noisy', 'b.ts'], toRequest, (file, message) => void messages.set(file, message), () => {});
+
+    expect(writeSpy).toHaveBeenCalledWith(expect.objectContaining({ message: 'noisy file' }));
+    expect(messages.get('noisy')?.status).toBe('success');
+    expect(messages.get('noisy')?.data).toHaveLength(2);
+  });
+
+  describe('drain', () => {
+    it('should finish the files being parsed and skip the rest', async () => {
+      const { pool, workers } = createPool({ size: 2, highWaterMark: 100 });
+      const files = Array.from({ length: 10 }, (_, index) => `file${index}.ts`);
+      const written: string[] = [];
+
+      const run = pool.run(files, toRequest, (file) => void written.push(file), () => {});
+      await vi.waitFor(() => expect(FakeWorker.inFlight).toBe(2));
+      await drainProducerPools(1000);
+      await run;
+
+      expect(pool.isDraining).toBe(true);
+      expect(written).toEqual(['file0.ts', 'file1.ts']);
+      expect(workers.every((worker) => worker.terminate.mock.calls.length === 1)).toBe(true);
+    });
+
+    it('should terminate a worker that does not acknowledge within the timeout', async () => {
+      const { pool, workers } = createPool({ size: 1, highWaterMark: 100, acknowledgesShutdown: false });
+
+      const run = pool.run(['a.ts', 'b.ts'], toRequest, () => {}, () => {});
+      await vi.waitFor(() => expect(FakeWorker.inFlight).toBe(1));
+      const startedAt = Date.now();
+      await pool.drain(30);
+      await run;
+
+      expect(Date.now() - startedAt).toBeGreaterThanOrEqual(25);
+      expect(workers[0].terminate).toHaveBeenCalledTimes(1);
+      expect(workers[0].requests).toHaveLength(1);
+    });
+  });
+});
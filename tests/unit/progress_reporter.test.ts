@@ -0,0 +1,307 @@
+import { describe, it, expect, vi } from 'vitest';
+import {
+  ProgressReporter,
+  ProgressEvent,
+  formatChunkOverflows,
+  formatParseFailures,
+  groupParseFailures,
+  formatProgressBar,
+  formatProgressLine,
+} from '../../src/utils/progress_reporter';
+import * as loggerModule from '../../src/utils/logger';
+import { createLogger } from '../../src/utils/logger';
+
+function createClock(start = 0) {
+  let now = start;
+  return {
+    now: () => now,
+    advance: (ms: number) => {
+      now += ms;
+    },
+  };
+}
+
+describe('ProgressReporter', () => {
+  it('should write JSON progress events with an enqueue ETA', () => {
+    const clock = createClock();
+    const lines: string[] = [];
+    const reporter = new ProgressReporter({
+      format: 'json',
+      repoName: 'repo',
+      branch: 'main',
+      write: (line) => lines.push(line),
+      now: clock.now,
+    });
+
+    reporter.startEnqueue(10);
+    clock.advance(10_000);
+    for (let i = 0; i < 5; i++) {
+      reporter.recordFileEnqueued(4);
+    }
+    reporter.report();
+    reporter.stop();
+
+    expect(lines).toHaveLength(2);
+    const event = JSON.parse(lines[0]) as ProgressEvent;
+    expect(event).toMatchObject({
+      type: 'progress',
+      repo: 'repo',
+      branch: 'main',
+      phase: 'enqueue',
+      filesTotal: 10,
+      filesEnqueued: 5,
+      chunksProduced: 20,
+      chunksIndexed: 0,
+      chunksPerSecond: 2,
+      percentComplete: 50,
+      etaSeconds: 10,
+    });
+  });
+
+  it('should use the queue depth for the index percentage and ETA', () => {
+    const clock = createClock();
+    const lines: string[] = [];
+    const reporter = new ProgressReporter({ format: 'json', write: (line) => lines.push(line), now: clock.now });
+
+    const depth = { remaining: 300, completed: 0, enqueueCompleted: true };
+    reporter.startIndexing(() => depth);
+    clock.advance(10_000);
+    reporter.recordIndexed(100, 40, 3);
+    depth.remaining = 200;
+    depth.completed = 100;
+
+    const event = reporter.snapshot();
+    expect(event.phase).toBe('index');
+    expect(event.chunksIndexed).toBe(100);
+    expect(event.chunksDeduplicated).toBe(40);
+    expect(event.chunksVersionConflicts).toBe(3);
+    expect(event.chunksRemaining).toBe(200);
+    expect(event.chunksTotal).toBe(300);
+    expect(event.percentComplete).toBe(33.3);
+    expect(event.chunksPerSecond).toBe(10);
+    expect(event.etaSeconds).toBe(20);
+    reporter.stop();
+  });
+
+  it('should project the total from the files enqueued while the enqueue is running', () => {
+    const clock = createClock();
+    const reporter = new ProgressReporter({ format: 'json', write: () => {}, now: clock.now });
+
+    reporter.startEnqueue(10);
+    for (let i = 0; i < 4; i++) {
+      reporter.recordFileEnqueued(25);
+    }
+    const depth = { remaining: 60, completed: 40, enqueueCompleted: false };
+    reporter.startIndexing(() => depth);
+    clock.advance(10_000);
+    reporter.recordIndexed(40);
+
+    const event = reporter.snapshot();
+    // 4 of 10 files produced 100 chunks, so the whole repository should produce about 250.
+    expect(event.chunksTotal).toBe(250);
+    expect(event.percentComplete).toBe(16);
+    expect(event.etaSeconds).toBe(53);
+    reporter.stop();
+  });
+
+  it('should leave the percentage unknown without a file count before the enqueue completes', () => {
+    const reporter = new ProgressReporter({ format: 'json', write: () => {} });
+
+    reporter.startIndexing(() => ({ remaining: 50, completed: 10, enqueueCompleted: false }));
+
+    const event = reporter.snapshot();
+    expect(event.chunksRemaining).toBe(50);
+    expect(event.chunksTotal).toBeUndefined();
+    expect(event.percentComplete).toBeNull();
+    expect(event.etaSeconds).toBeNull();
+    reporter.stop();
+  });
+
+  it('should redraw a status line in bar mode and log the final state on stop', () => {
+    const statusSpy = vi.spyOn(loggerModule, 'setStatusLine').mockImplementation(() => {});
+    const logger = createLogger();
+    const infoSpy = vi.spyOn(logger, 'info');
+    const reporter = new ProgressReporter({ logger, bar: true, now: () => 0 });
+
+    reporter.startEnqueue(4);
+    reporter.recordFileEnqueued(2);
+    reporter.report();
+    reporter.stop();
+
+    expect(statusSpy).toHaveBeenNthCalledWith(1, expect.stringMatching(/^\[#{8}-{22}\] 25\.0% enqueue: 1\/4 files/));
+    expect(statusSpy).toHaveBeenLastCalledWith(undefined);
+    expect(infoSpy).toHaveBeenCalledTimes(1);
+    expect(infoSpy).toHaveBeenCalledWith('Progress (enqueue): 1/4 files, 2 chunks produced, 0 chunks/s, ETA unknown');
+    statusSpy.mockRestore();
+  });
+
+  it('should log a human-readable line in text mode', () => {
+    const logger = createLogger();
+    const infoSpy = vi.spyOn(logger, 'info');
+    const reporter = new ProgressReporter({ logger, now: () => 0 });
+
+    reporter.startEnqueue(3);
+    reporter.recordFileEnqueued(2);
+    reporter.report();
+    reporter.stop();
+
+    expect(infoSpy).toHaveBeenCalledWith('Progress (enqueue): 1/3 files, 2 chunks produced, 0 chunks/s, ETA unknown');
+  });
+
+  it('should split wall-clock time between enqueue, embed and bulk', async () => {
+    const clock = createClock();
+    const reporter = new ProgressReporter({ format: 'json', write: () => {}, now: clock.now });
+
+    reporter.startEnqueue(1);
+    reporter.recordFileEnqueued(2, 64);
+    clock.advance(1000);
+    reporter.startIndexing();
+    await reporter.timePhase('bulk', async () => {
+      clock.advance(200);
+      // Time spent embedding inside a bulk batch is counted as embed only.
+      await reporter.timePhase('embed', async () => clock.advance(300));
+      clock.advance(100);
+    });
+    clock.advance(5000);
+
+    const event = reporter.snapshot();
+    expect(event.bytesProduced).toBe(64);
+    expect(event.phaseMs).toEqual({ enqueue: 1000, embed: 300, bulk: 300 });
+    reporter.stop();
+  });
+
+  it('should keep the skipped files for the run summary', () => {
+    const reporter = new ProgressReporter({ format: 'json', write: () => {} });
+
+    reporter.recordFilesSkipped([{ file: 'dist/app.min.js', size: 40_000_000, reason: 'too_large' }]);
+    reporter.recordFilesSkipped([{ file: 'assets/video.ts', size: 1024, reason: 'binary' }]);
+
+    expect(reporter.snapshot().filesSkipped).toBe(2);
+    expect(reporter.skippedFiles).toEqual([
+      { file: 'dist/app.min.js', size: 40_000_000, reason: 'too_large' },
+      { file: 'assets/video.ts', size: 1024, reason: 'binary' },
+    ]);
+  });
+
+  it('should not throw when the queue size cannot be read', () => {
+    const logger = createLogger();
+    const warnSpy = vi.spyOn(logger, 'warn');
+    const reporter = new ProgressReporter({ format: 'json', logger, write: () => {} });
+
+    reporter.startIndexing(() => {
+      throw new Error('database is locked');
+    });
+
+    expect(() => reporter.report()).not.toThrow();
+    expect(warnSpy).toHaveBeenCalledWith('Failed to report progress', { error: 'database is locked' });
+    reporter.stop();
+  });
+});
+
+const indexEvent: ProgressEvent = {
+  type: 'progress',
+  timestamp: new Date(0).toISOString(),
+  phase: 'index',
+  filesTotal: 10,
+  filesEnqueued: 10,
+  filesFailed: 0,
+  filesSkipped: 0,
+  chunksProduced: 500,
+  bytesProduced: 50_000,
+  chunksIndexed: 200,
+  chunksDeduplicated: 0,
+  chunksVersionConflicts: 0,
+  phaseMs: { enqueue: 1000, embed: 0, bulk: 2000 },
+  chunksRemaining: 300,
+  percentComplete: null,
+  chunksPerSecond: 12.5,
+  etaSeconds: 24,
+};
+
+describe('formatProgressLine', () => {
+  it('should include the remaining chunks and ETA for the index phase', () => {
+    const line = formatProgressLine(indexEvent);
+
+    expect(line).toBe('Progress (index): 200 chunks indexed, 300 remaining, 12.5 chunks/s, ETA 24s');
+  });
+
+  it('should include the percentage of the total when it is known', () => {
+    const line = formatProgressLine({ ...indexEvent, chunksTotal: 500, percentComplete: 40 });
+
+    expect(line).toBe('Progress (index): 200 chunks indexed, 300 remaining, 40.0% of 500, 12.5 chunks/s, ETA 24s');
+  });
+
+  it('should include the use of the configured embedding rate limits', () => {
+    const line = formatProgressLine({
+      ...indexEvent,
+      embeddingUtilization: { requestsPerMinute: 45, tokensPerMinute: 80_000, requestLimit: 60 },
+    });
+
+    expect(line).toBe(
+      'Progress (index): 200 chunks indexed, 300 remaining, 12.5 chunks/s, ETA 24s, embedding 45/60 rpm (75%)'
+    );
+  });
+
+  it('should say when indexing is paused', () => {
+    const line = formatProgressLine({ ...indexEvent, paused: true });
+
+    expect(line).toBe('Progress (index, paused): 200 chunks indexed, 300 remaining, 12.5 chunks/s, ETA 24s');
+  });
+});
+
+describe('formatProgressBar', () => {
+  it('should fill the bar by the percentage complete', () => {
+    const line = formatProgressBar({ ...indexEvent, chunksTotal: 500, percentComplete: 40 }, 10);
+
+    expect(line).toBe('[####------] 40.0% index: 200 chunks indexed, 300 remaining, 12.5 chunks/s, ETA 24s');
+  });
+
+  it('should leave the bar empty while the percentage is unknown', () => {
+    const line = formatProgressBar({ ...indexEvent, etaSeconds: null }, 10);
+
+    expect(line).toBe('[----------]   ?% index: 200 chunks indexed, 300 remaining, 12.5 chunks/s, ETA unknown');
+  });
+});
+
+describe('formatParseFailures', () => {
+  it('should list the failures recorded by the reporter and count the ones over the limit', () => {
+    const reporter = new ProgressReporter({ write: () => {} });
+    reporter.recordFileFailed({ file: 'src/broken.go', error: 'unexpected node' });
+    reporter.recordParseFailure({ file: 'src/legacy.go', error: 'timeout', wholeFile: true });
+    for (let i = 0; i < 20; i++) {
+      reporter.recordFileFailed({ file: `src/gen_${i}.go`, error: 'unexpected node' });
+    }
+
+    expect(reporter.snapshot().filesFailed).toBe(21);
+    const listed = formatParseFailures(reporter.parseFailures);
+    expect(listed).toContain('src/broken.go (unexpected node), src/legacy.go (timeout, indexed as a whole file), ');
+    expect(listed.endsWith('src/gen_17.go (unexpected node) and 2 more')).toBe(true);
+  });
+});
+
+describe('formatChunkOverflows', () => {
+  it('should list the files recorded by the reporter, the most chunks first', () => {
+    const reporter = new ProgressReporter({ write: () => {} });
+    reporter.recordChunkOverflow({ file: 'api/service.pb.go', chunks: 1200, action: 'whole-file' });
+    reporter.recordChunkOverflow({ file: 'api/messages.pb.go', chunks: 50_000, action: 'skip' });
+
+    expect(formatChunkOverflows(reporter.chunkOverflowFiles)).toBe(
+      'api/messages.pb.go (50000 chunks, skipped), api/service.pb.go (1200 chunks, indexed as a whole file)'
+    );
+  });
+});
+
+describe('groupParseFailures', () => {
+  it('should group the failures by error, the most frequent first', () => {
+    const groups = groupParseFailures([
+      { file: 'a.go', error: 'timeout' },
+      { file: 'b.go', error: 'unexpected node' },
+      { file: 'c.go', error: 'unexpected node', wholeFile: true },
+    ]);
+
+    expect(groups).toEqual([
+      { error: 'unexpected node', files: ['b.go', 'c.go'] },
+      { error: 'timeout', files: ['a.go'] },
+    ]);
+  });
+});
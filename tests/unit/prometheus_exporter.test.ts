@@ -0,0 +1,104 @@
+import http from 'http';
+import { MeterProvider } from '@opentelemetry/sdk-metrics';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { PROMETHEUS_CONTENT_TYPE, PrometheusMetricReader } from '../../src/utils/prometheus_exporter';
+
+function get(port: number, requestPath: string): Promise<{ status?: number; contentType?: string; body: string }> {
+  return new Promise((resolve, reject) => {
+    http
+      .get({ host: '127.0.0.1', port, path: requestPath }, (response) => {
+        let body = '';
+        response.on('data', (data) => (body += data));
+        response.on('end', () =>
+          resolve({ status: response.statusCode, contentType: response.headers['content-type'], body })
+        );
+      })
+      .on('error', reject);
+  });
+}
+
+describe('PrometheusMetricReader', () => {
+  let reader: PrometheusMetricReader;
+  let meterProvider: MeterProvider;
+  let port: number;
+
+  beforeEach(async () => {
+    reader = new PrometheusMetricReader(0);
+    port = await reader.listen();
+    meterProvider = new MeterProvider({ readers: [reader] });
+  });
+
+  afterEach(async () => {
+    await meterProvider.shutdown();
+  });
+
+  it('should serve counters and gauges in the Prometheus text format', async () => {
+    const meter = meterProvider.getMeter('test');
+    meter
+      .createCounter('queue.documents.enqueued', { description: 'Total number of documents added to queue' })
+      .add(3, { 'repo.name': 'kibana', 'repo.branch': 'main' });
+    meter.createObservableGauge('indexer.bulk.size.current').addCallback((result) => result.observe(50));
+
+    const response = await get(port, '/metrics');
+
+    expect(response.status).toBe(200);
+    expect(response.contentType).toBe(PROMETHEUS_CONTENT_TYPE);
+    expect(response.body).toContain(
+      [
+        '# HELP queue_documents_enqueued_total Total number of documents added to queue',
+        '# TYPE queue_documents_enqueued_total counter',
+        'queue_documents_enqueued_total{repo_name="kibana",repo_branch="main"} 3',
+      ].join('\n')
+    );
+    expect(response.body).toContain('# TYPE indexer_bulk_size_current gauge\nindexer_bulk_size_current 50\n');
+  });
+
+  it('should expose histograms as cumulative buckets with a sum and a count', async () => {
+    const histogram = meterProvider.getMeter('test').createHistogram('indexer.bulk.duration', {
+      advice: { explicitBucketBoundaries: [10, 100] },
+    });
+    histogram.record(5);
+    histogram.record(50);
+    histogram.record(500);
+
+    const { body } = await get(port, '/metrics');
+
+    expect(body).toContain(
+      [
+        '# TYPE indexer_bulk_duration histogram',
+        'indexer_bulk_duration_bucket{le="10"} 1',
+        'indexer_bulk_duration_bucket{le="100"} 2',
+        'indexer_bulk_duration_bucket{le="+Inf"} 3',
+        'indexer_bulk_duration_sum 555',
+        'indexer_bulk_duration_count 3',
+      ].join('\n')
+    );
+  });
+
+  it('should escape label values', async () => {
+    meterProvider.getMeter('test').createCounter('parser.files.failed').add(1, { path: 'a "b"\\c\nd' });
+
+    const { body } = await get(port, '/metrics');
+
+    expect(body).toContain('parser_files_failed_total{path="a \\"b\\"\\\\c\\nd"} 1');
+  });
+
+  it('should answer other paths with a 404', async () => {
+    const response = await get(port, '/');
+
+    expect(response.status).toBe(404);
+  });
+
+  it('should close the server on shutdown', async () => {
+    await meterProvider.shutdown();
+
+    await expect(get(port, '/metrics')).rejects.toThrow();
+  });
+
+  it('should fail to listen on a port that is in use', async () => {
+    const other = new PrometheusMetricReader(port);
+
+    await expect(other.listen()).rejects.toThrow(/EADDRINUSE/);
+  });
+});
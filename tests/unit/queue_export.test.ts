@@ -0,0 +1,158 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import Database from 'better-sqlite3';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { SqliteQueue } from '../../src/utils/sqlite_queue';
+import { CodeChunk } from '../../src/utils/elasticsearch';
+import { exportQueue, importQueue, readQueueExport, redactDirectory, redactPath } from '../../src/utils/queue_export';
+
+const chunk = (filePath: string, content: string): CodeChunk => ({
+  type: 'code',
+  language: 'typescript',
+  filePath,
+  directoryPath: path.posix.dirname(filePath),
+  directoryName: path.posix.basename(path.posix.dirname(filePath)),
+  directoryDepth: 2,
+  git_file_hash: `hash-${filePath}`,
+  git_branch: 'main',
+  repo_root: '/home/customer/acme',
+  chunk_hash: `chunk-${filePath}`,
+  startLine: 1,
+  endLine: 3,
+  content,
+  semantic_text: content,
+  symbols: [{ name: 'secretAlgorithm', kind: 'function', line: 1 }],
+  created_at: '2024-01-01T00:00:00.000Z',
+  updated_at: '2024-01-01T00:00:00.000Z',
+});
+
+describe('queue export', () => {
+  let tmpDir: string;
+  let sourcePath: string;
+
+  beforeEach(async () => {
+    tmpDir = fs.mkdtempSync(path.join(os.tmpdir(), 'queue-export-'));
+    sourcePath = path.join(tmpDir, 'source', 'queue.db');
+
+    const queue = new SqliteQueue({ dbPath: sourcePath });
+    await queue.initialize();
+    await queue.enqueue([
+      chunk('src/billing/a.ts', 'function secretAlgorithm() {}'),
+      chunk('src/billing/b.ts', 'const apiKey = 1;'),
+    ]);
+    await queue.setEnqueueCommitHash('abc123');
+    await queue.markLocationsStale(['src/billing/a.ts'], 1000);
+    queue.close();
+
+    // Rows in the legacy `failed` status are moved to the dead-letter table when the queue is opened.
+    const db = new Database(sourcePath);
+    db.prepare("UPDATE queue SET status = 'failed', last_error = ? WHERE id = 2").run(
+      'mapper_parsing_exception in /home/customer/acme/src/billing/b.ts'
+    );
+    db.close();
+  });
+
+  afterEach(() => {
+    fs.rmSync(tmpDir, { recursive: true, force: true });
+  });
+
+  async function exportSource(fileName: string, redactPaths = false) {
+    const queue = new SqliteQueue({ dbPath: sourcePath });
+    await queue.initialize();
+    try {
+      const filePath = path.join(tmpDir, fileName);
+      return { filePath, counts: exportQueue(queue, filePath, { repoName: 'acme', redactPaths }) };
+    } finally {
+      queue.close();
+    }
+  }
+
+  it('should export rows of every status without their source and import them into a new queue', async () => {
+    const { filePath, counts } = await exportSource('queue.ndjson');
+    const text = fs.readFileSync(filePath, 'utf8');
+
+    expect(counts).toMatchObject({ queue: 1, dead_letter: 1, stale_location_files: 1 });
+    expect(text).not.toContain('secretAlgorithm');
+    expect(text).not.toContain('apiKey');
+    expect(text.trim().split('\n')).toHaveLength(1 + Object.values(counts).reduce((sum, count) => sum + count, 0));
+
+    const importedPath = path.join(tmpDir, 'imported', 'queue.db');
+    await importQueue(readQueueExport(filePath), importedPath);
+
+    const db = new Database(importedPath, { readonly: true });
+    const queued = db.prepare('SELECT id, status, document FROM queue').all() as { document: string }[];
+    const deadLettered = db.prepare('SELECT id, attempts, last_error FROM dead_letter').all();
+    const commitHash = db.prepare("SELECT value FROM queue_metadata WHERE key = 'enqueue_commit_hash'").get();
+    const staleFiles = db.prepare('SELECT file_path, indexed_before FROM stale_location_files').all();
+    db.close();
+
+    expect(queued).toEqual([{ id: 1, status: 'pending', document: expect.any(String) }]);
+    expect(JSON.parse(queued[0].document)).toMatchObject({
+      filePath: 'src/billing/a.ts',
+      chunk_hash: 'chunk-src/billing/a.ts',
+      startLine: 1,
+      endLine: 3,
+      content: '',
+      semantic_text: '',
+    });
+    expect(JSON.parse(queued[0].document)).not.toHaveProperty('symbols');
+    expect(deadLettered).toEqual([
+      { id: 2, attempts: 1, last_error: 'mapper_parsing_exception in /home/customer/acme/src/billing/b.ts' },
+    ]);
+    expect(commitHash).toEqual({ value: 'abc123' });
+    expect(staleFiles).toEqual([{ file_path: 'src/billing/a.ts', indexed_before: 1000 }]);
+  });
+
+  it('should hash the directories of paths with --redact-paths', async () => {
+    const { filePath } = await exportSource('queue.json', true);
+    const { header, records } = readQueueExport(filePath);
+    const text = fs.readFileSync(filePath, 'utf8');
+
+    expect(header).toMatchObject({ format: 'scs-queue-export', repoName: 'acme', redactedPaths: true });
+    expect(text).not.toContain('billing');
+    expect(text).not.toContain('customer');
+    const queued = records.find((record) => record.table === 'queue');
+    expect(JSON.parse(queued?.row.document as string)).toMatchObject({
+      filePath: redactPath('src/billing/a.ts'),
+      directoryPath: redactDirectory('src/billing'),
+      repo_root: redactDirectory('/home/customer/acme'),
+    });
+    const stale = records.find((record) => record.table === 'stale_location_files');
+    expect(stale?.row.file_path).toBe(redactPath('src/billing/a.ts'));
+    const deadLettered = records.find((record) => record.table === 'dead_letter');
+    expect(deadLettered?.row.last_error).toBe(
+      `mapper_parsing_exception in ${redactDirectory('/home/customer/acme')}/${redactPath('src/billing/b.ts')}`
+    );
+  });
+
+  it('should keep files of the same directory under the same hash', () => {
+    expect(redactPath('src/billing/a.ts')).toMatch(/^dir-[0-9a-f]{12}\/a\.ts$/);
+    expect(redactPath('src/billing/b.ts').split('/')[0]).toBe(redactPath('src/billing/a.ts').split('/')[0]);
+    expect(redactPath('src/other/a.ts')).not.toBe(redactPath('src/billing/a.ts'));
+    expect(redactPath('README.md')).toBe('README.md');
+  });
+
+  it('should not import into an existing queue database', async () => {
+    const { filePath } = await exportSource('queue.ndjson');
+
+    await expect(importQueue(readQueueExport(filePath), sourcePath)).rejects.toThrow('A queue database already exists');
+  });
+
+  it('should import the file_hashes records of older exports as indexed files', () => {
+    const filePath = path.join(tmpDir, 'legacy.ndjson');
+    const header = { format: 'scs-queue-export', version: 1, repoName: 'acme', exportedAt: '', redactedPaths: false };
+    const record = { table: 'file_hashes', row: { file_path: 'src/a.ts', sha256: 'abc' } };
+    fs.writeFileSync(filePath, `${JSON.stringify(header)}\n${JSON.stringify(record)}\n`);
+
+    expect(readQueueExport(filePath).records).toEqual([{ ...record, table: 'indexed_files' }]);
+  });
+
+  it('should reject files that are not queue exports', () => {
+    const filePath = path.join(tmpDir, 'other.json');
+    fs.writeFileSync(filePath, JSON.stringify({ records: [] }));
+
+    expect(() => readQueueExport(filePath)).toThrow('is not a queue export');
+  });
+});
@@ -0,0 +1,133 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import Database from 'better-sqlite3';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { getQueueSchemaVersion, QUEUE_SCHEMA_VERSION } from '../../src/utils/queue_schema';
+import { SqliteQueue } from '../../src/utils/sqlite_queue';
+
+/** A queue database as version 1 of the schema wrote it, with a document in each status. */
+function createV1QueueDb(dbPath: string): void {
+  const db = new Database(dbPath);
+  db.exec(`
+    CREATE TABLE queue (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      batch_id TEXT NOT NULL,
+      document TEXT NOT NULL,
+      status TEXT NOT NULL DEFAULT 'pending',
+      retry_count INTEGER NOT NULL DEFAULT 0,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX idx_status_created ON queue (status, created_at);
+    CREATE TABLE queue_metadata (
+      key TEXT PRIMARY KEY,
+      value TEXT NOT NULL,
+      updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE TABLE file_hashes (
+      file_path TEXT PRIMARY KEY,
+      sha256 TEXT NOT NULL,
+      indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    PRAGMA user_version = 1;
+  `);
+  const insert = db.prepare('INSERT INTO queue (batch_id, document, status, retry_count) VALUES (?, ?, ?, ?)');
+  insert.run('batch-1', JSON.stringify({ filePath: 'a.ts', content: 'const a = 1;' }), 'pending', 0);
+  insert.run('batch-1', JSON.stringify({ filePath: 'b.ts', content: 'const b = 2;' }), 'failed', 2);
+  db.prepare('INSERT INTO queue_metadata (key, value) VALUES (?, ?)').run('enqueue_commit_hash', 'abc123');
+  db.prepare('INSERT INTO file_hashes (file_path, sha256) VALUES (?, ?)').run('c.ts', 'hash-c');
+  db.close();
+}
+
+/** Column names of every table, to compare a migrated database with a new one. */
+function describeSchema(dbPath: string): Record<string, string[]> {
+  const db = new Database(dbPath, { readonly: true });
+  try {
+    const tables = db
+      .prepare("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+      .all() as Array<{ name: string }>;
+    return Object.fromEntries(
+      tables.map(({ name }) => [
+        name,
+        (db.prepare(`PRAGMA table_info(${name})`).all() as Array<{ name: string }>).map((column) => column.name).sort(),
+      ])
+    );
+  } finally {
+    db.close();
+  }
+}
+
+describe('queue schema migrations', () => {
+  let queueDir: string;
+
+  beforeEach(() => {
+    queueDir = fs.mkdtempSync(path.join(os.tmpdir(), 'queue-schema-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(queueDir, { recursive: true, force: true });
+  });
+
+  const openQueue = async (dbPath: string) => {
+    const queue = new SqliteQueue({ dbPath });
+    await queue.initialize();
+    return queue;
+  };
+
+  it('should create new queues at the current version', async () => {
+    const dbPath = path.join(queueDir, 'new.db');
+    (await openQueue(dbPath)).close();
+
+    const db = new Database(dbPath, { readonly: true });
+    expect(getQueueSchemaVersion(db)).toBe(QUEUE_SCHEMA_VERSION);
+    db.close();
+  });
+
+  it('should migrate a version 1 database to the current schema, keeping its rows', async () => {
+    const dbPath = path.join(queueDir, 'v1.db');
+    const newDbPath = path.join(queueDir, 'new.db');
+    createV1QueueDb(dbPath);
+    (await openQueue(newDbPath)).close();
+
+    const queue = await openQueue(dbPath);
+    try {
+      expect(queue.getEnqueueCommitHash()).toBe('abc123');
+      expect(queue.getIndexedFiles()).toEqual([
+        { filePath: 'c.ts', sha256: 'hash-c', indexedAt: expect.any(String), chunkCount: 0, indexName: null },
+      ]);
+      // The terminal `failed` status of version 1 is dead-lettered with its attempts.
+      expect(queue.getDeadLetterEntries()).toEqual([
+        expect.objectContaining({ id: 2, document: expect.stringContaining('b.ts'), attempts: 3 }),
+      ]);
+      const [pending, ...rest] = await queue.dequeue(10);
+      expect(rest).toEqual([]);
+      expect(pending).toMatchObject({ id: 'batch-1_1', document: { filePath: 'a.ts' } });
+      await queue.commit([pending]);
+    } finally {
+      queue.close();
+    }
+
+    expect(describeSchema(dbPath)).toEqual(describeSchema(newDbPath));
+    const db = new Database(dbPath, { readonly: true });
+    expect(getQueueSchemaVersion(db)).toBe(QUEUE_SCHEMA_VERSION);
+    db.close();
+  });
+
+  it('should refuse a database written by a newer version without changing it', async () => {
+    const dbPath = path.join(queueDir, 'future.db');
+    createV1QueueDb(dbPath);
+    const db = new Database(dbPath);
+    db.pragma(`user_version = ${QUEUE_SCHEMA_VERSION + 1}`);
+    db.close();
+    const schemaBefore = describeSchema(dbPath);
+
+    const queue = new SqliteQueue({ dbPath });
+    await expect(queue.initialize()).rejects.toThrow(
+      `has schema version ${QUEUE_SCHEMA_VERSION + 1}, newer than version ${QUEUE_SCHEMA_VERSION} supported`
+    );
+    queue.close();
+
+    expect(describeSchema(dbPath)).toEqual(schemaBefore);
+  });
+});
@@ -0,0 +1,134 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { CodeChunk } from '../../src/utils/elasticsearch';
+import {
+  getQueueShard,
+  getQueueShardPath,
+  listQueueShardPaths,
+  openQueueShards,
+  resolveQueueShardCount,
+  ShardedQueue,
+} from '../../src/utils/queue_shards';
+
+const chunk = (filePath: string, line = 1): CodeChunk => ({
+  type: 'code',
+  language: 'typescript',
+  filePath,
+  directoryPath: 'src',
+  directoryName: 'src',
+  directoryDepth: 1,
+  git_file_hash: `hash-${filePath}`,
+  git_branch: 'main',
+  chunk_hash: `chunk-${filePath}-${line}`,
+  startLine: line,
+  endLine: line,
+  content: `export const a${line} = 1;`,
+  semantic_text: `export const a${line} = 1;`,
+  created_at: new Date().toISOString(),
+  updated_at: new Date().toISOString(),
+});
+
+/** Paths that fall into different shards of a queue with `shardCount` shards. */
+const filesInDistinctShards = (shardCount: number): string[] => {
+  const files = new Map<number, string>();
+  for (let i = 0; files.size < shardCount; i++) {
+    const filePath = `src/file${i}.ts`;
+    const shard = getQueueShard(filePath, shardCount);
+    if (!files.has(shard)) {
+      files.set(shard, filePath);
+    }
+  }
+  return Array.from(files.values());
+};
+
+describe('getQueueShard', () => {
+  it('should route a file path to the same shard every time', () => {
+    for (const filePath of ['src/a.ts', 'lib/b.go', 'README.md']) {
+      const shard = getQueueShard(filePath, 4);
+      expect(shard).toBeGreaterThanOrEqual(0);
+      expect(shard).toBeLessThan(4);
+      expect(getQueueShard(filePath, 4)).toBe(shard);
+    }
+    expect(getQueueShard('src/a.ts', 1)).toBe(0);
+  });
+});
+
+describe('queue shards', () => {
+  let queueDir: string;
+
+  beforeEach(() => {
+    queueDir = fs.mkdtempSync(path.join(os.tmpdir(), 'queue-shards-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(queueDir, { recursive: true, force: true });
+  });
+
+  it('should keep the existing shard count unless a clean run reshards the queue', async () => {
+    expect(resolveQueueShardCount(queueDir, undefined, false)).toBe(1);
+    (await openQueueShards(queueDir, { shards: 3 })).forEach((queue) => queue.close());
+
+    expect(listQueueShardPaths(queueDir)).toEqual([0, 1, 2].map((shard) => getQueueShardPath(queueDir, shard)));
+    expect(resolveQueueShardCount(queueDir, undefined, false)).toBe(3);
+    expect(resolveQueueShardCount(queueDir, 3, false)).toBe(3);
+    expect(() => resolveQueueShardCount(queueDir, 2, false)).toThrow(
+      `The queue in ${queueDir} has 3 shards, but --queue-shards is 2. Run with --clean to reshard it.`
+    );
+
+    expect(resolveQueueShardCount(queueDir, 2, true)).toBe(2);
+    expect(listQueueShardPaths(queueDir)).toEqual([]);
+  });
+
+  describe('ShardedQueue', () => {
+    let queue: ShardedQueue;
+
+    beforeEach(async () => {
+      queue = new ShardedQueue(await openQueueShards(queueDir, { shards: 2 }));
+    });
+
+    afterEach(() => {
+      queue.close();
+    });
+
+    it('should keep the documents and the state of a file in its shard and sum the counts', async () => {
+      const [first, second] = filesInDistinctShards(2);
+      const shardOf = (filePath: string) => queue.shards[getQueueShard(filePath, 2)];
+      await queue.markEnqueueStarted();
+      await queue.enqueue([chunk(first, 1), chunk(first, 2)], { sourceFile: { filePath: first, sha256: 'a' } });
+      await queue.enqueue([chunk(second)], { sourceFile: { filePath: second, sha256: 'b' } });
+      queue.recordParseFailure({ file: second, error: 'Unexpected token' });
+
+      expect(shardOf(first).getRemainingCount()).toBe(2);
+      expect(shardOf(second).getRemainingCount()).toBe(1);
+      expect(shardOf(second).getParseFailures()).toHaveLength(1);
+      expect(queue.getRemainingCount()).toBe(3);
+      expect(queue.getPendingFileCount()).toBe(2);
+      expect(queue.getParseFailures().map((failure) => failure.filePath)).toEqual([second]);
+
+      const documents = await queue.dequeue(3);
+      expect(documents).toHaveLength(3);
+      await queue.commit(documents);
+
+      expect(queue.getPendingFileCount()).toBe(0);
+      expect(queue.getCompletedCount()).toBe(3);
+      expect(queue.getFileHashes()).toEqual(
+        new Map([
+          [first, 'a'],
+          [second, 'b'],
+        ])
+      );
+    });
+
+    it('should complete the enqueue session once every shard has', async () => {
+      await queue.markEnqueueStarted();
+      await queue.shards[0].markEnqueueCompleted();
+      expect(queue.isEnqueueCompleted()).toBe(false);
+
+      await queue.markEnqueueCompleted();
+      expect(queue.isEnqueueCompleted()).toBe(true);
+    });
+  });
+});
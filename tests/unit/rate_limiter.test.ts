@@ -0,0 +1,103 @@
+import { afterEach, beforeEach, describe, it, expect, vi } from 'vitest';
+
+import { parseRetryAfter, RateLimiter } from '../../src/utils/rate_limiter';
+
+describe('RateLimiter', () => {
+  beforeEach(() => {
+    vi.useFakeTimers();
+  });
+
+  afterEach(() => {
+    vi.useRealTimers();
+  });
+
+  /** Acquires `count` times with `tokens` each and returns the times at which each call resolved. */
+  async function acquireTimes(limiter: RateLimiter, count: number, tokens = 0, runForMs = 60_000): Promise<number[]> {
+    const start = Date.now();
+    const times: number[] = [];
+    for (let i = 0; i < count; i++) {
+      void limiter.acquire(tokens).then(() => times.push(Date.now() - start));
+    }
+    await vi.advanceTimersByTimeAsync(runForMs);
+    return times;
+  }
+
+  it('should spread requests evenly under the requests-per-minute limit', async () => {
+    const times = await acquireTimes(new RateLimiter({ requestsPerMinute: 60 }), 4);
+
+    expect(times).toEqual([0, 1000, 2000, 3000]);
+  });
+
+  it('should let large requests through and hold back the next ones under the tokens-per-minute limit', async () => {
+    // 600 tokens per minute refill 10 tokens per second; a full bucket holds 10.
+    const times = await acquireTimes(new RateLimiter({ tokensPerMinute: 600 }), 3, 50);
+
+    expect(times).toEqual([0, 5000, 10_000]);
+  });
+
+  it('should hold back every request during a pause', async () => {
+    const limiter = new RateLimiter();
+    limiter.pauseFor(2500);
+
+    const times = await acquireTimes(limiter, 2);
+
+    expect(times).toEqual([2500, 2500]);
+  });
+
+  it('should report the requests and tokens of the last minute', async () => {
+    const limiter = new RateLimiter({ requestsPerMinute: 600, tokensPerMinute: 100_000 });
+    await acquireTimes(limiter, 3, 200, 30_000);
+
+    expect(limiter.utilization()).toEqual({
+      requestsPerMinute: 3,
+      tokensPerMinute: 600,
+      requestLimit: 600,
+      tokenLimit: 100_000,
+    });
+
+    await vi.advanceTimersByTimeAsync(31_000);
+
+    expect(limiter.utilization()).toMatchObject({ requestsPerMinute: 0, tokensPerMinute: 0 });
+  });
+
+  it('should log when it throttles requests, then at most once a minute', async () => {
+    const info = vi.fn();
+    const logger = { info, warn: vi.fn(), error: vi.fn(), debug: vi.fn(), child: vi.fn() };
+    const limiter = new RateLimiter({ requestsPerMinute: 60, logger });
+
+    // The first request passes at once, the next three wait one second each behind the one before.
+    await acquireTimes(limiter, 4, 0, 5_000);
+
+    expect(info).toHaveBeenCalledTimes(1);
+    expect(info).toHaveBeenCalledWith(
+      'Embedding requests are throttled by the --embed-rpm limit (1 held back, waiting 1.0s in total).',
+      expect.objectContaining({ throttledRequests: 1, throttledMs: 1000, requestLimit: 60 })
+    );
+
+    await vi.advanceTimersByTimeAsync(56_000);
+    await acquireTimes(limiter, 2, 0, 5_000);
+
+    expect(info).toHaveBeenCalledTimes(2);
+    expect(info).toHaveBeenLastCalledWith(
+      'Embedding requests are throttled by the --embed-rpm limit (3 held back, waiting 3.0s in total).',
+      expect.objectContaining({ throttledRequests: 3 })
+    );
+  });
+});
+
+describe('parseRetryAfter', () => {
+  it('should parse seconds and HTTP dates', () => {
+    const now = Date.parse('2025-01-01T00:00:00Z');
+
+    expect(parseRetryAfter('1.5', now)).toBe(1500);
+    expect(parseRetryAfter('Wed, 01 Jan 2025 00:00:30 GMT', now)).toBe(30_000);
+    expect(parseRetryAfter('Tue, 31 Dec 2024 23:59:00 GMT', now)).toBe(0);
+  });
+
+  it('should ignore missing and malformed values', () => {
+    expect(parseRetryAfter(null)).toBeUndefined();
+    expect(parseRetryAfter('')).toBeUndefined();
+    expect(parseRetryAfter('-1')).toBeUndefined();
+    expect(parseRetryAfter('soon')).toBeUndefined();
+  });
+});
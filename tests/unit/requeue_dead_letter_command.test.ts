@@ -1,4 +1,4 @@
-import { retryFailedCommand } from '../../src/commands/retry_failed_command';
+import { requeueDeadLetterCommand } from '../../src/commands/requeue_dead_letter_command';
 import { SqliteQueue } from '../../src/utils/sqlite_queue';
 import { CodeChunk } from '../../src/utils/elasticsearch';
 import { appConfig } from '../../src/config';
@@ -12,6 +12,11 @@ vi.mock('../../src/config', () => ({
   appConfig: {
     queueBaseDir: './.test-queues',
   },
+  indexingConfig: {
+    queueMaxAttempts: 3,
+    queueRetryBaseDelayMs: 0,
+    queueRetryMaxDelayMs: 0,
+  },
   otelConfig: {
     enabled: false,
     serviceName: 'test-service',
@@ -92,7 +97,7 @@ const MOCK_CHUNK_3: CodeChunk = {
   updated_at: new Date().toISOString(),
 };
 
-describe('retryFailedCommand', () => {
+describe('requeueDeadLetterCommand', () => {
   const repoName = 'test-repo';
   const queueDir = path.join(appConfig.queueBaseDir, repoName);
   const dbPath = path.join(queueDir, 'queue.db');
@@ -103,17 +108,15 @@ describe('retryFailedCommand', () => {
     fs.mkdirSync(queueDir, { recursive: true });
 
     // Seed the database
-    queue = new SqliteQueue({ dbPath });
+    queue = new SqliteQueue({ dbPath, maxAttempts: 1 });
     await queue.initialize();
     await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2, MOCK_CHUNK_3]);
 
-    // Manually set some documents to 'failed'
+    // Exhaust the single attempt of documents 1 and 3 so they are dead-lettered
+    const dequeued = await queue.dequeue(3);
+    await queue.requeue(dequeued.filter((d) => !d.id.endsWith('_2')));
     const db = new Database(dbPath);
-    db.exec(`
-      UPDATE queue
-      SET status = 'failed', retry_count = 3
-      WHERE id IN (1, 3)
-    `);
+    db.exec("UPDATE queue SET status = 'pending' WHERE id = 2");
     db.close();
   });
 
@@ -122,9 +125,9 @@ describe('retryFailedCommand', () => {
     fs.rmSync(appConfig.queueBaseDir, { recursive: true, force: true });
   });
 
-  it('should reset all failed documents to pending and clear their retry count', async () => {
+  it('should move all dead-lettered documents back to pending and clear their retry count', async () => {
     // --- Execute the command ---
-    await retryFailedCommand.parseAsync(['', '', '--repo-name', repoName]);
+    await requeueDeadLetterCommand.parseAsync(['', '', '--repo-name', repoName]);
 
     // --- Assert the outcome ---
     const db = new Database(dbPath);
@@ -149,6 +152,26 @@ describe('retryFailedCommand', () => {
     expect(doc2?.status).toBe('pending');
     expect(doc2?.retry_count).toBe(0); // Should be untouched
 
+    const deadLetter = db.prepare('SELECT COUNT(*) as count FROM dead_letter').get() as { count: number };
+    expect(deadLetter.count).toBe(0);
+
+    db.close();
+  });
+
+  it('should migrate documents left in the legacy failed status', async () => {
+    const db = new Database(dbPath);
+    db.exec("UPDATE queue SET status = 'failed', retry_count = 2 WHERE id = 2");
     db.close();
+
+    await requeueDeadLetterCommand.parseAsync(['', '', '--repo-name', repoName]);
+
+    const check = new Database(dbPath);
+    const statuses = check.prepare('SELECT id, status FROM queue ORDER BY id').all();
+    check.close();
+    expect(statuses).toEqual([
+      { id: 1, status: 'pending' },
+      { id: 2, status: 'pending' },
+      { id: 3, status: 'pending' },
+    ]);
   });
 });
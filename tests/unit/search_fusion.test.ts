@@ -0,0 +1,43 @@
+import { fuseSearchResults } from '../../src/utils/search_fusion';
+import { SearchResult } from '../../src/utils/elasticsearch';
+import { describe, it, expect } from 'vitest';
+
+const result = (id: string, score: number): SearchResult => ({ id, score }) as SearchResult;
+
+describe('fuseSearchResults', () => {
+  const lexical = [result('exact', 12), result('both', 6), result('lexical-only', 3)];
+  const vector = [result('both', 0.9), result('vector-only', 0.8), result('exact', 0.5)];
+
+  it('should rank chunks found by both searches first with rrf', () => {
+    const fused = fuseSearchResults(lexical, vector, { fusion: 'rrf' });
+
+    expect(fused.map((hit) => hit.id)).toEqual(['both', 'exact', 'vector-only', 'lexical-only']);
+    expect(fused[0].score).toBeCloseTo(1 / 62 + 1 / 61);
+  });
+
+  it('should apply the weights and rank constant to reciprocal ranks', () => {
+    const fused = fuseSearchResults(lexical, vector, { fusion: 'rrf', lexicalWeight: 0, rankConstant: 1 });
+
+    expect(fused.map((hit) => hit.id)).toEqual(['both', 'vector-only', 'exact', 'lexical-only']);
+    expect(fused[0].score).toBeCloseTo(1 / 2);
+    expect(fused[3].score).toBe(0);
+  });
+
+  it('should sum scaled scores times the weights with linear', () => {
+    const fused = fuseSearchResults(lexical, vector, { fusion: 'linear', lexicalWeight: 2, vectorWeight: 1 });
+
+    // Lexical scores scale to 1, 1/3 and 0; vector scores to 1, 0.75 and 0.
+    expect(fused.map((hit) => [hit.id, Number(hit.score.toFixed(3))])).toEqual([
+      ['exact', 2],
+      ['both', 1.667],
+      ['vector-only', 0.75],
+      ['lexical-only', 0],
+    ]);
+  });
+
+  it('should give every hit of a list with equal scores the full weight', () => {
+    const fused = fuseSearchResults([result('a', 4), result('b', 4)], [], { fusion: 'linear' });
+
+    expect(fused.map((hit) => hit.score)).toEqual([1, 1]);
+  });
+});
@@ -0,0 +1,190 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect, vi } from 'vitest';
+
+import * as indexCommandModule from '../../src/commands/index_command';
+import { parseInterval, runServeLoop, serveCommand, ServeStatus } from '../../src/commands/serve_command';
+import { isShutdownRequested } from '../../src/utils/graceful_shutdown';
+import * as otelProvider from '../../src/utils/otel_provider';
+
+vi.mock('../../src/utils/graceful_shutdown', () => ({ isShutdownRequested: vi.fn() }));
+
+describe('parseInterval', () => {
+  it('should parse durations with a unit, and bare numbers as seconds', () => {
+    expect(parseInterval('250ms')).toBe(250);
+    expect(parseInterval('30s')).toBe(30_000);
+    expect(parseInterval('5m')).toBe(300_000);
+    expect(parseInterval('1.5h')).toBe(5_400_000);
+    expect(parseInterval('10')).toBe(10_000);
+  });
+
+  it('should reject empty, zero and unknown durations', () => {
+    for (const value of ['', '0', '0s', '5d', '-1m', 'm']) {
+      expect(() => parseInterval(value)).toThrow(`Invalid --interval value: ${value}.`);
+    }
+  });
+});
+
+describe('runServeLoop', () => {
+  let tempDir: string;
+  let clock: number;
+  const sleep = vi.fn(async (ms: number) => {
+    clock += ms;
+  });
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'serve-'));
+    clock = 0;
+    vi.mocked(isShutdownRequested).mockReturnValue(false);
+  });
+
+  afterEach(() => {
+    fs.rmSync(tempDir, { recursive: true, force: true });
+    process.exitCode = undefined;
+    vi.clearAllMocks();
+  });
+
+  it('should start runs on the interval and skip the ticks a long run outlasts', async () => {
+    const durations = [1_000, 12_000, 500];
+    const statusFile = path.join(tempDir, 'status.json');
+    const statuses: ServeStatus[] = [];
+    const runCycle = vi.fn(async (cycle: number) => {
+      statuses.push(JSON.parse(fs.readFileSync(statusFile, 'utf8')));
+      clock += durations[cycle - 1];
+      if (cycle === 3) {
+        vi.mocked(isShutdownRequested).mockReturnValue(true);
+      }
+    });
+
+    const status = await runServeLoop(runCycle, { intervalMs: 5_000, statusFile, now: () => clock, sleep });
+
+    expect(runCycle).toHaveBeenCalledTimes(3);
+    // Runs start at 0, 5s and, since the second one ran until 17s, at 20s.
+    expect(sleep.mock.calls).toEqual([[4_000], [3_000]]);
+    expect(statuses.map(({ state, lastRunStartedAt }) => [state, lastRunStartedAt])).toEqual([
+      ['running', new Date(0).toISOString()],
+      ['running', new Date(5_000).toISOString()],
+      ['running', new Date(20_000).toISOString()],
+    ]);
+    expect(status).toEqual({
+      state: 'stopped',
+      cycles: 3,
+      skippedCycles: 2,
+      lastRunStartedAt: new Date(20_000).toISOString(),
+      lastRunFinishedAt: new Date(20_500).toISOString(),
+      lastRunStatus: 'succeeded',
+      lastRunError: null,
+      nextRunAt: null,
+    });
+    expect(JSON.parse(fs.readFileSync(statusFile, 'utf8'))).toEqual(status);
+  });
+
+  it('should record a failed run and keep serving', async () => {
+    const statusFile = path.join(tempDir, 'status.json');
+    let statusAfterFirstRun: ServeStatus | undefined;
+    const runCycle = vi.fn(async (cycle: number) => {
+      if (cycle === 1) {
+        throw new Error('Elasticsearch is not reachable');
+      }
+      statusAfterFirstRun = JSON.parse(fs.readFileSync(statusFile, 'utf8'));
+      // A multi-repository run reports a failed repository through the exit code.
+      process.exitCode = 1;
+      vi.mocked(isShutdownRequested).mockReturnValue(true);
+    });
+
+    const status = await runServeLoop(runCycle, { intervalMs: 1_000, statusFile, now: () => clock, sleep });
+
+    expect(runCycle).toHaveBeenCalledTimes(2);
+    expect(statusAfterFirstRun).toMatchObject({
+      state: 'running',
+      cycles: 2,
+      lastRunStatus: 'failed',
+      lastRunError: 'Elasticsearch is not reachable',
+    });
+    expect(status).toMatchObject({
+      state: 'stopped',
+      cycles: 2,
+      lastRunStatus: 'failed',
+      lastRunError: 'One or more repositories failed to index.',
+    });
+    expect(process.exitCode).toBeUndefined();
+  });
+
+  it('should not start a run once a shutdown was requested', async () => {
+    vi.mocked(isShutdownRequested).mockReturnValue(true);
+    const runCycle = vi.fn(async () => {});
+
+    const status = await runServeLoop(runCycle, { intervalMs: 1_000, now: () => clock, sleep });
+
+    expect(runCycle).not.toHaveBeenCalled();
+    expect(status).toMatchObject({ state: 'stopped', cycles: 0, lastRunStatus: null });
+  });
+});
+
+describe('serveCommand', () => {
+  afterEach(() => {
+    vi.restoreAllMocks();
+  });
+
+  it('should run each cycle with the options of index', async () => {
+    vi.mocked(isShutdownRequested).mockReturnValueOnce(false).mockReturnValue(true);
+    vi.spyOn(otelProvider, 'shutdown').mockResolvedValue(undefined);
+    const indexSpy = vi.spyOn(indexCommandModule, 'indexRepos').mockResolvedValue(undefined);
+
+    await serveCommand.parseAsync([
+      'node',
+      'test',
+      '/path/to/repo',
+      '--no-dedup',
+      '--store-compressed',
+      '--max-chunk-tokens',
+      '512',
+      '--bulk-max-size',
+      '200',
+    ]);
+
+    expect(indexSpy).toHaveBeenCalledTimes(1);
+    expect(indexSpy).toHaveBeenCalledWith(
+      ['/path/to/repo'],
+      expect.objectContaining({
+        dedup: false,
+        storeCompressed: true,
+        maxChunkTokens: '512',
+        bulkMaxSize: '200',
+        serve: true,
+      })
+    );
+  });
+
+  it('should keep writing chunks without their content with --no-store-content', async () => {
+    vi.mocked(isShutdownRequested).mockReturnValueOnce(false).mockReturnValue(true);
+    vi.spyOn(otelProvider, 'shutdown').mockResolvedValue(undefined);
+    const indexSpy = vi.spyOn(indexCommandModule, 'indexRepos').mockResolvedValue(undefined);
+
+    await serveCommand.parseAsync(['node', 'test', '/path/to/repo', '--no-store-content']);
+
+    expect(indexSpy).toHaveBeenCalledWith(['/path/to/repo'], expect.objectContaining({ storeContent: false }));
+  });
+
+  it('should hold every cycle to --max-chunks-per-file', async () => {
+    vi.mocked(isShutdownRequested).mockReturnValueOnce(false).mockReturnValue(true);
+    vi.spyOn(otelProvider, 'shutdown').mockResolvedValue(undefined);
+    const indexSpy = vi.spyOn(indexCommandModule, 'indexRepos').mockResolvedValue(undefined);
+
+    await serveCommand.parseAsync([
+      'node',
+      'test',
+      '/path/to/repo',
+      '--max-chunks-per-file',
+      '500',
+      '--chunk-overflow',
+      'whole-file',
+    ]);
+
+    expect(indexSpy).toHaveBeenCalledWith(
+      ['/path/to/repo'],
+      expect.objectContaining({ maxChunksPerFile: '500', chunkOverflow: 'whole-file' })
+    );
+  });
+});
@@ -0,0 +1,128 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeAll, describe, it, expect, vi } from 'vitest';
+
+import * as elasticsearch from '../../src/utils/elasticsearch';
+import { CodeChunk, getChunkDocumentId, getLocationDocumentId } from '../../src/utils/elasticsearch';
+import { LanguageParser } from '../../src/utils/parser';
+import { parseDiffHunks, selectChangedChunks } from '../../src/utils/smart_incremental';
+
+const FIXTURE = fs.readFileSync(path.resolve(__dirname, '../fixtures/smart_incremental.ts'), 'utf8');
+
+describe('parseDiffHunks', () => {
+  it('should collect the changed lines of each file', () => {
+    const diff = [
+      'diff --git src/a.ts src/a.ts',
+      'index 1111111..2222222 100644',
+      '--- src/a.ts',
+      '+++ src/a.ts',
+      '@@ -7 +7 @@ export function formatAmount(amount: number): string {',
+      '-  return amount.toFixed(2);',
+      '+  return amount.toFixed(3);',
+      '@@ -20,0 +21,2 @@',
+      '+++ an added line that starts like a file header',
+      '+',
+      '@@ -30,2 +32,0 @@',
+      '-',
+      '-',
+      'diff --git src/my file.ts src/my file.ts',
+      '--- src/my file.ts\t',
+      '+++ src/my file.ts\t',
+      '@@ -1,3 +1,4 @@',
+      'diff --git "src/\\303\\251.ts" "src/\\303\\251.ts"',
+      '--- "src/\\303\\251.ts"',
+      '+++ "src/\\303\\251.ts"',
+      '@@ -1 +1 @@',
+      'diff --git src/logo.png src/logo.png',
+      'Binary files src/logo.png and src/logo.png differ',
+    ].join('\n');
+
+    expect(parseDiffHunks(diff)).toEqual(
+      new Map([
+        [
+          'src/a.ts',
+          [
+            { start: 7, end: 7 },
+            { start: 21, end: 22 },
+            { start: 32, end: 33 },
+          ],
+        ],
+        ['src/my file.ts', [{ start: 1, end: 4 }]],
+      ])
+    );
+  });
+});
+
+describe('selectChangedChunks', () => {
+  let parser: LanguageParser;
+  let tmpDir: string;
+
+  beforeAll(() => {
+    parser = new LanguageParser('typescript');
+    tmpDir = fs.mkdtempSync(path.join(os.tmpdir(), 'smart-incremental-'));
+    return () => fs.rmSync(tmpDir, { recursive: true, force: true });
+  });
+
+  afterEach(() => {
+    vi.restoreAllMocks();
+  });
+
+  const parse = (content: string): CodeChunk[] => {
+    const filePath = path.join(tmpDir, 'amounts.ts');
+    fs.writeFileSync(filePath, content);
+    return parser.parseFile(filePath, 'main', 'src/amounts.ts').chunks;
+  };
+
+  /** Serves the locations of `chunks` as the ones in the index. */
+  const mockIndexedLocations = (chunks: CodeChunk[]) => {
+    const indexed = new Map(
+      chunks.map((chunk) => [
+        getLocationDocumentId(chunk),
+        { chunk_id: getChunkDocumentId(chunk), startLine: chunk.startLine, endLine: chunk.endLine },
+      ])
+    );
+    vi.spyOn(elasticsearch, 'getExistingLocations').mockImplementation(
+      async (_index, ids) => new Map(ids.filter((id) => indexed.has(id)).map((id) => [id, indexed.get(id) ?? {}]))
+    );
+  };
+
+  it('should only select the chunks of an edited function and keep the others', async () => {
+    const before = parse(FIXTURE);
+    const after = parse(FIXTURE.replace('amount.toFixed(2)', 'amount.toFixed(3)'));
+    mockIndexedLocations(before);
+
+    const result = await selectChangedChunks(after, [{ start: 7, end: 7 }], 'code-chunks');
+
+    expect(result.chunks.length).toBeGreaterThan(0);
+    expect(result.chunks.every((chunk) => chunk.content.includes('toFixed(3)'))).toBe(true);
+    expect(result.keptLocationIds).toEqual(
+      before.filter((chunk) => !chunk.content.includes('toFixed')).map((chunk) => getLocationDocumentId(chunk))
+    );
+  });
+
+  it('should select the chunks moved by added lines, which keep their chunk documents', async () => {
+    const before = parse(FIXTURE);
+    const after = parse(`const CURRENCY = 'EUR';\n\n${FIXTURE}`);
+    mockIndexedLocations(before);
+
+    const result = await selectChangedChunks(after, [{ start: 1, end: 2 }], 'code-chunks');
+
+    expect(result.keptLocationIds).toEqual([]);
+    const movedChunkIds = result.chunks
+      .filter((chunk) => !chunk.content.includes('CURRENCY'))
+      .map((chunk) => getChunkDocumentId(chunk));
+    expect(movedChunkIds).toEqual(before.map((chunk) => getChunkDocumentId(chunk)));
+  });
+
+  it('should select untouched chunks whose indexed location has other file imports', async () => {
+    const chunks = parse(FIXTURE);
+    vi.spyOn(elasticsearch, 'getExistingLocations').mockImplementation(
+      async (_index, ids) => new Map(ids.map((id) => [id, { file_imports: ['src/money.ts'] }]))
+    );
+
+    const result = await selectChangedChunks(chunks, [], 'code-chunks');
+
+    expect(result).toEqual({ chunks, keptLocationIds: [] });
+  });
+});
@@ -0,0 +1,90 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { execFileSync } from 'child_process';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { readChunkSource, readSourceSnippet } from '../../src/utils/source_snippet';
+
+describe('readSourceSnippet', () => {
+  let repoRoot: string;
+  const source = 'package main\n\nfunc greet() string {\n\treturn "hi"\n}\n';
+
+  beforeEach(() => {
+    repoRoot = fs.mkdtempSync(path.join(os.tmpdir(), 'source-snippet-'));
+    fs.writeFileSync(path.join(repoRoot, 'main.go'), source);
+  });
+
+  afterEach(() => {
+    fs.rmSync(repoRoot, { recursive: true, force: true });
+  });
+
+  const gitFileHash = () => execFileSync('git', ['hash-object', path.join(repoRoot, 'main.go')]).toString().trim();
+
+  it('should read the line range of a file unchanged since it was indexed', () => {
+    const location = { filePath: 'main.go', startLine: 3, endLine: 5, git_file_hash: gitFileHash() };
+
+    expect(readSourceSnippet(repoRoot, location)).toEqual({
+      filePath: 'main.go',
+      startLine: 3,
+      endLine: 5,
+      content: 'func greet() string {\n\treturn "hi"\n}',
+      stale: false,
+    });
+  });
+
+  it('should flag the lines of a file that changed since it was indexed', () => {
+    const location = { filePath: 'main.go', startLine: 3, endLine: 5, git_file_hash: gitFileHash() };
+    fs.writeFileSync(path.join(repoRoot, 'main.go'), `// Package main greets.\n${source}`);
+
+    expect(readSourceSnippet(repoRoot, location)).toMatchObject({
+      content: '\nfunc greet() string {\n\treturn "hi"',
+      stale: true,
+    });
+  });
+
+  it('should flag missing files and paths outside the checkout', () => {
+    expect(readSourceSnippet(repoRoot, { filePath: 'gone.go', startLine: 1, endLine: 2 })).toEqual({
+      filePath: 'gone.go',
+      startLine: 1,
+      endLine: 2,
+      stale: true,
+    });
+    expect(readSourceSnippet(repoRoot, { filePath: '../main.go', startLine: 1, endLine: 2 }).stale).toBe(true);
+  });
+});
+
+describe('readChunkSource', () => {
+  let repoRoot: string;
+
+  beforeEach(() => {
+    repoRoot = fs.mkdtempSync(path.join(os.tmpdir(), 'chunk-source-'));
+    fs.writeFileSync(path.join(repoRoot, 'a.ts'), 'export const a = 1;\n');
+    fs.writeFileSync(path.join(repoRoot, 'b.ts'), 'export const a = 1;\n');
+  });
+
+  afterEach(() => {
+    fs.rmSync(repoRoot, { recursive: true, force: true });
+  });
+
+  it('should prefer a location whose file is unchanged', () => {
+    const hash = execFileSync('git', ['hash-object', path.join(repoRoot, 'b.ts')]).toString().trim();
+
+    const snippet = readChunkSource(repoRoot, [
+      { filePath: 'a.ts', startLine: 1, endLine: 1, git_file_hash: 'outdated' },
+      { filePath: 'b.ts', startLine: 1, endLine: 1, git_file_hash: hash },
+    ]);
+
+    expect(snippet).toMatchObject({ filePath: 'b.ts', content: 'export const a = 1;', stale: false });
+  });
+
+  it('should fall back to the first location that can be read', () => {
+    const snippet = readChunkSource(repoRoot, [
+      { filePath: 'gone.ts', startLine: 1, endLine: 1 },
+      { filePath: 'a.ts', startLine: 1, endLine: 1, git_file_hash: 'outdated' },
+    ]);
+
+    expect(snippet).toMatchObject({ filePath: 'a.ts', stale: true });
+    expect(readChunkSource(repoRoot, [])).toBeUndefined();
+  });
+});
@@ -3,8 +3,10 @@ import fs from 'fs';
 import os from 'os';
 import { describe, it, expect, beforeEach, afterEach } from 'vitest';
 
-import { SqliteQueue } from '../../src/utils/sqlite_queue';
+import Database from 'better-sqlite3';
+import { SqliteQueue, computeBackoffDelayMs } from '../../src/utils/sqlite_queue';
 import { CodeChunk } from '../../src/utils/elasticsearch';
+import { withTestEnv } from './utils/test_env';
 
 /**
  * Test interface exposing private members of SqliteQueue for unit testing.
@@ -85,6 +87,63 @@ describe('SqliteQueue', () => {
     expect(dequeued.map((d) => d.document)).toEqual([MOCK_CHUNK_1, MOCK_CHUNK_2]);
   });
 
+  it('should return the row id range assigned to an enqueued batch', async () => {
+    const first = await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+    const second = await queue.enqueue([MOCK_CHUNK_1]);
+
+    expect(first).toEqual({ firstId: 1, lastId: 2 });
+    expect(second).toEqual({ firstId: 3, lastId: 3 });
+    expect(await queue.enqueue([])).toBeUndefined();
+  });
+
+  it('should tell whether documents of a row id range are still in the queue, requeued ones included', async () => {
+    const range = await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+    expect(range).toEqual({ firstId: 1, lastId: 2 });
+
+    const [first, second] = await queue.dequeue(2);
+    await queue.requeue([first]);
+    await queue.commit([second]);
+    expect(queue.hasDocumentsInRange(1, 2)).toBe(true);
+    expect(queue.hasDocumentsInRange(2, 2)).toBe(false);
+    expect(queue.hasDocumentsInRange(3, 10)).toBe(false);
+  });
+
+  it('should dequeue higher priority documents first and keep FIFO order within a priority', async () => {
+    await queue.enqueue([MOCK_CHUNK_1]);
+    await queue.enqueue([MOCK_CHUNK_2, MOCK_CHUNK_1], { priority: 10 });
+    await queue.enqueue([MOCK_CHUNK_2]);
+
+    const dequeued = await queue.dequeue(4);
+
+    expect(dequeued.map((d) => d.id.split('_').pop())).toEqual(['2', '3', '1', '4']);
+  });
+
+  it('should add the priority column with a default of 0 to existing databases', async () => {
+    const legacyDbPath = path.join(queueDir, 'legacy.db');
+    const legacyDb = new Database(legacyDbPath);
+    legacyDb.exec(`
+      CREATE TABLE queue (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        batch_id TEXT NOT NULL,
+        document TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        retry_count INTEGER NOT NULL DEFAULT 0,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+      );
+    `);
+    legacyDb.prepare('INSERT INTO queue (batch_id, document) VALUES (?, ?)').run('legacy', JSON.stringify(MOCK_CHUNK_1));
+    legacyDb.close();
+
+    const upgraded = new SqliteQueue({ dbPath: legacyDbPath });
+    await upgraded.initialize();
+    await upgraded.enqueue([MOCK_CHUNK_2], { priority: 10 });
+
+    const dequeued = await upgraded.dequeue(2);
+    upgraded.close();
+
+    expect(dequeued.map((d) => d.document.filePath)).toEqual(['test2.ts', 'test1.ts']);
+  });
+
   it('should only dequeue up to the specified count', async () => {
     await queue.enqueue([MOCK_CHUNK_1]);
     await queue.enqueue([MOCK_CHUNK_2]);
@@ -143,7 +202,7 @@ describe('SqliteQueue', () => {
     noContextQueue.close();
   });
 
-  it('should move documents to failed status after MAX_RETRIES', async () => {
+  it('should dead-letter documents after MAX_RETRIES', async () => {
     await queue.enqueue([MOCK_CHUNK_1]);
 
     // Simulate MAX_RETRIES (3) requeue attempts
@@ -153,7 +212,7 @@ describe('SqliteQueue', () => {
       await queue.requeue(dequeued);
     }
 
-    // After 3 requeues, documents should be in failed status and not dequeued
+    // After 3 requeues, documents should be dead-lettered and not dequeued
     const shouldBeEmpty = await queue.dequeue(1);
     expect(shouldBeEmpty.length).toBe(0);
   });
@@ -176,6 +235,130 @@ describe('SqliteQueue', () => {
     expect(dequeued3.length).toBe(1);
   });
 
+  it('should honour the maxAttempts option', async () => {
+    const singleAttemptQueue = new SqliteQueue({ dbPath: path.join(queueDir, 'single.db'), maxAttempts: 1 });
+    await singleAttemptQueue.initialize();
+
+    await singleAttemptQueue.enqueue([MOCK_CHUNK_1]);
+    const dequeued = await singleAttemptQueue.dequeue(1);
+    await singleAttemptQueue.requeue(dequeued);
+
+    expect((await singleAttemptQueue.dequeue(1)).length).toBe(0);
+    singleAttemptQueue.close();
+  });
+
+  it('should move exhausted documents to the dead-letter table with their last error', async () => {
+    const singleAttemptQueue = new SqliteQueue({ dbPath: path.join(queueDir, 'dead-letter.db'), maxAttempts: 1 });
+    await singleAttemptQueue.initialize();
+
+    await singleAttemptQueue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+    const [first] = await singleAttemptQueue.dequeue(1);
+    await singleAttemptQueue.requeue([first], { errors: new Map([[first.id, 'input too large']]) });
+
+    expect(singleAttemptQueue.getDeadLetteredCount()).toBe(1);
+    expect(singleAttemptQueue.getDeadLetterEntries()).toEqual([
+      expect.objectContaining({ id: 1, attempts: 1, lastError: 'input too large' }),
+    ]);
+    // The remaining document is still processed normally.
+    expect((await singleAttemptQueue.dequeue(10)).map((d) => d.document.chunk_hash)).toEqual([
+      MOCK_CHUNK_2.chunk_hash,
+    ]);
+    singleAttemptQueue.close();
+  });
+
+  it('should requeue dead-lettered documents with a fresh attempt budget', async () => {
+    const singleAttemptQueue = new SqliteQueue({ dbPath: path.join(queueDir, 'dead-letter.db'), maxAttempts: 1 });
+    await singleAttemptQueue.initialize();
+
+    await singleAttemptQueue.enqueue([MOCK_CHUNK_1]);
+    await singleAttemptQueue.requeue(await singleAttemptQueue.dequeue(1));
+
+    expect(singleAttemptQueue.requeueDeadLetter()).toBe(1);
+    expect(singleAttemptQueue.getDeadLetterEntries()).toEqual([]);
+    const [requeued] = await singleAttemptQueue.dequeue(1);
+    expect(requeued.id.split('_').pop()).toBe('1');
+    expect(requeued.document.chunk_hash).toBe(MOCK_CHUNK_1.chunk_hash);
+    singleAttemptQueue.close();
+  });
+
+  it('should record the last error for requeued documents', async () => {
+    await queue.enqueue([MOCK_CHUNK_1]);
+    const dequeued = await queue.dequeue(1);
+    await queue.requeue(dequeued, { errors: new Map([[dequeued[0].id, 'es_rejected_execution_exception']]) });
+
+    const db = new Database(dbPath, { readonly: true });
+    const row = db.prepare('SELECT last_error, last_error_at FROM queue WHERE id = 1').get() as {
+      last_error: string | null;
+      last_error_at: string | null;
+    };
+    db.close();
+
+    expect(row.last_error).toBe('es_rejected_execution_exception');
+    expect(row.last_error_at).not.toBeNull();
+  });
+
+  it('should claim each dequeued batch under its own lease', async () => {
+    await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+    const [first] = await queue.dequeue(1);
+    const [second] = await queue.dequeue(1);
+
+    expect(first.leaseId).toBeDefined();
+    expect(second.leaseId).toBeDefined();
+    expect(first.leaseId).not.toBe(second.leaseId);
+  });
+
+  it('should not requeue documents whose lease was lost to another worker', async () => {
+    await queue.enqueue([MOCK_CHUNK_1]);
+    const [stale] = await queue.dequeue(1);
+
+    // Stale recovery hands the row to a second worker while the first is still indexing it.
+    const db = new Database(dbPath);
+    db.prepare("UPDATE queue SET status = 'pending', lease_id = NULL").run();
+    db.close();
+    const [reclaimed] = await queue.dequeue(1);
+
+    await queue.requeue([stale]);
+
+    const check = new Database(dbPath);
+    const row = check.prepare('SELECT status, retry_count, lease_id FROM queue').get() as {
+      status: string;
+      retry_count: number;
+      lease_id: string;
+    };
+    check.close();
+    expect(row).toEqual({ status: 'processing', retry_count: 0, lease_id: reclaimed.leaseId });
+  });
+
+  it('should renew the lease of in-flight documents so they are not requeued as stale', async () => {
+    await queue.enqueue([MOCK_CHUNK_1]);
+    const [inFlight] = await queue.dequeue(1);
+
+    const db = new Database(dbPath);
+    db.prepare("UPDATE queue SET processing_started_at = datetime('now', '-1 hour')").run();
+    db.close();
+
+    expect(queue.renewLeases([inFlight.leaseId!])).toBe(1);
+    await queue.requeueStaleTasks();
+
+    expect(await queue.dequeue(1)).toHaveLength(0);
+  });
+
+  it('should delay requeued documents until their backoff expires', async () => {
+    await withTestEnv({ SCS_IDXR_QUEUE_RETRY_BASE_DELAY_MS: '60000' }, async () => {
+      await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+      const [first] = await queue.dequeue(1);
+      await queue.requeue([first]);
+
+      // The delayed document is skipped while the other one is still available.
+      const available = await queue.dequeue(2);
+      expect(available.map((d) => d.document.chunk_hash)).toEqual([MOCK_CHUNK_2.chunk_hash]);
+
+      const delay = queue.getNextAttemptDelayMs();
+      expect(delay).toBeGreaterThan(0);
+      expect(delay).toBeLessThanOrEqual(60000);
+    });
+  });
+
   it('should cache queue stats to prevent blocking event loop', async () => {
     // Add some documents to have non-zero stats
     await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
@@ -236,7 +419,7 @@ describe('SqliteQueue', () => {
     expect(refreshedStats.pending).toBe(2);
   });
 
-  it('should clear all items including failed documents', async () => {
+  it('should clear all items including dead-lettered documents', async () => {
     // Enqueue documents
     await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
 
@@ -266,4 +449,271 @@ describe('SqliteQueue', () => {
     expect(fresh.length).toBe(1);
     expect(fresh[0].document.chunk_hash).toBe(MOCK_CHUNK_1.chunk_hash);
   });
+
+  it('should count committed documents until the next enqueue session starts', async () => {
+    await queue.markEnqueueStarted();
+    await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+    await queue.commit(await queue.dequeue(1));
+    expect(queue.getCompletedCount()).toBe(1);
+
+    // A resumed session keeps the count while documents are still queued
+    await queue.markEnqueueStarted();
+    await queue.commit(await queue.dequeue(1));
+    expect(queue.getCompletedCount()).toBe(2);
+
+    await queue.markEnqueueStarted();
+    expect(queue.getCompletedCount()).toBe(0);
+
+    await queue.enqueue([MOCK_CHUNK_1]);
+    await queue.commit(await queue.dequeue(1));
+    await queue.clear();
+    expect(queue.getCompletedCount()).toBe(0);
+  });
+
+  describe('flush', () => {
+    it('should checkpoint the whole WAL into the database file', async () => {
+      await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+      await queue.commit(await queue.dequeue(1));
+      expect(fs.statSync(`${dbPath}-wal`).size).toBeGreaterThan(0);
+
+      queue.flush();
+
+      expect(fs.statSync(`${dbPath}-wal`).size).toBe(0);
+      expect(queue.getRemainingCount()).toBe(1);
+    });
+
+    it('should do nothing once the queue is closed', () => {
+      queue.close();
+
+      expect(() => queue.flush()).not.toThrow();
+    });
+  });
+
+  describe('compaction', () => {
+    const bigChunk = (index: number): CodeChunk => ({
+      ...MOCK_CHUNK_1,
+      chunk_hash: `chunk_hash_${index}`,
+      content: 'x'.repeat(4096),
+    });
+
+    it('should shrink the database file after many documents were committed', async () => {
+      await queue.enqueue(Array.from({ length: 500 }, (_, index) => bigChunk(index)));
+      await queue.commit(await queue.dequeue(500));
+      expect(queue.getFreeBytes()).toBeGreaterThan(1024 * 1024);
+
+      const result = queue.compact();
+
+      expect(result.vacuumed).toBe(true);
+      expect(result.bytesAfter).toBeLessThan(result.bytesBefore);
+      expect(queue.getFreeBytes()).toBe(0);
+    });
+
+    it('should restart row ids at 1 once the queue is empty', async () => {
+      await queue.enqueue([MOCK_CHUNK_1, MOCK_CHUNK_2]);
+      await queue.commit(await queue.dequeue(2));
+
+      expect(queue.compact().sequenceReset).toBe(true);
+      expect(await queue.enqueue([MOCK_CHUNK_1])).toEqual({ firstId: 1, lastId: 1 });
+
+      await queue.clear();
+      expect(await queue.enqueue([MOCK_CHUNK_2])).toEqual({ firstId: 1, lastId: 1 });
+    });
+
+    it('should keep row ids increasing while dead-lettered documents remain', async () => {
+      const deadLetterQueue = new SqliteQueue({ dbPath: path.join(queueDir, 'dead_letter.db'), maxAttempts: 1 });
+      await deadLetterQueue.initialize();
+      await deadLetterQueue.enqueue([MOCK_CHUNK_1]);
+      await deadLetterQueue.requeue(await deadLetterQueue.dequeue(1));
+
+      expect(deadLetterQueue.compact().sequenceReset).toBe(false);
+      expect(await deadLetterQueue.enqueue([MOCK_CHUNK_2])).toEqual({ firstId: 2, lastId: 2 });
+      deadLetterQueue.close();
+    });
+
+    it('should skip the VACUUM when free pages hold fewer bytes than the threshold', async () => {
+      await queue.enqueue([MOCK_CHUNK_1]);
+      await queue.commit(await queue.dequeue(1));
+
+      expect(queue.compact({ minFreeBytes: 64 * 1024 * 1024 }).vacuumed).toBe(false);
+    });
+  });
+
+  describe('file content hashes', () => {
+    const secondChunk: CodeChunk = { ...MOCK_CHUNK_1, chunk_hash: 'chunk_hash_1b', startLine: 2, endLine: 2 };
+
+    it('should record the hash only after the last document of the file is committed', async () => {
+      await queue.enqueue([MOCK_CHUNK_1, secondChunk], { sourceFile: { filePath: 'test1.ts', sha256: 'abc' } });
+
+      const [first] = await queue.dequeue(1);
+      await queue.commit([first]);
+      expect(queue.getFileHashes().has('test1.ts')).toBe(false);
+
+      const rest = await queue.dequeue(1);
+      await queue.commit(rest);
+      expect(queue.getFileHashes().get('test1.ts')).toBe('abc');
+    });
+
+    it('should record the hash immediately for a file without documents', async () => {
+      await queue.enqueue([], { sourceFile: { filePath: 'empty.ts', sha256: 'def' } });
+
+      expect(queue.getFileHashes().get('empty.ts')).toBe('def');
+    });
+
+    it('should drop the previous hash as soon as new content is enqueued', async () => {
+      await queue.enqueue([], { sourceFile: { filePath: 'test1.ts', sha256: 'old' } });
+      await queue.enqueue([MOCK_CHUNK_1], { sourceFile: { filePath: 'test1.ts', sha256: 'new' } });
+
+      expect(queue.getFileHashes().has('test1.ts')).toBe(false);
+    });
+
+    it('should never record the hash of a file with a dead-lettered document', async () => {
+      const singleAttemptQueue = new SqliteQueue({ dbPath, maxAttempts: 1 });
+      await singleAttemptQueue.initialize();
+      try {
+        await singleAttemptQueue.enqueue([MOCK_CHUNK_1, secondChunk], {
+          sourceFile: { filePath: 'test1.ts', sha256: 'abc' },
+        });
+        const [first, second] = await singleAttemptQueue.dequeue(2);
+        await singleAttemptQueue.requeue([first]);
+        await singleAttemptQueue.commit([second]);
+
+        expect(singleAttemptQueue.getFileHashes().has('test1.ts')).toBe(false);
+      } finally {
+        singleAttemptQueue.close();
+      }
+    });
+
+    it('should forget hashes of deleted files and keep them when the queue is cleared', async () => {
+      await queue.enqueue([], { sourceFile: { filePath: 'a.ts', sha256: '1' } });
+      await queue.enqueue([], { sourceFile: { filePath: 'b.ts', sha256: '2' } });
+
+      await queue.deleteFileHashes(['a.ts']);
+      await queue.clear();
+      expect(queue.getFileHashes()).toEqual(new Map([['b.ts', '2']]));
+
+      await queue.clearFileHashes();
+      expect(queue.getFileHashes().size).toBe(0);
+    });
+
+    it('should record the chunk count and index of each file once it is indexed', async () => {
+      await queue.enqueue([MOCK_CHUNK_1, secondChunk], {
+        sourceFile: { filePath: 'test1.ts', sha256: 'abc', indexName: 'code-search' },
+      });
+      await queue.enqueue([MOCK_CHUNK_2], {
+        sourceFile: { filePath: 'test2.ts', sha256: 'def', chunkCount: 4, indexName: 'other-index' },
+      });
+      expect(queue.getIndexedFiles()).toEqual([]);
+      expect(queue.getPendingFileCount()).toBe(2);
+
+      await queue.commit(await queue.dequeue(3));
+
+      expect(queue.getPendingFileCount()).toBe(0);
+      expect(queue.getIndexedFiles('code-search')).toEqual([
+        { filePath: 'test1.ts', sha256: 'abc', indexedAt: expect.any(String), chunkCount: 2, indexName: 'code-search' },
+      ]);
+      expect(queue.getIndexedFiles().map((file) => [file.filePath, file.chunkCount])).toEqual([
+        ['test1.ts', 2],
+        ['test2.ts', 4],
+      ]);
+    });
+
+    it('should keep the hashes of a file_hashes table from older versions', async () => {
+      const legacyDbPath = path.join(queueDir, 'legacy-hashes.db');
+      const legacyDb = new Database(legacyDbPath);
+      legacyDb.exec(`
+        CREATE TABLE file_hashes (
+          file_path TEXT PRIMARY KEY,
+          sha256 TEXT NOT NULL,
+          indexed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+        );
+      `);
+      legacyDb.prepare('INSERT INTO file_hashes (file_path, sha256) VALUES (?, ?)').run('old.ts', 'abc');
+      legacyDb.close();
+
+      const upgraded = new SqliteQueue({ dbPath: legacyDbPath });
+      await upgraded.initialize();
+      try {
+        expect(upgraded.getIndexedFiles('code-search')).toEqual([
+          { filePath: 'old.ts', sha256: 'abc', indexedAt: expect.any(String), chunkCount: 0, indexName: null },
+        ]);
+      } finally {
+        upgraded.close();
+      }
+    });
+  });
+
+  describe('stale locations', () => {
+    it('should only return marked files once their documents are committed', async () => {
+      await queue.markLocationsStale(['test1.ts', 'test2.ts'], 1000);
+      await queue.enqueue([MOCK_CHUNK_1]);
+      expect(queue.getPrunableLocationFiles()).toEqual(new Map([['test2.ts', 1000]]));
+
+      await queue.commit(await queue.dequeue(1));
+      expect(queue.getPrunableLocationFiles()).toEqual(
+        new Map([
+          ['test1.ts', 1000],
+          ['test2.ts', 1000],
+        ])
+      );
+    });
+
+    it('should keep files with dead-lettered documents', async () => {
+      const singleAttemptQueue = new SqliteQueue({ dbPath, maxAttempts: 1 });
+      await singleAttemptQueue.initialize();
+      try {
+        await singleAttemptQueue.markLocationsStale(['test1.ts'], 1000);
+        await singleAttemptQueue.enqueue([MOCK_CHUNK_1]);
+        await singleAttemptQueue.requeue(await singleAttemptQueue.dequeue(1));
+
+        expect(singleAttemptQueue.getPrunableLocationFiles().size).toBe(0);
+      } finally {
+        singleAttemptQueue.close();
+      }
+    });
+
+    it('should keep a newer mark when clearing pruned files', async () => {
+      await queue.markLocationsStale(['test1.ts', 'test2.ts'], 1000);
+      const pruned = queue.getPrunableLocationFiles();
+      await queue.markLocationsStale(['test2.ts'], 2000);
+
+      await queue.clearPrunedLocationFiles(pruned);
+      expect(queue.getPrunableLocationFiles()).toEqual(new Map([['test2.ts', 2000]]));
+    });
+  });
+
+  describe('parse failures', () => {
+    it('should keep the last failure of each file until it is cleared', async () => {
+      queue.recordParseFailure({ file: 'b.go', error: 'first error', language: 'go' });
+      queue.recordParseFailure({ file: 'b.go', error: 'syntax error', language: 'go', wholeFile: true });
+      queue.recordParseFailure({ file: 'a.bin', error: 'unreadable' });
+
+      expect(queue.getParseFailures()).toEqual([
+        { filePath: 'a.bin', language: null, error: 'unreadable', wholeFile: false, failedAt: expect.any(String) },
+        { filePath: 'b.go', language: 'go', error: 'syntax error', wholeFile: true, failedAt: expect.any(String) },
+      ]);
+
+      queue.clearParseFailures(['b.go']);
+      expect(queue.getParseFailures().map((failure) => failure.filePath)).toEqual(['a.bin']);
+
+      await queue.clear();
+      expect(queue.getParseFailures()).toEqual([]);
+    });
+  });
+});
+
+describe('computeBackoffDelayMs', () => {
+  it('should double the delay per attempt with jitter in the upper half', () => {
+    expect(computeBackoffDelayMs(1, 1000, 300000, () => 0)).toBe(500);
+    expect(computeBackoffDelayMs(1, 1000, 300000, () => 0.999)).toBe(999);
+    expect(computeBackoffDelayMs(3, 1000, 300000, () => 0)).toBe(2000);
+  });
+
+  it('should cap the delay at the maximum', () => {
+    expect(computeBackoffDelayMs(20, 1000, 300000, () => 0.999)).toBeLessThan(300000);
+    expect(computeBackoffDelayMs(20, 1000, 300000, () => 0)).toBe(150000);
+  });
+
+  it('should return 0 when backoff is disabled', () => {
+    expect(computeBackoffDelayMs(5, 0, 300000)).toBe(0);
+  });
 });
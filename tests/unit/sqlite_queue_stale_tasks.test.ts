@@ -5,6 +5,7 @@ import path from 'path';
 import os from 'os';
 import Database from 'better-sqlite3';
 import { describe, it, expect, beforeEach, afterEach } from 'vitest';
+import { withTestEnv } from './utils/test_env';
 
 describe('SqliteQueue - Stale Task Recovery', () => {
   let testDbDir: string;
@@ -208,5 +209,84 @@ describe('SqliteQueue - Stale Task Recovery', () => {
       expect(requeuedBatch).toHaveLength(1);
       expect(requeuedBatch[0].document.filePath).toBe('/test/stale.ts');
     });
+
+    it('SHOULD use the configured visibility timeout', async () => {
+      const testDoc: CodeChunk = {
+        type: 'code',
+        language: 'typescript',
+        filePath: '/test/visibility.ts',
+        directoryPath: '/test',
+        directoryName: 'test',
+        directoryDepth: 1,
+        git_file_hash: 'vis123',
+        git_branch: 'main',
+        chunk_hash: 'vis456',
+        startLine: 1,
+        endLine: 10,
+        content: 'visibility content',
+        semantic_text: 'visibility content',
+        created_at: new Date().toISOString(),
+        updated_at: new Date().toISOString(),
+      };
+
+      await queue.enqueue([testDoc]);
+      expect(await queue.dequeue(1)).toHaveLength(1);
+
+      // Two minutes is within the default timeout but past a one-minute timeout
+      const db = new Database(testDbPath);
+      const twoMinutesAgo = new Date(Date.now() - 2 * 60 * 1000)
+        .toISOString()
+        .replace('T', ' ')
+        .replace(/\.\d{3}Z$/, '');
+      db.prepare('UPDATE queue SET processing_started_at = ?').run(twoMinutesAgo);
+      db.close();
+
+      await queue.requeueStaleTasks();
+      expect(await queue.dequeue(1)).toHaveLength(0);
+
+      await withTestEnv({ SCS_IDXR_QUEUE_VISIBILITY_TIMEOUT_MS: '60000' }, () => queue.requeueStaleTasks());
+      expect(await queue.dequeue(1)).toHaveLength(1);
+    });
+  });
+
+  describe('WHEN dequeuing after a lease expired', () => {
+    it('SHOULD hand the document out again under a new lease without a stale task check', async () => {
+      const testDoc: CodeChunk = {
+        type: 'code',
+        language: 'typescript',
+        filePath: '/test/expired.ts',
+        directoryPath: '/test',
+        directoryName: 'test',
+        directoryDepth: 1,
+        git_file_hash: 'exp123',
+        git_branch: 'main',
+        chunk_hash: 'exp456',
+        startLine: 1,
+        endLine: 10,
+        content: 'expired content',
+        semantic_text: 'expired content',
+        created_at: new Date().toISOString(),
+        updated_at: new Date().toISOString(),
+      };
+
+      await queue.enqueue([testDoc]);
+      const [crashed] = await queue.dequeue(1);
+      expect(await queue.dequeue(1)).toHaveLength(0);
+
+      // The worker holding the lease crashed six minutes ago and never renewed it.
+      const db = new Database(testDbPath);
+      const sixMinutesAgo = new Date(Date.now() - 6 * 60 * 1000)
+        .toISOString()
+        .replace('T', ' ')
+        .replace(/\.\d{3}Z$/, '');
+      db.prepare('UPDATE queue SET processing_started_at = ?').run(sixMinutesAgo);
+      db.close();
+
+      const [reclaimed] = await queue.dequeue(1);
+      expect(reclaimed.document.filePath).toBe('/test/expired.ts');
+      expect(reclaimed.leaseId).not.toBe(crashed.leaseId);
+      // Only one worker gets it.
+      expect(await queue.dequeue(1)).toHaveLength(0);
+    });
   });
 });
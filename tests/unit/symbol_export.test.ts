@@ -0,0 +1,120 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { CodeChunk, getChunkDocumentId } from '../../src/utils/elasticsearch';
+import { LanguageParser } from '../../src/utils/parser';
+import { collectSymbols, SymbolExportWriter, SymbolRecord } from '../../src/utils/symbol_export';
+
+describe('collectSymbols', () => {
+  it('should describe each definition the parser chunked, with its references', () => {
+    const filePath = path.resolve(__dirname, '../fixtures/usage.go');
+    const { chunks } = new LanguageParser('go').parseFile(filePath, 'main', 'tests/fixtures/usage.go');
+    const main = chunks.find((chunk) => chunk.symbol_fqn === 'main.main');
+
+    const symbols = collectSymbols(chunks, 'demo');
+
+    expect(symbols.map((symbol) => symbol.name)).toEqual(
+      expect.arrayContaining(['main.greet', 'main.Greeter', 'main.main'])
+    );
+    expect(symbols.find((symbol) => symbol.name === 'main.main')).toEqual({
+      id: getChunkDocumentId(main!),
+      repo: 'demo',
+      name: 'main.main',
+      kind: 'function',
+      nodeType: 'function_declaration',
+      language: 'go',
+      file: 'tests/fixtures/usage.go',
+      startLine: main!.startLine,
+      endLine: main!.endLine,
+      references: [
+        { name: 'greet', kind: 'function' },
+        { name: 'Greeter', kind: 'type' },
+        { name: 'Greet', kind: 'method', receiver: 'g', receiver_type: 'Greeter' },
+      ],
+      chunkIds: [getChunkDocumentId(main!)],
+    });
+  });
+
+  it('should merge the parts of a split symbol into one record', () => {
+    const part = (chunkIndex: number, startLine: number, endLine: number, references: CodeChunk['references']) =>
+      ({
+        type: 'code',
+        language: 'go',
+        kind: 'function_declaration',
+        symbol_fqn: 'main.run',
+        symbol_kind: 'function',
+        symbol_id: 'symbol-run',
+        filePath: 'run.go',
+        startLine,
+        endLine,
+        chunkIndex,
+        totalChunks: 2,
+        content: `part ${chunkIndex}`,
+        references,
+      }) as CodeChunk;
+
+    const symbols = collectSymbols(
+      [
+        part(0, 10, 40, [{ name: 'open', kind: 'function' }]),
+        part(1, 41, 70, [
+          { name: 'open', kind: 'function' },
+          { name: 'close', kind: 'function' },
+        ]),
+        // A Markdown section defines no symbol.
+        { type: 'doc', language: 'markdown', filePath: 'README.md', startLine: 1, endLine: 3 } as CodeChunk,
+      ],
+      'demo'
+    );
+
+    expect(symbols).toHaveLength(1);
+    expect(symbols[0]).toMatchObject({
+      id: 'symbol-run',
+      startLine: 10,
+      endLine: 70,
+      references: [
+        { name: 'open', kind: 'function' },
+        { name: 'close', kind: 'function' },
+      ],
+    });
+    expect(symbols[0].chunkIds).toHaveLength(2);
+  });
+});
+
+describe('SymbolExportWriter', () => {
+  let tempDir: string;
+
+  beforeEach(() => {
+    tempDir = fs.mkdtempSync(path.join(os.tmpdir(), 'symbol-export-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(tempDir, { recursive: true, force: true });
+  });
+
+  it('should write one JSON line per symbol', async () => {
+    const outPath = path.join(tempDir, 'symbols.jsonl');
+    const symbol = (name: string): SymbolRecord => ({
+      id: name,
+      repo: 'demo',
+      name,
+      language: 'go',
+      file: 'main.go',
+      startLine: 1,
+      endLine: 2,
+      references: [],
+      chunkIds: [name],
+    });
+    const writer = new SymbolExportWriter(outPath);
+
+    await writer.write([symbol('main.a'), symbol('main.b')]);
+    await writer.write([]);
+    await writer.write([symbol('main.c')]);
+    await writer.close();
+
+    expect(writer.symbols).toBe(3);
+    const lines = fs.readFileSync(outPath, 'utf8').trimEnd().split('\n');
+    expect(lines.map((line) => JSON.parse(line).name)).toEqual(['main.a', 'main.b', 'main.c']);
+  });
+});
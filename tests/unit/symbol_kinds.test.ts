@@ -0,0 +1,31 @@
+import { isSymbolKindIncluded, parseSymbolKinds } from '../../src/utils/symbol_kinds';
+import { describe, it, expect } from 'vitest';
+
+describe('parseSymbolKinds', () => {
+  it('should normalize kinds and their aliases', () => {
+    expect(parseSymbolKinds('--include-kinds', 'func, Method,,type,class,struct,const')).toEqual([
+      'function',
+      'method',
+      'type',
+      'struct',
+      'variable',
+    ]);
+  });
+
+  it('should reject unknown kinds and empty lists', () => {
+    expect(() => parseSymbolKinds('--exclude-kinds', 'getter')).toThrow(
+      'unknown kind "getter". Expected one of: function, method, type, struct, interface, enum, variable.'
+    );
+    expect(() => parseSymbolKinds('--include-kinds', ' , ')).toThrow('Invalid --include-kinds value: empty list.');
+  });
+});
+
+describe('isSymbolKindIncluded', () => {
+  it('should keep only included kinds, or all but excluded kinds', () => {
+    expect(isSymbolKindIncluded('function', { include: ['function'] })).toBe(true);
+    expect(isSymbolKindIncluded('method', { include: ['function'] })).toBe(false);
+    expect(isSymbolKindIncluded('method', { exclude: ['method'] })).toBe(false);
+    expect(isSymbolKindIncluded('type', { exclude: ['method'] })).toBe(true);
+    expect(isSymbolKindIncluded('type', {})).toBe(true);
+  });
+});
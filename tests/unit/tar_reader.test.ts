@@ -0,0 +1,250 @@
+import { execFileSync } from 'child_process';
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import zlib from 'zlib';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import {
+  findArchiveRoot,
+  getArchiveBaseName,
+  isArchivePath,
+  normalizeArchivePath,
+  readGitArchiveEntries,
+  readTarEntries,
+  ReadTarOptions,
+  TarEntry,
+} from '../../src/utils/tar_reader';
+
+/** Builds a ustar header block with a valid checksum. */
+function header(name: string, options: { type?: string; size?: number; linkName?: string; prefix?: string } = {}) {
+  const block = Buffer.alloc(512);
+  block.write(name, 0, 100, 'utf8');
+  block.write('0000644\0', 100);
+  block.write('0000000\0', 108);
+  block.write('0000000\0', 116);
+  block.write(`${(options.size ?? 0).toString(8).padStart(11, '0')}\0`, 124);
+  block.write('00000000000\0', 136);
+  block.write(' '.repeat(8), 148);
+  block.write(options.type ?? '0', 156);
+  block.write(options.linkName ?? '', 157, 100, 'utf8');
+  block.write('ustar\0', 257, 'latin1');
+  block.write('00', 263);
+  block.write(options.prefix ?? '', 345, 155, 'utf8');
+  const checksum = block.reduce((sum, byte) => sum + byte, 0);
+  block.write(`${checksum.toString(8).padStart(6, '0')}\0 `, 148, 'latin1');
+  return block;
+}
+
+function entry(name: string, content = '', options: { type?: string; linkName?: string; prefix?: string } = {}) {
+  const data = Buffer.from(content);
+  const padding = Buffer.alloc((512 - (data.length % 512)) % 512);
+  return Buffer.concat([header(name, { ...options, size: data.length }), data, padding]);
+}
+
+function paxRecord(key: string, value: string): string {
+  const body = ` ${key}=${value}\n`;
+  let length = body.length + 1;
+  while (`${length}${body}`.length !== length) {
+    length++;
+  }
+  return `${length}${body}`;
+}
+
+function archive(...entries: Buffer[]): Buffer {
+  return Buffer.concat([...entries, Buffer.alloc(1024)]);
+}
+
+describe('readTarEntries', () => {
+  let tmpDir: string;
+
+  beforeEach(() => {
+    tmpDir = fs.mkdtempSync(path.join(os.tmpdir(), 'tar-reader-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(tmpDir, { recursive: true, force: true });
+  });
+
+  async function read(data: Buffer, options: ReadTarOptions = { readContent: () => true }, name = 'repo.tar') {
+    const archivePath = path.join(tmpDir, name);
+    fs.writeFileSync(archivePath, data);
+    const entries: TarEntry[] = [];
+    for await (const tarEntry of readTarEntries(archivePath, options)) {
+      entries.push(tarEntry);
+    }
+    return entries;
+  }
+
+  it('should read regular files and report links and directories without content', async () => {
+    const entries = await read(
+      archive(
+        entry('repo/', '', { type: '5' }),
+        entry('repo/src/a.ts', 'export const a = 1;\n'),
+        entry('repo/secret.ts', '', { type: '2', linkName: '/etc/passwd' }),
+        entry('repo/copy.ts', '', { type: '1', linkName: 'repo/src/a.ts' }),
+        entry('repo/fifo', '', { type: '6' })
+      )
+    );
+
+    expect(entries.map(({ name, type, content }) => ({ name, type, content: content?.toString() }))).toEqual([
+      { name: 'repo/', type: 'directory', content: undefined },
+      { name: 'repo/src/a.ts', type: 'file', content: 'export const a = 1;\n' },
+      { name: 'repo/secret.ts', type: 'symlink', content: undefined },
+      { name: 'repo/copy.ts', type: 'hardlink', content: undefined },
+      { name: 'repo/fifo', type: 'other', content: undefined },
+    ]);
+  });
+
+  it('should skip the content of files that are not requested', async () => {
+    const large = 'x'.repeat(3000);
+    const entries = await read(archive(entry('large.ts', large), entry('small.ts', 'small')), {
+      readContent: (tarEntry) => tarEntry.size < 1000,
+    });
+
+    expect(entries.map(({ name, size, content }) => ({ name, size, content: content?.toString() }))).toEqual([
+      { name: 'large.ts', size: 3000, content: undefined },
+      { name: 'small.ts', size: 5, content: 'small' },
+    ]);
+  });
+
+  it('should decompress gzip archives, whatever their suffix', async () => {
+    const entries = await read(zlib.gzipSync(archive(entry('a.ts', 'a'))), undefined, 'repo.tar');
+
+    expect(entries.map((tarEntry) => tarEntry.name)).toEqual(['a.ts']);
+  });
+
+  it('should apply long names from ustar prefixes, GNU and PAX headers', async () => {
+    const longName = `${'deep/'.repeat(30)}file.ts`;
+    const entries = await read(
+      archive(
+        entry('file.ts', 'prefixed', { prefix: 'some/dir' }),
+        entry('././@LongLink', `${longName}\0`, { type: 'L' }),
+        entry('truncated', 'gnu'),
+        entry('PaxHeader/x', paxRecord('path', `pax/${longName}`), { type: 'x' }),
+        entry('truncated', 'pax')
+      )
+    );
+
+    expect(entries.map(({ name, content }) => [name, content?.toString()])).toEqual([
+      ['some/dir/file.ts', 'prefixed'],
+      [longName, 'gnu'],
+      [`pax/${longName}`, 'pax'],
+    ]);
+  });
+
+  it('should reject files that are not tar archives', async () => {
+    await expect(read(Buffer.from('not an archive'.repeat(100)))).rejects.toThrow('is not a valid tar archive');
+  });
+
+  it('should reject archives that end in the middle of an entry', async () => {
+    const truncated = entry('a.ts', 'x'.repeat(2000)).subarray(0, 1024);
+
+    await expect(read(truncated)).rejects.toThrow('Unexpected end of archive');
+  });
+});
+
+describe('findArchiveRoot', () => {
+  let tmpDir: string;
+
+  beforeEach(() => {
+    tmpDir = fs.mkdtempSync(path.join(os.tmpdir(), 'tar-root-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(tmpDir, { recursive: true, force: true });
+  });
+
+  function findRoot(data: Buffer) {
+    const archivePath = path.join(tmpDir, 'repo.tar.gz');
+    fs.writeFileSync(archivePath, zlib.gzipSync(data));
+    return findArchiveRoot(archivePath);
+  }
+
+  it('should return the directory every entry is under', async () => {
+    const root = await findRoot(
+      archive(
+        entry('PaxHeader', paxRecord('comment', 'abc123'), { type: 'g' }),
+        entry('kibana-abc123/', '', { type: '5' }),
+        entry('kibana-abc123/src/a.ts', 'a'),
+        entry('./kibana-abc123/README.md', 'readme')
+      )
+    );
+
+    expect(root).toBe('kibana-abc123');
+  });
+
+  it('should return undefined when entries are at the root or under several directories', async () => {
+    expect(await findRoot(archive(entry('src/a.ts', 'a'), entry('package.json', '{}')))).toBeUndefined();
+    expect(await findRoot(archive(entry('src/a.ts', 'a'), entry('test/a.test.ts', 'a')))).toBeUndefined();
+  });
+});
+
+describe('readGitArchiveEntries', () => {
+  let repoDir: string;
+
+  beforeEach(() => {
+    repoDir = fs.mkdtempSync(path.join(os.tmpdir(), 'git-archive-'));
+    const git = (...args: string[]) => execFileSync('git', args, { cwd: repoDir, stdio: 'ignore' });
+    git('init', '-q');
+    fs.mkdirSync(path.join(repoDir, 'src'));
+    fs.writeFileSync(path.join(repoDir, 'src', 'a.ts'), 'export const a = 1;\n');
+    fs.symlinkSync('src/a.ts', path.join(repoDir, 'link.ts'));
+    git('add', '-A');
+    git('-c', 'user.name=test', '-c', 'user.email=test@example.com', 'commit', '-q', '-m', 'initial');
+    // Changes to the working tree are not part of the ref.
+    fs.writeFileSync(path.join(repoDir, 'src', 'a.ts'), 'export const a = 2;\n');
+  });
+
+  afterEach(() => {
+    fs.rmSync(repoDir, { recursive: true, force: true });
+  });
+
+  it('should read the entries of the ref relative to the repository root', async () => {
+    const entries: TarEntry[] = [];
+    for await (const tarEntry of readGitArchiveEntries(repoDir, 'HEAD', { readContent: () => true })) {
+      entries.push(tarEntry);
+    }
+
+    expect(entries.map(({ name, type, content }) => ({ name, type, content: content?.toString() }))).toEqual([
+      { name: 'link.ts', type: 'symlink', content: undefined },
+      { name: 'src/', type: 'directory', content: undefined },
+      { name: 'src/a.ts', type: 'file', content: 'export const a = 1;\n' },
+    ]);
+  });
+
+  it('should throw when git cannot archive the ref', async () => {
+    const read = async () => {
+      for await (const tarEntry of readGitArchiveEntries(repoDir, 'does-not-exist')) {
+        expect(tarEntry).toBeUndefined();
+      }
+    };
+
+    await expect(read()).rejects.toThrow('git archive does-not-exist failed');
+  });
+});
+
+describe('archive paths', () => {
+  it('should recognize tar archives by their suffix', () => {
+    expect(isArchivePath('/ci/kibana.tar')).toBe(true);
+    expect(isArchivePath('/ci/kibana.TAR.GZ')).toBe(true);
+    expect(isArchivePath('kibana.tgz')).toBe(true);
+    expect(isArchivePath('/ci/kibana.zip')).toBe(false);
+    expect(isArchivePath('/repos/kibana')).toBe(false);
+  });
+
+  it('should name an archive after its file name without the suffix', () => {
+    expect(getArchiveBaseName('/ci/kibana.tar.gz')).toBe('kibana');
+    expect(getArchiveBaseName('/ci/kibana-main.tgz')).toBe('kibana-main');
+  });
+
+  it('should normalize entry names and reject the ones pointing outside the archive', () => {
+    expect(normalizeArchivePath('./src/a.ts')).toBe('src/a.ts');
+    expect(normalizeArchivePath('repo//src/./a.ts')).toBe('repo/src/a.ts');
+    expect(normalizeArchivePath('repo/src/')).toBe('repo/src');
+    expect(normalizeArchivePath('/etc/passwd')).toBeUndefined();
+    expect(normalizeArchivePath('../outside.ts')).toBeUndefined();
+    expect(normalizeArchivePath('repo/../../outside.ts')).toBeUndefined();
+    expect(normalizeArchivePath('C:\\Windows\\win.ini')).toBeUndefined();
+  });
+});
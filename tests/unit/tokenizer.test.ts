@@ -0,0 +1,48 @@
+import { describe, it, expect } from 'vitest';
+
+import { getDefaultTokenizerName, getTokenizer, LargestChunks } from '../../src/utils/tokenizer';
+
+describe('getTokenizer', () => {
+  const source = "function getUserById(id: number) {\n    return users[id] ?? null; // don't\n}";
+
+  it('should count the pieces of a BPE pre-tokenizer, splitting long ones', () => {
+    const bpe = getTokenizer('bpe');
+
+    // ` getUserById` is a single twelve-character piece, counted as three tokens.
+    expect(bpe.countTokens(' getUserById')).toBe(3);
+    expect(bpe.countTokens('12345')).toBe(2);
+    expect(bpe.countTokens(source)).toBe(27);
+  });
+
+  it('should count whitespace-separated words and estimate characters', () => {
+    expect(getTokenizer('whitespace').countTokens(source)).toBe(11);
+    expect(getTokenizer('whitespace').countTokens('  \n')).toBe(0);
+    expect(getTokenizer('chars').countTokens('abcdefg')).toBe(3);
+  });
+
+  it('should reject unknown tokenizers', () => {
+    expect(() => getTokenizer('wordpiece')).toThrow(
+      'Invalid --tokenizer value: wordpiece. Expected one of: bpe, whitespace, chars.'
+    );
+  });
+
+  it('should use BPE for OpenAI and whitespace for the other providers', () => {
+    expect(getDefaultTokenizerName('openai')).toBe('bpe');
+    expect(getDefaultTokenizerName('cohere')).toBe('whitespace');
+    expect(getDefaultTokenizerName()).toBe('whitespace');
+  });
+});
+
+describe('LargestChunks', () => {
+  it('should keep the chunks with the most tokens, largest first', () => {
+    const largest = new LargestChunks(2);
+    largest.add({ filePath: 'a.ts', startLine: 1, endLine: 5, token_count: 40 });
+    largest.add({ filePath: 'b.ts', startLine: 1, endLine: 90, token_count: 900 });
+    largest.add({ filePath: 'c.ts', startLine: 3, endLine: 4, token_count: 10 });
+    largest.add({ filePath: 'd.ts', startLine: 7, endLine: 30, token_count: 120 });
+    largest.add({ filePath: 'e.ts', startLine: 1, endLine: 2 });
+
+    expect(largest.size).toBe(2);
+    expect(largest.format(512)).toBe('b.ts:1-90 (900 tokens, over --max-chunk-tokens), d.ts:7-30 (120 tokens)');
+  });
+});
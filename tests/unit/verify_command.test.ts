@@ -0,0 +1,97 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { execFileSync } from 'child_process';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { compareManifestWithIndex, hasDiscrepancies } from '../../src/commands/verify_command';
+import { createManifest, hashContent, Manifest } from '../../src/utils/manifest';
+import type { IndexedFileSummary } from '../../src/utils/elasticsearch';
+
+describe('compareManifestWithIndex', () => {
+  let rootDir: string;
+  let manifest: Manifest;
+
+  const writeFile = (filePath: string, content: string, chunkCount: number, ids?: [number, number]): string => {
+    const absolutePath = path.join(rootDir, filePath);
+    fs.writeFileSync(absolutePath, content);
+    manifest.files[filePath] = {
+      sha256: hashContent(content),
+      chunkCount,
+      enqueuedAt: '2024-01-01T00:00:00.000Z',
+      ...(ids ? { firstDocumentId: ids[0], lastDocumentId: ids[1] } : {}),
+    };
+    return execFileSync('git', ['hash-object', absolutePath]).toString().trim();
+  };
+
+  beforeEach(() => {
+    rootDir = fs.mkdtempSync(path.join(os.tmpdir(), 'verify-'));
+    manifest = createManifest('repo', 'main');
+  });
+
+  afterEach(() => {
+    fs.rmSync(rootDir, { recursive: true, force: true });
+  });
+
+  it('should report nothing when every file has its documents', () => {
+    const hash = writeFile('a.ts', 'export const a = 1;', 2);
+    writeFile('empty.ts', '', 0);
+
+    const result = compareManifestWithIndex(
+      manifest,
+      new Map<string, IndexedFileSummary>([['a.ts', { documentCount: 2, gitFileHashes: [hash] }]]),
+      { rootDir }
+    );
+
+    expect(result).toEqual({
+      checkedFiles: 2,
+      missing: [],
+      orphaned: [],
+      hashMismatches: [],
+      queued: [],
+      changed: [],
+    });
+    expect(hasDiscrepancies(result)).toBe(false);
+  });
+
+  it('should report missing documents, orphaned files and locations indexed from other content', () => {
+    const hashA = writeFile('a.ts', 'export const a = 1;', 3);
+    writeFile('b.ts', 'export const b = 1;', 1);
+    const hashC = writeFile('c.ts', 'export const c = 1;', 1);
+
+    const result = compareManifestWithIndex(
+      manifest,
+      new Map<string, IndexedFileSummary>([
+        ['a.ts', { documentCount: 2, gitFileHashes: [hashA] }],
+        ['c.ts', { documentCount: 2, gitFileHashes: [hashC, 'stale-hash'] }],
+        ['deleted.ts', { documentCount: 1, gitFileHashes: ['old'] }],
+      ]),
+      { rootDir }
+    );
+
+    expect(result.missing).toEqual([
+      { filePath: 'a.ts', expected: 3, found: 2 },
+      { filePath: 'b.ts', expected: 1, found: 0 },
+    ]);
+    expect(result.orphaned).toEqual(['deleted.ts']);
+    expect(result.hashMismatches).toEqual(['c.ts']);
+    expect(hasDiscrepancies(result)).toBe(true);
+  });
+
+  it('should skip files still in the queue and not compare the hashes of files changed on disk', () => {
+    writeFile('queued.ts', 'export const q = 1;', 2, [5, 6]);
+    writeFile('edited.ts', 'export const e = 1;', 1);
+    fs.writeFileSync(path.join(rootDir, 'edited.ts'), 'export const e = 2;');
+
+    const result = compareManifestWithIndex(
+      manifest,
+      new Map<string, IndexedFileSummary>([['edited.ts', { documentCount: 1, gitFileHashes: ['old'] }]]),
+      { rootDir, isQueued: (entry) => entry.firstDocumentId === 5 }
+    );
+
+    expect(result.queued).toEqual(['queued.ts']);
+    expect(result.changed).toEqual(['edited.ts']);
+    expect(result.checkedFiles).toBe(1);
+    expect(hasDiscrepancies(result)).toBe(false);
+  });
+});
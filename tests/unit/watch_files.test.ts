@@ -0,0 +1,64 @@
+import fs from 'fs';
+import os from 'os';
+import path from 'path';
+import { afterEach, beforeEach, describe, it, expect } from 'vitest';
+
+import { classifyChangedPaths } from '../../src/commands/watch_files';
+
+describe('classifyChangedPaths', () => {
+  let gitRoot: string;
+
+  const writeFile = (relativePath: string, content = '') => {
+    const absolutePath = path.join(gitRoot, relativePath);
+    fs.mkdirSync(path.dirname(absolutePath), { recursive: true });
+    fs.writeFileSync(absolutePath, content);
+  };
+
+  const classify = (changedPaths: string[], indexedFiles: string[]) =>
+    classifyChangedPaths(changedPaths, {
+      gitRoot,
+      indexedFiles: new Set(indexedFiles),
+      isSupported: (file) => file.endsWith('.ts'),
+      isExcluded: (file) => file.startsWith('dist'),
+      walkDirectory: (relativeDir) => (relativeDir === 'lib' ? ['lib/a.ts', 'lib/b.ts'] : []),
+    });
+
+  beforeEach(() => {
+    gitRoot = fs.mkdtempSync(path.join(os.tmpdir(), 'watch-files-'));
+  });
+
+  afterEach(() => {
+    fs.rmSync(gitRoot, { recursive: true, force: true });
+  });
+
+  it('should re-index saved files and prune the ones indexed before', () => {
+    writeFile('src/new.ts');
+    writeFile('src/edited.ts');
+    writeFile('README.txt');
+    writeFile('dist/out.ts');
+
+    const changes = classify(['src/new.ts', 'src/edited.ts', 'README.txt', 'dist/out.ts'], ['src/edited.ts']);
+
+    expect(changes).toEqual({
+      filesToIndex: ['src/new.ts', 'src/edited.ts'],
+      filesToDelete: [],
+      filesToPrune: ['src/edited.ts'],
+    });
+  });
+
+  it('should delete removed files and the indexed files of removed directories', () => {
+    const changes = classify(['src/gone.ts', 'old', 'notes.txt'], ['old/a.ts', 'old/sub/b.ts', 'older/c.ts']);
+
+    expect(changes.filesToIndex).toEqual([]);
+    expect(changes.filesToDelete.sort()).toEqual(['old/a.ts', 'old/sub/b.ts', 'src/gone.ts']);
+  });
+
+  it('should walk directories that appeared', () => {
+    writeFile('lib/a.ts');
+    writeFile('lib/b.ts');
+
+    const changes = classify(['lib'], ['lib/a.ts']);
+
+    expect(changes).toEqual({ filesToIndex: ['lib/a.ts', 'lib/b.ts'], filesToDelete: [], filesToPrune: ['lib/a.ts'] });
+  });
+});